@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+type ActivityType string
+
+const (
+	ActivityCash     ActivityType = "cash"
+	ActivityLogin    ActivityType = "login"
+	ActivitySecurity ActivityType = "security"
+	ActivitySettings ActivityType = "settings"
+	ActivityTransfer ActivityType = "transfer"
+)
+
+// accountEventActions are the audit events behind the security and
+// settings entries of the feed. The rest of an account's audit log, such
+// as fraud signals and back-office decisions, isn't shown to the holder.
+var accountEventActions = map[ActivityType][]string{
+	ActivitySecurity: {"device.registered", "device.revoked", "delegate.granted", "delegate.revoked"},
+	ActivitySettings: {"preferences.paperless_updated", "freeze.created", "freeze.deleted"},
+}
+
+// AccountEvent is an audit event as shown in the holder's activity feed.
+type AccountEvent struct {
+	Action  string            `json:"action"`
+	Actor   string            `json:"actor"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Activity is one entry of an account's activity feed. Data holds the
+// underlying record, e.g. a *Transfer for ActivityTransfer, a
+// *CashOperation for ActivityCash, a *LoginAttempt for ActivityLogin or an
+// AccountEvent for ActivitySecurity and ActivitySettings.
+type Activity struct {
+	Type       ActivityType `json:"type"`
+	ID         int          `json:"-"`
+	OccurredAt time.Time    `json:"occurredAt"`
+	Data       any          `json:"data"`
+}
+
+type ActivityPage struct {
+	Items      []Activity `json:"items"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// activityCursor points at the last item of a page. The feed is ordered by
+// OccurredAt descending, then by Type, then by ID descending.
+type activityCursor struct {
+	OccurredAt time.Time
+	Type       ActivityType
+	ID         int
+}
+
+func (c activityCursor) String() string {
+	raw := fmt.Sprintf("%d:%s:%d", c.OccurredAt.UnixNano(), c.Type, c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseActivityCursor(s string) (*activityCursor, error) {
+	invalid := ApiError{Err: "invalid cursor", Status: http.StatusBadRequest}
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, invalid
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 {
+		return nil, invalid
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, invalid
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, invalid
+	}
+
+	return &activityCursor{
+		OccurredAt: time.Unix(0, nanos).UTC(),
+		Type:       ActivityType(parts[1]),
+		ID:         id,
+	}, nil
+}
+
+// pageFor returns the query a source of type t has to run to continue after
+// the cursor. Items at the cursor's timestamp are already shown when their
+// type sorts before the cursor's, and never shown when it sorts after.
+func (c *activityCursor) pageFor(t ActivityType, q PageQuery) PageQuery {
+	if c == nil {
+		return q
+	}
+
+	q.Before = c.OccurredAt
+	switch {
+	case t < c.Type:
+		q.BeforeID = 0
+	case t == c.Type:
+		q.BeforeID = c.ID
+	default:
+		q.BeforeID = math.MaxInt32
+	}
+	return q
+}
+
+func getPageLimit(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultPageLimit, nil
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 {
+		return 0, ApiError{Err: "invalid limit: " + v, Status: http.StatusBadRequest}
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return limit, nil
+}
+
+// HandleGetAccountActivity returns the account's transfers, cash
+// operations, logins and changes to its security and settings as one
+// chronological feed, newest first. It pages with ?cursor=&limit= and can
+// be narrowed with ?from=&to=&tz=. Delegates only get the transfers and
+// cash operations.
+func (s *APIServer) HandleGetAccountActivity(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	period, err := parsePeriod(r)
+	if err != nil {
+		return err
+	}
+	limit, err := getPageLimit(r)
+	if err != nil {
+		return err
+	}
+
+	var cursor *activityCursor
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		if cursor, err = parseActivityCursor(v); err != nil {
+			return err
+		}
+	}
+
+	// Every source is asked for a full page; the merged result is cut
+	// back down to limit.
+	base := PageQuery{After: period.From, Before: period.To, BeforeID: math.MaxInt32, Limit: limit}
+	items := []Activity{}
+	store := s.storageFor(r)
+
+	transfers, err := store.GetTransfers(TransferFilter{AccountID: &id}, cursor.pageFor(ActivityTransfer, base))
+	if err != nil {
+		return err
+	}
+	for _, t := range transfers {
+		items = append(items, Activity{Type: ActivityTransfer, ID: t.ID, OccurredAt: t.CreatedAt, Data: t})
+	}
+
+	cash, err := store.GetCashOperations(id, cursor.pageFor(ActivityCash, base))
+	if err != nil {
+		return err
+	}
+	for _, c := range cash {
+		items = append(items, Activity{Type: ActivityCash, ID: c.ID, OccurredAt: c.CreatedAt, Data: c})
+	}
+
+	// Logins are owner-only, as on /account/{id}/logins: they carry the
+	// owner's IP addresses and user agents. So are the security and
+	// settings changes.
+	if p := principalFromContext(r); p == nil || p.DelegatorID == 0 {
+		logins, err := store.GetLoginAttempts(LoginAttemptFilter{AccountID: &id}, cursor.pageFor(ActivityLogin, base))
+		if err != nil {
+			return err
+		}
+		for _, l := range logins {
+			items = append(items, Activity{Type: ActivityLogin, ID: l.ID, OccurredAt: l.CreatedAt, Data: l})
+		}
+
+		for _, kind := range []ActivityType{ActivitySecurity, ActivitySettings} {
+			events, err := store.GetAccountAuditEvents(id, accountEventActions[kind], cursor.pageFor(kind, base))
+			if err != nil {
+				return err
+			}
+			for _, e := range events {
+				data := AccountEvent{Action: e.Action, Actor: e.Actor, Details: e.Details}
+				items = append(items, Activity{Type: kind, ID: e.ID, OccurredAt: e.CreatedAt, Data: data})
+			}
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if !a.OccurredAt.Equal(b.OccurredAt) {
+			return a.OccurredAt.After(b.OccurredAt)
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.ID > b.ID
+	})
+
+	page := ActivityPage{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+	}
+	if len(page.Items) == limit {
+		last := page.Items[limit-1]
+		page.NextCursor = activityCursor{OccurredAt: last.OccurredAt, Type: last.Type, ID: last.ID}.String()
+	}
+
+	return writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type activityTest struct {
+	t       *testing.T
+	store   *MemoryStorage
+	router  http.Handler
+	account *Account
+	token   string
+}
+
+func newActivityTest(t *testing.T) *activityTest {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	account := createTestAccount(t, store, 1000)
+	token, err := createJWT(account)
+	assert.Nil(t, err)
+	return &activityTest{t: t, store: store, router: NewAPIServer(":0", store).Router(), account: account, token: token}
+}
+
+func (a *activityTest) do(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("x-jwt-token", a.token)
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (a *activityTest) page(query string) ActivityPage {
+	rec := a.do(http.MethodGet, "/account/"+a.account.PublicID+"/activity"+query, "")
+	assert.Equal(a.t, http.StatusOK, rec.Code)
+	var page ActivityPage
+	assert.Nil(a.t, json.Unmarshal(rec.Body.Bytes(), &page))
+	return page
+}
+
+func (a *activityTest) login(at time.Time) {
+	id := a.account.ID
+	assert.Nil(a.t, a.store.CreateLoginAttempt(&LoginAttempt{AccountID: &id, Number: a.account.Number, Success: true, CreatedAt: at}))
+}
+
+func (a *activityTest) event(action string, at time.Time) {
+	e := NewAuditEvent("account:"+a.account.PublicID, action, a.account.ID, nil)
+	e.CreatedAt = at
+	assert.Nil(a.t, a.store.CreateAuditEvent(e))
+}
+
+type activityKey struct {
+	Type       ActivityType
+	OccurredAt time.Time
+	Action     string
+}
+
+func activityKeys(items []Activity) []activityKey {
+	keys := []activityKey{}
+	for _, item := range items {
+		key := activityKey{Type: item.Type, OccurredAt: item.OccurredAt.UTC()}
+		if data, ok := item.Data.(map[string]any); ok {
+			key.Action, _ = data["action"].(string)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestActivityMergesSourcesInOrder(t *testing.T) {
+	a := newActivityTest(t)
+	base := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	a.login(base)
+	a.event("device.registered", base.Add(time.Minute))
+	a.event("freeze.created", base.Add(2*time.Minute))
+	// Same timestamp: by type, then newest first within a type.
+	a.event("delegate.granted", base.Add(3*time.Minute))
+	a.event("device.revoked", base.Add(3*time.Minute))
+	a.login(base.Add(3 * time.Minute))
+	// Not shown to the holder.
+	a.event("fraud.flagged", base.Add(4*time.Minute))
+
+	assert.Equal(t, []activityKey{
+		{ActivityLogin, base.Add(3 * time.Minute), ""},
+		{ActivitySecurity, base.Add(3 * time.Minute), "device.revoked"},
+		{ActivitySecurity, base.Add(3 * time.Minute), "delegate.granted"},
+		{ActivitySettings, base.Add(2 * time.Minute), "freeze.created"},
+		{ActivitySecurity, base.Add(time.Minute), "device.registered"},
+		{ActivityLogin, base, ""},
+	}, activityKeys(a.page("").Items))
+}
+
+func TestActivityCursorPagesAcrossSources(t *testing.T) {
+	a := newActivityTest(t)
+	base := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		at := base.Add(time.Duration(i) * time.Minute)
+		a.login(at)
+		a.event("device.registered", at)
+		a.event("preferences.paperless_updated", at)
+	}
+
+	all := activityKeys(a.page("").Items)
+	assert.Len(t, all, 9)
+
+	var walked []activityKey
+	query := "?limit=2"
+	for pages := 0; pages < 10; pages++ {
+		page := a.page(query)
+		assert.LessOrEqual(t, len(page.Items), 2)
+		walked = append(walked, activityKeys(page.Items)...)
+		if page.NextCursor == "" {
+			break
+		}
+		query = "?limit=2&cursor=" + page.NextCursor
+	}
+	assert.Equal(t, all, walked)
+}
+
+func TestActivityShowsSettingsChanges(t *testing.T) {
+	a := newActivityTest(t)
+
+	rec := a.do(http.MethodPut, "/account/"+a.account.PublicID+"/documents/preferences", `{"statements":true,"notices":true}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	items := a.page("").Items
+	assert.Len(t, items, 1)
+	assert.Equal(t, ActivitySettings, items[0].Type)
+	data := items[0].Data.(map[string]any)
+	assert.Equal(t, "preferences.paperless_updated", data["action"])
+	assert.Equal(t, "account:"+a.account.PublicID, data["actor"])
+	assert.NotContains(t, data, "accountId")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"math"
+	"net/http"
+	"time"
+)
+
+//go:embed admin_ui
+var adminUIFiles embed.FS
+
+type BalanceTotals struct {
+	Currency         string `json:"currency"`
+	Accounts         int    `json:"accounts"`
+	Total            Money  `json:"total"`
+	NegativeBalances int    `json:"negativeBalances"`
+}
+
+type TransferTotals struct {
+	Status TransferStatus `json:"status"`
+	Count  int            `json:"count"`
+	Total  Money          `json:"total"`
+}
+
+// ReconciliationReport sums up the ledger for operators: what customers
+// hold per currency, where transfers stand and which ones look stuck.
+type ReconciliationReport struct {
+	GeneratedAt    time.Time         `json:"generatedAt"`
+	Balances       []BalanceTotals   `json:"balances"`
+	Transfers      []TransferTotals  `json:"transfers"`
+	StuckTransfers []TransferWithIDs `json:"stuckTransfers"`
+}
+
+func (s *APIServer) HandleAdminSearchAccounts(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	limit, err := getPageLimit(r)
+	if err != nil {
+		return err
+	}
+
+	accounts, err := s.storage.SearchAccounts(r.URL.Query().Get("q"), limit)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, adminAccounts(accounts))
+}
+
+// HandleAdminGetTransfers lists transfers across accounts, optionally
+// filtered by ?status= and ?account=.
+func (s *APIServer) HandleAdminGetTransfers(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	q := r.URL.Query()
+	filter := TransferFilter{Status: TransferStatus(q.Get("status"))}
+	if v := q.Get("account"); v != "" {
+		id, err := getIntParam(v, "account")
+		if err != nil {
+			return err
+		}
+		filter.AccountID = &id
+	}
+
+	period, err := parsePeriod(r)
+	if err != nil {
+		return err
+	}
+	limit, err := getPageLimit(r)
+	if err != nil {
+		return err
+	}
+
+	var cursor *activityCursor
+	if v := q.Get("cursor"); v != "" {
+		if cursor, err = parseActivityCursor(v); err != nil {
+			return err
+		}
+	}
+
+	page := PageQuery{After: period.From, Before: period.To, BeforeID: math.MaxInt32, Limit: limit}
+	transfers, err := s.storageFor(r).GetTransfers(filter, cursor.pageFor(ActivityTransfer, page))
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int, len(transfers))
+	for i, t := range transfers {
+		ids[i] = t.ID
+	}
+	origins, err := s.storage.GetTransferOrigins(ids)
+	if err != nil {
+		return err
+	}
+
+	resp := ActivityPage{Items: []Activity{}}
+	for _, t := range transfers {
+		data := AdminTransfer{TransferWithIDs: withInternalIDs(t), Origin: origins[t.ID]}
+		resp.Items = append(resp.Items, Activity{Type: ActivityTransfer, ID: t.ID, OccurredAt: t.CreatedAt, Data: data})
+	}
+	if len(transfers) == limit {
+		last := transfers[limit-1]
+		resp.NextCursor = activityCursor{OccurredAt: last.CreatedAt, Type: ActivityTransfer, ID: last.ID}.String()
+	}
+
+	return writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *APIServer) HandleAdminReconciliation(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	report, err := s.storage.GetReconciliationReport(time.Now().UTC().Add(-transferProcessingLease))
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, report)
+}
+
+// HandleAdminUI serves the embedded single page admin UI, which talks to
+// the /admin API with the same credentials.
+func (s *APIServer) HandleAdminUI(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	files, err := fs.Sub(adminUIFiles, "admin_ui")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.StripPrefix("/admin/ui", http.FileServer(http.FS(files))).ServeHTTP(w, r)
+	return nil
+}
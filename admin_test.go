@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminUINeedsAdmin(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	customer := createTestAccount(t, store, 0)
+	customerToken, err := createJWT(customer)
+	assert.Nil(t, err)
+
+	get := func(prepare func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/admin/ui/", nil)
+		prepare(req)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(func(*http.Request) {})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Basic")
+
+	// A customer's token is no admin credential, wherever it is sent.
+	assert.Equal(t, http.StatusUnauthorized, get(func(r *http.Request) { r.Header.Set("x-jwt-token", customerToken) }).Code)
+	assert.Equal(t, http.StatusForbidden, get(func(r *http.Request) { r.Header.Set("x-admin-token", customerToken) }).Code)
+	assert.Equal(t, http.StatusForbidden, get(func(r *http.Request) { r.SetBasicAuth("ops", "wrong") }).Code)
+
+	rec = get(func(r *http.Request) { r.SetBasicAuth("ops", "test-admin") })
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	assert.Contains(t, rec.Body.String(), "<title>gobank admin</title>")
+
+	rec = get(func(r *http.Request) { r.Header.Set("x-admin-token", "test-admin") })
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAdminUIListings(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	sender := createTestAccount(t, store, 1000)
+	recipient := createTestAccount(t, store, 0)
+	token, err := createJWT(sender)
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/transfer", strings.NewReader(`{"toAccountId":"`+recipient.PublicID+`","amount":{"amount":100}}`))
+	req.Header.Set("x-jwt-token", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// The UI renders the listings from these fields.
+	get := func(path string, v any) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.SetBasicAuth("ops", "test-admin")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), v))
+	}
+
+	var accounts []map[string]any
+	get("/admin/accounts?q="+strings.ToLower(sender.PublicID), &accounts)
+	assert.Len(t, accounts, 1)
+	assert.Equal(t, float64(sender.ID), accounts[0]["id"])
+	assert.Equal(t, sender.PublicID, accounts[0]["publicId"])
+	for _, field := range []string{"firstName", "lastName", "number", "balance", "createdAt"} {
+		assert.Contains(t, accounts[0], field)
+	}
+
+	var page struct {
+		Items []struct {
+			Data map[string]any `json:"data"`
+		} `json:"items"`
+	}
+	get("/admin/transfers?account="+strconv.Itoa(sender.ID), &page)
+	assert.Len(t, page.Items, 1)
+	transfer := page.Items[0].Data
+	assert.Equal(t, float64(sender.ID), transfer["fromAccount"])
+	assert.Equal(t, float64(recipient.ID), transfer["toAccount"])
+	assert.Equal(t, "settled", transfer["status"])
+	for _, field := range []string{"id", "publicId", "amount", "createdAt"} {
+		assert.Contains(t, transfer, field)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+type AlertKind string
+
+const (
+	AlertBalanceBelow    AlertKind = "balance_below"
+	AlertDebitAbove      AlertKind = "debit_above"
+	AlertForeignCurrency AlertKind = "foreign_currency"
+)
+
+// AlertRule asks for a notification when a posting to the account matches
+// it. Threshold applies to balance_below and debit_above; Currency is the
+// home currency foreign_currency compares against.
+type AlertRule struct {
+	ID        int       `json:"id"`
+	AccountID int       `json:"-"`
+	Kind      AlertKind `json:"kind"`
+	Threshold *Money    `json:"threshold,omitempty"`
+	Currency  string    `json:"currency,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type AlertRuleRequest struct {
+	Kind      AlertKind `json:"kind"`
+	Threshold *Money    `json:"threshold"`
+	Currency  string    `json:"currency"`
+}
+
+// LedgerPosting is a single change to an account balance. Amount is
+// negative for debits; Balance is the balance after the posting.
+type LedgerPosting struct {
+	AccountID   int
+	Amount      Money
+	Balance     Money
+	Description string
+}
+
+func (p LedgerPosting) IsDebit() bool {
+	return p.Amount.IsNegative()
+}
+
+// Matches reports whether the posting triggers the rule. Balance alerts
+// fire when the balance crosses the threshold, not on every posting while
+// it stays below.
+func (a *AlertRule) Matches(p LedgerPosting) bool {
+	switch a.Kind {
+	case AlertBalanceBelow:
+		if p.Balance.Currency != a.Threshold.Currency {
+			return false
+		}
+		before := p.Balance.Amount - p.Amount.Amount
+		return p.Balance.Amount < a.Threshold.Amount && before >= a.Threshold.Amount
+	case AlertDebitAbove:
+		return p.IsDebit() && p.Amount.Currency == a.Threshold.Currency && -p.Amount.Amount > a.Threshold.Amount
+	case AlertForeignCurrency:
+		return p.Amount.Currency != a.Currency
+	}
+	return false
+}
+
+func (a *AlertRule) message(p LedgerPosting) string {
+	switch a.Kind {
+	case AlertBalanceBelow:
+		return fmt.Sprintf("Your balance dropped to %s, below %s.", p.Balance, a.Threshold)
+	case AlertDebitAbove:
+		return fmt.Sprintf("A debit of %s (%s) is above your %s alert.", p.Amount.Negate(), p.Description, a.Threshold)
+	default:
+		return fmt.Sprintf("A %s transaction of %s (%s) was posted to your account.", p.Amount.Currency, p.Amount, p.Description)
+	}
+}
+
+// applyRequest validates req and copies it onto the rule.
+func (a *AlertRule) applyRequest(req *AlertRuleRequest, account *Account) error {
+	switch req.Kind {
+	case AlertBalanceBelow, AlertDebitAbove:
+		if req.Threshold == nil || req.Threshold.IsNegative() {
+			return ApiError{Err: "threshold must not be negative", Status: http.StatusBadRequest}
+		}
+		threshold := *req.Threshold
+		if threshold.Currency == "" {
+			threshold.Currency = account.Balance.Currency
+		}
+		a.Threshold, a.Currency = &threshold, ""
+	case AlertForeignCurrency:
+		a.Threshold, a.Currency = nil, req.Currency
+		if a.Currency == "" {
+			a.Currency = account.Balance.Currency
+		}
+	default:
+		return ApiError{Err: "unknown alert kind: " + string(req.Kind), Status: http.StatusBadRequest}
+	}
+
+	a.Kind = req.Kind
+	return nil
+}
+
+func (s *APIServer) HandleAlertRules(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		rules, err := s.storage.GetAlertRules(id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, rules)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(AlertRuleRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	rule := &AlertRule{AccountID: id, CreatedAt: time.Now().UTC()}
+	if err := rule.applyRequest(req, accountFromContext(r)); err != nil {
+		return err
+	}
+	if err := s.storage.CreateAlertRule(rule); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, rule)
+}
+
+func (s *APIServer) HandleAlertRule(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	ruleID, err := getIntVar(r, "ruleID")
+	if err != nil {
+		return err
+	}
+
+	rule, err := s.storage.GetAlertRule(id, ruleID)
+	if err != nil {
+		return ApiError{Err: "alert rule not found", Status: http.StatusNotFound}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return writeJSON(w, http.StatusOK, rule)
+	case http.MethodPut:
+		req := new(AlertRuleRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return invalidRequest
+		}
+		defer r.Body.Close()
+
+		if err := rule.applyRequest(req, accountFromContext(r)); err != nil {
+			return err
+		}
+		if err := s.storage.UpdateAlertRule(rule); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, rule)
+	case http.MethodDelete:
+		if err := s.storage.DeleteAlertRule(id, ruleID); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, map[string]int{"deleted": ruleID})
+	}
+
+	return methodNotAllowed
+}
+
+// AlertingStorage evaluates alert rules on every posting made through the
+// Storage it wraps: transfers, cash operations, bill payments and cheques.
+// onCredit, when set, is told about every account money was credited to,
+// and metrics, when set, about every posting. The postings and the
+// transfers settled or failed are published on events, when set. Postings
+// wait while ledger, when set, is frozen.
+type AlertingStorage struct {
+	Storage
+	notifier Notifier
+	onCredit func(accountID int)
+	metrics  *LedgerMetrics
+	events   *EventBus
+	ledger   *LedgerFreeze
+}
+
+func NewAlertingStorage(store Storage, notifier Notifier) *AlertingStorage {
+	return &AlertingStorage{Storage: store, notifier: notifier}
+}
+
+func (s *AlertingStorage) WithContext(ctx context.Context) Storage {
+	c := *s
+	c.Storage = storageWithContext(s.Storage, ctx)
+	return &c
+}
+
+func (s *AlertingStorage) ExecuteTransfer(t *Transfer) error {
+	defer s.ledger.hold()()
+	wasPending := t.IsPending()
+	if err := s.Storage.ExecuteTransfer(t); err != nil {
+		return err
+	}
+	if !wasPending {
+		return nil
+	}
+
+	switch t.Status {
+	case TransferSettled:
+		s.metrics.settled(t)
+		if t.Credit != nil {
+			// Converted transfers move money between currencies.
+			s.metrics.moved(t.Amount.Negate())
+			s.metrics.moved(*t.Credit)
+		}
+		settled := *t
+		s.events.Publish(DomainEvent{Kind: EventTransferSettled, AccountID: t.FromAccount, Transfer: &settled, Amount: t.Amount})
+		desc := "transfer " + t.PublicID
+		s.post(t.FromAccount, t.Amount.Negate(), desc, &settled)
+		s.post(t.ToAccount, t.Credited(), desc, &settled)
+		if t.Reference != sweepTransferReference {
+			s.credited(t.ToAccount)
+		}
+	case TransferFailed:
+		failed := *t
+		s.events.Publish(DomainEvent{Kind: EventTransferFailed, AccountID: t.FromAccount, Transfer: &failed, Amount: t.Amount})
+	}
+	return nil
+}
+
+func (s *AlertingStorage) ExecuteCashOperation(op *CashOperation, dailyLimit Money) error {
+	defer s.ledger.hold()()
+	if err := s.Storage.ExecuteCashOperation(op, dailyLimit); err != nil {
+		return err
+	}
+
+	if op.Status == CashCompleted {
+		amount := op.Amount
+		if !op.Kind.credit() {
+			amount = amount.Negate()
+		}
+		s.metrics.moved(amount)
+		s.post(op.AccountID, amount, "cash "+string(op.Kind), nil)
+		if op.Kind.credit() {
+			s.credited(op.AccountID)
+		}
+	}
+	return nil
+}
+
+func (s *AlertingStorage) SendDueBillPayment(now time.Time, closedCurrencies []string) (*BillPayment, error) {
+	defer s.ledger.hold()()
+	p, err := s.Storage.SendDueBillPayment(now, closedCurrencies)
+	if err != nil || p == nil {
+		return p, err
+	}
+
+	if p.Status == BillPaymentSent {
+		s.metrics.moved(p.Amount.Negate())
+		s.post(p.AccountID, p.Amount.Negate(), "bill payment "+p.PublicID, nil)
+	}
+	return p, nil
+}
+
+func (s *AlertingStorage) RefundBillPayment(p *BillPayment, reason string) error {
+	defer s.ledger.hold()()
+	if err := s.Storage.RefundBillPayment(p, reason); err != nil {
+		return err
+	}
+
+	s.metrics.moved(p.Amount)
+	s.post(p.AccountID, p.Amount, "refund of bill payment "+p.PublicID, nil)
+	s.credited(p.AccountID)
+	return nil
+}
+
+func (s *AlertingStorage) ClearCheque(c *Cheque) error {
+	defer s.ledger.hold()()
+	if err := s.Storage.ClearCheque(c); err != nil {
+		return err
+	}
+
+	s.metrics.moved(c.Amount)
+	s.post(c.AccountID, c.Amount, "cheque "+c.PublicID, nil)
+	s.credited(c.AccountID)
+	return nil
+}
+
+func (s *AlertingStorage) credited(accountID int) {
+	if s.onCredit != nil {
+		s.onCredit(accountID)
+	}
+}
+
+// post publishes a posting and evaluates the account's rules against it.
+// transfer is the transfer the posting is a leg of, if any. Failing to
+// alert never fails the posting itself.
+func (s *AlertingStorage) post(accountID int, amount Money, description string, transfer *Transfer) {
+	s.events.Publish(DomainEvent{Kind: EventAccountPosted, AccountID: accountID, Transfer: transfer, Amount: amount, Description: description})
+
+	rules, err := s.Storage.GetAlertRules(accountID)
+	if err != nil {
+		log.Printf("Failed to load alert rules for account %d: %v\n", accountID, err)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	account, err := s.Storage.GetAccountByID(accountID)
+	if err != nil {
+		log.Printf("Failed to load account %d for alerts: %v\n", accountID, err)
+		return
+	}
+
+	posting := LedgerPosting{AccountID: accountID, Amount: amount, Balance: account.Balance, Description: description}
+	for _, rule := range rules {
+		if !rule.Matches(posting) {
+			continue
+		}
+		n := NewNotification(accountID, NotificationKind("alert."+string(rule.Kind)), rule.message(posting))
+		if err := s.notifier.Notify(n); err != nil {
+			log.Println("Failed to send alert: ", err)
+		}
+	}
+}
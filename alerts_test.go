@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingNotifier struct {
+	sent []Notification
+}
+
+func (n *recordingNotifier) Notify(msg Notification) error {
+	n.sent = append(n.sent, msg)
+	return nil
+}
+
+func TestAlertRuleMatches(t *testing.T) {
+	usd := func(amount int64) *Money {
+		m := NewMoney(amount, "USD")
+		return &m
+	}
+	posting := func(amount, balance int64, currency string) LedgerPosting {
+		return LedgerPosting{Amount: NewMoney(amount, currency), Balance: NewMoney(balance, currency)}
+	}
+
+	tests := []struct {
+		name    string
+		rule    AlertRule
+		posting LedgerPosting
+		want    bool
+	}{
+		{"balance crosses threshold", AlertRule{Kind: AlertBalanceBelow, Threshold: usd(1000)}, posting(-500, 800, "USD"), true},
+		{"balance already below", AlertRule{Kind: AlertBalanceBelow, Threshold: usd(1000)}, posting(-100, 800, "USD"), false},
+		{"balance stays above", AlertRule{Kind: AlertBalanceBelow, Threshold: usd(1000)}, posting(-100, 1000, "USD"), false},
+		{"large debit", AlertRule{Kind: AlertDebitAbove, Threshold: usd(5000)}, posting(-5001, 0, "USD"), true},
+		{"small debit", AlertRule{Kind: AlertDebitAbove, Threshold: usd(5000)}, posting(-5000, 0, "USD"), false},
+		{"large credit", AlertRule{Kind: AlertDebitAbove, Threshold: usd(5000)}, posting(9000, 9000, "USD"), false},
+		{"foreign currency", AlertRule{Kind: AlertForeignCurrency, Currency: "USD"}, posting(-100, 0, "EUR"), true},
+		{"home currency", AlertRule{Kind: AlertForeignCurrency, Currency: "USD"}, posting(-100, 0, "USD"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.rule.Matches(tt.posting))
+		})
+	}
+}
+
+func TestAlertingStorageNotifiesOnTransfer(t *testing.T) {
+	notifier := &recordingNotifier{}
+	store := NewAlertingStorage(NewMemoryStorage(), notifier)
+	from := createTestAccount(t, store, 10000)
+	to := createTestAccount(t, store, 0)
+
+	threshold := NewMoney(2000, defaultCurrency)
+	assert.Nil(t, store.CreateAlertRule(&AlertRule{AccountID: from.ID, Kind: AlertBalanceBelow, Threshold: &threshold}))
+
+	transfer := NewTransfer(from.ID, to.ID, NewMoney(9000, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(transfer))
+	assert.Nil(t, store.ExecuteTransfer(transfer))
+
+	assert.Len(t, notifier.sent, 1)
+	assert.Equal(t, from.ID, notifier.sent[0].AccountID)
+	assert.Equal(t, NotificationKind("alert.balance_below"), notifier.sent[0].Kind)
+
+	// Executing it again doesn't post twice.
+	assert.Nil(t, store.ExecuteTransfer(transfer))
+	assert.Len(t, notifier.sent, 1)
+}
@@ -1,40 +1,310 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 )
 
+// apiVersion is bumped whenever a JSON field clients may rely on is renamed
+// or removed, or changes type. TestAPIContract enforces it.
+const apiVersion = 8
+
 type APIServer struct {
 	listenAddress string
 	storage       Storage
+	transfers     *TransferProcessor
+	billPay       *BillPayScheduler
+	cheques       *ChequeClearing
+	notifier      Notifier
+	notifications *QueuedNotifier
+	webhooks      *WebhookDispatcher
+	receipts      *ReceiptSigner
+	sandbox       *Sandbox
+	capture       CaptureConfig
+	concurrency   *ConcurrencyLimiter
+	shedder       *LoadShedder
+	usage         *UsageMeter
+	sweeps        *SweepEvaluator
+	archive       *Archiver
+	retention     *RetentionSweeper
+	geo           GeoLocator
+	documents     *DocumentCenter
+	latency       *LatencyBudget
+	timeouts      *StatementTimeouts
+	metrics       *LedgerMetrics
+	migrations    *MigrationRunner
+	errorReports  *ErrorReporting
+	events        *EventBus
+	eod           *EndOfDay
+	reviewSLA     *ReviewSLA
+	policy        *PolicyEngine
 }
 
 func NewAPIServer(listenAddr string, store Storage) *APIServer {
+	sandbox := sandboxFromEnv()
+	webhooks := webhookDispatcherFromEnv(LogNotifier{}, store)
+	notifications := NewQueuedNotifierFromEnv(webhooks)
+	var notifier Notifier = notifications
+	alerting := NewAlertingStorage(store, notifier)
+	store = alerting
+	sweeps := NewSweepEvaluator(store, notifier)
+	alerting.onCredit = sweeps.Enqueue
+	metrics := ledgerMetricsFromEnv(store, notifications)
+	alerting.metrics = metrics
+	events := eventBusFromEnv()
+	alerting.events = events
+	ledger := &LedgerFreeze{}
+	alerting.ledger = ledger
+	documents := documentCenterFromEnv(store, notifier)
+	latency := latencyBudgetFromEnv()
+	policy, err := policyEngineFromEnv()
+	if err != nil {
+		log.Fatal("Invalid authorization policy: ", err)
+	}
+	events.Subscribe("transfer-notifications", notifyTransferOutcome(notifier), EventTransferSettled, EventTransferFailed)
+	events.Subscribe("statements", documents.onEndOfDay, EventEndOfDayCompleted)
+	events.Subscribe("reconciliation", metrics.onEndOfDay, EventEndOfDayCompleted)
+	events.Subscribe("automations", NewAutomationEngine(store, notifier).handle, EventAccountPosted)
+	events.Subscribe("review-escalations", auditEscalation(store), EventReviewEscalated)
+
 	return &APIServer{
 		listenAddress: listenAddr,
 		storage:       store,
+		transfers:     NewTransferProcessor(store, sandbox.settleDelay()),
+		billPay:       NewBillPayScheduler(store, notifier),
+		cheques:       NewChequeClearing(store, notifier, chequeClearingConfigFromEnv()),
+		notifier:      notifier,
+		notifications: notifications,
+		webhooks:      webhooks,
+		receipts:      newReceiptSignerFromEnv(),
+		sandbox:       sandbox,
+		capture:       captureConfigFromEnv(),
+		concurrency:   concurrencyLimiterFromEnv(),
+		shedder:       loadShedderFromEnv(store),
+		usage:         usageMeterFromEnv(store),
+		sweeps:        sweeps,
+		archive:       archiverFromEnv(store),
+		retention:     retentionSweeperFromEnv(store),
+		geo:           geoLocatorFromEnv(),
+		documents:     documents,
+		latency:       latency,
+		timeouts:      statementTimeoutsFromEnv(latency),
+		metrics:       metrics,
+		migrations:    migrationRunnerFromEnv(store),
+		errorReports:  errorReportingFromEnv(),
+		events:        events,
+		eod:           endOfDayFromEnv(store, events, ledger),
+		reviewSLA:     reviewSLAFromEnv(store, events),
+		policy:        policy,
 	}
 }
 
 func (s *APIServer) Run() {
+	s.notifications.Start()
+	s.webhooks.Start()
+	s.events.Start()
+	go s.transfers.Run()
+	go s.billPay.Run()
+	go s.cheques.Run()
+	go s.shedder.Run()
+	go s.usage.Run()
+	go s.sweeps.Run()
+	go s.documents.Run()
+	go s.metrics.Run()
+	go s.migrations.Run()
+	go s.eod.Run()
+	go s.reviewSLA.Run()
+	go s.errorReports.Run()
+	if s.archive != nil {
+		go s.archive.Run()
+	}
+	if s.retention != nil {
+		go s.retention.Run()
+	}
+
+	router, err := s.Handler()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	listener, err := systemdListener()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if listener == nil {
+		if listener, err = net.Listen("tcp", s.listenAddress); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Println("JSON API Server is running on ", listener.Addr())
+
+	if err := serve(listener, router); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (s *APIServer) Router() *mux.Router {
 	router := mux.NewRouter()
 
 	router.HandleFunc("/login", makeHTTPHandleFunc(s.HandleLogin))
+	router.HandleFunc("/login/verify", makeHTTPHandleFunc(s.HandleVerifyLogin))
 	router.HandleFunc("/account", makeHTTPHandleFunc(s.HandleAccount))
-	router.HandleFunc("/account/{id}", makeHTTPHandleFunc(withJWTAuth(s.HandleGetAccountByID, s.storage)))
-	router.HandleFunc("/transfer", makeHTTPHandleFunc(withJWTAuth(s.HandleTransfer, s.storage)))
+	router.HandleFunc("/account/{id}", makeHTTPHandleFunc(withJWTAuth(s.HandleGetAccountByID, s.storage, ownerOrDelegate)))
+	router.HandleFunc("/account/{id}/balance", makeHTTPHandleFunc(withJWTAuth(s.HandleGetBalance, s.storage, ownerOrDelegate)))
+	router.HandleFunc("/account/{id}/interest/simulate", makeHTTPHandleFunc(withJWTAuth(s.HandleSimulateInterest, s.storage, ownerOrDelegate)))
+	router.HandleFunc("/account/{id}/activity", makeHTTPHandleFunc(withJWTAuth(s.HandleGetAccountActivity, s.storage, ownerOrDelegate)))
+	router.HandleFunc("/account/{id}/transfers/export", makeHTTPHandleFunc(withJWTAuth(s.HandleExportTransfers, s.storage, ownerOrDelegate)))
+	router.HandleFunc("/account/{id}/transactions/sync", makeHTTPHandleFunc(withJWTAuth(s.HandleTransactionsSync, s.storage, ownerOrDelegate)))
+	router.HandleFunc("/account/{id}/transactions/retag", makeHTTPHandleFunc(withJWTAuth(s.HandleRetagTransactions, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/transactions/{transferID}/tags", makeHTTPHandleFunc(withJWTAuth(s.HandleTransactionTag, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/categories", makeHTTPHandleFunc(withJWTAuth(s.HandleGetCategories, s.storage, ownerOrDelegate)))
+	router.HandleFunc("/account/{id}/logins", makeHTTPHandleFunc(withJWTAuth(s.HandleGetAccountLogins, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/api-usage", makeHTTPHandleFunc(withJWTAuth(s.HandleGetAPIUsage, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/devices", makeHTTPHandleFunc(withJWTAuth(s.HandleDevices, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/devices/{deviceID}", makeHTTPHandleFunc(withJWTAuth(s.HandleRevokeDevice, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/payees", makeHTTPHandleFunc(withJWTAuth(s.HandlePayees, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/payees/{payeeID}", makeHTTPHandleFunc(withJWTAuth(s.HandleDeletePayee, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/bill-payments", makeHTTPHandleFunc(withJWTAuth(s.HandleBillPayments, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/bill-payments/{paymentID}", makeHTTPHandleFunc(withJWTAuth(s.HandleCancelBillPayment, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/bill-payments/{paymentID}/pause", makeHTTPHandleFunc(withJWTAuth(s.HandlePauseBillPayment, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/bill-payments/{paymentID}/resume", makeHTTPHandleFunc(withJWTAuth(s.HandleResumeBillPayment, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/bill-payments/{paymentID}/skip", makeHTTPHandleFunc(withJWTAuth(s.HandleSkipBillPayment, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/invoices", makeHTTPHandleFunc(withJWTAuth(s.HandleInvoices, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/invoices/{invoiceID}", makeHTTPHandleFunc(withJWTAuth(s.HandleGetInvoice, s.storage, ownsAccount)))
+	router.HandleFunc("/invoices/{invoiceID}/pay", makeHTTPHandleFunc(withJWTAuth(s.HandlePayInvoice, s.storage, requireScope("transfers"))))
+	router.HandleFunc("/account/{id}/alerts", makeHTTPHandleFunc(withJWTAuth(s.HandleAlertRules, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/alerts/{ruleID}", makeHTTPHandleFunc(withJWTAuth(s.HandleAlertRule, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/sweeps", makeHTTPHandleFunc(withJWTAuth(s.HandleSweepRules, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/sweeps/{ruleID}", makeHTTPHandleFunc(withJWTAuth(s.HandleSweepRule, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/automations", makeHTTPHandleFunc(withJWTAuth(s.HandleAutomationRules, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/automations/{ruleID}", makeHTTPHandleFunc(withJWTAuth(s.HandleAutomationRule, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/webhooks", makeHTTPHandleFunc(withJWTAuth(s.HandleWebhooks, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/webhooks/{webhookID}", makeHTTPHandleFunc(withJWTAuth(s.HandleWebhook, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/webhooks/{webhookID}/enable", makeHTTPHandleFunc(withJWTAuth(s.HandleEnableWebhook, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/automations/{ruleID}/runs", makeHTTPHandleFunc(withJWTAuth(s.HandleAutomationRuns, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/freezes", makeHTTPHandleFunc(withJWTAuth(s.HandleFreezeWindows, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/freezes/{windowID}", makeHTTPHandleFunc(withJWTAuth(s.HandleFreezeWindow, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/delegates", makeHTTPHandleFunc(withJWTAuth(s.HandleDelegations, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/delegates/{delegationID}", makeHTTPHandleFunc(withJWTAuth(s.HandleRevokeDelegation, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/impersonations", makeHTTPHandleFunc(withJWTAuth(s.HandleImpersonations, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/impersonations/{impersonationID}/consent", makeHTTPHandleFunc(withJWTAuth(s.HandleConsentImpersonation, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/impersonations/{impersonationID}/revoke", makeHTTPHandleFunc(withJWTAuth(s.HandleRevokeImpersonation, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/documents", makeHTTPHandleFunc(withJWTAuth(s.HandleDocuments, s.storage, ownerOrDelegate)))
+	router.HandleFunc("/account/{id}/documents/preferences", makeHTTPHandleFunc(withJWTAuth(s.HandlePaperlessPreferences, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/documents/{documentID}/url", makeHTTPHandleFunc(withJWTAuth(s.HandleDocumentURL, s.storage, ownerOrDelegate)))
+	router.HandleFunc("/documents/{documentID}", makeHTTPHandleFunc(s.HandleDownloadDocument))
+	router.HandleFunc("/account/{id}/terms", makeHTTPHandleFunc(withJWTAuth(s.HandleTerms, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/contacts", makeHTTPHandleFunc(withJWTAuth(s.HandleContacts, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/contacts/lookup", makeHTTPHandleFunc(withJWTAuth(s.HandleContactLookup, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/contacts/{contactID}", makeHTTPHandleFunc(withJWTAuth(s.HandleContact, s.storage, ownsAccount)))
+	router.HandleFunc("/account/{id}/cheques", makeHTTPHandleFunc(withJWTAuth(s.HandleCheques, s.storage, ownsAccount)))
+	router.HandleFunc("/cash/deposit", makeHTTPHandleFunc(withTerminalAuth(s.HandleCashDeposit)))
+	router.HandleFunc("/cash/withdrawal", makeHTTPHandleFunc(withTerminalAuth(s.HandleCashWithdrawal)))
+	router.HandleFunc("/teller/cash/deposit", makeHTTPHandleFunc(withTellerAuth(s.HandleTellerCashDeposit)))
+	router.HandleFunc("/teller/cash/withdrawal", makeHTTPHandleFunc(withTellerAuth(s.HandleTellerCashWithdrawal)))
+	router.HandleFunc("/teller/adjustments", makeHTTPHandleFunc(withTellerAuth(s.HandleTellerAdjustment)))
+	router.HandleFunc("/teller/approvals", makeHTTPHandleFunc(withTellerAuth(s.HandleTellerApprovals)))
+	router.HandleFunc("/teller/approvals/{approvalID}/approve", makeHTTPHandleFunc(withTellerAuth(s.HandleTellerApprove)))
+	router.HandleFunc("/teller/approvals/{approvalID}/reject", makeHTTPHandleFunc(withTellerAuth(s.HandleTellerReject)))
+	router.HandleFunc("/admin/logins", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminGetLogins)))
+	router.HandleFunc("/admin/accounts", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminSearchAccounts)))
+	router.HandleFunc("/admin/accounts/{accountID}/ownership", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminTransferOwnership)))
+	router.HandleFunc("/admin/accounts/{accountID}/close", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminCloseAccount)))
+	router.HandleFunc("/admin/accounts/{accountID}/legal-hold", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminLegalHold)))
+	router.HandleFunc("/admin/accounts/{accountID}/impersonations", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminImpersonate)))
+	router.HandleFunc("/admin/impersonations/{impersonationID}/revoke", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminRevokeImpersonation)))
+	router.HandleFunc("/admin/audit", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminGetAuditEvents)))
+	router.HandleFunc("/admin/usage", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminGetUsage)))
+	router.HandleFunc("/admin/transfers", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminGetTransfers)))
+	router.HandleFunc("/admin/policy", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminPolicy)))
+	router.HandleFunc("/admin/policy/reload", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminReloadPolicy)))
+	router.HandleFunc("/admin/reviews", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminReviews)))
+	router.HandleFunc("/admin/reviews/worklist", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminReviewWorklist)))
+	router.HandleFunc("/admin/reviews/{transferID}/case", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminReviewCase)))
+	router.HandleFunc("/admin/reviews/{transferID}/approve", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminApproveReview)))
+	router.HandleFunc("/admin/reviews/{transferID}/decline", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminDeclineReview)))
+	router.HandleFunc("/admin/migrations", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminMigrations)))
+	router.HandleFunc("/admin/migrations/{name}/cutover", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminCutOverMigration)))
+	router.HandleFunc("/admin/terms", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminTerms)))
+	router.HandleFunc("/admin/holidays", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminHolidays)))
+	router.HandleFunc("/admin/holidays/{holidayID}", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminDeleteHoliday)))
+	router.HandleFunc("/admin/statements/regenerations", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminRegenerateStatements)))
+	router.HandleFunc("/admin/statements/regenerations/{regenerationID}", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminGetRegeneration)))
+	router.HandleFunc("/admin/eod", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminEndOfDay)))
+	router.HandleFunc("/admin/eod/{businessDate}", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminGetEndOfDay)))
+	router.HandleFunc("/admin/reports/reconciliation", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminReconciliation)))
+	router.HandleFunc("/admin/reports/duplicates", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminDuplicates)))
+	router.HandleFunc("/admin/reports/system-accounts", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminSystemAccounts)))
+	router.HandleFunc("/admin/captures", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminGetCaptures)))
+	router.HandleFunc("/admin/captures/{captureID}", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminGetCapture)))
+	router.HandleFunc("/admin/captures/{captureID}/replay", makeHTTPHandleFunc(withAdminAuth(s.HandleAdminReplayCapture)))
+	router.PathPrefix("/admin/ui").Handler(makeHTTPHandleFunc(withAdminAuth(s.HandleAdminUI)))
+	router.HandleFunc("/transfer", makeHTTPHandleFunc(withJWTAuth(withAccountLock(s.HandleTransfer, s.concurrency), s.storage, requireScope("transfers"))))
+	router.HandleFunc("/fx/quotes", makeHTTPHandleFunc(withJWTAuth(s.HandleCreateFXQuote, s.storage, requireScope("transfers"))))
+	router.HandleFunc("/transfer/preview", makeHTTPHandleFunc(withJWTAuth(s.HandlePreviewTransfer, s.storage, requireScope("transfers"))))
+	router.HandleFunc("/transfer/{transferID}", makeHTTPHandleFunc(withJWTAuth(s.HandleGetTransfer, s.storage, partyToTransfer)))
+	router.HandleFunc("/transfer/{transferID}/status", makeHTTPHandleFunc(withJWTAuth(s.HandleTransferStatus, s.storage, partyToTransfer)))
+	router.HandleFunc("/transfer/{transferID}/refund", makeHTTPHandleFunc(withJWTAuth(withAccountLock(s.HandleRefundTransfer, s.concurrency), s.storage, allOf(partyToTransfer, requireScope("transfers")))))
+	router.HandleFunc("/transactions/{transferID}/receipt", makeHTTPHandleFunc(withJWTAuth(s.HandleGetReceipt, s.storage, partyToTransfer)))
+	router.HandleFunc("/receipts/key", makeHTTPHandleFunc(s.HandleGetReceiptKey))
+	router.HandleFunc("/webhooks/event-types", makeHTTPHandleFunc(s.HandleEventTypes))
+	router.HandleFunc("/debug/vars", makeHTTPHandleFunc(withAdminAuth(s.HandleDebugVars)))
+	router.HandleFunc("/metrics", makeHTTPHandleFunc(withAdminAuth(s.HandleMetrics)))
+
+	router.Use(s.middlewares()...)
+
+	if s.sandbox != nil {
+		log.Println("Running in sandbox mode")
+		router.HandleFunc("/sandbox/account/{id}/failures", makeHTTPHandleFunc(withJWTAuth(s.HandleForceFailure, s.storage, ownsAccount)))
+	}
+
+	return router
+}
 
-	log.Println("JSON API Server is running on ", s.listenAddress)
+// middlewares run on every matched route, the first outermost.
+func (s *APIServer) middlewares() []mux.MiddlewareFunc {
+	return []mux.MiddlewareFunc{
+		s.errorReports.Middleware,
+		s.policy.Middleware,
+		s.latency.Middleware,
+		s.timeouts.Middleware,
+		s.captureMiddleware,
+		s.shedder.Middleware,
+		s.concurrency.Middleware,
+		s.usage.Middleware,
+		jsonNamingMiddleware,
+	}
+}
 
-	http.ListenAndServe(s.listenAddress, router)
+// Handler serves the routes of Router with the router selected by ROUTER:
+// "mux", the default, or "radix", which matches paths without trying
+// every route's regexp.
+func (s *APIServer) Handler() (http.Handler, error) {
+	router := s.Router()
+	switch kind := getEnv("ROUTER", "mux"); kind {
+	case "mux":
+		return router, nil
+	case "radix":
+		return newRadixRouter(router, s.middlewares())
+	default:
+		return nil, fmt.Errorf("unknown ROUTER %q", kind)
+	}
 }
 
 func (s *APIServer) HandleLogin(w http.ResponseWriter, r *http.Request) error {
@@ -47,20 +317,33 @@ func (s *APIServer) HandleLogin(w http.ResponseWriter, r *http.Request) error {
 		return loginDenied
 	}
 
+	attempt := NewLoginAttempt(req.Number, r)
 	acc, err := s.storage.GetAccountByNumber(int32(req.Number))
-	if err != nil {
+	if err == nil {
+		attempt.AccountID = &acc.ID
+		attempt.Success = acc.ValidPassword(req.Password)
+	}
+	if err := s.storage.CreateLoginAttempt(attempt); err != nil {
+		log.Println("Failed to record login attempt: ", err)
+	}
+
+	if !attempt.Success {
 		return loginDenied
 	}
 
-	if ok := acc.ValidPassword(req.Password); ok {
-		token, err := createJWT(acc)
-		if err != nil {
-			return err
-		}
-		return writeJSON(w, http.StatusOK, LoginResponse{Number: acc.Number, Token: token})
+	trusted, err := s.trustedDevice(acc, req)
+	if err != nil {
+		return err
+	}
+	if !trusted {
+		return s.challengeLogin(w, acc, attempt)
 	}
 
-	return loginDenied
+	token, err := createJWT(acc)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, LoginResponse{Number: acc.Number, Token: token})
 }
 
 func (s *APIServer) HandleAccount(w http.ResponseWriter, r *http.Request) error {
@@ -90,19 +373,22 @@ func (s *APIServer) HandleGetAccountByID(w http.ResponseWriter, r *http.Request)
 			return err
 		}
 
-		account, err := s.storage.GetAccountByID(id)
+		account, err := s.storageFor(r).GetAccountByID(id)
 		if err != nil {
 			return err
 		}
+		if p := principalFromContext(r); p != nil && p.DelegatorID != 0 {
+			return writeJSON(w, http.StatusOK, account)
+		}
 
-		return writeJSON(w, http.StatusOK, account)
+		return writeJSON(w, http.StatusOK, account.Details())
 	}
 
 	if r.Method == http.MethodDelete {
 		return s.HandleDeleteAccount(w, r)
 	}
 
-	return fmt.Errorf("method not allowed")
+	return methodNotAllowed
 }
 
 func (s *APIServer) HandleCreateAccount(w http.ResponseWriter, r *http.Request) error {
@@ -116,12 +402,40 @@ func (s *APIServer) HandleCreateAccount(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return err
 	}
+	account.Balance.Amount = s.sandbox.openingBalance()
+	account.Business = req.Business
+	if req.Phone != "" {
+		phone, ok := normalizePhone(req.Phone)
+		if !ok {
+			return ApiError{Err: "invalid phone number: " + req.Phone, Status: http.StatusBadRequest}
+		}
+		account.Phone = phone
+	}
+	if ok, err := s.checkDuplicateSignup(w, req, account.Phone); !ok {
+		return err
+	}
+	if err := s.checkSignupTerms(req.AcceptedTerms); err != nil {
+		return err
+	}
 
-	if err := s.storage.CreateAccount(account); err != nil {
+	// Account numbers are random; draw a new one on the rare collision.
+	for attempt := 0; ; attempt++ {
+		err = s.storage.CreateAccount(account)
+		if !errors.Is(err, ErrDuplicateAccountNumber) || attempt == 3 {
+			break
+		}
+		account.Number = rand.Int31n(math.MaxInt32)
+	}
+	if err != nil {
 		return err
 	}
+	if req.AcceptedTerms != "" {
+		if _, err := s.acceptTerms(r, account.ID, req.AcceptedTerms); err != nil {
+			return err
+		}
+	}
 
-	return writeJSON(w, http.StatusOK, account)
+	return writeJSON(w, http.StatusOK, account.Details())
 }
 
 func (s *APIServer) HandleDeleteAccount(w http.ResponseWriter, r *http.Request) error {
@@ -137,27 +451,32 @@ func (s *APIServer) HandleDeleteAccount(w http.ResponseWriter, r *http.Request)
 	return writeJSON(w, http.StatusOK, map[string]int{"deleted": id})
 }
 
-func (s *APIServer) HandleTransfer(w http.ResponseWriter, r *http.Request) error {
-	transferReq := new(TransferRequest)
-	if err := json.NewDecoder(r.Body).Decode(transferReq); err != nil {
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	start := time.Now()
+	e := getEncoder()
+	defer e.release()
+	naming := jsonNamingOf(w)
+	if err := e.enc.Encode(withJSONNaming(v, naming)); err != nil {
 		return err
 	}
-	defer r.Body.Close()
-
-	return writeJSON(w, http.StatusOK, transferReq)
-}
+	if t := serverTimingOf(w); t != nil {
+		t.serialize += time.Since(start)
+	}
 
-func writeJSON(w http.ResponseWriter, status int, v any) error {
 	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set(jsonNamingHeader, string(naming))
 	w.WriteHeader(status)
 
-	return json.NewEncoder(w).Encode(v)
+	_, err := w.Write(e.buf.Bytes())
+	return err
 }
 
 func createJWT(account *Account) (string, error) {
 	claims := jwt.MapClaims{
 		"expiresAt":     15000,
 		"accountNumber": account.Number,
+		"tokenVersion":  account.TokenVersion,
+		"scope":         strings.Join(customerScopes, " "),
 	}
 
 	secret := getSecret()
@@ -166,36 +485,99 @@ func createJWT(account *Account) (string, error) {
 	return token.SignedString([]byte(secret))
 }
 
-func withJWTAuth(apiFunc apiFunc, s Storage) apiFunc {
+type contextKey int
+
+const (
+	accountContextKey contextKey = iota
+	principalContextKey
+)
+
+// withJWTAuth authenticates the caller from the x-jwt-token header and
+// checks policy against what the request addresses, through the
+// authorization rules in force.
+func withJWTAuth(apiFunc apiFunc, s Storage, policy Policy) apiFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
+		start := time.Now()
 		tokenString := r.Header.Get("x-jwt-token")
 		token, err := validateJWT(tokenString)
 		if err != nil || !token.Valid {
 			return permissionDenied
 		}
 
-		userId, err := getID(r)
-		if err != nil {
+		claims := token.Claims.(jwt.MapClaims)
+		number, ok := claims["accountNumber"].(float64)
+		if !ok {
 			return permissionDenied
 		}
 
-		account, err := s.GetAccountByID(userId)
+		store := storageWithContext(s, r.Context())
+		account, err := store.GetAccountByNumber(int32(number))
 		if err != nil {
 			return permissionDenied
 		}
-
-		claims := token.Claims.(jwt.MapClaims)
-		res, ok := claims["accountNumber"].(float64)
-		if !ok || account.Number != int32(res) {
+		// Tokens issued before tokenVersion existed carry none, which
+		// matches accounts whose sessions were never revoked.
+		if version, _ := claims["tokenVersion"].(float64); int(version) != account.TokenVersion {
 			return permissionDenied
 		}
 
-		err = apiFunc(w, r)
-		if err != nil {
+		scope, _ := claims["scope"].(string)
+		principal := customerPrincipal(account, scope)
+		if id, _ := claims["impersonation"].(string); id != "" {
+			if err := impersonate(r, s, principal, id); err != nil {
+				return err
+			}
+		}
+		identifyConsumer(r, principal)
+		if err := authorize(w, r, principal, store, policy); err != nil {
 			return err
 		}
+		if principal.ImpersonationID != "" {
+			auditImpersonatedRequest(s, r, principal)
+		}
+
+		r = withPrincipal(r, principal)
+		if err := meterRequest(w, r); err != nil {
+			return err
+		}
+		useConsumerNaming(w, r)
 
-		return nil
+		ctx := context.WithValue(r.Context(), accountContextKey, account)
+		timeAuth(w, start)
+		return apiFunc(w, r.WithContext(ctx))
+	}
+}
+
+// accountFromContext returns the account authenticated by withJWTAuth.
+func accountFromContext(r *http.Request) *Account {
+	account, _ := r.Context().Value(accountContextKey).(*Account)
+	return account
+}
+
+// withAdminAuth lets through back-office callers presenting the shared
+// admin token, either in the x-admin-token header or, for browsers, as the
+// HTTP Basic password.
+func withAdminAuth(apiFunc apiFunc) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		start := time.Now()
+		token := r.Header.Get("x-admin-token")
+		user := ""
+		if token == "" {
+			basicUser, password, ok := r.BasicAuth()
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Basic realm="gobank admin"`)
+				return ApiError{Err: "authentication required", Status: http.StatusUnauthorized}
+			}
+			user, token = basicUser, password
+		}
+
+		secret := getAdminSecret()
+		if secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return permissionDenied
+		}
+
+		timeAuth(w, start)
+		return apiFunc(w, withPrincipal(r, &Principal{Role: RoleAdmin, Name: user}))
 	}
 }
 
@@ -212,8 +594,48 @@ func validateJWT(tokenString string) (*jwt.Token, error) {
 }
 
 var loginDenied = ApiError{Err: "wrong number or password", Status: http.StatusForbidden}
+
+// domainErrorStatus maps the domain errors storage reports to the status
+// they are answered with.
+var domainErrorStatus = map[error]int{
+	ErrInsufficientFunds:      http.StatusUnprocessableEntity,
+	ErrDuplicateAccountNumber: http.StatusConflict,
+	ErrDuplicatePhone:         http.StatusConflict,
+	ErrStateConflict:          http.StatusConflict,
+	ErrPrimaryUnavailable:     http.StatusServiceUnavailable,
+	ErrQueryTimeout:           http.StatusServiceUnavailable,
+	ErrRequestCancelled:       http.StatusServiceUnavailable,
+	ErrDebitsFrozen:           http.StatusLocked,
+	ErrDuplicateHoliday:       http.StatusConflict,
+	ErrDuplicateTermsVersion:  http.StatusConflict,
+	ErrTermsNotAccepted:       http.StatusForbidden,
+	ErrAccountNotFound:        http.StatusNotFound,
+	ErrTransferNotFound:       http.StatusNotFound,
+	ErrDeviceNotFound:         http.StatusNotFound,
+	ErrLoginChallengeNotFound: http.StatusNotFound,
+	ErrCaptureNotFound:        http.StatusNotFound,
+	ErrPayeeNotFound:          http.StatusNotFound,
+	ErrBillPaymentNotFound:    http.StatusNotFound,
+	ErrInvoiceNotFound:        http.StatusNotFound,
+	ErrAlertRuleNotFound:      http.StatusNotFound,
+	ErrContactNotFound:        http.StatusNotFound,
+	ErrSweepRuleNotFound:      http.StatusNotFound,
+	ErrFreezeWindowNotFound:   http.StatusNotFound,
+	ErrDocumentNotFound:       http.StatusNotFound,
+	ErrMigrationNotFound:      http.StatusNotFound,
+	ErrTellerApprovalNotFound: http.StatusNotFound,
+	ErrFXQuoteNotFound:        http.StatusNotFound,
+	ErrImpersonationNotFound:  http.StatusNotFound,
+	ErrRegenerationNotFound:   http.StatusNotFound,
+	ErrEndOfDayNotFound:       http.StatusNotFound,
+	ErrAutomationRuleNotFound: http.StatusNotFound,
+	ErrLegalHoldNotFound:      http.StatusNotFound,
+	ErrWebhookNotFound:        http.StatusNotFound,
+}
+
 var permissionDenied = ApiError{Err: "permission denied", Status: http.StatusForbidden}
 var methodNotAllowed = ApiError{Err: "method not allowed", Status: http.StatusMethodNotAllowed}
+var invalidRequest = ApiError{Err: "invalid request body", Status: http.StatusBadRequest}
 
 type apiFunc func(http.ResponseWriter, *http.Request) error
 
@@ -235,7 +657,15 @@ func makeHTTPHandleFunc(f apiFunc) http.HandlerFunc {
 				writeJSON(w, e.Status, e)
 				return
 			}
+			er = contextError(r.Context(), er)
+			for domainErr, status := range domainErrorStatus {
+				if errors.Is(er, domainErr) {
+					writeJSON(w, status, ApiError{Err: domainErr.Error(), Status: status})
+					return
+				}
+			}
 			fmt.Println("Internal error: ", er.Error())
+			reportError(r, er)
 			writeJSON(w, http.StatusInternalServerError, ApiError{Err: "internal server", Status: http.StatusInternalServerError})
 		}
 	}
@@ -245,11 +675,36 @@ func getSecret() string {
 	return os.Getenv("JWT_SECRET")
 }
 
+func getAdminSecret() string {
+	return os.Getenv("ADMIN_TOKEN")
+}
+
+// getID returns the account id from the URL, which holds either the
+// account's public ULID or its integer id.
 func getID(r *http.Request) (int, error) {
 	idStr := mux.Vars(r)["id"]
+	if acc := accountFromContext(r); acc != nil && isULID(idStr) && acc.PublicID == idStr {
+		return acc.ID, nil
+	}
+	if p := principalFromContext(r); p != nil && p.DelegatorPublicID != "" && p.DelegatorPublicID == idStr {
+		return p.DelegatorID, nil
+	}
+	return getIntVar(r, "id")
+}
+
+func getIntParam(v, name string) (int, error) {
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, ApiError{Err: fmt.Sprintf("invalid %s: %s", name, v), Status: http.StatusBadRequest}
+	}
+	return i, nil
+}
+
+func getIntVar(r *http.Request, name string) (int, error) {
+	idStr := mux.Vars(r)[name]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		return id, fmt.Errorf("invalid id given: %s", idStr)
+		return id, ApiError{Err: "invalid id given: " + idStr, Status: http.StatusBadRequest}
 	}
 	return id, err
 }
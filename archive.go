@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const archiveBatchSize = 1000
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore is the slice of an S3-compatible bucket the archiver needs.
+// Keys are slash separated paths.
+type ObjectStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	List(prefix string) ([]string, error)
+}
+
+// DirObjectStore keeps objects as files below Root, for local runs and
+// deployments that mount a bucket as a file system.
+type DirObjectStore struct {
+	Root string
+}
+
+func (d DirObjectStore) Put(key string, data []byte) error {
+	path := filepath.Join(d.Root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so readers never see half an object.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d DirObjectStore) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(d.Root, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	return data, err
+}
+
+func (d DirObjectStore) List(prefix string) ([]string, error) {
+	keys := []string{}
+	dir := filepath.Join(d.Root, filepath.FromSlash(prefix))
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || entry.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return err
+		}
+		rel, err := filepath.Rel(d.Root, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+// Archiver moves settled transfers and audit events older than RetainFor
+// out of the database into an object store, as NDJSON objects per account.
+// Each object's key carries the time range it covers:
+//
+//	accounts/{accountID}/{transfers|audit}/{fromUnix}-{toUnix}-{ulid}.ndjson
+//
+// Objects are written before rows are deleted, so a crash in between
+// leaves duplicates, never gaps; readers drop duplicates by id.
+type Archiver struct {
+	RetainFor time.Duration
+	Interval  time.Duration
+
+	storage Storage
+	objects ObjectStore
+}
+
+// archiverFromEnv returns nil unless ARCHIVE_AFTER_DAYS is set.
+func archiverFromEnv(store Storage) *Archiver {
+	days := getEnvInt("ARCHIVE_AFTER_DAYS", 0)
+	if days <= 0 {
+		return nil
+	}
+	return &Archiver{
+		RetainFor: time.Duration(days) * 24 * time.Hour,
+		Interval:  getEnvDuration("ARCHIVE_INTERVAL", time.Hour),
+		storage:   store,
+		objects:   DirObjectStore{Root: getEnv("ARCHIVE_DIR", "archive")},
+	}
+}
+
+func (a *Archiver) Run() {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.archive(time.Now().UTC()); err != nil {
+			log.Println("Failed to archive: ", err)
+		}
+		<-ticker.C
+	}
+}
+
+func (a *Archiver) archive(now time.Time) error {
+	cutoff := now.Add(-a.RetainFor)
+
+	for {
+		transfers, err := a.storage.GetArchivableTransfers(cutoff, archiveBatchSize)
+		if err != nil || len(transfers) == 0 {
+			return err
+		}
+
+		perAccount := map[int][]TransferWithIDs{}
+		ids := make([]int, 0, len(transfers))
+		for _, t := range transfers {
+			archived := withInternalIDs(t)
+			perAccount[t.FromAccount] = append(perAccount[t.FromAccount], archived)
+			perAccount[t.ToAccount] = append(perAccount[t.ToAccount], archived)
+			ids = append(ids, t.ID)
+		}
+		for accountID, batch := range perAccount {
+			data, err := encodeNDJSON(batch)
+			if err != nil {
+				return err
+			}
+			key := archiveKey(accountID, "transfers", batch[0].CreatedAt, batch[len(batch)-1].CreatedAt)
+			if err := a.objects.Put(key, data); err != nil {
+				return err
+			}
+		}
+		if err := a.storage.DeleteTransfers(ids); err != nil {
+			return err
+		}
+		if len(transfers) < archiveBatchSize {
+			break
+		}
+	}
+
+	for {
+		events, err := a.storage.GetArchivableAuditEvents(cutoff, archiveBatchSize)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		perAccount := map[int][]*AuditEvent{}
+		ids := make([]int, 0, len(events))
+		for _, e := range events {
+			perAccount[e.AccountID] = append(perAccount[e.AccountID], e)
+			ids = append(ids, e.ID)
+		}
+		for accountID, batch := range perAccount {
+			data, err := encodeNDJSON(batch)
+			if err != nil {
+				return err
+			}
+			key := archiveKey(accountID, "audit", batch[0].CreatedAt, batch[len(batch)-1].CreatedAt)
+			if err := a.objects.Put(key, data); err != nil {
+				return err
+			}
+		}
+		if err := a.storage.DeleteAuditEvents(ids); err != nil {
+			return err
+		}
+		if len(events) < archiveBatchSize {
+			return nil
+		}
+	}
+}
+
+func archiveKey(accountID int, kind string, from, to time.Time) string {
+	return fmt.Sprintf("accounts/%d/%s/%d-%d-%s.ndjson", accountID, kind, from.Unix(), to.Unix(), NewULID())
+}
+
+// encodeNDJSON encodes records as NDJSON, one record per line.
+// restoreArchivedTransfer puts back the internal ids a transfer is
+// archived with, and looks up the public account ids older objects lack.
+// Accounts purged since stay without one.
+func restoreArchivedTransfer(a TransferWithIDs, store Storage) *Transfer {
+	t := a.Transfer
+	t.ID, t.FromAccount, t.ToAccount, t.RefundOf = a.ID, a.FromAccount, a.ToAccount, a.RefundOf
+	if t.FromPublicID == "" {
+		if from, err := store.GetAccountByID(t.FromAccount); err == nil {
+			t.FromPublicID = from.PublicID
+		}
+	}
+	if t.ToPublicID == "" {
+		if to, err := store.GetAccountByID(t.ToAccount); err == nil {
+			t.ToPublicID = to.PublicID
+		}
+	}
+	return t
+}
+
+func encodeNDJSON[T any](records []T) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// archivedObjects lists the account's objects of kind that may hold
+// records created in [from, to), newest first.
+func (a *Archiver) archivedObjects(accountID int, kind string, from, to time.Time) ([]string, error) {
+	keys, err := a.objects.List(fmt.Sprintf("accounts/%d/%s/", accountID, kind))
+	if err != nil {
+		return nil, err
+	}
+
+	type object struct {
+		key      string
+		from, to int64
+	}
+	objects := []object{}
+	for _, key := range keys {
+		parts := strings.SplitN(key[strings.LastIndex(key, "/")+1:], "-", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		oFrom, err1 := strconv.ParseInt(parts[0], 10, 64)
+		oTo, err2 := strconv.ParseInt(parts[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if oTo < from.Unix() || (!to.IsZero() && oFrom > to.Unix()) {
+			continue
+		}
+		objects = append(objects, object{key: key, from: oFrom, to: oTo})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].to > objects[j].to })
+
+	result := make([]string, len(objects))
+	for i, o := range objects {
+		result[i] = o.key
+	}
+	return result, nil
+}
+
+// ArchivedTransfers calls yield with the account's archived transfers
+// created in [from, to), newest first, one object at a time so reading
+// an account's whole history doesn't need it all in memory.
+func (a *Archiver) ArchivedTransfers(accountID int, from, to time.Time, yield func(*Transfer) error) error {
+	keys, err := a.archivedObjects(accountID, "transfers", from, to)
+	if err != nil {
+		return err
+	}
+
+	seen := map[int]bool{}
+	for _, key := range keys {
+		data, err := a.objects.Get(key)
+		if err != nil {
+			return err
+		}
+
+		transfers := []*Transfer{}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+		for scanner.Scan() {
+			archived := TransferWithIDs{Transfer: new(Transfer)}
+			if err := json.Unmarshal(scanner.Bytes(), &archived); err != nil {
+				return fmt.Errorf("archive object %s: %w", key, err)
+			}
+			t := restoreArchivedTransfer(archived, a.storage)
+			if seen[t.ID] || t.CreatedAt.Before(from) || (!to.IsZero() && !t.CreatedAt.Before(to)) {
+				continue
+			}
+			seen[t.ID] = true
+			transfers = append(transfers, t)
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+
+		sort.Slice(transfers, func(i, j int) bool {
+			return newerFirst(transfers[i].CreatedAt, transfers[i].ID, transfers[j].CreatedAt, transfers[j].ID)
+		})
+		for _, t := range transfers {
+			if err := yield(t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiverMovesOldRecords(t *testing.T) {
+	store := NewMemoryStorage()
+	archive := &Archiver{RetainFor: 30 * 24 * time.Hour, storage: store, objects: DirObjectStore{Root: t.TempDir()}}
+	server := &APIServer{storage: store, archive: archive}
+
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+	now := time.Now().UTC()
+
+	old := NewTransfer(from.ID, to.ID, NewMoney(100, defaultCurrency))
+	old.CreatedAt = now.AddDate(0, 0, -60)
+	assert.Nil(t, store.CreateTransfer(old))
+	assert.Nil(t, store.ExecuteTransfer(old))
+	recent := NewTransfer(from.ID, to.ID, NewMoney(200, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(recent))
+	assert.Nil(t, store.ExecuteTransfer(recent))
+
+	event := NewAuditEvent("admin", "account.ownership_transferred", from.ID, nil)
+	event.CreatedAt = now.AddDate(0, 0, -90)
+	assert.Nil(t, store.CreateAuditEvent(event))
+
+	assert.Nil(t, archive.archive(now))
+
+	_, err := store.GetTransferByID(old.ID)
+	assert.ErrorIs(t, err, ErrTransferNotFound)
+	events, err := store.GetAuditEvents(from.ID, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+
+	// Both parties' archives hold the transfer.
+	for _, acc := range []*Account{from, to} {
+		var archived []*Transfer
+		assert.Nil(t, archive.ArchivedTransfers(acc.ID, time.Time{}, now, func(t *Transfer) error {
+			archived = append(archived, t)
+			return nil
+		}))
+		assert.Len(t, archived, 1)
+		assert.Equal(t, old.PublicID, archived[0].PublicID)
+	}
+
+	// Exports read through to the archive.
+	r := httptest.NewRequest(http.MethodGet, "/account/"+strconv.Itoa(from.ID)+"/transfers/export?from=2000-01-01", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(from.ID)})
+	w := httptest.NewRecorder()
+	assert.Nil(t, server.HandleExportTransfers(w, r))
+
+	var exported []*Transfer
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &exported))
+	assert.Len(t, exported, 2)
+	assert.Equal(t, recent.PublicID, exported[0].PublicID)
+	assert.Equal(t, old.PublicID, exported[1].PublicID)
+	assert.Equal(t, to.PublicID, exported[1].ToPublicID)
+}
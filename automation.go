@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// automationTransferReference marks the transfers made by automation
+// rules. Credits from them, or from sweeps, don't trigger rules, so rules
+// moving money back and forth between accounts can't loop.
+const automationTransferReference = "automation"
+
+const NotifyAutomation NotificationKind = "automation.triggered"
+
+// maxAutomationRuns is how much history an automation rule lists.
+const maxAutomationRuns = 100
+
+type AutomationTrigger string
+
+const (
+	// TriggerCredit fires on money coming in, optionally only at least
+	// MinAmount or with a reference containing ReferenceContains, e.g.
+	// "salary".
+	TriggerCredit AutomationTrigger = "credit"
+	// TriggerBalanceBelow fires when a debit takes the balance below
+	// Threshold.
+	TriggerBalanceBelow AutomationTrigger = "balance_below"
+)
+
+type AutomationAction string
+
+const (
+	// ActionMovePercent moves Percent of the credit to TargetAccount.
+	ActionMovePercent AutomationAction = "move_percent"
+	// ActionMoveAmount moves Amount to TargetAccount.
+	ActionMoveAmount AutomationAction = "move_amount"
+	// ActionNotify sends the customer Message.
+	ActionNotify AutomationAction = "notify"
+)
+
+// AutomationRule is an if-this-then-that rule a customer sets up on their
+// account, e.g. "when my salary arrives, move 20% to savings". Rules are
+// evaluated by a subscriber of the event bus after each posting, and
+// every time one fires is recorded as an AutomationRun.
+type AutomationRule struct {
+	ID                int               `json:"id"`
+	AccountID         int               `json:"-"`
+	Name              string            `json:"name"`
+	Trigger           AutomationTrigger `json:"trigger"`
+	MinAmount         *Money            `json:"minAmount,omitempty"`
+	ReferenceContains string            `json:"referenceContains,omitempty"`
+	Threshold         *Money            `json:"threshold,omitempty"`
+	Action            AutomationAction  `json:"action"`
+	TargetAccount     int               `json:"-"`
+	TargetPublicID    string            `json:"targetAccountId,omitempty"`
+	Percent           int               `json:"percent,omitempty"`
+	Amount            *Money            `json:"amount,omitempty"`
+	Message           string            `json:"message,omitempty"`
+	Enabled           bool              `json:"enabled"`
+	CreatedAt         time.Time         `json:"createdAt"`
+}
+
+type AutomationRuleRequest struct {
+	Name              string            `json:"name"`
+	Trigger           AutomationTrigger `json:"trigger"`
+	MinAmount         *Money            `json:"minAmount"`
+	ReferenceContains string            `json:"referenceContains"`
+	Threshold         *Money            `json:"threshold"`
+	Action            AutomationAction  `json:"action"`
+	TargetPublicID    string            `json:"targetAccountId"`
+	Percent           int               `json:"percent"`
+	Amount            *Money            `json:"amount"`
+	Message           string            `json:"message"`
+	// Enabled defaults to true.
+	Enabled *bool `json:"enabled"`
+}
+
+type AutomationRunStatus string
+
+const (
+	AutomationExecuted AutomationRunStatus = "executed"
+	AutomationFailed   AutomationRunStatus = "failed"
+)
+
+// AutomationRun records a rule firing: the posting that triggered it and
+// what its action did.
+type AutomationRun struct {
+	ID         int                 `json:"id"`
+	RuleID     int                 `json:"ruleId"`
+	AccountID  int                 `json:"-"`
+	Posting    string              `json:"posting"`
+	Status     AutomationRunStatus `json:"status"`
+	TransferID string              `json:"transferId,omitempty"`
+	Detail     string              `json:"detail,omitempty"`
+	CreatedAt  time.Time           `json:"createdAt"`
+}
+
+// applyAutomationRequest validates req and copies it onto the rule.
+func (s *APIServer) applyAutomationRequest(rule *AutomationRule, req *AutomationRuleRequest, account *Account) error {
+	currency := account.Balance.Currency
+	inCurrency := func(m *Money, name string) (*Money, error) {
+		if m == nil {
+			return nil, nil
+		}
+		copied := *m
+		if copied.Currency == "" {
+			copied.Currency = currency
+		}
+		if copied.Currency != currency {
+			return nil, ApiError{Err: name + " must be in the account's currency", Status: http.StatusBadRequest}
+		}
+		if copied.IsNegative() {
+			return nil, ApiError{Err: name + " must not be negative", Status: http.StatusBadRequest}
+		}
+		return &copied, nil
+	}
+
+	minAmount, err := inCurrency(req.MinAmount, "minAmount")
+	if err != nil {
+		return err
+	}
+	threshold, err := inCurrency(req.Threshold, "threshold")
+	if err != nil {
+		return err
+	}
+	amount, err := inCurrency(req.Amount, "amount")
+	if err != nil {
+		return err
+	}
+
+	switch req.Trigger {
+	case TriggerCredit:
+	case TriggerBalanceBelow:
+		if threshold == nil {
+			return ApiError{Err: "a balance_below trigger needs a threshold", Status: http.StatusBadRequest}
+		}
+	default:
+		return ApiError{Err: "unknown trigger: " + string(req.Trigger), Status: http.StatusBadRequest}
+	}
+
+	var target *Account
+	switch req.Action {
+	case ActionMovePercent, ActionMoveAmount:
+		if req.Action == ActionMovePercent && (req.Trigger != TriggerCredit || req.Percent < 1 || req.Percent > 100) {
+			return ApiError{Err: "move_percent needs a credit trigger and a percent from 1 to 100", Status: http.StatusBadRequest}
+		}
+		if req.Action == ActionMoveAmount && (amount == nil || !amount.IsPositive()) {
+			return ApiError{Err: "move_amount needs a positive amount", Status: http.StatusBadRequest}
+		}
+		if req.TargetPublicID == account.PublicID {
+			return ApiError{Err: "cannot move money into the same account", Status: http.StatusBadRequest}
+		}
+		if target, err = s.targetAccount(req.TargetPublicID); err != nil {
+			return err
+		}
+		if target.Balance.Currency != currency {
+			return ApiError{Err: "target account holds a different currency", Status: http.StatusBadRequest}
+		}
+	case ActionNotify:
+		if strings.TrimSpace(req.Message) == "" {
+			return ApiError{Err: "notify needs a message", Status: http.StatusBadRequest}
+		}
+	default:
+		return ApiError{Err: "unknown action: " + string(req.Action), Status: http.StatusBadRequest}
+	}
+
+	rule.Name, rule.Trigger, rule.Action = req.Name, req.Trigger, req.Action
+	rule.MinAmount, rule.ReferenceContains, rule.Threshold = minAmount, req.ReferenceContains, threshold
+	rule.TargetAccount, rule.TargetPublicID = 0, ""
+	if target != nil {
+		rule.TargetAccount, rule.TargetPublicID = target.ID, target.PublicID
+	}
+	rule.Percent, rule.Amount, rule.Message = req.Percent, amount, req.Message
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+	return nil
+}
+
+func (s *APIServer) HandleAutomationRules(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		rules, err := s.storage.GetAutomationRules(id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, rules)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(AutomationRuleRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	rule := &AutomationRule{AccountID: id, CreatedAt: time.Now().UTC()}
+	if err := s.applyAutomationRequest(rule, req, accountFromContext(r)); err != nil {
+		return err
+	}
+	if err := s.storage.CreateAutomationRule(rule); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, rule)
+}
+
+func (s *APIServer) HandleAutomationRule(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	ruleID, err := getIntVar(r, "ruleID")
+	if err != nil {
+		return err
+	}
+
+	rule, err := s.storage.GetAutomationRule(id, ruleID)
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return writeJSON(w, http.StatusOK, rule)
+	case http.MethodPut:
+		req := new(AutomationRuleRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return invalidRequest
+		}
+		defer r.Body.Close()
+
+		if err := s.applyAutomationRequest(rule, req, accountFromContext(r)); err != nil {
+			return err
+		}
+		if err := s.storage.UpdateAutomationRule(rule); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, rule)
+	case http.MethodDelete:
+		if err := s.storage.DeleteAutomationRule(id, ruleID); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, map[string]int{"deleted": ruleID})
+	}
+
+	return methodNotAllowed
+}
+
+// HandleAutomationRuns lists the latest runs of a rule, newest first.
+func (s *APIServer) HandleAutomationRuns(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	ruleID, err := getIntVar(r, "ruleID")
+	if err != nil {
+		return err
+	}
+	if _, err := s.storage.GetAutomationRule(id, ruleID); err != nil {
+		return err
+	}
+
+	runs, err := s.storage.GetAutomationRuns(id, ruleID, maxAutomationRuns)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, runs)
+}
+
+// AutomationEngine evaluates automation rules on the postings published on
+// the event bus. It runs off the path of the posting, so a rule moving
+// money never slows down or fails the transfer that triggered it.
+type AutomationEngine struct {
+	storage  Storage
+	notifier Notifier
+}
+
+func NewAutomationEngine(store Storage, notifier Notifier) *AutomationEngine {
+	return &AutomationEngine{storage: store, notifier: notifier}
+}
+
+// handle subscribes the engine to account.posted events.
+func (a *AutomationEngine) handle(e DomainEvent) error {
+	if e.Transfer != nil && e.Amount.IsPositive() {
+		if ref := e.Transfer.Reference; ref == automationTransferReference || ref == sweepTransferReference {
+			return nil
+		}
+	}
+
+	rules, err := a.storage.GetAutomationRules(e.AccountID)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	account, err := a.storage.GetAccountByID(e.AccountID)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if !rule.Enabled || !rule.matches(e, account.Balance) {
+			continue
+		}
+		run := a.execute(rule, e)
+		if err := a.storage.CreateAutomationRun(run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether the posting e fires the rule. balance is the
+// account's balance when the rule is evaluated, which is after the posting.
+func (rule *AutomationRule) matches(e DomainEvent, balance Money) bool {
+	switch rule.Trigger {
+	case TriggerCredit:
+		if !e.Amount.IsPositive() {
+			return false
+		}
+		if rule.MinAmount != nil && (e.Amount.Currency != rule.MinAmount.Currency || e.Amount.Amount < rule.MinAmount.Amount) {
+			return false
+		}
+		if rule.ReferenceContains != "" {
+			reference := e.Description
+			if e.Transfer != nil {
+				reference = e.Transfer.Reference
+			}
+			return strings.Contains(strings.ToLower(reference), strings.ToLower(rule.ReferenceContains))
+		}
+		return true
+	case TriggerBalanceBelow:
+		if !e.Amount.IsNegative() || balance.Currency != rule.Threshold.Currency {
+			return false
+		}
+		before := balance.Amount - e.Amount.Amount
+		return balance.Amount < rule.Threshold.Amount && before >= rule.Threshold.Amount
+	}
+	return false
+}
+
+func (a *AutomationEngine) execute(rule *AutomationRule, e DomainEvent) *AutomationRun {
+	run := &AutomationRun{
+		RuleID:    rule.ID,
+		AccountID: rule.AccountID,
+		Posting:   fmt.Sprintf("%s %s", e.Amount, e.Description),
+		Status:    AutomationExecuted,
+		CreatedAt: time.Now().UTC(),
+	}
+	fail := func(detail string) *AutomationRun {
+		run.Status, run.Detail = AutomationFailed, detail
+		return run
+	}
+
+	if rule.Action == ActionNotify {
+		if err := a.notifier.Notify(NewNotification(rule.AccountID, NotifyAutomation, rule.Message)); err != nil {
+			return fail(err.Error())
+		}
+		return run
+	}
+
+	amount := NewMoney(0, e.Amount.Currency)
+	switch rule.Action {
+	case ActionMovePercent:
+		amount.Amount = e.Amount.Amount * int64(rule.Percent) / 100
+	case ActionMoveAmount:
+		amount = *rule.Amount
+	}
+	if !amount.IsPositive() {
+		return fail("nothing to move")
+	}
+
+	t := NewTransfer(rule.AccountID, rule.TargetAccount, amount)
+	t.Reference = automationTransferReference
+	if err := a.storage.CreateTransfer(t); err != nil {
+		return fail(err.Error())
+	}
+	run.TransferID = t.PublicID
+	if err := a.storage.ExecuteTransfer(t); err != nil {
+		return fail(err.Error())
+	}
+	if t.Status != TransferSettled {
+		return fail(t.FailureReason)
+	}
+	run.Detail = fmt.Sprintf("moved %s to account %s", amount, t.ToPublicID)
+	return run
+}
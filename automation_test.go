@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutomationMovesPercentOfSalary(t *testing.T) {
+	store := NewMemoryStorage()
+	engine := NewAutomationEngine(store, &recordingNotifier{})
+
+	checking := createTestAccount(t, store, 1000)
+	savings := createTestAccount(t, store, 0)
+	minAmount := NewMoney(500, defaultCurrency)
+	rule := &AutomationRule{
+		AccountID:         checking.ID,
+		Trigger:           TriggerCredit,
+		MinAmount:         &minAmount,
+		ReferenceContains: "salary",
+		Action:            ActionMovePercent,
+		TargetAccount:     savings.ID,
+		Percent:           20,
+		Enabled:           true,
+	}
+	assert.Nil(t, store.CreateAutomationRule(rule))
+
+	salary := &Transfer{Reference: "ACME SALARY"}
+	assert.Nil(t, engine.handle(DomainEvent{Kind: EventAccountPosted, AccountID: checking.ID, Transfer: salary, Amount: NewMoney(999, defaultCurrency)}))
+	assert.Equal(t, int64(801), balanceOf(t, store, checking.ID))
+	assert.Equal(t, int64(199), balanceOf(t, store, savings.ID))
+
+	// Too small, another reference and automation's own credits don't fire.
+	assert.Nil(t, engine.handle(DomainEvent{Kind: EventAccountPosted, AccountID: checking.ID, Transfer: salary, Amount: NewMoney(100, defaultCurrency)}))
+	assert.Nil(t, engine.handle(DomainEvent{Kind: EventAccountPosted, AccountID: checking.ID, Transfer: &Transfer{Reference: "rent"}, Amount: NewMoney(999, defaultCurrency)}))
+	own := &Transfer{Reference: automationTransferReference}
+	assert.Nil(t, engine.handle(DomainEvent{Kind: EventAccountPosted, AccountID: checking.ID, Transfer: own, Amount: NewMoney(999, defaultCurrency)}))
+
+	runs, err := store.GetAutomationRuns(checking.ID, rule.ID, maxAutomationRuns)
+	assert.Nil(t, err)
+	assert.Len(t, runs, 1)
+	assert.Equal(t, AutomationExecuted, runs[0].Status)
+	assert.NotEmpty(t, runs[0].TransferID)
+}
+
+func TestAutomationNotifiesWhenBalanceFallsBelow(t *testing.T) {
+	store := NewMemoryStorage()
+	notifier := &recordingNotifier{}
+	engine := NewAutomationEngine(store, notifier)
+
+	acc := createTestAccount(t, store, 40)
+	threshold := NewMoney(50, defaultCurrency)
+	rule := &AutomationRule{AccountID: acc.ID, Trigger: TriggerBalanceBelow, Threshold: &threshold, Action: ActionNotify,
+		Message: "Balance is low", Enabled: true}
+	assert.Nil(t, store.CreateAutomationRule(rule))
+
+	// The balance went from 60 to 40: it crossed the threshold.
+	assert.Nil(t, engine.handle(DomainEvent{Kind: EventAccountPosted, AccountID: acc.ID, Amount: NewMoney(-20, defaultCurrency)}))
+	// From 45 to 40 it was already below.
+	assert.Nil(t, engine.handle(DomainEvent{Kind: EventAccountPosted, AccountID: acc.ID, Amount: NewMoney(-5, defaultCurrency)}))
+
+	assert.Len(t, notifier.sent, 1)
+	assert.Equal(t, NotifyAutomation, notifier.sent[0].Kind)
+	assert.Equal(t, "Balance is low", notifier.sent[0].Message)
+
+	rule.Enabled = false
+	assert.Nil(t, store.UpdateAutomationRule(rule))
+	assert.Nil(t, engine.handle(DomainEvent{Kind: EventAccountPosted, AccountID: acc.ID, Amount: NewMoney(-20, defaultCurrency)}))
+	assert.Len(t, notifier.sent, 1)
+}
+
+func TestAutomationRuleTargetsPublicID(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	checking := createTestAccount(t, store, 0)
+	savings := createTestAccount(t, store, 0)
+	token, err := createJWT(checking)
+	assert.Nil(t, err)
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/account/"+checking.PublicID+"/automations", strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := create(`{"name":"save","trigger":"credit","action":"move_percent","percent":10,"targetAccountId":"` + savings.PublicID + `"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var body map[string]any
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, savings.PublicID, body["targetAccountId"])
+	assert.NotContains(t, body, "targetAccount")
+
+	rec = create(`{"name":"save","trigger":"credit","action":"move_percent","percent":10,"targetAccountId":"` + NewULID() + `"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// A notify rule has no target.
+	rec = create(`{"name":"ping","trigger":"credit","action":"notify","message":"money in"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "targetAccountId")
+}
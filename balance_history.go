@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// BalanceEntry is an account's balance right after it changed. Storage
+// journals one for every change, including the opening balance, so the
+// balance at any time since is the last entry recorded by then.
+type BalanceEntry struct {
+	AccountID  int       `json:"-"`
+	Balance    Money     `json:"balance"`
+	RecordedAt time.Time `json:"recordedAt"`
+}
+
+// HistoricalBalance answers GET /account/{id}/balance. AsOf is when the
+// balance last changed before At.
+type HistoricalBalance struct {
+	AccountID int       `json:"-"`
+	At        time.Time `json:"at"`
+	Balance   Money     `json:"balance"`
+	AsOf      time.Time `json:"asOf"`
+}
+
+// HandleGetBalance returns the account's balance as of ?at=, an RFC 3339
+// time, or now without it. Auditors use it to check statements against
+// the ledger. Accounts opened before balances were journaled have no
+// history before the journal started.
+func (s *APIServer) HandleGetBalance(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	at := time.Now().UTC()
+	if v := r.URL.Query().Get("at"); v != "" {
+		if at, err = time.Parse(time.RFC3339, v); err != nil {
+			return ApiError{Err: "invalid time: " + v, Status: http.StatusBadRequest}
+		}
+		at = at.UTC()
+	}
+
+	entry, err := s.storageFor(r).GetBalanceAt(id, at)
+	if errors.Is(err, ErrBalanceHistoryNotFound) {
+		return ApiError{Err: fmt.Sprintf("no balance recorded at or before %s", at.Format(time.RFC3339)), Status: http.StatusNotFound}
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, HistoricalBalance{AccountID: id, At: at, Balance: entry.Balance, AsOf: entry.RecordedAt})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBalanceAt(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	beforeOpening := time.Now().UTC()
+	time.Sleep(time.Millisecond)
+
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+	token, err := createJWT(from)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond)
+	opened := time.Now().UTC()
+	time.Sleep(time.Millisecond)
+
+	transfer := NewTransfer(from.ID, to.ID, NewMoney(300, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(transfer))
+	assert.Nil(t, store.ExecuteTransfer(transfer))
+	assert.Equal(t, TransferSettled, transfer.Status)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/account/"+from.PublicID+"/balance"+query, nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	balanceAt := func(at time.Time) int64 {
+		rec := get("?at=" + at.Format(time.RFC3339Nano))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		resp := new(HistoricalBalance)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(resp))
+		return resp.Balance.Amount
+	}
+
+	assert.Equal(t, int64(1000), balanceAt(opened))
+	assert.Equal(t, int64(700), balanceAt(time.Now().UTC()))
+
+	rec := get("")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"amount":700`)
+
+	assert.Equal(t, http.StatusNotFound, get("?at="+beforeOpening.Format(time.RFC3339Nano)).Code)
+	assert.Equal(t, http.StatusBadRequest, get("?at=yesterday").Code)
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type BillPaymentStatus string
+
+// A bill payment is scheduled, debited from the account and sent to the
+// biller rail, which eventually confirms or rejects it. Rejected payments
+// are refunded. An occurrence of a standing order (a recurring payment) can
+// also be paused until resumed, or skipped in favour of the next one.
+const (
+	BillPaymentScheduled BillPaymentStatus = "scheduled"
+	BillPaymentPaused    BillPaymentStatus = "paused"
+	BillPaymentSkipped   BillPaymentStatus = "skipped"
+	BillPaymentSent      BillPaymentStatus = "sent"
+	BillPaymentConfirmed BillPaymentStatus = "confirmed"
+	BillPaymentFailed    BillPaymentStatus = "failed"
+	BillPaymentCancelled BillPaymentStatus = "cancelled"
+)
+
+type StandingOrderAction string
+
+const (
+	StandingOrderPause  StandingOrderAction = "pause"
+	StandingOrderResume StandingOrderAction = "resume"
+	StandingOrderSkip   StandingOrderAction = "skip"
+)
+
+type Recurrence string
+
+const (
+	RecurrenceNone    Recurrence = ""
+	RecurrenceWeekly  Recurrence = "weekly"
+	RecurrenceMonthly Recurrence = "monthly"
+)
+
+const (
+	NotifyBillPaymentSent      NotificationKind = "bill_payment.sent"
+	NotifyBillPaymentConfirmed NotificationKind = "bill_payment.confirmed"
+	NotifyBillPaymentFailed    NotificationKind = "bill_payment.failed"
+)
+
+const billPayPollRate = 5 * time.Second
+
+const billPaymentRejectedReason = "rejected by biller"
+
+// Payee is a biller the customer pays, e.g. a utility, identified at the
+// biller by the customer's reference number.
+type Payee struct {
+	ID         int       `json:"id"`
+	AccountID  int       `json:"-"`
+	BillerName string    `json:"billerName"`
+	Reference  string    `json:"reference"`
+	Nickname   string    `json:"nickname"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type CreatePayeeRequest struct {
+	BillerName string `json:"billerName"`
+	Reference  string `json:"reference"`
+	Nickname   string `json:"nickname"`
+}
+
+// BillPayment is a single occurrence of a payment to a payee. Executing a
+// recurring occurrence schedules the next one.
+type BillPayment struct {
+	ID            int               `json:"id"`
+	PublicID      string            `json:"publicId"`
+	AccountID     int               `json:"-"`
+	PayeeID       int               `json:"payeeId"`
+	Amount        Money             `json:"amount"`
+	Recurrence    Recurrence        `json:"recurrence,omitempty"`
+	ScheduledFor  time.Time         `json:"scheduledFor"`
+	Status        BillPaymentStatus `json:"status"`
+	FailureReason string            `json:"failureReason,omitempty"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	UpdatedAt     time.Time         `json:"updatedAt"`
+}
+
+type CreateBillPaymentRequest struct {
+	PayeeID      int        `json:"payeeId"`
+	Amount       Money      `json:"amount"`
+	Recurrence   Recurrence `json:"recurrence"`
+	ScheduledFor time.Time  `json:"scheduledFor"`
+}
+
+// after returns the occurrence following t.
+func (r Recurrence) after(t time.Time) time.Time {
+	switch r {
+	case RecurrenceWeekly:
+		return t.AddDate(0, 0, 7)
+	case RecurrenceMonthly:
+		return t.AddDate(0, 1, 0)
+	}
+	return t
+}
+
+func (p *BillPayment) nextOccurrence() *BillPayment {
+	if p.Recurrence == RecurrenceNone {
+		return nil
+	}
+	next := p.Recurrence.after(p.ScheduledFor)
+
+	now := time.Now().UTC()
+	return &BillPayment{
+		PublicID:     NewULID(),
+		AccountID:    p.AccountID,
+		PayeeID:      p.PayeeID,
+		Amount:       p.Amount,
+		Recurrence:   p.Recurrence,
+		ScheduledFor: next,
+		Status:       BillPaymentScheduled,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+}
+
+// applyStandingOrderAction pauses, resumes or skips the occurrence p of a
+// standing order. Skipping returns the occurrence scheduled in its place.
+// A resumed order picks up at its first occurrence after now; the ones
+// missed while paused are not made up for.
+func (p *BillPayment) applyStandingOrderAction(action StandingOrderAction, now time.Time) (*BillPayment, error) {
+	if p.Recurrence == RecurrenceNone {
+		return nil, fmt.Errorf("%w: bill payment %d is not a standing order", ErrStateConflict, p.ID)
+	}
+
+	var next *BillPayment
+	switch {
+	case action == StandingOrderPause && p.Status == BillPaymentScheduled:
+		p.Status = BillPaymentPaused
+	case action == StandingOrderResume && p.Status == BillPaymentPaused:
+		for p.ScheduledFor.Before(now) {
+			p.ScheduledFor = p.Recurrence.after(p.ScheduledFor)
+		}
+		p.Status = BillPaymentScheduled
+	case action == StandingOrderSkip && p.Status == BillPaymentScheduled:
+		p.Status = BillPaymentSkipped
+		next = p.nextOccurrence()
+	default:
+		return nil, fmt.Errorf("%w: cannot %s bill payment %d while it is %s", ErrStateConflict, action, p.ID, p.Status)
+	}
+
+	p.UpdatedAt = now
+	return next, nil
+}
+
+func (s *APIServer) HandlePayees(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		payees, err := s.storage.GetPayees(id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, payees)
+	}
+
+	if r.Method == http.MethodPost {
+		req := new(CreatePayeeRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return invalidRequest
+		}
+		defer r.Body.Close()
+
+		if strings.TrimSpace(req.BillerName) == "" || strings.TrimSpace(req.Reference) == "" {
+			return ApiError{Err: "billerName and reference are required", Status: http.StatusBadRequest}
+		}
+
+		payee := &Payee{
+			AccountID:  id,
+			BillerName: req.BillerName,
+			Reference:  req.Reference,
+			Nickname:   req.Nickname,
+			CreatedAt:  time.Now().UTC(),
+		}
+		if err := s.storage.CreatePayee(payee); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusCreated, payee)
+	}
+
+	return methodNotAllowed
+}
+
+func (s *APIServer) HandleDeletePayee(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodDelete {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	payeeID, err := getIntVar(r, "payeeID")
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.DeletePayee(id, payeeID); err != nil {
+		return ApiError{Err: "payee not found", Status: http.StatusNotFound}
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]int{"deleted": payeeID})
+}
+
+func (s *APIServer) HandleBillPayments(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		payments, err := s.storage.GetBillPayments(id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, payments)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(CreateBillPaymentRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	payee, err := s.storage.GetPayee(id, req.PayeeID)
+	if err != nil {
+		return ApiError{Err: "payee not found", Status: http.StatusBadRequest}
+	}
+	account := accountFromContext(r)
+	if req.Amount.Currency == "" {
+		req.Amount.Currency = account.Balance.Currency
+	}
+	if !req.Amount.IsPositive() {
+		return ApiError{Err: "amount must be positive", Status: http.StatusBadRequest}
+	}
+	if req.Recurrence != RecurrenceNone && req.Recurrence != RecurrenceWeekly && req.Recurrence != RecurrenceMonthly {
+		return ApiError{Err: "unknown recurrence: " + string(req.Recurrence), Status: http.StatusBadRequest}
+	}
+
+	now := time.Now().UTC()
+	if req.ScheduledFor.IsZero() {
+		req.ScheduledFor = now
+	}
+
+	payment := &BillPayment{
+		PublicID:     NewULID(),
+		AccountID:    id,
+		PayeeID:      payee.ID,
+		Amount:       req.Amount,
+		Recurrence:   req.Recurrence,
+		ScheduledFor: req.ScheduledFor.UTC(),
+		Status:       BillPaymentScheduled,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.storage.CreateBillPayment(payment); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, payment)
+}
+
+// HandleCancelBillPayment cancels a payment that hasn't been sent yet,
+// scheduled or paused, which also ends a recurring series.
+func (s *APIServer) HandleCancelBillPayment(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodDelete {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	paymentID, err := getIntVar(r, "paymentID")
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.CancelBillPayment(id, paymentID); err != nil {
+		return ApiError{Err: "no scheduled bill payment found", Status: http.StatusNotFound}
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]int{"cancelled": paymentID})
+}
+
+func (s *APIServer) HandlePauseBillPayment(w http.ResponseWriter, r *http.Request) error {
+	return s.handleStandingOrderAction(w, r, StandingOrderPause)
+}
+
+func (s *APIServer) HandleResumeBillPayment(w http.ResponseWriter, r *http.Request) error {
+	return s.handleStandingOrderAction(w, r, StandingOrderResume)
+}
+
+// HandleSkipBillPayment skips the next occurrence of a standing order. The
+// skipped occurrence stays listed with status skipped.
+func (s *APIServer) HandleSkipBillPayment(w http.ResponseWriter, r *http.Request) error {
+	return s.handleStandingOrderAction(w, r, StandingOrderSkip)
+}
+
+func (s *APIServer) handleStandingOrderAction(w http.ResponseWriter, r *http.Request, action StandingOrderAction) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	paymentID, err := getIntVar(r, "paymentID")
+	if err != nil {
+		return err
+	}
+
+	payment, err := s.storage.UpdateStandingOrder(id, paymentID, action, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, payment)
+}
+
+// BillPayScheduler sends due bill payments and plays the biller rail,
+// which confirms sent payments after ConfirmDelay. References starting
+// with "FAIL" are rejected by the mock rail so clients can test refunds.
+// Neither happens outside business days; payments due on one go out on
+// the next business day.
+type BillPayScheduler struct {
+	storage      Storage
+	notifier     Notifier
+	calendar     BusinessCalendar
+	ConfirmDelay time.Duration
+}
+
+func NewBillPayScheduler(store Storage, notifier Notifier) *BillPayScheduler {
+	return &BillPayScheduler{
+		storage:      store,
+		notifier:     notifier,
+		calendar:     BusinessCalendar{storage: store},
+		ConfirmDelay: getEnvDuration("BILLPAY_CONFIRM_DELAY", 30*time.Second),
+	}
+}
+
+func (b *BillPayScheduler) Run() {
+	ticker := time.NewTicker(billPayPollRate)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		b.sendDue()
+		b.settleSent()
+	}
+}
+
+func (b *BillPayScheduler) sendDue() {
+	now := time.Now().UTC()
+	if isWeekend(now) {
+		return
+	}
+	closed, err := b.calendar.closedCurrencies(now)
+	if err != nil {
+		log.Println("Failed to load holidays: ", err)
+		return
+	}
+
+	for {
+		payment, err := b.storage.SendDueBillPayment(now, closed)
+		if err != nil {
+			log.Println("Failed to send bill payment: ", err)
+			return
+		}
+		if payment == nil {
+			return
+		}
+
+		if payment.Status == BillPaymentFailed {
+			b.notify(payment, NotifyBillPaymentFailed, "could not be sent: "+payment.FailureReason)
+		} else {
+			b.notify(payment, NotifyBillPaymentSent, "was sent to the biller")
+		}
+	}
+}
+
+func (b *BillPayScheduler) settleSent() {
+	payments, err := b.storage.GetBillPaymentsByStatus(BillPaymentSent, time.Now().UTC().Add(-b.ConfirmDelay))
+	if err != nil {
+		log.Println("Failed to load sent bill payments: ", err)
+		return
+	}
+
+	for _, payment := range payments {
+		if open, err := b.calendar.IsBusinessDay(payment.Amount.Currency, time.Now().UTC()); err != nil || !open {
+			continue
+		}
+
+		// A payee deleted after the payment was sent doesn't matter to the
+		// biller anymore; only the mock rejection is simulated.
+		accepted := true
+		if payee, err := b.storage.GetPayee(payment.AccountID, payment.PayeeID); err == nil {
+			accepted = !strings.HasPrefix(strings.ToUpper(payee.Reference), "FAIL")
+		}
+
+		var err error
+
+		if accepted {
+			err = b.storage.ConfirmBillPayment(payment)
+		} else {
+			err = b.storage.RefundBillPayment(payment, billPaymentRejectedReason)
+		}
+		if err != nil {
+			log.Printf("Failed to settle bill payment %d: %v\n", payment.ID, err)
+			continue
+		}
+
+		if accepted {
+			b.notify(payment, NotifyBillPaymentConfirmed, "was confirmed by the biller")
+		} else {
+			b.notify(payment, NotifyBillPaymentFailed, "was rejected by the biller and refunded")
+		}
+	}
+}
+
+func (b *BillPayScheduler) notify(p *BillPayment, kind NotificationKind, what string) {
+	msg := fmt.Sprintf("Bill payment %s of %s %s.", p.PublicID, p.Amount, what)
+	if err := b.notifier.Notify(NewNotification(p.AccountID, kind, msg)); err != nil {
+		log.Println("Failed to send bill payment notification: ", err)
+	}
+}
+
+// debitBillPayment returns the balance after paying amount out of it, or
+// the reason the payment has to fail.
+func debitBillPayment(balance, amount Money) (Money, string) {
+	if balance.Currency != amount.Currency {
+		return balance, "currency mismatch"
+	}
+
+	newBalance, err := balance.Sub(amount)
+	if err != nil {
+		return balance, err.Error()
+	}
+	if newBalance.IsNegative() {
+		return balance, "insufficient funds"
+	}
+	return newBalance, ""
+}
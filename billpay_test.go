@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBillPaymentRecurrence(t *testing.T) {
+	jan31 := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+
+	p := &BillPayment{Recurrence: RecurrenceWeekly, ScheduledFor: jan31}
+	assert.Equal(t, jan31.AddDate(0, 0, 7), p.nextOccurrence().ScheduledFor)
+
+	p.Recurrence = RecurrenceMonthly
+	next := p.nextOccurrence()
+	assert.Equal(t, jan31.AddDate(0, 1, 0), next.ScheduledFor)
+	assert.Equal(t, BillPaymentScheduled, next.Status)
+
+	p.Recurrence = RecurrenceNone
+	assert.Nil(t, p.nextOccurrence())
+}
+
+func TestSendDueBillPayment(t *testing.T) {
+	store := NewMemoryStorage()
+	acc := createTestAccount(t, store, 1000)
+
+	payee := &Payee{AccountID: acc.ID, BillerName: "City Water", Reference: "W-1"}
+	assert.Nil(t, store.CreatePayee(payee))
+
+	now := time.Now().UTC()
+	payment := &BillPayment{
+		PublicID:     NewULID(),
+		AccountID:    acc.ID,
+		PayeeID:      payee.ID,
+		Amount:       NewMoney(600, defaultCurrency),
+		Recurrence:   RecurrenceMonthly,
+		ScheduledFor: now.Add(-time.Minute),
+		Status:       BillPaymentScheduled,
+	}
+	assert.Nil(t, store.CreateBillPayment(payment))
+
+	sent, err := store.SendDueBillPayment(now, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, BillPaymentSent, sent.Status)
+	assert.Equal(t, int64(400), balanceOf(t, store, acc.ID))
+
+	// The next occurrence isn't due yet.
+	sent, err = store.SendDueBillPayment(now, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, sent)
+
+	// A month later the account can't cover it anymore.
+	failed, err := store.SendDueBillPayment(now.AddDate(0, 1, 0), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, BillPaymentFailed, failed.Status)
+	assert.Equal(t, "insufficient funds", failed.FailureReason)
+	assert.Equal(t, int64(400), balanceOf(t, store, acc.ID))
+
+	assert.Nil(t, store.RefundBillPayment(payment, "rejected by biller"))
+	assert.Equal(t, int64(1000), balanceOf(t, store, acc.ID))
+	assert.NotNil(t, store.RefundBillPayment(payment, "rejected by biller"))
+}
+
+func TestStandingOrderPauseResumeSkip(t *testing.T) {
+	store := NewMemoryStorage()
+	acc := createTestAccount(t, store, 1000)
+
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	payment := &BillPayment{
+		PublicID:     NewULID(),
+		AccountID:    acc.ID,
+		Amount:       NewMoney(100, defaultCurrency),
+		Recurrence:   RecurrenceWeekly,
+		ScheduledFor: now.AddDate(0, 0, 1),
+		Status:       BillPaymentScheduled,
+	}
+	assert.Nil(t, store.CreateBillPayment(payment))
+
+	paused, err := store.UpdateStandingOrder(acc.ID, payment.ID, StandingOrderPause, now)
+	assert.Nil(t, err)
+	assert.Equal(t, BillPaymentPaused, paused.Status)
+
+	// Paused occurrences aren't sent, even once due.
+	sent, err := store.SendDueBillPayment(now.AddDate(0, 0, 2), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, sent)
+
+	// Resuming three weeks later picks up at the next occurrence from then.
+	later := now.AddDate(0, 0, 21)
+	resumed, err := store.UpdateStandingOrder(acc.ID, payment.ID, StandingOrderResume, later)
+	assert.Nil(t, err)
+	assert.Equal(t, BillPaymentScheduled, resumed.Status)
+	assert.Equal(t, now.AddDate(0, 0, 22), resumed.ScheduledFor)
+
+	skipped, err := store.UpdateStandingOrder(acc.ID, payment.ID, StandingOrderSkip, later)
+	assert.Nil(t, err)
+	assert.Equal(t, BillPaymentSkipped, skipped.Status)
+
+	payments, err := store.GetBillPayments(acc.ID)
+	assert.Nil(t, err)
+	assert.Len(t, payments, 2)
+	assert.Equal(t, BillPaymentScheduled, payments[0].Status)
+	assert.Equal(t, now.AddDate(0, 0, 29), payments[0].ScheduledFor)
+
+	_, err = store.UpdateStandingOrder(acc.ID, payment.ID, StandingOrderResume, later)
+	assert.ErrorIs(t, err, ErrStateConflict)
+}
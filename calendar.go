@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Holiday is a day no settlement happens in Currency, on top of weekends.
+// Date is a calendar day in UTC, like the rest of the scheduling.
+type Holiday struct {
+	ID        int       `json:"id"`
+	Currency  string    `json:"currency"`
+	Date      string    `json:"date"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type HolidayRequest struct {
+	Currency string `json:"currency"`
+	Date     string `json:"date"`
+	Name     string `json:"name"`
+}
+
+// maxHolidayRun bounds how far Roll looks ahead, so a calendar that closes
+// every day can't hang a worker.
+const maxHolidayRun = 31
+
+// BusinessCalendar knows which days settlement happens on per currency:
+// weekdays that aren't holidays. Bill payments are sent and confirmed, and
+// cheques clear, only on business days; internal transfers settle at once
+// regardless.
+type BusinessCalendar struct {
+	storage Storage
+}
+
+func (c BusinessCalendar) holidays(currency string) (map[string]bool, error) {
+	holidays, err := c.storage.GetHolidays(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	days := map[string]bool{}
+	for _, h := range holidays {
+		days[h.Date] = true
+	}
+	return days, nil
+}
+
+func isWeekend(t time.Time) bool {
+	return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+}
+
+func (c BusinessCalendar) IsBusinessDay(currency string, t time.Time) (bool, error) {
+	if isWeekend(t.UTC()) {
+		return false, nil
+	}
+	holidays, err := c.holidays(currency)
+	if err != nil {
+		return false, err
+	}
+	return !holidays[t.UTC().Format(dateLayout)], nil
+}
+
+// Roll moves t forward by whole days until it falls on a business day in
+// currency, keeping the time of day. A t on a business day is returned as
+// is.
+func (c BusinessCalendar) Roll(currency string, t time.Time) (time.Time, error) {
+	holidays, err := c.holidays(currency)
+	if err != nil {
+		return t, err
+	}
+
+	t = t.UTC()
+	for i := 0; i < maxHolidayRun && (isWeekend(t) || holidays[t.Format(dateLayout)]); i++ {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// closedCurrencies returns the currencies with a holiday on the day of now.
+func (c BusinessCalendar) closedCurrencies(now time.Time) ([]string, error) {
+	holidays, err := c.storage.GetHolidays("")
+	if err != nil {
+		return nil, err
+	}
+
+	today := now.UTC().Format(dateLayout)
+	closed := []string{}
+	for _, h := range holidays {
+		if h.Date == today {
+			closed = append(closed, h.Currency)
+		}
+	}
+	return closed, nil
+}
+
+func (s *APIServer) HandleAdminHolidays(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodGet {
+		holidays, err := s.storage.GetHolidays(strings.ToUpper(r.URL.Query().Get("currency")))
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, holidays)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(HolidayRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	holiday := &Holiday{
+		Currency:  strings.ToUpper(strings.TrimSpace(req.Currency)),
+		Date:      req.Date,
+		Name:      strings.TrimSpace(req.Name),
+		CreatedAt: time.Now().UTC(),
+	}
+	if len(holiday.Currency) != 3 {
+		return ApiError{Err: "currency must be a three-letter code", Status: http.StatusBadRequest}
+	}
+	if _, err := time.Parse(dateLayout, holiday.Date); err != nil {
+		return ApiError{Err: "date must be formatted as " + dateLayout, Status: http.StatusBadRequest}
+	}
+	if holiday.Name == "" {
+		return ApiError{Err: "name is required", Status: http.StatusBadRequest}
+	}
+
+	if err := s.storage.CreateHoliday(holiday); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, holiday)
+}
+
+func (s *APIServer) HandleAdminDeleteHoliday(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodDelete {
+		return methodNotAllowed
+	}
+
+	holidayID, err := getIntVar(r, "holidayID")
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.DeleteHoliday(holidayID); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]int{"deleted": holidayID})
+}
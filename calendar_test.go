@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusinessCalendarRollsPastWeekendsAndHolidays(t *testing.T) {
+	store := NewMemoryStorage()
+	calendar := BusinessCalendar{storage: store}
+	assert.Nil(t, store.CreateHoliday(&Holiday{Currency: "USD", Date: "2026-12-28", Name: "Boxing Day (observed)"}))
+	assert.ErrorIs(t, store.CreateHoliday(&Holiday{Currency: "USD", Date: "2026-12-28", Name: "again"}), ErrDuplicateHoliday)
+
+	// Saturday the 26th rolls past the weekend and Monday's holiday.
+	saturday := time.Date(2026, 12, 26, 9, 30, 0, 0, time.UTC)
+	rolled, err := calendar.Roll("USD", saturday)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2026, 12, 29, 9, 30, 0, 0, time.UTC), rolled)
+
+	// The holiday is USD's only.
+	rolled, err = calendar.Roll("EUR", saturday)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2026, 12, 28, 9, 30, 0, 0, time.UTC), rolled)
+
+	monday := time.Date(2026, 12, 28, 12, 0, 0, 0, time.UTC)
+	open, err := calendar.IsBusinessDay("USD", monday)
+	assert.Nil(t, err)
+	assert.False(t, open)
+	closed, err := calendar.closedCurrencies(monday)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"USD"}, closed)
+}
+
+func TestBillPaymentsWaitOutHolidays(t *testing.T) {
+	store := NewMemoryStorage()
+	acc := createTestAccount(t, store, 1000)
+	payee := &Payee{AccountID: acc.ID, BillerName: "City Water", Reference: "W-1"}
+	assert.Nil(t, store.CreatePayee(payee))
+
+	holiday := time.Date(2026, 12, 28, 12, 0, 0, 0, time.UTC)
+	payment := &BillPayment{
+		PublicID:     NewULID(),
+		AccountID:    acc.ID,
+		PayeeID:      payee.ID,
+		Amount:       NewMoney(600, defaultCurrency),
+		ScheduledFor: holiday.Add(-time.Hour),
+		Status:       BillPaymentScheduled,
+	}
+	assert.Nil(t, store.CreateBillPayment(payment))
+
+	sent, err := store.SendDueBillPayment(holiday, []string{defaultCurrency})
+	assert.Nil(t, err)
+	assert.Nil(t, sent)
+
+	sent, err = store.SendDueBillPayment(holiday.AddDate(0, 0, 1), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, BillPaymentSent, sent.Status)
+}
+
+func TestAdminHolidays(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+	router := NewAPIServer(":0", NewMemoryStorage()).Router()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-admin-token", "test-admin")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/admin/holidays", `{"currency":"usd","date":"2026-07-03","name":"Independence Day (observed)"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"currency":"USD"`)
+
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/admin/holidays", `{"currency":"USD","date":"2026-07-03","name":"x"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/admin/holidays", `{"currency":"USD","date":"07/03/2026","name":"x"}`).Code)
+
+	assert.Contains(t, do(http.MethodGet, "/admin/holidays?currency=USD", "").Body.String(), `"date":"2026-07-03"`)
+	assert.Equal(t, "[]\n", do(http.MethodGet, "/admin/holidays?currency=EUR", "").Body.String())
+}
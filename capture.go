@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxCapturedBody = 64 << 10
+	redacted        = "[REDACTED]"
+)
+
+// Headers and JSON fields that never make it into a capture.
+var (
+	sensitiveHeaders = map[string]bool{
+		"Authorization": true,
+		"Cookie":        true,
+		"Set-Cookie":    true,
+		"X-Jwt-Token":   true,
+		"X-Admin-Token": true,
+	}
+	sensitiveFields = map[string]bool{
+		"password":    true,
+		"token":       true,
+		"deviceToken": true,
+		"code":        true,
+		"firstName":   true,
+		"lastName":    true,
+	}
+)
+
+// CapturedExchange is a sanitized request/response pair recorded for
+// debugging.
+type CapturedExchange struct {
+	ID              int                 `json:"id"`
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	Query           string              `json:"query"`
+	RequestHeaders  map[string][]string `json:"requestHeaders"`
+	RequestBody     string              `json:"requestBody"`
+	Status          int                 `json:"status"`
+	ResponseHeaders map[string][]string `json:"responseHeaders"`
+	ResponseBody    string              `json:"responseBody"`
+	DurationMs      int64               `json:"durationMs"`
+	CreatedAt       time.Time           `json:"createdAt"`
+}
+
+type ReplayRequest struct {
+	// Headers are added to the replayed request, typically credentials
+	// for the target environment since captured ones are redacted.
+	Headers map[string]string `json:"headers"`
+}
+
+type ReplayResponse struct {
+	Target        string `json:"target"`
+	Status        int    `json:"status"`
+	Body          string `json:"body"`
+	StatusDiffers bool   `json:"statusDiffers"`
+	BodyDiffers   bool   `json:"bodyDiffers"`
+}
+
+type CaptureConfig struct {
+	Enabled      bool
+	SampleRate   float64
+	ReplayTarget string
+}
+
+func captureConfigFromEnv() CaptureConfig {
+	return CaptureConfig{
+		Enabled:      getEnvBool("CAPTURE_ENABLED", false),
+		SampleRate:   getEnvFloat("CAPTURE_SAMPLE_RATE", 0.01),
+		ReplayTarget: strings.TrimSuffix(getEnv("REPLAY_TARGET_URL", ""), "/"),
+	}
+}
+
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *captureWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if room := maxCapturedBody - w.body.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		w.body.Write(b[:room])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// captureMiddleware records a sample of customer-facing traffic. Admin and
+// debug endpoints are never captured.
+func (s *APIServer) captureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.capture.Enabled || rand.Float64() >= s.capture.SampleRate ||
+			strings.HasPrefix(r.URL.Path, "/admin") || strings.HasPrefix(r.URL.Path, "/debug") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reqBody, _ := io.ReadAll(io.LimitReader(r.Body, maxCapturedBody))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(reqBody), r.Body))
+
+		start := time.Now()
+		cw := &captureWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+
+		exchange := &CapturedExchange{
+			Method:          r.Method,
+			Path:            r.URL.Path,
+			Query:           r.URL.RawQuery,
+			RequestHeaders:  redactHeaders(r.Header),
+			RequestBody:     redactBody(reqBody),
+			Status:          cw.status,
+			ResponseHeaders: redactHeaders(cw.Header()),
+			ResponseBody:    redactBody(cw.body.Bytes()),
+			DurationMs:      time.Since(start).Milliseconds(),
+			CreatedAt:       start.UTC(),
+		}
+		if err := s.storage.CreateCapture(exchange); err != nil {
+			log.Println("Failed to store captured request: ", err)
+		}
+	})
+}
+
+func redactHeaders(h http.Header) map[string][]string {
+	out := map[string][]string{}
+	for k, v := range h {
+		if sensitiveHeaders[http.CanonicalHeaderKey(k)] {
+			out[k] = []string{redacted}
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// redactBody blanks out sensitive fields of JSON bodies. Anything that
+// isn't JSON is dropped altogether since we can't tell what it contains.
+func redactBody(body []byte) string {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return ""
+	}
+
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return redacted
+	}
+
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return redacted
+	}
+	return string(out)
+}
+
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, field := range v {
+			if sensitiveFields[k] {
+				v[k] = redacted
+			} else {
+				v[k] = redactValue(field)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	}
+	return v
+}
+
+func (s *APIServer) HandleAdminGetCaptures(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	limit, err := getPageLimit(r)
+	if err != nil {
+		return err
+	}
+
+	captures, err := s.storage.GetCaptures(limit)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, captures)
+}
+
+func (s *APIServer) HandleAdminGetCapture(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getIntVar(r, "captureID")
+	if err != nil {
+		return err
+	}
+
+	capture, err := s.storage.GetCapture(id)
+	if err != nil {
+		return captureNotFound
+	}
+
+	return writeJSON(w, http.StatusOK, capture)
+}
+
+// HandleAdminReplayCapture sends a captured request to REPLAY_TARGET_URL
+// and reports how the answer compares to the captured one. Replaying is
+// disabled in production and when no target is configured.
+func (s *APIServer) HandleAdminReplayCapture(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+	if isProduction() || s.capture.ReplayTarget == "" {
+		return ApiError{Err: "replay is not available in this environment", Status: http.StatusConflict}
+	}
+
+	id, err := getIntVar(r, "captureID")
+	if err != nil {
+		return err
+	}
+	capture, err := s.storage.GetCapture(id)
+	if err != nil {
+		return captureNotFound
+	}
+
+	req := new(ReplayRequest)
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return invalidRequest
+		}
+		defer r.Body.Close()
+	}
+
+	url := s.capture.ReplayTarget + capture.Path
+	if capture.Query != "" {
+		url += "?" + capture.Query
+	}
+	replay, err := http.NewRequestWithContext(r.Context(), capture.Method, url, strings.NewReader(capture.RequestBody))
+	if err != nil {
+		return err
+	}
+	for k, v := range capture.RequestHeaders {
+		if len(v) > 0 && v[0] != redacted {
+			replay.Header[k] = v
+		}
+	}
+	for k, v := range req.Headers {
+		replay.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(replay)
+	if err != nil {
+		return ApiError{Err: "replay failed: " + err.Error(), Status: http.StatusBadGateway}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCapturedBody))
+	if err != nil {
+		return err
+	}
+	replayed := redactBody(body)
+
+	return writeJSON(w, http.StatusOK, ReplayResponse{
+		Target:        url,
+		Status:        resp.StatusCode,
+		Body:          replayed,
+		StatusDiffers: resp.StatusCode != capture.Status,
+		BodyDiffers:   replayed != capture.ResponseBody,
+	})
+}
+
+var captureNotFound = ApiError{Err: "capture not found", Status: http.StatusNotFound}
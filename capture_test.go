@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactBody(t *testing.T) {
+	body := `{"number":12345678901234567,"password":"hunter2","nested":[{"firstName":"Ann","amount":5}]}`
+
+	assert.JSONEq(t,
+		`{"number":12345678901234567,"password":"[REDACTED]","nested":[{"firstName":"[REDACTED]","amount":5}]}`,
+		redactBody([]byte(body)))
+	assert.Equal(t, redacted, redactBody([]byte("not json")))
+	assert.Equal(t, "", redactBody(nil))
+}
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("x-jwt-token", "secret")
+	h.Set("Accept", "application/json")
+
+	out := redactHeaders(h)
+	assert.Equal(t, []string{redacted}, out["X-Jwt-Token"])
+	assert.Equal(t, []string{"application/json"}, out["Accept"])
+}
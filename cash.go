@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type CashOperationKind string
+
+const (
+	CashDeposit    CashOperationKind = "deposit"
+	CashWithdrawal CashOperationKind = "withdrawal"
+	// Adjustments are manual corrections a teller posts, such as reversing
+	// a miscounted deposit. They move no cash but are booked the same way.
+	CashAdjustmentCredit CashOperationKind = "adjustment_credit"
+	CashAdjustmentDebit  CashOperationKind = "adjustment_debit"
+)
+
+// credit reports whether operations of the kind add to the balance.
+func (k CashOperationKind) credit() bool {
+	return k == CashDeposit || k == CashAdjustmentCredit
+}
+
+type CashOperationStatus string
+
+const (
+	CashCompleted CashOperationStatus = "completed"
+	CashRejected  CashOperationStatus = "rejected"
+)
+
+const (
+	NotifyCashDeposit    NotificationKind = "cash.deposit"
+	NotifyCashWithdrawal NotificationKind = "cash.withdrawal"
+)
+
+// CashOperation is cash paid in or taken out at a teller or ATM terminal.
+type CashOperation struct {
+	ID            int                 `json:"id"`
+	PublicID      string              `json:"publicId"`
+	AccountID     int                 `json:"-"`
+	TerminalID    string              `json:"terminalId"`
+	Kind          CashOperationKind   `json:"kind"`
+	Amount        Money               `json:"amount"`
+	Status        CashOperationStatus `json:"status"`
+	FailureReason string              `json:"failureReason,omitempty"`
+	CreatedAt     time.Time           `json:"createdAt"`
+}
+
+type CashOperationRequest struct {
+	AccountNumber int32 `json:"accountNumber"`
+	Amount        Money `json:"amount"`
+}
+
+type contextTerminalKey struct{}
+
+// terminalTokens parses TERMINAL_TOKENS, a comma separated list of
+// terminalID=token pairs.
+func terminalTokens() map[string]string {
+	return tokenPairs("TERMINAL_TOKENS")
+}
+
+// tokenPairs parses the env variable key, a comma separated list of
+// id=token pairs.
+func tokenPairs(key string) map[string]string {
+	tokens := map[string]string{}
+	for _, pair := range strings.Split(getEnv(key, ""), ",") {
+		id, token, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && id != "" && token != "" {
+			tokens[id] = token
+		}
+	}
+	return tokens
+}
+
+// withTerminalAuth lets through teller and ATM terminals identifying with
+// x-terminal-id and x-terminal-token.
+func withTerminalAuth(apiFunc apiFunc) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		start := time.Now()
+		id := r.Header.Get("x-terminal-id")
+		expected, ok := terminalTokens()[id]
+		token := r.Header.Get("x-terminal-token")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			return permissionDenied
+		}
+		r = withPrincipal(r, &Principal{Role: RoleTerminal, TerminalID: id, Scopes: []string{"cash"}})
+		if err := meterRequest(w, r); err != nil {
+			return err
+		}
+		useConsumerNaming(w, r)
+
+		timeAuth(w, start)
+		return apiFunc(w, r)
+	}
+}
+
+func atmDailyWithdrawalLimit(currency string) Money {
+	return NewMoney(getEnvInt("ATM_DAILY_WITHDRAWAL_LIMIT", 1_000_00), currency)
+}
+
+func (s *APIServer) HandleCashDeposit(w http.ResponseWriter, r *http.Request) error {
+	return s.handleCashOperation(w, r, CashDeposit)
+}
+
+func (s *APIServer) HandleCashWithdrawal(w http.ResponseWriter, r *http.Request) error {
+	return s.handleCashOperation(w, r, CashWithdrawal)
+}
+
+func (s *APIServer) handleCashOperation(w http.ResponseWriter, r *http.Request, kind CashOperationKind) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(CashOperationRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	account, err := s.storage.GetAccountByNumber(req.AccountNumber)
+	if err != nil {
+		return ApiError{Err: "account not found", Status: http.StatusNotFound}
+	}
+	if req.Amount.Currency == "" {
+		req.Amount.Currency = account.Balance.Currency
+	}
+	if !req.Amount.IsPositive() {
+		return ApiError{Err: "amount must be positive", Status: http.StatusBadRequest}
+	}
+
+	if kind == CashWithdrawal {
+		if err := s.checkDebitsAllowed(account.ID, time.Now().UTC()); err != nil {
+			return err
+		}
+	}
+
+	op := &CashOperation{
+		PublicID:   NewULID(),
+		AccountID:  account.ID,
+		TerminalID: r.Header.Get("x-terminal-id"),
+		Kind:       kind,
+		Amount:     req.Amount,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := s.postCashOperation(r.Context(), op, atmDailyWithdrawalLimit(req.Amount.Currency)); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, op)
+}
+
+// postCashOperation executes op under the account lock and tells the
+// customer about cash that went in or out.
+func (s *APIServer) postCashOperation(ctx context.Context, op *CashOperation, dailyLimit Money) error {
+	unlock, err := s.concurrency.LockAccount(ctx, op.AccountID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.storage.ExecuteCashOperation(op, dailyLimit); err != nil {
+		return err
+	}
+
+	if op.Status == CashCompleted && (op.Kind == CashDeposit || op.Kind == CashWithdrawal) {
+		notification := NewNotification(op.AccountID, NotifyCashDeposit,
+			fmt.Sprintf("Cash deposit of %s at %s.", op.Amount, op.TerminalID))
+		if op.Kind == CashWithdrawal {
+			notification = NewNotification(op.AccountID, NotifyCashWithdrawal,
+				fmt.Sprintf("Cash withdrawal of %s at %s.", op.Amount, op.TerminalID))
+		}
+		if err := s.notifier.Notify(notification); err != nil {
+			log.Println("Failed to send cash notification: ", err)
+		}
+	}
+	return nil
+}
+
+// applyCashOperation returns the balance after the operation, or the reason
+// it has to be rejected. withdrawnToday is what the account has already
+// taken out in cash since midnight UTC.
+func applyCashOperation(balance Money, op *CashOperation, withdrawnToday, dailyLimit Money) (Money, string) {
+	if balance.Currency != op.Amount.Currency {
+		return balance, "currency mismatch"
+	}
+
+	if op.Kind.credit() {
+		newBalance, err := balance.Add(op.Amount)
+		if err != nil {
+			return balance, err.Error()
+		}
+		return newBalance, ""
+	}
+
+	if op.Kind == CashWithdrawal {
+		total, err := withdrawnToday.Add(op.Amount)
+		if err != nil {
+			return balance, err.Error()
+		}
+		if cmp, err := total.Cmp(dailyLimit); err != nil || cmp > 0 {
+			return balance, "daily withdrawal limit exceeded"
+		}
+	}
+
+	newBalance, err := balance.Sub(op.Amount)
+	if err != nil {
+		return balance, err.Error()
+	}
+	if newBalance.IsNegative() {
+		return balance, "insufficient funds"
+	}
+	return newBalance, ""
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
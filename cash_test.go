@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type cashTest struct {
+	t      *testing.T
+	store  *MemoryStorage
+	router http.Handler
+}
+
+func newCashTest(t *testing.T) *cashTest {
+	t.Setenv("TERMINAL_TOKENS", "atm-1=atm-secret")
+	t.Setenv("ATM_DAILY_WITHDRAWAL_LIMIT", "1000")
+
+	store := NewMemoryStorage()
+	return &cashTest{t: t, store: store, router: NewAPIServer(":0", store).Router()}
+}
+
+func (c *cashTest) post(kind CashOperationKind, acc *Account, amount int64) *CashOperation {
+	body := `{"accountNumber":` + strconv.Itoa(int(acc.Number)) + `,"amount":{"amount":` + strconv.FormatInt(amount, 10) + `}}`
+	req := httptest.NewRequest(http.MethodPost, "/cash/"+string(kind), strings.NewReader(body))
+	req.Header.Set("x-terminal-id", "atm-1")
+	req.Header.Set("x-terminal-token", "atm-secret")
+	rec := httptest.NewRecorder()
+	c.router.ServeHTTP(rec, req)
+	assert.Equal(c.t, http.StatusOK, rec.Code)
+
+	op := new(CashOperation)
+	assert.Nil(c.t, json.Unmarshal(rec.Body.Bytes(), op))
+	return op
+}
+
+func TestCashWithdrawalDailyLimit(t *testing.T) {
+	c := newCashTest(t)
+
+	for _, tc := range []struct {
+		amount int64
+		status CashOperationStatus
+	}{
+		{999, CashCompleted},
+		{1000, CashCompleted},
+		{1001, CashRejected},
+	} {
+		acc := createTestAccount(t, c.store, 5000)
+		op := c.post(CashWithdrawal, acc, tc.amount)
+		assert.Equal(t, tc.status, op.Status, "withdrawing %d", tc.amount)
+		if tc.status == CashRejected {
+			assert.Equal(t, "daily withdrawal limit exceeded", op.FailureReason)
+			assert.Equal(t, int64(5000), balanceOf(t, c.store, acc.ID))
+		} else {
+			assert.Equal(t, 5000-tc.amount, balanceOf(t, c.store, acc.ID))
+		}
+	}
+}
+
+func TestCashWithdrawalLimitCountsTheDay(t *testing.T) {
+	c := newCashTest(t)
+
+	// Up to the limit in two goes, then nothing more.
+	acc := createTestAccount(t, c.store, 5000)
+	assert.Equal(t, CashCompleted, c.post(CashWithdrawal, acc, 600).Status)
+	assert.Equal(t, CashCompleted, c.post(CashWithdrawal, acc, 400).Status)
+	assert.Equal(t, CashRejected, c.post(CashWithdrawal, acc, 1).Status)
+	assert.Equal(t, int64(4000), balanceOf(t, c.store, acc.ID))
+
+	// One over after a partial day is rejected and doesn't count itself.
+	acc = createTestAccount(t, c.store, 5000)
+	assert.Equal(t, CashCompleted, c.post(CashWithdrawal, acc, 600).Status)
+	assert.Equal(t, CashRejected, c.post(CashWithdrawal, acc, 401).Status)
+	assert.Equal(t, CashCompleted, c.post(CashWithdrawal, acc, 399).Status)
+	assert.Equal(t, int64(4001), balanceOf(t, c.store, acc.ID))
+
+	// Yesterday's withdrawals don't count against today.
+	acc = createTestAccount(t, c.store, 5000)
+	yesterday := &CashOperation{
+		PublicID:  NewULID(),
+		AccountID: acc.ID,
+		Kind:      CashWithdrawal,
+		Amount:    NewMoney(1000, acc.Balance.Currency),
+		CreatedAt: startOfDay(time.Now().UTC()).Add(-time.Hour),
+	}
+	assert.Nil(t, c.store.ExecuteCashOperation(yesterday, atmDailyWithdrawalLimit(acc.Balance.Currency)))
+	assert.Equal(t, CashCompleted, yesterday.Status)
+	assert.Equal(t, CashCompleted, c.post(CashWithdrawal, acc, 1000).Status)
+	assert.Equal(t, int64(3000), balanceOf(t, c.store, acc.ID))
+}
+
+func TestCashDepositsHaveNoDailyLimit(t *testing.T) {
+	c := newCashTest(t)
+	acc := createTestAccount(t, c.store, 0)
+
+	for _, amount := range []int64{999, 1000, 1001, 10_000} {
+		assert.Equal(t, CashCompleted, c.post(CashDeposit, acc, amount).Status)
+	}
+	assert.Equal(t, int64(13_000), balanceOf(t, c.store, acc.ID))
+
+	// Deposits don't free up withdrawal allowance either.
+	assert.Equal(t, CashCompleted, c.post(CashWithdrawal, acc, 1000).Status)
+	assert.Equal(t, CashRejected, c.post(CashWithdrawal, acc, 1).Status)
+}
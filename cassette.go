@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CassetteMode selects whether calls to external services are recorded to
+// cassettes, replayed from them, or go out as usual.
+type CassetteMode string
+
+const (
+	CassetteOff    CassetteMode = ""
+	CassetteRecord CassetteMode = "record"
+	CassetteReplay CassetteMode = "replay"
+)
+
+// Interaction is one recorded request and its response. Request headers
+// aren't kept, so credentials such as API keys never end up in a cassette.
+type Interaction struct {
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestBody    string      `json:"requestBody,omitempty"`
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"responseHeader,omitempty"`
+	ResponseBody   string      `json:"responseBody,omitempty"`
+}
+
+// Cassette is an http.RoundTripper that records the calls to one external
+// service to a JSON file, or replays them from it, so tests and local
+// development run the same way every time and without live credentials.
+// Replayed requests are matched by method and URL, in recorded order;
+// bodies often carry ids and timestamps, so they are kept for reading but
+// not compared.
+type Cassette struct {
+	Mode CassetteMode
+	Path string
+
+	next         http.RoundTripper
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+// cassetteTransport returns the transport for calls to the external
+// service name. CASSETTE_MODE=record or replay keeps them in
+// CASSETTE_DIR/<name>.json; cassettes are refused in production.
+func cassetteTransport(name string) http.RoundTripper {
+	mode := CassetteMode(getEnv("CASSETTE_MODE", ""))
+	if mode == CassetteOff {
+		return http.DefaultTransport
+	}
+	if isProduction() {
+		log.Println("Cassettes are not allowed in production, ignoring CASSETTE_MODE")
+		return http.DefaultTransport
+	}
+
+	c, err := NewCassette(mode, filepath.Join(getEnv("CASSETTE_DIR", "testdata/cassettes"), name+".json"), http.DefaultTransport)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
+
+func NewCassette(mode CassetteMode, path string, next http.RoundTripper) (*Cassette, error) {
+	c := &Cassette{Mode: mode, Path: path, next: next}
+	switch mode {
+	case CassetteRecord:
+		return c, nil
+	case CassetteReplay:
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &c.interactions); err != nil {
+			return nil, fmt.Errorf("reading cassette %s: %w", path, err)
+		}
+		c.used = make([]bool, len(c.interactions))
+		return c, nil
+	}
+	return nil, fmt.Errorf("CASSETTE_MODE must be record or replay, got %q", mode)
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if c.Mode == CassetteReplay {
+		return c.replay(req)
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.record(&Interaction{
+		Method:         req.Method,
+		URL:            req.URL.String(),
+		RequestBody:    string(body),
+		Status:         resp.StatusCode,
+		ResponseHeader: resp.Header,
+		ResponseBody:   string(respBody),
+	}); err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// record appends the interaction and rewrites the cassette, so it is
+// complete even if the process is killed.
+func (c *Cassette) record(i *Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.interactions = append(c.interactions, i)
+	b, err := json.MarshalIndent(c.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.Path, b, 0o644)
+}
+
+func (c *Cassette) replay(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	url := req.URL.String()
+	for n, i := range c.interactions {
+		if c.used[n] || i.Method != req.Method || i.URL != url {
+			continue
+		}
+		c.used[n] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status)),
+			StatusCode:    i.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        i.ResponseHeader.Clone(),
+			Body:          io.NopCloser(bytes.NewReader([]byte(i.ResponseBody))),
+			ContentLength: int64(len(i.ResponseBody)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("cassette %s has no recording of %s %s left", c.Path, req.Method, url)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCassetteRecordReplay(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("x-provider", "fx")
+		w.Write([]byte("rate for " + string(body)))
+	}))
+	path := filepath.Join(t.TempDir(), "fx.json")
+
+	rec, err := NewCassette(CassetteRecord, path, http.DefaultTransport)
+	assert.Nil(t, err)
+	client := &http.Client{Transport: rec}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/rates", strings.NewReader("EUR"))
+	req.Header.Set("Authorization", "Bearer live-secret")
+	resp, err := client.Do(req)
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "rate for EUR", string(body))
+	srv.Close()
+
+	saved, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(saved), "live-secret")
+
+	replay, err := NewCassette(CassetteReplay, path, nil)
+	assert.Nil(t, err)
+	client = &http.Client{Transport: replay}
+	resp, err = client.Post(srv.URL+"/rates", "text/plain", strings.NewReader("EUR"))
+	assert.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "rate for EUR", string(body))
+	assert.Equal(t, "fx", resp.Header.Get("x-provider"))
+	assert.Equal(t, 1, calls)
+
+	// Each recording is played back once.
+	_, err = client.Post(srv.URL+"/rates", "text/plain", strings.NewReader("EUR"))
+	assert.NotNil(t, err)
+}
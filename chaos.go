@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var errChaosConnectionDropped = errors.New("chaos: connection dropped")
+
+var chaosInjected = expvar.NewMap("chaos_injected")
+
+// ChaosConfig controls fault injection. Rates are probabilities between 0
+// and 1 applied to every call.
+type ChaosConfig struct {
+	Enabled     bool
+	LatencyRate float64
+	Latency     time.Duration
+	ErrorRate   float64
+	// Seed makes the injected faults reproducible. Zero seeds from the
+	// clock.
+	Seed int64
+}
+
+func chaosConfigFromEnv() ChaosConfig {
+	return ChaosConfig{
+		Enabled:     getEnvBool("CHAOS_ENABLED", false),
+		LatencyRate: getEnvFloat("CHAOS_LATENCY_RATE", 0.05),
+		Latency:     getEnvDuration("CHAOS_LATENCY", 2*time.Second),
+		ErrorRate:   getEnvFloat("CHAOS_ERROR_RATE", 0.01),
+		Seed:        getEnvInt("CHAOS_SEED", 0),
+	}
+}
+
+// Chaos randomly slows down or fails calls to a downstream dependency so
+// timeouts and retries get exercised before a release. It refuses to run in
+// production.
+type Chaos struct {
+	cfg ChaosConfig
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func NewChaos(cfg ChaosConfig) *Chaos {
+	if cfg.Enabled && isProduction() {
+		log.Println("Chaos mode is not allowed in production, ignoring CHAOS_ENABLED")
+		cfg.Enabled = false
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Chaos{cfg: cfg, rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (c *Chaos) roll() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rnd.Float64()
+}
+
+// Inject is called before talking to the dependency named by target. It may
+// sleep for a latency spike or return an error standing in for a dropped
+// connection.
+func (c *Chaos) Inject(target string) error {
+	if c == nil || !c.cfg.Enabled {
+		return nil
+	}
+
+	if c.roll() < c.cfg.LatencyRate {
+		chaosInjected.Add(target+".latency", 1)
+		time.Sleep(c.cfg.Latency)
+	}
+	if c.roll() < c.cfg.ErrorRate {
+		chaosInjected.Add(target+".error", 1)
+		return errChaosConnectionDropped
+	}
+	return nil
+}
+
+// ChaosStorage injects faults in front of the account and transfer
+// operations of another Storage. Everything else passes straight through.
+type ChaosStorage struct {
+	Storage
+	chaos *Chaos
+}
+
+func NewChaosStorage(store Storage, chaos *Chaos) *ChaosStorage {
+	return &ChaosStorage{Storage: store, chaos: chaos}
+}
+
+func (s *ChaosStorage) WithContext(ctx context.Context) Storage {
+	return NewChaosStorage(storageWithContext(s.Storage, ctx), s.chaos)
+}
+
+func (s *ChaosStorage) CreateAccount(a *Account) error {
+	if err := s.chaos.Inject("storage"); err != nil {
+		return err
+	}
+	return s.Storage.CreateAccount(a)
+}
+
+func (s *ChaosStorage) GetAccounts() ([]*Account, error) {
+	if err := s.chaos.Inject("storage"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAccounts()
+}
+
+func (s *ChaosStorage) GetAccountByID(id int) (*Account, error) {
+	if err := s.chaos.Inject("storage"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAccountByID(id)
+}
+
+func (s *ChaosStorage) GetAccountByNumber(number int32) (*Account, error) {
+	if err := s.chaos.Inject("storage"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAccountByNumber(number)
+}
+
+func (s *ChaosStorage) CreateTransfer(t *Transfer) error {
+	if err := s.chaos.Inject("storage"); err != nil {
+		return err
+	}
+	return s.Storage.CreateTransfer(t)
+}
+
+func (s *ChaosStorage) GetTransferByID(id int) (*Transfer, error) {
+	if err := s.chaos.Inject("storage"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetTransferByID(id)
+}
+
+func (s *ChaosStorage) ExecuteTransfer(t *Transfer) error {
+	if err := s.chaos.Inject("storage"); err != nil {
+		return err
+	}
+	return s.Storage.ExecuteTransfer(t)
+}
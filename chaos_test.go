@@ -0,0 +1,61 @@
+package main
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func chaosCount(key string) int64 {
+	if v, ok := chaosInjected.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestChaosIsOffInProduction(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+
+	chaos := NewChaos(ChaosConfig{Enabled: true, LatencyRate: 1, Latency: time.Hour, ErrorRate: 1})
+	assert.False(t, chaos.cfg.Enabled)
+	assert.Nil(t, chaos.Inject("production-test"))
+}
+
+func TestChaosRespectsRates(t *testing.T) {
+	t.Setenv("APP_ENV", "staging")
+
+	never := NewChaos(ChaosConfig{Enabled: true, LatencyRate: 0, ErrorRate: 0, Seed: 1})
+	always := NewChaos(ChaosConfig{Enabled: true, LatencyRate: 1, Latency: time.Millisecond, ErrorRate: 1, Seed: 1})
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, never.Inject("never-test"))
+		assert.ErrorIs(t, always.Inject("always-test"), errChaosConnectionDropped)
+	}
+	assert.Zero(t, chaosCount("never-test.latency"))
+	assert.Zero(t, chaosCount("never-test.error"))
+	assert.Equal(t, int64(100), chaosCount("always-test.latency"))
+	assert.Equal(t, int64(100), chaosCount("always-test.error"))
+
+	store := NewChaosStorage(NewMemoryStorage(), always)
+	_, err := store.GetAccountByID(1)
+	assert.ErrorIs(t, err, errChaosConnectionDropped)
+}
+
+func TestChaosSeedMakesFaultsReproducible(t *testing.T) {
+	t.Setenv("APP_ENV", "staging")
+
+	outcomes := func(seed int64) []bool {
+		chaos := NewChaos(ChaosConfig{Enabled: true, ErrorRate: 0.5, Seed: seed})
+		failed := make([]bool, 50)
+		for i := range failed {
+			failed[i] = chaos.Inject("seed-test") != nil
+		}
+		return failed
+	}
+
+	first := outcomes(42)
+	assert.Equal(t, first, outcomes(42))
+	assert.Contains(t, first, true)
+	assert.Contains(t, first, false)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ChequeStatus string
+
+// A deposited cheque is a pending credit until it clears, when the money
+// becomes available, or bounces.
+const (
+	ChequePending ChequeStatus = "pending"
+	ChequeCleared ChequeStatus = "cleared"
+	ChequeBounced ChequeStatus = "bounced"
+)
+
+const (
+	NotifyChequeCleared NotificationKind = "cheque.cleared"
+	NotifyChequeBounced NotificationKind = "cheque.bounced"
+)
+
+const chequeClearingPollRate = 5 * time.Second
+
+type Cheque struct {
+	ID             int          `json:"id"`
+	PublicID       string       `json:"publicId"`
+	AccountID      int          `json:"-"`
+	ImageRef       string       `json:"imageRef"`
+	IssuingAccount string       `json:"issuingAccount"`
+	Amount         Money        `json:"amount"`
+	Status         ChequeStatus `json:"status"`
+	HoldReason     string       `json:"holdReason,omitempty"`
+	FailureReason  string       `json:"failureReason,omitempty"`
+	AvailableAt    time.Time    `json:"availableAt"`
+	CreatedAt      time.Time    `json:"createdAt"`
+	UpdatedAt      time.Time    `json:"updatedAt"`
+}
+
+type DepositChequeRequest struct {
+	ImageRef       string `json:"imageRef"`
+	IssuingAccount string `json:"issuingAccount"`
+	Amount         Money  `json:"amount"`
+}
+
+// ChequeClearingConfig controls how long deposited cheques are held before
+// they are settled. Cheques above LargeAmount are held for LargeHold.
+type ChequeClearingConfig struct {
+	Delay       time.Duration
+	LargeAmount int64
+	LargeHold   time.Duration
+}
+
+func chequeClearingConfigFromEnv() ChequeClearingConfig {
+	return ChequeClearingConfig{
+		Delay:       getEnvDuration("CHEQUE_CLEARING_DELAY", 24*time.Hour),
+		LargeAmount: getEnvInt("CHEQUE_LARGE_AMOUNT", 5_000_00),
+		LargeHold:   getEnvDuration("CHEQUE_LARGE_HOLD", 5*24*time.Hour),
+	}
+}
+
+// hold returns when a cheque deposited at now becomes available, and why it
+// is held longer than usual, if it is.
+func (c ChequeClearingConfig) hold(amount Money, now time.Time) (time.Time, string) {
+	if amount.Amount > c.LargeAmount {
+		return now.Add(c.LargeHold), fmt.Sprintf("amount above %s", NewMoney(c.LargeAmount, amount.Currency))
+	}
+	return now.Add(c.Delay), ""
+}
+
+func (s *APIServer) HandleCheques(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		cheques, err := s.storage.GetCheques(id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, cheques)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(DepositChequeRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	account := accountFromContext(r)
+	if req.Amount.Currency == "" {
+		req.Amount.Currency = account.Balance.Currency
+	}
+	if !req.Amount.IsPositive() {
+		return ApiError{Err: "amount must be positive", Status: http.StatusBadRequest}
+	}
+	if req.Amount.Currency != account.Balance.Currency {
+		return ApiError{Err: ErrCurrencyMismatch.Error(), Status: http.StatusBadRequest}
+	}
+	if strings.TrimSpace(req.ImageRef) == "" || strings.TrimSpace(req.IssuingAccount) == "" {
+		return ApiError{Err: "imageRef and issuingAccount are required", Status: http.StatusBadRequest}
+	}
+
+	now := time.Now().UTC()
+	availableAt, holdReason := s.cheques.config.hold(req.Amount, now)
+	if availableAt, err = s.cheques.calendar.Roll(req.Amount.Currency, availableAt); err != nil {
+		return err
+	}
+	cheque := &Cheque{
+		PublicID:       NewULID(),
+		AccountID:      id,
+		ImageRef:       req.ImageRef,
+		IssuingAccount: req.IssuingAccount,
+		Amount:         req.Amount,
+		Status:         ChequePending,
+		HoldReason:     holdReason,
+		AvailableAt:    availableAt,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := s.storage.CreateCheque(cheque); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusAccepted, cheque)
+}
+
+// ChequeClearing settles pending cheques once their hold has passed, on
+// business days only. It plays the issuing bank: cheques drawn on an
+// issuing account starting with "FAIL" bounce, so clients can test that
+// path.
+type ChequeClearing struct {
+	storage  Storage
+	notifier Notifier
+	calendar BusinessCalendar
+	config   ChequeClearingConfig
+}
+
+func NewChequeClearing(store Storage, notifier Notifier, config ChequeClearingConfig) *ChequeClearing {
+	return &ChequeClearing{storage: store, notifier: notifier, calendar: BusinessCalendar{storage: store}, config: config}
+}
+
+func (c *ChequeClearing) Run() {
+	ticker := time.NewTicker(chequeClearingPollRate)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.settleDue(time.Now().UTC())
+	}
+}
+
+func (c *ChequeClearing) settleDue(now time.Time) {
+	cheques, err := c.storage.GetDueCheques(now)
+	if err != nil {
+		log.Println("Failed to load due cheques: ", err)
+		return
+	}
+
+	for _, cheque := range cheques {
+		// Holidays added after the deposit can still push clearing back.
+		if open, err := c.calendar.IsBusinessDay(cheque.Amount.Currency, now); err != nil || !open {
+			continue
+		}
+
+		kind, what := NotifyChequeCleared, "has cleared"
+		if strings.HasPrefix(strings.ToUpper(cheque.IssuingAccount), "FAIL") {
+			err = c.storage.BounceCheque(cheque, "refused by issuing bank")
+			kind, what = NotifyChequeBounced, "bounced"
+		} else {
+			err = c.storage.ClearCheque(cheque)
+		}
+		if err != nil {
+			log.Printf("Failed to settle cheque %d: %v\n", cheque.ID, err)
+			continue
+		}
+
+		msg := fmt.Sprintf("Your cheque deposit %s of %s %s.", cheque.PublicID, cheque.Amount, what)
+		if err := c.notifier.Notify(NewNotification(cheque.AccountID, kind, msg)); err != nil {
+			log.Println("Failed to send cheque notification: ", err)
+		}
+	}
+}
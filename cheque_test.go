@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChequeHold(t *testing.T) {
+	cfg := ChequeClearingConfig{Delay: time.Hour, LargeAmount: 1000, LargeHold: 48 * time.Hour}
+	now := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	availableAt, reason := cfg.hold(NewMoney(1000, "USD"), now)
+	assert.Equal(t, now.Add(time.Hour), availableAt)
+	assert.Empty(t, reason)
+
+	availableAt, reason = cfg.hold(NewMoney(1001, "USD"), now)
+	assert.Equal(t, now.Add(48*time.Hour), availableAt)
+	assert.Equal(t, "amount above 10.00 USD", reason)
+}
+
+func TestChequeClearingSettlesDueCheques(t *testing.T) {
+	store := NewMemoryStorage()
+	notifier := &recordingNotifier{}
+	acc := createTestAccount(t, store, 0)
+
+	now := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	deposit := func(issuer string, availableAt time.Time) *Cheque {
+		c := &Cheque{
+			PublicID:       NewULID(),
+			AccountID:      acc.ID,
+			ImageRef:       "img-1",
+			IssuingAccount: issuer,
+			Amount:         NewMoney(2500, defaultCurrency),
+			Status:         ChequePending,
+			AvailableAt:    availableAt,
+		}
+		assert.Nil(t, store.CreateCheque(c))
+		return c
+	}
+	good := deposit("021000021-12345", now.Add(-time.Minute))
+	bad := deposit("FAIL-0001", now.Add(-time.Minute))
+	held := deposit("021000021-12345", now.Add(time.Hour))
+
+	NewChequeClearing(store, notifier, ChequeClearingConfig{}).settleDue(now)
+
+	cheques, err := store.GetCheques(acc.ID)
+	assert.Nil(t, err)
+	statuses := map[int]ChequeStatus{}
+	for _, c := range cheques {
+		statuses[c.ID] = c.Status
+	}
+	assert.Equal(t, ChequeCleared, statuses[good.ID])
+	assert.Equal(t, ChequeBounced, statuses[bad.ID])
+	assert.Equal(t, ChequePending, statuses[held.ID])
+	assert.Equal(t, int64(2500), balanceOf(t, store, acc.ID))
+	assert.Len(t, notifier.sent, 2)
+
+	// Nothing clears on a holiday.
+	assert.Nil(t, store.CreateHoliday(&Holiday{Currency: defaultCurrency, Date: "2026-03-03", Name: "Bank holiday"}))
+	NewChequeClearing(store, notifier, ChequeClearingConfig{}).settleDue(now.AddDate(0, 0, 1))
+	assert.Len(t, notifier.sent, 2)
+}
@@ -0,0 +1,327 @@
+// Package client is a typed client for the gobank JSON API. It retries
+// requests the server refused under load, and sends transfers with an
+// Idempotency-Key so retrying one never sends the money twice.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to one gobank server. Login stores the session token used
+// by the calls after it.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Token is the session token sent as x-jwt-token.
+	Token string
+	// MaxRetries is how often a request is retried after a 503, a short
+	// 429 or, for requests safe to repeat, a network error.
+	MaxRetries int
+	// Backoff is the wait before the first retry; it doubles after each.
+	Backoff time.Duration
+	// MaxRetryAfter caps how long a Retry-After header may ask to wait.
+	// Longer waits, such as a quota resetting at midnight, are returned as
+	// errors instead.
+	MaxRetryAfter time.Duration
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:       strings.TrimSuffix(baseURL, "/"),
+		HTTPClient:    &http.Client{Timeout: 30 * time.Second},
+		MaxRetries:    3,
+		Backoff:       200 * time.Millisecond,
+		MaxRetryAfter: 10 * time.Second,
+	}
+}
+
+// Error is an error answer from the server.
+type Error struct {
+	Status  int    `json:"-"`
+	Message string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("gobank: %d %s", e.Status, e.Message)
+}
+
+// IsStatus reports whether err is an answer from the server with status.
+func IsStatus(err error, status int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == status
+}
+
+// VerificationRequiredError is returned by Login when the server wants
+// the one-time code it sent the customer, because they signed in from a
+// device it doesn't know.
+type VerificationRequiredError struct {
+	ChallengeID string    `json:"challengeId"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+func (e *VerificationRequiredError) Error() string {
+	return "gobank: login needs verification, challenge " + e.ChallengeID
+}
+
+// Money is an amount in minor units. It reads amounts sent as JSON
+// strings too, for servers running with JSON_AMOUNTS_AS_STRINGS.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Amount   json.Number `json:"amount"`
+		Currency string      `json:"currency"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	amount, err := strconv.ParseInt(strings.Trim(raw.Amount.String(), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("gobank: invalid amount %q", raw.Amount)
+	}
+	m.Amount, m.Currency = amount, raw.Currency
+	return nil
+}
+
+type Account struct {
+	PublicID  string    `json:"publicId"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	Number    int32     `json:"number"`
+	Balance   Money     `json:"balance"`
+	Business  bool      `json:"business"`
+	Phone     string    `json:"phone,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type CreateAccountRequest struct {
+	FirstName           string `json:"firstName"`
+	LastName            string `json:"lastName"`
+	Password            string `json:"password"`
+	Business            bool   `json:"business"`
+	Phone               string `json:"phone"`
+	AcceptedTerms       string `json:"acceptedTerms,omitempty"`
+	ConfirmNotDuplicate bool   `json:"confirmNotDuplicate,omitempty"`
+}
+
+type TransferRequest struct {
+	// ToAccount is the public id of the receiving account.
+	ToAccount         string `json:"toAccountId"`
+	Amount            Money  `json:"amount"`
+	Reference         string `json:"reference,omitempty"`
+	ConfirmationToken string `json:"confirmationToken,omitempty"`
+	// QuoteID is an FX quote locking the rate for a transfer to an account
+	// in another currency.
+	QuoteID string `json:"quoteId,omitempty"`
+	// IdempotencyKey identifies the transfer across retries. Transfer
+	// generates one when it is empty; set it to retry a transfer across
+	// calls, e.g. after a crash.
+	IdempotencyKey string `json:"-"`
+}
+
+type Transfer struct {
+	PublicID      string    `json:"publicId"`
+	FromAccount   string    `json:"fromAccountId"`
+	ToAccount     string    `json:"toAccountId"`
+	Amount        Money     `json:"amount"`
+	Credit        *Money    `json:"credit,omitempty"`
+	QuoteID       string    `json:"quoteId,omitempty"`
+	Reference     string    `json:"reference,omitempty"`
+	RefundOf      string    `json:"refundOfId,omitempty"`
+	Status        string    `json:"status"`
+	FailureReason string    `json:"failureReason,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	NextStatuses  []string  `json:"nextStatuses"`
+}
+
+// Activity is an entry of an account's activity feed. Data holds the
+// transfer, cash operation, login or security or settings change it stands
+// for, depending on Type.
+type Activity struct {
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+type ActivityPage struct {
+	Items      []Activity `json:"items"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+func (c *Client) CreateAccount(ctx context.Context, req CreateAccountRequest) (*Account, error) {
+	account := new(Account)
+	if err := c.do(ctx, http.MethodPost, "/account", req, nil, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// Login signs in with the account number and password and keeps the
+// session token for later calls.
+func (c *Client) Login(ctx context.Context, number int32, password string) error {
+	req := map[string]any{"number": number, "password": password}
+	var resp struct {
+		Token                string    `json:"token"`
+		VerificationRequired bool      `json:"verificationRequired"`
+		ChallengeID          string    `json:"challengeId"`
+		ExpiresAt            time.Time `json:"expiresAt"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/login", req, nil, &resp); err != nil {
+		return err
+	}
+	if resp.VerificationRequired {
+		return &VerificationRequiredError{ChallengeID: resp.ChallengeID, ExpiresAt: resp.ExpiresAt}
+	}
+	c.Token = resp.Token
+	return nil
+}
+
+// Transfer sends money from the signed in account.
+func (c *Client) Transfer(ctx context.Context, req TransferRequest) (*Transfer, error) {
+	if req.IdempotencyKey == "" {
+		key := make([]byte, 16)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		req.IdempotencyKey = hex.EncodeToString(key)
+	}
+
+	t := new(Transfer)
+	header := http.Header{"Idempotency-Key": {req.IdempotencyKey}}
+	if err := c.do(ctx, http.MethodPost, "/transfer", req, header, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ListTransactions returns a page of the account's activity, newest
+// first. Pass the NextCursor of a page to get the next one.
+func (c *Client) ListTransactions(ctx context.Context, accountID string, cursor string, limit int) (*ActivityPage, error) {
+	q := url.Values{}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	path := "/account/" + url.PathEscape(accountID) + "/activity"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	page := new(ActivityPage)
+	if err := c.do(ctx, http.MethodGet, path, nil, nil, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// do sends a request, retrying as MaxRetries allows, and decodes a
+// successful answer into out.
+func (c *Client) do(ctx context.Context, method, path string, in any, header http.Header, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	// Repeating a request the server may have acted on is only safe for
+	// reads and requests it can recognize as repeated.
+	safe := method == http.MethodGet || header.Get("Idempotency-Key") != ""
+
+	wait := c.Backoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.send(ctx, method, path, body, header, out)
+		if err == nil {
+			return nil
+		}
+
+		retryable := safe && ctx.Err() == nil
+		var apiErr *Error
+		if errors.As(err, &apiErr) {
+			// The server answers 503 and 429 before acting on a
+			// request, so those are retried for any request.
+			retryable = retryAfter >= 0
+		}
+		if !retryable || attempt >= c.MaxRetries {
+			return err
+		}
+
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// send makes one attempt. For answers worth retrying, a 503 or a 429 with
+// a short enough Retry-After, it returns how long the server asked to
+// wait; it returns -1 for every other outcome.
+func (c *Client) send(ctx context.Context, method, path string, body []byte, header http.Header, out any) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("x-jwt-token", c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		if out == nil {
+			_, err = io.Copy(io.Discard, resp.Body)
+			return -1, err
+		}
+		return -1, json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	apiErr := &Error{Status: resp.StatusCode}
+	if err := json.NewDecoder(resp.Body).Decode(apiErr); err != nil || apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+
+	retryAfter := time.Duration(-1)
+	if resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter = 0
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		if retryAfter > c.MaxRetryAfter {
+			retryAfter = -1
+		}
+	}
+	return retryAfter, apiErr
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetriesRefusedRequests(t *testing.T) {
+	calls, keys := 0, map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		keys[r.Header.Get("Idempotency-Key")] = true
+		switch {
+		case r.URL.Path == "/quota":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case calls < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"server busy, try again"}`))
+		default:
+			w.Write([]byte(`{"publicId":"t1","amount":{"amount":"300","currency":"USD"},"status":"settled"}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	c.Backoff = time.Millisecond
+	transfer, err := c.Transfer(context.Background(), TransferRequest{ToAccount: "01JRECIPIENT", Amount: Money{Amount: 300}})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Len(t, keys, 1, "retries reuse the idempotency key")
+	assert.Equal(t, int64(300), transfer.Amount.Amount)
+
+	// A quota that resets in an hour isn't waited for.
+	calls = 0
+	err = c.do(context.Background(), http.MethodGet, "/quota", nil, nil, nil)
+	assert.True(t, IsStatus(err, http.StatusTooManyRequests))
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexstepanenkoyt/test-bank-json-api/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientAgainstServer(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	srv := httptest.NewServer(NewAPIServer(":0", store).Router())
+	defer srv.Close()
+	ctx := context.Background()
+	c := client.New(srv.URL)
+
+	sender, err := c.CreateAccount(ctx, client.CreateAccountRequest{FirstName: "Ada", LastName: "Sender", Password: "hunter22"})
+	assert.Nil(t, err)
+	recipient, err := c.CreateAccount(ctx, client.CreateAccountRequest{FirstName: "Bob", LastName: "Recipient", Password: "hunter22"})
+	assert.Nil(t, err)
+	funded, err := store.GetAccountByNumber(sender.Number)
+	assert.Nil(t, err)
+	funded.Balance.Amount = 1000
+	assert.Nil(t, store.UpdateAccount(funded))
+
+	err = c.Login(ctx, sender.Number, "wrong")
+	assert.True(t, client.IsStatus(err, http.StatusForbidden))
+	assert.Nil(t, c.Login(ctx, sender.Number, "hunter22"))
+
+	// Retrying with the same key returns the first transfer instead of
+	// sending the money again.
+	req := client.TransferRequest{ToAccount: recipient.PublicID, Amount: client.Money{Amount: 300}, IdempotencyKey: "retry-me"}
+	first, err := c.Transfer(ctx, req)
+	assert.Nil(t, err)
+	assert.Equal(t, "settled", first.Status)
+	again, err := c.Transfer(ctx, req)
+	assert.Nil(t, err)
+	assert.Equal(t, first.PublicID, again.PublicID)
+	assert.Equal(t, sender.PublicID, first.FromAccount)
+	assert.Equal(t, recipient.PublicID, first.ToAccount)
+	assert.Equal(t, int64(700), balanceOf(t, store, funded.ID))
+
+	req.Amount.Amount = 400
+	_, err = c.Transfer(ctx, req)
+	assert.True(t, client.IsStatus(err, http.StatusUnprocessableEntity))
+
+	page, err := c.ListTransactions(ctx, sender.PublicID, "", 10)
+	assert.Nil(t, err)
+	assert.Len(t, page.Items, 3, "the transfer and both logins")
+	assert.Equal(t, "transfer", page.Items[0].Type)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// concurrencyStats exports, per route group and for account locks, how
+// many requests had to queue, how long they waited in total and how many
+// gave up waiting.
+var concurrencyStats = expvar.NewMap("concurrency")
+
+var errConcurrencyQueueTimeout = ApiError{Err: "server busy, try again", Status: http.StatusServiceUnavailable}
+
+// ConcurrencyLimiter bounds the number of requests served at once per route
+// group and serializes mutations of a single account, so bursts queue up
+// instead of racing each other.
+type ConcurrencyLimiter struct {
+	// limits maps a route group, the first path segment, to the number of
+	// requests it may serve at once. Groups not listed use defaultLimit;
+	// a limit of 0 means unlimited.
+	limits       map[string]int
+	defaultLimit int
+	queueTimeout time.Duration
+
+	mu       sync.Mutex
+	groups   map[string]chan struct{}
+	accounts map[int]*accountLock
+}
+
+type accountLock struct {
+	ch      chan struct{}
+	waiters int
+}
+
+// concurrencyLimiterFromEnv reads ROUTE_CONCURRENCY_LIMITS, a comma
+// separated list of group=limit pairs such as "transfer=50,admin=5".
+func concurrencyLimiterFromEnv() *ConcurrencyLimiter {
+	limits := map[string]int{}
+	for _, pair := range strings.Split(getEnv("ROUTE_CONCURRENCY_LIMITS", ""), ",") {
+		group, limit, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if n, err := strconv.Atoi(limit); ok && err == nil && n >= 0 {
+			limits[group] = n
+		}
+	}
+
+	return NewConcurrencyLimiter(limits, int(getEnvInt("ROUTE_CONCURRENCY_DEFAULT", 256)),
+		getEnvDuration("CONCURRENCY_QUEUE_TIMEOUT", 10*time.Second))
+}
+
+func NewConcurrencyLimiter(limits map[string]int, defaultLimit int, queueTimeout time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		limits:       limits,
+		defaultLimit: defaultLimit,
+		queueTimeout: queueTimeout,
+		groups:       map[string]chan struct{}{},
+		accounts:     map[int]*accountLock{},
+	}
+}
+
+func routeGroup(path string) string {
+	group, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return group
+}
+
+func (l *ConcurrencyLimiter) semaphore(group string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sem, ok := l.groups[group]
+	if !ok {
+		limit, listed := l.limits[group]
+		if !listed {
+			limit = l.defaultLimit
+		}
+		if limit > 0 {
+			sem = make(chan struct{}, limit)
+		}
+		l.groups[group] = sem
+	}
+	return sem
+}
+
+// acquire takes a slot in ch, waiting up to the queue timeout. Waits are
+// recorded under name.
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, ch chan struct{}, name string) error {
+	select {
+	case ch <- struct{}{}:
+		return nil
+	default:
+	}
+
+	concurrencyStats.Add(name+".queued", 1)
+	start := time.Now()
+	defer func() {
+		concurrencyStats.Add(name+".wait_us", time.Since(start).Microseconds())
+	}()
+
+	timeout := time.NewTimer(l.queueTimeout)
+	defer timeout.Stop()
+
+	select {
+	case ch <- struct{}{}:
+		return nil
+	case <-timeout.C:
+		concurrencyStats.Add(name+".rejected", 1)
+		return errConcurrencyQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Middleware limits concurrent requests per route group. /debug is never
+// limited so metrics stay readable under load.
+func (l *ConcurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := routeGroup(r.URL.Path)
+		sem := l.semaphore(group)
+		if sem == nil || group == "debug" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := l.acquire(r.Context(), sem, "route."+group); err != nil {
+			if err == errConcurrencyQueueTimeout {
+				writeJSON(w, http.StatusServiceUnavailable, err)
+			}
+			return
+		}
+		defer func() { <-sem }()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LockAccount waits until no other mutation of the account is in progress
+// and returns the function releasing it.
+func (l *ConcurrencyLimiter) LockAccount(ctx context.Context, accountID int) (func(), error) {
+	l.mu.Lock()
+	lock, ok := l.accounts[accountID]
+	if !ok {
+		lock = &accountLock{ch: make(chan struct{}, 1)}
+		l.accounts[accountID] = lock
+	}
+	lock.waiters++
+	l.mu.Unlock()
+
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.waiters--
+		if lock.waiters == 0 {
+			delete(l.accounts, accountID)
+		}
+	}
+
+	if err := l.acquire(ctx, lock.ch, "account"); err != nil {
+		release()
+		return nil, err
+	}
+
+	return func() {
+		<-lock.ch
+		release()
+	}, nil
+}
+
+// withAccountLock serializes the mutating requests of the authenticated
+// account. It has to run inside withJWTAuth.
+func withAccountLock(f apiFunc, l *ConcurrencyLimiter) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return f(w, r)
+		}
+
+		unlock, err := l.LockAccount(r.Context(), accountFromContext(r).ID)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		return f(w, r)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockAccountSerializesMutations(t *testing.T) {
+	l := NewConcurrencyLimiter(nil, 0, time.Second)
+
+	var inside, maxInside int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := l.LockAccount(context.Background(), 1)
+			if !assert.Nil(t, err) {
+				return
+			}
+			n := atomic.AddInt32(&inside, 1)
+			if n > atomic.LoadInt32(&maxInside) {
+				atomic.StoreInt32(&maxInside, n)
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&inside, -1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), maxInside)
+	assert.Empty(t, l.accounts)
+}
+
+func TestConcurrencyMiddlewareRejectsAfterQueueTimeout(t *testing.T) {
+	l := NewConcurrencyLimiter(map[string]int{"transfer": 1}, 0, 10*time.Millisecond)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("block") != "" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/transfer?block=1", nil))
+	<-started
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/transfer", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	// Other groups are unaffected.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/account", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	close(release)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxPhoneLookup = 500
+
+// Contact is a counterparty in an account's address book. It points at a
+// bank customer by account number or alias, or is just a phone number the
+// customer may later be found by.
+type Contact struct {
+	ID            int       `json:"id"`
+	AccountID     int       `json:"-"`
+	Name          string    `json:"name"`
+	AccountNumber int32     `json:"accountNumber,omitempty"`
+	Alias         string    `json:"alias,omitempty"`
+	Phone         string    `json:"phone,omitempty"`
+	AvatarURL     string    `json:"avatarUrl,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+type ContactRequest struct {
+	Name          string `json:"name"`
+	AccountNumber int32  `json:"accountNumber"`
+	Alias         string `json:"alias"`
+	Phone         string `json:"phone"`
+	AvatarURL     string `json:"avatarUrl"`
+}
+
+type PhoneLookupRequest struct {
+	Phones []string `json:"phones"`
+}
+
+// PhoneLookupResult tells whether a phone number belongs to a customer.
+// Only what a send screen needs is revealed about the customer.
+type PhoneLookupResult struct {
+	Phone         string `json:"phone"`
+	IsCustomer    bool   `json:"isCustomer"`
+	AccountNumber int32  `json:"accountNumber,omitempty"`
+	DisplayName   string `json:"displayName,omitempty"`
+}
+
+// normalizePhone reduces a phone number to "+" and its digits, so numbers
+// typed with spaces, dashes or brackets compare equal.
+func normalizePhone(phone string) (string, bool) {
+	var digits strings.Builder
+	for _, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' || r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", false
+		}
+	}
+	if digits.Len() < 7 || digits.Len() > 15 {
+		return "", false
+	}
+	return "+" + digits.String(), true
+}
+
+// displayName is a customer's first name and last initial.
+func (a *Account) displayName() string {
+	if a.LastName == "" {
+		return a.FirstName
+	}
+	return a.FirstName + " " + string([]rune(a.LastName)[:1]) + "."
+}
+
+func (c *Contact) applyRequest(req *ContactRequest) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return ApiError{Err: "name is required", Status: http.StatusBadRequest}
+	}
+	if req.AccountNumber == 0 && req.Alias == "" && req.Phone == "" {
+		return ApiError{Err: "a contact needs an account number, alias or phone", Status: http.StatusBadRequest}
+	}
+
+	phone := ""
+	if req.Phone != "" {
+		var ok bool
+		if phone, ok = normalizePhone(req.Phone); !ok {
+			return ApiError{Err: "invalid phone number: " + req.Phone, Status: http.StatusBadRequest}
+		}
+	}
+
+	c.Name, c.AccountNumber, c.Alias, c.Phone, c.AvatarURL = req.Name, req.AccountNumber, req.Alias, phone, req.AvatarURL
+	return nil
+}
+
+func (s *APIServer) HandleContacts(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		contacts, err := s.storage.GetContacts(id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, contacts)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(ContactRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	contact := &Contact{AccountID: id, CreatedAt: time.Now().UTC()}
+	if err := contact.applyRequest(req); err != nil {
+		return err
+	}
+	if err := s.storage.CreateContact(contact); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, contact)
+}
+
+func (s *APIServer) HandleContact(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	contactID, err := getIntVar(r, "contactID")
+	if err != nil {
+		return err
+	}
+
+	contact, err := s.storage.GetContact(id, contactID)
+	if err != nil {
+		return ApiError{Err: "contact not found", Status: http.StatusNotFound}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return writeJSON(w, http.StatusOK, contact)
+	case http.MethodPut:
+		req := new(ContactRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return invalidRequest
+		}
+		defer r.Body.Close()
+
+		if err := contact.applyRequest(req); err != nil {
+			return err
+		}
+		if err := s.storage.UpdateContact(contact); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, contact)
+	case http.MethodDelete:
+		if err := s.storage.DeleteContact(id, contactID); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, map[string]int{"deleted": contactID})
+	}
+
+	return methodNotAllowed
+}
+
+// HandleContactLookup takes the phone numbers of a customer's address book
+// and reports which of them belong to bank customers. Results come back in
+// request order; numbers that can't be parsed are reported as non-customers.
+func (s *APIServer) HandleContactLookup(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(PhoneLookupRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	if len(req.Phones) > maxPhoneLookup {
+		return ApiError{Err: "too many phone numbers in one lookup", Status: http.StatusBadRequest}
+	}
+
+	normalized := make([]string, 0, len(req.Phones))
+	for _, phone := range req.Phones {
+		if n, ok := normalizePhone(phone); ok {
+			normalized = append(normalized, n)
+		}
+	}
+
+	accounts, err := s.storage.GetAccountsByPhone(normalized)
+	if err != nil {
+		return err
+	}
+	byPhone := map[string]*Account{}
+	for _, account := range accounts {
+		byPhone[account.Phone] = account
+	}
+
+	results := make([]PhoneLookupResult, 0, len(req.Phones))
+	for _, phone := range req.Phones {
+		result := PhoneLookupResult{Phone: phone}
+		if n, ok := normalizePhone(phone); ok {
+			if account, found := byPhone[n]; found {
+				result.IsCustomer = true
+				result.AccountNumber = account.Number
+				result.DisplayName = account.displayName()
+			}
+		}
+		results = append(results, result)
+	}
+
+	return writeJSON(w, http.StatusOK, results)
+}
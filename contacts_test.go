@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	for in, want := range map[string]string{
+		"+1 (555) 010-2030": "+15550102030",
+		"+44 20 7946 0958":  "+442079460958",
+		"555.010.2030":      "+5550102030",
+	} {
+		got, ok := normalizePhone(in)
+		assert.True(t, ok, in)
+		assert.Equal(t, want, got)
+	}
+
+	for _, in := range []string{"", "12345", "call me", "+1 555 010 2030 ext 4", "1234567890123456"} {
+		_, ok := normalizePhone(in)
+		assert.False(t, ok, in)
+	}
+}
+
+func TestAccountDisplayName(t *testing.T) {
+	assert.Equal(t, "Ana Ö.", (&Account{FirstName: "Ana", LastName: "Öberg"}).displayName())
+	assert.Equal(t, "Ana", (&Account{FirstName: "Ana"}).displayName())
+}
+
+func TestPhoneOnlyInOwnersView(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	owner := createTestAccount(t, store, 0)
+	owner.Phone = "+15550102030"
+	assert.Nil(t, store.UpdateAccount(owner))
+	token, err := createJWT(owner)
+	assert.Nil(t, err)
+
+	get := func(path, token string) string {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.String()
+	}
+
+	assert.NotContains(t, get("/account", ""), owner.Phone)
+	assert.Contains(t, get("/account/"+owner.PublicID, token), `"phone":"`+owner.Phone+`"`)
+}
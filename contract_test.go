@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateContract = flag.Bool("update-contract", false, "rewrite testdata/api_contract.golden")
+
+const contractGolden = "testdata/api_contract.golden"
+
+// contractTypes are the JSON bodies clients send and receive. A type used
+// on the wire that isn't listed here (or reachable from one that is) isn't
+// protected by TestAPIContract.
+var contractTypes = []any{
+	Account{}, AccountDetails{}, AdminAccount{}, CreateAccountRequest{}, LoginRequest{}, LoginResponse{}, LoginChallengeResponse{},
+	VerifyLoginRequest{}, RegisterDeviceRequest{}, RegisterDeviceResponse{}, Device{}, LoginAttemptPage{},
+	TransferRequest{}, FXQuoteRequest{}, FXQuote{}, TransferPreview{}, TransferResource{}, RefundRequest{}, RefundResponse{}, Receipt{},
+	SignedReceipt{}, ActivityPage{}, SyncPage{}, TransactionTag{}, WebhookEndpoint{}, WebhookEndpointRequest{}, WebhookHealth{}, TagRequest{}, RetagRequest{}, RetagResult{}, CategorySummary{},
+	CashOperationRequest{}, CashOperation{}, AdjustmentRequest{}, TellerApproval{}, CreatePayeeRequest{}, Payee{}, CreateBillPaymentRequest{},
+	BillPayment{}, CreateInvoiceRequest{}, InvoiceResource{}, InvoicePayment{}, PayableInvoice{}, AlertRuleRequest{}, AlertRule{},
+	ContactRequest{}, Contact{}, PhoneLookupRequest{}, PhoneLookupResult{}, DepositChequeRequest{}, Cheque{},
+	SweepRuleRequest{}, SweepRule{}, AutomationRuleRequest{}, AutomationRule{}, AutomationRun{}, FreezeWindowRequest{}, FreezeWindow{}, DelegationRequest{}, Delegation{}, ImpersonationRequest{}, Impersonation{},
+	Document{}, DocumentURL{}, PaperlessPreferences{}, StatementRegenerationRequest{}, StatementRegeneration{},
+	ForceFailureRequest{}, ReconciliationReport{}, EndOfDayRequest{}, EndOfDayRun{}, SystemAccountsReport{}, AdminTransfer{}, PolicyDocument{}, LegalHold{}, LegalHoldRequest{}, ReviewCaseRequest{}, ReviewCase{}, ReviewWorkItem{}, AuditEvent{},
+	HolidayRequest{}, Holiday{}, TermsRequest{}, Terms{}, AcceptTermsRequest{}, TermsAcceptance{}, TermsStatus{},
+	DuplicateAccountsReport{}, DuplicateSignup{}, MigrationStatus{},
+	OwnershipTransferRequest{}, OwnershipTransferResponse{}, CloseAccountRequest{}, AccountRedirect{}, HistoricalBalance{}, InterestSimulation{}, EventCatalog{}, UsageReport{}, APIUsageInsights{}, CapturedExchange{},
+	ReplayRequest{}, ReplayResponse{}, ApiError{},
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// contractShape flattens the JSON shape of the given types into
+// "Type.field" -> wire type, following nested structs.
+func contractShape(types []any) map[string]string {
+	shape := map[string]string{}
+	seen := map[reflect.Type]bool{}
+
+	var visit func(t reflect.Type)
+	var describe func(t reflect.Type) string
+	describe = func(t reflect.Type) string {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch {
+		case t == timeType:
+			return "time"
+		case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+			return "custom:" + t.Name()
+		}
+
+		switch t.Kind() {
+		case reflect.Struct:
+			visit(t)
+			return t.Name()
+		case reflect.Slice, reflect.Array:
+			if t.Elem().Kind() == reflect.Uint8 {
+				return "bytes"
+			}
+			return "[]" + describe(t.Elem())
+		case reflect.Map:
+			return "map[" + describe(t.Key()) + "]" + describe(t.Elem())
+		case reflect.Interface:
+			return "any"
+		case reflect.Bool:
+			return "bool"
+		case reflect.String:
+			return "string"
+		default:
+			return "number"
+		}
+	}
+
+	var fields func(owner string, t reflect.Type)
+	fields = func(owner string, t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" {
+				embedded := f.Type
+				if embedded.Kind() == reflect.Pointer {
+					embedded = embedded.Elem()
+				}
+				fields(owner, embedded)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			wire := describe(f.Type)
+			if strings.Contains(opts, "omitempty") {
+				wire += ",omitempty"
+			}
+			shape[owner+"."+name] = wire
+		}
+	}
+
+	visit = func(t reflect.Type) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		fields(t.Name(), t)
+	}
+
+	for _, v := range types {
+		visit(reflect.TypeOf(v))
+	}
+	return shape
+}
+
+func readContract(t *testing.T) (int, map[string]string) {
+	f, err := os.Open(contractGolden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	version := 0
+	shape := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if v, ok := strings.CutPrefix(line, "version "); ok {
+			fmt.Sscan(v, &version)
+			continue
+		}
+		if key, wire, ok := strings.Cut(line, " "); ok {
+			shape[key] = wire
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return version, shape
+}
+
+func writeContract(t *testing.T, shape map[string]string) {
+	keys := make([]string, 0, len(shape))
+	for k := range shape {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "version %d\n", apiVersion)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s %s\n", k, shape[k])
+	}
+	if err := os.WriteFile(contractGolden, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// operationShape maps each operation to its id, which generated SDKs name
+// their methods after, so renaming one is a breaking change too.
+func operationShape(shape map[string]string) {
+	for _, op := range apiOperations {
+		shape["operation:"+op.Method+":"+op.Path] = op.OperationID
+	}
+}
+
+// TestAPIContract fails when a field clients rely on is renamed, removed or
+// changes type without apiVersion being bumped. Additions only need the
+// golden file refreshed with -update-contract.
+func TestAPIContract(t *testing.T) {
+	current := contractShape(contractTypes)
+	operationShape(current)
+	version, golden := readContract(t)
+
+	var breaking, added []string
+	for key, wire := range golden {
+		if now, ok := current[key]; !ok {
+			breaking = append(breaking, key+" was removed")
+		} else if now != wire {
+			breaking = append(breaking, fmt.Sprintf("%s changed from %s to %s", key, wire, now))
+		}
+	}
+	for key := range current {
+		if _, ok := golden[key]; !ok {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(breaking)
+	sort.Strings(added)
+
+	if len(breaking) > 0 && version == apiVersion {
+		t.Fatalf("breaking API changes without bumping apiVersion:\n%s", strings.Join(breaking, "\n"))
+	}
+	if *updateContract {
+		writeContract(t, current)
+		return
+	}
+	if len(breaking) > 0 || len(added) > 0 || version != apiVersion {
+		t.Fatalf("API contract changed, run go test -run TestAPIContract -update-contract to accept:\n%s",
+			strings.Join(append(breaking, added...), "\n"))
+	}
+}
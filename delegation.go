@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Delegation gives the owner of AccountID's accountant or other delegate
+// read-only access to it: the delegate can view the account and its
+// transactions and download exports, but not move money.
+type Delegation struct {
+	ID               int       `json:"id"`
+	AccountID        int       `json:"-"`
+	DelegateAccount  int       `json:"-"`
+	DelegatePublicID string    `json:"delegateAccountId"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// DelegationRequest names the delegate by public id. DelegateAccount, the
+// integer id, is deprecated.
+type DelegationRequest struct {
+	DelegatePublicID string `json:"delegateAccountId,omitempty"`
+	DelegateAccount  int    `json:"delegateAccount,omitempty"`
+}
+
+// ownerOrDelegate extends ownsAccount with read-only access for delegates
+// of the account addressed by {id}. Only GET requests are let through for
+// delegates; the principal records whose account they are reading.
+func ownerOrDelegate(w http.ResponseWriter, r *http.Request, p *Principal, s Storage) error {
+	err := ownsAccount(w, r, p, s)
+	if err == nil || r.Method != http.MethodGet {
+		return err
+	}
+
+	delegations, err := s.GetDelegationsTo(p.AccountID)
+	if err != nil {
+		return err
+	}
+
+	idStr := mux.Vars(r)["id"]
+	for _, d := range delegations {
+		owner, err := s.GetAccountByID(d.AccountID)
+		if err != nil {
+			continue
+		}
+		if idStr == owner.PublicID || idStr == strconv.Itoa(owner.ID) {
+			p.DelegatorID, p.DelegatorPublicID = owner.ID, owner.PublicID
+			return nil
+		}
+	}
+	return permissionDenied
+}
+
+func (s *APIServer) HandleDelegations(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		delegations, err := s.storage.GetDelegations(id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, delegations)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(DelegationRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	delegateNotFound := ApiError{Err: "delegate account not found", Status: http.StatusBadRequest}
+	if req.DelegatePublicID != "" {
+		if req.DelegateAccount, err = s.storage.GetAccountIDByPublicID(req.DelegatePublicID); err != nil {
+			return delegateNotFound
+		}
+	}
+	if req.DelegateAccount == id {
+		return ApiError{Err: "cannot delegate to the same account", Status: http.StatusBadRequest}
+	}
+	delegate, err := s.storage.GetAccountByID(req.DelegateAccount)
+	if err != nil {
+		return delegateNotFound
+	}
+	if req.DelegatePublicID == "" {
+		markIntegerIDDeprecated(w, r, strconv.Itoa(req.DelegateAccount), delegate.PublicID)
+	}
+
+	existing, err := s.storage.GetDelegations(id)
+	if err != nil {
+		return err
+	}
+	for _, d := range existing {
+		if d.DelegateAccount == req.DelegateAccount {
+			return writeJSON(w, http.StatusOK, d)
+		}
+	}
+
+	delegation := &Delegation{AccountID: id, DelegateAccount: req.DelegateAccount, CreatedAt: time.Now().UTC()}
+	if err := s.storage.CreateDelegation(delegation); err != nil {
+		return err
+	}
+	if err := s.recordAccountEvent(r, "delegate.granted", id, map[string]string{"delegateAccountId": delegate.PublicID}); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, delegation)
+}
+
+// HandleRevokeDelegation takes a delegate's access away again. It applies
+// to the delegate's next request, as access is checked on every one.
+func (s *APIServer) HandleRevokeDelegation(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodDelete {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	delegationID, err := getIntVar(r, "delegationID")
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.DeleteDelegation(id, delegationID); err != nil {
+		return err
+	}
+	if err := s.recordAccountEvent(r, "delegate.revoked", id, map[string]string{"delegation": strconv.Itoa(delegationID)}); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]int{"deleted": delegationID})
+}
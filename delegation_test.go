@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelegateHasReadOnlyAccess(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	owner := createTestAccount(t, store, 1000)
+	accountant := createTestAccount(t, store, 0)
+	ownerToken, err := createJWT(owner)
+	assert.Nil(t, err)
+	accountantToken, err := createJWT(accountant)
+	assert.Nil(t, err)
+
+	do := func(token, method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	activity := "/account/" + owner.PublicID + "/activity"
+
+	assert.Equal(t, http.StatusForbidden, do(accountantToken, http.MethodGet, activity, ""))
+
+	grant := `{"delegateAccount":` + strconv.Itoa(accountant.ID) + `}`
+	assert.Equal(t, http.StatusCreated, do(ownerToken, http.MethodPost, "/account/"+owner.PublicID+"/delegates", grant))
+
+	assert.Equal(t, http.StatusOK, do(accountantToken, http.MethodGet, activity, ""))
+	assert.Equal(t, http.StatusOK, do(accountantToken, http.MethodGet, "/account/"+owner.PublicID, ""))
+	assert.Equal(t, http.StatusOK, do(accountantToken, http.MethodGet, "/account/"+owner.PublicID+"/transfers/export", ""))
+
+	// No money movement or changes to the account.
+	assert.Equal(t, http.StatusForbidden, do(accountantToken, http.MethodDelete, "/account/"+owner.PublicID, ""))
+	assert.Equal(t, http.StatusForbidden, do(accountantToken, http.MethodGet, "/account/"+owner.PublicID+"/delegates", ""))
+	assert.Equal(t, http.StatusForbidden, do(accountantToken, http.MethodPost, "/account/"+owner.PublicID+"/sweeps", "{}"))
+
+	delegations, err := store.GetDelegations(owner.ID)
+	assert.Nil(t, err)
+	assert.Len(t, delegations, 1)
+	revoke := "/account/" + owner.PublicID + "/delegates/" + strconv.Itoa(delegations[0].ID)
+	assert.Equal(t, http.StatusOK, do(ownerToken, http.MethodDelete, revoke, ""))
+	assert.Equal(t, http.StatusForbidden, do(accountantToken, http.MethodGet, activity, ""))
+}
+
+func TestDelegateActivityHasNoLogins(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	owner := createTestAccount(t, store, 1000)
+	accountant := createTestAccount(t, store, 0)
+	ownerToken, err := createJWT(owner)
+	assert.Nil(t, err)
+	accountantToken, err := createJWT(accountant)
+	assert.Nil(t, err)
+	assert.Nil(t, store.CreateDelegation(&Delegation{AccountID: owner.ID, DelegateAccount: accountant.ID, CreatedAt: time.Now().UTC()}))
+
+	login := `{"number":` + strconv.Itoa(int(owner.Number)) + `,"password":"wrong"}`
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(login)))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	activity := func(token string) []ActivityType {
+		req := httptest.NewRequest(http.MethodGet, "/account/"+owner.PublicID+"/activity", nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var page ActivityPage
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		types := []ActivityType{}
+		for _, item := range page.Items {
+			types = append(types, item.Type)
+		}
+		return types
+	}
+
+	assert.Nil(t, store.CreateAuditEvent(NewAuditEvent("account:"+owner.PublicID, "freeze.created", owner.ID, nil)))
+	assert.Nil(t, store.CreateAuditEvent(NewAuditEvent("account:"+owner.PublicID, "device.registered", owner.ID, nil)))
+
+	assert.Subset(t, activity(ownerToken), []ActivityType{ActivityLogin, ActivitySecurity, ActivitySettings})
+	for _, hidden := range []ActivityType{ActivityLogin, ActivitySecurity, ActivitySettings} {
+		assert.NotContains(t, activity(accountantToken), hidden)
+	}
+}
+
+func TestDelegateNamedByPublicID(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	owner := createTestAccount(t, store, 0)
+	accountant := createTestAccount(t, store, 0)
+	token, err := createJWT(owner)
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/account/"+owner.PublicID+"/delegates",
+		strings.NewReader(`{"delegateAccountId":"`+accountant.PublicID+`"}`))
+	req.Header.Set("x-jwt-token", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+
+	var body map[string]any
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, accountant.PublicID, body["delegateAccountId"])
+	assert.NotContains(t, body, "delegateAccount")
+	assert.NotContains(t, body, "accountId")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// deprecatedRouteHits counts requests per deprecated route so we can tell
+// when nobody uses one anymore and it is safe to remove.
+var deprecatedRouteHits = expvar.NewMap("deprecated_route_hits")
+
+type Deprecation struct {
+	// Since is when the route was deprecated.
+	Since time.Time
+	// Sunset is when the route will stop working, if already decided.
+	Sunset time.Time
+	// Successor is the URL clients should migrate to.
+	Successor string
+}
+
+// markDeprecated marks a response as coming from a deprecated route, or a
+// deprecated form of one, using the Deprecation (RFC 9745), Sunset
+// (RFC 8594) and Link headers.
+func markDeprecated(w http.ResponseWriter, route string, d Deprecation) {
+	deprecatedRouteHits.Add(route, 1)
+
+	h := w.Header()
+	h.Set("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+	if !d.Sunset.IsZero() {
+		h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Successor != "" {
+		h.Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, d.Successor))
+	}
+}
+
+// integerIDsDeprecatedSince is when ULIDs replaced integer ids in URLs.
+var integerIDsDeprecatedSince = time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC)
+
+// markIntegerIDDeprecated flags a request that addressed a resource by its
+// integer id and points it at the same URL using the public id instead.
+func markIntegerIDDeprecated(w http.ResponseWriter, r *http.Request, intID, publicID string) {
+	successor := strings.Replace(r.URL.Path, "/"+intID+"/", "/"+publicID+"/", 1)
+	if strings.HasSuffix(successor, "/"+intID) {
+		successor = strings.TrimSuffix(successor, intID) + publicID
+	}
+	markDeprecated(w, routeTemplate(r), Deprecation{Since: integerIDsDeprecatedSince, Successor: successor})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkDeprecatedSetsHeaders(t *testing.T) {
+	since := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2026, time.July, 1, 0, 0, 0, 0, time.UTC)
+
+	rec := httptest.NewRecorder()
+	markDeprecated(rec, "/old", Deprecation{Since: since, Sunset: sunset, Successor: "/new"})
+
+	assert.Equal(t, "@"+strconv.FormatInt(since.Unix(), 10), rec.Header().Get("Deprecation"))
+	assert.Equal(t, "Wed, 01 Jul 2026 00:00:00 GMT", rec.Header().Get("Sunset"))
+	assert.Equal(t, `</new>; rel="successor-version"`, rec.Header().Get("Link"))
+	assert.Equal(t, "1", deprecatedRouteHits.Get("/old").String())
+
+	// Without a sunset date or successor only Deprecation is set.
+	rec = httptest.NewRecorder()
+	markDeprecated(rec, "/undecided", Deprecation{Since: since})
+	assert.NotEmpty(t, rec.Header().Get("Deprecation"))
+	assert.Empty(t, rec.Header().Get("Sunset"))
+	assert.Empty(t, rec.Header().Get("Link"))
+}
+
+func TestIntegerIDsAreDeprecated(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	acc := createTestAccount(t, store, 0)
+	token, err := createJWT(acc)
+	assert.Nil(t, err)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/account/" + acc.PublicID + "/balance")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+
+	hits := func() int64 {
+		if v := deprecatedRouteHits.Get("/account/{id}/balance"); v != nil {
+			n, _ := strconv.ParseInt(v.String(), 10, 64)
+			return n
+		}
+		return 0
+	}
+	before := hits()
+	rec = get("/account/" + strconv.Itoa(acc.ID) + "/balance")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "@"+strconv.FormatInt(integerIDsDeprecatedSince.Unix(), 10), rec.Header().Get("Deprecation"))
+	assert.Equal(t, `</account/`+acc.PublicID+`/balance>; rel="successor-version"`, rec.Header().Get("Link"))
+	assert.Equal(t, before+1, hits(), "hits are counted per route, not per path")
+}
+
+func TestDebugVarsNeedAdmin(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	acc := createTestAccount(t, store, 0)
+	token, err := createJWT(acc)
+	assert.Nil(t, err)
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get("", "").Code)
+	assert.Equal(t, http.StatusForbidden, get("x-admin-token", token).Code)
+	assert.Equal(t, http.StatusForbidden, get("x-admin-token", "wrong").Code)
+
+	rec := get("x-admin-token", "test-admin")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"deprecated_route_hits"`)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	loginChallengeTTL         = 10 * time.Minute
+	maxLoginChallengeAttempts = 5
+)
+
+// Device is a device the customer trusts. Once an account has trusted
+// devices, logins from anywhere else need a verification code.
+type Device struct {
+	ID         int        `json:"id"`
+	AccountID  int        `json:"-"`
+	DeviceID   string     `json:"deviceId"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+type RegisterDeviceRequest struct {
+	DeviceID string `json:"deviceId"`
+	Name     string `json:"name"`
+}
+
+// RegisterDeviceResponse carries the device token. It is only ever shown
+// once; we keep just its hash.
+type RegisterDeviceResponse struct {
+	*Device
+	DeviceToken string `json:"deviceToken"`
+}
+
+// LoginChallenge is the pending second step of a login from an unknown
+// device.
+type LoginChallenge struct {
+	ID        string
+	AccountID int
+	CodeHash  string
+	Attempts  int
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+type LoginChallengeResponse struct {
+	VerificationRequired bool      `json:"verificationRequired"`
+	ChallengeID          string    `json:"challengeId"`
+	ExpiresAt            time.Time `json:"expiresAt"`
+}
+
+type VerifyLoginRequest struct {
+	ChallengeID string `json:"challengeId"`
+	Code        string `json:"code"`
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func secretMatches(secret, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(hash)) == 1
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func randomCode(digits int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
+
+func (s *APIServer) HandleDevices(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		devices, err := s.storage.GetDevicesByAccount(id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, devices)
+	}
+
+	if r.Method == http.MethodPost {
+		req := new(RegisterDeviceRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.DeviceID == "" {
+			return invalidRequest
+		}
+		defer r.Body.Close()
+
+		token, err := randomToken(32)
+		if err != nil {
+			return err
+		}
+
+		device := &Device{
+			AccountID: id,
+			DeviceID:  req.DeviceID,
+			Name:      req.Name,
+			TokenHash: hashSecret(token),
+			CreatedAt: time.Now().UTC(),
+		}
+		if err := s.storage.CreateDevice(device); err != nil {
+			return err
+		}
+		if err := s.recordAccountEvent(r, "device.registered", id, map[string]string{"deviceId": device.DeviceID, "name": device.Name}); err != nil {
+			return err
+		}
+
+		return writeJSON(w, http.StatusCreated, RegisterDeviceResponse{Device: device, DeviceToken: token})
+	}
+
+	return methodNotAllowed
+}
+
+func (s *APIServer) HandleRevokeDevice(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodDelete {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	deviceID, err := getIntVar(r, "deviceID")
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.RevokeDevice(id, deviceID); err != nil {
+		return ApiError{Err: "device not found", Status: http.StatusNotFound}
+	}
+	if err := s.recordAccountEvent(r, "device.revoked", id, map[string]string{"device": strconv.Itoa(deviceID)}); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]int{"revoked": deviceID})
+}
+
+// trustedDevice reports whether the login comes from one of the account's
+// trusted devices. Accounts without any registered device are not subject
+// to device checks.
+func (s *APIServer) trustedDevice(acc *Account, req *LoginRequest) (bool, error) {
+	devices, err := s.storage.GetDevicesByAccount(acc.ID)
+	if err != nil {
+		return false, err
+	}
+	if len(devices) == 0 {
+		return true, nil
+	}
+
+	for _, d := range devices {
+		if d.DeviceID == req.DeviceID && secretMatches(req.DeviceToken, d.TokenHash) {
+			if err := s.storage.TouchDevice(d.ID); err != nil {
+				log.Println("Failed to update device: ", err)
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// challengeLogin starts the verification of a login from an unknown device
+// by sending the customer a one-time code.
+func (s *APIServer) challengeLogin(w http.ResponseWriter, acc *Account, attempt *LoginAttempt) error {
+	code, err := randomCode(6)
+	if err != nil {
+		return err
+	}
+	id, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+
+	challenge := &LoginChallenge{
+		ID:        id,
+		AccountID: acc.ID,
+		CodeHash:  hashSecret(code),
+		ExpiresAt: time.Now().UTC().Add(loginChallengeTTL),
+	}
+	if err := s.storage.CreateLoginChallenge(challenge); err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Sign-in attempt from a new device (%s, %s). Your verification code is %s.",
+		attempt.IP, attempt.UserAgent, code)
+	if err := s.notifier.Notify(NewNotification(acc.ID, NotifyNewDeviceLogin, msg)); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusAccepted, LoginChallengeResponse{
+		VerificationRequired: true,
+		ChallengeID:          challenge.ID,
+		ExpiresAt:            challenge.ExpiresAt,
+	})
+}
+
+// HandleVerifyLogin finishes a login from an unknown device with the code
+// sent by challengeLogin.
+func (s *APIServer) HandleVerifyLogin(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(VerifyLoginRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return loginDenied
+	}
+	defer r.Body.Close()
+
+	// The guess is counted before the code is checked, so parallel
+	// guesses can't get past maxLoginChallengeAttempts.
+	now := time.Now().UTC()
+	challenge, err := s.storage.CountLoginChallengeAttempt(req.ChallengeID, maxLoginChallengeAttempts, now)
+	if errors.Is(err, ErrLoginChallengeNotFound) {
+		return loginDenied
+	}
+	if err != nil {
+		return err
+	}
+	if !secretMatches(req.Code, challenge.CodeHash) {
+		return loginDenied
+	}
+	if err := s.storage.UseLoginChallenge(challenge.ID, now); err != nil {
+		if errors.Is(err, ErrLoginChallengeNotFound) {
+			return loginDenied
+		}
+		return err
+	}
+
+	acc, err := s.storage.GetAccountByID(challenge.AccountID)
+	if err != nil {
+		return loginDenied
+	}
+
+	token, err := createJWT(acc)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, LoginResponse{Number: acc.Number, Token: token})
+}
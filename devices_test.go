@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type deviceTest struct {
+	t        *testing.T
+	store    *MemoryStorage
+	router   http.Handler
+	notifier *recordingNotifier
+	account  *Account
+	token    string
+}
+
+func newDeviceTest(t *testing.T) *deviceTest {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	server := NewAPIServer(":0", store)
+	notifier := &recordingNotifier{}
+	server.notifier = notifier
+	acc, err := NewAccount("device", "test", "secret")
+	assert.Nil(t, err)
+	assert.Nil(t, store.CreateAccount(acc))
+	token, err := createJWT(acc)
+	assert.Nil(t, err)
+
+	return &deviceTest{t: t, store: store, router: server.Router(), notifier: notifier, account: acc, token: token}
+}
+
+func (d *deviceTest) do(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("x-jwt-token", d.token)
+	rec := httptest.NewRecorder()
+	d.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (d *deviceTest) registerDevice(deviceID string) RegisterDeviceResponse {
+	rec := d.do(http.MethodPost, "/account/"+strconv.Itoa(d.account.ID)+"/devices", `{"deviceId":"`+deviceID+`","name":"phone"}`)
+	assert.Equal(d.t, http.StatusCreated, rec.Code)
+	var resp RegisterDeviceResponse
+	assert.Nil(d.t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp
+}
+
+func (d *deviceTest) login(deviceID, deviceToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(LoginRequest{Number: d.account.Number, Password: "secret", DeviceID: deviceID, DeviceToken: deviceToken})
+	return d.do(http.MethodPost, "/login", string(body))
+}
+
+// challenge logs in from an unknown device and returns the challenge id
+// with the code sent to the customer.
+func (d *deviceTest) challenge() (string, string) {
+	rec := d.login("laptop", "")
+	assert.Equal(d.t, http.StatusAccepted, rec.Code)
+	var resp LoginChallengeResponse
+	assert.Nil(d.t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.True(d.t, resp.VerificationRequired)
+
+	sent := d.notifier.sent[len(d.notifier.sent)-1]
+	assert.Equal(d.t, NotifyNewDeviceLogin, sent.Kind)
+	code := strings.TrimSuffix(sent.Message[strings.LastIndex(sent.Message, " ")+1:], ".")
+	return resp.ChallengeID, code
+}
+
+func (d *deviceTest) verify(challengeID, code string) int {
+	body, _ := json.Marshal(VerifyLoginRequest{ChallengeID: challengeID, Code: code})
+	return d.do(http.MethodPost, "/login/verify", string(body)).Code
+}
+
+func TestLoginFromUnknownDeviceNeedsCode(t *testing.T) {
+	d := newDeviceTest(t)
+
+	// Without devices there is nothing to check.
+	assert.Equal(t, http.StatusOK, d.login("", "").Code)
+
+	phone := d.registerDevice("phone")
+	assert.NotEmpty(t, phone.DeviceToken)
+	assert.Equal(t, http.StatusOK, d.login("phone", phone.DeviceToken).Code)
+	assert.Equal(t, http.StatusAccepted, d.login("phone", "forged").Code)
+
+	challengeID, code := d.challenge()
+	assert.Len(t, code, 6)
+	assert.Equal(t, http.StatusOK, d.verify(challengeID, code))
+	// A code only works once.
+	assert.Equal(t, http.StatusForbidden, d.verify(challengeID, code))
+	assert.Equal(t, http.StatusForbidden, d.verify("unknown", code))
+}
+
+func TestLoginChallengeLocksAfterMaxAttempts(t *testing.T) {
+	d := newDeviceTest(t)
+	d.registerDevice("phone")
+
+	challengeID, code := d.challenge()
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < maxLoginChallengeAttempts; i++ {
+		assert.Equal(t, http.StatusForbidden, d.verify(challengeID, wrong))
+	}
+	assert.Equal(t, http.StatusForbidden, d.verify(challengeID, code))
+}
+
+func TestLoginChallengeCountsParallelGuesses(t *testing.T) {
+	d := newDeviceTest(t)
+	d.registerDevice("phone")
+	challengeID, code := d.challenge()
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4*maxLoginChallengeAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.verify(challengeID, wrong)
+		}()
+	}
+	wg.Wait()
+
+	challenge, err := d.store.GetLoginChallenge(challengeID)
+	assert.Nil(t, err)
+	assert.Equal(t, maxLoginChallengeAttempts, challenge.Attempts)
+	assert.Equal(t, http.StatusForbidden, d.verify(challengeID, code))
+}
+
+func TestRevokedDeviceIsNoLongerTrusted(t *testing.T) {
+	d := newDeviceTest(t)
+	phone := d.registerDevice("phone")
+	d.registerDevice("tablet")
+	assert.Equal(t, http.StatusOK, d.login("phone", phone.DeviceToken).Code)
+
+	path := "/account/" + strconv.Itoa(d.account.ID) + "/devices/" + strconv.Itoa(phone.ID)
+	assert.Equal(t, http.StatusOK, d.do(http.MethodDelete, path, "").Code)
+	assert.Equal(t, http.StatusNotFound, d.do(http.MethodDelete, path, "").Code)
+	assert.Equal(t, http.StatusAccepted, d.login("phone", phone.DeviceToken).Code)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type DocumentKind string
+
+const (
+	DocumentStatement DocumentKind = "statement"
+	DocumentReceipt   DocumentKind = "receipt"
+	DocumentNotice    DocumentKind = "notice"
+)
+
+// DocumentDelivery is how a document reaches the customer besides the
+// document center, decided by their paperless preferences when it is filed.
+type DocumentDelivery string
+
+const (
+	DeliveryElectronic DocumentDelivery = "electronic"
+	DeliveryPostal     DocumentDelivery = "postal"
+)
+
+const statementPeriodLayout = "2006-01"
+
+// Document is a statement, receipt or notice filed for an account. The
+// content lives in the object store; this is its metadata.
+type Document struct {
+	ID          int              `json:"-"`
+	PublicID    string           `json:"id"`
+	AccountID   int              `json:"-"`
+	Kind        DocumentKind     `json:"kind"`
+	Title       string           `json:"title"`
+	Period      string           `json:"period,omitempty"`
+	ContentType string           `json:"contentType"`
+	Size        int              `json:"size"`
+	Delivery    DocumentDelivery `json:"delivery"`
+	CreatedAt   time.Time        `json:"createdAt"`
+}
+
+func (d *Document) objectKey() string {
+	return fmt.Sprintf("accounts/%d/documents/%s", d.AccountID, d.PublicID)
+}
+
+// PaperlessPreferences says which documents the customer only wants
+// electronically. Anything not paperless is also sent by post.
+type PaperlessPreferences struct {
+	AccountID  int       `json:"-"`
+	Statements bool      `json:"statements"`
+	Notices    bool      `json:"notices"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+func defaultPaperlessPreferences(accountID int) *PaperlessPreferences {
+	return &PaperlessPreferences{AccountID: accountID, Statements: true, Notices: true}
+}
+
+func (p *PaperlessPreferences) delivery(kind DocumentKind) DocumentDelivery {
+	if (kind == DocumentStatement && !p.Statements) || (kind == DocumentNotice && !p.Notices) {
+		return DeliveryPostal
+	}
+	return DeliveryElectronic
+}
+
+// DocumentURL is a short-lived link to a document's content that works
+// without a token, so it can be handed to a browser or PDF viewer.
+type DocumentURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// DocumentCenter files documents and, once a month, every account's
+// statement for the previous month.
+type DocumentCenter struct {
+	URLTTL   time.Duration
+	Interval time.Duration
+
+	storage  Storage
+	objects  ObjectStore
+	notifier Notifier
+}
+
+// documentCenterFromEnv keeps documents below DOCUMENT_DIR, checks for due
+// statements every STATEMENT_INTERVAL and signs download links for
+// DOCUMENT_URL_TTL.
+func documentCenterFromEnv(store Storage, notifier Notifier) *DocumentCenter {
+	return &DocumentCenter{
+		URLTTL:   getEnvDuration("DOCUMENT_URL_TTL", 5*time.Minute),
+		Interval: getEnvDuration("STATEMENT_INTERVAL", time.Hour),
+		storage:  store,
+		objects:  DirObjectStore{Root: getEnv("DOCUMENT_DIR", "documents")},
+		notifier: notifier,
+	}
+}
+
+// File stores body as a new document for the account and tells the
+// customer about it.
+func (d *DocumentCenter) File(doc *Document, body []byte) error {
+	prefs, err := d.storage.GetPaperlessPreferences(doc.AccountID)
+	if err != nil {
+		return err
+	}
+
+	doc.PublicID = NewULID()
+	doc.Size = len(body)
+	doc.Delivery = prefs.delivery(doc.Kind)
+	doc.CreatedAt = time.Now().UTC()
+	if err := d.objects.Put(doc.objectKey(), body); err != nil {
+		return err
+	}
+	if err := d.storage.CreateDocument(doc); err != nil {
+		return err
+	}
+
+	// Postal delivery stands in for a print-and-mail provider, like
+	// LogNotifier does for SMS and email.
+	if doc.Delivery == DeliveryPostal {
+		log.Printf("Queued document %s for postal delivery to account %d\n", doc.PublicID, doc.AccountID)
+	}
+	n := NewNotification(doc.AccountID, NotificationKind(string(doc.Kind)+".available"),
+		fmt.Sprintf("A new document is in your document center: %s.", doc.Title))
+	if err := d.notifier.Notify(n); err != nil {
+		log.Println("Failed to send document notification: ", err)
+	}
+	return nil
+}
+
+func (d *DocumentCenter) Run() {
+	go d.ResumeRegenerations()
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.fileStatements(time.Now().UTC()); err != nil {
+			log.Println("Failed to file statements: ", err)
+		}
+		<-ticker.C
+	}
+}
+
+// fileStatements files last month's statement for every account that
+// doesn't have one yet, so a restart or a missed run catches up.
+func (d *DocumentCenter) fileStatements(now time.Time) error {
+	end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	start := end.AddDate(0, -1, 0)
+	period := start.Format(statementPeriodLayout)
+
+	accounts, err := d.storage.GetAccounts()
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		if account.System != "" || !account.CreatedAt.Before(end) {
+			continue
+		}
+		if err := d.fileStatement(account, period, start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DocumentCenter) fileStatement(account *Account, period string, start, end time.Time) error {
+	existing, err := d.storage.GetDocuments(account.ID, DocumentStatement)
+	if err != nil {
+		return err
+	}
+	for _, doc := range existing {
+		if doc.Period == period {
+			return nil
+		}
+	}
+
+	body, err := d.statementPDF(account, period, start, end)
+	if err != nil {
+		return err
+	}
+	doc := &Document{
+		AccountID:   account.ID,
+		Kind:        DocumentStatement,
+		Title:       "Statement " + period,
+		Period:      period,
+		ContentType: "application/pdf",
+	}
+	return d.File(doc, body)
+}
+
+// statementPDF renders the settled transfers of the account between start
+// and end. The same transfers always render to the same bytes, which is
+// what lets regenerating a statement tell whether anything changed.
+func (d *DocumentCenter) statementPDF(account *Account, period string, start, end time.Time) ([]byte, error) {
+	lines := []string{fmt.Sprintf("Account %d, %s to %s", account.Number,
+		start.Format(dateLayout), end.AddDate(0, 0, -1).Format(dateLayout)), ""}
+	page := PageQuery{After: start, Before: end, BeforeID: math.MaxInt32, Limit: maxPageLimit}
+	for {
+		transfers, err := d.storage.GetTransfers(TransferFilter{AccountID: &account.ID, Status: TransferSettled}, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range transfers {
+			amount, counterparty := t.Amount, t.ToPublicID
+			if t.ToAccount == account.ID {
+				counterparty = t.FromPublicID
+			} else {
+				amount = amount.Negate()
+			}
+			lines = append(lines, fmt.Sprintf("%s  %-34s  %12s  %s",
+				t.CreatedAt.Format(dateLayout), "account "+counterparty, amount, t.Reference))
+		}
+		if len(transfers) < page.Limit {
+			break
+		}
+		last := transfers[len(transfers)-1]
+		page.Before, page.BeforeID = last.CreatedAt, last.ID
+	}
+
+	return renderTextPDF("Statement "+period, lines), nil
+}
+
+// signedURL links to the document's content until expiresAt. The HMAC is
+// keyed separately from the JWT secret's other uses.
+func (d *DocumentCenter) signedURL(doc *Document, expiresAt time.Time) string {
+	exp := strconv.FormatInt(expiresAt.Unix(), 10)
+	q := url.Values{"expires": {exp}, "signature": {documentSignature(doc.PublicID, exp)}}
+	return "/documents/" + doc.PublicID + "?" + q.Encode()
+}
+
+func documentSignature(publicID, exp string) string {
+	h := hmac.New(sha256.New, []byte("document:"+getSecret()))
+	fmt.Fprintf(h, "%s|%s", publicID, exp)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *APIServer) HandleDocuments(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	docs, err := s.storage.GetDocuments(id, DocumentKind(r.URL.Query().Get("kind")))
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, docs)
+}
+
+// HandleDocumentURL hands out a signed download link for one document.
+func (s *APIServer) HandleDocumentURL(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	doc, err := s.storage.GetDocumentByPublicID(mux.Vars(r)["documentID"])
+	if err != nil {
+		return err
+	}
+	if doc.AccountID != id {
+		return fmt.Errorf("%w: %s", ErrDocumentNotFound, doc.PublicID)
+	}
+
+	expiresAt := time.Now().UTC().Add(s.documents.URLTTL).Truncate(time.Second)
+	return writeJSON(w, http.StatusOK, DocumentURL{URL: s.documents.signedURL(doc, expiresAt), ExpiresAt: expiresAt})
+}
+
+// HandleDownloadDocument serves a document's content to whoever holds an
+// unexpired signed link.
+func (s *APIServer) HandleDownloadDocument(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	publicID := mux.Vars(r)["documentID"]
+	q := r.URL.Query()
+	exp := q.Get("expires")
+	expiresAt, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt ||
+		!hmac.Equal([]byte(q.Get("signature")), []byte(documentSignature(publicID, exp))) {
+		return ApiError{Err: "link is invalid or has expired", Status: http.StatusForbidden}
+	}
+
+	doc, err := s.storage.GetDocumentByPublicID(publicID)
+	if err != nil {
+		return err
+	}
+	body, err := s.documents.objects.Get(doc.objectKey())
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", doc.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.pdf"`, doc.Kind, doc.PublicID))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	return err
+}
+
+func (s *APIServer) HandlePaperlessPreferences(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		prefs, err := s.storage.GetPaperlessPreferences(id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, prefs)
+	case http.MethodPut:
+		prefs := &PaperlessPreferences{}
+		if err := json.NewDecoder(r.Body).Decode(prefs); err != nil {
+			return invalidRequest
+		}
+		defer r.Body.Close()
+
+		prefs.AccountID, prefs.UpdatedAt = id, time.Now().UTC()
+		if err := s.storage.UpdatePaperlessPreferences(prefs); err != nil {
+			return err
+		}
+		details := map[string]string{"statements": strconv.FormatBool(prefs.Statements), "notices": strconv.FormatBool(prefs.Notices)}
+		if err := s.recordAccountEvent(r, "preferences.paperless_updated", id, details); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, prefs)
+	}
+
+	return methodNotAllowed
+}
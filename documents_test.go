@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonthlyStatementsAndSignedDownloads(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("DOCUMENT_DIR", t.TempDir())
+
+	store := NewMemoryStorage()
+	server := NewAPIServer(":0", store)
+	router := server.Router()
+
+	now := time.Now().UTC()
+	lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	acc := createTestAccount(t, store, 1000)
+	other := createTestAccount(t, store, 0)
+	for _, a := range []*Account{acc, other} {
+		a.CreatedAt = lastMonth
+		assert.Nil(t, store.UpdateAccount(a))
+	}
+	assert.Nil(t, store.UpdatePaperlessPreferences(&PaperlessPreferences{AccountID: other.ID, Notices: true}))
+
+	transfer := NewTransfer(acc.ID, other.ID, NewMoney(250, defaultCurrency))
+	transfer.CreatedAt = lastMonth.Add(48 * time.Hour)
+	assert.Nil(t, store.CreateTransfer(transfer))
+	assert.Nil(t, store.ExecuteTransfer(transfer))
+
+	// Running twice files each statement once.
+	assert.Nil(t, server.documents.fileStatements(now))
+	assert.Nil(t, server.documents.fileStatements(now))
+
+	docs, err := store.GetDocuments(acc.ID, DocumentStatement)
+	assert.Nil(t, err)
+	assert.Len(t, docs, 1)
+	assert.Equal(t, lastMonth.Format(statementPeriodLayout), docs[0].Period)
+	assert.Equal(t, DeliveryElectronic, docs[0].Delivery)
+
+	otherDocs, err := store.GetDocuments(other.ID, "")
+	assert.Nil(t, err)
+	assert.Len(t, otherDocs, 1)
+	assert.Equal(t, DeliveryPostal, otherDocs[0].Delivery)
+
+	token, err := createJWT(acc)
+	assert.Nil(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/account/"+acc.PublicID+"/documents/"+docs[0].PublicID+"/url", nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var link DocumentURL
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &link))
+
+	// The link works without a token; a tampered one doesn't.
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, link.URL, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, bytes.HasPrefix(rec.Body.Bytes(), []byte("%PDF-")))
+	assert.Contains(t, rec.Body.String(), "-2.50")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, strings.Replace(link.URL, "expires=", "expires=9", 1), nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Other customers can't get a link to it.
+	otherToken, err := createJWT(other)
+	assert.Nil(t, err)
+	req = httptest.NewRequest(http.MethodGet, "/account/"+other.PublicID+"/documents/"+docs[0].PublicID+"/url", nil)
+	req.Header.Set("x-jwt-token", otherToken)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Accounts hold no date of birth or email, so a signup looks like a
+// duplicate when its name matches an existing customer's, or its phone
+// number is already registered. A name match only prompts: the customer
+// can confirm they're new and go ahead. A phone match can't be overridden.
+const (
+	DuplicateByName  = "name"
+	DuplicateByPhone = "phone"
+)
+
+// DuplicateSignup is the 409 body of a signup that looks like an existing
+// customer. It names what matched but not who, as signup is anonymous.
+type DuplicateSignup struct {
+	Err         string   `json:"error"`
+	Reasons     []string `json:"reasons"`
+	CanConfirm  bool     `json:"canConfirm"`
+	SuggestLink string   `json:"suggestLink"`
+}
+
+// DuplicateCluster is a group of customer accounts sharing Key.
+type DuplicateCluster struct {
+	Reason   string         `json:"reason"`
+	Key      string         `json:"key"`
+	Accounts []AdminAccount `json:"accounts"`
+}
+
+type DuplicateAccountsReport struct {
+	GeneratedAt time.Time           `json:"generatedAt"`
+	Clusters    []*DuplicateCluster `json:"clusters"`
+}
+
+// normalizeName folds case and whitespace, so "Ada  Lovelace" and
+// "ada lovelace" compare equal.
+func normalizeName(firstName, lastName string) string {
+	return strings.ToLower(strings.Join(strings.Fields(firstName+" "+lastName), " "))
+}
+
+// checkDuplicateSignup writes a DuplicateSignup and returns false when req
+// looks like an existing customer. phone is already normalized.
+func (s *APIServer) checkDuplicateSignup(w http.ResponseWriter, req *CreateAccountRequest, phone string) (bool, error) {
+	conflict := DuplicateSignup{Reasons: []string{}, CanConfirm: true, SuggestLink: "/login"}
+
+	if phone != "" {
+		existing, err := s.storage.GetAccountsByPhone([]string{phone})
+		if err != nil {
+			return false, err
+		}
+		if len(existing) > 0 {
+			conflict.Reasons = append(conflict.Reasons, DuplicateByPhone)
+			conflict.CanConfirm = false
+		}
+	}
+
+	if !req.ConfirmNotDuplicate || !conflict.CanConfirm {
+		existing, err := s.storage.GetAccountsByName(normalizeName(req.FirstName, req.LastName))
+		if err != nil {
+			return false, err
+		}
+		if len(existing) > 0 {
+			conflict.Reasons = append(conflict.Reasons, DuplicateByName)
+		}
+	}
+
+	if len(conflict.Reasons) == 0 {
+		return true, nil
+	}
+	if conflict.CanConfirm {
+		conflict.Err = "an account with these details may already exist; log in, or resend with confirmNotDuplicate if this is a new customer"
+	} else {
+		conflict.Err = "phone number is already registered"
+	}
+	return false, writeJSON(w, http.StatusConflict, conflict)
+}
+
+// duplicateClusters groups customer accounts by normalized name and by
+// phone number, keeping the groups with more than one account.
+func duplicateClusters(accounts []*Account) []*DuplicateCluster {
+	groups := map[string]*DuplicateCluster{}
+	add := func(reason, key string, a *Account) {
+		if key == "" {
+			return
+		}
+		c, ok := groups[reason+":"+key]
+		if !ok {
+			c = &DuplicateCluster{Reason: reason, Key: key}
+			groups[reason+":"+key] = c
+		}
+		c.Accounts = append(c.Accounts, a.Admin())
+	}
+	for _, a := range accounts {
+		if a.System != "" {
+			continue
+		}
+		add(DuplicateByName, normalizeName(a.FirstName, a.LastName), a)
+		add(DuplicateByPhone, a.Phone, a)
+	}
+
+	clusters := []*DuplicateCluster{}
+	for _, c := range groups {
+		if len(c.Accounts) > 1 {
+			clusters = append(clusters, c)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Reason != clusters[j].Reason {
+			return clusters[i].Reason < clusters[j].Reason
+		}
+		return clusters[i].Key < clusters[j].Key
+	})
+	return clusters
+}
+
+func (s *APIServer) HandleAdminDuplicates(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	accounts, err := s.storage.GetAccounts()
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, DuplicateAccountsReport{GeneratedAt: time.Now().UTC(), Clusters: duplicateClusters(accounts)})
+}
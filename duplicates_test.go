@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignupDetectsDuplicates(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	signup := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/account", strings.NewReader(body)))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, signup(`{"firstName":"Ada","lastName":"Lovelace","password":"pw","phone":"+44 20 7946 0000"}`).Code)
+
+	var conflict DuplicateSignup
+	rec := signup(`{"firstName":" ada ","lastName":"LOVELACE","password":"pw"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &conflict))
+	assert.Equal(t, []string{DuplicateByName}, conflict.Reasons)
+	assert.True(t, conflict.CanConfirm)
+
+	assert.Equal(t, http.StatusOK, signup(`{"firstName":"Ada","lastName":"Lovelace","password":"pw","confirmNotDuplicate":true}`).Code)
+
+	// A registered phone number can't be confirmed past.
+	rec = signup(`{"firstName":"Charles","lastName":"Babbage","password":"pw","phone":"+442079460000","confirmNotDuplicate":true}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &conflict))
+	assert.Equal(t, []string{DuplicateByPhone}, conflict.Reasons)
+	assert.False(t, conflict.CanConfirm)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/reports/duplicates", nil)
+	req.Header.Set("x-admin-token", "test-admin")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var report DuplicateAccountsReport
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	if assert.Len(t, report.Clusters, 1) {
+		assert.Equal(t, DuplicateByName, report.Clusters[0].Reason)
+		assert.Equal(t, "ada lovelace", report.Clusters[0].Key)
+		assert.Len(t, report.Clusters[0].Accounts, 2)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const EventEndOfDayCompleted DomainEventKind = "eod.completed"
+
+type EndOfDayStatus string
+
+const (
+	EndOfDayRunning   EndOfDayStatus = "running"
+	EndOfDayCompleted EndOfDayStatus = "completed"
+)
+
+// EndOfDayRun closes a business day: pending transfers accepted before
+// the cutoff are settled, then the ledger is frozen for as long as it takes
+// to snapshot the position of every currency.
+type EndOfDayRun struct {
+	ID           int                `json:"id"`
+	PublicID     string             `json:"publicId"`
+	BusinessDate string             `json:"businessDate"`
+	Status       EndOfDayStatus     `json:"status"`
+	Settled      int                `json:"settled"`
+	Failed       int                `json:"failed"`
+	Positions    []CurrencyPosition `json:"positions"`
+	StartedAt    time.Time          `json:"startedAt"`
+	CompletedAt  *time.Time         `json:"completedAt,omitempty"`
+}
+
+// CurrencyPosition is what customers held in a currency at the close,
+// against the close of the previous run. Pending counts the transfers the
+// run couldn't settle, such as those held for review.
+type CurrencyPosition struct {
+	Currency string `json:"currency"`
+	Accounts int    `json:"accounts"`
+	Opening  Money  `json:"opening"`
+	Closing  Money  `json:"closing"`
+	Net      Money  `json:"net"`
+	Pending  int    `json:"pending"`
+}
+
+type EndOfDayRequest struct {
+	// BusinessDate is YYYY-MM-DD, today when empty.
+	BusinessDate string `json:"businessDate"`
+}
+
+// LedgerFreeze stops postings for a moment. Every posting holds it for
+// reading; freezing takes it for writing, waiting for postings in flight
+// and holding back new ones until thawed.
+type LedgerFreeze struct {
+	mu sync.RWMutex
+}
+
+// hold keeps the ledger from freezing until the returned func is called.
+// A nil freeze never freezes.
+func (f *LedgerFreeze) hold() func() {
+	if f == nil {
+		return func() {}
+	}
+	f.mu.RLock()
+	return f.mu.RUnlock
+}
+
+func (f *LedgerFreeze) freeze() func() {
+	f.mu.Lock()
+	return f.mu.Unlock
+}
+
+// EndOfDay runs the end-of-day process every day once the clock passes
+// Cutoff (UTC), and publishes eod.completed on the event bus when done.
+type EndOfDay struct {
+	// Cutoff is the time of day, as an offset from midnight.
+	Cutoff        time.Duration
+	CheckInterval time.Duration
+
+	storage Storage
+	events  *EventBus
+	ledger  *LedgerFreeze
+	// mu keeps two runs from closing the same day at once.
+	mu sync.Mutex
+}
+
+func endOfDayFromEnv(store Storage, events *EventBus, ledger *LedgerFreeze) *EndOfDay {
+	cutoff, err := time.Parse("15:04", getEnv("EOD_CUTOFF", "22:00"))
+	if err != nil {
+		log.Println("Invalid EOD_CUTOFF, using 22:00: ", err)
+		cutoff, _ = time.Parse("15:04", "22:00")
+	}
+	return &EndOfDay{
+		Cutoff:        time.Duration(cutoff.Hour())*time.Hour + time.Duration(cutoff.Minute())*time.Minute,
+		CheckInterval: getEnvDuration("EOD_CHECK_INTERVAL", time.Minute),
+		storage:       store,
+		events:        events,
+		ledger:        ledger,
+	}
+}
+
+func (e *EndOfDay) Run() {
+	ticker := time.NewTicker(e.CheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now().UTC()
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		if now.Before(day.Add(e.Cutoff)) {
+			continue
+		}
+		if _, err := e.Close(day.Format(dateLayout)); err != nil && err != ErrStateConflict {
+			log.Println("End of day failed: ", err)
+		}
+	}
+}
+
+// Close runs the end-of-day process for businessDate, or finishes a run
+// a restart interrupted. A day closes only once: closing it again returns
+// ErrStateConflict.
+func (e *EndOfDay) Close(businessDate string) (*EndOfDayRun, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	run, err := e.storage.GetEndOfDay(businessDate)
+	switch {
+	case err == nil && run.Status == EndOfDayCompleted:
+		return nil, ErrStateConflict
+	case err == nil:
+		log.Printf("Resuming end of day %s\n", businessDate)
+	default:
+		run = &EndOfDayRun{
+			PublicID:     NewULID(),
+			BusinessDate: businessDate,
+			Status:       EndOfDayRunning,
+			Positions:    []CurrencyPosition{},
+			StartedAt:    time.Now().UTC(),
+		}
+		if err := e.storage.CreateEndOfDay(run); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := e.settle(run); err != nil {
+		return nil, err
+	}
+	if err := e.snapshot(run); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	run.Status, run.CompletedAt = EndOfDayCompleted, &now
+	if err := e.storage.UpdateEndOfDay(run); err != nil {
+		return nil, err
+	}
+	completed := *run
+	e.events.Publish(DomainEvent{Kind: EventEndOfDayCompleted, EndOfDay: &completed, OccurredAt: now})
+	return run, nil
+}
+
+// settle executes every transfer accepted before now, like the transfer
+// processor would but without waiting for it.
+func (e *EndOfDay) settle(run *EndOfDayRun) error {
+	cutoff := time.Now().UTC()
+	for {
+		t, err := e.storage.ClaimTransfer(transferProcessingLease, cutoff)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return nil
+		}
+		if err := e.storage.ExecuteTransfer(t); err != nil {
+			return err
+		}
+		if t.Status == TransferSettled {
+			run.Settled++
+		} else {
+			run.Failed++
+		}
+	}
+}
+
+// snapshot records the positions with the ledger frozen, so no posting
+// lands between reading the balances and the pending transfers.
+func (e *EndOfDay) snapshot(run *EndOfDayRun) error {
+	previous, err := e.storage.GetEndOfDays(2)
+	if err != nil {
+		return err
+	}
+	opening := map[string]Money{}
+	for _, p := range previous {
+		if p.BusinessDate < run.BusinessDate && p.Status == EndOfDayCompleted {
+			for _, pos := range p.Positions {
+				opening[pos.Currency] = pos.Closing
+			}
+			break
+		}
+	}
+
+	thaw := e.ledger.freeze()
+	report, err := e.storage.GetReconciliationReport(time.Now().UTC().Add(-transferProcessingLease))
+	thaw()
+	if err != nil {
+		return err
+	}
+
+	positions := map[string]*CurrencyPosition{}
+	position := func(currency string) *CurrencyPosition {
+		p, ok := positions[currency]
+		if !ok {
+			p = &CurrencyPosition{Currency: currency, Opening: NewMoney(0, currency), Closing: NewMoney(0, currency)}
+			if o, ok := opening[currency]; ok {
+				p.Opening = o
+			}
+			positions[currency] = p
+		}
+		return p
+	}
+	for _, b := range report.Balances {
+		p := position(b.Currency)
+		p.Accounts, p.Closing = b.Accounts, b.Total
+	}
+	for _, t := range report.Transfers {
+		if t.Status == TransferAccepted || t.Status == TransferProcessing || t.Status == TransferHeld {
+			position(t.Total.Currency).Pending += t.Count
+		}
+	}
+	for currency := range opening {
+		position(currency)
+	}
+
+	run.Positions = []CurrencyPosition{}
+	for _, p := range positions {
+		p.Net = NewMoney(p.Closing.Amount-p.Opening.Amount, p.Currency)
+		run.Positions = append(run.Positions, *p)
+	}
+	sort.Slice(run.Positions, func(i, j int) bool { return run.Positions[i].Currency < run.Positions[j].Currency })
+	return nil
+}
+
+// HandleAdminEndOfDay lists the latest runs, or closes a day in the
+// background and answers 202 with its Location.
+func (s *APIServer) HandleAdminEndOfDay(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		limit, err := getPageLimit(r)
+		if err != nil {
+			return err
+		}
+		runs, err := s.storage.GetEndOfDays(limit)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, runs)
+	case http.MethodPost:
+		req := new(EndOfDayRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return invalidRequest
+		}
+		defer r.Body.Close()
+
+		if req.BusinessDate == "" {
+			req.BusinessDate = time.Now().UTC().Format(dateLayout)
+		}
+		if _, err := time.Parse(dateLayout, req.BusinessDate); err != nil {
+			return ApiError{Err: "invalid businessDate: " + req.BusinessDate, Status: http.StatusBadRequest}
+		}
+		if run, err := s.storage.GetEndOfDay(req.BusinessDate); err == nil && run.Status == EndOfDayCompleted {
+			return ErrStateConflict
+		}
+
+		go func() {
+			if _, err := s.eod.Close(req.BusinessDate); err != nil {
+				log.Printf("End of day %s failed: %v\n", req.BusinessDate, err)
+			}
+		}()
+
+		event := NewAuditEvent(adminActor(r), "eod.started", 0, map[string]string{"businessDate": req.BusinessDate})
+		if err := s.storage.CreateAuditEvent(event); err != nil {
+			log.Println("Failed to audit end of day: ", err)
+		}
+
+		w.Header().Set("Location", "/admin/eod/"+req.BusinessDate)
+		return writeJSON(w, http.StatusAccepted, req)
+	}
+	return methodNotAllowed
+}
+
+func (s *APIServer) HandleAdminGetEndOfDay(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	run, err := s.storage.GetEndOfDay(mux.Vars(r)["businessDate"])
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, run)
+}
+
+// onEndOfDay files last month's statements at the first close after the
+// month ended, rather than waiting for the next statement interval.
+// Filing is idempotent, so other closes are no-ops.
+func (d *DocumentCenter) onEndOfDay(e DomainEvent) error {
+	date, err := time.Parse(dateLayout, e.EndOfDay.BusinessDate)
+	if err != nil {
+		return err
+	}
+	return d.fileStatements(date)
+}
+
+// onEndOfDay resyncs the gauges with the reconciliation at the close.
+func (m *LedgerMetrics) onEndOfDay(e DomainEvent) error {
+	if err := m.Resync(time.Now().UTC()); err != nil {
+		return fmt.Errorf("resync after end of day %s: %w", e.EndOfDay.BusinessDate, err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndOfDay(t *testing.T) {
+	store := NewMemoryStorage()
+	events := &EventBus{Shards: 1, QueueSize: 10}
+	closed := make(chan DomainEvent, 10)
+	events.Subscribe("recorder", func(e DomainEvent) error {
+		closed <- e
+		return nil
+	}, EventEndOfDayCompleted)
+	events.Start()
+	eod := &EndOfDay{storage: store, events: events, ledger: &LedgerFreeze{}}
+
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+	for _, amount := range []int64{300, 900} {
+		assert.Nil(t, store.CreateTransfer(NewTransfer(from.ID, to.ID, NewMoney(amount, defaultCurrency))))
+	}
+
+	run, err := eod.Close("2026-03-02")
+	assert.Nil(t, err)
+	assert.Equal(t, EndOfDayCompleted, run.Status)
+	assert.Equal(t, 1, run.Settled)
+	assert.Equal(t, 1, run.Failed, "the second transfer exceeds the balance left")
+	assert.Equal(t, []CurrencyPosition{{
+		Currency: defaultCurrency, Accounts: 2,
+		Opening: NewMoney(0, defaultCurrency), Closing: NewMoney(1000, defaultCurrency), Net: NewMoney(1000, defaultCurrency),
+	}}, run.Positions)
+	assert.Equal(t, int64(700), balanceOf(t, store, from.ID))
+
+	select {
+	case e := <-closed:
+		assert.Equal(t, "2026-03-02", e.EndOfDay.BusinessDate)
+	case <-time.After(time.Second):
+		t.Fatal("eod.completed not published")
+	}
+
+	_, err = eod.Close("2026-03-02")
+	assert.ErrorIs(t, err, ErrStateConflict, "a day closes once")
+
+	createTestAccount(t, store, 250)
+	run, err = eod.Close("2026-03-03")
+	assert.Nil(t, err)
+	assert.Equal(t, NewMoney(1000, defaultCurrency), run.Positions[0].Opening)
+	assert.Equal(t, NewMoney(250, defaultCurrency), run.Positions[0].Net)
+}
+
+func TestLedgerFreezeHoldsPostings(t *testing.T) {
+	ledger := &LedgerFreeze{}
+	store := NewAlertingStorage(NewMemoryStorage(), &recordingNotifier{})
+	store.ledger = ledger
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+	transfer := NewTransfer(from.ID, to.ID, NewMoney(100, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(transfer))
+
+	thaw := ledger.freeze()
+	done := make(chan error)
+	go func() { done <- store.ExecuteTransfer(transfer) }()
+	select {
+	case <-done:
+		t.Fatal("posted while the ledger was frozen")
+	case <-time.After(20 * time.Millisecond):
+	}
+	thaw()
+	assert.Nil(t, <-done)
+	assert.Equal(t, int64(100), balanceOf(t, store, to.ID))
+}
+
+func TestAdminEndOfDay(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	router := NewAPIServer(":0", NewMemoryStorage()).Router()
+
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-admin-token", "admin-secret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/admin/eod", `{"businessDate":"yesterday"}`).Code)
+	rec := call(http.MethodPost, "/admin/eod", `{"businessDate":"2026-03-02"}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "/admin/eod/2026-03-02", rec.Header().Get("Location"))
+
+	run := new(EndOfDayRun)
+	assert.Eventually(t, func() bool {
+		rec := call(http.MethodGet, "/admin/eod/2026-03-02", "")
+		return json.NewDecoder(rec.Body).Decode(run) == nil && run.Status == EndOfDayCompleted
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, http.StatusConflict, call(http.MethodPost, "/admin/eod", `{"businessDate":"2026-03-02"}`).Code)
+
+	runs := []*EndOfDayRun{}
+	assert.Nil(t, json.NewDecoder(call(http.MethodGet, "/admin/eod", "").Body).Decode(&runs))
+	assert.Len(t, runs, 1)
+}
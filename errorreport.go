@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// release identifies the build in error reports. Set it with
+// -ldflags "-X main.release=...", as the Makefile does.
+var release = "dev"
+
+// errorReportStats exports how many error reports were sent, and dropped
+// by the rate limit or a full queue.
+var errorReportStats = expvar.NewMap("error_reports")
+
+// ErrorEvent is a panic or unexpected error, in the shape of a Sentry
+// event. It carries no request body or query string, and credentials in
+// headers are redacted like in captures.
+type ErrorEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Message     string            `json:"message"`
+	Release     string            `json:"release"`
+	Environment string            `json:"environment"`
+	Request     *ErrorRequest     `json:"request,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+type ErrorRequest struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Headers map[string][]string `json:"headers"`
+}
+
+func newErrorEvent(r *http.Request, level, message string) *ErrorEvent {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	e := &ErrorEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC(),
+		Level:       level,
+		Platform:    "go",
+		Message:     message,
+		Release:     release,
+		Environment: appEnv(),
+		Tags:        map[string]string{},
+		Extra:       map[string]string{},
+	}
+	if r != nil {
+		e.Request = &ErrorRequest{Method: r.Method, URL: r.URL.Path, Headers: redactHeaders(r.Header)}
+		e.Tags["route"] = routeTemplate(r)
+	}
+	return e
+}
+
+// ErrorReporter sends error events somewhere people look at them.
+type ErrorReporter interface {
+	Report(*ErrorEvent) error
+}
+
+// LogErrorReporter writes error events to the server log. It is used when
+// no SENTRY_DSN is set.
+type LogErrorReporter struct{}
+
+func (LogErrorReporter) Report(e *ErrorEvent) error {
+	log.Printf("Error report %s [%s]: %s %s\n", e.EventID, e.Level, e.Message, e.Extra["stack"])
+	return nil
+}
+
+// SentryReporter posts events to the store endpoint of a Sentry
+// compatible server, such as Sentry itself or GlitchTip.
+type SentryReporter struct {
+	endpoint string
+	key      string
+	client   *http.Client
+}
+
+// NewSentryReporter parses a DSN of the form
+// https://<key>@<host>/<project>.
+func NewSentryReporter(dsn string) (*SentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	project := strings.TrimPrefix(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return nil, fmt.Errorf("invalid sentry DSN: %s", u.Redacted())
+	}
+
+	return &SentryReporter{
+		endpoint: fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project),
+		key:      u.User.Username(),
+		client:   &http.Client{Timeout: 5 * time.Second, Transport: cassetteTransport("sentry")},
+	}, nil
+}
+
+func (s *SentryReporter) Report(e *ErrorEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=gobank/"+release+", sentry_key="+s.key)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sentry answered %s", resp.Status)
+	}
+	return nil
+}
+
+// ErrorReporting queues error events for a reporter, sending at most
+// PerMinute a minute so an outage doesn't flood the sink. Reporting never
+// blocks a request: events past the limit or a full queue are dropped.
+type ErrorReporting struct {
+	PerMinute int
+
+	reporter ErrorReporter
+	queue    chan *ErrorEvent
+
+	mu          sync.Mutex
+	windowStart time.Time
+	sent        int
+}
+
+func errorReportingFromEnv() *ErrorReporting {
+	var reporter ErrorReporter = LogErrorReporter{}
+	if dsn := getEnv("SENTRY_DSN", ""); dsn != "" {
+		sentry, err := NewSentryReporter(dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reporter = sentry
+	}
+	return NewErrorReporting(reporter, int(getEnvInt("ERROR_REPORTS_PER_MINUTE", 60)))
+}
+
+func NewErrorReporting(reporter ErrorReporter, perMinute int) *ErrorReporting {
+	return &ErrorReporting{PerMinute: perMinute, reporter: reporter, queue: make(chan *ErrorEvent, 100)}
+}
+
+func (e *ErrorReporting) Run() {
+	for event := range e.queue {
+		if err := e.reporter.Report(event); err != nil {
+			log.Println("Failed to report error: ", err)
+			continue
+		}
+		errorReportStats.Add("sent", 1)
+	}
+}
+
+// allow reports whether another event fits in the current minute.
+func (e *ErrorReporting) allow(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if now.Sub(e.windowStart) >= time.Minute {
+		e.windowStart, e.sent = now, 0
+	}
+	if e.sent >= e.PerMinute {
+		return false
+	}
+	e.sent++
+	return true
+}
+
+func (e *ErrorReporting) report(event *ErrorEvent) {
+	if !e.allow(time.Now()) {
+		errorReportStats.Add("rate_limited", 1)
+		return
+	}
+	select {
+	case e.queue <- event:
+	default:
+		errorReportStats.Add("dropped", 1)
+	}
+}
+
+type contextErrorReportingKey struct{}
+
+// Middleware answers handler panics with a 500 and reports them with
+// their stack, and makes the reporting available to makeHTTPHandleFunc,
+// which reports internal errors.
+func (e *ErrorReporting) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), contextErrorReportingKey{}, e))
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				event := newErrorEvent(r, "fatal", fmt.Sprint("panic: ", v))
+				event.Extra["stack"] = string(debug.Stack())
+				e.report(event)
+				writeJSON(w, http.StatusInternalServerError, ApiError{Err: "internal server", Status: http.StatusInternalServerError})
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reportError reports an error answered with a 500. Domain errors and
+// 503s from the limiters are expected and not reported.
+func reportError(r *http.Request, err error) {
+	if e, ok := r.Context().Value(contextErrorReportingKey{}).(*ErrorReporting); ok {
+		e.report(newErrorEvent(r, "error", err.Error()))
+	}
+}
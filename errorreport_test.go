@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorReportingCapturesPanicsAndInternalErrors(t *testing.T) {
+	reporting := NewErrorReporting(LogErrorReporter{}, 10)
+
+	panicking := reporting.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/account/1?secret=x", nil)
+	req.Header.Set("x-jwt-token", "token")
+	rec := httptest.NewRecorder()
+	panicking.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	failing := reporting.Middleware(makeHTTPHandleFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return errors.New("connection refused")
+	}))
+	failing.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/transfer", nil))
+
+	// Domain errors aren't reported.
+	notFound := reporting.Middleware(makeHTTPHandleFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return ErrAccountNotFound
+	}))
+	notFound.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/account/2", nil))
+
+	if assert.Len(t, reporting.queue, 2) {
+		event := <-reporting.queue
+		assert.Equal(t, "fatal", event.Level)
+		assert.Equal(t, "panic: boom", event.Message)
+		assert.Equal(t, "/account/1", event.Request.URL)
+		assert.Equal(t, []string{redacted}, event.Request.Headers["X-Jwt-Token"])
+		assert.Contains(t, event.Extra["stack"], "errorreport_test.go")
+
+		event = <-reporting.queue
+		assert.Equal(t, "error", event.Level)
+		assert.Equal(t, "connection refused", event.Message)
+	}
+}
+
+func TestErrorReportingRateLimit(t *testing.T) {
+	reporting := NewErrorReporting(LogErrorReporter{}, 2)
+	now := time.Now()
+
+	assert.True(t, reporting.allow(now))
+	assert.True(t, reporting.allow(now))
+	assert.False(t, reporting.allow(now.Add(30*time.Second)))
+	assert.True(t, reporting.allow(now.Add(time.Minute)))
+}
+
+func TestSentryReporter(t *testing.T) {
+	var auth string
+	var event ErrorEvent
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/42/store/", r.URL.Path)
+		auth = r.Header.Get("X-Sentry-Auth")
+		json.NewDecoder(r.Body).Decode(&event)
+	}))
+	defer sink.Close()
+
+	_, err := NewSentryReporter("https://sentry.example.com/42")
+	assert.NotNil(t, err)
+
+	reporter, err := NewSentryReporter("http://public-key@" + sink.Listener.Addr().String() + "/42")
+	assert.Nil(t, err)
+	assert.Nil(t, reporter.Report(newErrorEvent(nil, "error", "connection refused")))
+
+	assert.Contains(t, auth, "sentry_key=public-key")
+	assert.Equal(t, "connection refused", event.Message)
+	assert.Equal(t, release, event.Release)
+	assert.Len(t, event.EventID, 32)
+}
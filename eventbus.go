@@ -0,0 +1,157 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"sync"
+	"time"
+)
+
+type DomainEventKind string
+
+const (
+	EventTransferSettled DomainEventKind = "transfer.settled"
+	EventTransferFailed  DomainEventKind = "transfer.failed"
+	// EventAccountPosted is published for every posting to an account:
+	// each leg of a transfer, cash, bill payments, refunds and cheques.
+	EventAccountPosted DomainEventKind = "account.posted"
+)
+
+// DomainEvent is something that happened to the ledger. Transfer events
+// are published for the sending account; events about the whole ledger,
+// such as the end of day, for account 0.
+type DomainEvent struct {
+	Kind        DomainEventKind
+	AccountID   int
+	Transfer    *Transfer
+	Amount      Money
+	Description string
+	EndOfDay    *EndOfDayRun
+	Review      *ReviewCase
+	OccurredAt  time.Time
+}
+
+// EventHandler reacts to an event. Errors are logged and counted; the
+// event isn't delivered again.
+type EventHandler func(DomainEvent) error
+
+var eventBusStats = expvar.NewMap("event_bus")
+
+// EventBus delivers domain events to subscribers in-process, off the path
+// of the request that caused them. Each subscriber gets its own queues,
+// sharded by account, so the events of an account reach a subscriber in
+// the order they were published while a slow subscriber only delays
+// itself.
+type EventBus struct {
+	Shards    int
+	QueueSize int
+
+	mu            sync.RWMutex
+	subscriptions []*subscription
+	started       bool
+}
+
+type subscription struct {
+	name   string
+	kinds  map[DomainEventKind]bool
+	handle EventHandler
+	shards []chan DomainEvent
+}
+
+// eventBusFromEnv sizes the bus from EVENT_BUS_SHARDS and
+// EVENT_BUS_QUEUE_SIZE.
+func eventBusFromEnv() *EventBus {
+	return &EventBus{
+		Shards:    int(getEnvInt("EVENT_BUS_SHARDS", 4)),
+		QueueSize: int(getEnvInt("EVENT_BUS_QUEUE_SIZE", 1000)),
+	}
+}
+
+// Subscribe registers handler under name for events of kinds, or of every
+// kind when none are given. Subscribing after Start is an error in the
+// wiring, so it panics.
+func (b *EventBus) Subscribe(name string, handler EventHandler, kinds ...DomainEventKind) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started {
+		panic("event bus: subscribing " + name + " after start")
+	}
+	sub := &subscription{name: name, kinds: map[DomainEventKind]bool{}, handle: handler}
+	for _, kind := range kinds {
+		sub.kinds[kind] = true
+	}
+	shards := b.Shards
+	if shards < 1 {
+		shards = 1
+	}
+	for i := 0; i < shards; i++ {
+		sub.shards = append(sub.shards, make(chan DomainEvent, b.QueueSize))
+	}
+	b.subscriptions = append(b.subscriptions, sub)
+}
+
+// Start launches a worker per shard of every subscriber. Events published
+// before wait in the queues.
+func (b *EventBus) Start() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.started = true
+	for _, sub := range b.subscriptions {
+		for _, shard := range sub.shards {
+			go sub.work(shard)
+		}
+	}
+}
+
+func (s *subscription) work(shard chan DomainEvent) {
+	for e := range shard {
+		s.deliver(e)
+	}
+}
+
+func (s *subscription) deliver(e DomainEvent) {
+	if err := s.handle(e); err != nil {
+		eventBusStats.Add("failed."+s.name, 1)
+		log.Printf("Subscriber %s failed to handle %s of account %d: %v\n", s.name, e.Kind, e.AccountID, err)
+		return
+	}
+	eventBusStats.Add("delivered."+s.name, 1)
+}
+
+// Publish queues e for every subscriber interested in its kind. It never
+// blocks the publisher: a subscriber whose queue is full misses the event.
+// A nil bus drops everything, so storage used outside a server needs no
+// bus.
+func (b *EventBus) Publish(e DomainEvent) {
+	if b == nil {
+		return
+	}
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now().UTC()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	eventBusStats.Add("published", 1)
+	for _, sub := range b.subscriptions {
+		if len(sub.kinds) > 0 && !sub.kinds[e.Kind] {
+			continue
+		}
+		select {
+		case sub.shards[shardOf(e.AccountID, len(sub.shards))] <- e:
+		default:
+			eventBusStats.Add("dropped."+sub.name, 1)
+			log.Printf("Subscriber %s is behind, dropping %s of account %d\n", sub.name, e.Kind, e.AccountID)
+		}
+	}
+}
+
+func shardOf(accountID, shards int) int {
+	if accountID < 0 {
+		accountID = -accountID
+	}
+	return accountID % shards
+}
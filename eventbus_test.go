@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventBusDeliversInOrderPerAccount(t *testing.T) {
+	bus := &EventBus{Shards: 3, QueueSize: 100}
+
+	var mu sync.Mutex
+	seen := map[int][]int64{}
+	bus.Subscribe("recorder", func(e DomainEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[e.AccountID] = append(seen[e.AccountID], e.Amount.Amount)
+		return nil
+	}, EventAccountPosted)
+	bus.Start()
+	assert.Panics(t, func() { bus.Subscribe("late", func(DomainEvent) error { return nil }) })
+
+	for i := int64(1); i <= 20; i++ {
+		for accountID := 1; accountID <= 4; accountID++ {
+			bus.Publish(DomainEvent{Kind: EventAccountPosted, AccountID: accountID, Amount: NewMoney(i, defaultCurrency)})
+		}
+	}
+	bus.Publish(DomainEvent{Kind: EventTransferFailed, AccountID: 1})
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for accountID := 1; accountID <= 4; accountID++ {
+			if len(seen[accountID]) < 20 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 5*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for accountID := 1; accountID <= 4; accountID++ {
+		assert.Len(t, seen[accountID], 20, "kinds not subscribed to aren't delivered")
+		for i, amount := range seen[accountID] {
+			assert.Equal(t, int64(i+1), amount)
+		}
+	}
+}
+
+func TestAlertingStoragePublishesTransfers(t *testing.T) {
+	bus := &EventBus{Shards: 1, QueueSize: 10}
+	events := make(chan DomainEvent, 10)
+	bus.Subscribe("recorder", func(e DomainEvent) error {
+		events <- e
+		return nil
+	})
+	bus.Start()
+
+	store := NewAlertingStorage(NewMemoryStorage(), &recordingNotifier{})
+	store.events = bus
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+
+	transfer := NewTransfer(from.ID, to.ID, NewMoney(400, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(transfer))
+	assert.Nil(t, store.ExecuteTransfer(transfer))
+
+	kinds := []DomainEventKind{}
+	for i := 0; i < 3; i++ {
+		select {
+		case e := <-events:
+			kinds = append(kinds, e.Kind)
+			assert.Equal(t, transfer.PublicID, e.Transfer.PublicID)
+		case <-time.After(time.Second):
+			t.Fatal("event not delivered")
+		}
+	}
+	assert.Equal(t, []DomainEventKind{EventTransferSettled, EventAccountPosted, EventAccountPosted}, kinds)
+}
+
+func TestNotifyTransferOutcome(t *testing.T) {
+	notifier := &recordingNotifier{}
+	notify := notifyTransferOutcome(notifier)
+
+	transfer := NewTransfer(1, 2, NewMoney(400, defaultCurrency))
+	assert.Nil(t, notify(DomainEvent{Kind: EventTransferSettled, AccountID: 1, Transfer: transfer}))
+	transfer.Status, transfer.FailureReason = TransferFailed, "insufficient funds"
+	assert.Nil(t, notify(DomainEvent{Kind: EventTransferFailed, AccountID: 1, Transfer: transfer}))
+	transfer.Reference = sweepTransferReference
+	assert.Nil(t, notify(DomainEvent{Kind: EventTransferSettled, AccountID: 1, Transfer: transfer}))
+
+	assert.Len(t, notifier.sent, 2)
+	assert.Equal(t, 2, notifier.sent[0].AccountID)
+	assert.Equal(t, NotifyTransferReceived, notifier.sent[0].Kind)
+	assert.Equal(t, 1, notifier.sent[1].AccountID)
+	assert.Equal(t, NotifyTransferFailed, notifier.sent[1].Kind)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+)
+
+// EventType describes an event integrators can receive. Events are the
+// notifications the bank sends customers, and their payload is the
+// Notification. Bump Version when a payload changes in a way a consumer
+// validating against the previous schema would reject, and keep the old
+// version documented until consumers moved on.
+type EventType struct {
+	Name        NotificationKind     `json:"name"`
+	Description string               `json:"description"`
+	Version     int                  `json:"version"`
+	Priority    NotificationPriority `json:"priority"`
+	Schema      map[string]any       `json:"schema"`
+}
+
+type EventCatalog struct {
+	EventTypes []EventType `json:"eventTypes"`
+}
+
+// eventTypes lists every notification kind the server sends. Kinds built
+// at runtime, from alert rule kinds and document kinds, are spelled out.
+var eventTypes = []EventType{
+	{Name: NotifyNewDeviceLogin, Description: "Someone signed in from a device the account hasn't used before.", Version: 1},
+	{Name: NotifyCashDeposit, Description: "Cash was deposited at a terminal.", Version: 1},
+	{Name: NotifyCashWithdrawal, Description: "Cash was withdrawn at a terminal.", Version: 1},
+	{Name: NotifyTransferHeld, Description: "A transfer is held for review.", Version: 1},
+	{Name: NotifyTransferApproved, Description: "A held transfer was approved and sent.", Version: 1},
+	{Name: NotifyTransferDeclined, Description: "A held transfer was declined.", Version: 1},
+	{Name: NotifyTransferReceived, Description: "Money from a transfer arrived in the account.", Version: 1},
+	{Name: NotifyTransferFailed, Description: "A transfer failed and no money left the account.", Version: 1},
+	{Name: NotifyBillPaymentSent, Description: "A bill payment left the account.", Version: 1},
+	{Name: NotifyBillPaymentConfirmed, Description: "The biller confirmed a bill payment.", Version: 1},
+	{Name: NotifyBillPaymentFailed, Description: "A bill payment failed or was refunded.", Version: 1},
+	{Name: NotifyChequeCleared, Description: "A deposited cheque cleared.", Version: 1},
+	{Name: NotifyChequeBounced, Description: "A deposited cheque bounced.", Version: 1},
+	{Name: NotifySweepExecuted, Description: "A sweep rule moved money.", Version: 1},
+	{Name: NotifyAutomation, Description: "An automation rule sent its message.", Version: 1},
+	{Name: NotifyWebhookDisabled, Description: "A webhook endpoint was disabled after failing every delivery for too long.", Version: 1},
+	{Name: "alert." + NotificationKind(AlertBalanceBelow), Description: "The balance fell below an alert threshold.", Version: 1},
+	{Name: "alert." + NotificationKind(AlertDebitAbove), Description: "A debit exceeded an alert threshold.", Version: 1},
+	{Name: "alert." + NotificationKind(AlertForeignCurrency), Description: "A posting was in a foreign currency.", Version: 1},
+	{Name: NotificationKind(DocumentStatement) + ".available", Description: "A statement is ready to download.", Version: 1},
+	{Name: NotificationKind(DocumentReceipt) + ".available", Description: "A receipt is ready to download.", Version: 1},
+	{Name: NotificationKind(DocumentNotice) + ".available", Description: "A notice is ready to download.", Version: 1},
+	{Name: NotificationKind(DocumentStatement) + ".corrected", Description: "A statement was regenerated with corrections.", Version: 1},
+}
+
+// eventSchema is the JSON Schema of the Notification payload of an event
+// of kind, with kind pinned to its name.
+func eventSchema(kind NotificationKind) map[string]any {
+	schema := openAPISchemas{}.object(reflect.TypeOf(Notification{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["properties"].(map[string]any)["kind"] = map[string]any{"type": "string", "enum": []string{string(kind)}}
+	return schema
+}
+
+func eventCatalog() EventCatalog {
+	catalog := EventCatalog{EventTypes: make([]EventType, len(eventTypes))}
+	for i, t := range eventTypes {
+		t.Priority = notificationPriority(t.Name)
+		t.Schema = eventSchema(t.Name)
+		catalog.EventTypes[i] = t
+	}
+	return catalog
+}
+
+// HandleEventTypes serves the event catalog, so integrators can validate
+// payloads and notice when an event's version changes.
+func (s *APIServer) HandleEventTypes(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+	return writeJSON(w, http.StatusOK, eventCatalog())
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventCatalog(t *testing.T) {
+	router := NewAPIServer(":0", NewMemoryStorage()).Router()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/webhooks/event-types", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	catalog := new(EventCatalog)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(catalog))
+	assert.Len(t, catalog.EventTypes, len(eventTypes))
+
+	payload, err := json.Marshal(NewNotification(1, NotifyCashDeposit, "Cash deposit"))
+	assert.Nil(t, err)
+	var fields map[string]any
+	assert.Nil(t, json.Unmarshal(payload, &fields))
+	keys := []string{}
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	seen := map[NotificationKind]bool{}
+	for _, e := range catalog.EventTypes {
+		assert.False(t, seen[e.Name], "%s is listed twice", e.Name)
+		seen[e.Name] = true
+		assert.Positive(t, e.Version)
+		assert.NotEmpty(t, e.Description)
+
+		// Every payload field is described and required.
+		required := []string{}
+		for _, k := range e.Schema["required"].([]any) {
+			required = append(required, k.(string))
+		}
+		assert.Equal(t, keys, required, e.Name)
+		kind := e.Schema["properties"].(map[string]any)["kind"].(map[string]any)
+		assert.Equal(t, []any{string(e.Name)}, kind["enum"])
+	}
+	assert.True(t, seen[NotifyCashDeposit])
+	assert.True(t, seen["alert.balance_below"])
+	assert.True(t, seen["statement.available"])
+}
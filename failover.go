@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrPrimaryUnavailable is returned for writes while reads are served by
+// the standby.
+var ErrPrimaryUnavailable = errors.New("primary database unavailable, try again later")
+
+// storageFailoverStats exports which database serves reads and how often
+// that changed.
+var storageFailoverStats = expvar.NewMap("storage_failover")
+
+type StorageState string
+
+const (
+	StoragePrimary    StorageState = "primary"
+	StorageFailedOver StorageState = "failed_over"
+)
+
+// failoverDB sends queries to the primary and, once the primary has failed
+// FailAfter health checks in a row, sends reads to the standby instead. It
+// fails back once the primary passed RecoverAfter checks in a row, so a
+// flapping primary doesn't bounce reads back and forth.
+//
+// Writes aren't queued while failed over: most of them return ids or
+// balances the caller needs right away, and replaying them later could
+// break the invariants the transactions checked. They get
+// ErrPrimaryUnavailable, or for the inserts returning a row, whatever
+// error the primary gives.
+type failoverDB struct {
+	FailAfter     int
+	RecoverAfter  int
+	CheckInterval time.Duration
+	// Prepare runs the queries of the transfer hot path as prepared
+	// statements.
+	Prepare bool
+
+	primary      *sql.DB
+	standby      *sql.DB
+	primaryStmts *stmtCache
+	standbyStmts *stmtCache
+
+	mu        sync.RWMutex
+	state     StorageState
+	since     time.Time
+	failures  int
+	successes int
+}
+
+func newFailoverDB(primary, standby *sql.DB) *failoverDB {
+	f := &failoverDB{
+		FailAfter:     int(getEnvInt("FAILOVER_AFTER_FAILURES", 5)),
+		RecoverAfter:  int(getEnvInt("FAILBACK_AFTER_SUCCESSES", 10)),
+		CheckInterval: getEnvDuration("FAILOVER_CHECK_INTERVAL", time.Second),
+		Prepare:       getEnvBool("PREPARED_STATEMENTS", true),
+		primary:       primary,
+		primaryStmts:  newStmtCache(primary),
+		state:         StoragePrimary,
+		since:         time.Now().UTC(),
+	}
+	f.setStandby(standby)
+	storageFailoverStats.Set("state", expvar.Func(func() any { return f.State() }))
+	storageFailoverStats.Set("since", expvar.Func(func() any {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+		return f.since
+	}))
+	storageFailoverStats.Set("standby_configured", expvar.Func(func() any { return f.standby != nil }))
+	return f
+}
+
+// openStandby connects to the standby in STANDBY_DATABASE_URL, if any. A
+// standby that is down at startup is only logged; it is needed only once
+// the primary fails.
+func openStandby() (*sql.DB, error) {
+	connStr := getEnv("STANDBY_DATABASE_URL", "")
+	if connStr == "" {
+		return nil, nil
+	}
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		log.Println("Standby database unreachable: ", err)
+	}
+	return db, nil
+}
+
+// setStandby sets the database to fail over to, nil for none.
+func (f *failoverDB) setStandby(standby *sql.DB) {
+	f.standby = standby
+	if standby != nil {
+		f.standbyStmts = newStmtCache(standby)
+	}
+}
+
+// Run checks the primary until the process exits. Without a standby there
+// is nothing to fail over to.
+func (f *failoverDB) Run() {
+	if f.standby == nil {
+		return
+	}
+
+	ticker := time.NewTicker(f.CheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		f.observe(f.primary.Ping())
+	}
+}
+
+// observe records the outcome of one health check of the primary.
+func (f *failoverDB) observe(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err != nil {
+		f.failures++
+		f.successes = 0
+		if f.state == StoragePrimary && f.failures >= f.FailAfter {
+			log.Printf("Primary database failed %d health checks, serving reads from the standby: %v\n", f.failures, err)
+			f.state, f.since = StorageFailedOver, time.Now().UTC()
+			storageFailoverStats.Add("failovers", 1)
+		}
+		return
+	}
+
+	f.successes++
+	f.failures = 0
+	if f.state == StorageFailedOver && f.successes >= f.RecoverAfter {
+		log.Println("Primary database recovered, failing back")
+		f.state, f.since = StoragePrimary, time.Now().UTC()
+		storageFailoverStats.Add("failbacks", 1)
+	}
+}
+
+func (f *failoverDB) State() StorageState {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.state
+}
+
+func (f *failoverDB) failedOver() bool {
+	return f.State() == StorageFailedOver
+}
+
+// isReadQuery reports whether query only reads, so the standby can run it.
+func isReadQuery(query string) bool {
+	q := strings.ToLower(strings.TrimSpace(query))
+	return strings.HasPrefix(q, "select") && !strings.Contains(q, " for update")
+}
+
+func (f *failoverDB) Exec(query string, args ...any) (sql.Result, error) {
+	return f.ExecContext(context.Background(), query, args...)
+}
+
+func (f *failoverDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return f.QueryContext(context.Background(), query, args...)
+}
+
+func (f *failoverDB) QueryRow(query string, args ...any) *sql.Row {
+	return f.QueryRowContext(context.Background(), query, args...)
+}
+
+func (f *failoverDB) Begin() (*sql.Tx, error) {
+	return f.BeginTx(context.Background(), nil)
+}
+
+func (f *failoverDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if f.failedOver() {
+		return nil, ErrPrimaryUnavailable
+	}
+	return f.primary.ExecContext(ctx, query, args...)
+}
+
+func (f *failoverDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if f.failedOver() {
+		if !isReadQuery(query) {
+			return nil, ErrPrimaryUnavailable
+		}
+		return f.standby.QueryContext(ctx, query, args...)
+	}
+	return f.primary.QueryContext(ctx, query, args...)
+}
+
+func (f *failoverDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if f.failedOver() && isReadQuery(query) {
+		return f.standby.QueryRowContext(ctx, query, args...)
+	}
+	return f.primary.QueryRowContext(ctx, query, args...)
+}
+
+func (f *failoverDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if f.failedOver() {
+		return nil, ErrPrimaryUnavailable
+	}
+	return f.primary.BeginTx(ctx, opts)
+}
+
+// Ping pings the database serving reads, so the load shedder keeps
+// measuring the one requests actually hit.
+func (f *failoverDB) Ping() error {
+	if f.failedOver() {
+		return f.standby.Ping()
+	}
+	return f.primary.Ping()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailoverStateMachine(t *testing.T) {
+	t.Setenv("FAILOVER_AFTER_FAILURES", "3")
+	t.Setenv("FAILBACK_AFTER_SUCCESSES", "2")
+	f := newFailoverDB(nil, nil)
+	down := errors.New("connection refused")
+
+	f.observe(down)
+	f.observe(down)
+	f.observe(nil)
+	f.observe(down)
+	f.observe(down)
+	assert.Equal(t, StoragePrimary, f.State(), "failures must be consecutive")
+	f.observe(down)
+	assert.Equal(t, StorageFailedOver, f.State())
+
+	f.observe(nil)
+	f.observe(down)
+	f.observe(nil)
+	assert.Equal(t, StorageFailedOver, f.State(), "a flapping primary doesn't get reads back")
+	f.observe(nil)
+	assert.Equal(t, StoragePrimary, f.State())
+}
+
+func TestFailoverRejectsWrites(t *testing.T) {
+	t.Setenv("FAILOVER_AFTER_FAILURES", "1")
+	f := newFailoverDB(nil, nil)
+	f.observe(errors.New("connection refused"))
+
+	_, err := f.Exec("update account set balance = 0")
+	assert.ErrorIs(t, err, ErrPrimaryUnavailable)
+	_, err = f.Query("update transfer set status = $1 returning id", TransferProcessing)
+	assert.ErrorIs(t, err, ErrPrimaryUnavailable)
+	_, err = f.Begin()
+	assert.ErrorIs(t, err, ErrPrimaryUnavailable)
+
+	assert.True(t, isReadQuery("\n\t\tselect id from account"))
+	assert.False(t, isReadQuery("select id from account where id = $1 for update"))
+	assert.False(t, isReadQuery("insert into account (id) values ($1) returning id"))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// fakeEpoch is when every seeded account was created, so responses from
+// gobank-fake are the same from one run to the next.
+var fakeEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeEnv is what gobank-fake runs with unless the environment says
+// otherwise: known secrets, so integrators can mint or reuse tokens, and
+// sandbox mode without the settlement delay.
+var fakeEnv = map[string]string{
+	"JWT_SECRET":           "gobank-fake-secret",
+	"ADMIN_TOKEN":          "gobank-fake-admin",
+	"APP_ENV":              "sandbox",
+	"SANDBOX_SETTLE_DELAY": "0s",
+}
+
+var fakeAccounts = []struct {
+	FirstName, LastName string
+	Number              int32
+	Balance             int64
+	Business            bool
+	Phone               string
+}{
+	{"Alice", "Fake", 100001, 1_000_00, false, "+15550000001"},
+	{"Bob", "Fake", 100002, 250_00, false, "+15550000002"},
+	{"Empty", "Fake", 100003, 0, false, ""},
+	{"Acme", "Fake", 100004, 50_000_00, true, ""},
+}
+
+// fakePassword is the password of every seeded account.
+const fakePassword = "password"
+
+// FakeAccount is a seeded account as printed at startup, with a token for
+// the x-jwt-token header.
+type FakeAccount struct {
+	PublicID string `json:"publicId"`
+	Number   int32  `json:"number"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
+// FakeFixture is what gobank-fake prints to stdout once it is seeded.
+type FakeFixture struct {
+	Address    string        `json:"address"`
+	AdminToken string        `json:"adminToken"`
+	Accounts   []FakeAccount `json:"accounts"`
+}
+
+// runFake serves the API from memory, seeded with fakeAccounts, for
+// teams who need a local stand-in for gobank in their own test suites.
+func runFake(out io.Writer) error {
+	for key, value := range fakeEnv {
+		if _, ok := os.LookupEnv(key); !ok {
+			os.Setenv(key, value)
+		}
+	}
+
+	store := NewMemoryStorage()
+	accounts, err := seedFake(store)
+	if err != nil {
+		return err
+	}
+
+	address := getEnv("FAKE_ADDR", ":3000")
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(FakeFixture{Address: address, AdminToken: getAdminSecret(), Accounts: accounts}); err != nil {
+		return err
+	}
+
+	log.Println("Serving fake gobank with", len(accounts), "seeded accounts")
+	NewAPIServer(address, store).Run()
+	return nil
+}
+
+// seedFake creates fakeAccounts and the system accounts in store. Seeded
+// into an empty store, the accounts and their tokens come out the same
+// every time.
+func seedFake(store Storage) ([]FakeAccount, error) {
+	seeded := make([]FakeAccount, 0, len(fakeAccounts))
+	for i, f := range fakeAccounts {
+		account, err := NewAccount(f.FirstName, f.LastName, fakePassword)
+		if err != nil {
+			return nil, err
+		}
+		account.PublicID = fmt.Sprintf("01JFAKE%019d", i+1)
+		account.Number = f.Number
+		account.Balance.Amount = f.Balance
+		account.Business = f.Business
+		account.Phone = f.Phone
+		account.CreatedAt = fakeEpoch
+		if err := store.CreateAccount(account); err != nil {
+			return nil, err
+		}
+
+		token, err := createJWT(account)
+		if err != nil {
+			return nil, err
+		}
+		seeded = append(seeded, FakeAccount{
+			PublicID: account.PublicID,
+			Number:   account.Number,
+			Name:     f.FirstName + " " + f.LastName,
+			Password: fakePassword,
+			Token:    token,
+		})
+	}
+
+	if err := ensureSystemAccounts(store, defaultCurrency); err != nil {
+		return nil, err
+	}
+	return seeded, nil
+}
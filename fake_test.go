@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeedFakeIsDeterministic(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	first, err := seedFake(NewMemoryStorage())
+	assert.Nil(t, err)
+	store := NewMemoryStorage()
+	second, err := seedFake(store)
+	assert.Nil(t, err)
+	assert.Equal(t, first, second)
+	assert.Len(t, second, len(fakeAccounts))
+
+	router := NewAPIServer(":0", store).Router()
+	alice := second[0]
+	assert.True(t, isULID(alice.PublicID))
+
+	req := httptest.NewRequest(http.MethodGet, "/account/"+alice.PublicID+"/balance", nil)
+	req.Header.Set("x-jwt-token", alice.Token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"amount":100000`)
+
+	body := `{"number":` + strconv.Itoa(int(alice.Number)) + `,"password":"` + alice.Password + `"}`
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const timeOfDayLayout = "15:04"
+
+// FreezeWindow blocks debits from an account while it is active. It is
+// either a one-off period from StartsAt to EndsAt, e.g. a vacation, or a
+// daily window from DailyFrom to DailyTo ("HH:MM") in Timezone. A daily
+// window whose end is before its start runs past midnight.
+type FreezeWindow struct {
+	ID        int        `json:"id"`
+	AccountID int        `json:"-"`
+	StartsAt  *time.Time `json:"startsAt,omitempty"`
+	EndsAt    *time.Time `json:"endsAt,omitempty"`
+	DailyFrom string     `json:"dailyFrom,omitempty"`
+	DailyTo   string     `json:"dailyTo,omitempty"`
+	Timezone  string     `json:"timezone,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type FreezeWindowRequest struct {
+	StartsAt  *time.Time `json:"startsAt,omitempty"`
+	EndsAt    *time.Time `json:"endsAt,omitempty"`
+	DailyFrom string     `json:"dailyFrom,omitempty"`
+	DailyTo   string     `json:"dailyTo,omitempty"`
+	Timezone  string     `json:"timezone,omitempty"`
+}
+
+// newFreezeWindow validates req. Exactly one of the one-off period and the
+// daily window has to be given.
+func newFreezeWindow(accountID int, req *FreezeWindowRequest) (*FreezeWindow, error) {
+	w := &FreezeWindow{AccountID: accountID, CreatedAt: time.Now().UTC()}
+
+	oneOff := req.StartsAt != nil || req.EndsAt != nil
+	daily := req.DailyFrom != "" || req.DailyTo != ""
+	switch {
+	case oneOff == daily:
+		return nil, ApiError{Err: "give either startsAt and endsAt or dailyFrom and dailyTo", Status: http.StatusBadRequest}
+	case oneOff:
+		if req.StartsAt == nil || req.EndsAt == nil || !req.EndsAt.After(*req.StartsAt) {
+			return nil, ApiError{Err: "endsAt must be after startsAt", Status: http.StatusBadRequest}
+		}
+		startsAt, endsAt := req.StartsAt.UTC(), req.EndsAt.UTC()
+		w.StartsAt, w.EndsAt = &startsAt, &endsAt
+	default:
+		for _, v := range []string{req.DailyFrom, req.DailyTo} {
+			if _, err := time.Parse(timeOfDayLayout, v); err != nil {
+				return nil, ApiError{Err: fmt.Sprintf("invalid time of day: %q", v), Status: http.StatusBadRequest}
+			}
+		}
+		if req.DailyFrom == req.DailyTo {
+			return nil, ApiError{Err: "dailyFrom and dailyTo must differ", Status: http.StatusBadRequest}
+		}
+		w.Timezone = req.Timezone
+		if w.Timezone == "" {
+			w.Timezone = "UTC"
+		}
+		if _, err := time.LoadLocation(w.Timezone); err != nil || w.Timezone == "Local" {
+			return nil, ApiError{Err: fmt.Sprintf("unknown timezone: %s", w.Timezone), Status: http.StatusBadRequest}
+		}
+		w.DailyFrom, w.DailyTo = req.DailyFrom, req.DailyTo
+	}
+
+	return w, nil
+}
+
+// Active reports whether the window blocks debits at t.
+func (w *FreezeWindow) Active(t time.Time) bool {
+	if w.StartsAt != nil && w.EndsAt != nil {
+		return !t.Before(*w.StartsAt) && t.Before(*w.EndsAt)
+	}
+
+	loc, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	now := t.In(loc).Format(timeOfDayLayout)
+	if w.DailyFrom < w.DailyTo {
+		return now >= w.DailyFrom && now < w.DailyTo
+	}
+	return now >= w.DailyFrom || now < w.DailyTo
+}
+
+// activeFreezeWindow returns the first of windows active at t, if any.
+func activeFreezeWindow(windows []*FreezeWindow, t time.Time) *FreezeWindow {
+	for _, w := range windows {
+		if w.Active(t) {
+			return w
+		}
+	}
+	return nil
+}
+
+// checkDebitsAllowed is called on every path that takes money out of an
+// account on the customer's behalf, to refuse the request up front.
+// ExecuteTransfer checks again when the money moves, and fails transfers
+// that come due during a freeze.
+func (s *APIServer) checkDebitsAllowed(accountID int, now time.Time) error {
+	windows, err := s.storage.GetFreezeWindows(accountID)
+	if err != nil {
+		return err
+	}
+	if w := activeFreezeWindow(windows, now); w != nil {
+		return fmt.Errorf("%w: freeze window %d", ErrDebitsFrozen, w.ID)
+	}
+	return nil
+}
+
+func (s *APIServer) HandleFreezeWindows(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		windows, err := s.storage.GetFreezeWindows(id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, windows)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(FreezeWindowRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	window, err := newFreezeWindow(id, req)
+	if err != nil {
+		return err
+	}
+	if err := s.storage.CreateFreezeWindow(window); err != nil {
+		return err
+	}
+	if err := s.recordAccountEvent(r, "freeze.created", id, map[string]string{"window": strconv.Itoa(window.ID)}); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, window)
+}
+
+func (s *APIServer) HandleFreezeWindow(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	windowID, err := getIntVar(r, "windowID")
+	if err != nil {
+		return err
+	}
+
+	window, err := s.storage.GetFreezeWindow(id, windowID)
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return writeJSON(w, http.StatusOK, window)
+	case http.MethodDelete:
+		if err := s.storage.DeleteFreezeWindow(id, windowID); err != nil {
+			return err
+		}
+		if err := s.recordAccountEvent(r, "freeze.deleted", id, map[string]string{"window": strconv.Itoa(windowID)}); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, map[string]int{"deleted": windowID})
+	}
+
+	return methodNotAllowed
+}
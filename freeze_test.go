@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreezeWindowActive(t *testing.T) {
+	night, err := newFreezeWindow(1, &FreezeWindowRequest{DailyFrom: "23:00", DailyTo: "06:00", Timezone: "Europe/Berlin"})
+	assert.Nil(t, err)
+	// 23:30 and 05:59 in Berlin (UTC+1 in January) are frozen, noon isn't.
+	assert.True(t, night.Active(time.Date(2024, 1, 10, 22, 30, 0, 0, time.UTC)))
+	assert.True(t, night.Active(time.Date(2024, 1, 10, 4, 59, 0, 0, time.UTC)))
+	assert.False(t, night.Active(time.Date(2024, 1, 10, 11, 0, 0, 0, time.UTC)))
+
+	from := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 14)
+	vacation, err := newFreezeWindow(1, &FreezeWindowRequest{StartsAt: &from, EndsAt: &to})
+	assert.Nil(t, err)
+	assert.True(t, vacation.Active(from))
+	assert.False(t, vacation.Active(to))
+
+	_, err = newFreezeWindow(1, &FreezeWindowRequest{StartsAt: &from, EndsAt: &to, DailyFrom: "00:00", DailyTo: "06:00"})
+	assert.NotNil(t, err)
+	_, err = newFreezeWindow(1, &FreezeWindowRequest{DailyFrom: "25:00", DailyTo: "06:00"})
+	assert.NotNil(t, err)
+}
+
+func TestFreezeWindowBlocksTransfers(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+	token, err := createJWT(from)
+	assert.Nil(t, err)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	starts, ends := time.Now().UTC().Add(-time.Hour), time.Now().UTC().Add(time.Hour)
+	rec := post("/account/"+from.PublicID+"/freezes",
+		`{"startsAt":"`+starts.Format(time.RFC3339)+`","endsAt":"`+ends.Format(time.RFC3339)+`"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = post("/transfer", `{"toAccount":`+strconv.Itoa(to.ID)+`,"amount":{"amount":100}}`)
+	assert.Equal(t, http.StatusLocked, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrDebitsFrozen.Error())
+	assert.Equal(t, int64(1000), balanceOf(t, store, from.ID))
+}
+
+func TestFreezeWindowFailsTransfersAcceptedBefore(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	server := NewAPIServer(":0", store)
+	router := server.Router()
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+	token, err := createJWT(from)
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/transfer", strings.NewReader(`{"toAccount":`+strconv.Itoa(to.ID)+`,"amount":{"amount":100}}`))
+	req.Header.Set("x-jwt-token", token)
+	req.Header.Set("Prefer", "respond-async")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	// The customer freezes the account before the processor gets to it.
+	starts, ends := time.Now().UTC().Add(-time.Minute), time.Now().UTC().Add(time.Hour)
+	assert.Nil(t, store.CreateFreezeWindow(&FreezeWindow{AccountID: from.ID, StartsAt: &starts, EndsAt: &ends, CreatedAt: starts}))
+	server.transfers.processAll()
+
+	transfers, err := store.GetTransfers(TransferFilter{AccountID: &from.ID}, PageQuery{Before: time.Now().UTC().Add(time.Hour), BeforeID: math.MaxInt32, Limit: 10})
+	assert.Nil(t, err)
+	if assert.Len(t, transfers, 1) {
+		assert.Equal(t, TransferFailed, transfers[0].Status)
+		assert.Equal(t, ErrDebitsFrozen.Error(), transfers[0].FailureReason)
+	}
+	assert.Equal(t, int64(1000), balanceOf(t, store, from.ID))
+	assert.Equal(t, int64(0), balanceOf(t, store, to.ID))
+}
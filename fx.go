@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FXQuote locks the rate for converting From into To for a while. A
+// transfer referencing it credits the recipient at exactly that rate, as
+// long as it is sent before ExpiresAt. Each quote pays for one transfer.
+type FXQuote struct {
+	ID        int    `json:"id"`
+	PublicID  string `json:"publicId"`
+	AccountID int    `json:"-"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	// Rate is how many units of To one unit of From buys, as an exact
+	// decimal.
+	Rate       string     `json:"rate"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	TransferID string     `json:"transferId,omitempty"`
+	UsedAt     *time.Time `json:"usedAt,omitempty"`
+}
+
+type FXQuoteRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// fxRates parses FX_RATES, a comma separated list of FROM/TO=rate pairs
+// such as "USD/EUR=0.9231,EUR/USD=1.0834". Pairs are quoted only in the
+// direction listed. Rates are kept as the decimals given, so quotes show
+// exactly the rate they convert at.
+func fxRates() map[string]string {
+	rates := map[string]string{}
+	for _, pair := range strings.Split(getEnv("FX_RATES", ""), ",") {
+		name, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		if rate, err := ParseRate(v); err != nil || rate.Num <= 0 {
+			log.Printf("Ignoring FX_RATES entry %q", pair)
+			continue
+		}
+		rates[strings.ToUpper(name)] = v
+	}
+	return rates
+}
+
+func fxQuoteTTL() time.Duration {
+	return getEnvDuration("FX_QUOTE_TTL", 30*time.Second)
+}
+
+func (s *APIServer) HandleCreateFXQuote(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(FXQuoteRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	from, to := strings.ToUpper(req.From), strings.ToUpper(req.To)
+	if from == to {
+		return ApiError{Err: "from and to must be different currencies", Status: http.StatusBadRequest}
+	}
+	rate, ok := fxRates()[from+"/"+to]
+	if !ok {
+		return ApiError{Err: "no rate for " + from + "/" + to, Status: http.StatusUnprocessableEntity}
+	}
+
+	now := time.Now().UTC()
+	q := &FXQuote{
+		PublicID:  NewULID(),
+		AccountID: accountFromContext(r).ID,
+		From:      from,
+		To:        to,
+		Rate:      rate,
+		CreatedAt: now,
+		ExpiresAt: now.Add(fxQuoteTTL()),
+	}
+	if err := s.storage.CreateFXQuote(q); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, q)
+}
+
+// quotedCredit checks the quote req references against the transfer and
+// returns it with what the recipient is credited. The quote has to be the
+// sender's, unused and unexpired, and match both currencies.
+func (s *APIServer) quotedCredit(from, to *Account, req *TransferRequest) (*FXQuote, Money, error) {
+	q, err := s.storage.GetFXQuote(req.QuoteID)
+	if err != nil || q.AccountID != from.ID {
+		return nil, Money{}, ApiError{Err: "quote not found", Status: http.StatusNotFound}
+	}
+	if q.TransferID != "" {
+		return nil, Money{}, ApiError{Err: "quote was already used", Status: http.StatusConflict}
+	}
+	if !time.Now().Before(q.ExpiresAt) {
+		return nil, Money{}, ApiError{Err: "quote expired", Status: http.StatusUnprocessableEntity}
+	}
+	if q.From != req.Amount.Currency || q.To != to.Balance.Currency {
+		return nil, Money{}, ApiError{Err: "quote is for " + q.From + "/" + q.To + ", transfer is " +
+			req.Amount.Currency + "/" + to.Balance.Currency, Status: http.StatusBadRequest}
+	}
+
+	rate, err := ParseRate(q.Rate)
+	if err != nil {
+		return nil, Money{}, err
+	}
+	credit, err := req.Amount.Convert(q.To, rate)
+	if err != nil {
+		return nil, Money{}, err
+	}
+	return q, credit, nil
+}
+
+// failQuotedTransfer fails a transfer whose quote couldn't be tied to it,
+// typically because another transfer used the quote first.
+func (s *APIServer) failQuotedTransfer(t *Transfer, err error) error {
+	reason := err.Error()
+	if errors.Is(err, ErrStateConflict) {
+		err = ApiError{Err: "quote was already used", Status: http.StatusConflict}
+		reason = "quote was already used"
+	}
+	if failErr := s.storage.FailTransfer(t, reason); failErr != nil {
+		log.Printf("Failed to fail transfer %s: %v\n", t.PublicID, failErr)
+	}
+	return err
+}
+
+// useFXQuote ties the quote to the transfer, so it can't pay for another
+// one, and audits the rate the customer got.
+func (s *APIServer) useFXQuote(r *http.Request, q *FXQuote, t *Transfer) error {
+	now := time.Now().UTC()
+	q.TransferID, q.UsedAt = t.PublicID, &now
+	if err := s.storage.UseFXQuote(q); err != nil {
+		return err
+	}
+
+	event := NewAuditEvent(principalFromContext(r).consumer(), "fx.quote_used", t.FromAccount, map[string]string{
+		"quote":    q.PublicID,
+		"transfer": t.PublicID,
+		"rate":     q.From + "/" + q.To + "=" + q.Rate,
+		"debit":    t.Amount.String(),
+		"credit":   t.Credited().String(),
+	})
+	if err := s.storage.CreateAuditEvent(event); err != nil {
+		log.Println("Failed to audit FX quote use: ", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFXQuoteTransfer(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("FX_RATES", "USD/JPY=151.37")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	sender := createTestAccount(t, store, 100_00)
+	recipient := createTestAccount(t, store, 0)
+	recipient.Balance.Currency = "JPY"
+	store.accounts[recipient.ID].Balance.Currency = "JPY"
+	token, err := createJWT(sender)
+	assert.Nil(t, err)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	quote := func() *FXQuote {
+		rec := post("/fx/quotes", `{"from":"USD","to":"JPY"}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		q := new(FXQuote)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(q))
+		return q
+	}
+	transfer := func(quoteID string) *httptest.ResponseRecorder {
+		return post("/transfer", `{"toAccount":`+strconv.Itoa(recipient.ID)+`,"amount":{"amount":1000},"quoteId":"`+quoteID+`"}`)
+	}
+
+	rec := post("/fx/quotes", `{"from":"JPY","to":"USD"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, "pairs are only quoted in the direction configured")
+
+	q := quote()
+	assert.Equal(t, "151.37", q.Rate)
+	assert.WithinDuration(t, time.Now().Add(30*time.Second), q.ExpiresAt, 5*time.Second)
+
+	// The rate changing after the quote doesn't change what is credited.
+	t.Setenv("FX_RATES", "USD/JPY=140")
+	rec = transfer(q.PublicID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	sent := new(Transfer)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(sent))
+	assert.Equal(t, TransferSettled, sent.Status)
+	assert.Equal(t, &Money{Amount: 1514, Currency: "JPY"}, sent.Credit)
+	assert.Equal(t, q.PublicID, sent.QuoteID)
+	assert.Equal(t, int64(90_00), balanceOf(t, store, sender.ID))
+	assert.Equal(t, int64(1514), balanceOf(t, store, recipient.ID))
+
+	rec = transfer(q.PublicID)
+	assert.Equal(t, http.StatusConflict, rec.Code, "a quote pays for one transfer")
+
+	expired := quote()
+	stored := store.fxQuotes[expired.PublicID]
+	stored.ExpiresAt = time.Now().Add(-time.Second)
+	rec = transfer(expired.PublicID)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "quote expired")
+
+	rec = transfer("01ARZ3NDEKTSV4RRFFQ69G5FAV")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	events, err := store.GetAuditEvents(sender.ID, 10)
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "fx.quote_used", events[0].Action)
+	assert.Equal(t, "USD/JPY=151.37", events[0].Details["rate"])
+	assert.Equal(t, "1514 JPY", events[0].Details["credit"])
+}
+
+// quoteRaceStorage lets a test break or race the transfer insert that a
+// quote is spent after.
+type quoteRaceStorage struct {
+	*MemoryStorage
+	createTransfer func(*Transfer) error
+}
+
+func (s *quoteRaceStorage) CreateTransfer(t *Transfer) error {
+	if s.createTransfer != nil {
+		if err := s.createTransfer(t); err != nil {
+			return err
+		}
+	}
+	return s.MemoryStorage.CreateTransfer(t)
+}
+
+func TestFXQuoteSpentOnlyWithTransfer(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("FX_RATES", "USD/JPY=151.37")
+
+	store := &quoteRaceStorage{MemoryStorage: NewMemoryStorage()}
+	router := NewAPIServer(":0", store).Router()
+	sender := createTestAccount(t, store, 100_00)
+	recipient := createTestAccount(t, store, 0)
+	store.accounts[recipient.ID].Balance.Currency = "JPY"
+	token, err := createJWT(sender)
+	assert.Nil(t, err)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := post("/fx/quotes", `{"from":"USD","to":"JPY"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	q := new(FXQuote)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(q))
+	transfer := func(amount string) *httptest.ResponseRecorder {
+		return post("/transfer", `{"toAccountId":"`+recipient.PublicID+`","amount":{"amount":`+amount+`},"quoteId":"`+q.PublicID+`"}`)
+	}
+
+	// A transfer that never gets stored leaves the quote for the next try.
+	store.createTransfer = func(*Transfer) error { return errors.New("insert failed") }
+	assert.Equal(t, http.StatusInternalServerError, transfer("1000").Code)
+	stored, err := store.GetFXQuote(q.PublicID)
+	assert.Nil(t, err)
+	assert.Empty(t, stored.TransferID)
+
+	// Another transfer spending the quote while this one is stored fails it.
+	var lost *Transfer
+	store.createTransfer = func(t *Transfer) error {
+		lost = t
+		now := time.Now().UTC()
+		return store.UseFXQuote(&FXQuote{PublicID: q.PublicID, TransferID: NewULID(), UsedAt: &now})
+	}
+	rec = transfer("1001")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "quote was already used")
+	failed, err := store.GetTransferByID(lost.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, TransferFailed, failed.Status)
+	assert.Equal(t, "quote was already used", failed.FailureReason)
+	assert.Equal(t, int64(100_00), balanceOf(t, store, sender.ID))
+	assert.Equal(t, int64(0), balanceOf(t, store, recipient.ID))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TransferOrigin is where a transfer was requested from: the client IP and
+// the country it maps to. LoginCountry is the country of the session's
+// last successful login; GeoMismatch is set when the two differ, which
+// usually means a token is being used from somewhere its owner isn't.
+type TransferOrigin struct {
+	TransferID   int       `json:"-"`
+	IP           string    `json:"ip"`
+	Country      string    `json:"country,omitempty"`
+	LoginCountry string    `json:"loginCountry,omitempty"`
+	GeoMismatch  bool      `json:"geoMismatch"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// AdminTransfer is a transfer as back-office views see it, with the origin
+// customers don't get to see of their counterparties.
+type AdminTransfer struct {
+	TransferWithIDs
+	Origin *TransferOrigin `json:"origin,omitempty"`
+}
+
+type geoRange struct {
+	network *net.IPNet
+	country string
+}
+
+// GeoLocator maps client IPs to ISO country codes. It is a plain range
+// table; IPs it doesn't cover locate to "".
+type GeoLocator []geoRange
+
+// geoLocatorFromEnv parses GEOIP_RANGES, a comma separated list of
+// cidr=country pairs such as "81.2.69.0/24=GB".
+func geoLocatorFromEnv() GeoLocator {
+	var g GeoLocator
+	for _, pair := range strings.Split(getEnv("GEOIP_RANGES", ""), ",") {
+		cidr, country, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || country == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Printf("Ignoring GEOIP_RANGES entry %q: %v", pair, err)
+			continue
+		}
+		g = append(g, geoRange{network: network, country: strings.ToUpper(country)})
+	}
+	return g
+}
+
+// Locate returns the country of the narrowest range containing ip.
+func (g GeoLocator) Locate(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+
+	country, best := "", -1
+	for _, r := range g {
+		if ones, _ := r.network.Mask.Size(); r.network.Contains(addr) && ones > best {
+			country, best = r.country, ones
+		}
+	}
+	return country
+}
+
+// recordTransferOrigin stores where the transfer was requested from and
+// checks it against the account's last login. A mismatch is recorded in
+// the audit log; callers decide whether to hold the transfer for review.
+// It returns nil when the origin couldn't be stored.
+func (s *APIServer) recordTransferOrigin(r *http.Request, t *Transfer) *TransferOrigin {
+	origin := &TransferOrigin{
+		TransferID: t.ID,
+		IP:         clientIP(r),
+		CreatedAt:  t.CreatedAt,
+	}
+	origin.Country = s.geo.Locate(origin.IP)
+
+	success := true
+	logins, err := s.storage.GetLoginAttempts(LoginAttemptFilter{AccountID: &t.FromAccount, Success: &success},
+		PageQuery{Before: t.CreatedAt.Add(time.Second), BeforeID: math.MaxInt32, Limit: 1})
+	if err != nil {
+		log.Println("Failed to look up last login: ", err)
+	} else if len(logins) > 0 {
+		origin.LoginCountry = s.geo.Locate(logins[0].IP)
+	}
+	origin.GeoMismatch = origin.Country != "" && origin.LoginCountry != "" && origin.Country != origin.LoginCountry
+
+	if err := s.storage.CreateTransferOrigin(origin); err != nil {
+		log.Println("Failed to store transfer origin: ", err)
+		return nil
+	}
+
+	if origin.GeoMismatch {
+		event := NewAuditEvent("system", "transfer.geo_mismatch", t.FromAccount, map[string]string{
+			"transfer":     t.PublicID,
+			"ip":           origin.IP,
+			"country":      origin.Country,
+			"loginCountry": origin.LoginCountry,
+		})
+		if err := s.storage.CreateAuditEvent(event); err != nil {
+			log.Println("Failed to audit geo mismatch: ", err)
+		}
+	}
+	return origin
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeoLocatorPrefersNarrowestRange(t *testing.T) {
+	t.Setenv("GEOIP_RANGES", "81.0.0.0/8=fr, 81.2.69.0/24=GB, bogus=US")
+	geo := geoLocatorFromEnv()
+
+	assert.Equal(t, "GB", geo.Locate("81.2.69.160"))
+	assert.Equal(t, "FR", geo.Locate("81.3.0.1"))
+	assert.Equal(t, "", geo.Locate("10.0.0.1"))
+	assert.Equal(t, "", geo.Locate("not an ip"))
+}
+
+func TestTransferOriginFlagsGeoMismatch(t *testing.T) {
+	t.Setenv("GEOIP_RANGES", "81.2.69.0/24=GB,216.160.83.0/24=US")
+	store := NewMemoryStorage()
+	server := &APIServer{storage: store, geo: geoLocatorFromEnv()}
+
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+	login := NewLoginAttempt(from.Number, &http.Request{RemoteAddr: "81.2.69.160:5000", Header: http.Header{}})
+	login.AccountID, login.Success = &from.ID, true
+	assert.Nil(t, store.CreateLoginAttempt(login))
+
+	transfer := NewTransfer(from.ID, to.ID, NewMoney(100, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(transfer))
+	r := httptest.NewRequest(http.MethodPost, "/transfer", nil)
+	r.RemoteAddr = "216.160.83.56:4000"
+	server.recordTransferOrigin(r, transfer)
+
+	origins, err := store.GetTransferOrigins([]int{transfer.ID})
+	assert.Nil(t, err)
+	origin := origins[transfer.ID]
+	assert.Equal(t, "216.160.83.56", origin.IP)
+	assert.Equal(t, "US", origin.Country)
+	assert.Equal(t, "GB", origin.LoginCountry)
+	assert.True(t, origin.GeoMismatch)
+
+	events, err := store.GetAuditEvents(from.ID, 10)
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "transfer.geo_mismatch", events[0].Action)
+
+	w := httptest.NewRecorder()
+	assert.Nil(t, server.HandleAdminGetTransfers(w, httptest.NewRequest(http.MethodGet, "/admin/transfers", nil)))
+	assert.Contains(t, w.Body.String(), `"geoMismatch":true`)
+}
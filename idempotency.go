@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// idempotentTransfer returns the transfer an earlier POST /transfer with
+// the same Idempotency-Key header created, so a client retrying after a
+// lost response gets that transfer back instead of sending the money
+// twice. Keys are scoped to the sending account. It returns nil when the
+// request carries no key or the key is new.
+func (s *APIServer) idempotentTransfer(r *http.Request, from *Account, req *TransferRequest) (*Transfer, error) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		return nil, nil
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, ApiError{Err: "Idempotency-Key is too long", Status: http.StatusBadRequest}
+	}
+
+	t, err := s.storage.GetIdempotentTransfer(from.ID, key)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	if t.ToAccount != req.ToAccount || t.Amount != req.Amount || t.Reference != req.Reference {
+		return nil, ApiError{Err: "Idempotency-Key was already used for a different transfer", Status: http.StatusUnprocessableEntity}
+	}
+	return t, nil
+}
+
+// saveIdempotencyKey remembers which transfer the request's key created.
+// Requests to /transfer hold the sender's account lock, so a retry reaching
+// the same instance can't race the first request between lookup and save.
+func (s *APIServer) saveIdempotencyKey(r *http.Request, t *Transfer) error {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		return nil
+	}
+	return s.storage.SaveIdempotencyKey(&IdempotencyKey{AccountID: t.FromAccount, Key: key, TransferID: t.ID, CreatedAt: time.Now().UTC()})
+}
+
+// IdempotencyKey ties a client supplied key to the transfer it created.
+type IdempotencyKey struct {
+	AccountID  int
+	Key        string
+	TransferID int
+	CreatedAt  time.Time
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+type ImpersonationStatus string
+
+const (
+	// ImpersonationPending waits for the customer to consent.
+	ImpersonationPending ImpersonationStatus = "pending"
+	ImpersonationActive  ImpersonationStatus = "active"
+	ImpersonationRevoked ImpersonationStatus = "revoked"
+)
+
+// Impersonation lets a support admin see an account the way its customer
+// does, to troubleshoot an issue they reported. Its token is read-only,
+// works only while the impersonation is active and before ExpiresAt, and
+// every request made with it is audited.
+type Impersonation struct {
+	ID          int                 `json:"id"`
+	PublicID    string              `json:"publicId"`
+	AccountID   int                 `json:"-"`
+	Admin       string              `json:"admin"`
+	Reason      string              `json:"reason"`
+	Status      ImpersonationStatus `json:"status"`
+	CreatedAt   time.Time           `json:"createdAt"`
+	ConsentedAt *time.Time          `json:"consentedAt,omitempty"`
+	ExpiresAt   time.Time           `json:"expiresAt"`
+	RevokedBy   string              `json:"revokedBy,omitempty"`
+	RevokedAt   *time.Time          `json:"revokedAt,omitempty"`
+	// Token is only returned to the admin starting the impersonation.
+	Token string `json:"token,omitempty"`
+}
+
+type ImpersonationRequest struct {
+	Reason string `json:"reason"`
+}
+
+// impersonationTTL is how long an impersonation lasts once active. A
+// pending one has as long to get the customer's consent.
+func impersonationTTL() time.Duration {
+	return getEnvDuration("IMPERSONATION_TTL", 30*time.Minute)
+}
+
+func impersonationConsentRequired() bool {
+	return getEnvBool("IMPERSONATION_CONSENT_REQUIRED", true)
+}
+
+func createImpersonationJWT(account *Account, i *Impersonation) (string, error) {
+	claims := jwt.MapClaims{
+		"accountNumber": account.Number,
+		"tokenVersion":  account.TokenVersion,
+		"scope":         "accounts",
+		"impersonation": i.PublicID,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(getSecret()))
+}
+
+// impersonate checks the impersonation a token was issued for and marks p
+// as impersonated by its admin. Revoking or expiring the impersonation
+// stops its token right away.
+func impersonate(r *http.Request, s Storage, p *Principal, publicID string) error {
+	i, err := s.GetImpersonation(publicID)
+	if err != nil || i.AccountID != p.AccountID || i.Status != ImpersonationActive || !time.Now().Before(i.ExpiresAt) {
+		return permissionDenied
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ApiError{Err: "impersonation is read-only", Status: http.StatusForbidden}
+	}
+	p.ImpersonatedBy, p.ImpersonationID = i.Admin, i.PublicID
+	return nil
+}
+
+// auditImpersonatedRequest records each request an admin makes as the
+// customer, so the audit log shows exactly what they looked at.
+func auditImpersonatedRequest(s Storage, r *http.Request, p *Principal) {
+	event := NewAuditEvent(p.consumer(), "impersonation.request", p.AccountID, map[string]string{
+		"impersonation": p.ImpersonationID,
+		"method":        r.Method,
+		"path":          r.URL.Path,
+	})
+	if err := s.CreateAuditEvent(event); err != nil {
+		log.Println("Failed to audit impersonated request: ", err)
+	}
+}
+
+func (s *APIServer) auditImpersonation(actor, action string, i *Impersonation) {
+	event := NewAuditEvent(actor, action, i.AccountID, map[string]string{
+		"impersonation": i.PublicID,
+		"admin":         i.Admin,
+	})
+	if err := s.storage.CreateAuditEvent(event); err != nil {
+		log.Println("Failed to audit impersonation: ", err)
+	}
+}
+
+// HandleAdminImpersonate starts an impersonation of the account and returns
+// its token. Unless IMPERSONATION_CONSENT_REQUIRED is off, the token only
+// works once the customer has consented.
+func (s *APIServer) HandleAdminImpersonate(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	accountID, err := getIntVar(r, "accountID")
+	if err != nil {
+		return err
+	}
+
+	req := new(ImpersonationRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	if strings.TrimSpace(req.Reason) == "" {
+		return ApiError{Err: "a reason is required", Status: http.StatusBadRequest}
+	}
+
+	account, err := s.storage.GetAccountByID(accountID)
+	if err != nil {
+		return ApiError{Err: "account not found", Status: http.StatusNotFound}
+	}
+
+	now := time.Now().UTC()
+	i := &Impersonation{
+		PublicID:  NewULID(),
+		AccountID: account.ID,
+		Admin:     adminActor(r),
+		Reason:    req.Reason,
+		Status:    ImpersonationActive,
+		CreatedAt: now,
+		ExpiresAt: now.Add(impersonationTTL()),
+	}
+	if impersonationConsentRequired() {
+		i.Status = ImpersonationPending
+	}
+	if err := s.storage.CreateImpersonation(i); err != nil {
+		return err
+	}
+	s.auditImpersonation(i.Admin, "impersonation.started", i)
+
+	if i.Token, err = createImpersonationJWT(account, i); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, i)
+}
+
+// HandleAdminRevokeImpersonation ends an impersonation, whoever started it.
+func (s *APIServer) HandleAdminRevokeImpersonation(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	i, err := s.storage.GetImpersonation(mux.Vars(r)["impersonationID"])
+	if err != nil {
+		return err
+	}
+	return s.revokeImpersonation(w, adminActor(r), i)
+}
+
+// HandleImpersonations lists the impersonations of the account, newest
+// first, so customers can see who asked to look at it and why.
+func (s *APIServer) HandleImpersonations(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	impersonations, err := s.storage.GetImpersonations(id)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, impersonations)
+}
+
+// HandleConsentImpersonation activates a pending impersonation. It lasts
+// the full TTL from the consent, but a request left pending longer than
+// that can't be consented to anymore.
+func (s *APIServer) HandleConsentImpersonation(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	i, err := s.accountImpersonation(r)
+	if err != nil {
+		return err
+	}
+	if i.Status != ImpersonationPending {
+		return ErrStateConflict
+	}
+	now := time.Now().UTC()
+	if !now.Before(i.ExpiresAt) {
+		return ApiError{Err: "impersonation request expired", Status: http.StatusUnprocessableEntity}
+	}
+
+	i.Status, i.ConsentedAt, i.ExpiresAt = ImpersonationActive, &now, now.Add(impersonationTTL())
+	if err := s.storage.UpdateImpersonation(i, ImpersonationPending); err != nil {
+		return err
+	}
+	s.auditImpersonation(principalFromContext(r).consumer(), "impersonation.consented", i)
+
+	return writeJSON(w, http.StatusOK, i)
+}
+
+// HandleRevokeImpersonation lets the customer decline a pending
+// impersonation or end an active one.
+func (s *APIServer) HandleRevokeImpersonation(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	i, err := s.accountImpersonation(r)
+	if err != nil {
+		return err
+	}
+	return s.revokeImpersonation(w, principalFromContext(r).consumer(), i)
+}
+
+func (s *APIServer) accountImpersonation(r *http.Request) (*Impersonation, error) {
+	id, err := getID(r)
+	if err != nil {
+		return nil, err
+	}
+	i, err := s.storage.GetImpersonation(mux.Vars(r)["impersonationID"])
+	if err != nil {
+		return nil, err
+	}
+	if i.AccountID != id {
+		return nil, ApiError{Err: "impersonation not found", Status: http.StatusNotFound}
+	}
+	return i, nil
+}
+
+func (s *APIServer) revokeImpersonation(w http.ResponseWriter, actor string, i *Impersonation) error {
+	if i.Status == ImpersonationRevoked {
+		return ErrStateConflict
+	}
+
+	now := time.Now().UTC()
+	from := i.Status
+	i.Status, i.RevokedBy, i.RevokedAt = ImpersonationRevoked, actor, &now
+	if err := s.storage.UpdateImpersonation(i, from); err != nil {
+		return err
+	}
+	s.auditImpersonation(actor, "impersonation.revoked", i)
+
+	return writeJSON(w, http.StatusOK, i)
+}
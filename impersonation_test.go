@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImpersonation(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	account := createTestAccount(t, store, 100)
+	customerToken, err := createJWT(account)
+	assert.Nil(t, err)
+	id := strconv.Itoa(account.ID)
+
+	call := func(method, path string, header map[string]string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	admin := map[string]string{"x-admin-token": "admin-secret"}
+	customer := map[string]string{"x-jwt-token": customerToken}
+
+	rec := call(http.MethodPost, "/admin/accounts/"+id+"/impersonations", admin, `{}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "a reason is required")
+
+	rec = call(http.MethodPost, "/admin/accounts/"+id+"/impersonations", admin, `{"reason":"balance looks wrong"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	i := new(Impersonation)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(i))
+	assert.Equal(t, ImpersonationPending, i.Status)
+	assert.NotEmpty(t, i.Token)
+	support := map[string]string{"x-jwt-token": i.Token}
+
+	rec = call(http.MethodGet, "/account/"+id, support, "")
+	assert.Equal(t, http.StatusForbidden, rec.Code, "the token doesn't work before consent")
+
+	rec = call(http.MethodPost, "/account/"+id+"/impersonations/"+i.PublicID+"/consent", customer, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = call(http.MethodGet, "/account/"+id, support, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = call(http.MethodPost, "/transfer", support, `{"toAccount":1,"amount":{"amount":1}}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "read-only")
+	rec = call(http.MethodPost, "/account/"+id+"/impersonations/"+i.PublicID+"/revoke", support, "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = call(http.MethodPost, "/account/"+id+"/impersonations/"+i.PublicID+"/revoke", customer, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = call(http.MethodGet, "/account/"+id, support, "")
+	assert.Equal(t, http.StatusForbidden, rec.Code, "revoking stops the token right away")
+	rec = call(http.MethodPost, "/admin/impersonations/"+i.PublicID+"/revoke", admin, "")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = call(http.MethodGet, "/account/"+id+"/impersonations", customer, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	listed := []*Impersonation{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&listed))
+	assert.Len(t, listed, 1)
+	assert.Equal(t, ImpersonationRevoked, listed[0].Status)
+	assert.Empty(t, listed[0].Token)
+
+	events, err := store.GetAuditEvents(account.ID, 10)
+	assert.Nil(t, err)
+	actions := []string{}
+	for _, e := range events {
+		actions = append(actions, e.Actor+" "+e.Action)
+	}
+	assert.ElementsMatch(t, []string{
+		"admin impersonation.started",
+		"account:" + account.PublicID + " impersonation.consented",
+		"admin as account:" + account.PublicID + " impersonation.request",
+		"account:" + account.PublicID + " impersonation.revoked",
+	}, actions)
+}
+
+func TestImpersonationWithoutConsent(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Setenv("IMPERSONATION_CONSENT_REQUIRED", "false")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	account := createTestAccount(t, store, 100)
+	id := strconv.Itoa(account.ID)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/accounts/"+id+"/impersonations", strings.NewReader(`{"reason":"ticket 42"}`))
+	req.Header.Set("x-admin-token", "admin-secret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	i := new(Impersonation)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(i))
+	assert.Equal(t, ImpersonationActive, i.Status)
+
+	req = httptest.NewRequest(http.MethodGet, "/account/"+id+"/balance", nil)
+	req.Header.Set("x-jwt-token", i.Token)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	stored := store.impersonations[0]
+	stored.ExpiresAt = stored.CreatedAt
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "the token stops working when the impersonation expires")
+}
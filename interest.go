@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxSimulationMonths bounds a simulation to a 30 year mortgage.
+const maxSimulationMonths = 360
+
+// InterestSimulation projects an account's balance month by month at an
+// annual rate, with an optional monthly payment paying a negative balance
+// down, as a loan is amortized.
+type InterestSimulation struct {
+	AccountPublicID string          `json:"accountId"`
+	Rate            string          `json:"rate"`
+	Payment         Money           `json:"payment"`
+	Opening         Money           `json:"opening"`
+	Closing         Money           `json:"closing"`
+	TotalInterest   Money           `json:"totalInterest"`
+	Months          []InterestMonth `json:"months"`
+}
+
+// InterestMonth is one month of a simulation. PostingDate is the first of
+// the next month, when the month's interest is posted.
+type InterestMonth struct {
+	Month       int    `json:"month"`
+	PostingDate string `json:"postingDate"`
+	Opening     Money  `json:"opening"`
+	Interest    Money  `json:"interest"`
+	Payment     Money  `json:"payment"`
+	Closing     Money  `json:"closing"`
+}
+
+// monthlyInterest is the interest a month earns on balance at annual
+// rate: a twelfth of the rate, rounded by the currency rule. A negative
+// balance is charged interest. Postings of interest and the simulation
+// both compute it here, so projections match what gets posted.
+func monthlyInterest(balance Money, annual Rate) (Money, error) {
+	if annual.Den <= 0 || annual.Den > math.MaxInt64/12 {
+		return Money{}, ErrInvalidRate
+	}
+	return balance.ApplyRate(Rate{Num: annual.Num, Den: annual.Den * 12})
+}
+
+// accrueMonth applies one month to balance: interest first, then payment
+// toward a negative balance, never past zero.
+func accrueMonth(balance Money, annual Rate, payment Money) (InterestMonth, error) {
+	m := InterestMonth{Opening: balance, Payment: NewMoney(0, balance.Currency)}
+	interest, err := monthlyInterest(balance, annual)
+	if err != nil {
+		return m, err
+	}
+	m.Interest = interest
+	if m.Closing, err = balance.Add(interest); err != nil {
+		return m, err
+	}
+	if m.Closing.IsNegative() && payment.IsPositive() {
+		m.Payment = payment
+		if payment.Amount > -m.Closing.Amount {
+			m.Payment.Amount = -m.Closing.Amount
+		}
+		if m.Closing, err = m.Closing.Add(m.Payment); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// simulateInterest projects months of accrual from balance, starting with
+// the month of from.
+func simulateInterest(balance Money, annual Rate, payment Money, months int, from time.Time) (*InterestSimulation, error) {
+	sim := &InterestSimulation{
+		Payment:       payment,
+		Opening:       balance,
+		TotalInterest: NewMoney(0, balance.Currency),
+		Months:        []InterestMonth{},
+	}
+	firstOfMonth := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= months; i++ {
+		m, err := accrueMonth(balance, annual, payment)
+		if err != nil {
+			return nil, err
+		}
+		m.Month = i
+		m.PostingDate = firstOfMonth.AddDate(0, i, 0).Format(dateLayout)
+		if sim.TotalInterest, err = sim.TotalInterest.Add(m.Interest); err != nil {
+			return nil, err
+		}
+		sim.Months = append(sim.Months, m)
+		balance = m.Closing
+	}
+	sim.Closing = balance
+	return sim, nil
+}
+
+// HandleSimulateInterest projects the balance of the account in the path,
+// for its owner or a delegate, at ?rate=, an annual rate as a decimal such
+// as 0.045, for ?months=. With ?payment=, in minor units, a negative
+// balance is paid down by it every month.
+func (s *APIServer) HandleSimulateInterest(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	account, err := s.storageFor(r).GetAccountByID(id)
+	if err != nil {
+		return err
+	}
+	query := r.URL.Query()
+
+	v := query.Get("rate")
+	rate, err := ParseRate(v)
+	if err != nil || rate.Num < 0 || rate.Num > rate.Den {
+		return ApiError{Err: "invalid rate: " + v, Status: http.StatusBadRequest}
+	}
+
+	v = query.Get("months")
+	months, err := strconv.Atoi(v)
+	if err != nil || months < 1 || months > maxSimulationMonths {
+		return ApiError{Err: "months must be from 1 to " + strconv.Itoa(maxSimulationMonths), Status: http.StatusBadRequest}
+	}
+
+	payment := NewMoney(0, account.Balance.Currency)
+	if v := query.Get("payment"); v != "" {
+		if payment.Amount, err = strconv.ParseInt(v, 10, 64); err != nil || payment.Amount < 0 {
+			return ApiError{Err: "invalid payment: " + v, Status: http.StatusBadRequest}
+		}
+	}
+
+	sim, err := simulateInterest(account.Balance, rate, payment, months, time.Now().UTC())
+	if errors.Is(err, ErrMoneyOverflow) {
+		return ApiError{Err: "the projected balance is out of range", Status: http.StatusUnprocessableEntity}
+	}
+	if err != nil {
+		return err
+	}
+	sim.AccountPublicID, sim.Rate = account.PublicID, query.Get("rate")
+
+	return writeJSON(w, http.StatusOK, sim)
+}
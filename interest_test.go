@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonthlyInterestRoundsByCurrency(t *testing.T) {
+	rate, err := ParseRate("0.05")
+	assert.Nil(t, err)
+
+	// 100000 * 0.05 / 12 = 416.666...
+	interest, err := monthlyInterest(NewMoney(100000, "USD"), rate)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(417), interest.Amount)
+
+	interest, err = monthlyInterest(NewMoney(-100000, "USD"), rate)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-417), interest.Amount)
+}
+
+func TestSimulateInterestAmortizesLoan(t *testing.T) {
+	rate, err := ParseRate("0.12")
+	assert.Nil(t, err)
+	from := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+
+	sim, err := simulateInterest(NewMoney(-10000, "USD"), rate, NewMoney(5000, "USD"), 3, from)
+	assert.Nil(t, err)
+	assert.Len(t, sim.Months, 3)
+
+	// -10000 - 100 interest + 5000 paid.
+	assert.Equal(t, int64(-5100), sim.Months[0].Closing.Amount)
+	assert.Equal(t, "2026-11-01", sim.Months[0].PostingDate)
+	// -5100 - 51 interest; the last payment only clears the debt.
+	assert.Equal(t, int64(5000), sim.Months[1].Payment.Amount)
+	assert.Equal(t, int64(-151), sim.Months[1].Closing.Amount)
+	assert.Equal(t, int64(153), sim.Months[2].Payment.Amount)
+	assert.Equal(t, int64(0), sim.Closing.Amount)
+	assert.Equal(t, int64(-153), sim.TotalInterest.Amount)
+}
+
+func TestSimulateInterestForDelegate(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	owner := createTestAccount(t, store, 120_000)
+	accountant := createTestAccount(t, store, 0)
+	assert.Nil(t, store.CreateDelegation(&Delegation{AccountID: owner.ID, DelegateAccount: accountant.ID, CreatedAt: time.Now().UTC()}))
+	token, err := createJWT(accountant)
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/account/"+owner.PublicID+"/interest/simulate?rate=0.12&months=1", nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// The delegate sees the owner's account, not their own.
+	var sim InterestSimulation
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &sim))
+	assert.Equal(t, owner.PublicID, sim.AccountPublicID)
+	assert.Equal(t, int64(120_000), sim.Opening.Amount)
+	assert.Equal(t, int64(1200), sim.TotalInterest.Amount)
+}
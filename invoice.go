@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type InvoiceStatus string
+
+// Invoices are stored as sent or paid. Overdue is derived when a sent
+// invoice is read after its due date.
+const (
+	InvoiceSent    InvoiceStatus = "sent"
+	InvoicePaid    InvoiceStatus = "paid"
+	InvoiceOverdue InvoiceStatus = "overdue"
+)
+
+type InvoiceLineItem struct {
+	Description string `json:"description"`
+	Quantity    int64  `json:"quantity"`
+	UnitPrice   Money  `json:"unitPrice"`
+}
+
+// Invoice is a bill issued by a business account. It's paid by a transfer
+// to the issuing account whose reference is the invoice's public id.
+type Invoice struct {
+	ID             int               `json:"id"`
+	PublicID       string            `json:"publicId"`
+	AccountID      int               `json:"-"`
+	CustomerName   string            `json:"customerName"`
+	CustomerEmail  string            `json:"customerEmail,omitempty"`
+	LineItems      []InvoiceLineItem `json:"lineItems"`
+	Total          Money             `json:"total"`
+	DueDate        time.Time         `json:"dueDate"`
+	Status         InvoiceStatus     `json:"status"`
+	PaidByTransfer *int              `json:"paidByTransfer,omitempty"`
+	PaidAt         *time.Time        `json:"paidAt,omitempty"`
+	CreatedAt      time.Time         `json:"createdAt"`
+}
+
+type CreateInvoiceRequest struct {
+	CustomerName  string            `json:"customerName"`
+	CustomerEmail string            `json:"customerEmail"`
+	LineItems     []InvoiceLineItem `json:"lineItems"`
+	// DueDate is a calendar date; the invoice is overdue from the day after.
+	DueDate string `json:"dueDate"`
+}
+
+// InvoiceResource is an invoice as returned by the API, with the link the
+// customer follows to pay it.
+type InvoiceResource struct {
+	*Invoice
+	PaymentLink string `json:"paymentLink"`
+}
+
+func newInvoiceResource(inv *Invoice, now time.Time) InvoiceResource {
+	if inv.Status == InvoiceSent && !now.Before(inv.DueDate.AddDate(0, 0, 1)) {
+		inv.Status = InvoiceOverdue
+	}
+	return InvoiceResource{Invoice: inv, PaymentLink: "/invoices/" + inv.PublicID + "/pay"}
+}
+
+// PayableInvoice is an invoice as shown to whoever follows its payment
+// link: what is owed and by when, without the merchant's own records such
+// as who the customer is.
+type PayableInvoice struct {
+	PublicID  string            `json:"publicId"`
+	LineItems []InvoiceLineItem `json:"lineItems"`
+	Total     Money             `json:"total"`
+	DueDate   time.Time         `json:"dueDate"`
+	Status    InvoiceStatus     `json:"status"`
+}
+
+func newPayableInvoice(inv *Invoice, now time.Time) PayableInvoice {
+	r := newInvoiceResource(inv, now)
+	return PayableInvoice{PublicID: r.PublicID, LineItems: r.LineItems, Total: r.Total, DueDate: r.DueDate, Status: r.Status}
+}
+
+// InvoicePayment is what a payment link resolves to: the transfer request
+// that settles the invoice.
+type InvoicePayment struct {
+	Invoice  PayableInvoice  `json:"invoice"`
+	Transfer TransferRequest `json:"transfer"`
+}
+
+// invoiceTotal sums the line items, which all have to be in one currency.
+func invoiceTotal(items []InvoiceLineItem) (Money, error) {
+	if len(items) == 0 {
+		return Money{}, ApiError{Err: "an invoice needs at least one line item", Status: http.StatusBadRequest}
+	}
+
+	total := NewMoney(0, items[0].UnitPrice.Currency)
+	for _, item := range items {
+		if strings.TrimSpace(item.Description) == "" || item.Quantity < 1 || !item.UnitPrice.IsPositive() {
+			return Money{}, ApiError{Err: "line items need a description, a quantity and a positive unit price", Status: http.StatusBadRequest}
+		}
+
+		if item.UnitPrice.Amount > math.MaxInt64/item.Quantity {
+			return Money{}, ApiError{Err: ErrMoneyOverflow.Error(), Status: http.StatusBadRequest}
+		}
+		line := NewMoney(item.UnitPrice.Amount*item.Quantity, item.UnitPrice.Currency)
+
+		var err error
+		if total, err = total.Add(line); err != nil {
+			return Money{}, ApiError{Err: err.Error(), Status: http.StatusBadRequest}
+		}
+	}
+	return total, nil
+}
+
+func (s *APIServer) HandleInvoices(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		invoices, err := s.storage.GetInvoices(id)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		resources := make([]InvoiceResource, 0, len(invoices))
+		for _, inv := range invoices {
+			resources = append(resources, newInvoiceResource(inv, now))
+		}
+		return writeJSON(w, http.StatusOK, resources)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	if !accountFromContext(r).Business {
+		return ApiError{Err: "invoicing is only available to business accounts", Status: http.StatusForbidden}
+	}
+
+	req := new(CreateInvoiceRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	if strings.TrimSpace(req.CustomerName) == "" {
+		return ApiError{Err: "customerName is required", Status: http.StatusBadRequest}
+	}
+	for i := range req.LineItems {
+		if req.LineItems[i].UnitPrice.Currency == "" {
+			req.LineItems[i].UnitPrice.Currency = accountFromContext(r).Balance.Currency
+		}
+	}
+	total, err := invoiceTotal(req.LineItems)
+	if err != nil {
+		return err
+	}
+	dueDate, err := time.Parse("2006-01-02", req.DueDate)
+	if err != nil {
+		return ApiError{Err: "dueDate must be a date like 2006-01-02", Status: http.StatusBadRequest}
+	}
+
+	invoice := &Invoice{
+		PublicID:      NewULID(),
+		AccountID:     id,
+		CustomerName:  req.CustomerName,
+		CustomerEmail: req.CustomerEmail,
+		LineItems:     req.LineItems,
+		Total:         total,
+		DueDate:       dueDate,
+		Status:        InvoiceSent,
+		CreatedAt:     time.Now().UTC(),
+	}
+	if err := s.storage.CreateInvoice(invoice); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, newInvoiceResource(invoice, invoice.CreatedAt))
+}
+
+func (s *APIServer) HandleGetInvoice(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	invoice, err := s.storage.GetInvoiceByPublicID(mux.Vars(r)["invoiceID"])
+	if err != nil || invoice.AccountID != id {
+		return invoiceNotFound
+	}
+
+	return writeJSON(w, http.StatusOK, newInvoiceResource(invoice, time.Now().UTC()))
+}
+
+// HandlePayInvoice resolves a payment link to the transfer request that
+// pays the invoice. Any customer may follow it; the transfer itself is
+// made through /transfer.
+func (s *APIServer) HandlePayInvoice(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	invoice, err := s.storage.GetInvoiceByPublicID(mux.Vars(r)["invoiceID"])
+	if err != nil {
+		return invoiceNotFound
+	}
+	if invoice.Status == InvoicePaid {
+		return ApiError{Err: "invoice is already paid", Status: http.StatusConflict}
+	}
+	merchant, err := s.storage.GetAccountByID(invoice.AccountID)
+	if err != nil {
+		return invoiceNotFound
+	}
+
+	return writeJSON(w, http.StatusOK, InvoicePayment{
+		Invoice: newPayableInvoice(invoice, time.Now().UTC()),
+		Transfer: TransferRequest{
+			ToPublicID: merchant.PublicID,
+			Amount:     invoice.Total,
+			Reference:  invoice.PublicID,
+		},
+	})
+}
+
+var invoiceNotFound = ApiError{Err: "invoice not found", Status: http.StatusNotFound}
+
+// paysInvoice reports whether a settled transfer pays the invoice in full.
+// What counts is what the merchant was credited, in the invoice currency.
+func (t *Transfer) paysInvoice(inv *Invoice) bool {
+	return inv.Status == InvoiceSent && t.Reference == inv.PublicID &&
+		t.ToAccount == inv.AccountID && t.Credited() == inv.Total
+}
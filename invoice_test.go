@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvoiceTotal(t *testing.T) {
+	total, err := invoiceTotal([]InvoiceLineItem{
+		{Description: "consulting", Quantity: 3, UnitPrice: NewMoney(15000, "USD")},
+		{Description: "travel", Quantity: 1, UnitPrice: NewMoney(4250, "USD")},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, NewMoney(49250, "USD"), total)
+
+	_, err = invoiceTotal([]InvoiceLineItem{
+		{Description: "consulting", Quantity: 1, UnitPrice: NewMoney(100, "USD")},
+		{Description: "travel", Quantity: 1, UnitPrice: NewMoney(100, "EUR")},
+	})
+	assert.NotNil(t, err)
+
+	_, err = invoiceTotal(nil)
+	assert.NotNil(t, err)
+}
+
+func TestTransferSettlesReferencedInvoice(t *testing.T) {
+	store := NewMemoryStorage()
+	business := createTestAccount(t, store, 0)
+	customer := createTestAccount(t, store, 10000)
+
+	invoice := &Invoice{
+		PublicID:  NewULID(),
+		AccountID: business.ID,
+		LineItems: []InvoiceLineItem{{Description: "widgets", Quantity: 2, UnitPrice: NewMoney(2500, defaultCurrency)}},
+		Total:     NewMoney(5000, defaultCurrency),
+		DueDate:   time.Now().UTC().AddDate(0, 0, -2).Truncate(24 * time.Hour),
+		Status:    InvoiceSent,
+		CreatedAt: time.Now().UTC(),
+	}
+	assert.Nil(t, store.CreateInvoice(invoice))
+	assert.Equal(t, InvoiceOverdue, newInvoiceResource(invoice, time.Now().UTC()).Status)
+
+	// A partial payment leaves the invoice open.
+	partial := NewTransfer(customer.ID, business.ID, NewMoney(1000, defaultCurrency))
+	partial.Reference = invoice.PublicID
+	assert.Nil(t, store.CreateTransfer(partial))
+	assert.Nil(t, store.ExecuteTransfer(partial))
+
+	stored, err := store.GetInvoiceByPublicID(invoice.PublicID)
+	assert.Nil(t, err)
+	assert.Equal(t, InvoiceSent, stored.Status)
+
+	full := NewTransfer(customer.ID, business.ID, NewMoney(5000, defaultCurrency))
+	full.Reference = invoice.PublicID
+	assert.Nil(t, store.CreateTransfer(full))
+	assert.Nil(t, store.ExecuteTransfer(full))
+
+	stored, err = store.GetInvoiceByPublicID(invoice.PublicID)
+	assert.Nil(t, err)
+	assert.Equal(t, InvoicePaid, stored.Status)
+	assert.Equal(t, full.ID, *stored.PaidByTransfer)
+}
+
+func TestFXTransferPaysInvoiceInItsCurrency(t *testing.T) {
+	store := NewMemoryStorage()
+	business := createTestAccount(t, store, 0)
+	store.accounts[business.ID].Balance.Currency = "JPY"
+	customer := createTestAccount(t, store, 100_00)
+
+	invoice := &Invoice{
+		PublicID:  NewULID(),
+		AccountID: business.ID,
+		LineItems: []InvoiceLineItem{{Description: "tea", Quantity: 1, UnitPrice: NewMoney(1514, "JPY")}},
+		Total:     NewMoney(1514, "JPY"),
+		DueDate:   time.Now().UTC().AddDate(0, 0, 7).Truncate(24 * time.Hour),
+		Status:    InvoiceSent,
+		CreatedAt: time.Now().UTC(),
+	}
+	assert.Nil(t, store.CreateInvoice(invoice))
+
+	pay := func(amount Money, credit Money) *Transfer {
+		transfer := NewTransfer(customer.ID, business.ID, amount)
+		transfer.Credit, transfer.Reference = &credit, invoice.PublicID
+		assert.Nil(t, store.CreateTransfer(transfer))
+		assert.Nil(t, store.ExecuteTransfer(transfer))
+		return transfer
+	}
+
+	// Less than the total is credited, whatever was sent.
+	pay(NewMoney(1000, defaultCurrency), NewMoney(1000, "JPY"))
+	stored, err := store.GetInvoiceByPublicID(invoice.PublicID)
+	assert.Nil(t, err)
+	assert.Equal(t, InvoiceSent, stored.Status)
+
+	full := pay(NewMoney(1000, defaultCurrency), NewMoney(1514, "JPY"))
+	stored, err = store.GetInvoiceByPublicID(invoice.PublicID)
+	assert.Nil(t, err)
+	assert.Equal(t, InvoicePaid, stored.Status)
+	assert.Equal(t, full.ID, *stored.PaidByTransfer)
+}
+
+func TestPaymentLinkHidesMerchantRecords(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	business := createTestAccount(t, store, 0)
+	store.accounts[business.ID].Business = true
+	business.Business = true
+	payer := createTestAccount(t, store, 10000)
+
+	do := func(acc *Account, method, path, body string) *httptest.ResponseRecorder {
+		token, err := createJWT(acc)
+		assert.Nil(t, err)
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(business, http.MethodPost, "/account/"+business.PublicID+"/invoices", `{"customerName":"Ada","customerEmail":"ada@example.com",
+		"lineItems":[{"description":"widgets","quantity":2,"unitPrice":{"amount":2500}}],"dueDate":"2099-01-31"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Contains(t, rec.Body.String(), "ada@example.com")
+	var created InvoiceResource
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	rec = do(payer, http.MethodGet, created.PaymentLink, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var body map[string]map[string]any
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	for _, field := range []string{"customerEmail", "customerName", "accountId", "paidByTransfer"} {
+		assert.NotContains(t, body["invoice"], field)
+	}
+	assert.Equal(t, created.PublicID, body["invoice"]["publicId"])
+	assert.Equal(t, string(InvoiceSent), body["invoice"]["status"])
+	assert.Equal(t, business.PublicID, body["transfer"]["toAccountId"])
+	assert.Equal(t, created.PublicID, body["transfer"]["reference"])
+}
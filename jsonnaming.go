@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// JSONNaming is how the field names of request and response bodies are
+// spelled. Struct tags are camelCase; snake_case is derived from them when
+// encoding, so the two can't drift apart.
+type JSONNaming string
+
+const (
+	NamingCamelCase JSONNaming = "camelCase"
+	NamingSnakeCase JSONNaming = "snake_case"
+)
+
+const jsonNamingHeader = "X-JSON-Naming"
+
+func parseJSONNaming(s string) (JSONNaming, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "camelcase", "camel":
+		return NamingCamelCase, true
+	case "snake_case", "snake":
+		return NamingSnakeCase, true
+	}
+	return "", false
+}
+
+// jsonNamingProfiles parses JSON_NAMING_PROFILES, a comma separated list of
+// consumer=naming pairs such as "terminal:atm-1=snake_case", setting the
+// naming of consumers that can't send the header.
+func jsonNamingProfiles() map[string]JSONNaming {
+	profiles := map[string]JSONNaming{}
+	for _, pair := range strings.Split(getEnv("JSON_NAMING_PROFILES", ""), ",") {
+		consumer, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		naming, ok := parseJSONNaming(v)
+		if !ok {
+			log.Printf("Ignoring JSON_NAMING_PROFILES entry %q", pair)
+			continue
+		}
+		profiles[consumer] = naming
+	}
+	return profiles
+}
+
+// namingWriter carries the naming of the response down to writeJSON.
+type namingWriter struct {
+	http.ResponseWriter
+	naming JSONNaming
+	// requested is set when the client sent the header, which takes
+	// precedence over the profile of the consumer.
+	requested bool
+}
+
+func (w *namingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush keeps streamed responses streaming.
+func (w *namingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func namingWriterOf(w http.ResponseWriter) *namingWriter {
+	for {
+		switch nw := w.(type) {
+		case *namingWriter:
+			return nw
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = nw.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+func jsonNamingOf(w http.ResponseWriter) JSONNaming {
+	if nw := namingWriterOf(w); nw != nil {
+		return nw.naming
+	}
+	return NamingCamelCase
+}
+
+// jsonNamingMiddleware negotiates the naming from the X-JSON-Naming request
+// header. Responses say which naming they use in the same header.
+func jsonNamingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", jsonNamingHeader)
+		nw := &namingWriter{ResponseWriter: w, naming: NamingCamelCase}
+		if v := r.Header.Get(jsonNamingHeader); v != "" {
+			naming, ok := parseJSONNaming(v)
+			if !ok {
+				writeJSON(w, http.StatusBadRequest, ApiError{Err: "unsupported " + jsonNamingHeader + ": " + v})
+				return
+			}
+			nw.naming, nw.requested = naming, true
+			renameRequestBody(r, naming)
+		}
+		next.ServeHTTP(nw, r)
+	})
+}
+
+// useConsumerNaming switches the response, and the request body not read
+// yet, to the naming profile of the authenticated consumer, unless the
+// request asked for a naming itself.
+func useConsumerNaming(w http.ResponseWriter, r *http.Request) {
+	nw := namingWriterOf(w)
+	if nw == nil || nw.requested {
+		return
+	}
+	naming, ok := jsonNamingProfiles()[principalFromContext(r).consumer()]
+	if !ok {
+		return
+	}
+	nw.naming = naming
+	renameRequestBody(r, naming)
+}
+
+// renameRequestBody rewrites the field names of a snake_case JSON body to
+// the camelCase the handlers decode. Bodies that aren't JSON objects or
+// arrays are left for the handler to reject.
+func renameRequestBody(r *http.Request, naming JSONNaming) {
+	if naming != NamingSnakeCase || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return
+	}
+	renamed, err := json.Marshal(camelCaseKeys(v))
+	if err != nil {
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(renamed))
+	r.ContentLength = int64(len(renamed))
+}
+
+func camelCaseKeys(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		renamed := make(map[string]any, len(v))
+		for k, e := range v {
+			renamed[camelCase(k)] = camelCaseKeys(e)
+		}
+		return renamed
+	case []any:
+		for i, e := range v {
+			v[i] = camelCaseKeys(e)
+		}
+		return v
+	}
+	return v
+}
+
+func camelCase(s string) string {
+	if !strings.Contains(s, "_") {
+		return s
+	}
+	var b strings.Builder
+	upper := false
+	for i, c := range s {
+		switch {
+		case c == '_' && i > 0:
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(c))
+			upper = false
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		if unicode.IsUpper(c) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// withJSONNaming returns v ready to encode in naming. For snake_case, that
+// is a copy of v with the field names of its structs converted; map keys
+// are data, such as consumers or IDs, and stay as they are. Values that
+// marshal themselves are encoded as they are.
+func withJSONNaming(v any, naming JSONNaming) any {
+	if naming != NamingSnakeCase {
+		return v
+	}
+	return snakeCaseFields(reflect.ValueOf(v))
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func snakeCaseFields(v reflect.Value) any {
+	if !v.IsValid() {
+		return nil
+	}
+	if t := v.Type(); t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+			return nil
+		}
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return snakeCaseFields(v.Elem())
+	case reflect.Struct:
+		obj := jsonObject{}
+		appendSnakeCaseFields(&obj, v)
+		return obj
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		fallthrough
+	case reflect.Array:
+		elems := make([]any, v.Len())
+		for i := range elems {
+			elems[i] = snakeCaseFields(v.Index(i))
+		}
+		return elems
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[mapKey(iter.Key())] = snakeCaseFields(iter.Value())
+		}
+		return m
+	}
+	return v.Interface()
+}
+
+// appendSnakeCaseFields follows the rules of encoding/json for tags and
+// embedded structs, like the OpenAPI schemas do.
+func appendSnakeCaseFields(obj *jsonObject, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+		if f.Anonymous && name == "" {
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				appendSnakeCaseFields(obj, fv)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if strings.Contains(opts, "omitempty") && isEmptyJSONValue(fv) {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		*obj = append(*obj, jsonField{name: snakeCase(name), value: snakeCaseFields(fv)})
+	}
+}
+
+func mapKey(k reflect.Value) string {
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if text, err := tm.MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(k.Uint(), 10)
+	}
+	return k.String()
+}
+
+func isEmptyJSONValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+// jsonObject is a JSON object keeping its fields in struct order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	name  string
+	value any
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(f.name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnakeCaseFields(t *testing.T) {
+	type inner struct {
+		PublicID string `json:"publicId"`
+	}
+	type embedded struct {
+		CreatedBy string `json:"createdBy"`
+	}
+	v := struct {
+		embedded
+		ToAccount int               `json:"toAccount"`
+		Amount    Money             `json:"amount"`
+		Quote     *inner            `json:"quote,omitempty"`
+		Items     []inner           `json:"items"`
+		Details   map[string]string `json:"details"`
+		Skipped   string            `json:"-"`
+		Untagged  bool
+	}{
+		embedded:  embedded{CreatedBy: "x"},
+		ToAccount: 2,
+		Amount:    NewMoney(100, "USD"),
+		Items:     []inner{{PublicID: "a"}},
+		Details:   map[string]string{"fromAccount": "1"},
+		Skipped:   "no",
+	}
+
+	got, err := json.Marshal(withJSONNaming(v, NamingSnakeCase))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"created_by":"x","to_account":2,"amount":{"amount":100,"currency":"USD"},
+		"items":[{"public_id":"a"}],"details":{"fromAccount":"1"},"untagged":false}`, string(got))
+
+	camel, err := json.Marshal(withJSONNaming(v, NamingCamelCase))
+	assert.Nil(t, err)
+	assert.Contains(t, string(camel), `"toAccount":2`)
+
+	assert.Equal(t, "to_account", snakeCase("toAccount"))
+	assert.Equal(t, "toAccount", camelCase("to_account"))
+	assert.Equal(t, "event_id", snakeCase("event_id"))
+}
+
+func TestJSONNamingNegotiation(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	sender := createTestAccount(t, store, 100_00)
+	recipient := createTestAccount(t, store, 0)
+	token, err := createJWT(sender)
+	assert.Nil(t, err)
+
+	transfer := func(naming, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/transfer", strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		if naming != "" {
+			req.Header.Set("X-JSON-Naming", naming)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := transfer("snake_case", `{"to_account_id":"`+recipient.PublicID+`","amount":{"amount":100}}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "snake_case", rec.Header().Get("X-JSON-Naming"))
+	assert.Contains(t, rec.Header().Values("Vary"), "X-JSON-Naming")
+	var body map[string]any
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, recipient.PublicID, body["to_account_id"])
+	assert.NotContains(t, body, "toAccountId")
+
+	rec = transfer("kebab-case", `{}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// The profile of the consumer applies unless the header says otherwise.
+	t.Setenv("JSON_NAMING_PROFILES", "account:"+sender.PublicID+"=snake_case")
+	rec = transfer("", `{"to_account_id":"`+recipient.PublicID+`","amount":{"amount":200}}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"from_account_id"`)
+
+	rec = transfer("camelCase", `{"toAccount":`+strconv.Itoa(recipient.ID)+`,"amount":{"amount":300}}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"fromAccountId"`)
+	assert.NotEmpty(t, rec.Header().Get("Deprecation"), "integer ids are deprecated")
+	assert.Equal(t, int64(600), balanceOf(t, store, recipient.ID))
+}
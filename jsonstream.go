@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// streamFlushBytes is how much encoded output a stream buffers before
+	// writing it out.
+	streamFlushBytes = 32 << 10
+	// maxPooledBuffer keeps the odd huge response from pinning its buffer
+	// in the pool forever.
+	maxPooledBuffer = 1 << 20
+)
+
+// exportPageSize is how many rows an export reads from storage at a time.
+var exportPageSize = 500
+
+// pooledEncoder is a JSON encoder with the buffer it encodes into, reused
+// across responses to save allocations.
+type pooledEncoder struct {
+	buf *bytes.Buffer
+	enc *json.Encoder
+}
+
+var encoderPool = sync.Pool{
+	New: func() any {
+		buf := new(bytes.Buffer)
+		return &pooledEncoder{buf: buf, enc: json.NewEncoder(buf)}
+	},
+}
+
+func getEncoder() *pooledEncoder {
+	return encoderPool.Get().(*pooledEncoder)
+}
+
+func (e *pooledEncoder) release() {
+	if e.buf.Cap() > maxPooledBuffer {
+		return
+	}
+	e.buf.Reset()
+	encoderPool.Put(e)
+}
+
+// jsonArrayStream writes a JSON array to the client element by element,
+// flushing as it goes, so exports don't hold every row in memory. Once
+// started the status can't change anymore: a failure half way leaves the
+// client with a truncated, invalid document.
+type jsonArrayStream struct {
+	w     http.ResponseWriter
+	e     *pooledEncoder
+	count int
+}
+
+func newJSONArrayStream(w http.ResponseWriter, status int) *jsonArrayStream {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set(jsonNamingHeader, string(jsonNamingOf(w)))
+	w.WriteHeader(status)
+
+	s := &jsonArrayStream{w: w, e: getEncoder()}
+	s.e.buf.WriteByte('[')
+	return s
+}
+
+func (s *jsonArrayStream) Write(v any) error {
+	if s.count > 0 {
+		s.e.buf.WriteByte(',')
+	}
+	if err := s.e.enc.Encode(withJSONNaming(v, jsonNamingOf(s.w))); err != nil {
+		return err
+	}
+	s.count++
+
+	if s.e.buf.Len() >= streamFlushBytes {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *jsonArrayStream) flush() error {
+	if _, err := s.w.Write(s.e.buf.Bytes()); err != nil {
+		return err
+	}
+	s.e.buf.Reset()
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (s *jsonArrayStream) Close() error {
+	defer s.e.release()
+
+	s.e.buf.WriteString("]\n")
+	return s.flush()
+}
+
+// HandleExportTransfers streams every transfer of the account in the
+// period given by ?from=&to= as one JSON array: those still in the
+// database newest first, followed by archived ones, also newest first.
+func (s *APIServer) HandleExportTransfers(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	period, err := parsePeriod(r)
+	if err != nil {
+		return err
+	}
+
+	store := s.storageFor(r)
+	q := PageQuery{After: period.From, Before: period.To, BeforeID: 1<<31 - 1, Limit: exportPageSize}
+	transfers, err := store.GetTransfers(TransferFilter{AccountID: &id}, q)
+	if err != nil {
+		return err
+	}
+
+	// Transfers that were archived but not yet deleted when the archiver
+	// stopped show up in both places; those are all old and settled.
+	var archivable map[int]bool
+	var archivedBefore time.Time
+	if s.archive != nil {
+		archivable = map[int]bool{}
+		archivedBefore = time.Now().UTC().Add(-s.archive.RetainFor)
+	}
+
+	stream := newJSONArrayStream(w, http.StatusOK)
+	for len(transfers) > 0 {
+		for _, t := range transfers {
+			if archivable != nil && !t.IsPending() && t.CreatedAt.Before(archivedBefore) {
+				archivable[t.ID] = true
+			}
+			if err := stream.Write(t); err != nil {
+				log.Printf("Failed to export transfers of account %d: %v\n", id, err)
+				return nil
+			}
+		}
+		if len(transfers) < exportPageSize {
+			break
+		}
+
+		last := transfers[len(transfers)-1]
+		q.Before, q.BeforeID = last.CreatedAt, last.ID
+		if transfers, err = store.GetTransfers(TransferFilter{AccountID: &id}, q); err != nil {
+			log.Printf("Failed to export transfers of account %d: %v\n", id, err)
+			return nil
+		}
+	}
+
+	if s.archive != nil {
+		err := s.archive.ArchivedTransfers(id, period.From, period.To, func(t *Transfer) error {
+			if archivable[t.ID] {
+				return nil
+			}
+			return stream.Write(t)
+		})
+		if err != nil {
+			log.Printf("Failed to export archived transfers of account %d: %v\n", id, err)
+			return nil
+		}
+	}
+
+	if err := stream.Close(); err != nil {
+		log.Printf("Failed to export transfers of account %d: %v\n", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportTransfersStreamsAllPages(t *testing.T) {
+	defer func(size int) { exportPageSize = size }(exportPageSize)
+	exportPageSize = 2
+
+	store := NewMemoryStorage()
+	server := &APIServer{storage: store}
+	from := createTestAccount(t, store, 0)
+	to := createTestAccount(t, store, 0)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, store.CreateTransfer(NewTransfer(from.ID, to.ID, NewMoney(int64(i+1), defaultCurrency))))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/account/"+strconv.Itoa(from.ID)+"/transfers/export", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(from.ID)})
+	w := httptest.NewRecorder()
+	assert.Nil(t, server.HandleExportTransfers(w, r))
+
+	var transfers []*Transfer
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &transfers))
+	assert.Len(t, transfers, 5)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+}
+
+func TestJSONArrayStreamEmpty(t *testing.T) {
+	w := httptest.NewRecorder()
+	assert.Nil(t, newJSONArrayStream(w, http.StatusOK).Close())
+	assert.JSONEq(t, `[]`, w.Body.String())
+}
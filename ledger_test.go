@@ -0,0 +1,228 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ledgerStorages returns the storages the ledger tests run against. Postgres
+// is only included when TEST_DATABASE_URL points at a disposable database.
+func ledgerStorages(tb testing.TB) map[string]func() Storage {
+	storages := map[string]func() Storage{
+		"memory": func() Storage { return NewMemoryStorage() },
+	}
+
+	if dsn := os.Getenv("TEST_DATABASE_URL"); dsn != "" {
+		storages["postgres"] = func() Storage {
+			store, err := OpenPostgresStore(dsn)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			if err := store.Init(); err != nil {
+				tb.Fatal(err)
+			}
+			return store
+		}
+		storages["postgres-unprepared"] = func() Storage {
+			store := storages["postgres"]().(*PostgresStorage)
+			store.db.Prepare = false
+			return store
+		}
+	}
+	return storages
+}
+
+func createTestAccount(tb testing.TB, store Storage, balance int64) *Account {
+	acc := &Account{
+		PublicID:  NewULID(),
+		FirstName: "test",
+		LastName:  "account",
+		Number:    rand.Int31(),
+		Balance:   NewMoney(balance, defaultCurrency),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := store.CreateAccount(acc); err != nil {
+		tb.Fatal(err)
+	}
+	return acc
+}
+
+func balanceOf(tb testing.TB, store Storage, id int) int64 {
+	acc, err := store.GetAccountByID(id)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return acc.Balance.Amount
+}
+
+type ledgerOp struct {
+	From, To uint8
+	Amount   uint16
+}
+
+// TestLedgerInvariants runs random transfer sequences and checks them
+// against a simple model: money is never created or destroyed, balances
+// never go negative, and a transfer settles exactly when the payer can
+// cover it.
+func TestLedgerInvariants(t *testing.T) {
+	for name, newStorage := range ledgerStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStorage()
+			cfg := &quick.Config{MaxCount: 100, Rand: rand.New(rand.NewSource(710))}
+			if name != "memory" {
+				cfg.MaxCount = 10
+			}
+
+			property := func(initial [4]uint16, ops []ledgerOp) bool {
+				accounts := make([]*Account, len(initial))
+				model := make([]int64, len(initial))
+				var total int64
+				for i, balance := range initial {
+					accounts[i] = createTestAccount(t, store, int64(balance))
+					model[i] = int64(balance)
+					total += int64(balance)
+				}
+
+				for _, op := range ops {
+					from, to := int(op.From)%len(accounts), int(op.To)%len(accounts)
+					if from == to {
+						to = (to + 1) % len(accounts)
+					}
+					amount := int64(op.Amount) + 1
+
+					transfer := NewTransfer(accounts[from].ID, accounts[to].ID, NewMoney(amount, defaultCurrency))
+					if err := store.CreateTransfer(transfer); err != nil {
+						t.Fatal(err)
+					}
+					if err := store.ExecuteTransfer(transfer); err != nil {
+						t.Fatal(err)
+					}
+
+					shouldSettle := model[from] >= amount
+					if shouldSettle != (transfer.Status == TransferSettled) {
+						return false
+					}
+					if shouldSettle {
+						model[from] -= amount
+						model[to] += amount
+					}
+				}
+
+				var sum int64
+				for i, acc := range accounts {
+					balance := balanceOf(t, store, acc.ID)
+					if balance < 0 || balance != model[i] {
+						return false
+					}
+					sum += balance
+				}
+				return sum == total
+			}
+
+			assert.Nil(t, quick.Check(property, cfg))
+		})
+	}
+}
+
+func TestLedgerConcurrentTransfersConserveMoney(t *testing.T) {
+	for name, newStorage := range ledgerStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStorage()
+			accounts := []*Account{}
+			for i := 0; i < 3; i++ {
+				accounts = append(accounts, createTestAccount(t, store, 1000))
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 300; i++ {
+				from, to := accounts[i%3], accounts[(i+1)%3]
+				wg.Add(1)
+				go func(amount int64) {
+					defer wg.Done()
+					transfer := NewTransfer(from.ID, to.ID, NewMoney(amount, defaultCurrency))
+					if err := store.CreateTransfer(transfer); err != nil {
+						t.Error(err)
+						return
+					}
+					if err := store.ExecuteTransfer(transfer); err != nil {
+						t.Error(err)
+					}
+				}(int64(i%7*50 + 1))
+			}
+			wg.Wait()
+
+			var sum int64
+			for _, acc := range accounts {
+				balance := balanceOf(t, store, acc.ID)
+				assert.GreaterOrEqual(t, balance, int64(0))
+				sum += balance
+			}
+			assert.Equal(t, int64(3000), sum)
+		})
+	}
+}
+
+func BenchmarkTransfer(b *testing.B) {
+	for name, newStorage := range ledgerStorages(b) {
+		b.Run(name, func(b *testing.B) {
+			store := newStorage()
+			a := createTestAccount(b, store, 1_000_000)
+			c := createTestAccount(b, store, 1_000_000)
+
+			latencies := make([]time.Duration, b.N)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				from, to := a, c
+				if i%2 == 1 {
+					from, to = c, a
+				}
+				transfer := NewTransfer(from.ID, to.ID, NewMoney(100, defaultCurrency))
+				if err := store.CreateTransfer(transfer); err != nil {
+					b.Fatal(err)
+				}
+				if err := store.ExecuteTransfer(transfer); err != nil {
+					b.Fatal(err)
+				}
+				latencies[i] = time.Since(start)
+			}
+			b.StopTimer()
+			reportP99(b, latencies)
+		})
+	}
+}
+
+func BenchmarkGetAccountByID(b *testing.B) {
+	for name, newStorage := range ledgerStorages(b) {
+		b.Run(name, func(b *testing.B) {
+			store := newStorage()
+			acc := createTestAccount(b, store, 0)
+
+			latencies := make([]time.Duration, b.N)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				if _, err := store.GetAccountByID(acc.ID); err != nil {
+					b.Fatal(err)
+				}
+				latencies[i] = time.Since(start)
+			}
+			b.StopTimer()
+			reportP99(b, latencies)
+		})
+	}
+}
+
+// reportP99 adds the 99th percentile of latencies to the benchmark's
+// output, which the mean ns/op hides.
+func reportP99(b *testing.B, latencies []time.Duration) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+}
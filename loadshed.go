@@ -0,0 +1,141 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// loadSheddingStats exports how many requests were shed per route along
+// with the signals the decisions are based on.
+var loadSheddingStats = expvar.NewMap("load_shedding")
+
+// lowPriorityRoutes are listings and reports that can be retried later
+// without hurting anyone, unlike transfers and logins.
+var lowPriorityRoutes = map[string]bool{
+	"/account":                                true,
+	"/account/{id}/activity":                  true,
+	"/account/{id}/transactions/sync":         true,
+	"/account/{id}/transfers/export":          true,
+	"/account/{id}/logins":                    true,
+	"/account/{id}/api-usage":                 true,
+	"/account/{id}/bill-payments":             true,
+	"/account/{id}/invoices":                  true,
+	"/account/{id}/contacts":                  true,
+	"/account/{id}/categories":                true,
+	"/account/{id}/sweeps":                    true,
+	"/account/{id}/automations":               true,
+	"/account/{id}/automations/{ruleID}/runs": true,
+	"/account/{id}/webhooks":                  true,
+	"/account/{id}/freezes":                   true,
+	"/account/{id}/documents":                 true,
+	"/account/{id}/delegates":                 true,
+	"/account/{id}/impersonations":            true,
+	"/account/{id}/cheques":                   true,
+	"/teller/approvals":                       true,
+	"/admin/logins":                           true,
+	"/admin/accounts":                         true,
+	"/admin/transfers":                        true,
+	"/admin/reviews":                          true,
+	"/admin/reviews/worklist":                 true,
+	"/admin/holidays":                         true,
+	"/admin/terms":                            true,
+	"/admin/migrations":                       true,
+	"/admin/audit":                            true,
+	"/admin/eod":                              true,
+	"/admin/captures":                         true,
+	"/admin/reports/reconciliation":           true,
+	"/admin/reports/system-accounts":          true,
+	"/admin/reports/duplicates":               true,
+}
+
+// LoadShedder rejects low priority requests while the server is
+// overloaded: too many requests in flight or a slow database. A zero
+// threshold disables that signal.
+type LoadShedder struct {
+	MaxInFlight   int64
+	MaxDBLatency  time.Duration
+	ProbeInterval time.Duration
+
+	storage   Storage
+	inFlight  atomic.Int64
+	dbLatency atomic.Int64
+}
+
+func loadShedderFromEnv(store Storage) *LoadShedder {
+	l := &LoadShedder{
+		MaxInFlight:   getEnvInt("SHED_MAX_IN_FLIGHT", 0),
+		MaxDBLatency:  getEnvDuration("SHED_MAX_DB_LATENCY", 0),
+		ProbeInterval: getEnvDuration("SHED_PROBE_INTERVAL", time.Second),
+		storage:       store,
+	}
+
+	loadSheddingStats.Set("in_flight", expvar.Func(func() any { return l.inFlight.Load() }))
+	loadSheddingStats.Set("db_latency_ms", expvar.Func(func() any { return time.Duration(l.dbLatency.Load()).Milliseconds() }))
+	return l
+}
+
+// Run probes the database so the shedder knows its latency. Failed probes
+// count as infinitely slow.
+func (l *LoadShedder) Run() {
+	if l.MaxDBLatency == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(l.ProbeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		start := time.Now()
+		latency := time.Duration(0)
+		if err := l.storage.Ping(); err != nil {
+			log.Println("Database probe failed: ", err)
+			latency = time.Duration(1<<63 - 1)
+		} else {
+			latency = time.Since(start)
+		}
+		l.dbLatency.Store(int64(latency))
+	}
+}
+
+// overloaded returns why the server is overloaded, or "" if it isn't.
+func (l *LoadShedder) overloaded() string {
+	if l.MaxInFlight > 0 && l.inFlight.Load() > l.MaxInFlight {
+		return "in_flight"
+	}
+	if l.MaxDBLatency > 0 && time.Duration(l.dbLatency.Load()) > l.MaxDBLatency {
+		return "db_latency"
+	}
+	return ""
+}
+
+func isLowPriority(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	template, ok := currentRouteTemplate(r)
+	return ok && lowPriorityRoutes[template]
+}
+
+func (l *LoadShedder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.inFlight.Add(1)
+		defer l.inFlight.Add(-1)
+
+		if isLowPriority(r) {
+			if reason := l.overloaded(); reason != "" {
+				template, _ := currentRouteTemplate(r)
+				loadSheddingStats.Add("shed."+reason+"."+template, 1)
+
+				w.Header().Set("Retry-After", "1")
+				writeJSON(w, http.StatusServiceUnavailable,
+					ApiError{Err: "server overloaded, try again later", Status: http.StatusServiceUnavailable})
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadShedderShedsOnlyLowPriorityRequests(t *testing.T) {
+	l := &LoadShedder{MaxDBLatency: 100 * time.Millisecond}
+
+	router := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	router.HandleFunc("/admin/transfers", ok)
+	router.HandleFunc("/transfer", ok)
+	router.Use(l.Middleware)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/admin/transfers").Code)
+
+	l.dbLatency.Store(int64(time.Second))
+	rec := serve(http.MethodGet, "/admin/transfers")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/transfer").Code)
+
+	l.dbLatency.Store(int64(10 * time.Millisecond))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/admin/transfers").Code)
+}
+
+func TestLoadShedderInFlightThreshold(t *testing.T) {
+	l := &LoadShedder{MaxInFlight: 2}
+	assert.Equal(t, "", l.overloaded())
+
+	l.inFlight.Store(3)
+	assert.Equal(t, "in_flight", l.overloaded())
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// LoginAttempt records a single POST /login. AccountID is nil when the
+// number didn't match any account.
+type LoginAttempt struct {
+	ID        int       `json:"id"`
+	AccountID *int      `json:"-"`
+	Number    int32     `json:"number"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Success   bool      `json:"success"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type LoginAttemptFilter struct {
+	AccountID *int
+	Success   *bool
+	IP        string
+}
+
+type LoginAttemptPage struct {
+	Items      []*LoginAttempt `json:"items"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+}
+
+func NewLoginAttempt(number int32, r *http.Request) *LoginAttempt {
+	return &LoginAttempt{
+		Number:    number,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// HandleGetAccountLogins lets customers review the login attempts made
+// against their own account.
+func (s *APIServer) HandleGetAccountLogins(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	return s.writeLoginAttempts(w, r, LoginAttemptFilter{AccountID: &id})
+}
+
+// HandleAdminGetLogins lists login attempts across all accounts, optionally
+// filtered by ?account=, ?success= and ?ip=.
+func (s *APIServer) HandleAdminGetLogins(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	q := r.URL.Query()
+	filter := LoginAttemptFilter{IP: q.Get("ip")}
+	if v := q.Get("account"); v != "" {
+		id, err := getIntParam(v, "account")
+		if err != nil {
+			return err
+		}
+		filter.AccountID = &id
+	}
+	if v := q.Get("success"); v != "" {
+		success, err := strconv.ParseBool(v)
+		if err != nil {
+			return ApiError{Err: "invalid success: " + v, Status: http.StatusBadRequest}
+		}
+		filter.Success = &success
+	}
+
+	return s.writeLoginAttempts(w, r, filter)
+}
+
+func (s *APIServer) writeLoginAttempts(w http.ResponseWriter, r *http.Request, filter LoginAttemptFilter) error {
+	period, err := parsePeriod(r)
+	if err != nil {
+		return err
+	}
+	limit, err := getPageLimit(r)
+	if err != nil {
+		return err
+	}
+
+	var cursor *activityCursor
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		if cursor, err = parseActivityCursor(v); err != nil {
+			return err
+		}
+	}
+
+	page := PageQuery{After: period.From, Before: period.To, BeforeID: math.MaxInt32, Limit: limit}
+	attempts, err := s.storage.GetLoginAttempts(filter, cursor.pageFor(ActivityLogin, page))
+	if err != nil {
+		return err
+	}
+
+	resp := LoginAttemptPage{Items: attempts}
+	if len(attempts) == limit {
+		last := attempts[limit-1]
+		resp.NextCursor = activityCursor{OccurredAt: last.CreatedAt, Type: ActivityLogin, ID: last.ID}.String()
+	}
+
+	return writeJSON(w, http.StatusOK, resp)
+}
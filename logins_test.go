@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginAttemptsAreRecorded(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	acc, err := NewAccount("login", "test", "secret")
+	assert.Nil(t, err)
+	assert.Nil(t, store.CreateAccount(acc))
+
+	login := func(number int32, password string) int {
+		body := `{"number":` + strconv.Itoa(int(number)) + `,"password":"` + password + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+		req.RemoteAddr = "203.0.113.7:4321"
+		req.Header.Set("User-Agent", "gobank-test")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, login(acc.Number, "wrong"))
+	assert.Equal(t, http.StatusOK, login(acc.Number, "secret"))
+	assert.Equal(t, http.StatusForbidden, login(acc.Number+1, "secret"))
+
+	attempts, err := store.GetLoginAttempts(LoginAttemptFilter{}, PageQuery{Before: time.Now().UTC().Add(time.Hour), BeforeID: math.MaxInt32, Limit: 10})
+	assert.Nil(t, err)
+	assert.Len(t, attempts, 3)
+	unknown, succeeded, failed := attempts[0], attempts[1], attempts[2]
+
+	assert.Nil(t, unknown.AccountID)
+	assert.Equal(t, acc.Number+1, unknown.Number)
+	assert.False(t, unknown.Success)
+
+	assert.Equal(t, acc.ID, *succeeded.AccountID)
+	assert.True(t, succeeded.Success)
+	assert.Equal(t, "203.0.113.7", succeeded.IP)
+	assert.Equal(t, "gobank-test", succeeded.UserAgent)
+
+	assert.Equal(t, acc.ID, *failed.AccountID)
+	assert.False(t, failed.Success)
+}
+
+func TestAccountLoginsAreOwnerOnly(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	owner := createTestAccount(t, store, 0)
+	other := createTestAccount(t, store, 0)
+	accountant := createTestAccount(t, store, 0)
+	assert.Nil(t, store.CreateDelegation(&Delegation{AccountID: owner.ID, DelegateAccount: accountant.ID, CreatedAt: time.Now().UTC()}))
+
+	get := func(acc *Account) int {
+		token, err := createJWT(acc)
+		assert.Nil(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/account/"+owner.PublicID+"/logins", nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get(owner))
+	assert.Equal(t, http.StatusForbidden, get(other))
+	assert.Equal(t, http.StatusForbidden, get(accountant))
+}
+
+func TestAccountLoginsPage(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	owner := createTestAccount(t, store, 0)
+	other := createTestAccount(t, store, 0)
+	token, err := createJWT(owner)
+	assert.Nil(t, err)
+
+	base := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		// Two attempts share each timestamp to exercise the id tie-break.
+		at := base.Add(time.Duration(i/2) * time.Minute)
+		assert.Nil(t, store.CreateLoginAttempt(&LoginAttempt{AccountID: &owner.ID, Number: owner.Number, Success: i%2 == 0, CreatedAt: at}))
+	}
+	assert.Nil(t, store.CreateLoginAttempt(&LoginAttempt{AccountID: &other.ID, Number: other.Number, CreatedAt: base}))
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/account/"+owner.PublicID+"/logins"+query, nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	page := func(query string) LoginAttemptPage {
+		rec := get(query)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var page LoginAttemptPage
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		return page
+	}
+
+	assert.NotContains(t, get("").Body.String(), "accountId")
+	all := page("").Items
+	assert.Len(t, all, 5)
+	for i, a := range all {
+		assert.Equal(t, owner.Number, a.Number)
+		if i > 0 {
+			assert.False(t, a.CreatedAt.After(all[i-1].CreatedAt))
+		}
+	}
+
+	var walked []*LoginAttempt
+	query := "?limit=2"
+	for pages := 0; pages < 10; pages++ {
+		p := page(query)
+		walked = append(walked, p.Items...)
+		if p.NextCursor == "" {
+			break
+		}
+		query = "?limit=2&cursor=" + p.NextCursor
+	}
+	assert.Equal(t, all, walked)
+
+	assert.Equal(t, http.StatusBadRequest, get("?cursor=bogus").Code)
+}
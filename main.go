@@ -2,17 +2,51 @@ package main
 
 import (
 	"log"
+	"os"
+	"path/filepath"
 )
 
 func main() {
-	storage, err := NewPostgresStore()
+	// "gobank spec" prints the OpenAPI definition for SDK generators
+	// without needing a database.
+	if len(os.Args) > 1 && os.Args[1] == "spec" {
+		if err := writeOpenAPI(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// "gobank fake", or the binary built as gobank-fake, serves the API
+	// from memory with seeded accounts and no setup.
+	if len(os.Args) > 1 && os.Args[1] == "fake" || filepath.Base(os.Args[0]) == "gobank-fake" {
+		if err := runFake(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	postgres, err := NewPostgresStore()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := storage.Init(); err != nil {
+	if err := postgres.Init(); err != nil {
 		log.Fatal(err)
 	}
+	if err := ensureSystemAccounts(postgres, defaultCurrency); err != nil {
+		log.Fatal(err)
+	}
+	go postgres.RunFailover()
+
+	var storage Storage = postgres
+	if getEnvBool("TRANSFER_SHADOW_MODE", false) {
+		log.Println("Shadow mode enabled for the ledger engine")
+		storage = NewShadowStorage(storage)
+	}
+	if chaos := NewChaos(chaosConfigFromEnv()); chaos.cfg.Enabled {
+		log.Println("Chaos mode enabled")
+		storage = NewChaosStorage(storage, chaos)
+	}
 
 	server := NewAPIServer(":3000", storage)
 	server.Run()
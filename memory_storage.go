@@ -0,0 +1,2589 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps everything in process memory. It backs tests and
+// local runs that shouldn't need Postgres.
+type MemoryStorage struct {
+	mu sync.Mutex
+
+	accounts        map[int]*Account
+	transfers       map[int]*Transfer
+	transferOrigins map[int]*TransferOrigin
+	loginAttempts   []*LoginAttempt
+	devices         map[int]*Device
+	loginChallenges map[string]*LoginChallenge
+	captures        []*CapturedExchange
+	cashOperations  []*CashOperation
+	payees          map[int]*Payee
+	billPayments    map[int]*BillPayment
+	invoices        map[int]*Invoice
+	alertRules      map[int]*AlertRule
+	contacts        map[int]*Contact
+	cheques         map[int]*Cheque
+	auditEvents     []*AuditEvent
+	usage           map[usageKey]int64
+	sweepRules      map[int]*SweepRule
+	freezeWindows   map[int]*FreezeWindow
+	delegations     map[int]*Delegation
+	documents       []*Document
+	paperless       map[int]*PaperlessPreferences
+	holidays        map[int]*Holiday
+	terms           []*Terms
+	termsAccepted   []*TermsAcceptance
+	migrations      map[string]*MigrationProgress
+	redirects       map[int]*AccountRedirect
+	balanceEntries  []*BalanceEntry
+	idempotencyKeys map[int]map[string]int
+	tellerApprovals []*TellerApproval
+	impersonations  []*Impersonation
+	regenerations   []*StatementRegeneration
+	endOfDays       map[string]*EndOfDayRun
+	fxQuotes        map[string]*FXQuote
+	automationRules map[int]*AutomationRule
+	automationRuns  []*AutomationRun
+	reviewCases     map[int]*ReviewCase
+	transactionTags map[int]map[string]*TransactionTag
+	deletedAccounts map[int]*DeletedAccount
+	legalHolds      map[int]*LegalHold
+	webhooks        map[int]*WebhookEndpoint
+	lastID          int
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		accounts:        map[int]*Account{},
+		transfers:       map[int]*Transfer{},
+		transferOrigins: map[int]*TransferOrigin{},
+		devices:         map[int]*Device{},
+		loginChallenges: map[string]*LoginChallenge{},
+		payees:          map[int]*Payee{},
+		billPayments:    map[int]*BillPayment{},
+		invoices:        map[int]*Invoice{},
+		alertRules:      map[int]*AlertRule{},
+		contacts:        map[int]*Contact{},
+		cheques:         map[int]*Cheque{},
+		usage:           map[usageKey]int64{},
+		sweepRules:      map[int]*SweepRule{},
+		freezeWindows:   map[int]*FreezeWindow{},
+		delegations:     map[int]*Delegation{},
+		paperless:       map[int]*PaperlessPreferences{},
+		holidays:        map[int]*Holiday{},
+		migrations:      map[string]*MigrationProgress{},
+		redirects:       map[int]*AccountRedirect{},
+		idempotencyKeys: map[int]map[string]int{},
+		fxQuotes:        map[string]*FXQuote{},
+		endOfDays:       map[string]*EndOfDayRun{},
+		automationRules: map[int]*AutomationRule{},
+		reviewCases:     map[int]*ReviewCase{},
+		transactionTags: map[int]map[string]*TransactionTag{},
+		deletedAccounts: map[int]*DeletedAccount{},
+		legalHolds:      map[int]*LegalHold{},
+		webhooks:        map[int]*WebhookEndpoint{},
+	}
+}
+
+func (s *MemoryStorage) nextID() int {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *MemoryStorage) Init() error {
+	return nil
+}
+
+func (s *MemoryStorage) Ping() error {
+	return nil
+}
+
+func (s *MemoryStorage) CreateAccount(account *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.accounts {
+		if other.Number == account.Number {
+			return ErrDuplicateAccountNumber
+		}
+		if account.Phone != "" && other.Phone == account.Phone {
+			return ErrDuplicatePhone
+		}
+	}
+
+	account.ID = s.nextID()
+	copied := *account
+	s.accounts[account.ID] = &copied
+	s.recordBalance(&copied)
+	return nil
+}
+
+func (s *MemoryStorage) DeleteAccount(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[id]; !ok {
+		return fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+	}
+	s.softDelete(id, time.Now().UTC())
+	return nil
+}
+
+// softDelete hides the account from lookups, remembering it for the
+// retention sweeper.
+func (s *MemoryStorage) softDelete(id int, now time.Time) {
+	s.deletedAccounts[id] = &DeletedAccount{ID: id, PublicID: s.accounts[id].PublicID, Balance: s.accounts[id].Balance, DeletedAt: now}
+	delete(s.accounts, id)
+}
+
+func (s *MemoryStorage) UpdateAccount(account *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.accounts[account.ID]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrAccountNotFound, account.ID)
+	}
+	copied := *account
+	s.accounts[account.ID] = &copied
+	if copied.Balance != stored.Balance {
+		s.recordBalance(&copied)
+	}
+	return nil
+}
+
+// recordBalance journals the account's balance after a change, like the
+// trigger on the Postgres account table.
+func (s *MemoryStorage) recordBalance(account *Account) {
+	s.balanceEntries = append(s.balanceEntries, &BalanceEntry{
+		AccountID:  account.ID,
+		Balance:    account.Balance,
+		RecordedAt: time.Now().UTC(),
+	})
+}
+
+func (s *MemoryStorage) GetBalanceAt(accountID int, at time.Time) (*BalanceEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.balanceEntries) - 1; i >= 0; i-- {
+		if e := s.balanceEntries[i]; e.AccountID == accountID && !e.RecordedAt.After(at) {
+			copied := *e
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: account %d", ErrBalanceHistoryNotFound, accountID)
+}
+
+func (s *MemoryStorage) GetAccounts() ([]*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts := []*Account{}
+	for _, a := range s.accounts {
+		copied := *a
+		accounts = append(accounts, &copied)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	return accounts, nil
+}
+
+func (s *MemoryStorage) GetSystemAccounts() ([]*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts := []*Account{}
+	for _, a := range s.accounts {
+		if a.System != "" {
+			copied := *a
+			accounts = append(accounts, &copied)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].Balance.Currency != accounts[j].Balance.Currency {
+			return accounts[i].Balance.Currency < accounts[j].Balance.Currency
+		}
+		return accounts[i].System < accounts[j].System
+	})
+	return accounts, nil
+}
+
+func (s *MemoryStorage) GetAccountByID(id int) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+	}
+	copied := *a
+	return &copied, nil
+}
+
+func (s *MemoryStorage) GetAccountIDByPublicID(publicID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.accounts {
+		if a.PublicID == publicID {
+			return a.ID, nil
+		}
+	}
+	for _, a := range s.deletedAccounts {
+		if a.PublicID == publicID {
+			return a.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrAccountNotFound, publicID)
+}
+
+func (s *MemoryStorage) GetAccountByNumber(number int32) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.accounts {
+		if a.Number == number {
+			copied := *a
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: number %d", ErrAccountNotFound, number)
+}
+
+func (s *MemoryStorage) SearchAccounts(query string, limit int) ([]*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query = strings.ToLower(query)
+	accounts := []*Account{}
+	for _, a := range s.accounts {
+		name := strings.ToLower(a.FirstName + " " + a.LastName)
+		if strings.Contains(name, query) || strconv.Itoa(int(a.Number)) == query || strings.ToLower(a.PublicID) == query {
+			copied := *a
+			accounts = append(accounts, &copied)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	return limitSlice(accounts, limit), nil
+}
+
+func (s *MemoryStorage) CreateTransfer(t *Transfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.ID = s.nextID()
+	t.FromPublicID = s.accountPublicID(t.FromAccount)
+	t.ToPublicID = s.accountPublicID(t.ToAccount)
+	if refunded, ok := s.transfers[t.RefundOf]; ok {
+		t.RefundOfPublicID = refunded.PublicID
+	}
+	copied := *t
+	s.transfers[t.ID] = &copied
+	return nil
+}
+
+// accountPublicID is the public id of the account, soft-deleted or not.
+func (s *MemoryStorage) accountPublicID(id int) string {
+	if a, ok := s.accounts[id]; ok {
+		return a.PublicID
+	}
+	if a, ok := s.deletedAccounts[id]; ok {
+		return a.PublicID
+	}
+	return ""
+}
+
+func (s *MemoryStorage) GetTransferByID(id int) (*Transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.transfers[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrTransferNotFound, id)
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (s *MemoryStorage) GetIdempotentTransfer(accountID int, key string) (*Transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.transfers[s.idempotencyKeys[accountID][key]]
+	if !ok {
+		return nil, nil
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (s *MemoryStorage) SaveIdempotencyKey(k *IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.idempotencyKeys[k.AccountID] == nil {
+		s.idempotencyKeys[k.AccountID] = map[string]int{}
+	}
+	s.idempotencyKeys[k.AccountID][k.Key] = k.TransferID
+	return nil
+}
+
+func (s *MemoryStorage) GetTransferByPublicID(publicID string) (*Transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.transfers {
+		if t.PublicID == publicID {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrTransferNotFound, publicID)
+}
+
+func (s *MemoryStorage) ExecuteTransfer(t *Transfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.transfers[t.ID]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrTransferNotFound, t.ID)
+	}
+	if !stored.IsPending() {
+		return nil
+	}
+
+	from, fromOK := s.accounts[t.FromAccount]
+	to, toOK := s.accounts[t.ToAccount]
+
+	t.UpdatedAt = time.Now().UTC()
+	reason := "account not found"
+	if fromOK && toOK {
+		var newFrom, newTo Money
+		newFrom, newTo, reason = applyTransfer(from.Balance, to.Balance, t.Amount, t.Credited())
+		if reason == "" && activeFreezeWindow(s.freezeWindowsOf(t.FromAccount), t.UpdatedAt) != nil {
+			reason = ErrDebitsFrozen.Error()
+		}
+		if reason == "" {
+			from.Balance, to.Balance = newFrom, newTo
+			s.recordBalance(from)
+			s.recordBalance(to)
+		}
+	}
+
+	if reason != "" {
+		t.Status, t.FailureReason = TransferFailed, reason
+	} else {
+		t.Status = TransferSettled
+		s.settleInvoice(t)
+	}
+	stored.Status, stored.FailureReason, stored.UpdatedAt = t.Status, t.FailureReason, t.UpdatedAt
+	return nil
+}
+
+func (s *MemoryStorage) FailTransfer(t *Transfer, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.transfers[t.ID]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrTransferNotFound, t.ID)
+	}
+
+	t.Status, t.FailureReason, t.UpdatedAt = TransferFailed, reason, time.Now().UTC()
+	if stored.IsPending() {
+		stored.Status, stored.FailureReason, stored.UpdatedAt = t.Status, t.FailureReason, t.UpdatedAt
+	}
+	return nil
+}
+
+func (s *MemoryStorage) HoldTransfer(t *Transfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.transfers[t.ID]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrTransferNotFound, t.ID)
+	}
+
+	t.Status, t.UpdatedAt = TransferHeld, time.Now().UTC()
+	if stored.Status == TransferAccepted {
+		stored.Status, stored.UpdatedAt = t.Status, t.UpdatedAt
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ClaimTransfer(lease time.Duration, acceptedBefore time.Time) (*Transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	var claimed *Transfer
+	for _, t := range s.transfers {
+		ready := t.Status == TransferAccepted && !t.CreatedAt.After(acceptedBefore)
+		expired := t.Status == TransferProcessing && t.UpdatedAt.Before(now.Add(-lease))
+		if (ready || expired) && (claimed == nil || t.ID < claimed.ID) {
+			claimed = t
+		}
+	}
+	if claimed == nil {
+		return nil, nil
+	}
+
+	claimed.Status, claimed.UpdatedAt = TransferProcessing, now
+	copied := *claimed
+	return &copied, nil
+}
+
+func (s *MemoryStorage) GetTransfers(f TransferFilter, q PageQuery) ([]*Transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transfers := []*Transfer{}
+	for _, t := range s.transfers {
+		if f.AccountID != nil && !t.Involves(*f.AccountID) {
+			continue
+		}
+		if f.Status != "" && t.Status != f.Status {
+			continue
+		}
+		if q.includes(t.CreatedAt, t.ID) {
+			copied := *t
+			transfers = append(transfers, &copied)
+		}
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		return newerFirst(transfers[i].CreatedAt, transfers[i].ID, transfers[j].CreatedAt, transfers[j].ID)
+	})
+	return limitSlice(transfers, q.Limit), nil
+}
+
+func (s *MemoryStorage) CreateTransferOrigin(o *TransferOrigin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *o
+	s.transferOrigins[o.TransferID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetTransferOrigins(ids []int) (map[int]*TransferOrigin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	origins := map[int]*TransferOrigin{}
+	for _, id := range ids {
+		if o, ok := s.transferOrigins[id]; ok {
+			copied := *o
+			origins[id] = &copied
+		}
+	}
+	return origins, nil
+}
+
+func (s *MemoryStorage) GetReconciliationReport(stuckBefore time.Time) (*ReconciliationReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := &ReconciliationReport{
+		GeneratedAt:    time.Now().UTC(),
+		Balances:       []BalanceTotals{},
+		Transfers:      []TransferTotals{},
+		StuckTransfers: []TransferWithIDs{},
+	}
+
+	balances := map[string]*BalanceTotals{}
+	for _, a := range s.accounts {
+		if a.System != "" {
+			continue
+		}
+		b, ok := balances[a.Balance.Currency]
+		if !ok {
+			b = &BalanceTotals{Currency: a.Balance.Currency, Total: NewMoney(0, a.Balance.Currency)}
+			balances[a.Balance.Currency] = b
+		}
+		b.Accounts++
+		b.Total.Amount += a.Balance.Amount
+		if a.Balance.IsNegative() {
+			b.NegativeBalances++
+		}
+	}
+	for _, b := range balances {
+		report.Balances = append(report.Balances, *b)
+	}
+	sort.Slice(report.Balances, func(i, j int) bool { return report.Balances[i].Currency < report.Balances[j].Currency })
+
+	transfers := map[[2]string]*TransferTotals{}
+	for _, t := range s.transfers {
+		key := [2]string{string(t.Status), t.Amount.Currency}
+		totals, ok := transfers[key]
+		if !ok {
+			totals = &TransferTotals{Status: t.Status, Total: NewMoney(0, t.Amount.Currency)}
+			transfers[key] = totals
+		}
+		totals.Count++
+		totals.Total.Amount += t.Amount.Amount
+
+		if t.Status == TransferProcessing && t.UpdatedAt.Before(stuckBefore) {
+			copied := *t
+			report.StuckTransfers = append(report.StuckTransfers, withInternalIDs(&copied))
+		}
+	}
+	for _, t := range transfers {
+		report.Transfers = append(report.Transfers, *t)
+	}
+	sort.Slice(report.Transfers, func(i, j int) bool {
+		a, b := report.Transfers[i], report.Transfers[j]
+		if a.Status != b.Status {
+			return a.Status < b.Status
+		}
+		return a.Total.Currency < b.Total.Currency
+	})
+	sort.Slice(report.StuckTransfers, func(i, j int) bool { return report.StuckTransfers[i].ID < report.StuckTransfers[j].ID })
+
+	return report, nil
+}
+
+func (s *MemoryStorage) CreateLoginAttempt(a *LoginAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a.ID = s.nextID()
+	copied := *a
+	s.loginAttempts = append(s.loginAttempts, &copied)
+	return nil
+}
+
+func (s *MemoryStorage) GetLoginAttempts(f LoginAttemptFilter, q PageQuery) ([]*LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := []*LoginAttempt{}
+	for _, a := range s.loginAttempts {
+		if f.AccountID != nil && (a.AccountID == nil || *a.AccountID != *f.AccountID) {
+			continue
+		}
+		if f.Success != nil && a.Success != *f.Success {
+			continue
+		}
+		if f.IP != "" && a.IP != f.IP {
+			continue
+		}
+		if q.includes(a.CreatedAt, a.ID) {
+			copied := *a
+			attempts = append(attempts, &copied)
+		}
+	}
+	sort.Slice(attempts, func(i, j int) bool {
+		return newerFirst(attempts[i].CreatedAt, attempts[i].ID, attempts[j].CreatedAt, attempts[j].ID)
+	})
+	return limitSlice(attempts, q.Limit), nil
+}
+
+func (s *MemoryStorage) CreateDevice(d *Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d.ID = s.nextID()
+	copied := *d
+	s.devices[d.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetDevicesByAccount(accountID int) ([]*Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	devices := []*Device{}
+	for _, d := range s.devices {
+		if d.AccountID == accountID && d.RevokedAt == nil {
+			copied := *d
+			devices = append(devices, &copied)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices, nil
+}
+
+func (s *MemoryStorage) RevokeDevice(accountID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.devices[id]
+	if !ok || d.AccountID != accountID || d.RevokedAt != nil {
+		return fmt.Errorf("%w: %d", ErrDeviceNotFound, id)
+	}
+	now := time.Now().UTC()
+	d.RevokedAt = &now
+	return nil
+}
+
+func (s *MemoryStorage) TouchDevice(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d, ok := s.devices[id]; ok {
+		now := time.Now().UTC()
+		d.LastUsedAt = &now
+	}
+	return nil
+}
+
+func (s *MemoryStorage) CreateLoginChallenge(c *LoginChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *c
+	s.loginChallenges[c.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetLoginChallenge(id string) (*LoginChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.loginChallenges[id]
+	if !ok {
+		return nil, ErrLoginChallengeNotFound
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (s *MemoryStorage) CountLoginChallengeAttempt(id string, maxAttempts int, now time.Time) (*LoginChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.loginChallenges[id]
+	if !ok || c.Attempts >= maxAttempts || c.UsedAt != nil || !now.Before(c.ExpiresAt) {
+		return nil, ErrLoginChallengeNotFound
+	}
+	c.Attempts++
+	copied := *c
+	return &copied, nil
+}
+
+func (s *MemoryStorage) UseLoginChallenge(id string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.loginChallenges[id]
+	if !ok || c.UsedAt != nil {
+		return ErrLoginChallengeNotFound
+	}
+	c.UsedAt = &now
+	return nil
+}
+
+func (s *MemoryStorage) ExecuteCashOperation(op *CashOperation, dailyLimit Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[op.AccountID]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrAccountNotFound, op.AccountID)
+	}
+
+	withdrawn := NewMoney(0, op.Amount.Currency)
+	dayStart := startOfDay(op.CreatedAt)
+	for _, prev := range s.cashOperations {
+		if prev.AccountID == op.AccountID && prev.Kind == CashWithdrawal && prev.Status == CashCompleted &&
+			prev.Amount.Currency == op.Amount.Currency && !prev.CreatedAt.Before(dayStart) {
+			withdrawn.Amount += prev.Amount.Amount
+		}
+	}
+
+	newBalance, reason := applyCashOperation(account.Balance, op, withdrawn, dailyLimit)
+	if reason != "" {
+		op.Status, op.FailureReason = CashRejected, reason
+	} else {
+		op.Status = CashCompleted
+		account.Balance = newBalance
+		s.recordBalance(account)
+	}
+
+	op.ID = s.nextID()
+	copied := *op
+	s.cashOperations = append(s.cashOperations, &copied)
+	return nil
+}
+
+func (s *MemoryStorage) GetCashOperations(accountID int, q PageQuery) ([]*CashOperation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ops := []*CashOperation{}
+	for _, op := range s.cashOperations {
+		if op.AccountID == accountID && q.includes(op.CreatedAt, op.ID) {
+			copied := *op
+			ops = append(ops, &copied)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return newerFirst(ops[i].CreatedAt, ops[i].ID, ops[j].CreatedAt, ops[j].ID)
+	})
+	return limitSlice(ops, q.Limit), nil
+}
+
+func (s *MemoryStorage) CreateCapture(c *CapturedExchange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.ID = s.nextID()
+	copied := *c
+	s.captures = append(s.captures, &copied)
+	return nil
+}
+
+func (s *MemoryStorage) GetCapture(id int) (*CapturedExchange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.captures {
+		if c.ID == id {
+			copied := *c
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d", ErrCaptureNotFound, id)
+}
+
+func (s *MemoryStorage) GetCaptures(limit int) ([]*CapturedExchange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	captures := []*CapturedExchange{}
+	for i := len(s.captures) - 1; i >= 0; i-- {
+		copied := *s.captures[i]
+		captures = append(captures, &copied)
+	}
+	return limitSlice(captures, limit), nil
+}
+
+// includes mirrors the where clause the Postgres storage builds from a
+// PageQuery.
+func (q PageQuery) includes(createdAt time.Time, id int) bool {
+	if createdAt.Before(q.After) {
+		return false
+	}
+	return createdAt.Before(q.Before) || (createdAt.Equal(q.Before) && id < q.BeforeID)
+}
+
+func newerFirst(aAt time.Time, aID int, bAt time.Time, bID int) bool {
+	if !aAt.Equal(bAt) {
+		return aAt.After(bAt)
+	}
+	return aID > bID
+}
+
+func limitSlice[T any](items []T, limit int) []T {
+	if limit > 0 && len(items) > limit {
+		return items[:limit]
+	}
+	return items
+}
+
+func (s *MemoryStorage) CreatePayee(p *Payee) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.ID = s.nextID()
+	copied := *p
+	s.payees[p.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetPayee(accountID, id int) (*Payee, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.payees[id]
+	if !ok || p.AccountID != accountID {
+		return nil, fmt.Errorf("%w: %d", ErrPayeeNotFound, id)
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (s *MemoryStorage) GetPayees(accountID int) ([]*Payee, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payees := []*Payee{}
+	for _, p := range s.payees {
+		if p.AccountID == accountID {
+			copied := *p
+			payees = append(payees, &copied)
+		}
+	}
+	sort.Slice(payees, func(i, j int) bool { return payees[i].ID < payees[j].ID })
+	return payees, nil
+}
+
+func (s *MemoryStorage) DeletePayee(accountID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.payees[id]
+	if !ok || p.AccountID != accountID {
+		return fmt.Errorf("%w: %d", ErrPayeeNotFound, id)
+	}
+	delete(s.payees, id)
+
+	for _, bp := range s.billPayments {
+		if bp.PayeeID == id && (bp.Status == BillPaymentScheduled || bp.Status == BillPaymentPaused) {
+			bp.Status, bp.UpdatedAt = BillPaymentCancelled, time.Now().UTC()
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) CreateBillPayment(p *BillPayment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.insertBillPayment(p)
+	return nil
+}
+
+func (s *MemoryStorage) insertBillPayment(p *BillPayment) {
+	p.ID = s.nextID()
+	copied := *p
+	s.billPayments[p.ID] = &copied
+}
+
+func (s *MemoryStorage) GetBillPayments(accountID int) ([]*BillPayment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payments := []*BillPayment{}
+	for _, p := range s.billPayments {
+		if p.AccountID == accountID {
+			copied := *p
+			payments = append(payments, &copied)
+		}
+	}
+	sort.Slice(payments, func(i, j int) bool {
+		return newerFirst(payments[i].ScheduledFor, payments[i].ID, payments[j].ScheduledFor, payments[j].ID)
+	})
+	return payments, nil
+}
+
+func (s *MemoryStorage) GetBillPaymentsByStatus(status BillPaymentStatus, updatedBefore time.Time) ([]*BillPayment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payments := []*BillPayment{}
+	for _, p := range s.billPayments {
+		if p.Status == status && p.UpdatedAt.Before(updatedBefore) {
+			copied := *p
+			payments = append(payments, &copied)
+		}
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].ID < payments[j].ID })
+	return payments, nil
+}
+
+func (s *MemoryStorage) CancelBillPayment(accountID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.billPayments[id]
+	if !ok || p.AccountID != accountID || (p.Status != BillPaymentScheduled && p.Status != BillPaymentPaused) {
+		return fmt.Errorf("%w: %d", ErrBillPaymentNotFound, id)
+	}
+	p.Status, p.UpdatedAt = BillPaymentCancelled, time.Now().UTC()
+	return nil
+}
+
+func (s *MemoryStorage) UpdateStandingOrder(accountID, id int, action StandingOrderAction, now time.Time) (*BillPayment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.billPayments[id]
+	if !ok || stored.AccountID != accountID {
+		return nil, fmt.Errorf("%w: %d", ErrBillPaymentNotFound, id)
+	}
+
+	p := *stored
+	next, err := p.applyStandingOrderAction(action, now)
+	if err != nil {
+		return nil, err
+	}
+	*stored = p
+	if next != nil {
+		s.insertBillPayment(next)
+	}
+	return &p, nil
+}
+
+func (s *MemoryStorage) SendDueBillPayment(now time.Time, closedCurrencies []string) (*BillPayment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	closed := map[string]bool{}
+	for _, c := range closedCurrencies {
+		closed[c] = true
+	}
+
+	var p *BillPayment
+	for _, candidate := range s.billPayments {
+		if candidate.Status != BillPaymentScheduled || candidate.ScheduledFor.After(now) || closed[candidate.Amount.Currency] {
+			continue
+		}
+		if p == nil || candidate.ScheduledFor.Before(p.ScheduledFor) ||
+			(candidate.ScheduledFor.Equal(p.ScheduledFor) && candidate.ID < p.ID) {
+			p = candidate
+		}
+	}
+	if p == nil {
+		return nil, nil
+	}
+
+	account, ok := s.accounts[p.AccountID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrAccountNotFound, p.AccountID)
+	}
+
+	newBalance, reason := debitBillPayment(account.Balance, p.Amount)
+	if reason != "" {
+		p.Status, p.FailureReason = BillPaymentFailed, reason
+	} else {
+		p.Status = BillPaymentSent
+		account.Balance = newBalance
+		s.recordBalance(account)
+	}
+	p.UpdatedAt = now
+
+	if next := p.nextOccurrence(); next != nil {
+		s.insertBillPayment(next)
+	}
+
+	copied := *p
+	return &copied, nil
+}
+
+func (s *MemoryStorage) ConfirmBillPayment(p *BillPayment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.billPayments[p.ID]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrBillPaymentNotFound, p.ID)
+	}
+	if stored.Status == BillPaymentSent {
+		stored.Status, stored.UpdatedAt = BillPaymentConfirmed, time.Now().UTC()
+	}
+	p.Status, p.UpdatedAt = stored.Status, stored.UpdatedAt
+	return nil
+}
+
+func (s *MemoryStorage) RefundBillPayment(p *BillPayment, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.billPayments[p.ID]
+	if !ok || stored.Status != BillPaymentSent {
+		return fmt.Errorf("%w: bill payment %d is not awaiting confirmation", ErrStateConflict, p.ID)
+	}
+
+	if account, ok := s.accounts[stored.AccountID]; ok && account.Balance.Currency == stored.Amount.Currency {
+		account.Balance.Amount += stored.Amount.Amount
+		s.recordBalance(account)
+	}
+	stored.Status, stored.FailureReason, stored.UpdatedAt = BillPaymentFailed, reason, time.Now().UTC()
+	p.Status, p.FailureReason, p.UpdatedAt = stored.Status, stored.FailureReason, stored.UpdatedAt
+	return nil
+}
+
+func (s *MemoryStorage) CreateInvoice(inv *Invoice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inv.ID = s.nextID()
+	copied := copyInvoice(inv)
+	s.invoices[inv.ID] = copied
+	return nil
+}
+
+func (s *MemoryStorage) GetInvoices(accountID int) ([]*Invoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invoices := []*Invoice{}
+	for _, inv := range s.invoices {
+		if inv.AccountID == accountID {
+			invoices = append(invoices, copyInvoice(inv))
+		}
+	}
+	sort.Slice(invoices, func(i, j int) bool {
+		return newerFirst(invoices[i].CreatedAt, invoices[i].ID, invoices[j].CreatedAt, invoices[j].ID)
+	})
+	return invoices, nil
+}
+
+func (s *MemoryStorage) GetInvoiceByPublicID(publicID string) (*Invoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, inv := range s.invoices {
+		if inv.PublicID == publicID {
+			return copyInvoice(inv), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrInvoiceNotFound, publicID)
+}
+
+// settleInvoice marks the invoice a settled transfer pays as paid. The
+// caller holds the lock.
+func (s *MemoryStorage) settleInvoice(t *Transfer) {
+	if t.Reference == "" {
+		return
+	}
+	for _, inv := range s.invoices {
+		if t.paysInvoice(inv) {
+			id, paidAt := t.ID, t.UpdatedAt
+			inv.Status, inv.PaidByTransfer, inv.PaidAt = InvoicePaid, &id, &paidAt
+		}
+	}
+}
+
+func copyInvoice(inv *Invoice) *Invoice {
+	copied := *inv
+	copied.LineItems = append([]InvoiceLineItem(nil), inv.LineItems...)
+	return &copied
+}
+
+func (s *MemoryStorage) CreateAlertRule(a *AlertRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a.ID = s.nextID()
+	s.alertRules[a.ID] = copyAlertRule(a)
+	return nil
+}
+
+func (s *MemoryStorage) GetAlertRule(accountID, id int) (*AlertRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.alertRules[id]
+	if !ok || a.AccountID != accountID {
+		return nil, fmt.Errorf("%w: %d", ErrAlertRuleNotFound, id)
+	}
+	return copyAlertRule(a), nil
+}
+
+func (s *MemoryStorage) GetAlertRules(accountID int) ([]*AlertRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := []*AlertRule{}
+	for _, a := range s.alertRules {
+		if a.AccountID == accountID {
+			rules = append(rules, copyAlertRule(a))
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules, nil
+}
+
+func (s *MemoryStorage) UpdateAlertRule(a *AlertRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.alertRules[a.ID]; ok && stored.AccountID == a.AccountID {
+		s.alertRules[a.ID] = copyAlertRule(a)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) DeleteAlertRule(accountID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.alertRules[id]; ok && a.AccountID == accountID {
+		delete(s.alertRules, id)
+	}
+	return nil
+}
+
+func copyAlertRule(a *AlertRule) *AlertRule {
+	copied := *a
+	if a.Threshold != nil {
+		threshold := *a.Threshold
+		copied.Threshold = &threshold
+	}
+	return &copied
+}
+
+func (s *MemoryStorage) GetAccountsByPhone(phones []string) ([]*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wanted := map[string]bool{}
+	for _, phone := range phones {
+		wanted[phone] = true
+	}
+
+	accounts := []*Account{}
+	for _, account := range s.accounts {
+		if account.Phone != "" && wanted[account.Phone] {
+			copied := *account
+			accounts = append(accounts, &copied)
+		}
+	}
+	return accounts, nil
+}
+
+func (s *MemoryStorage) GetAccountsByName(normalized string) ([]*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts := []*Account{}
+	for _, account := range s.accounts {
+		if account.System == "" && normalizeName(account.FirstName, account.LastName) == normalized {
+			copied := *account
+			accounts = append(accounts, &copied)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	return accounts, nil
+}
+
+func (s *MemoryStorage) CreateContact(c *Contact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.ID = s.nextID()
+	copied := *c
+	s.contacts[c.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetContact(accountID, id int) (*Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.contacts[id]
+	if !ok || c.AccountID != accountID {
+		return nil, fmt.Errorf("%w: %d", ErrContactNotFound, id)
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (s *MemoryStorage) GetContacts(accountID int) ([]*Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contacts := []*Contact{}
+	for _, c := range s.contacts {
+		if c.AccountID == accountID {
+			copied := *c
+			contacts = append(contacts, &copied)
+		}
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		if contacts[i].Name != contacts[j].Name {
+			return contacts[i].Name < contacts[j].Name
+		}
+		return contacts[i].ID < contacts[j].ID
+	})
+	return contacts, nil
+}
+
+func (s *MemoryStorage) UpdateContact(c *Contact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.contacts[c.ID]; ok && stored.AccountID == c.AccountID {
+		copied := *c
+		s.contacts[c.ID] = &copied
+	}
+	return nil
+}
+
+func (s *MemoryStorage) DeleteContact(accountID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.contacts[id]; ok && c.AccountID == accountID {
+		delete(s.contacts, id)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) CreateCheque(c *Cheque) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.ID = s.nextID()
+	copied := *c
+	s.cheques[c.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetCheques(accountID int) ([]*Cheque, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cheques := []*Cheque{}
+	for _, c := range s.cheques {
+		if c.AccountID == accountID {
+			copied := *c
+			cheques = append(cheques, &copied)
+		}
+	}
+	sort.Slice(cheques, func(i, j int) bool {
+		return newerFirst(cheques[i].CreatedAt, cheques[i].ID, cheques[j].CreatedAt, cheques[j].ID)
+	})
+	return cheques, nil
+}
+
+func (s *MemoryStorage) GetDueCheques(now time.Time) ([]*Cheque, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cheques := []*Cheque{}
+	for _, c := range s.cheques {
+		if c.Status == ChequePending && !c.AvailableAt.After(now) {
+			copied := *c
+			cheques = append(cheques, &copied)
+		}
+	}
+	sort.Slice(cheques, func(i, j int) bool { return cheques[i].ID < cheques[j].ID })
+	return cheques, nil
+}
+
+func (s *MemoryStorage) ClearCheque(c *Cheque) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.cheques[c.ID]
+	if !ok || stored.Status != ChequePending {
+		return fmt.Errorf("%w: cheque %d is not pending", ErrStateConflict, c.ID)
+	}
+
+	if account, ok := s.accounts[stored.AccountID]; ok && account.Balance.Currency == stored.Amount.Currency {
+		account.Balance.Amount += stored.Amount.Amount
+		s.recordBalance(account)
+	}
+	stored.Status, stored.UpdatedAt = ChequeCleared, time.Now().UTC()
+	c.Status, c.UpdatedAt = stored.Status, stored.UpdatedAt
+	return nil
+}
+
+func (s *MemoryStorage) BounceCheque(c *Cheque, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.cheques[c.ID]
+	if !ok || stored.Status != ChequePending {
+		return fmt.Errorf("%w: cheque %d is not pending", ErrStateConflict, c.ID)
+	}
+
+	stored.Status, stored.FailureReason, stored.UpdatedAt = ChequeBounced, reason, time.Now().UTC()
+	c.Status, c.FailureReason, c.UpdatedAt = stored.Status, stored.FailureReason, stored.UpdatedAt
+	return nil
+}
+
+func (s *MemoryStorage) CreateAuditEvent(e *AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.insertAuditEvent(e)
+	return nil
+}
+
+func (s *MemoryStorage) insertAuditEvent(e *AuditEvent) {
+	e.ID = s.nextID()
+	copied := *e
+	s.auditEvents = append(s.auditEvents, &copied)
+}
+
+func (s *MemoryStorage) GetAuditEvents(accountID, limit int) ([]*AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []*AuditEvent{}
+	for i := len(s.auditEvents) - 1; i >= 0; i-- {
+		if e := s.auditEvents[i]; accountID == 0 || e.AccountID == accountID {
+			copied := *e
+			events = append(events, &copied)
+		}
+	}
+	return limitSlice(events, limit), nil
+}
+
+func (s *MemoryStorage) GetAccountAuditEvents(accountID int, actions []string, q PageQuery) ([]*AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wanted := map[string]bool{}
+	for _, action := range actions {
+		wanted[action] = true
+	}
+	events := []*AuditEvent{}
+	for _, e := range s.auditEvents {
+		if e.AccountID == accountID && wanted[e.Action] && q.includes(e.CreatedAt, e.ID) {
+			copied := *e
+			events = append(events, &copied)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return newerFirst(events[i].CreatedAt, events[i].ID, events[j].CreatedAt, events[j].ID)
+	})
+	return limitSlice(events, q.Limit), nil
+}
+
+func (s *MemoryStorage) TransferAccountOwnership(account *Account, event *AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.accounts[account.ID]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrAccountNotFound, account.ID)
+	}
+
+	stored.FirstName, stored.LastName, stored.Phone = account.FirstName, account.LastName, account.Phone
+	stored.EncryptedPassword = account.EncryptedPassword
+	stored.TokenVersion++
+	account.TokenVersion = stored.TokenVersion
+
+	for _, d := range s.devices {
+		if d.AccountID == account.ID && d.RevokedAt == nil {
+			revokedAt := event.CreatedAt
+			d.RevokedAt = &revokedAt
+		}
+	}
+
+	s.insertAuditEvent(event)
+	return nil
+}
+
+func (s *MemoryStorage) CreateTellerApproval(a *TellerApproval) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a.ID = s.nextID()
+	copied := *a
+	s.tellerApprovals = append(s.tellerApprovals, &copied)
+	return nil
+}
+
+func (s *MemoryStorage) GetTellerApproval(publicID string) (*TellerApproval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.tellerApprovals {
+		if a.PublicID == publicID {
+			copied := *a
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrTellerApprovalNotFound, publicID)
+}
+
+func (s *MemoryStorage) GetPendingTellerApprovals() ([]*TellerApproval, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	approvals := []*TellerApproval{}
+	for _, a := range s.tellerApprovals {
+		if a.Status == TellerApprovalPending {
+			copied := *a
+			approvals = append(approvals, &copied)
+		}
+	}
+	return approvals, nil
+}
+
+func (s *MemoryStorage) DecideTellerApproval(a *TellerApproval) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, stored := range s.tellerApprovals {
+		if stored.ID != a.ID {
+			continue
+		}
+		if stored.Status != TellerApprovalPending {
+			return ErrStateConflict
+		}
+		copied := *a
+		s.tellerApprovals[i] = &copied
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrTellerApprovalNotFound, a.PublicID)
+}
+
+func (s *MemoryStorage) CreateImpersonation(i *Impersonation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i.ID = s.nextID()
+	copied := *i
+	copied.Token = ""
+	s.impersonations = append(s.impersonations, &copied)
+	return nil
+}
+
+func (s *MemoryStorage) GetImpersonation(publicID string) (*Impersonation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, i := range s.impersonations {
+		if i.PublicID == publicID {
+			copied := *i
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrImpersonationNotFound, publicID)
+}
+
+func (s *MemoryStorage) GetImpersonations(accountID int) ([]*Impersonation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	impersonations := []*Impersonation{}
+	for j := len(s.impersonations) - 1; j >= 0; j-- {
+		if i := s.impersonations[j]; i.AccountID == accountID {
+			copied := *i
+			impersonations = append(impersonations, &copied)
+		}
+	}
+	return impersonations, nil
+}
+
+func (s *MemoryStorage) UpdateImpersonation(i *Impersonation, from ImpersonationStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for j, stored := range s.impersonations {
+		if stored.ID != i.ID {
+			continue
+		}
+		if stored.Status != from {
+			return ErrStateConflict
+		}
+		copied := *i
+		copied.Token = ""
+		s.impersonations[j] = &copied
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrImpersonationNotFound, i.PublicID)
+}
+
+func (s *MemoryStorage) CreateFXQuote(q *FXQuote) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q.ID = s.nextID()
+	copied := *q
+	s.fxQuotes[q.PublicID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetFXQuote(publicID string) (*FXQuote, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.fxQuotes[publicID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFXQuoteNotFound, publicID)
+	}
+	copied := *q
+	return &copied, nil
+}
+
+func (s *MemoryStorage) UseFXQuote(q *FXQuote) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.fxQuotes[q.PublicID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrFXQuoteNotFound, q.PublicID)
+	}
+	if stored.TransferID != "" {
+		return ErrStateConflict
+	}
+	stored.TransferID, stored.UsedAt = q.TransferID, q.UsedAt
+	return nil
+}
+
+func (s *MemoryStorage) CloseAccountWithRedirect(r *AccountRedirect, event *AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[r.AccountID]; !ok {
+		return fmt.Errorf("%w: %d", ErrAccountNotFound, r.AccountID)
+	}
+	s.softDelete(r.AccountID, r.CreatedAt)
+
+	copied := *r
+	s.redirects[r.AccountID] = &copied
+	if successor, ok := s.accounts[r.SuccessorID]; ok && r.Phone != "" && successor.Phone == "" {
+		successor.Phone = r.Phone
+	}
+
+	s.insertAuditEvent(event)
+	return nil
+}
+
+func (s *MemoryStorage) GetAccountRedirect(accountID int) (*AccountRedirect, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.redirects[accountID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrAccountNotFound, accountID)
+	}
+	copied := *r
+	return &copied, nil
+}
+
+// includes mirrors the where clause the Postgres storage builds from a
+// ChangeQuery.
+func (q ChangeQuery) includes(changedAt time.Time, id int) bool {
+	return changedAt.After(q.Since) || (changedAt.Equal(q.Since) && id > q.AfterID)
+}
+
+func olderFirst(at1 time.Time, id1 int, at2 time.Time, id2 int) bool {
+	if !at1.Equal(at2) {
+		return at1.Before(at2)
+	}
+	return id1 < id2
+}
+
+func (s *MemoryStorage) GetRefunds(transferID int) ([]*Transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refunds := []*Transfer{}
+	for _, t := range s.transfers {
+		if t.RefundOf == transferID {
+			copied := *t
+			refunds = append(refunds, &copied)
+		}
+	}
+	sort.Slice(refunds, func(i, j int) bool { return refunds[i].ID < refunds[j].ID })
+	return refunds, nil
+}
+
+func (s *MemoryStorage) GetTransferChanges(q ChangeQuery) ([]*Transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transfers := []*Transfer{}
+	for _, t := range s.transfers {
+		if t.Involves(q.AccountID) && q.includes(t.UpdatedAt, t.ID) {
+			copied := *t
+			transfers = append(transfers, &copied)
+		}
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		return olderFirst(transfers[i].UpdatedAt, transfers[i].ID, transfers[j].UpdatedAt, transfers[j].ID)
+	})
+	return limitSlice(transfers, q.Limit), nil
+}
+
+func (s *MemoryStorage) GetCashOperationChanges(q ChangeQuery) ([]*CashOperation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ops := []*CashOperation{}
+	for _, op := range s.cashOperations {
+		if op.AccountID == q.AccountID && q.includes(op.CreatedAt, op.ID) {
+			copied := *op
+			ops = append(ops, &copied)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return olderFirst(ops[i].CreatedAt, ops[i].ID, ops[j].CreatedAt, ops[j].ID)
+	})
+	return limitSlice(ops, q.Limit), nil
+}
+
+func (s *MemoryStorage) GetBillPaymentChanges(q ChangeQuery) ([]*BillPayment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payments := []*BillPayment{}
+	for _, p := range s.billPayments {
+		if p.AccountID == q.AccountID && q.includes(p.UpdatedAt, p.ID) {
+			copied := *p
+			payments = append(payments, &copied)
+		}
+	}
+	sort.Slice(payments, func(i, j int) bool {
+		return olderFirst(payments[i].UpdatedAt, payments[i].ID, payments[j].UpdatedAt, payments[j].ID)
+	})
+	return limitSlice(payments, q.Limit), nil
+}
+
+func (s *MemoryStorage) AddUsage(records []UsageRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rec := range records {
+		s.usage[usageKey{rec.Consumer, rec.Metric, rec.Day, rec.Endpoint}] += rec.Count
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GetUsage(filter UsageFilter) ([]UsageRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := []UsageRecord{}
+	for k, n := range s.usage {
+		// Days are formatted as YYYY-MM-DD, so they compare as strings.
+		if (filter.Consumer == "" || k.consumer == filter.Consumer) && k.day >= filter.From && k.day <= filter.To &&
+			(k.endpoint != "") == filter.ByEndpoint {
+			records = append(records, UsageRecord{Consumer: k.consumer, Metric: k.metric, Day: k.day, Endpoint: k.endpoint, Count: n})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Consumer != b.Consumer {
+			return a.Consumer < b.Consumer
+		}
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		return a.Endpoint < b.Endpoint
+	})
+	return records, nil
+}
+
+func (s *MemoryStorage) CreateSweepRule(r *SweepRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r.ID = s.nextID()
+	r.TargetPublicID = s.accountPublicID(r.TargetAccount)
+	copied := *r
+	s.sweepRules[r.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetSweepRule(accountID, id int) (*SweepRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.sweepRules[id]
+	if !ok || r.AccountID != accountID {
+		return nil, fmt.Errorf("%w: %d", ErrSweepRuleNotFound, id)
+	}
+	copied := *r
+	return &copied, nil
+}
+
+func (s *MemoryStorage) GetSweepRules(accountID int) ([]*SweepRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := []*SweepRule{}
+	for _, r := range s.sweepRules {
+		if r.AccountID == accountID {
+			copied := *r
+			rules = append(rules, &copied)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules, nil
+}
+
+func (s *MemoryStorage) UpdateSweepRule(r *SweepRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.sweepRules[r.ID]; ok && stored.AccountID == r.AccountID {
+		r.TargetPublicID = s.accountPublicID(r.TargetAccount)
+		copied := *r
+		s.sweepRules[r.ID] = &copied
+	}
+	return nil
+}
+
+func (s *MemoryStorage) DeleteSweepRule(accountID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.sweepRules[id]; ok && r.AccountID == accountID {
+		delete(s.sweepRules, id)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) CreateFreezeWindow(w *FreezeWindow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.ID = s.nextID()
+	copied := *w
+	s.freezeWindows[w.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetFreezeWindow(accountID, id int) (*FreezeWindow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.freezeWindows[id]
+	if !ok || w.AccountID != accountID {
+		return nil, fmt.Errorf("%w: %d", ErrFreezeWindowNotFound, id)
+	}
+	copied := *w
+	return &copied, nil
+}
+
+func (s *MemoryStorage) GetFreezeWindows(accountID int) ([]*FreezeWindow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.freezeWindowsOf(accountID), nil
+}
+
+func (s *MemoryStorage) freezeWindowsOf(accountID int) []*FreezeWindow {
+	windows := []*FreezeWindow{}
+	for _, w := range s.freezeWindows {
+		if w.AccountID == accountID {
+			copied := *w
+			windows = append(windows, &copied)
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].ID < windows[j].ID })
+	return windows
+}
+
+func (s *MemoryStorage) GetAllFreezeWindows() ([]*FreezeWindow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	windows := []*FreezeWindow{}
+	for _, w := range s.freezeWindows {
+		copied := *w
+		windows = append(windows, &copied)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].ID < windows[j].ID })
+	return windows, nil
+}
+
+func (s *MemoryStorage) DeleteFreezeWindow(accountID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w, ok := s.freezeWindows[id]; ok && w.AccountID == accountID {
+		delete(s.freezeWindows, id)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) CreateDelegation(d *Delegation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d.ID = s.nextID()
+	d.DelegatePublicID = s.accountPublicID(d.DelegateAccount)
+	copied := *d
+	s.delegations[d.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetDelegations(accountID int) ([]*Delegation, error) {
+	return s.filterDelegations(func(d *Delegation) bool { return d.AccountID == accountID }), nil
+}
+
+func (s *MemoryStorage) GetDelegationsTo(delegateAccount int) ([]*Delegation, error) {
+	return s.filterDelegations(func(d *Delegation) bool { return d.DelegateAccount == delegateAccount }), nil
+}
+
+func (s *MemoryStorage) filterDelegations(match func(*Delegation) bool) []*Delegation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delegations := []*Delegation{}
+	for _, d := range s.delegations {
+		if match(d) {
+			copied := *d
+			delegations = append(delegations, &copied)
+		}
+	}
+	sort.Slice(delegations, func(i, j int) bool { return delegations[i].ID < delegations[j].ID })
+	return delegations
+}
+
+func (s *MemoryStorage) DeleteDelegation(accountID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d, ok := s.delegations[id]; ok && d.AccountID == accountID {
+		delete(s.delegations, id)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) CreateDocument(d *Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d.ID = s.nextID()
+	copied := *d
+	s.documents = append(s.documents, &copied)
+	return nil
+}
+
+func (s *MemoryStorage) GetDocuments(accountID int, kind DocumentKind) ([]*Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs := []*Document{}
+	for _, d := range s.documents {
+		if d.AccountID == accountID && (kind == "" || d.Kind == kind) {
+			copied := *d
+			docs = append(docs, &copied)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return newerFirst(docs[i].CreatedAt, docs[i].ID, docs[j].CreatedAt, docs[j].ID)
+	})
+	return docs, nil
+}
+
+func (s *MemoryStorage) GetDocumentByPublicID(publicID string) (*Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.documents {
+		if d.PublicID == publicID {
+			copied := *d
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, publicID)
+}
+
+func (s *MemoryStorage) UpdateDocumentSize(d *Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.documents {
+		if stored.ID == d.ID {
+			stored.Size = d.Size
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) CreateStatementRegeneration(job *StatementRegeneration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.ID = s.nextID()
+	copied := *job
+	s.regenerations = append(s.regenerations, &copied)
+	return nil
+}
+
+func (s *MemoryStorage) GetStatementRegeneration(publicID string) (*StatementRegeneration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.regenerations {
+		if job.PublicID == publicID {
+			copied := *job
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrRegenerationNotFound, publicID)
+}
+
+func (s *MemoryStorage) GetRunningStatementRegenerations() ([]*StatementRegeneration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := []*StatementRegeneration{}
+	for _, job := range s.regenerations {
+		if job.Status == RegenerationRunning {
+			copied := *job
+			jobs = append(jobs, &copied)
+		}
+	}
+	return jobs, nil
+}
+
+func (s *MemoryStorage) UpdateStatementRegeneration(job *StatementRegeneration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, stored := range s.regenerations {
+		if stored.ID == job.ID {
+			copied := *job
+			s.regenerations[i] = &copied
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrRegenerationNotFound, job.PublicID)
+}
+
+func (s *MemoryStorage) GetPaperlessPreferences(accountID int) (*PaperlessPreferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.paperless[accountID]
+	if !ok {
+		return defaultPaperlessPreferences(accountID), nil
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (s *MemoryStorage) UpdatePaperlessPreferences(p *PaperlessPreferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *p
+	s.paperless[p.AccountID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetArchivableTransfers(before time.Time, limit int) ([]*Transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transfers := []*Transfer{}
+	for _, t := range s.transfers {
+		if !t.IsPending() && t.CreatedAt.Before(before) {
+			copied := *t
+			transfers = append(transfers, &copied)
+		}
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		return olderFirst(transfers[i].CreatedAt, transfers[i].ID, transfers[j].CreatedAt, transfers[j].ID)
+	})
+	return limitSlice(transfers, limit), nil
+}
+
+func (s *MemoryStorage) DeleteTransfers(ids []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		delete(s.transfers, id)
+		delete(s.transferOrigins, id)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GetArchivableAuditEvents(before time.Time, limit int) ([]*AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []*AuditEvent{}
+	for _, e := range s.auditEvents {
+		if e.CreatedAt.Before(before) {
+			copied := *e
+			events = append(events, &copied)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return olderFirst(events[i].CreatedAt, events[i].ID, events[j].CreatedAt, events[j].ID)
+	})
+	return limitSlice(events, limit), nil
+}
+
+func (s *MemoryStorage) DeleteAuditEvents(ids []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := map[int]bool{}
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	kept := s.auditEvents[:0]
+	for _, e := range s.auditEvents {
+		if !deleted[e.ID] {
+			kept = append(kept, e)
+		}
+	}
+	s.auditEvents = kept
+	return nil
+}
+
+func (s *MemoryStorage) CreateHoliday(h *Holiday) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.holidays {
+		if existing.Currency == h.Currency && existing.Date == h.Date {
+			return ErrDuplicateHoliday
+		}
+	}
+	h.ID = s.nextID()
+	copied := *h
+	s.holidays[h.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetHolidays(currency string) ([]*Holiday, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	holidays := []*Holiday{}
+	for _, h := range s.holidays {
+		if currency == "" || h.Currency == currency {
+			copied := *h
+			holidays = append(holidays, &copied)
+		}
+	}
+	sort.Slice(holidays, func(i, j int) bool {
+		if holidays[i].Date != holidays[j].Date {
+			return holidays[i].Date < holidays[j].Date
+		}
+		return holidays[i].Currency < holidays[j].Currency
+	})
+	return holidays, nil
+}
+
+func (s *MemoryStorage) DeleteHoliday(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.holidays, id)
+	return nil
+}
+
+func (s *MemoryStorage) CreateTerms(t *Terms) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.terms {
+		if existing.Version == t.Version {
+			return ErrDuplicateTermsVersion
+		}
+	}
+	t.ID = s.nextID()
+	copied := *t
+	s.terms = append(s.terms, &copied)
+	return nil
+}
+
+func (s *MemoryStorage) GetTerms() ([]*Terms, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	terms := make([]*Terms, 0, len(s.terms))
+	for _, t := range s.terms {
+		copied := *t
+		terms = append(terms, &copied)
+	}
+	sort.SliceStable(terms, func(i, j int) bool { return terms[i].PublishedAt.Before(terms[j].PublishedAt) })
+	return terms, nil
+}
+
+func (s *MemoryStorage) AcceptTerms(a *TermsAcceptance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.termsAccepted {
+		if existing.AccountID == a.AccountID && existing.Version == a.Version {
+			return nil
+		}
+	}
+	copied := *a
+	s.termsAccepted = append(s.termsAccepted, &copied)
+	return nil
+}
+
+func (s *MemoryStorage) GetTermsAcceptances(accountID int) ([]*TermsAcceptance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acceptances := []*TermsAcceptance{}
+	for _, a := range s.termsAccepted {
+		if a.AccountID == accountID {
+			copied := *a
+			acceptances = append(acceptances, &copied)
+		}
+	}
+	return acceptances, nil
+}
+
+// StartOnlineMigration records m. There is no schema to change in memory,
+// so there is nothing to backfill either.
+func (s *MemoryStorage) StartOnlineMigration(m *OnlineMigration) (*MigrationProgress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.migrations[m.Name]
+	if !ok {
+		now := time.Now().UTC()
+		p = &MigrationProgress{Name: m.Name, Phase: MigrationBackfilling, StartedAt: now, UpdatedAt: now}
+		s.migrations[m.Name] = p
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (s *MemoryStorage) BackfillOnlineMigration(m *OnlineMigration, batchSize int) (*MigrationProgress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.migrations[m.Name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMigrationNotFound, m.Name)
+	}
+	if p.Phase == MigrationBackfilling {
+		p.Phase, p.BackfilledTo, p.UpdatedAt = MigrationBackfilled, p.MaxID, time.Now().UTC()
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (s *MemoryStorage) GetMigrationProgress() ([]*MigrationProgress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	progress := []*MigrationProgress{}
+	for _, p := range s.migrations {
+		copied := *p
+		progress = append(progress, &copied)
+	}
+	sort.Slice(progress, func(i, j int) bool {
+		if !progress[i].StartedAt.Equal(progress[j].StartedAt) {
+			return progress[i].StartedAt.Before(progress[j].StartedAt)
+		}
+		return progress[i].Name < progress[j].Name
+	})
+	return progress, nil
+}
+
+func (s *MemoryStorage) CutOverOnlineMigration(name string, now time.Time) (*MigrationProgress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.migrations[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMigrationNotFound, name)
+	}
+	if p.Phase != MigrationBackfilled {
+		return nil, ErrStateConflict
+	}
+	p.Phase, p.CutOverAt, p.UpdatedAt = MigrationCutOver, &now, now
+	copied := *p
+	return &copied, nil
+}
+
+func copyEndOfDay(run *EndOfDayRun) *EndOfDayRun {
+	copied := *run
+	copied.Positions = append([]CurrencyPosition{}, run.Positions...)
+	return &copied
+}
+
+func (s *MemoryStorage) CreateEndOfDay(run *EndOfDayRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.endOfDays[run.BusinessDate]; ok {
+		return ErrStateConflict
+	}
+	run.ID = s.nextID()
+	s.endOfDays[run.BusinessDate] = copyEndOfDay(run)
+	return nil
+}
+
+func (s *MemoryStorage) GetEndOfDay(businessDate string) (*EndOfDayRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run, ok := s.endOfDays[businessDate]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEndOfDayNotFound, businessDate)
+	}
+	return copyEndOfDay(run), nil
+}
+
+func (s *MemoryStorage) GetEndOfDays(limit int) ([]*EndOfDayRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := []*EndOfDayRun{}
+	for _, run := range s.endOfDays {
+		runs = append(runs, copyEndOfDay(run))
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].BusinessDate > runs[j].BusinessDate })
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+func (s *MemoryStorage) UpdateEndOfDay(run *EndOfDayRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.endOfDays[run.BusinessDate]; !ok {
+		return fmt.Errorf("%w: %s", ErrEndOfDayNotFound, run.BusinessDate)
+	}
+	s.endOfDays[run.BusinessDate] = copyEndOfDay(run)
+	return nil
+}
+
+func (s *MemoryStorage) CreateAutomationRule(r *AutomationRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r.ID = s.nextID()
+	r.TargetPublicID = s.accountPublicID(r.TargetAccount)
+	copied := *r
+	s.automationRules[r.ID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetAutomationRule(accountID, id int) (*AutomationRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.automationRules[id]
+	if !ok || r.AccountID != accountID {
+		return nil, fmt.Errorf("%w: %d", ErrAutomationRuleNotFound, id)
+	}
+	copied := *r
+	return &copied, nil
+}
+
+func (s *MemoryStorage) GetAutomationRules(accountID int) ([]*AutomationRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := []*AutomationRule{}
+	for _, r := range s.automationRules {
+		if r.AccountID == accountID {
+			copied := *r
+			rules = append(rules, &copied)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules, nil
+}
+
+func (s *MemoryStorage) UpdateAutomationRule(r *AutomationRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.automationRules[r.ID]; ok && stored.AccountID == r.AccountID {
+		r.TargetPublicID = s.accountPublicID(r.TargetAccount)
+		copied := *r
+		s.automationRules[r.ID] = &copied
+	}
+	return nil
+}
+
+func (s *MemoryStorage) DeleteAutomationRule(accountID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.automationRules[id]; ok && r.AccountID == accountID {
+		delete(s.automationRules, id)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) CreateAutomationRun(run *AutomationRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run.ID = s.nextID()
+	copied := *run
+	s.automationRuns = append(s.automationRuns, &copied)
+	return nil
+}
+
+func (s *MemoryStorage) GetAutomationRuns(accountID, ruleID, limit int) ([]*AutomationRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := []*AutomationRun{}
+	for i := len(s.automationRuns) - 1; i >= 0 && len(runs) < limit; i-- {
+		if run := s.automationRuns[i]; run.RuleID == ruleID && run.AccountID == accountID {
+			copied := *run
+			runs = append(runs, &copied)
+		}
+	}
+	return runs, nil
+}
+
+func (s *MemoryStorage) SaveReviewCase(c *ReviewCase) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *c
+	s.reviewCases[c.TransferID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetReviewCases(transferIDs []int) (map[int]*ReviewCase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cases := map[int]*ReviewCase{}
+	for _, id := range transferIDs {
+		if c, ok := s.reviewCases[id]; ok {
+			copied := *c
+			cases[id] = &copied
+		}
+	}
+	return cases, nil
+}
+
+func copyTransactionTag(t *TransactionTag) *TransactionTag {
+	copied := *t
+	copied.Tags = append([]string{}, t.Tags...)
+	return &copied
+}
+
+func (s *MemoryStorage) SaveTransactionTags(tags []*TransactionTag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range tags {
+		if s.transactionTags[t.AccountID] == nil {
+			s.transactionTags[t.AccountID] = map[string]*TransactionTag{}
+		}
+		s.transactionTags[t.AccountID][t.TransferID] = copyTransactionTag(t)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GetTransactionTags(accountID int) (map[string]*TransactionTag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags := map[string]*TransactionTag{}
+	for id, t := range s.transactionTags[accountID] {
+		tags[id] = copyTransactionTag(t)
+	}
+	return tags, nil
+}
+
+func (s *MemoryStorage) GetDeletedAccounts(deletedBefore time.Time, afterID, limit int) ([]*DeletedAccount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts := []*DeletedAccount{}
+	for _, a := range s.deletedAccounts {
+		if a.DeletedAt.Before(deletedBefore) && a.ID > afterID {
+			copied := *a
+			_, copied.LegalHold = s.legalHolds[a.ID]
+			accounts = append(accounts, &copied)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	if len(accounts) > limit {
+		accounts = accounts[:limit]
+	}
+	return accounts, nil
+}
+
+func (s *MemoryStorage) PurgeAccount(id int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, held := s.legalHolds[id]
+	if _, ok := s.deletedAccounts[id]; !ok || held {
+		return 0, fmt.Errorf("%w: account %d is live or on legal hold", ErrStateConflict, id)
+	}
+	delete(s.deletedAccounts, id)
+	records := int64(1)
+
+	attempts := s.loginAttempts[:0]
+	for _, a := range s.loginAttempts {
+		if a.AccountID != nil && *a.AccountID == id {
+			records++
+			continue
+		}
+		attempts = append(attempts, a)
+	}
+	s.loginAttempts = attempts
+	for key, d := range s.devices {
+		if d.AccountID == id {
+			delete(s.devices, key)
+			records++
+		}
+	}
+	for key, c := range s.loginChallenges {
+		if c.AccountID == id {
+			delete(s.loginChallenges, key)
+			records++
+		}
+	}
+	for key, r := range s.alertRules {
+		if r.AccountID == id {
+			delete(s.alertRules, key)
+			records++
+		}
+	}
+	for key, c := range s.contacts {
+		if c.AccountID == id {
+			delete(s.contacts, key)
+			records++
+		}
+	}
+	for key, r := range s.sweepRules {
+		if r.AccountID == id {
+			delete(s.sweepRules, key)
+			records++
+		}
+	}
+	for key, r := range s.automationRules {
+		if r.AccountID == id {
+			delete(s.automationRules, key)
+			records++
+		}
+	}
+	runs := s.automationRuns[:0]
+	for _, r := range s.automationRuns {
+		if r.AccountID == id {
+			records++
+			continue
+		}
+		runs = append(runs, r)
+	}
+	s.automationRuns = runs
+	for key, w := range s.freezeWindows {
+		if w.AccountID == id {
+			delete(s.freezeWindows, key)
+			records++
+		}
+	}
+	for key, e := range s.webhooks {
+		if e.AccountID == id {
+			delete(s.webhooks, key)
+			records++
+		}
+	}
+	for key, d := range s.delegations {
+		if d.AccountID == id || d.DelegateAccount == id {
+			delete(s.delegations, key)
+			records++
+		}
+	}
+	if _, ok := s.paperless[id]; ok {
+		delete(s.paperless, id)
+		records++
+	}
+	records += int64(len(s.idempotencyKeys[id]) + len(s.transactionTags[id]))
+	delete(s.idempotencyKeys, id)
+	delete(s.transactionTags, id)
+	return records, nil
+}
+
+func (s *MemoryStorage) PlaceLegalHold(h *LegalHold) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *h
+	s.legalHolds[h.AccountID] = &copied
+	return nil
+}
+
+func (s *MemoryStorage) GetLegalHold(accountID int) (*LegalHold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.legalHolds[accountID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrLegalHoldNotFound, accountID)
+	}
+	copied := *h
+	return &copied, nil
+}
+
+func (s *MemoryStorage) ReleaseLegalHold(accountID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.legalHolds[accountID]; !ok {
+		return fmt.Errorf("%w: %d", ErrLegalHoldNotFound, accountID)
+	}
+	delete(s.legalHolds, accountID)
+	return nil
+}
+
+func copyWebhookEndpoint(e *WebhookEndpoint) *WebhookEndpoint {
+	copied := *e
+	copied.EventTypes = append([]string{}, e.EventTypes...)
+	if e.DisabledAt != nil {
+		disabledAt := *e.DisabledAt
+		copied.DisabledAt = &disabledAt
+	}
+	copied.Health = nil
+	return &copied
+}
+
+func (s *MemoryStorage) CreateWebhookEndpoint(e *WebhookEndpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.ID = s.nextID()
+	s.webhooks[e.ID] = copyWebhookEndpoint(e)
+	return nil
+}
+
+func (s *MemoryStorage) GetWebhookEndpoint(accountID, id int) (*WebhookEndpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.webhooks[id]
+	if !ok || e.AccountID != accountID {
+		return nil, fmt.Errorf("%w: %d", ErrWebhookNotFound, id)
+	}
+	return copyWebhookEndpoint(e), nil
+}
+
+func (s *MemoryStorage) GetWebhookEndpoints(accountID int) ([]*WebhookEndpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoints := []*WebhookEndpoint{}
+	for _, e := range s.webhooks {
+		if e.AccountID == accountID {
+			endpoints = append(endpoints, copyWebhookEndpoint(e))
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].ID < endpoints[j].ID })
+	return endpoints, nil
+}
+
+func (s *MemoryStorage) UpdateWebhookEndpoint(e *WebhookEndpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.webhooks[e.ID]
+	if !ok || stored.AccountID != e.AccountID {
+		return fmt.Errorf("%w: %d", ErrWebhookNotFound, e.ID)
+	}
+	updated := copyWebhookEndpoint(stored)
+	updated.Status, updated.DisabledReason, updated.DisabledAt = e.Status, e.DisabledReason, copyWebhookEndpoint(e).DisabledAt
+	s.webhooks[e.ID] = updated
+	return nil
+}
+
+func (s *MemoryStorage) DeleteWebhookEndpoint(accountID, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.webhooks[id]; ok && e.AccountID == accountID {
+		delete(s.webhooks, id)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LedgerMetrics keeps business metrics for finance and ops dashboards and
+// serves them at /metrics in the Prometheus text format. Like the admin
+// routes it needs the admin token, which Prometheus sends as the basic
+// auth password. HTTP and runtime numbers stay in /debug/vars.
+//
+// Postings through the AlertingStorage keep the numbers current: settled
+// transfers count towards the transfer totals, and cash, bill payments and
+// cheques move the deposits held. Transfers between customers don't, as
+// they can't reach system accounts. Resync recomputes the deposits and
+// the frozen account count from storage, which also picks up what the
+// postings don't see, such as sandbox opening balances.
+type LedgerMetrics struct {
+	ResyncInterval time.Duration
+
+	storage       Storage
+	notifications *QueuedNotifier
+
+	mu             sync.Mutex
+	depositsHeld   map[string]int64
+	transferCount  map[string]int64
+	transferValue  map[string]int64
+	frozenAccounts int
+	resyncedAt     time.Time
+}
+
+func ledgerMetricsFromEnv(store Storage, notifications *QueuedNotifier) *LedgerMetrics {
+	return &LedgerMetrics{
+		ResyncInterval: getEnvDuration("METRICS_RESYNC_INTERVAL", 5*time.Minute),
+		storage:        store,
+		notifications:  notifications,
+		depositsHeld:   map[string]int64{},
+		transferCount:  map[string]int64{},
+		transferValue:  map[string]int64{},
+	}
+}
+
+func (m *LedgerMetrics) Run() {
+	ticker := time.NewTicker(m.ResyncInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Resync(time.Now().UTC()); err != nil {
+			log.Println("Failed to resync ledger metrics: ", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Resync recomputes the gauges from storage.
+func (m *LedgerMetrics) Resync(now time.Time) error {
+	report, err := m.storage.GetReconciliationReport(now)
+	if err != nil {
+		return err
+	}
+	windows, err := m.storage.GetAllFreezeWindows()
+	if err != nil {
+		return err
+	}
+
+	frozen := map[int]bool{}
+	for _, w := range windows {
+		if w.Active(now) {
+			frozen[w.AccountID] = true
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.depositsHeld = map[string]int64{}
+	for _, b := range report.Balances {
+		m.depositsHeld[b.Currency] = b.Total.Amount
+	}
+	m.frozenAccounts = len(frozen)
+	m.resyncedAt = now
+	return nil
+}
+
+// settled counts a transfer that just settled.
+func (m *LedgerMetrics) settled(t *Transfer) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.transferCount[t.Amount.Currency]++
+	m.transferValue[t.Amount.Currency] += t.Amount.Amount
+}
+
+// moved adjusts the deposits held by money entering (positive) or leaving
+// (negative) customer accounts.
+func (m *LedgerMetrics) moved(amount Money) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.depositsHeld[amount.Currency] += amount.Amount
+}
+
+// metricFamily is one metric in the Prometheus text format, with a sample
+// per currency or lane.
+type metricFamily struct {
+	name, kind, help, label string
+	samples                 map[string]float64
+}
+
+func (f metricFamily) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+	if f.label == "" {
+		fmt.Fprintf(w, "%s %g\n", f.name, f.samples[""])
+		return
+	}
+
+	keys := make([]string, 0, len(f.samples))
+	for k := range f.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %g\n", f.name, f.label, k, f.samples[k])
+	}
+}
+
+// majorUnits converts minor unit amounts per currency to the major units
+// Prometheus expects.
+func majorUnits(amounts map[string]int64) map[string]float64 {
+	samples := map[string]float64{}
+	for currency, amount := range amounts {
+		samples[currency] = float64(amount) / 100
+	}
+	return samples
+}
+
+func (m *LedgerMetrics) families() []metricFamily {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := map[string]float64{}
+	for currency, n := range m.transferCount {
+		counts[currency] = float64(n)
+	}
+	families := []metricFamily{
+		{"bank_deposits_held", "gauge", "Money held in customer accounts.", "currency", majorUnits(m.depositsHeld)},
+		{"bank_transfers_settled_total", "counter", "Transfers settled since the server started.", "currency", counts},
+		{"bank_transfers_settled_value_total", "counter", "Value of the transfers settled since the server started; take increase() over 1d for the daily value.", "currency", majorUnits(m.transferValue)},
+		{"bank_frozen_accounts", "gauge", "Accounts in an active freeze window.", "", map[string]float64{"": float64(m.frozenAccounts)}},
+	}
+	if !m.resyncedAt.IsZero() {
+		families = append(families, metricFamily{"bank_metrics_resynced_timestamp_seconds", "gauge", "When the gauges were last recomputed from storage.", "", map[string]float64{"": float64(m.resyncedAt.Unix())}})
+	}
+
+	if m.notifications != nil {
+		queued := map[string]float64{}
+		for priority, lane := range m.notifications.lanes {
+			queued[string(priority)] = float64(len(lane.queue))
+		}
+		families = append(families, metricFamily{"bank_notifications_queued", "gauge", "Notifications waiting for delivery per lane.", "lane", queued})
+	}
+	return families
+}
+
+// HandleDebugVars serves the expvar variables. They include what chaos
+// mode injected and per-route traffic, so only admins get to see them.
+func (s *APIServer) HandleDebugVars(w http.ResponseWriter, r *http.Request) error {
+	expvar.Handler().ServeHTTP(w, r)
+	return nil
+}
+
+func (s *APIServer) HandleMetrics(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	for _, f := range s.metrics.families() {
+		f.write(w)
+	}
+	return nil
+}
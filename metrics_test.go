@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedgerMetrics(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	store := NewMemoryStorage()
+	server := NewAPIServer(":0", store)
+	router := server.Router()
+	from := createTestAccount(t, store, 10000)
+	to := createTestAccount(t, store, 0)
+
+	now := time.Now().UTC()
+	startsAt, endsAt := now.Add(-time.Hour), now.Add(time.Hour)
+	assert.Nil(t, store.CreateFreezeWindow(&FreezeWindow{AccountID: to.ID, StartsAt: &startsAt, EndsAt: &endsAt, CreatedAt: now}))
+	assert.Nil(t, server.metrics.Resync(now))
+
+	transfer := NewTransfer(from.ID, to.ID, NewMoney(2550, defaultCurrency))
+	assert.Nil(t, server.storage.CreateTransfer(transfer))
+	assert.Nil(t, server.storage.ExecuteTransfer(transfer))
+
+	deposit := &CashOperation{PublicID: NewULID(), AccountID: from.ID, Kind: CashDeposit, Amount: NewMoney(500, defaultCurrency), CreatedAt: now}
+	assert.Nil(t, server.storage.ExecuteCashOperation(deposit, atmDailyWithdrawalLimit(defaultCurrency)))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.SetBasicAuth("prometheus", "test-admin")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	body := rec.Body.String()
+	assert.Contains(t, body, "# TYPE bank_deposits_held gauge\n")
+	assert.Contains(t, body, `bank_deposits_held{currency="USD"} 105`+"\n")
+	assert.Contains(t, body, `bank_transfers_settled_total{currency="USD"} 1`+"\n")
+	assert.Contains(t, body, `bank_transfers_settled_value_total{currency="USD"} 25.5`+"\n")
+	assert.Contains(t, body, "bank_frozen_accounts 1\n")
+	assert.Contains(t, body, `bank_notifications_queued{lane="high"} 0`+"\n")
+}
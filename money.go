@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const defaultCurrency = "USD"
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrMoneyOverflow    = errors.New("money overflow")
+	ErrMoneyNotInteger  = errors.New("amount must be a whole number of minor units")
+)
+
+// moneyAmountsAsStrings makes amounts encode as JSON strings. Clients that
+// parse every JSON number into a float64, like browsers, lose precision
+// above 2^53 otherwise. Amounts are accepted in either form regardless.
+var moneyAmountsAsStrings = getEnvBool("JSON_AMOUNTS_AS_STRINGS", false)
+
+// Money is an amount in the minor units of its currency (cents for USD,
+// see currencyRules for the others).
+// Balances and amounts must never be handled as floats.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	if (o.Amount > 0 && m.Amount > math.MaxInt64-o.Amount) ||
+		(o.Amount < 0 && m.Amount < math.MinInt64-o.Amount) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == math.MinInt64 {
+		return Money{}, ErrMoneyOverflow
+	}
+	return m.Add(o.Negate())
+}
+
+func (m Money) Negate() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Cmp returns -1, 0 or +1 depending on whether m is less than, equal to or
+// greater than o. Both values must share a currency.
+func (m Money) Cmp(o Money) (int, error) {
+	if m.Currency != o.Currency {
+		return 0, ErrCurrencyMismatch
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (m Money) String() string {
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+	}
+	abs := uint64(amount)
+	if amount < 0 {
+		abs = uint64(-(amount + 1)) + 1
+	}
+	units := currencyRule(m.Currency).MinorUnits
+	if units == 0 {
+		return fmt.Sprintf("%s%d %s", sign, abs, m.Currency)
+	}
+	scale := uint64(1)
+	for i := 0; i < units; i++ {
+		scale *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d %s", sign, abs/scale, units, abs%scale, m.Currency)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	if moneyAmountsAsStrings {
+		return json.Marshal(struct {
+			Amount   string `json:"amount"`
+			Currency string `json:"currency"`
+		}{strconv.FormatInt(m.Amount, 10), m.Currency})
+	}
+
+	type plain Money
+	return json.Marshal(plain(m))
+}
+
+// UnmarshalJSON reads the amount as a JSON number or string without ever
+// going through a float64, and rejects fractions and out of range values
+// rather than rounding them.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Amount   json.RawMessage `json:"amount"`
+		Currency string          `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	amount := int64(0)
+	if len(raw.Amount) > 0 && !bytes.Equal(raw.Amount, []byte("null")) {
+		digits := string(raw.Amount)
+		if raw.Amount[0] == '"' {
+			if err := json.Unmarshal(raw.Amount, &digits); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if amount, err = strconv.ParseInt(digits, 10, 64); err != nil {
+			if errors.Is(err, strconv.ErrRange) {
+				return ErrMoneyOverflow
+			}
+			return ErrMoneyNotInteger
+		}
+	}
+
+	m.Amount, m.Currency = amount, raw.Currency
+	return nil
+}
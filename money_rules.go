@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var ErrInvalidRate = errors.New("invalid rate")
+
+// RoundingMode says how an amount that falls between two minor units is
+// rounded.
+type RoundingMode int
+
+const (
+	// RoundHalfEven rounds ties to the even neighbour, so rounding many
+	// amounts doesn't drift in either direction.
+	RoundHalfEven RoundingMode = iota
+	// RoundHalfUp rounds ties away from zero.
+	RoundHalfUp
+	// RoundDown truncates toward zero.
+	RoundDown
+)
+
+// CurrencyRule is how amounts of a currency are stored and rounded.
+type CurrencyRule struct {
+	// MinorUnits is the number of decimals of the currency: an Amount of
+	// 1 is 0.01 USD, 1 JPY and 0.001 BHD.
+	MinorUnits int
+	// Rounding applies to amounts computed from rates, such as fees,
+	// interest and FX conversions.
+	Rounding RoundingMode
+}
+
+// currencyRules lists the currencies whose minor units aren't 2, and
+// any with their own rounding, per ISO 4217.
+var currencyRules = map[string]CurrencyRule{
+	"BIF": {MinorUnits: 0},
+	"CLP": {MinorUnits: 0},
+	"DJF": {MinorUnits: 0},
+	"GNF": {MinorUnits: 0},
+	"ISK": {MinorUnits: 0},
+	"JPY": {MinorUnits: 0},
+	"KMF": {MinorUnits: 0},
+	"KRW": {MinorUnits: 0},
+	"PYG": {MinorUnits: 0},
+	"RWF": {MinorUnits: 0},
+	"UGX": {MinorUnits: 0},
+	"VND": {MinorUnits: 0},
+	"VUV": {MinorUnits: 0},
+	"XAF": {MinorUnits: 0},
+	"XOF": {MinorUnits: 0},
+	"XPF": {MinorUnits: 0},
+	"BHD": {MinorUnits: 3},
+	"IQD": {MinorUnits: 3},
+	"JOD": {MinorUnits: 3},
+	"KWD": {MinorUnits: 3},
+	"LYD": {MinorUnits: 3},
+	"OMR": {MinorUnits: 3},
+	"TND": {MinorUnits: 3},
+	"CLF": {MinorUnits: 4},
+	"UYW": {MinorUnits: 4},
+}
+
+// currencyRule returns the rule of currency. Currencies not listed use
+// two minor units and round half to even.
+func currencyRule(currency string) CurrencyRule {
+	if rule, ok := currencyRules[currency]; ok {
+		return rule
+	}
+	return CurrencyRule{MinorUnits: 2}
+}
+
+// Rate is an exact decimal factor, such as an FX rate or a fee or interest
+// percentage, kept as a fraction so applying it never goes through a float.
+type Rate struct {
+	Num int64
+	Den int64
+}
+
+// ParseRate reads a decimal such as "1.0834" or "0.015".
+func ParseRate(s string) (Rate, error) {
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(frac) > 18 {
+		return Rate{}, fmt.Errorf("%w: %s", ErrInvalidRate, s)
+	}
+	num, ok := new(big.Int).SetString(whole+frac, 10)
+	if !ok || whole == "" && frac == "" || !num.IsInt64() {
+		return Rate{}, fmt.Errorf("%w: %s", ErrInvalidRate, s)
+	}
+	den := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(len(frac))), nil)
+	return Rate{Num: num.Int64(), Den: den.Int64()}, nil
+}
+
+// BasisPoints is a rate of bps hundredths of a percent, how fees are
+// usually quoted.
+func BasisPoints(bps int64) Rate {
+	return Rate{Num: bps, Den: 10_000}
+}
+
+// ApplyRate returns m times rate in the currency of m, rounded by its
+// currency rule. Fees and interest are computed with it.
+func (m Money) ApplyRate(rate Rate) (Money, error) {
+	if rate.Den <= 0 {
+		return Money{}, ErrInvalidRate
+	}
+	num := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(rate.Num))
+	return roundedMoney(num, big.NewInt(rate.Den), m.Currency)
+}
+
+// Convert returns m in currency to at rate, the units of to one unit of
+// the currency of m buys. It accounts for the currencies having different
+// minor units and rounds by the rule of to.
+func (m Money) Convert(to string, rate Rate) (Money, error) {
+	if rate.Den <= 0 || rate.Num <= 0 {
+		return Money{}, ErrInvalidRate
+	}
+	num := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(rate.Num))
+	num.Mul(num, pow10(currencyRule(to).MinorUnits))
+	den := new(big.Int).Mul(big.NewInt(rate.Den), pow10(currencyRule(m.Currency).MinorUnits))
+	return roundedMoney(num, den, to)
+}
+
+// roundedMoney is num/den rounded to a whole minor unit of currency. den
+// must be positive.
+func roundedMoney(num, den *big.Int, currency string) (Money, error) {
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Sign() != 0 {
+		// Compare twice the remainder with den to tell below, at and
+		// above the half.
+		half := new(big.Int).Abs(r)
+		half.Lsh(half, 1)
+		cmp := half.Cmp(den)
+
+		away := false
+		switch currencyRule(currency).Rounding {
+		case RoundHalfEven:
+			away = cmp > 0 || cmp == 0 && q.Bit(0) == 1
+		case RoundHalfUp:
+			away = cmp >= 0
+		}
+		if away {
+			q.Add(q, big.NewInt(int64(num.Sign())))
+		}
+	}
+
+	if !q.IsInt64() {
+		return Money{}, ErrMoneyOverflow
+	}
+	return NewMoney(q.Int64(), currency), nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrencyRules(t *testing.T) {
+	tests := []struct {
+		currency string
+		units    int
+		amount   int64
+		str      string
+	}{
+		{"USD", 2, 1205, "12.05 USD"},
+		{"EUR", 2, -7, "-0.07 EUR"},
+		{"XYZ", 2, 100, "1.00 XYZ"},
+		{"JPY", 0, 1500, "1500 JPY"},
+		{"KRW", 0, -3, "-3 KRW"},
+		{"ISK", 0, 0, "0 ISK"},
+		{"BHD", 3, 1500, "1.500 BHD"},
+		{"KWD", 3, 5, "0.005 KWD"},
+		{"TND", 3, -1001, "-1.001 TND"},
+		{"CLF", 4, 12345, "1.2345 CLF"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.units, currencyRule(tt.currency).MinorUnits, tt.currency)
+		assert.Equal(t, tt.str, NewMoney(tt.amount, tt.currency).String(), tt.currency)
+	}
+	assert.Equal(t, "-9223372036854775808 JPY", NewMoney(math.MinInt64, "JPY").String())
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rate
+		err  bool
+	}{
+		{"1.0834", Rate{10834, 10000}, false},
+		{"0.015", Rate{15, 1000}, false},
+		{"2", Rate{2, 1}, false},
+		{".5", Rate{5, 10}, false},
+		{"-0.25", Rate{-25, 100}, false},
+		{"", Rate{}, true},
+		{".", Rate{}, true},
+		{"1.2.3", Rate{}, true},
+		{"abc", Rate{}, true},
+		{"1e3", Rate{}, true},
+		{"0.1234567890123456789", Rate{}, true},
+		{"99999999999999999999", Rate{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if tt.err {
+			assert.ErrorIs(t, err, ErrInvalidRate, tt.in)
+			continue
+		}
+		assert.Nil(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func TestApplyRate(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount Money
+		rate   Rate
+		want   int64
+	}{
+		{"exact", NewMoney(10000, "USD"), BasisPoints(150), 150},
+		{"below half", NewMoney(1001, "USD"), BasisPoints(100), 10},
+		{"above half", NewMoney(1099, "USD"), BasisPoints(100), 11},
+		{"half to even, down", NewMoney(1050, "USD"), BasisPoints(100), 10},
+		{"half to even, up", NewMoney(1150, "USD"), BasisPoints(100), 12},
+		{"negative half to even", NewMoney(-1050, "USD"), BasisPoints(100), -10},
+		{"negative above half", NewMoney(-1099, "USD"), BasisPoints(100), -11},
+		{"negative rate", NewMoney(1099, "USD"), BasisPoints(-100), -11},
+		{"zero", NewMoney(0, "USD"), BasisPoints(250), 0},
+		{"no minor units", NewMoney(333, "JPY"), BasisPoints(50), 2},
+		{"three minor units", NewMoney(1_100, "BHD"), BasisPoints(125), 14},
+		{"large amount half to even", NewMoney(math.MaxInt64, "USD"), Rate{1, 2}, math.MaxInt64/2 + 1},
+	}
+	for _, tt := range tests {
+		got, err := tt.amount.ApplyRate(tt.rate)
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, NewMoney(tt.want, tt.amount.Currency), got, tt.name)
+	}
+
+	_, err := NewMoney(math.MaxInt64, "USD").ApplyRate(Rate{2, 1})
+	assert.ErrorIs(t, err, ErrMoneyOverflow)
+	_, err = NewMoney(100, "USD").ApplyRate(Rate{1, 0})
+	assert.ErrorIs(t, err, ErrInvalidRate)
+}
+
+func TestRoundingModes(t *testing.T) {
+	tests := []struct {
+		mode RoundingMode
+		in   []int64 // tenths of a minor unit
+		want []int64
+	}{
+		{RoundHalfEven, []int64{5, 15, 25, 14, 16, -5, -15, -25}, []int64{0, 2, 2, 1, 2, 0, -2, -2}},
+		{RoundHalfUp, []int64{5, 15, 25, 14, 16, -5, -15, -25}, []int64{1, 2, 3, 1, 2, -1, -2, -3}},
+		{RoundDown, []int64{5, 15, 25, 14, 19, -5, -15, -19}, []int64{0, 1, 2, 1, 1, 0, -1, -1}},
+	}
+	for _, tt := range tests {
+		currencyRules["TST"] = CurrencyRule{MinorUnits: 2, Rounding: tt.mode}
+		for i, in := range tt.in {
+			got, err := NewMoney(in, "TST").ApplyRate(Rate{1, 10})
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want[i], got.Amount, "mode %d, %d/10", tt.mode, in)
+		}
+	}
+	delete(currencyRules, "TST")
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name string
+		from Money
+		to   string
+		rate string
+		want int64
+	}{
+		{"same minor units", NewMoney(10000, "USD"), "EUR", "0.9231", 9231},
+		{"rounds in target", NewMoney(1, "USD"), "EUR", "0.925", 1},
+		{"to no minor units", NewMoney(10000, "USD"), "JPY", "151.37", 15137},
+		{"from no minor units", NewMoney(15137, "JPY"), "USD", "0.0066064", 10000},
+		{"to three minor units", NewMoney(10000, "USD"), "BHD", "0.376", 37600},
+		{"from three minor units", NewMoney(37600, "BHD"), "USD", "2.6596", 10000},
+		{"three to none, half to even", NewMoney(1000, "KWD"), "JPY", "492.5", 492},
+		{"negative", NewMoney(-10000, "USD"), "JPY", "151.375", -15138},
+	}
+	for _, tt := range tests {
+		rate, err := ParseRate(tt.rate)
+		assert.Nil(t, err, tt.name)
+		got, err := tt.from.Convert(tt.to, rate)
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, NewMoney(tt.want, tt.to), got, tt.name)
+	}
+
+	_, err := NewMoney(100, "USD").Convert("EUR", Rate{0, 1})
+	assert.ErrorIs(t, err, ErrInvalidRate)
+	_, err = NewMoney(math.MaxInt64, "JPY").Convert("BHD", Rate{1, 1})
+	assert.ErrorIs(t, err, ErrMoneyOverflow)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoneyAdd(t *testing.T) {
+	sum, err := NewMoney(150, "USD").Add(NewMoney(-200, "USD"))
+	assert.Nil(t, err)
+	assert.Equal(t, NewMoney(-50, "USD"), sum)
+
+	_, err = NewMoney(1, "USD").Add(NewMoney(1, "EUR"))
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+
+	_, err = NewMoney(math.MaxInt64, "USD").Add(NewMoney(1, "USD"))
+	assert.ErrorIs(t, err, ErrMoneyOverflow)
+
+	_, err = NewMoney(0, "USD").Sub(NewMoney(math.MinInt64, "USD"))
+	assert.ErrorIs(t, err, ErrMoneyOverflow)
+}
+
+func TestMoneyString(t *testing.T) {
+	assert.Equal(t, "12.05 USD", NewMoney(1205, "USD").String())
+	assert.Equal(t, "-0.07 USD", NewMoney(-7, "USD").String())
+	assert.Equal(t, "-92233720368547758.08 USD", NewMoney(math.MinInt64, "USD").String())
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  error
+	}{
+		{`{"amount":1205,"currency":"USD"}`, 1205, nil},
+		{`{"amount":"1205","currency":"USD"}`, 1205, nil},
+		{`{"amount":9007199254740993,"currency":"USD"}`, 9007199254740993, nil},
+		{`{"amount":9223372036854775807,"currency":"USD"}`, math.MaxInt64, nil},
+		{`{"amount":"-9223372036854775808","currency":"USD"}`, math.MinInt64, nil},
+		{`{"amount":null,"currency":"USD"}`, 0, nil},
+		{`{"currency":"USD"}`, 0, nil},
+		{`{"amount":9223372036854775808,"currency":"USD"}`, 0, ErrMoneyOverflow},
+		{`{"amount":"-9223372036854775809","currency":"USD"}`, 0, ErrMoneyOverflow},
+		{`{"amount":12.5,"currency":"USD"}`, 0, ErrMoneyNotInteger},
+		{`{"amount":1e3,"currency":"USD"}`, 0, ErrMoneyNotInteger},
+		{`{"amount":"12 USD","currency":"USD"}`, 0, ErrMoneyNotInteger},
+	}
+
+	for _, tt := range tests {
+		var m Money
+		err := json.Unmarshal([]byte(tt.in), &m)
+		if tt.err != nil {
+			assert.ErrorIs(t, err, tt.err, tt.in)
+			continue
+		}
+		assert.Nil(t, err, tt.in)
+		assert.Equal(t, NewMoney(tt.want, "USD"), m, tt.in)
+	}
+}
+
+func TestMoneyMarshalJSON(t *testing.T) {
+	out, err := json.Marshal(NewMoney(math.MaxInt64, "USD"))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"amount":9223372036854775807,"currency":"USD"}`, string(out))
+
+	moneyAmountsAsStrings = true
+	defer func() { moneyAmountsAsStrings = false }()
+
+	out, err = json.Marshal(NewMoney(math.MinInt64, "USD"))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"amount":"-9223372036854775808","currency":"USD"}`, string(out))
+
+	var m Money
+	assert.Nil(t, json.Unmarshal(out, &m))
+	assert.Equal(t, NewMoney(math.MinInt64, "USD"), m)
+}
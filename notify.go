@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+type NotificationKind string
+
+const (
+	NotifyNewDeviceLogin NotificationKind = "login.new_device"
+)
+
+type Notification struct {
+	AccountID int              `json:"-"`
+	Kind      NotificationKind `json:"kind"`
+	Message   string           `json:"message"`
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
+func NewNotification(accountID int, kind NotificationKind, message string) Notification {
+	return Notification{
+		AccountID: accountID,
+		Kind:      kind,
+		Message:   message,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Notifier delivers messages to customers.
+type Notifier interface {
+	Notify(Notification) error
+}
+
+// LogNotifier writes notifications to the server log. It stands in for a
+// real SMS/email provider.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(n Notification) error {
+	log.Printf("Notification for account %d [%s]: %s\n", n.AccountID, n.Kind, n.Message)
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"log"
+	"strings"
+)
+
+type NotificationPriority string
+
+// Notifications are delivered in lanes by priority. Each lane has its own
+// queue and workers, so a backlog of low priority mail never delays a
+// verification code.
+const (
+	PriorityHigh   NotificationPriority = "high"
+	PriorityNormal NotificationPriority = "normal"
+	PriorityLow    NotificationPriority = "low"
+)
+
+var notificationStats = expvar.NewMap("notifications")
+
+var errNotificationQueueFull = errors.New("notification queue is full")
+
+// notificationPriority ranks a notification by its kind. Security codes go
+// first; statements and marketing can wait.
+func notificationPriority(kind NotificationKind) NotificationPriority {
+	switch {
+	case kind == NotifyNewDeviceLogin:
+		return PriorityHigh
+	case strings.HasPrefix(string(kind), "statement.") || strings.HasPrefix(string(kind), "marketing."):
+		return PriorityLow
+	}
+	return PriorityNormal
+}
+
+type notificationLane struct {
+	queue   chan Notification
+	workers int
+}
+
+// QueuedNotifier delivers notifications asynchronously through another
+// Notifier.
+type QueuedNotifier struct {
+	next  Notifier
+	lanes map[NotificationPriority]*notificationLane
+}
+
+// NewQueuedNotifierFromEnv sizes the lanes from NOTIFY_WORKERS_HIGH,
+// NOTIFY_WORKERS_NORMAL, NOTIFY_WORKERS_LOW and NOTIFY_QUEUE_SIZE.
+func NewQueuedNotifierFromEnv(next Notifier) *QueuedNotifier {
+	size := int(getEnvInt("NOTIFY_QUEUE_SIZE", 1000))
+	return NewQueuedNotifier(next, size, map[NotificationPriority]int{
+		PriorityHigh:   int(getEnvInt("NOTIFY_WORKERS_HIGH", 4)),
+		PriorityNormal: int(getEnvInt("NOTIFY_WORKERS_NORMAL", 2)),
+		PriorityLow:    int(getEnvInt("NOTIFY_WORKERS_LOW", 1)),
+	})
+}
+
+func NewQueuedNotifier(next Notifier, queueSize int, workers map[NotificationPriority]int) *QueuedNotifier {
+	q := &QueuedNotifier{next: next, lanes: map[NotificationPriority]*notificationLane{}}
+	for priority, n := range workers {
+		lane := &notificationLane{queue: make(chan Notification, queueSize), workers: n}
+		q.lanes[priority] = lane
+		notificationStats.Set("queued."+string(priority), expvar.Func(func() any { return len(lane.queue) }))
+	}
+	return q
+}
+
+// Start launches the delivery workers of every lane.
+func (q *QueuedNotifier) Start() {
+	for priority, lane := range q.lanes {
+		for i := 0; i < lane.workers; i++ {
+			go q.work(priority, lane)
+		}
+	}
+}
+
+func (q *QueuedNotifier) work(priority NotificationPriority, lane *notificationLane) {
+	for n := range lane.queue {
+		q.deliver(priority, n)
+	}
+}
+
+func (q *QueuedNotifier) deliver(priority NotificationPriority, n Notification) error {
+	if err := q.next.Notify(n); err != nil {
+		notificationStats.Add("failed."+string(priority), 1)
+		log.Printf("Failed to deliver %s notification to account %d: %v\n", n.Kind, n.AccountID, err)
+		return err
+	}
+	notificationStats.Add("delivered."+string(priority), 1)
+	return nil
+}
+
+// Notify queues n in the lane for its priority. High priority
+// notifications are delivered right away when their lane is full rather
+// than dropped; other lanes report the overflow.
+func (q *QueuedNotifier) Notify(n Notification) error {
+	priority := notificationPriority(n.Kind)
+	lane, ok := q.lanes[priority]
+	if !ok {
+		lane, priority = q.lanes[PriorityNormal], PriorityNormal
+	}
+
+	select {
+	case lane.queue <- n:
+		return nil
+	default:
+	}
+
+	if priority == PriorityHigh {
+		return q.deliver(priority, n)
+	}
+	notificationStats.Add("dropped."+string(priority), 1)
+	return errNotificationQueueFull
+}
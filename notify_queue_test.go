@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingNotifier holds statement deliveries until released and reports
+// every other delivery on delivered.
+type blockingNotifier struct {
+	release   chan struct{}
+	delivered chan Notification
+}
+
+func (n *blockingNotifier) Notify(msg Notification) error {
+	if strings.HasPrefix(string(msg.Kind), "statement.") {
+		<-n.release
+		return nil
+	}
+	n.delivered <- msg
+	return nil
+}
+
+func TestNotificationPriority(t *testing.T) {
+	assert.Equal(t, PriorityHigh, notificationPriority(NotifyNewDeviceLogin))
+	assert.Equal(t, PriorityNormal, notificationPriority(NotifyCashDeposit))
+	assert.Equal(t, PriorityLow, notificationPriority("statement.ready"))
+}
+
+func TestQueuedNotifierHighPriorityBypassesBacklog(t *testing.T) {
+	next := &blockingNotifier{release: make(chan struct{}), delivered: make(chan Notification, 1)}
+	defer close(next.release)
+
+	q := NewQueuedNotifier(next, 100, map[NotificationPriority]int{
+		PriorityHigh: 1, PriorityNormal: 1, PriorityLow: 1,
+	})
+	q.Start()
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, q.Notify(NewNotification(1, "statement.ready", "Your statement is ready.")))
+	}
+	assert.Nil(t, q.Notify(NewNotification(1, NotifyNewDeviceLogin, "Your code is 123456.")))
+
+	select {
+	case n := <-next.delivered:
+		assert.Equal(t, NotifyNewDeviceLogin, n.Kind)
+	case <-time.After(time.Second):
+		t.Fatal("verification code was held up by the statement backlog")
+	}
+}
+
+func TestQueuedNotifierOverflow(t *testing.T) {
+	next := &blockingNotifier{release: make(chan struct{}), delivered: make(chan Notification, 1)}
+
+	// No workers, so the one slot per lane stays taken.
+	q := NewQueuedNotifier(next, 1, map[NotificationPriority]int{PriorityHigh: 0, PriorityNormal: 0, PriorityLow: 0})
+
+	assert.Nil(t, q.Notify(NewNotification(1, "statement.ready", "")))
+	assert.ErrorIs(t, q.Notify(NewNotification(1, "statement.ready", "")), errNotificationQueueFull)
+
+	assert.Nil(t, q.Notify(NewNotification(1, NotifyNewDeviceLogin, "")))
+	assert.Nil(t, q.Notify(NewNotification(1, NotifyNewDeviceLogin, "")))
+	assert.Equal(t, NotifyNewDeviceLogin, (<-next.delivered).Kind)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// OnlineMigration changes a column of a live table without downtime, the
+// way public ids replaced integer ids and minor units replaced float
+// balances, in three steps:
+//
+//  1. Expand adds the new column and a trigger that dual-writes it from
+//     the old one, so every row written from then on has both.
+//  2. The MigrationRunner backfills the rows written before, in id order
+//     and in batches, recording how far it got so it resumes after a
+//     restart.
+//  3. Once backfilled, an admin cuts the migration over. Code reading the
+//     column checks MigrationRunner.CutOver to switch from the old column
+//     to the new one; the old column and trigger are dropped by a regular
+//     migration once no running version reads them.
+//
+// Expand runs on every start and has to be idempotent. Backfill sets the
+// new column of the rows with ids in ($1, $2]; the trigger keeps rows
+// updated meanwhile, so backfilling a row twice must be harmless.
+type OnlineMigration struct {
+	Name     string
+	Table    string
+	Expand   string
+	Backfill string
+}
+
+type MigrationPhase string
+
+const (
+	MigrationBackfilling MigrationPhase = "backfilling"
+	MigrationBackfilled  MigrationPhase = "backfilled"
+	MigrationCutOver     MigrationPhase = "cut_over"
+)
+
+// MigrationProgress is how far an online migration got. Rows up to MaxID,
+// the highest id when it started, need backfilling; later ones were
+// dual-written.
+type MigrationProgress struct {
+	Name         string         `json:"name"`
+	Phase        MigrationPhase `json:"phase"`
+	BackfilledTo int            `json:"backfilledTo"`
+	MaxID        int            `json:"maxId"`
+	StartedAt    time.Time      `json:"startedAt"`
+	UpdatedAt    time.Time      `json:"updatedAt"`
+	CutOverAt    *time.Time     `json:"cutOverAt,omitempty"`
+}
+
+// percent is the share of rows backfilled.
+func (p *MigrationProgress) percent() float64 {
+	if p.MaxID == 0 || p.Phase != MigrationBackfilling {
+		return 100
+	}
+	return float64(p.BackfilledTo) * 100 / float64(p.MaxID)
+}
+
+// onlineMigrations are run by the MigrationRunner, oldest first. Keep them
+// until their cleanup migration ships.
+var onlineMigrations = []*OnlineMigration{}
+
+// MigrationRunner backfills online migrations in the background and
+// caches which ones are cut over.
+type MigrationRunner struct {
+	Interval  time.Duration
+	BatchSize int
+
+	storage    Storage
+	migrations []*OnlineMigration
+
+	mu      sync.RWMutex
+	cutOver map[string]bool
+}
+
+func migrationRunnerFromEnv(store Storage) *MigrationRunner {
+	return NewMigrationRunner(store, onlineMigrations,
+		getEnvDuration("BACKFILL_INTERVAL", 10*time.Second), int(getEnvInt("BACKFILL_BATCH_SIZE", 1000)))
+}
+
+func NewMigrationRunner(store Storage, migrations []*OnlineMigration, interval time.Duration, batchSize int) *MigrationRunner {
+	return &MigrationRunner{
+		Interval:   interval,
+		BatchSize:  batchSize,
+		storage:    store,
+		migrations: migrations,
+		cutOver:    map[string]bool{},
+	}
+}
+
+func (m *MigrationRunner) Run() {
+	if len(m.migrations) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.step(); err != nil {
+			log.Println("Failed to run online migrations: ", err)
+		}
+		<-ticker.C
+	}
+}
+
+// step expands every migration and backfills one batch of each that is
+// still backfilling, pausing between batches so the backfill doesn't
+// starve live traffic.
+func (m *MigrationRunner) step() error {
+	for _, migration := range m.migrations {
+		progress, err := m.storage.StartOnlineMigration(migration)
+		if err != nil {
+			return err
+		}
+		if progress.Phase == MigrationBackfilling {
+			if progress, err = m.storage.BackfillOnlineMigration(migration, m.BatchSize); err != nil {
+				return err
+			}
+			if progress.Phase == MigrationBackfilled {
+				log.Printf("Online migration %s backfilled, ready to cut over\n", migration.Name)
+			}
+		}
+	}
+	return m.refresh()
+}
+
+func (m *MigrationRunner) refresh() error {
+	progress, err := m.storage.GetMigrationProgress()
+	if err != nil {
+		return err
+	}
+
+	cutOver := map[string]bool{}
+	for _, p := range progress {
+		cutOver[p.Name] = p.Phase == MigrationCutOver
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cutOver = cutOver
+	return nil
+}
+
+// CutOver reports whether reads of the migration should use the new
+// column.
+func (m *MigrationRunner) CutOver(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cutOver[name]
+}
+
+// MigrationStatus is MigrationProgress as reported to admins.
+type MigrationStatus struct {
+	*MigrationProgress
+	Percent float64 `json:"percent"`
+}
+
+func (s *APIServer) HandleAdminMigrations(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	progress, err := s.storage.GetMigrationProgress()
+	if err != nil {
+		return err
+	}
+
+	statuses := make([]MigrationStatus, len(progress))
+	for i, p := range progress {
+		statuses[i] = MigrationStatus{MigrationProgress: p, Percent: p.percent()}
+	}
+	return writeJSON(w, http.StatusOK, statuses)
+}
+
+// HandleAdminCutOverMigration switches reads to the new column of a
+// backfilled migration.
+func (s *APIServer) HandleAdminCutOverMigration(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	name := mux.Vars(r)["name"]
+	progress, err := s.storage.CutOverOnlineMigration(name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if err := s.migrations.refresh(); err != nil {
+		return err
+	}
+
+	event := NewAuditEvent(adminActor(r), "migration.cut_over", 0, map[string]string{"migration": name})
+	if err := s.storage.CreateAuditEvent(event); err != nil {
+		log.Println("Failed to audit migration cutover: ", err)
+	}
+
+	return writeJSON(w, http.StatusOK, MigrationStatus{MigrationProgress: progress, Percent: progress.percent()})
+}
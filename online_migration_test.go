@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnlineMigrationCutOver(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	store := NewMemoryStorage()
+	server := NewAPIServer(":0", store)
+	server.migrations = NewMigrationRunner(server.storage, []*OnlineMigration{{Name: "account_balance_minor_units", Table: "account"}}, time.Second, 100)
+	router := server.Router()
+
+	admin := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("x-admin-token", "test-admin")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusNotFound, admin(http.MethodPost, "/admin/migrations/account_balance_minor_units/cutover").Code)
+
+	_, err := store.StartOnlineMigration(server.migrations.migrations[0])
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, admin(http.MethodPost, "/admin/migrations/account_balance_minor_units/cutover").Code)
+
+	assert.Nil(t, server.migrations.step())
+	rec := admin(http.MethodGet, "/admin/migrations")
+	assert.Contains(t, rec.Body.String(), `"phase":"backfilled"`)
+	assert.Contains(t, rec.Body.String(), `"percent":100`)
+	assert.False(t, server.migrations.CutOver("account_balance_minor_units"))
+
+	rec = admin(http.MethodPost, "/admin/migrations/account_balance_minor_units/cutover")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"phase":"cut_over"`)
+	assert.True(t, server.migrations.CutOver("account_balance_minor_units"))
+}
+
+func TestMigrationProgressPercent(t *testing.T) {
+	p := &MigrationProgress{Phase: MigrationBackfilling, BackfilledTo: 250, MaxID: 1000}
+	assert.Equal(t, 25.0, p.percent())
+
+	p.Phase = MigrationBackfilled
+	assert.Equal(t, 100.0, p.percent())
+}
@@ -0,0 +1,632 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiAuth is how an operation authenticates its caller.
+type apiAuth int
+
+const (
+	authNone apiAuth = iota
+	authCustomer
+	authAdmin
+	authTerminal
+	authTeller
+)
+
+// apiOperation describes one method of one route for the OpenAPI
+// definition served by the spec subcommand.
+//
+// OperationID is what generated SDKs name the method after, so it is part
+// of the API contract and recorded in testdata/api_contract.golden. It is
+// lowerCamel, a verb followed by the resource (listPayees, createPayee,
+// getPayee, updatePayee, deletePayee, or a domain verb like refundTransfer),
+// and back-office operations start with "admin". Renaming a handler or a
+// route never changes it.
+type apiOperation struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	Auth        apiAuth
+	Request     any
+	Response    any
+	// Status is the success status, http.StatusOK if zero.
+	Status int
+	// ContentType is set for operations answering with raw content
+	// instead of JSON.
+	ContentType string
+	// Errors are the statuses the operation can answer with besides the
+	// ones every operation with its kind of auth, body and path can.
+	Errors []int
+	// Sandbox operations are only routed when APP_ENV is sandbox.
+	Sandbox bool
+}
+
+// transferErrors are what moving money can fail with: a duplicate or a
+// state conflict, insufficient funds, and a freeze window.
+var transferErrors = []int{http.StatusConflict, http.StatusUnprocessableEntity, http.StatusLocked}
+
+// apiOperations lists every operation the router serves. TestOpenAPIRoutes
+// fails when a route is added without one.
+var apiOperations = []apiOperation{
+	{Method: http.MethodPost, Path: "/login", OperationID: "login", Summary: "Log in with account number and password",
+		Request: LoginRequest{}, Response: LoginResponse{}},
+	{Method: http.MethodPost, Path: "/login/verify", OperationID: "verifyLogin", Summary: "Complete a login challenged for an unknown device",
+		Request: VerifyLoginRequest{}, Response: LoginResponse{}},
+	{Method: http.MethodGet, Path: "/account", OperationID: "listAccounts", Summary: "List accounts",
+		Response: []*Account{}},
+	{Method: http.MethodPost, Path: "/account", OperationID: "createAccount", Summary: "Open an account",
+		Request: CreateAccountRequest{}, Response: AccountDetails{}, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodGet, Path: "/account/{id}", OperationID: "getAccount", Summary: "Get an account",
+		Auth: authCustomer, Response: AccountDetails{}},
+	{Method: http.MethodDelete, Path: "/account/{id}", OperationID: "deleteAccount", Summary: "Close an account",
+		Auth: authCustomer, Response: map[string]int{}},
+	{Method: http.MethodGet, Path: "/account/{id}/balance", OperationID: "getBalance", Summary: "Get an account's balance as of a point in time",
+		Auth: authCustomer, Response: HistoricalBalance{}, Errors: []int{http.StatusBadRequest}},
+	{Method: http.MethodGet, Path: "/account/{id}/interest/simulate", OperationID: "simulateInterest", Summary: "Project interest or a loan's amortization at a rate",
+		Auth: authCustomer, Response: InterestSimulation{}, Errors: []int{http.StatusBadRequest, http.StatusUnprocessableEntity}},
+	{Method: http.MethodGet, Path: "/account/{id}/activity", OperationID: "getAccountActivity", Summary: "Page through an account's activity feed",
+		Auth: authCustomer, Response: ActivityPage{}},
+	{Method: http.MethodGet, Path: "/account/{id}/transfers/export", OperationID: "exportTransfers", Summary: "Export all transfers of an account",
+		Auth: authCustomer, Response: []*Transfer{}},
+	{Method: http.MethodGet, Path: "/account/{id}/transactions/sync", OperationID: "syncTransactions", Summary: "Fetch transaction changes since a cursor",
+		Auth: authCustomer, Response: SyncPage{}},
+	{Method: http.MethodPost, Path: "/account/{id}/transactions/retag", OperationID: "retagTransactions", Summary: "Recategorize the transactions matching a filter",
+		Auth: authCustomer, Request: RetagRequest{}, Response: RetagResult{}},
+	{Method: http.MethodGet, Path: "/account/{id}/transactions/{transferID}/tags", OperationID: "getTransactionTags", Summary: "Get the category and tags of a transaction",
+		Auth: authCustomer, Response: TransactionTag{}},
+	{Method: http.MethodPut, Path: "/account/{id}/transactions/{transferID}/tags", OperationID: "tagTransaction", Summary: "Set the category and tags of a transaction",
+		Auth: authCustomer, Request: TagRequest{}, Response: TransactionTag{}},
+	{Method: http.MethodGet, Path: "/account/{id}/categories", OperationID: "listCategories", Summary: "Sum an account's transactions by category",
+		Auth: authCustomer, Response: []*CategorySummary{}},
+	{Method: http.MethodGet, Path: "/account/{id}/logins", OperationID: "listAccountLogins", Summary: "List login attempts on an account",
+		Auth: authCustomer, Response: LoginAttemptPage{}},
+	{Method: http.MethodGet, Path: "/account/{id}/api-usage", OperationID: "getAPIUsage", Summary: "Summarize the account's API usage over the last 30 days",
+		Auth: authCustomer, Response: APIUsageInsights{}},
+	{Method: http.MethodGet, Path: "/account/{id}/devices", OperationID: "listDevices", Summary: "List trusted devices",
+		Auth: authCustomer, Response: []*Device{}},
+	{Method: http.MethodPost, Path: "/account/{id}/devices", OperationID: "registerDevice", Summary: "Trust a device",
+		Auth: authCustomer, Request: RegisterDeviceRequest{}, Response: RegisterDeviceResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/account/{id}/devices/{deviceID}", OperationID: "revokeDevice", Summary: "Revoke a trusted device",
+		Auth: authCustomer, Response: map[string]int{}},
+	{Method: http.MethodGet, Path: "/account/{id}/payees", OperationID: "listPayees", Summary: "List bill payees",
+		Auth: authCustomer, Response: []*Payee{}},
+	{Method: http.MethodPost, Path: "/account/{id}/payees", OperationID: "createPayee", Summary: "Add a bill payee",
+		Auth: authCustomer, Request: CreatePayeeRequest{}, Response: Payee{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/account/{id}/payees/{payeeID}", OperationID: "deletePayee", Summary: "Remove a bill payee",
+		Auth: authCustomer, Response: map[string]int{}},
+	{Method: http.MethodGet, Path: "/account/{id}/bill-payments", OperationID: "listBillPayments", Summary: "List bill payments",
+		Auth: authCustomer, Response: []*BillPayment{}},
+	{Method: http.MethodPost, Path: "/account/{id}/bill-payments", OperationID: "createBillPayment", Summary: "Schedule a bill payment or standing order",
+		Auth: authCustomer, Request: CreateBillPaymentRequest{}, Response: BillPayment{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/account/{id}/bill-payments/{paymentID}", OperationID: "cancelBillPayment", Summary: "Cancel a bill payment",
+		Auth: authCustomer, Response: map[string]int{}, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodPost, Path: "/account/{id}/bill-payments/{paymentID}/pause", OperationID: "pauseBillPayment", Summary: "Pause a standing order",
+		Auth: authCustomer, Response: BillPayment{}, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodPost, Path: "/account/{id}/bill-payments/{paymentID}/resume", OperationID: "resumeBillPayment", Summary: "Resume a paused standing order",
+		Auth: authCustomer, Response: BillPayment{}, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodPost, Path: "/account/{id}/bill-payments/{paymentID}/skip", OperationID: "skipBillPayment", Summary: "Skip the next run of a standing order",
+		Auth: authCustomer, Response: BillPayment{}, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodGet, Path: "/account/{id}/invoices", OperationID: "listInvoices", Summary: "List invoices",
+		Auth: authCustomer, Response: []InvoiceResource{}},
+	{Method: http.MethodPost, Path: "/account/{id}/invoices", OperationID: "createInvoice", Summary: "Issue an invoice",
+		Auth: authCustomer, Request: CreateInvoiceRequest{}, Response: InvoiceResource{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/account/{id}/invoices/{invoiceID}", OperationID: "getInvoice", Summary: "Get an invoice",
+		Auth: authCustomer, Response: InvoiceResource{}},
+	{Method: http.MethodGet, Path: "/invoices/{invoiceID}/pay", OperationID: "getInvoicePayment", Summary: "Get what paying an invoice takes",
+		Auth: authCustomer, Response: InvoicePayment{}},
+	{Method: http.MethodGet, Path: "/account/{id}/alerts", OperationID: "listAlertRules", Summary: "List alert rules",
+		Auth: authCustomer, Response: []*AlertRule{}},
+	{Method: http.MethodPost, Path: "/account/{id}/alerts", OperationID: "createAlertRule", Summary: "Add an alert rule",
+		Auth: authCustomer, Request: AlertRuleRequest{}, Response: AlertRule{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/account/{id}/alerts/{ruleID}", OperationID: "getAlertRule", Summary: "Get an alert rule",
+		Auth: authCustomer, Response: AlertRule{}},
+	{Method: http.MethodPut, Path: "/account/{id}/alerts/{ruleID}", OperationID: "updateAlertRule", Summary: "Change an alert rule",
+		Auth: authCustomer, Request: AlertRuleRequest{}, Response: AlertRule{}},
+	{Method: http.MethodDelete, Path: "/account/{id}/alerts/{ruleID}", OperationID: "deleteAlertRule", Summary: "Remove an alert rule",
+		Auth: authCustomer, Response: map[string]int{}},
+	{Method: http.MethodGet, Path: "/account/{id}/sweeps", OperationID: "listSweepRules", Summary: "List sweep rules",
+		Auth: authCustomer, Response: []*SweepRule{}},
+	{Method: http.MethodPost, Path: "/account/{id}/sweeps", OperationID: "createSweepRule", Summary: "Add a sweep rule",
+		Auth: authCustomer, Request: SweepRuleRequest{}, Response: SweepRule{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/account/{id}/sweeps/{ruleID}", OperationID: "getSweepRule", Summary: "Get a sweep rule",
+		Auth: authCustomer, Response: SweepRule{}},
+	{Method: http.MethodPut, Path: "/account/{id}/sweeps/{ruleID}", OperationID: "updateSweepRule", Summary: "Change a sweep rule",
+		Auth: authCustomer, Request: SweepRuleRequest{}, Response: SweepRule{}},
+	{Method: http.MethodDelete, Path: "/account/{id}/sweeps/{ruleID}", OperationID: "deleteSweepRule", Summary: "Remove a sweep rule",
+		Auth: authCustomer, Response: map[string]int{}},
+	{Method: http.MethodGet, Path: "/account/{id}/automations", OperationID: "listAutomationRules", Summary: "List automation rules",
+		Auth: authCustomer, Response: []*AutomationRule{}},
+	{Method: http.MethodPost, Path: "/account/{id}/automations", OperationID: "createAutomationRule", Summary: "Add an automation rule",
+		Auth: authCustomer, Request: AutomationRuleRequest{}, Response: AutomationRule{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/account/{id}/automations/{ruleID}", OperationID: "getAutomationRule", Summary: "Get an automation rule",
+		Auth: authCustomer, Response: AutomationRule{}},
+	{Method: http.MethodPut, Path: "/account/{id}/automations/{ruleID}", OperationID: "updateAutomationRule", Summary: "Change an automation rule",
+		Auth: authCustomer, Request: AutomationRuleRequest{}, Response: AutomationRule{}},
+	{Method: http.MethodDelete, Path: "/account/{id}/automations/{ruleID}", OperationID: "deleteAutomationRule", Summary: "Remove an automation rule",
+		Auth: authCustomer, Response: map[string]int{}},
+	{Method: http.MethodGet, Path: "/account/{id}/automations/{ruleID}/runs", OperationID: "listAutomationRuns", Summary: "List the runs of an automation rule",
+		Auth: authCustomer, Response: []*AutomationRun{}},
+	{Method: http.MethodGet, Path: "/account/{id}/webhooks", OperationID: "listWebhooks", Summary: "List webhook endpoints with their delivery health",
+		Auth: authCustomer, Response: []*WebhookEndpoint{}},
+	{Method: http.MethodPost, Path: "/account/{id}/webhooks", OperationID: "createWebhook", Summary: "Add a webhook endpoint",
+		Auth: authCustomer, Request: WebhookEndpointRequest{}, Response: WebhookEndpoint{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/account/{id}/webhooks/{webhookID}", OperationID: "getWebhook", Summary: "Get a webhook endpoint with its delivery health",
+		Auth: authCustomer, Response: WebhookEndpoint{}},
+	{Method: http.MethodDelete, Path: "/account/{id}/webhooks/{webhookID}", OperationID: "deleteWebhook", Summary: "Remove a webhook endpoint",
+		Auth: authCustomer, Response: map[string]int{}},
+	{Method: http.MethodPost, Path: "/account/{id}/webhooks/{webhookID}/enable", OperationID: "enableWebhook", Summary: "Enable a webhook endpoint disabled for failing",
+		Auth: authCustomer, Response: WebhookEndpoint{}},
+	{Method: http.MethodGet, Path: "/account/{id}/freezes", OperationID: "listFreezeWindows", Summary: "List freeze windows",
+		Auth: authCustomer, Response: []*FreezeWindow{}},
+	{Method: http.MethodPost, Path: "/account/{id}/freezes", OperationID: "createFreezeWindow", Summary: "Schedule a freeze window",
+		Auth: authCustomer, Request: FreezeWindowRequest{}, Response: FreezeWindow{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/account/{id}/freezes/{windowID}", OperationID: "getFreezeWindow", Summary: "Get a freeze window",
+		Auth: authCustomer, Response: FreezeWindow{}},
+	{Method: http.MethodDelete, Path: "/account/{id}/freezes/{windowID}", OperationID: "deleteFreezeWindow", Summary: "Remove a freeze window",
+		Auth: authCustomer, Response: map[string]int{}},
+	{Method: http.MethodGet, Path: "/account/{id}/terms", OperationID: "getTermsStatus", Summary: "Get the terms the account still has to accept",
+		Auth: authCustomer, Response: TermsStatus{}},
+	{Method: http.MethodPost, Path: "/account/{id}/terms", OperationID: "acceptTerms", Summary: "Accept a terms version",
+		Auth: authCustomer, Request: AcceptTermsRequest{}, Response: TermsAcceptance{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/account/{id}/delegates", OperationID: "listDelegations", Summary: "List read-only delegates",
+		Auth: authCustomer, Response: []*Delegation{}},
+	{Method: http.MethodPost, Path: "/account/{id}/delegates", OperationID: "createDelegation", Summary: "Grant a delegate read-only access",
+		Auth: authCustomer, Request: DelegationRequest{}, Response: Delegation{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/account/{id}/delegates/{delegationID}", OperationID: "revokeDelegation", Summary: "Revoke a delegate's access",
+		Auth: authCustomer, Response: map[string]int{}},
+	{Method: http.MethodGet, Path: "/account/{id}/impersonations", OperationID: "listImpersonations", Summary: "List support impersonations of the account",
+		Auth: authCustomer, Response: []*Impersonation{}},
+	{Method: http.MethodPost, Path: "/account/{id}/impersonations/{impersonationID}/consent", OperationID: "consentImpersonation", Summary: "Let support impersonate the account",
+		Auth: authCustomer, Response: Impersonation{}, Errors: []int{http.StatusConflict, http.StatusUnprocessableEntity}},
+	{Method: http.MethodPost, Path: "/account/{id}/impersonations/{impersonationID}/revoke", OperationID: "revokeImpersonation", Summary: "Decline or end a support impersonation",
+		Auth: authCustomer, Response: Impersonation{}, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodGet, Path: "/account/{id}/documents", OperationID: "listDocuments", Summary: "List statements, receipts and notices",
+		Auth: authCustomer, Response: []*Document{}},
+	{Method: http.MethodGet, Path: "/account/{id}/documents/preferences", OperationID: "getPaperlessPreferences", Summary: "Get paperless preferences",
+		Auth: authCustomer, Response: PaperlessPreferences{}},
+	{Method: http.MethodPut, Path: "/account/{id}/documents/preferences", OperationID: "updatePaperlessPreferences", Summary: "Change paperless preferences",
+		Auth: authCustomer, Request: PaperlessPreferences{}, Response: PaperlessPreferences{}},
+	{Method: http.MethodGet, Path: "/account/{id}/documents/{documentID}/url", OperationID: "getDocumentUrl", Summary: "Get a signed download link for a document",
+		Auth: authCustomer, Response: DocumentURL{}},
+	{Method: http.MethodGet, Path: "/documents/{documentID}", OperationID: "downloadDocument", Summary: "Download a document through a signed link",
+		ContentType: "application/pdf"},
+	{Method: http.MethodGet, Path: "/metrics", OperationID: "getMetrics", Summary: "Business metrics in the Prometheus text format",
+		Auth: authAdmin, ContentType: "text/plain"},
+	{Method: http.MethodGet, Path: "/account/{id}/contacts", OperationID: "listContacts", Summary: "List contacts",
+		Auth: authCustomer, Response: []*Contact{}},
+	{Method: http.MethodPost, Path: "/account/{id}/contacts", OperationID: "createContact", Summary: "Add a contact",
+		Auth: authCustomer, Request: ContactRequest{}, Response: Contact{}, Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/account/{id}/contacts/lookup", OperationID: "lookupContacts", Summary: "Find which phone numbers belong to customers",
+		Auth: authCustomer, Request: PhoneLookupRequest{}, Response: []PhoneLookupResult{}},
+	{Method: http.MethodGet, Path: "/account/{id}/contacts/{contactID}", OperationID: "getContact", Summary: "Get a contact",
+		Auth: authCustomer, Response: Contact{}},
+	{Method: http.MethodPut, Path: "/account/{id}/contacts/{contactID}", OperationID: "updateContact", Summary: "Change a contact",
+		Auth: authCustomer, Request: ContactRequest{}, Response: Contact{}},
+	{Method: http.MethodDelete, Path: "/account/{id}/contacts/{contactID}", OperationID: "deleteContact", Summary: "Remove a contact",
+		Auth: authCustomer, Response: map[string]int{}},
+	{Method: http.MethodGet, Path: "/account/{id}/cheques", OperationID: "listCheques", Summary: "List deposited cheques",
+		Auth: authCustomer, Response: []*Cheque{}},
+	{Method: http.MethodPost, Path: "/account/{id}/cheques", OperationID: "depositCheque", Summary: "Deposit a cheque",
+		Auth: authCustomer, Request: DepositChequeRequest{}, Response: Cheque{}, Status: http.StatusAccepted},
+	{Method: http.MethodPost, Path: "/cash/deposit", OperationID: "depositCash", Summary: "Deposit cash at a terminal",
+		Auth: authTerminal, Request: CashOperationRequest{}, Response: CashOperation{}, Errors: transferErrors},
+	{Method: http.MethodPost, Path: "/cash/withdrawal", OperationID: "withdrawCash", Summary: "Withdraw cash at a terminal",
+		Auth: authTerminal, Request: CashOperationRequest{}, Response: CashOperation{}, Errors: transferErrors},
+	// Teller operations above TELLER_APPROVAL_THRESHOLD answer 202 with the
+	// TellerApproval instead.
+	{Method: http.MethodPost, Path: "/teller/cash/deposit", OperationID: "tellerDepositCash", Summary: "Deposit cash at a branch",
+		Auth: authTeller, Request: CashOperationRequest{}, Response: CashOperation{}, Errors: transferErrors},
+	{Method: http.MethodPost, Path: "/teller/cash/withdrawal", OperationID: "tellerWithdrawCash", Summary: "Withdraw cash at a branch",
+		Auth: authTeller, Request: CashOperationRequest{}, Response: CashOperation{}, Errors: transferErrors},
+	{Method: http.MethodPost, Path: "/teller/adjustments", OperationID: "tellerCreateAdjustment", Summary: "Post a manual adjustment",
+		Auth: authTeller, Request: AdjustmentRequest{}, Response: CashOperation{}, Errors: transferErrors},
+	{Method: http.MethodGet, Path: "/teller/approvals", OperationID: "tellerListApprovals", Summary: "List operations waiting for a second teller",
+		Auth: authTeller, Response: []*TellerApproval{}},
+	{Method: http.MethodPost, Path: "/teller/approvals/{approvalID}/approve", OperationID: "tellerApproveOperation", Summary: "Approve and post an operation",
+		Auth: authTeller, Response: CashOperation{}, Errors: transferErrors},
+	{Method: http.MethodPost, Path: "/teller/approvals/{approvalID}/reject", OperationID: "tellerRejectOperation", Summary: "Reject an operation",
+		Auth: authTeller, Response: TellerApproval{}, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodGet, Path: "/admin/logins", OperationID: "adminListLogins", Summary: "Search login attempts",
+		Auth: authAdmin, Response: LoginAttemptPage{}},
+	{Method: http.MethodGet, Path: "/admin/accounts", OperationID: "adminSearchAccounts", Summary: "Search accounts",
+		Auth: authAdmin, Response: []AdminAccount{}},
+	{Method: http.MethodPost, Path: "/admin/accounts/{accountID}/ownership", OperationID: "adminTransferOwnership", Summary: "Move an account to a new owner",
+		Auth: authAdmin, Request: OwnershipTransferRequest{}, Response: OwnershipTransferResponse{}},
+	{Method: http.MethodPost, Path: "/admin/accounts/{accountID}/impersonations", OperationID: "adminImpersonate", Summary: "Get a read-only token to impersonate a customer",
+		Auth: authAdmin, Request: ImpersonationRequest{}, Response: Impersonation{}, Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/admin/impersonations/{impersonationID}/revoke", OperationID: "adminRevokeImpersonation", Summary: "End an impersonation",
+		Auth: authAdmin, Response: Impersonation{}, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodPost, Path: "/admin/accounts/{accountID}/close", OperationID: "adminCloseAccount", Summary: "Close an account and redirect it to its successor",
+		Auth: authAdmin, Request: CloseAccountRequest{}, Response: AccountRedirect{}},
+	{Method: http.MethodGet, Path: "/admin/accounts/{accountID}/legal-hold", OperationID: "adminGetLegalHold", Summary: "Show the legal hold on an account",
+		Auth: authAdmin, Response: LegalHold{}},
+	{Method: http.MethodPut, Path: "/admin/accounts/{accountID}/legal-hold", OperationID: "adminPlaceLegalHold", Summary: "Keep a deleted account from being purged",
+		Auth: authAdmin, Request: LegalHoldRequest{}, Response: LegalHold{}},
+	{Method: http.MethodDelete, Path: "/admin/accounts/{accountID}/legal-hold", OperationID: "adminReleaseLegalHold", Summary: "Release the legal hold on an account",
+		Auth: authAdmin, Response: map[string]int{}},
+	{Method: http.MethodGet, Path: "/admin/audit", OperationID: "adminListAuditEvents", Summary: "Search the audit log",
+		Auth: authAdmin, Response: []*AuditEvent{}},
+	{Method: http.MethodGet, Path: "/admin/usage", OperationID: "adminGetUsage", Summary: "Report API usage against quotas",
+		Auth: authAdmin, Response: UsageReport{}},
+	{Method: http.MethodGet, Path: "/admin/transfers", OperationID: "adminListTransfers", Summary: "Search transfers with their origin",
+		Auth: authAdmin, Response: ActivityPage{}},
+	{Method: http.MethodGet, Path: "/admin/policy", OperationID: "adminGetPolicy", Summary: "Show the authorization rules in force",
+		Auth: authAdmin, Response: PolicyDocument{}},
+	{Method: http.MethodPost, Path: "/admin/policy/reload", OperationID: "adminReloadPolicy", Summary: "Reload the authorization rules file",
+		Auth: authAdmin, Response: PolicyDocument{}, Errors: []int{http.StatusBadRequest}},
+	{Method: http.MethodGet, Path: "/admin/reviews", OperationID: "adminListReviews", Summary: "List transfers held for fraud review",
+		Auth: authAdmin, Response: []AdminTransfer{}},
+	{Method: http.MethodGet, Path: "/admin/reviews/worklist", OperationID: "adminReviewWorklist", Summary: "List held transfers by SLA breach risk",
+		Auth: authAdmin, Response: []ReviewWorkItem{}},
+	{Method: http.MethodPut, Path: "/admin/reviews/{transferID}/case", OperationID: "adminTriageReview", Summary: "Set the priority and note of a held transfer",
+		Auth: authAdmin, Request: ReviewCaseRequest{}, Response: ReviewCase{}, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodPost, Path: "/admin/reviews/{transferID}/approve", OperationID: "adminApproveReview", Summary: "Approve and execute a held transfer",
+		Auth: authAdmin, Response: TransferResource{}, Errors: []int{http.StatusConflict, http.StatusLocked}},
+	{Method: http.MethodPost, Path: "/admin/reviews/{transferID}/decline", OperationID: "adminDeclineReview", Summary: "Decline a held transfer",
+		Auth: authAdmin, Response: TransferResource{}, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodGet, Path: "/admin/holidays", OperationID: "adminListHolidays", Summary: "List settlement holidays",
+		Auth: authAdmin, Response: []*Holiday{}},
+	{Method: http.MethodPost, Path: "/admin/holidays", OperationID: "adminCreateHoliday", Summary: "Add a settlement holiday",
+		Auth: authAdmin, Request: HolidayRequest{}, Response: Holiday{}, Status: http.StatusCreated, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodDelete, Path: "/admin/holidays/{holidayID}", OperationID: "adminDeleteHoliday", Summary: "Remove a settlement holiday",
+		Auth: authAdmin, Response: map[string]int{}},
+	{Method: http.MethodGet, Path: "/admin/migrations", OperationID: "adminListMigrations", Summary: "List online schema migrations and their backfill progress",
+		Auth: authAdmin, Response: []MigrationStatus{}},
+	{Method: http.MethodPost, Path: "/admin/migrations/{name}/cutover", OperationID: "adminCutOverMigration", Summary: "Switch reads to the new column of a backfilled migration",
+		Auth: authAdmin, Response: MigrationStatus{}, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodGet, Path: "/admin/terms", OperationID: "adminListTerms", Summary: "List published terms versions",
+		Auth: authAdmin, Response: []*Terms{}},
+	{Method: http.MethodPost, Path: "/admin/terms", OperationID: "adminPublishTerms", Summary: "Publish a terms version",
+		Auth: authAdmin, Request: TermsRequest{}, Response: Terms{}, Status: http.StatusCreated, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodPost, Path: "/admin/statements/regenerations", OperationID: "adminRegenerateStatements", Summary: "Regenerate statements for a range of months",
+		Auth: authAdmin, Request: StatementRegenerationRequest{}, Response: StatementRegeneration{}, Status: http.StatusAccepted},
+	{Method: http.MethodGet, Path: "/admin/statements/regenerations/{regenerationID}", OperationID: "adminGetRegeneration", Summary: "Get the progress of a statement regeneration",
+		Auth: authAdmin, Response: StatementRegeneration{}},
+	{Method: http.MethodGet, Path: "/admin/eod", OperationID: "adminListEndOfDays", Summary: "List the latest end-of-day runs",
+		Auth: authAdmin, Response: []*EndOfDayRun{}},
+	{Method: http.MethodPost, Path: "/admin/eod", OperationID: "adminRunEndOfDay", Summary: "Close a business day",
+		Auth: authAdmin, Request: EndOfDayRequest{}, Response: EndOfDayRequest{}, Status: http.StatusAccepted, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodGet, Path: "/admin/eod/{businessDate}", OperationID: "adminGetEndOfDay", Summary: "Get an end-of-day run and its currency positions",
+		Auth: authAdmin, Response: EndOfDayRun{}},
+	{Method: http.MethodGet, Path: "/admin/reports/reconciliation", OperationID: "adminGetReconciliationReport", Summary: "Reconcile balances against the ledger",
+		Auth: authAdmin, Response: ReconciliationReport{}},
+	{Method: http.MethodGet, Path: "/admin/reports/system-accounts", OperationID: "adminGetSystemAccountsReport", Summary: "Report the bank's own accounts",
+		Auth: authAdmin, Response: SystemAccountsReport{}},
+	{Method: http.MethodGet, Path: "/admin/reports/duplicates", OperationID: "adminGetDuplicateAccountsReport", Summary: "Report clusters of suspected duplicate accounts",
+		Auth: authAdmin, Response: DuplicateAccountsReport{}},
+	{Method: http.MethodGet, Path: "/admin/captures", OperationID: "adminListCaptures", Summary: "List captured requests",
+		Auth: authAdmin, Response: []*CapturedExchange{}},
+	{Method: http.MethodGet, Path: "/admin/captures/{captureID}", OperationID: "adminGetCapture", Summary: "Get a captured request",
+		Auth: authAdmin, Response: CapturedExchange{}},
+	{Method: http.MethodPost, Path: "/admin/captures/{captureID}/replay", OperationID: "adminReplayCapture", Summary: "Replay a captured request",
+		Auth: authAdmin, Request: ReplayRequest{}, Response: ReplayResponse{}},
+	{Method: http.MethodPost, Path: "/transfer", OperationID: "createTransfer", Summary: "Send money to another account",
+		Auth: authCustomer, Request: TransferRequest{}, Response: TransferResource{}, Errors: transferErrors},
+	{Method: http.MethodPost, Path: "/fx/quotes", OperationID: "createFXQuote", Summary: "Lock an exchange rate for a transfer",
+		Auth: authCustomer, Request: FXQuoteRequest{}, Response: FXQuote{}, Status: http.StatusCreated,
+		Errors: []int{http.StatusUnprocessableEntity}},
+	{Method: http.MethodPost, Path: "/transfer/preview", OperationID: "previewTransfer", Summary: "Check a transfer without sending it",
+		Auth: authCustomer, Request: TransferRequest{}, Response: TransferPreview{}, Errors: transferErrors},
+	{Method: http.MethodGet, Path: "/transfer/{transferID}", OperationID: "getTransfer", Summary: "Get a transfer",
+		Auth: authCustomer, Response: TransferResource{}},
+	{Method: http.MethodGet, Path: "/transfer/{transferID}/status", OperationID: "getTransferStatus", Summary: "Wait for a transfer to settle",
+		Auth: authCustomer, Response: TransferResource{}},
+	{Method: http.MethodPost, Path: "/transfer/{transferID}/refund", OperationID: "refundTransfer", Summary: "Refund part or all of a settled transfer",
+		Auth: authCustomer, Request: RefundRequest{}, Response: RefundResponse{}, Errors: transferErrors},
+	{Method: http.MethodGet, Path: "/transactions/{transferID}/receipt", OperationID: "getReceipt", Summary: "Get a signed receipt for a settled transfer",
+		Auth: authCustomer, Response: SignedReceipt{}, Errors: []int{http.StatusConflict}},
+	{Method: http.MethodGet, Path: "/receipts/key", OperationID: "getReceiptKey", Summary: "Get the key receipts are signed with",
+		Response: map[string]string{}},
+	{Method: http.MethodGet, Path: "/webhooks/event-types", OperationID: "listEventTypes", Summary: "List event types with the JSON Schemas of their payloads",
+		Response: EventCatalog{}},
+	{Method: http.MethodPost, Path: "/sandbox/account/{id}/failures", OperationID: "forceFailures", Summary: "Make the account's next transfers fail",
+		Auth: authCustomer, Request: ForceFailureRequest{}, Response: map[string]int{}, Sandbox: true},
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// openAPISchemas builds JSON Schemas for Go types from their json tags,
+// the same way encoding/json reads them, and collects named structs as
+// components.
+type openAPISchemas map[string]any
+
+var moneyType = reflect.TypeOf(Money{})
+
+func (c openAPISchemas) ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func (c openAPISchemas) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case moneyType:
+		if _, ok := c["Money"]; !ok {
+			c["Money"] = map[string]any{
+				"type":        "object",
+				"description": "An amount in minor units. Amounts are JSON strings instead of numbers when the server runs with JSON_AMOUNTS_AS_STRINGS.",
+				"properties": map[string]any{
+					"amount":   map[string]any{"type": "integer", "format": "int64"},
+					"currency": map[string]any{"type": "string"},
+				},
+				"required": []string{"amount", "currency"},
+			}
+		}
+		return c.ref("Money")
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t.Name() == "" {
+			return c.object(t)
+		}
+		if _, ok := c[t.Name()]; !ok {
+			// Placeholder first, so a type referring to itself ends.
+			c[t.Name()] = nil
+			c[t.Name()] = c.object(t)
+		}
+		return c.ref(t.Name())
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": c.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": c.schema(t.Elem())}
+	case reflect.Interface:
+		return map[string]any{}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	default:
+		return map[string]any{"type": "number"}
+	}
+}
+
+func (c openAPISchemas) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+
+	var fields func(t reflect.Type)
+	fields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" {
+				embedded := f.Type
+				if embedded.Kind() == reflect.Pointer {
+					embedded = embedded.Elem()
+				}
+				fields(embedded)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = c.schema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	fields(t)
+
+	sort.Strings(required)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// errorResponseNames are the typed error responses operations refer to.
+// Each one is an ApiError; its description lists the domain errors
+// answered with that status.
+var errorResponseNames = map[int]string{
+	http.StatusBadRequest:          "BadRequest",
+	http.StatusUnauthorized:        "Unauthorized",
+	http.StatusForbidden:           "Forbidden",
+	http.StatusNotFound:            "NotFound",
+	http.StatusConflict:            "Conflict",
+	http.StatusUnprocessableEntity: "UnprocessableEntity",
+	http.StatusLocked:              "Locked",
+	http.StatusTooManyRequests:     "TooManyRequests",
+	http.StatusInternalServerError: "InternalServerError",
+	http.StatusServiceUnavailable:  "ServiceUnavailable",
+}
+
+func errorResponses() map[string]any {
+	known := map[int][]string{}
+	for err, status := range domainErrorStatus {
+		known[status] = append(known[status], strconv.Quote(err.Error()))
+	}
+
+	responses := map[string]any{}
+	for status, name := range errorResponseNames {
+		description := http.StatusText(status)
+		if errs := known[status]; len(errs) > 0 {
+			sort.Strings(errs)
+			description += ". Known errors: " + strings.Join(errs, ", ") + "."
+		}
+		responses[name] = map[string]any{
+			"description": description,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/ApiError"}},
+			},
+		}
+	}
+	return responses
+}
+
+// errorStatuses are the error statuses op can answer with.
+func (op apiOperation) errorStatuses() []int {
+	statuses := map[int]bool{http.StatusInternalServerError: true, http.StatusServiceUnavailable: true}
+	if op.Request != nil {
+		statuses[http.StatusBadRequest] = true
+	}
+	if strings.Contains(op.Path, "{") {
+		statuses[http.StatusNotFound] = true
+	}
+	switch op.Auth {
+	case authCustomer:
+		statuses[http.StatusForbidden] = true
+		statuses[http.StatusTooManyRequests] = true
+	case authAdmin:
+		statuses[http.StatusUnauthorized] = true
+		statuses[http.StatusForbidden] = true
+	case authTerminal, authTeller:
+		statuses[http.StatusForbidden] = true
+	}
+	for _, status := range op.Errors {
+		statuses[status] = true
+	}
+
+	list := make([]int, 0, len(statuses))
+	for status := range statuses {
+		list = append(list, status)
+	}
+	sort.Ints(list)
+	return list
+}
+
+func (op apiOperation) document(schemas openAPISchemas) map[string]any {
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	switch {
+	case op.ContentType != "":
+		success["content"] = map[string]any{
+			op.ContentType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+		}
+	case op.Response != nil:
+		success["content"] = map[string]any{
+			"application/json": map[string]any{"schema": schemas.schema(reflect.TypeOf(op.Response))},
+		}
+	}
+
+	responses := map[string]any{strconv.Itoa(status): success}
+	for _, status := range op.errorStatuses() {
+		responses[strconv.Itoa(status)] = map[string]any{"$ref": "#/components/responses/" + errorResponseNames[status]}
+	}
+
+	doc := map[string]any{
+		"operationId": op.OperationID,
+		"summary":     op.Summary,
+		"tags":        []string{strings.SplitN(strings.TrimPrefix(op.Path, "/"), "/", 2)[0]},
+		"responses":   responses,
+	}
+
+	var params []any
+	for _, m := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+		params = append(params, map[string]any{
+			"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		doc["parameters"] = params
+	}
+	if op.Request != nil {
+		doc["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": schemas.schema(reflect.TypeOf(op.Request))},
+			},
+		}
+	}
+
+	switch op.Auth {
+	case authCustomer:
+		doc["security"] = []any{map[string]any{"jwt": []string{}}}
+	case authAdmin:
+		doc["security"] = []any{map[string]any{"adminToken": []string{}}, map[string]any{"adminBasic": []string{}}}
+	case authTerminal:
+		doc["security"] = []any{map[string]any{"terminalId": []string{}, "terminalToken": []string{}}}
+	case authTeller:
+		doc["security"] = []any{map[string]any{"tellerId": []string{}, "tellerToken": []string{}}}
+	default:
+		doc["security"] = []any{}
+	}
+	if op.Sandbox {
+		doc["description"] = "Only available when the server runs with APP_ENV=sandbox."
+	}
+	return doc
+}
+
+// openAPIDocument is the OpenAPI 3 definition of the API, built from
+// apiOperations and the Go types handlers read and write.
+func openAPIDocument() map[string]any {
+	schemas := openAPISchemas{}
+	schemas.schema(reflect.TypeOf(ApiError{}))
+
+	paths := map[string]map[string]any{}
+	for _, op := range apiOperations {
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]any{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = op.document(schemas)
+	}
+
+	apiKey := func(header string) map[string]any {
+		return map[string]any{"type": "apiKey", "in": "header", "name": header}
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "gobank",
+			"version":     strconv.Itoa(apiVersion),
+			"description": "Field names are camelCase. Requests with an X-JSON-Naming: snake_case header, or from consumers configured in JSON_NAMING_PROFILES, are read and answered in snake_case instead.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas":   schemas,
+			"responses": errorResponses(),
+			"securitySchemes": map[string]any{
+				"jwt":           apiKey("x-jwt-token"),
+				"adminToken":    apiKey("x-admin-token"),
+				"adminBasic":    map[string]any{"type": "http", "scheme": "basic"},
+				"terminalId":    apiKey("x-terminal-id"),
+				"terminalToken": apiKey("x-terminal-token"),
+				"tellerId":      apiKey("x-teller-id"),
+				"tellerToken":   apiKey("x-teller-token"),
+			},
+		},
+	}
+}
+
+// writeOpenAPI writes the OpenAPI definition as indented JSON, for the
+// spec subcommand.
+func writeOpenAPI(w io.Writer) error {
+	b, err := json.MarshalIndent(openAPIDocument(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPIRoutes(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("APP_ENV", "sandbox")
+
+	routed := map[string]bool{}
+	router := NewAPIServer(":0", NewMemoryStorage()).Router()
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		// The admin UI and expvar serve browsers and operators, not SDKs.
+		if path != "/admin/ui" && path != "/debug/vars" {
+			routed[path] = true
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	documented := map[string]bool{}
+	for _, op := range apiOperations {
+		documented[op.Path] = true
+	}
+
+	var missing, stale []string
+	for path := range routed {
+		if !documented[path] {
+			missing = append(missing, path)
+		}
+	}
+	for path := range documented {
+		if !routed[path] {
+			stale = append(stale, path)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	assert.Empty(t, missing, "routes without an entry in apiOperations")
+	assert.Empty(t, stale, "apiOperations entries without a route")
+}
+
+func TestOpenAPIOperationIDs(t *testing.T) {
+	lowerCamel := regexp.MustCompile(`^[a-z][a-zA-Z]*$`)
+	ids := map[string]string{}
+	routes := map[string]bool{}
+	for _, op := range apiOperations {
+		route := op.Method + " " + op.Path
+		assert.False(t, routes[route], "%s is listed twice", route)
+		routes[route] = true
+
+		assert.Regexp(t, lowerCamel, op.OperationID, route)
+		if other, ok := ids[op.OperationID]; ok {
+			t.Errorf("%s and %s share operationId %s", other, route, op.OperationID)
+		}
+		ids[op.OperationID] = route
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, writeOpenAPI(&buf))
+
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas   map[string]json.RawMessage `json:"schemas"`
+			Responses map[string]json.RawMessage `json:"responses"`
+		} `json:"components"`
+	}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &doc))
+	assert.Contains(t, doc.Paths["/transfer/{transferID}/refund"], "post")
+	assert.Contains(t, string(doc.Components.Schemas["ApiError"]), `"error"`)
+	assert.Contains(t, string(doc.Components.Responses["NotFound"]), ErrTransferNotFound.Error())
+
+	// Every reference resolves, so generators don't trip over it.
+	for _, m := range regexp.MustCompile(`"#/components/(schemas|responses)/([^"]+)"`).FindAllStringSubmatch(buf.String(), -1) {
+		var ok bool
+		if m[1] == "schemas" {
+			_, ok = doc.Components.Schemas[m[2]]
+		} else {
+			_, ok = doc.Components.Responses[m[2]]
+		}
+		assert.True(t, ok, "unresolved reference %s", strings.Trim(m[0], `"`))
+	}
+}
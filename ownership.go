@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// OwnershipTransferRequest hands an account over to a new holder, e.g. to
+// an estate executor or the buyer of a business.
+type OwnershipTransferRequest struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Phone     string `json:"phone"`
+	Reason    string `json:"reason"`
+}
+
+// OwnershipTransferResponse carries the temporary password for the new
+// holder. It is only ever shown here.
+type OwnershipTransferResponse struct {
+	Account           AdminAccount `json:"account"`
+	TemporaryPassword string       `json:"temporaryPassword"`
+}
+
+// HandleAdminTransferOwnership moves an account to a new holder. The
+// previous holder's sessions and trusted devices stop working, the account
+// gets a new password for the new holder and the change is audited.
+func (s *APIServer) HandleAdminTransferOwnership(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	accountID, err := getIntVar(r, "accountID")
+	if err != nil {
+		return err
+	}
+
+	req := new(OwnershipTransferRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	if strings.TrimSpace(req.FirstName) == "" || strings.TrimSpace(req.LastName) == "" {
+		return ApiError{Err: "firstName and lastName are required", Status: http.StatusBadRequest}
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		return ApiError{Err: "a reason is required", Status: http.StatusBadRequest}
+	}
+
+	account, err := s.storage.GetAccountByID(accountID)
+	if err != nil {
+		return ApiError{Err: "account not found", Status: http.StatusNotFound}
+	}
+
+	phone := ""
+	if req.Phone != "" {
+		var ok bool
+		if phone, ok = normalizePhone(req.Phone); !ok {
+			return ApiError{Err: "invalid phone number: " + req.Phone, Status: http.StatusBadRequest}
+		}
+		existing, err := s.storage.GetAccountsByPhone([]string{phone})
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 && existing[0].ID != account.ID {
+			return ApiError{Err: "phone number is already registered", Status: http.StatusConflict}
+		}
+	}
+
+	password, err := randomToken(12)
+	if err != nil {
+		return err
+	}
+	encpw, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	event := NewAuditEvent(adminActor(r), "account.ownership_transferred", account.ID, map[string]string{
+		"reason":            req.Reason,
+		"previousFirstName": account.FirstName,
+		"previousLastName":  account.LastName,
+		"previousPhone":     account.Phone,
+		"newFirstName":      req.FirstName,
+		"newLastName":       req.LastName,
+		"tokenVersion":      strconv.Itoa(account.TokenVersion + 1),
+	})
+
+	account.FirstName, account.LastName, account.Phone = req.FirstName, req.LastName, phone
+	account.EncryptedPassword = string(encpw)
+	if err := s.storage.TransferAccountOwnership(account, event); err != nil {
+		return err
+	}
+
+	notice := &Document{
+		AccountID:   account.ID,
+		Kind:        DocumentNotice,
+		Title:       "Change of account ownership",
+		ContentType: "application/pdf",
+	}
+	body := renderTextPDF(notice.Title, []string{
+		fmt.Sprintf("Account %d is now held by %s %s.", account.Number, account.FirstName, account.LastName),
+		"All previous sessions have been signed out.",
+	})
+	if err := s.documents.File(notice, body); err != nil {
+		log.Println("Failed to file ownership notice: ", err)
+	}
+
+	return writeJSON(w, http.StatusOK, OwnershipTransferResponse{Account: account.Admin(), TemporaryPassword: password})
+}
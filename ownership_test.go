@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwnershipTransferRevokesSessions(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+	t.Setenv("DOCUMENT_DIR", t.TempDir())
+
+	store := NewMemoryStorage()
+	server := NewAPIServer(":0", store)
+	router := server.Router()
+	acc := createTestAccount(t, store, 0)
+
+	oldToken, err := createJWT(acc)
+	assert.Nil(t, err)
+
+	get := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/account/"+acc.PublicID, nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, get(oldToken))
+
+	body := `{"firstName":"Estate of","lastName":"Account","reason":"estate"}`
+	req := httptest.NewRequest(http.MethodPost, "/admin/accounts/"+strconv.Itoa(acc.ID)+"/ownership", strings.NewReader(body))
+	req.Header.Set("x-admin-token", "test-admin")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	resp := new(OwnershipTransferResponse)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(resp))
+	assert.NotEmpty(t, resp.TemporaryPassword)
+
+	assert.Equal(t, http.StatusForbidden, get(oldToken))
+
+	updated, err := store.GetAccountByID(acc.ID)
+	assert.Nil(t, err)
+	assert.True(t, updated.ValidPassword(resp.TemporaryPassword))
+	newToken, err := createJWT(updated)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, get(newToken))
+
+	events, err := store.GetAuditEvents(acc.ID, 10)
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "account.ownership_transferred", events[0].Action)
+	assert.Equal(t, "estate", events[0].Details["reason"])
+}
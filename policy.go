@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Policy decides whether the authenticated principal may access what the
+// request addresses. Every customer route declares one when it is wrapped
+// with withJWTAuth, so routes without an {id} are covered too.
+type Policy func(w http.ResponseWriter, r *http.Request, p *Principal, s Storage) error
+
+// ownsAccount restricts the route to the account addressed by {id}, given
+// either as public ULID or as deprecated integer id.
+func ownsAccount(w http.ResponseWriter, r *http.Request, p *Principal, s Storage) error {
+	idStr, ok := mux.Vars(r)["id"]
+	if !ok {
+		return permissionDenied
+	}
+
+	intID := strconv.Itoa(p.AccountID)
+	if idStr != p.AccountPublicID && idStr != intID {
+		return permissionDenied
+	}
+	if idStr == intID {
+		markIntegerIDDeprecated(w, r, intID, p.AccountPublicID)
+	}
+	return nil
+}
+
+// partyToTransfer restricts the route to the sending and receiving account
+// of the transfer addressed by {transferID}. Anyone else gets a 404, so
+// transfer ids can't be probed.
+func partyToTransfer(w http.ResponseWriter, r *http.Request, p *Principal, s Storage) error {
+	idStr := mux.Vars(r)["transferID"]
+
+	var transfer *Transfer
+	var err error
+	if isULID(idStr) {
+		transfer, err = s.GetTransferByPublicID(idStr)
+	} else if id, convErr := strconv.Atoi(idStr); convErr == nil {
+		transfer, err = s.GetTransferByID(id)
+	} else {
+		return transferNotFound
+	}
+
+	if err != nil || !transfer.Involves(p.AccountID) {
+		return transferNotFound
+	}
+	return nil
+}
+
+// requireScope lets through principals whose token grants scope.
+func requireScope(scope string) Policy {
+	return func(w http.ResponseWriter, r *http.Request, p *Principal, s Storage) error {
+		if !p.HasScope(scope) {
+			return permissionDenied
+		}
+		return nil
+	}
+}
+
+func allOf(policies ...Policy) Policy {
+	return func(w http.ResponseWriter, r *http.Request, p *Principal, s Storage) error {
+		for _, policy := range policies {
+			if err := policy(w, r, p, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
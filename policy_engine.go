@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+type PolicyEffect string
+
+const (
+	PolicyAllow PolicyEffect = "allow"
+	PolicyDeny  PolicyEffect = "deny"
+)
+
+// PolicyRule matches a subject, action and resource: a customer holding
+// Scopes, calling one of Actions (HTTP methods) on one of Resources (route
+// templates, with a trailing * matching any suffix), when every condition
+// in When holds. Empty lists match everything.
+//
+// Conditions are the checks routes declare in code: "route" is the
+// policy the route was registered with, "owner", "owner_or_delegate" and
+// "party_to_transfer" are the policies of the same names, "delegate"
+// holds for delegates reading someone else's account, "impersonated" for
+// support admins using an impersonation token, and "scope:<name>" for
+// tokens granting the scope.
+type PolicyRule struct {
+	Name      string       `json:"name"`
+	Effect    PolicyEffect `json:"effect"`
+	Actions   []string     `json:"actions,omitempty"`
+	Resources []string     `json:"resources,omitempty"`
+	Scopes    []string     `json:"scopes,omitempty"`
+	When      []string     `json:"when,omitempty"`
+}
+
+// PolicyDocument is the rules file, and what GET /admin/policy returns.
+type PolicyDocument struct {
+	Source string       `json:"source"`
+	Rules  []PolicyRule `json:"rules"`
+}
+
+// defaultPolicyRules let through whatever the route's own policy does.
+var defaultPolicyRules = []PolicyRule{{Name: "route", Effect: PolicyAllow, When: []string{"route"}}}
+
+// PolicyEngine decides customer requests from rules loaded from
+// POLICY_FILE, so compliance can tighten access without a release. Rules
+// are tried in order and the first that matches decides; a request no
+// rule matches is denied with the error of the condition that failed, so
+// a transfer that isn't the caller's is still a 404.
+type PolicyEngine struct {
+	path string
+
+	mu    sync.RWMutex
+	rules []PolicyRule
+}
+
+type policyEngineKey struct{}
+
+func policyEngineFromEnv() (*PolicyEngine, error) {
+	e := &PolicyEngine{path: getEnv("POLICY_FILE", ""), rules: defaultPolicyRules}
+	return e, e.Reload()
+}
+
+// Reload reads the rules file again. Invalid rules are rejected as a whole
+// and the rules in force stay.
+func (e *PolicyEngine) Reload() error {
+	if e.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+	doc := new(PolicyDocument)
+	if err := json.Unmarshal(data, doc); err != nil {
+		return fmt.Errorf("policy file %s: %w", e.path, err)
+	}
+	for _, rule := range doc.Rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("policy file %s: %w", e.path, err)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = doc.Rules
+	return nil
+}
+
+func (e *PolicyEngine) Document() PolicyDocument {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	source := e.path
+	if source == "" {
+		source = "default"
+	}
+	return PolicyDocument{Source: source, Rules: append([]PolicyRule{}, e.rules...)}
+}
+
+func (rule PolicyRule) validate() error {
+	if rule.Effect != PolicyAllow && rule.Effect != PolicyDeny {
+		return fmt.Errorf("rule %q: effect must be allow or deny", rule.Name)
+	}
+	for _, name := range rule.When {
+		if _, ok := policyConditions[name]; !ok && !strings.HasPrefix(name, "scope:") {
+			return fmt.Errorf("rule %q: unknown condition %q", rule.Name, name)
+		}
+	}
+	return nil
+}
+
+// policyConditions are the conditions rules can use besides "route" and
+// "scope:<name>".
+var policyConditions = map[string]Policy{
+	"route":             nil,
+	"owner":             ownsAccount,
+	"owner_or_delegate": ownerOrDelegate,
+	"party_to_transfer": partyToTransfer,
+	"delegate": func(w http.ResponseWriter, r *http.Request, p *Principal, s Storage) error {
+		if p.DelegatorID == 0 {
+			return permissionDenied
+		}
+		return nil
+	},
+	"impersonated": func(w http.ResponseWriter, r *http.Request, p *Principal, s Storage) error {
+		if p.ImpersonationID == "" {
+			return permissionDenied
+		}
+		return nil
+	},
+}
+
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(value, prefix) || pattern == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize decides r for p. route is the policy the route was
+// registered with.
+func (e *PolicyEngine) Authorize(w http.ResponseWriter, r *http.Request, p *Principal, s Storage, route Policy) error {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	resource := routeTemplate(r)
+	denied := error(permissionDenied)
+	for _, rule := range rules {
+		if !matchesAny(rule.Actions, r.Method) || !matchesAny(rule.Resources, resource) {
+			continue
+		}
+		if !hasScopes(p, rule.Scopes) {
+			continue
+		}
+		if err := rule.holds(w, r, p, s, route); err != nil {
+			denied = err
+			continue
+		}
+		if rule.Effect == PolicyDeny {
+			log.Printf("Policy rule %q denied %s %s to %s\n", rule.Name, r.Method, resource, p.consumer())
+			return permissionDenied
+		}
+		return nil
+	}
+	return denied
+}
+
+func hasScopes(p *Principal, scopes []string) bool {
+	for _, scope := range scopes {
+		if !p.HasScope(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func (rule PolicyRule) holds(w http.ResponseWriter, r *http.Request, p *Principal, s Storage, route Policy) error {
+	for _, name := range rule.When {
+		condition := policyConditions[name]
+		switch {
+		case name == "route":
+			condition = route
+		case strings.HasPrefix(name, "scope:"):
+			condition = requireScope(strings.TrimPrefix(name, "scope:"))
+		}
+		if err := condition(w, r, p, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Middleware makes the engine available to withJWTAuth.
+func (e *PolicyEngine) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), policyEngineKey{}, e)))
+	})
+}
+
+// authorize checks route through the engine serving r, or on its own
+// when there is none.
+func authorize(w http.ResponseWriter, r *http.Request, p *Principal, s Storage, route Policy) error {
+	if e, ok := r.Context().Value(policyEngineKey{}).(*PolicyEngine); ok {
+		return e.Authorize(w, r, p, s, route)
+	}
+	return route(w, r, p, s)
+}
+
+func (s *APIServer) HandleAdminPolicy(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+	return writeJSON(w, http.StatusOK, s.policy.Document())
+}
+
+// HandleAdminReloadPolicy applies changes to the rules file.
+func (s *APIServer) HandleAdminReloadPolicy(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+	if err := s.policy.Reload(); err != nil {
+		return ApiError{Err: err.Error(), Status: http.StatusBadRequest}
+	}
+
+	event := NewAuditEvent(adminActor(r), "policy.reloaded", 0, map[string]string{"source": s.policy.Document().Source})
+	if err := s.storage.CreateAuditEvent(event); err != nil {
+		log.Println("Failed to audit policy reload: ", err)
+	}
+	return writeJSON(w, http.StatusOK, s.policy.Document())
+}
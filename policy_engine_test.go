@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyFileTightensRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"rules": [
+		{"name": "no-login-history", "effect": "deny", "actions": ["GET"], "resources": ["/account/{id}/logins"]},
+		{"name": "route", "effect": "allow", "when": ["route"]}
+	]}`), 0o600))
+	t.Setenv("POLICY_FILE", path)
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	acc := createTestAccount(t, store, 100)
+	other := createTestAccount(t, store, 0)
+	token, err := createJWT(acc)
+	assert.Nil(t, err)
+
+	get := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	reload := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/policy/reload", nil)
+		req.Header.Set("x-admin-token", "test-admin")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get("/account/"+acc.PublicID))
+	assert.Equal(t, http.StatusForbidden, get("/account/"+other.PublicID))
+	assert.Equal(t, http.StatusForbidden, get("/account/"+acc.PublicID+"/logins"))
+
+	// A broken file is rejected and the rules in force stay.
+	assert.Nil(t, os.WriteFile(path, []byte(`{"rules": [{"effect": "allow", "when": ["anyone"]}]}`), 0o600))
+	assert.Equal(t, http.StatusBadRequest, reload())
+	assert.Equal(t, http.StatusForbidden, get("/account/"+acc.PublicID+"/logins"))
+
+	assert.Nil(t, os.WriteFile(path, []byte(`{"rules": [{"effect": "allow", "when": ["route"]}]}`), 0o600))
+	assert.Equal(t, http.StatusOK, reload())
+	assert.Equal(t, http.StatusOK, get("/account/"+acc.PublicID+"/logins"))
+}
+
+func TestPolicyEngineKeepsRouteErrors(t *testing.T) {
+	t.Setenv("POLICY_FILE", "")
+	engine, err := policyEngineFromEnv()
+	assert.Nil(t, err)
+
+	store := NewMemoryStorage()
+	from := createTestAccount(t, store, 100)
+	other := createTestAccount(t, store, 0)
+	transfer := NewTransfer(from.ID, other.ID, NewMoney(10, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(transfer))
+	stranger := createTestAccount(t, store, 0)
+
+	id := strconv.Itoa(transfer.ID)
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/transfer/"+id, nil), map[string]string{"transferID": id})
+	assert.Nil(t, engine.Authorize(httptest.NewRecorder(), r, customerPrincipal(from, ""), store, partyToTransfer))
+	err = engine.Authorize(httptest.NewRecorder(), r, customerPrincipal(stranger, ""), store, partyToTransfer)
+	assert.Equal(t, transferNotFound, err)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwnsAccount(t *testing.T) {
+	p := &Principal{Role: RoleCustomer, AccountID: 7, AccountPublicID: NewULID()}
+
+	check := func(id string) error {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/account/"+id, nil), map[string]string{"id": id})
+		return ownsAccount(httptest.NewRecorder(), r, p, nil)
+	}
+	assert.Nil(t, check(p.AccountPublicID))
+	assert.Nil(t, check("7"))
+	assert.Equal(t, permissionDenied, check("8"))
+	assert.Equal(t, permissionDenied, check(NewULID()))
+}
+
+func TestPartyToTransfer(t *testing.T) {
+	store := NewMemoryStorage()
+	from := createTestAccount(t, store, 100)
+	to := createTestAccount(t, store, 0)
+	other := createTestAccount(t, store, 0)
+
+	transfer := NewTransfer(from.ID, to.ID, NewMoney(10, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(transfer))
+
+	check := func(acc *Account, id string) error {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/transfer/"+id, nil), map[string]string{"transferID": id})
+		return partyToTransfer(httptest.NewRecorder(), r, customerPrincipal(acc, ""), store)
+	}
+	assert.Nil(t, check(from, transfer.PublicID))
+	assert.Nil(t, check(to, strconv.Itoa(transfer.ID)))
+	assert.Equal(t, transferNotFound, check(other, transfer.PublicID))
+	assert.Equal(t, transferNotFound, check(from, "nope"))
+}
+
+func TestRequireScope(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/transfer", nil)
+	policy := requireScope("transfers")
+
+	assert.Nil(t, policy(nil, r, &Principal{Scopes: []string{"accounts", "transfers"}}, nil))
+	assert.Equal(t, permissionDenied, policy(nil, r, &Principal{Scopes: []string{"accounts"}}, nil))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedirectMode is what happens to transfers addressed to a closed account
+// with a redirect.
+type RedirectMode string
+
+const (
+	// RedirectRoute credits the successor instead.
+	RedirectRoute RedirectMode = "route"
+	// RedirectBounce refuses the transfer, naming the successor so the
+	// payer can update their details.
+	RedirectBounce RedirectMode = "bounce"
+)
+
+// AccountRedirect points a closed account at its successor until
+// ExpiresAt. Phone is the number that moved over to the successor, if the
+// successor had none of its own.
+type AccountRedirect struct {
+	AccountID       int          `json:"accountId"`
+	Number          int32        `json:"number"`
+	Phone           string       `json:"phone,omitempty"`
+	SuccessorID     int          `json:"successorId"`
+	SuccessorNumber int32        `json:"successorNumber"`
+	Mode            RedirectMode `json:"mode"`
+	CreatedAt       time.Time    `json:"createdAt"`
+	ExpiresAt       time.Time    `json:"expiresAt"`
+}
+
+func (r *AccountRedirect) Active(now time.Time) bool {
+	return now.Before(r.ExpiresAt)
+}
+
+type CloseAccountRequest struct {
+	SuccessorID int          `json:"successorId"`
+	Mode        RedirectMode `json:"mode"`
+	Reason      string       `json:"reason"`
+}
+
+// accountRedirectGracePeriod is how long transfers to a closed account
+// keep following its redirect.
+func accountRedirectGracePeriod() time.Duration {
+	return getEnvDuration("ACCOUNT_REDIRECT_GRACE_PERIOD", 90*24*time.Hour)
+}
+
+// HandleAdminCloseAccount closes an account whose holder moved to another
+// one, e.g. after switching currency or from a personal to a business
+// account. The balance has to be moved first. For the grace period,
+// transfers to the closed account are routed to the successor or bounced
+// with its number, and the closed account's phone number moves over so
+// phone lookups find the successor.
+func (s *APIServer) HandleAdminCloseAccount(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	accountID, err := getIntVar(r, "accountID")
+	if err != nil {
+		return err
+	}
+
+	req := new(CloseAccountRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	if req.Mode == "" {
+		req.Mode = RedirectRoute
+	}
+	if req.Mode != RedirectRoute && req.Mode != RedirectBounce {
+		return ApiError{Err: "mode must be route or bounce", Status: http.StatusBadRequest}
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		return ApiError{Err: "a reason is required", Status: http.StatusBadRequest}
+	}
+	if req.SuccessorID == accountID {
+		return ApiError{Err: "an account cannot succeed itself", Status: http.StatusBadRequest}
+	}
+
+	account, err := s.storage.GetAccountByID(accountID)
+	if err != nil {
+		return ApiError{Err: "account not found", Status: http.StatusNotFound}
+	}
+	successor, err := s.storage.GetAccountByID(req.SuccessorID)
+	if err != nil {
+		return ApiError{Err: "successor account not found", Status: http.StatusBadRequest}
+	}
+	if account.System != "" || successor.System != "" {
+		return ApiError{Err: "system accounts cannot be redirected", Status: http.StatusBadRequest}
+	}
+	if account.Balance.Amount != 0 {
+		return ApiError{Err: "move the balance to the successor before closing", Status: http.StatusConflict}
+	}
+
+	now := time.Now().UTC()
+	redirect := &AccountRedirect{
+		AccountID:       account.ID,
+		Number:          account.Number,
+		SuccessorID:     successor.ID,
+		SuccessorNumber: successor.Number,
+		Mode:            req.Mode,
+		CreatedAt:       now,
+		ExpiresAt:       now.Add(accountRedirectGracePeriod()),
+	}
+	if successor.Phone == "" {
+		redirect.Phone = account.Phone
+	}
+
+	event := NewAuditEvent(adminActor(r), "account.closed", account.ID, map[string]string{
+		"reason":      req.Reason,
+		"successorId": strconv.Itoa(successor.ID),
+		"mode":        string(req.Mode),
+		"expiresAt":   redirect.ExpiresAt.Format(time.RFC3339),
+		"phoneMoved":  strconv.FormatBool(redirect.Phone != ""),
+	})
+	if err := s.storage.CloseAccountWithRedirect(redirect, event); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, redirect)
+}
+
+// redirectedDestination resolves a transfer to a closed account through
+// its redirect, pointing req at the successor. Without an active redirect
+// the destination is simply not found.
+func (s *APIServer) redirectedDestination(from *Account, req *TransferRequest) (*Account, error) {
+	notFound := ApiError{Err: "destination account not found", Status: http.StatusBadRequest}
+
+	redirect, err := s.storage.GetAccountRedirect(req.ToAccount)
+	if err != nil || !redirect.Active(time.Now()) {
+		return nil, notFound
+	}
+	if redirect.Mode == RedirectBounce {
+		return nil, ApiError{
+			Err: fmt.Sprintf("account %d is closed, its holder now receives payments at account %d",
+				redirect.Number, redirect.SuccessorNumber),
+			Status: http.StatusBadRequest,
+		}
+	}
+
+	to, err := s.storage.GetAccountByID(redirect.SuccessorID)
+	if err != nil {
+		return nil, notFound
+	}
+	if to.ID == from.ID {
+		return nil, ApiError{Err: "cannot transfer to the same account", Status: http.StatusBadRequest}
+	}
+	req.ToAccount = to.ID
+	return to, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClosedAccountRedirect(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	payer := createTestAccount(t, store, 1000)
+	closed := createTestAccount(t, store, 0)
+	closed.Phone = "+15550001234"
+	assert.Nil(t, store.UpdateAccount(closed))
+	successor := createTestAccount(t, store, 0)
+	token, err := createJWT(payer)
+	assert.Nil(t, err)
+
+	closeAccount := func(id int, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/accounts/"+strconv.Itoa(id)+"/close", strings.NewReader(body))
+		req.Header.Set("x-admin-token", "test-admin")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	preview := func(to int) *httptest.ResponseRecorder {
+		body := `{"toAccount":` + strconv.Itoa(to) + `,"amount":{"amount":100}}`
+		req := httptest.NewRequest(http.MethodPost, "/transfer/preview", strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := closeAccount(payer.ID, `{"successorId":`+strconv.Itoa(successor.ID)+`,"reason":"moved"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code, "accounts with money can't be closed")
+
+	rec = closeAccount(closed.ID, `{"successorId":`+strconv.Itoa(successor.ID)+`,"reason":"moved"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = preview(closed.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"toAccount":`+strconv.Itoa(successor.ID))
+
+	moved, err := store.GetAccountsByPhone([]string{"+15550001234"})
+	assert.Nil(t, err)
+	assert.Len(t, moved, 1)
+	assert.Equal(t, successor.ID, moved[0].ID)
+
+	events, err := store.GetAuditEvents(closed.ID, 10)
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "account.closed", events[0].Action)
+
+	bounced := createTestAccount(t, store, 0)
+	rec = closeAccount(bounced.ID, `{"successorId":`+strconv.Itoa(successor.ID)+`,"mode":"bounce","reason":"moved"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = preview(bounced.ID)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "at account "+strconv.Itoa(int(successor.Number)))
+
+	// Past the grace period the old account is simply gone.
+	redirect, err := store.GetAccountRedirect(closed.ID)
+	assert.Nil(t, err)
+	redirect.ExpiresAt = time.Now().Add(-time.Minute)
+	store.redirects[closed.ID] = redirect
+	rec = preview(closed.ID)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "destination account not found")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleAdmin    Role = "admin"
+	RoleTerminal Role = "terminal"
+	RoleTeller   Role = "teller"
+)
+
+// customerScopes are granted to customer tokens. Tokens issued before the
+// scope claim existed carry none and get all of them.
+var customerScopes = []string{"accounts", "transfers"}
+
+// Principal is the authenticated caller, put into the request context by
+// the auth wrappers. Which fields are set depends on the role: accounts
+// for customers, TerminalID for terminals and Name for admins and tellers.
+type Principal struct {
+	Role            Role
+	AccountID       int
+	AccountNumber   int32
+	AccountPublicID string
+	TerminalID      string
+	Name            string
+	Scopes          []string
+	// DelegatorID and DelegatorPublicID are set when a customer reads an
+	// account whose owner made them a delegate.
+	DelegatorID       int
+	DelegatorPublicID string
+	// ImpersonatedBy and ImpersonationID are set when a support admin
+	// uses an impersonation token for the account.
+	ImpersonatedBy  string
+	ImpersonationID string
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// consumer names the principal for usage metering. Impersonated requests
+// name the admin as well, so they can't pass for the customer's own.
+func (p *Principal) consumer() string {
+	if p.ImpersonatedBy != "" {
+		return p.ImpersonatedBy + " as account:" + p.AccountPublicID
+	}
+	switch p.Role {
+	case RoleTerminal:
+		return "terminal:" + p.TerminalID
+	case RoleAdmin:
+		return "admin"
+	case RoleTeller:
+		return "teller:" + p.Name
+	default:
+		return "account:" + p.AccountPublicID
+	}
+}
+
+func withPrincipal(r *http.Request, p *Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalContextKey, p))
+}
+
+// principalFromContext returns the caller authenticated by one of the auth
+// wrappers, or nil on unauthenticated routes.
+func principalFromContext(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalContextKey).(*Principal)
+	return p
+}
+
+func customerPrincipal(account *Account, scopeClaim string) *Principal {
+	scopes := customerScopes
+	if scopeClaim != "" {
+		scopes = strings.Fields(scopeClaim)
+	}
+	return &Principal{
+		Role:            RoleCustomer,
+		AccountID:       account.ID,
+		AccountNumber:   account.Number,
+		AccountPublicID: account.PublicID,
+		Scopes:          scopes,
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWTAuthSetsPrincipal(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	acc := createTestAccount(t, store, 0)
+	token, err := createJWT(acc)
+	assert.Nil(t, err)
+
+	var principal *Principal
+	handler := makeHTTPHandleFunc(withJWTAuth(func(w http.ResponseWriter, r *http.Request) error {
+		principal = principalFromContext(r)
+		return nil
+	}, store, requireScope("transfers")))
+
+	req := httptest.NewRequest(http.MethodGet, "/transfer", nil)
+	req.Header.Set("x-jwt-token", token)
+	handler(httptest.NewRecorder(), req)
+
+	assert.Equal(t, RoleCustomer, principal.Role)
+	assert.Equal(t, acc.ID, principal.AccountID)
+	assert.Equal(t, acc.Number, principal.AccountNumber)
+	assert.True(t, principal.HasScope("transfers"))
+	assert.Equal(t, "account:"+acc.PublicID, principal.consumer())
+}
+
+func TestAdminAuthNamesPrincipal(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	var actor string
+	handler := makeHTTPHandleFunc(withAdminAuth(func(w http.ResponseWriter, r *http.Request) error {
+		actor = adminActor(r)
+		return nil
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit", nil)
+	req.SetBasicAuth("jdoe", "test-admin")
+	handler(httptest.NewRecorder(), req)
+	assert.Equal(t, "admin:jdoe", actor)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrQueryTimeout     = errors.New("request took too long, try again later")
+	ErrRequestCancelled = errors.New("request cancelled")
+)
+
+// ContextStorage is a Storage that can run its queries under a context,
+// so they are cancelled with the request that made them.
+type ContextStorage interface {
+	WithContext(ctx context.Context) Storage
+}
+
+// storageWithContext returns store running its queries under ctx, or store
+// itself when it doesn't query a database.
+func storageWithContext(store Storage, ctx context.Context) Storage {
+	if cs, ok := store.(ContextStorage); ok {
+		return cs.WithContext(ctx)
+	}
+	return store
+}
+
+// storageFor returns the storage bound to r, whose queries stop when the
+// client goes away or the route's statement timeout runs out. Writes keep
+// using s.storage: a transfer abandoned halfway would be left pending.
+func (s *APIServer) storageFor(r *http.Request) Storage {
+	return storageWithContext(s.storage, r.Context())
+}
+
+// StatementTimeouts gives requests to routes with a latency budget a
+// deadline of Factor times the budget. Queries run past it are cancelled,
+// and transactions get it as their statement_timeout so Postgres stops
+// them even if the cancellation is lost.
+type StatementTimeouts struct {
+	latency *LatencyBudget
+	Factor  float64
+}
+
+func statementTimeoutsFromEnv(latency *LatencyBudget) *StatementTimeouts {
+	return &StatementTimeouts{latency: latency, Factor: getEnvFloat("STATEMENT_TIMEOUT_FACTOR", 2)}
+}
+
+// Timeout is the deadline of requests to route, or 0 for none.
+func (t *StatementTimeouts) Timeout(route string) time.Duration {
+	return time.Duration(float64(t.latency.Budgets[route]) * t.Factor)
+}
+
+func (t *StatementTimeouts) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, _ := currentRouteTemplate(r)
+		timeout := t.Timeout(route)
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// contextError explains err when the request ctx ended while it ran or
+// Postgres cancelled the statement.
+func contextError(ctx context.Context, err error) error {
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: %v", ErrQueryTimeout, err)
+	case ctx.Err() != nil:
+		return fmt.Errorf("%w: %v", ErrRequestCancelled, err)
+	case errors.As(err, &pqErr) && pqErr.Code == "57014":
+		return fmt.Errorf("%w: %v", ErrQueryTimeout, err)
+	}
+	return err
+}
+
+// dbConn is the failoverDB running queries under the context of one
+// request, or in the background for everything else.
+type dbConn struct {
+	*failoverDB
+	ctx context.Context
+}
+
+func (c dbConn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.ExecContext(c.ctx, query, args...)
+}
+
+func (c dbConn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.QueryContext(c.ctx, query, args...)
+}
+
+func (c dbConn) QueryRow(query string, args ...any) *sql.Row {
+	return c.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c dbConn) Begin() (*sql.Tx, error) {
+	return c.BeginTx(nil)
+}
+
+// BeginTx starts a transaction that is rolled back once the context ends.
+// With a deadline, it is also the transaction's statement_timeout.
+func (c dbConn) BeginTx(opts *sql.TxOptions) (*sql.Tx, error) {
+	tx, err := c.failoverDB.BeginTx(c.ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := c.ctx.Deadline(); ok {
+		ms := time.Until(deadline).Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		if _, err := tx.Exec("select set_config('statement_timeout', $1, true)", strconv.FormatInt(ms, 10)); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+// WithContext returns the storage running its queries under ctx.
+func (s *PostgresStorage) WithContext(ctx context.Context) Storage {
+	return &PostgresStorage{db: dbConn{failoverDB: s.db.failoverDB, ctx: ctx}}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementTimeoutFollowsLatencyBudget(t *testing.T) {
+	timeouts := &StatementTimeouts{
+		latency: NewLatencyBudget(map[string]time.Duration{"/slow": 10 * time.Millisecond}, 0.99, false),
+		Factor:  2,
+	}
+	assert.Equal(t, 20*time.Millisecond, timeouts.Timeout("/slow"))
+	assert.Equal(t, time.Duration(0), timeouts.Timeout("/other"))
+
+	router := mux.NewRouter()
+	router.Use(timeouts.Middleware)
+	router.HandleFunc("/slow", makeHTTPHandleFunc(func(w http.ResponseWriter, r *http.Request) error {
+		// Stands in for a query the driver cancels at the deadline.
+		<-r.Context().Done()
+		return r.Context().Err()
+	}))
+	router.HandleFunc("/other", makeHTTPHandleFunc(func(w http.ResponseWriter, r *http.Request) error {
+		_, ok := r.Context().Deadline()
+		assert.False(t, ok)
+		return writeJSON(w, http.StatusOK, nil)
+	}))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrQueryTimeout.Error())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/other", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestContextError(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, contextError(cancelled, context.Canceled), ErrRequestCancelled)
+	assert.ErrorIs(t, contextError(context.Background(), &pq.Error{Code: "57014"}), ErrQueryTimeout)
+	assert.Equal(t, ErrAccountNotFound, contextError(context.Background(), ErrAccountNotFound))
+}
+
+func TestWrappedStorageKeepsWrappersWithContext(t *testing.T) {
+	store := NewAlertingStorage(NewChaosStorage(NewMemoryStorage(), NewChaos(ChaosConfig{})), nil)
+	bound, ok := storageWithContext(store, context.Background()).(*AlertingStorage)
+	if assert.True(t, ok) {
+		assert.IsType(t, &ChaosStorage{}, bound.Storage)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Receipt is the proof of a settled transfer. Credited is what the
+// recipient got, which for transfers between currencies is not Amount.
+type Receipt struct {
+	TransferID  string    `json:"transferId"`
+	FromAccount int32     `json:"fromAccount"`
+	ToAccount   int32     `json:"toAccount"`
+	Amount      Money     `json:"amount"`
+	Credited    Money     `json:"credited"`
+	SettledAt   time.Time `json:"settledAt"`
+	IssuedAt    time.Time `json:"issuedAt"`
+}
+
+// SignedReceipt carries the exact bytes that were signed in Payload, so a
+// counterparty can check Signature against the public key offline without
+// having to reproduce our JSON encoding.
+type SignedReceipt struct {
+	Receipt   Receipt `json:"receipt"`
+	Payload   string  `json:"payload"`
+	Signature string  `json:"signature"`
+	Algorithm string  `json:"algorithm"`
+	KeyID     string  `json:"keyId"`
+}
+
+type ReceiptSigner struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+func NewReceiptSigner(key ed25519.PrivateKey) *ReceiptSigner {
+	sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+	return &ReceiptSigner{key: key, keyID: hex.EncodeToString(sum[:8])}
+}
+
+// newReceiptSignerFromEnv loads the base64 encoded Ed25519 seed from
+// RECEIPT_SIGNING_KEY. Without one, receipts are signed with a throwaway key
+// that only lives as long as the process.
+func newReceiptSignerFromEnv() *ReceiptSigner {
+	seed := os.Getenv("RECEIPT_SIGNING_KEY")
+	if seed == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("RECEIPT_SIGNING_KEY is not set, signing receipts with an ephemeral key")
+		return NewReceiptSigner(key)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil || len(raw) != ed25519.SeedSize {
+		log.Fatal("RECEIPT_SIGNING_KEY must be a base64 encoded 32 byte seed")
+	}
+	return NewReceiptSigner(ed25519.NewKeyFromSeed(raw))
+}
+
+func (rs *ReceiptSigner) Sign(receipt Receipt) (*SignedReceipt, error) {
+	payload, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignedReceipt{
+		Receipt:   receipt,
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(rs.key, payload)),
+		Algorithm: "Ed25519",
+		KeyID:     rs.keyID,
+	}, nil
+}
+
+func (rs *ReceiptSigner) PublicKey() ed25519.PublicKey {
+	return rs.key.Public().(ed25519.PublicKey)
+}
+
+func (sr *SignedReceipt) PDF() []byte {
+	r := sr.Receipt
+	lines := []string{
+		fmt.Sprintf("Transfer:     %s", r.TransferID),
+		fmt.Sprintf("From account: %d", r.FromAccount),
+		fmt.Sprintf("To account:   %d", r.ToAccount),
+		fmt.Sprintf("Amount:       %s", r.Amount),
+		fmt.Sprintf("Credited:     %s", r.Credited),
+		fmt.Sprintf("Settled at:   %s", r.SettledAt.Format(time.RFC3339)),
+		fmt.Sprintf("Issued at:    %s", r.IssuedAt.Format(time.RFC3339)),
+		"",
+		fmt.Sprintf("Signature (%s, key %s):", sr.Algorithm, sr.KeyID),
+	}
+	lines = append(lines, wrap(sr.Signature, 80)...)
+	lines = append(lines, "", "Signed payload:")
+	lines = append(lines, wrap(sr.Payload, 80)...)
+
+	return renderTextPDF("Payment receipt", lines)
+}
+
+func wrap(s string, width int) []string {
+	lines := []string{}
+	for len(s) > width {
+		lines = append(lines, s[:width])
+		s = s[width:]
+	}
+	return append(lines, s)
+}
+
+// HandleGetReceipt returns a signed receipt for a settled transfer, as PDF
+// when asked for with ?format=pdf or Accept: application/pdf.
+func (s *APIServer) HandleGetReceipt(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	transfer, err := s.getTransfer(w, r)
+	if err != nil {
+		return err
+	}
+	if transfer.Status != TransferSettled {
+		return ApiError{Err: "transfer is not settled", Status: http.StatusConflict}
+	}
+
+	from, err := s.storage.GetAccountByID(transfer.FromAccount)
+	if err != nil {
+		return err
+	}
+	to, err := s.storage.GetAccountByID(transfer.ToAccount)
+	if err != nil {
+		return err
+	}
+
+	signed, err := s.receipts.Sign(Receipt{
+		TransferID:  transfer.PublicID,
+		FromAccount: from.Number,
+		ToAccount:   to.Number,
+		Amount:      transfer.Amount,
+		Credited:    transfer.Credited(),
+		SettledAt:   transfer.UpdatedAt,
+		IssuedAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if r.URL.Query().Get("format") == "pdf" || strings.Contains(r.Header.Get("Accept"), "application/pdf") {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="receipt-%s.pdf"`, transfer.PublicID))
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write(signed.PDF())
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, signed)
+}
+
+// HandleGetReceiptKey publishes the key receipts can be verified with.
+func (s *APIServer) HandleGetReceiptKey(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]string{
+		"algorithm": "Ed25519",
+		"keyId":     s.receipts.keyID,
+		"publicKey": base64.StdEncoding.EncodeToString(s.receipts.PublicKey()),
+	})
+}
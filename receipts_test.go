@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignedReceiptVerifies(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	assert.Nil(t, err)
+	signer := NewReceiptSigner(key)
+
+	signed, err := signer.Sign(Receipt{TransferID: NewULID(), Amount: NewMoney(1250, "USD"), IssuedAt: time.Now().UTC()})
+	assert.Nil(t, err)
+
+	payload, _ := base64.StdEncoding.DecodeString(signed.Payload)
+	sig, _ := base64.StdEncoding.DecodeString(signed.Signature)
+	assert.True(t, ed25519.Verify(signer.PublicKey(), payload, sig))
+
+	payload[0] = 'x'
+	assert.False(t, ed25519.Verify(signer.PublicKey(), payload, sig))
+
+	pdf := signed.PDF()
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-1.4")))
+	assert.True(t, bytes.Contains(pdf, []byte("12.50 USD")))
+}
+
+func TestReceiptShowsCreditedAmount(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	sender := createTestAccount(t, store, 100_00)
+	recipient := createTestAccount(t, store, 0)
+	store.accounts[recipient.ID].Balance.Currency = "JPY"
+
+	transfer := NewTransfer(sender.ID, recipient.ID, NewMoney(1000, defaultCurrency))
+	transfer.Credit = &Money{Amount: 1514, Currency: "JPY"}
+	assert.Nil(t, store.CreateTransfer(transfer))
+	assert.Nil(t, store.ExecuteTransfer(transfer))
+
+	token, err := createJWT(recipient)
+	assert.Nil(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/transactions/"+transfer.PublicID+"/receipt", nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var signed SignedReceipt
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &signed))
+	assert.Equal(t, NewMoney(1000, defaultCurrency), signed.Receipt.Amount)
+	assert.Equal(t, NewMoney(1514, "JPY"), signed.Receipt.Credited)
+	assert.True(t, bytes.Contains(signed.PDF(), []byte("1514 JPY")))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type RefundRequest struct {
+	Amount Money `json:"amount"`
+}
+
+// RefundResponse is the refund transfer along with how much of the
+// original has been paid back so far and how much still can be.
+type RefundResponse struct {
+	Refund     TransferResource `json:"refund"`
+	Refunded   Money            `json:"refunded"`
+	Refundable Money            `json:"refundable"`
+}
+
+// refundedAmount sums the refunds of t that haven't failed, in the
+// currency t credited. Pending ones count, so two refunds in flight can't
+// together exceed the original.
+func refundedAmount(t *Transfer, refunds []*Transfer) (Money, error) {
+	total := NewMoney(0, t.Credited().Currency)
+	for _, r := range refunds {
+		if r.Status == TransferFailed {
+			continue
+		}
+		var err error
+		if total, err = total.Add(r.Amount); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// refundCredit is what the sender of original gets back, in the currency
+// they paid in, when amount more is refunded on top of refunded. It
+// converts at the rate the original went through at, and works on the
+// running total so partial refunds add up to exactly the original debit.
+func refundCredit(original *Transfer, refunded, amount Money) Money {
+	debited, credited := original.Amount.Amount, original.Credited().Amount
+	before := refunded.Amount * debited / credited
+	after := (refunded.Amount + amount.Amount) * debited / credited
+	return NewMoney(after-before, original.Amount.Currency)
+}
+
+// HandleRefundTransfer pays back part or all of a settled transfer from
+// its recipient to its sender. Refunds are in the currency the recipient
+// was credited in, add up against that and can't exceed it.
+func (s *APIServer) HandleRefundTransfer(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	original, err := s.getTransfer(w, r)
+	if err != nil {
+		return err
+	}
+
+	req := new(RefundRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	account := accountFromContext(r)
+	if original.ToAccount != account.ID {
+		return ApiError{Err: "only the recipient can refund a transfer", Status: http.StatusForbidden}
+	}
+	if original.RefundOf != 0 {
+		return ApiError{Err: "cannot refund a refund", Status: http.StatusBadRequest}
+	}
+	if original.Status != TransferSettled {
+		return ErrStateConflict
+	}
+	credited := original.Credited()
+	if req.Amount.Currency == "" {
+		req.Amount.Currency = credited.Currency
+	}
+	if !req.Amount.IsPositive() {
+		return ApiError{Err: "amount must be positive", Status: http.StatusBadRequest}
+	}
+	if req.Amount.Currency != credited.Currency {
+		return ApiError{Err: "refund must be in the currency the transfer was credited in", Status: http.StatusBadRequest}
+	}
+
+	refunds, err := s.storage.GetRefunds(original.ID)
+	if err != nil {
+		return err
+	}
+	refunded, err := refundedAmount(original, refunds)
+	if err != nil {
+		return err
+	}
+	refundable, err := credited.Sub(refunded)
+	if err != nil {
+		return err
+	}
+	if cmp, err := req.Amount.Cmp(refundable); err != nil || cmp > 0 {
+		return ApiError{Err: "refund exceeds the refundable amount of " + refundable.String(), Status: http.StatusUnprocessableEntity}
+	}
+
+	if err := s.checkDebitsAllowed(account.ID, time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := s.checkTermsAccepted(account.ID); err != nil {
+		return err
+	}
+	if err := s.usage.Record(w, principalFromContext(r).consumer(), UsageTransfers); err != nil {
+		return err
+	}
+
+	refund := NewTransfer(account.ID, original.FromAccount, req.Amount)
+	refund.RefundOf = original.ID
+	refund.Reference = "refund:" + original.PublicID
+	if original.Credit != nil {
+		credit := refundCredit(original, refunded, req.Amount)
+		refund.Credit = &credit
+	}
+	if err := s.storage.CreateTransfer(refund); err != nil {
+		return err
+	}
+	s.recordTransferOrigin(r, refund)
+	if err := s.storage.ExecuteTransfer(refund); err != nil {
+		return err
+	}
+
+	if refund.Status != TransferFailed {
+		refunded, _ = refunded.Add(refund.Amount)
+		refundable, _ = refundable.Sub(refund.Amount)
+	}
+	return writeJSON(w, http.StatusOK, RefundResponse{
+		Refund:     newTransferResource(refund),
+		Refunded:   refunded,
+		Refundable: refundable,
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartialRefunds(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	customer := createTestAccount(t, store, 1000)
+	merchant := createTestAccount(t, store, 0)
+	merchantToken, err := createJWT(merchant)
+	assert.Nil(t, err)
+	customerToken, err := createJWT(customer)
+	assert.Nil(t, err)
+
+	purchase := NewTransfer(customer.ID, merchant.ID, NewMoney(500, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(purchase))
+	assert.Nil(t, store.ExecuteTransfer(purchase))
+
+	refund := func(token string, amount string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/transfer/"+purchase.PublicID+"/refund",
+			strings.NewReader(`{"amount":{"amount":`+amount+`}}`))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusForbidden, refund(customerToken, "100").Code)
+
+	rec := refund(merchantToken, "200")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp RefundResponse
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, purchase.PublicID, resp.Refund.RefundOfPublicID)
+	assert.Equal(t, int64(200), resp.Refunded.Amount)
+	assert.Equal(t, int64(300), resp.Refundable.Amount)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, refund(merchantToken, "301").Code)
+	assert.Equal(t, http.StatusOK, refund(merchantToken, "300").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, refund(merchantToken, "1").Code)
+
+	assert.Equal(t, int64(1000), balanceOf(t, store, customer.ID))
+	assert.Equal(t, int64(0), balanceOf(t, store, merchant.ID))
+}
+
+func TestRefundOfFXTransfer(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	customer := createTestAccount(t, store, 1000)
+	merchant := createTestAccount(t, store, 0)
+	store.accounts[merchant.ID].Balance.Currency = "JPY"
+	merchantToken, err := createJWT(merchant)
+	assert.Nil(t, err)
+
+	// 1000 USD cents bought 1514 JPY at a quoted rate.
+	purchase := NewTransfer(customer.ID, merchant.ID, NewMoney(1000, defaultCurrency))
+	credit := NewMoney(1514, "JPY")
+	purchase.Credit = &credit
+	assert.Nil(t, store.CreateTransfer(purchase))
+	assert.Nil(t, store.ExecuteTransfer(purchase))
+	assert.Equal(t, TransferSettled, purchase.Status)
+
+	refund := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/transfer/"+purchase.PublicID+"/refund", strings.NewReader(body))
+		req.Header.Set("x-jwt-token", merchantToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusBadRequest, refund(`{"amount":{"amount":100,"currency":"USD"}}`).Code)
+
+	rec := refund(`{"amount":{"amount":500}}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp RefundResponse
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, TransferSettled, resp.Refund.Status)
+	assert.Equal(t, NewMoney(500, "JPY"), resp.Refund.Amount)
+	assert.Equal(t, NewMoney(330, defaultCurrency), resp.Refund.Credited())
+	assert.Equal(t, NewMoney(500, "JPY"), resp.Refunded)
+	assert.Equal(t, NewMoney(1014, "JPY"), resp.Refundable)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, refund(`{"amount":{"amount":1015}}`).Code)
+	assert.Equal(t, http.StatusOK, refund(`{"amount":{"amount":1014}}`).Code)
+
+	// Together the refunds pay back exactly what the customer sent.
+	assert.Equal(t, int64(1000), balanceOf(t, store, customer.ID))
+	assert.Equal(t, int64(0), balanceOf(t, store, merchant.ID))
+}
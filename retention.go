@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const purgeBatchSize = 100
+
+// LegalHold keeps a deleted account from being purged, for instance while
+// it is subject to litigation or an investigation.
+type LegalHold struct {
+	AccountID int       `json:"accountId"`
+	Reason    string    `json:"reason"`
+	PlacedBy  string    `json:"placedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type LegalHoldRequest struct {
+	Reason string `json:"reason"`
+}
+
+// DeletedAccount is a soft-deleted account waiting out its retention.
+type DeletedAccount struct {
+	ID        int
+	PublicID  string
+	Balance   Money
+	DeletedAt time.Time
+	LegalHold bool
+}
+
+// PurgeReport is what one sweep did, as written to the audit log.
+type PurgeReport struct {
+	Cutoff time.Time
+	Purged []int
+	// Records counts the rows removed along with the accounts.
+	Records     int64
+	Held        []int
+	WithBalance []int
+}
+
+// RetentionSweeper permanently purges accounts soft-deleted more than
+// RetainFor ago, along with the personal data kept for them: devices,
+// logins, contacts, rules and preferences. Transfers, audit events and
+// the rest of the ledger stay, so the books still add up. Accounts under
+// a legal hold, and those deleted with money left on them, are kept.
+type RetentionSweeper struct {
+	RetainFor time.Duration
+	Interval  time.Duration
+
+	storage Storage
+}
+
+// retentionSweeperFromEnv returns nil unless PURGE_DELETED_AFTER_DAYS is
+// set.
+func retentionSweeperFromEnv(store Storage) *RetentionSweeper {
+	days := getEnvInt("PURGE_DELETED_AFTER_DAYS", 0)
+	if days <= 0 {
+		return nil
+	}
+	return &RetentionSweeper{
+		RetainFor: time.Duration(days) * 24 * time.Hour,
+		Interval:  getEnvDuration("PURGE_INTERVAL", time.Hour),
+		storage:   store,
+	}
+}
+
+func (p *RetentionSweeper) Run() {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.sweep(time.Now().UTC()); err != nil {
+			log.Println("Failed to purge deleted accounts: ", err)
+		}
+		<-ticker.C
+	}
+}
+
+// sweep purges what is due at now and audits the report, unless nothing
+// was purged.
+func (p *RetentionSweeper) sweep(now time.Time) (*PurgeReport, error) {
+	report := &PurgeReport{Cutoff: now.Add(-p.RetainFor)}
+	err := p.purge(report)
+	if len(report.Purged) == 0 {
+		return report, err
+	}
+
+	event := NewAuditEvent("system", "retention.purged", 0, map[string]string{
+		"cutoff":      report.Cutoff.Format(time.RFC3339),
+		"accounts":    joinIDs(report.Purged),
+		"records":     strconv.FormatInt(report.Records, 10),
+		"held":        joinIDs(report.Held),
+		"withBalance": joinIDs(report.WithBalance),
+	})
+	if auditErr := p.storage.CreateAuditEvent(event); auditErr != nil && err == nil {
+		err = auditErr
+	}
+	return report, err
+}
+
+func (p *RetentionSweeper) purge(report *PurgeReport) error {
+	afterID := 0
+	for {
+		accounts, err := p.storage.GetDeletedAccounts(report.Cutoff, afterID, purgeBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, a := range accounts {
+			afterID = a.ID
+			switch {
+			case a.LegalHold:
+				report.Held = append(report.Held, a.ID)
+			case a.Balance.Amount != 0:
+				report.WithBalance = append(report.WithBalance, a.ID)
+			default:
+				records, err := p.storage.PurgeAccount(a.ID)
+				if err != nil {
+					return err
+				}
+				report.Purged = append(report.Purged, a.ID)
+				report.Records += records
+			}
+		}
+		if len(accounts) < purgeBatchSize {
+			return nil
+		}
+	}
+}
+
+func joinIDs(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return strings.Join(s, ",")
+}
+
+// HandleAdminLegalHold reads, places or releases the legal hold on an
+// account, deleted or not.
+func (s *APIServer) HandleAdminLegalHold(w http.ResponseWriter, r *http.Request) error {
+	accountID, err := getIntVar(r, "accountID")
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		hold, err := s.storage.GetLegalHold(accountID)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, hold)
+	case http.MethodPut:
+		req := new(LegalHoldRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return invalidRequest
+		}
+		defer r.Body.Close()
+
+		if strings.TrimSpace(req.Reason) == "" {
+			return ApiError{Err: "a reason is required", Status: http.StatusBadRequest}
+		}
+		hold := &LegalHold{AccountID: accountID, Reason: req.Reason, PlacedBy: adminActor(r), CreatedAt: time.Now().UTC()}
+		if err := s.storage.PlaceLegalHold(hold); err != nil {
+			return err
+		}
+		s.auditLegalHold(r, "legal_hold.placed", accountID, req.Reason)
+		return writeJSON(w, http.StatusOK, hold)
+	case http.MethodDelete:
+		if err := s.storage.ReleaseLegalHold(accountID); err != nil {
+			return err
+		}
+		s.auditLegalHold(r, "legal_hold.released", accountID, "")
+		return writeJSON(w, http.StatusOK, map[string]int{"released": accountID})
+	}
+	return methodNotAllowed
+}
+
+func (s *APIServer) auditLegalHold(r *http.Request, action string, accountID int, reason string) {
+	event := NewAuditEvent(adminActor(r), action, accountID, map[string]string{"reason": reason})
+	if err := s.storage.CreateAuditEvent(event); err != nil {
+		log.Println("Failed to audit legal hold: ", err)
+	}
+}
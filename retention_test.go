@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionSweeperPurgesUnheldAccounts(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	sweeper := &RetentionSweeper{RetainFor: 30 * 24 * time.Hour, storage: store}
+
+	gone := createTestAccount(t, store, 0)
+	held := createTestAccount(t, store, 0)
+	funded := createTestAccount(t, store, 100)
+	live := createTestAccount(t, store, 0)
+	assert.Nil(t, store.CreateDevice(&Device{AccountID: gone.ID, DeviceID: "phone", CreatedAt: time.Now().UTC()}))
+	for _, a := range []*Account{gone, held, funded} {
+		assert.Nil(t, store.DeleteAccount(a.ID))
+	}
+
+	call := func(method string, accountID int, body string) int {
+		req := httptest.NewRequest(method, "/admin/accounts/"+strconv.Itoa(accountID)+"/legal-hold", strings.NewReader(body))
+		req.Header.Set("x-admin-token", "test-admin")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPut, held.ID, `{"reason":" "}`))
+	assert.Equal(t, http.StatusOK, call(http.MethodPut, held.ID, `{"reason":"subpoena 2026-114"}`))
+	assert.Equal(t, http.StatusOK, call(http.MethodGet, held.ID, ""))
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, gone.ID, ""))
+
+	// Nothing is due within the retention window.
+	report, err := sweeper.sweep(time.Now().UTC())
+	assert.Nil(t, err)
+	assert.Empty(t, report.Purged)
+
+	report, err = sweeper.sweep(time.Now().UTC().AddDate(0, 0, 31))
+	assert.Nil(t, err)
+	assert.Equal(t, []int{gone.ID}, report.Purged)
+	assert.Equal(t, int64(2), report.Records)
+	assert.Equal(t, []int{held.ID}, report.Held)
+	assert.Equal(t, []int{funded.ID}, report.WithBalance)
+
+	devices, err := store.GetDevicesByAccount(gone.ID)
+	assert.Nil(t, err)
+	assert.Empty(t, devices)
+	_, err = store.GetAccountByID(live.ID)
+	assert.Nil(t, err)
+
+	events, err := store.GetAuditEvents(0, 10)
+	assert.Nil(t, err)
+	if assert.NotEmpty(t, events) {
+		assert.Equal(t, "retention.purged", events[0].Action)
+		assert.Equal(t, strconv.Itoa(gone.ID), events[0].Details["accounts"])
+		assert.Equal(t, strconv.Itoa(held.ID), events[0].Details["held"])
+	}
+
+	// Once released, the held account goes with the next sweep.
+	assert.Equal(t, http.StatusOK, call(http.MethodDelete, held.ID, ""))
+	assert.Equal(t, http.StatusNotFound, call(http.MethodDelete, held.ID, ""))
+	report, err = sweeper.sweep(time.Now().UTC().AddDate(0, 0, 31))
+	assert.Nil(t, err)
+	assert.Equal(t, []int{held.ID}, report.Purged)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	NotifyTransferHeld     NotificationKind = "transfer.held"
+	NotifyTransferApproved NotificationKind = "transfer.approved"
+	NotifyTransferDeclined NotificationKind = "transfer.declined"
+)
+
+// reviewDeclinedReason is the failure reason of transfers declined in
+// review.
+const reviewDeclinedReason = "declined in fraud review"
+
+// holdForReview holds a transfer flagged as possible fraud and answers the
+// request like an async transfer. The money stays in the sender's account
+// until a reviewer approves the transfer, which executes it like the
+// worker would, or declines it.
+func (s *APIServer) holdForReview(w http.ResponseWriter, t *Transfer) error {
+	if err := s.storage.HoldTransfer(t); err != nil {
+		return err
+	}
+	s.notifyReview(t, NotifyTransferHeld, "is on hold while we check it")
+
+	w.Header().Set("Location", "/transfer/"+t.PublicID)
+	return writeJSON(w, http.StatusAccepted, newTransferResource(t))
+}
+
+func (s *APIServer) notifyReview(t *Transfer, kind NotificationKind, what string) {
+	msg := fmt.Sprintf("Your transfer %s of %s %s.", t.PublicID, t.Amount, what)
+	if err := s.notifier.Notify(NewNotification(t.FromAccount, kind, msg)); err != nil {
+		log.Println("Failed to send transfer review notification: ", err)
+	}
+}
+
+// HandleAdminReviews lists held transfers with their origin, oldest first.
+func (s *APIServer) HandleAdminReviews(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	queue, err := s.reviewQueue()
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, queue)
+}
+
+// heldTransfers returns the transfers held for review, newest first.
+func heldTransfers(store Storage) ([]*Transfer, error) {
+	page := PageQuery{Before: time.Now().UTC().Add(time.Second), BeforeID: math.MaxInt32, Limit: maxPageLimit}
+	return store.GetTransfers(TransferFilter{Status: TransferHeld}, page)
+}
+
+// reviewQueue returns the held transfers with their origin, oldest first.
+func (s *APIServer) reviewQueue() ([]AdminTransfer, error) {
+	held, err := heldTransfers(s.storage)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(held))
+	for i, t := range held {
+		ids[i] = t.ID
+	}
+	origins, err := s.storage.GetTransferOrigins(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	queue := make([]AdminTransfer, 0, len(held))
+	for i := len(held) - 1; i >= 0; i-- {
+		queue = append(queue, AdminTransfer{TransferWithIDs: withInternalIDs(held[i]), Origin: origins[held[i].ID]})
+	}
+	return queue, nil
+}
+
+func (s *APIServer) HandleAdminApproveReview(w http.ResponseWriter, r *http.Request) error {
+	return s.handleReviewDecision(w, r, true)
+}
+
+func (s *APIServer) HandleAdminDeclineReview(w http.ResponseWriter, r *http.Request) error {
+	return s.handleReviewDecision(w, r, false)
+}
+
+// handleReviewDecision settles or fails a held transfer. An approved
+// transfer can still fail if the sender no longer has the money.
+func (s *APIServer) handleReviewDecision(w http.ResponseWriter, r *http.Request, approve bool) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	t, err := s.storage.GetTransferByPublicID(mux.Vars(r)["transferID"])
+	if err != nil {
+		return err
+	}
+	if t.Status != TransferHeld {
+		return ErrStateConflict
+	}
+
+	action := "transfer.review_declined"
+	if approve {
+		// A freeze window the customer set up since still applies.
+		if err := s.checkDebitsAllowed(t.FromAccount, time.Now().UTC()); err != nil {
+			return err
+		}
+		action = "transfer.review_approved"
+		err = s.storage.ExecuteTransfer(t)
+	} else {
+		err = s.storage.FailTransfer(t, reviewDeclinedReason)
+	}
+	if err != nil {
+		return err
+	}
+
+	event := NewAuditEvent(adminActor(r), action, t.FromAccount, map[string]string{
+		"transfer": t.PublicID,
+		"status":   string(t.Status),
+	})
+	if err := s.storage.CreateAuditEvent(event); err != nil {
+		log.Println("Failed to audit transfer review: ", err)
+	}
+
+	switch {
+	case t.Status == TransferSettled:
+		s.notifyReview(t, NotifyTransferApproved, "was approved and sent")
+	case approve:
+		s.notifyReview(t, NotifyTransferDeclined, "was approved but failed: "+t.FailureReason)
+	default:
+		s.notifyReview(t, NotifyTransferDeclined, "was declined and not sent")
+	}
+
+	return writeJSON(w, http.StatusOK, newTransferResource(t))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const EventReviewEscalated DomainEventKind = "review.escalated"
+
+type ReviewPriority string
+
+const (
+	ReviewNormal ReviewPriority = "normal"
+	ReviewHigh   ReviewPriority = "high"
+	ReviewUrgent ReviewPriority = "urgent"
+)
+
+// ReviewCase is the back office's triage of a transfer held for review:
+// how urgent it is, a note for whoever picks it up, and when the SLA for
+// deciding it runs out. Held transfers nobody triaged yet have a normal
+// priority.
+type ReviewCase struct {
+	TransferID  int            `json:"-"`
+	Priority    ReviewPriority `json:"priority"`
+	Note        string         `json:"note,omitempty"`
+	DueAt       time.Time      `json:"dueAt"`
+	EscalatedAt *time.Time     `json:"escalatedAt,omitempty"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+}
+
+type ReviewCaseRequest struct {
+	Priority ReviewPriority `json:"priority"`
+	Note     string         `json:"note"`
+}
+
+// ReviewWorkItem is a held transfer on the worklist. Breached is set once
+// its SLA ran out.
+type ReviewWorkItem struct {
+	AdminTransfer
+	Case     *ReviewCase `json:"case"`
+	Breached bool        `json:"breached"`
+}
+
+// ReviewSLA is how long the back office has to decide a held transfer of
+// each priority, counted from when it was held. A case is escalated once
+// less than Warning is left.
+type ReviewSLA struct {
+	Normal        time.Duration
+	High          time.Duration
+	Urgent        time.Duration
+	Warning       time.Duration
+	CheckInterval time.Duration
+
+	storage Storage
+	events  *EventBus
+}
+
+func reviewSLAFromEnv(store Storage, events *EventBus) *ReviewSLA {
+	return &ReviewSLA{
+		Normal:        getEnvDuration("REVIEW_SLA_NORMAL", 24*time.Hour),
+		High:          getEnvDuration("REVIEW_SLA_HIGH", 4*time.Hour),
+		Urgent:        getEnvDuration("REVIEW_SLA_URGENT", time.Hour),
+		Warning:       getEnvDuration("REVIEW_SLA_WARNING", 30*time.Minute),
+		CheckInterval: getEnvDuration("REVIEW_SLA_CHECK_INTERVAL", time.Minute),
+		storage:       store,
+		events:        events,
+	}
+}
+
+func (sla *ReviewSLA) deadline(heldAt time.Time, priority ReviewPriority) (time.Time, bool) {
+	switch priority {
+	case ReviewNormal:
+		return heldAt.Add(sla.Normal), true
+	case ReviewHigh:
+		return heldAt.Add(sla.High), true
+	case ReviewUrgent:
+		return heldAt.Add(sla.Urgent), true
+	}
+	return time.Time{}, false
+}
+
+// cases returns the case of every transfer in held, with a normal one for
+// those nobody triaged.
+func (sla *ReviewSLA) cases(held []*Transfer) (map[int]*ReviewCase, error) {
+	ids := make([]int, len(held))
+	for i, t := range held {
+		ids[i] = t.ID
+	}
+	cases, err := sla.storage.GetReviewCases(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range held {
+		if _, ok := cases[t.ID]; !ok {
+			due, _ := sla.deadline(t.UpdatedAt, ReviewNormal)
+			cases[t.ID] = &ReviewCase{TransferID: t.ID, Priority: ReviewNormal, DueAt: due, UpdatedAt: t.UpdatedAt}
+		}
+	}
+	return cases, nil
+}
+
+// Run escalates cases about to miss their SLA until the process exits.
+func (sla *ReviewSLA) Run() {
+	ticker := time.NewTicker(sla.CheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := sla.escalate(time.Now().UTC()); err != nil {
+			log.Println("Review SLA check failed: ", err)
+		}
+	}
+}
+
+// escalate publishes review.escalated once for every case with less than
+// Warning left at now, breached ones included.
+func (sla *ReviewSLA) escalate(now time.Time) error {
+	held, err := heldTransfers(sla.storage)
+	if err != nil {
+		return err
+	}
+	cases, err := sla.cases(held)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range held {
+		c := cases[t.ID]
+		if c.EscalatedAt != nil || now.Before(c.DueAt.Add(-sla.Warning)) {
+			continue
+		}
+		c.EscalatedAt = &now
+		if err := sla.storage.SaveReviewCase(c); err != nil {
+			return err
+		}
+		escalated := *c
+		sla.events.Publish(DomainEvent{Kind: EventReviewEscalated, AccountID: t.FromAccount, Transfer: t, Review: &escalated, OccurredAt: now})
+	}
+	return nil
+}
+
+// auditEscalation records escalations in the audit log, where the back
+// office picks them up.
+func auditEscalation(store Storage) EventHandler {
+	return func(e DomainEvent) error {
+		return store.CreateAuditEvent(NewAuditEvent("system", string(EventReviewEscalated), e.AccountID, map[string]string{
+			"transfer": e.Transfer.PublicID,
+			"priority": string(e.Review.Priority),
+			"dueAt":    e.Review.DueAt.Format(time.RFC3339),
+		}))
+	}
+}
+
+// HandleAdminReviewWorklist lists held transfers by breach risk: the
+// closest deadline first, breached ones on top, and the higher priority
+// first on the same deadline.
+func (s *APIServer) HandleAdminReviewWorklist(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	queue, err := s.reviewQueue()
+	if err != nil {
+		return err
+	}
+	held := make([]*Transfer, len(queue))
+	for i, item := range queue {
+		held[i] = item.Transfer
+	}
+	cases, err := s.reviewSLA.cases(held)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	worklist := make([]ReviewWorkItem, len(queue))
+	for i, item := range queue {
+		c := cases[item.ID]
+		worklist[i] = ReviewWorkItem{AdminTransfer: item, Case: c, Breached: !now.Before(c.DueAt)}
+	}
+	rank := map[ReviewPriority]int{ReviewUrgent: 0, ReviewHigh: 1, ReviewNormal: 2}
+	sort.SliceStable(worklist, func(i, j int) bool {
+		a, b := worklist[i].Case, worklist[j].Case
+		if !a.DueAt.Equal(b.DueAt) {
+			return a.DueAt.Before(b.DueAt)
+		}
+		return rank[a.Priority] < rank[b.Priority]
+	})
+
+	return writeJSON(w, http.StatusOK, worklist)
+}
+
+// HandleAdminReviewCase sets the priority and note of a held transfer. The
+// deadline moves with the priority, still counted from when the transfer
+// was held; a case already escalated isn't escalated again.
+func (s *APIServer) HandleAdminReviewCase(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPut {
+		return methodNotAllowed
+	}
+
+	req := new(ReviewCaseRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	t, err := s.storage.GetTransferByPublicID(mux.Vars(r)["transferID"])
+	if err != nil {
+		return err
+	}
+	if t.Status != TransferHeld {
+		return ErrStateConflict
+	}
+
+	if req.Priority == "" {
+		req.Priority = ReviewNormal
+	}
+	due, ok := s.reviewSLA.deadline(t.UpdatedAt, req.Priority)
+	if !ok {
+		return ApiError{Err: "unknown priority: " + string(req.Priority), Status: http.StatusBadRequest}
+	}
+
+	cases, err := s.reviewSLA.cases([]*Transfer{t})
+	if err != nil {
+		return err
+	}
+	c := cases[t.ID]
+	c.Priority, c.Note, c.DueAt, c.UpdatedAt = req.Priority, req.Note, due, time.Now().UTC()
+	if err := s.storage.SaveReviewCase(c); err != nil {
+		return err
+	}
+
+	event := NewAuditEvent(adminActor(r), "review.triaged", t.FromAccount, map[string]string{
+		"transfer": t.PublicID,
+		"priority": string(c.Priority),
+	})
+	if err := s.storage.CreateAuditEvent(event); err != nil {
+		log.Println("Failed to audit review triage: ", err)
+	}
+
+	return writeJSON(w, http.StatusOK, c)
+}
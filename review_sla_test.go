@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func holdTestTransfer(t *testing.T, store Storage, from, to *Account, amount int64) *Transfer {
+	transfer := NewTransfer(from.ID, to.ID, NewMoney(amount, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(transfer))
+	assert.Nil(t, store.HoldTransfer(transfer))
+	return transfer
+}
+
+func TestReviewSLAEscalatesOnce(t *testing.T) {
+	store := NewMemoryStorage()
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+	normal := holdTestTransfer(t, store, from, to, 100)
+	urgent := holdTestTransfer(t, store, from, to, 200)
+
+	bus := &EventBus{Shards: 1, QueueSize: 10}
+	var mu sync.Mutex
+	var escalated []string
+	bus.Subscribe("recorder", func(e DomainEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		escalated = append(escalated, e.Transfer.PublicID)
+		return nil
+	}, EventReviewEscalated)
+	bus.Start()
+
+	sla := &ReviewSLA{Normal: 24 * time.Hour, High: 4 * time.Hour, Urgent: time.Hour, Warning: 30 * time.Minute, storage: store, events: bus}
+	due, _ := sla.deadline(urgent.UpdatedAt, ReviewUrgent)
+	assert.Nil(t, store.SaveReviewCase(&ReviewCase{TransferID: urgent.ID, Priority: ReviewUrgent, DueAt: due}))
+
+	now := urgent.UpdatedAt.Add(45 * time.Minute)
+	assert.Nil(t, sla.escalate(now))
+	assert.Nil(t, sla.escalate(now.Add(time.Minute)))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(escalated) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, urgent.PublicID, escalated[0])
+
+	cases, err := store.GetReviewCases([]int{normal.ID, urgent.ID})
+	assert.Nil(t, err)
+	assert.NotNil(t, cases[urgent.ID].EscalatedAt)
+	assert.NotContains(t, cases, normal.ID)
+}
+
+func TestReviewWorklistSortsByBreachRisk(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+	older := holdTestTransfer(t, store, from, to, 100)
+	newer := holdTestTransfer(t, store, from, to, 200)
+
+	admin := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-admin-token", "test-admin")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := admin(http.MethodPut, "/admin/reviews/"+newer.PublicID+"/case", `{"priority":"high","note":"Customer called twice"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, admin(http.MethodPut, "/admin/reviews/"+older.PublicID+"/case", `{"priority":"whenever"}`).Code)
+
+	var worklist []ReviewWorkItem
+	rec = admin(http.MethodGet, "/admin/reviews/worklist", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &worklist))
+	if assert.Len(t, worklist, 2) {
+		assert.Equal(t, newer.PublicID, worklist[0].PublicID)
+		assert.Equal(t, ReviewHigh, worklist[0].Case.Priority)
+		assert.Equal(t, "Customer called twice", worklist[0].Case.Note)
+		assert.Equal(t, ReviewNormal, worklist[1].Case.Priority)
+		assert.False(t, worklist[1].Breached)
+	}
+}
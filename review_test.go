@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlaggedTransfersWaitForReview(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+	t.Setenv("GEOIP_RANGES", "81.2.69.0/24=GB,216.160.83.0/24=US")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+	token, err := createJWT(from)
+	assert.Nil(t, err)
+
+	login := NewLoginAttempt(from.Number, &http.Request{RemoteAddr: "81.2.69.160:5000", Header: http.Header{}})
+	login.AccountID, login.Success = &from.ID, true
+	assert.Nil(t, store.CreateLoginAttempt(login))
+
+	transfer := func(amount string) TransferResource {
+		req := httptest.NewRequest(http.MethodPost, "/transfer",
+			strings.NewReader(`{"toAccount":`+strconv.Itoa(to.ID)+`,"amount":{"amount":`+amount+`}}`))
+		req.RemoteAddr = "216.160.83.56:4000"
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+
+		var resource TransferResource
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resource))
+		return resource
+	}
+	admin := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("x-admin-token", "test-admin")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	approved := transfer("300")
+	declined := transfer("200")
+	assert.Equal(t, TransferHeld, approved.Status)
+	assert.Equal(t, int64(1000), balanceOf(t, store, from.ID))
+
+	var queue []AdminTransfer
+	rec := admin(http.MethodGet, "/admin/reviews")
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &queue))
+	if assert.Len(t, queue, 2) {
+		assert.Equal(t, approved.PublicID, queue[0].PublicID)
+		assert.True(t, queue[0].Origin.GeoMismatch)
+	}
+
+	rec = admin(http.MethodPost, "/admin/reviews/"+approved.PublicID+"/approve")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"settled"`)
+	assert.Equal(t, int64(700), balanceOf(t, store, from.ID))
+	assert.Equal(t, int64(300), balanceOf(t, store, to.ID))
+
+	rec = admin(http.MethodPost, "/admin/reviews/"+declined.PublicID+"/decline")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"failed"`)
+	assert.Equal(t, int64(700), balanceOf(t, store, from.ID))
+
+	assert.Equal(t, http.StatusConflict, admin(http.MethodPost, "/admin/reviews/"+declined.PublicID+"/approve").Code)
+	assert.Equal(t, "[]\n", admin(http.MethodGet, "/admin/reviews").Body.String())
+
+	events, err := store.GetAuditEvents(from.ID, 10)
+	assert.Nil(t, err)
+	actions := []string{}
+	for _, e := range events {
+		actions = append(actions, e.Action)
+	}
+	assert.Contains(t, actions, "transfer.review_approved")
+	assert.Contains(t, actions, "transfer.review_declined")
+}
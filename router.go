@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxRouteVars bounds the path variables of a route, so matching can
+// collect them without allocating.
+const maxRouteVars = 8
+
+// radixRouter serves the routes registered on a mux.Router from a tree of
+// path segments. Matching walks the tree once instead of trying every
+// route's regexp in turn, and allocates nothing; only the variables of a
+// matched route are copied into a map for mux.Vars.
+//
+// Static segments take precedence over variables, so /transfer/preview
+// wins over /transfer/{transferID} regardless of registration order.
+// The routes here are registered in an order where mux agrees.
+type radixRouter struct {
+	root     *radixNode
+	prefixes []*radixRoute
+}
+
+type radixNode struct {
+	static map[string]*radixNode
+	param  *radixNode
+	route  *radixRoute
+}
+
+type radixRoute struct {
+	template string
+	vars     []string
+	handler  http.Handler
+}
+
+type routeTemplateKey struct{}
+
+// newRadixRouter builds a radixRouter from the routes of router, wrapping
+// each in middlewares the way router.Use does.
+func newRadixRouter(router *mux.Router, middlewares []mux.MiddlewareFunc) (*radixRouter, error) {
+	rr := &radixRouter{root: &radixNode{}}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		regexp, err := route.GetPathRegexp()
+		if err != nil {
+			return err
+		}
+		handler := route.GetHandler()
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			handler = middlewares[i].Middleware(handler)
+		}
+
+		if !strings.HasSuffix(regexp, "$") {
+			rr.prefixes = append(rr.prefixes, &radixRoute{template: template, handler: handler})
+			return nil
+		}
+		return rr.add(template, handler)
+	})
+	return rr, err
+}
+
+func (rr *radixRouter) add(template string, handler http.Handler) error {
+	route := &radixRoute{template: template, handler: handler}
+	n := rr.root
+	for _, segment := range strings.Split(strings.TrimPrefix(template, "/"), "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			name := segment[1 : len(segment)-1]
+			if strings.Contains(name, ":") {
+				return fmt.Errorf("route %s: patterns in path variables are not supported", template)
+			}
+			route.vars = append(route.vars, name)
+			if n.param == nil {
+				n.param = &radixNode{}
+			}
+			n = n.param
+			continue
+		}
+		if n.static == nil {
+			n.static = map[string]*radixNode{}
+		}
+		child, ok := n.static[segment]
+		if !ok {
+			child = &radixNode{}
+			n.static[segment] = child
+		}
+		n = child
+	}
+	if len(route.vars) > maxRouteVars {
+		return fmt.Errorf("route %s has more than %d path variables", template, maxRouteVars)
+	}
+	if n.route == nil {
+		n.route = route
+	}
+	return nil
+}
+
+// match finds the route for the rest of path below n, collecting the
+// values of its variables in vals.
+func (n *radixNode) match(path string, vals *[maxRouteVars]string, depth int) *radixRoute {
+	if path == "" {
+		return n.route
+	}
+	segment, rest := path, ""
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		segment, rest = path[:i], path[i+1:]
+		if rest == "" {
+			// A trailing slash doesn't match, like mux without StrictSlash.
+			return nil
+		}
+	}
+
+	if child, ok := n.static[segment]; ok {
+		if route := child.match(rest, vals, depth); route != nil {
+			return route
+		}
+	}
+	if n.param != nil && segment != "" && depth < maxRouteVars {
+		vals[depth] = segment
+		return n.param.match(rest, vals, depth+1)
+	}
+	return nil
+}
+
+func (rr *radixRouter) lookup(path string, vals *[maxRouteVars]string) *radixRoute {
+	if strings.HasPrefix(path, "/") {
+		if route := rr.root.match(path[1:], vals, 0); route != nil {
+			return route
+		}
+	}
+	for _, route := range rr.prefixes {
+		if strings.HasPrefix(path, route.template) {
+			return route
+		}
+	}
+	return nil
+}
+
+func (rr *radixRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var vals [maxRouteVars]string
+	route := rr.lookup(r.URL.Path, &vals)
+	if route == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), routeTemplateKey{}, route.template)
+	r = r.WithContext(ctx)
+	if len(route.vars) > 0 {
+		vars := make(map[string]string, len(route.vars))
+		for i, name := range route.vars {
+			vars[name] = vals[i]
+		}
+		r = mux.SetURLVars(r, vars)
+	}
+	route.handler.ServeHTTP(w, r)
+}
+
+// currentRouteTemplate returns the path template of the route serving r,
+// whichever router matched it.
+func currentRouteTemplate(r *http.Request) (string, bool) {
+	if template, ok := r.Context().Value(routeTemplateKey{}).(string); ok {
+		return template, true
+	}
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template, true
+		}
+	}
+	return "", false
+}
+
+func routeTemplate(r *http.Request) string {
+	if template, ok := currentRouteTemplate(r); ok {
+		return template
+	}
+	return r.URL.Path
+}
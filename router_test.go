@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var routeVar = regexp.MustCompile(`\{[^}]+\}`)
+
+func TestRadixRouterMatchesMux(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("APP_ENV", "sandbox")
+
+	router := NewAPIServer(":0", NewMemoryStorage()).Router()
+	radix, err := newRadixRouter(router, nil)
+	assert.Nil(t, err)
+
+	err = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		path := routeVar.ReplaceAllString(template, "42")
+
+		var match mux.RouteMatch
+		assert.True(t, router.Match(httptest.NewRequest(http.MethodGet, path, nil), &match), path)
+		muxTemplate, _ := match.Route.GetPathTemplate()
+
+		var vals [maxRouteVars]string
+		matched := radix.lookup(path, &vals)
+		if assert.NotNil(t, matched, path) {
+			assert.Equal(t, muxTemplate, matched.template, path)
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	var vals [maxRouteVars]string
+	assert.Nil(t, radix.lookup("/account/42/", &vals), "trailing slashes don't match")
+	assert.Nil(t, radix.lookup("/account//balance", &vals))
+	assert.Nil(t, radix.lookup("/nope", &vals))
+	assert.Equal(t, "/admin/ui", radix.lookup("/admin/ui/app.js", &vals).template)
+}
+
+func TestRadixRouterServesAPI(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ROUTER", "radix")
+
+	store := NewMemoryStorage()
+	handler, err := NewAPIServer(":0", store).Handler()
+	assert.Nil(t, err)
+	assert.IsType(t, &radixRouter{}, handler)
+
+	account := createTestAccount(t, store, 1234)
+	token, err := createJWT(account)
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/account/"+strconv.Itoa(account.ID)+"/balance", nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"amount":1234`)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nope", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	t.Setenv("ROUTER", "trie")
+	_, err = NewAPIServer(":0", store).Handler()
+	assert.NotNil(t, err)
+}
+
+// benchmarkRouters registers the server's routes with a handler that only
+// reads the path variables, so the benchmarks measure routing alone.
+func benchmarkRouters(b *testing.B) map[string]http.Handler {
+	b.Setenv("JWT_SECRET", "test-secret")
+
+	routes := mux.NewRouter()
+	vars := func(w http.ResponseWriter, r *http.Request) { _ = mux.Vars(r)["id"] }
+	err := NewAPIServer(":0", NewMemoryStorage()).Router().Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		routes.HandleFunc(template, vars)
+		return err
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	radix, err := newRadixRouter(routes, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return map[string]http.Handler{"mux": routes, "radix": radix}
+}
+
+func BenchmarkRouters(b *testing.B) {
+	paths := map[string]string{
+		"static":   "/transfer",
+		"one-var":  "/account/42/balance",
+		"two-vars": "/account/42/bill-payments/7/pause",
+		"last":     "/metrics",
+	}
+	for name, router := range benchmarkRouters(b) {
+		for kind, path := range paths {
+			b.Run(name+"/"+kind, func(b *testing.B) {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				w := httptest.NewRecorder()
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					router.ServeHTTP(w, req)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkRadixLookup(b *testing.B) {
+	radix := benchmarkRouters(b)["radix"].(*radixRouter)
+	var vals [maxRouteVars]string
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if radix.lookup("/account/42/bill-payments/7/pause", &vals) == nil {
+			b.Fatal("no match")
+		}
+	}
+}
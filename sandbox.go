@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type SandboxFailure string
+
+const (
+	SandboxInsufficientFunds SandboxFailure = "insufficient_funds"
+	SandboxTimeout           SandboxFailure = "timeout"
+)
+
+// Sandbox holds the behaviour of APP_ENV=sandbox deployments, where client
+// developers integrate against fake money: accounts are opened with a
+// balance, async transfers settle after a delay and failures can be forced
+// on demand. A nil *Sandbox means the server runs for real.
+type Sandbox struct {
+	OpeningBalance int64
+	SettleDelay    time.Duration
+	Timeout        time.Duration
+
+	mu       sync.Mutex
+	failures map[int][]SandboxFailure
+}
+
+type ForceFailureRequest struct {
+	Failure SandboxFailure `json:"failure"`
+	Count   int            `json:"count"`
+}
+
+func sandboxFromEnv() *Sandbox {
+	if appEnv() != "sandbox" {
+		return nil
+	}
+
+	return &Sandbox{
+		OpeningBalance: getEnvInt("SANDBOX_OPENING_BALANCE", 1_000_00),
+		SettleDelay:    getEnvDuration("SANDBOX_SETTLE_DELAY", 5*time.Second),
+		Timeout:        getEnvDuration("SANDBOX_TIMEOUT", 30*time.Second),
+		failures:       map[int][]SandboxFailure{},
+	}
+}
+
+// openingBalance returns what a new account starts with.
+func (sb *Sandbox) openingBalance() int64 {
+	if sb == nil {
+		return 0
+	}
+	return sb.OpeningBalance
+}
+
+func (sb *Sandbox) settleDelay() time.Duration {
+	if sb == nil {
+		return 0
+	}
+	return sb.SettleDelay
+}
+
+// nextFailure pops the failure forced for the account's next transfer, if
+// any.
+func (sb *Sandbox) nextFailure(accountID int) SandboxFailure {
+	if sb == nil {
+		return ""
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	queue := sb.failures[accountID]
+	if len(queue) == 0 {
+		return ""
+	}
+	sb.failures[accountID] = queue[1:]
+	return queue[0]
+}
+
+// HandleForceFailure makes the next transfers of the account fail in the
+// requested way.
+func (s *APIServer) HandleForceFailure(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+	// The route is only registered in sandbox mode, but the handler must
+	// not rely on that.
+	if s.sandbox == nil {
+		return ApiError{Err: "sandbox mode is not enabled", Status: http.StatusNotFound}
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	req := new(ForceFailureRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	if req.Failure != SandboxInsufficientFunds && req.Failure != SandboxTimeout {
+		return ApiError{Err: "unknown failure: " + string(req.Failure), Status: http.StatusBadRequest}
+	}
+	if req.Count <= 0 {
+		req.Count = 1
+	}
+
+	s.sandbox.mu.Lock()
+	for i := 0; i < req.Count; i++ {
+		s.sandbox.failures[id] = append(s.sandbox.failures[id], req.Failure)
+	}
+	queued := len(s.sandbox.failures[id])
+	s.sandbox.mu.Unlock()
+
+	return writeJSON(w, http.StatusOK, map[string]int{"queued": queued})
+}
+
+// simulateFailure applies a forced sandbox failure to a freshly created
+// transfer. It returns false when no failure was forced and the transfer
+// should go ahead normally.
+func (s *APIServer) simulateFailure(w http.ResponseWriter, r *http.Request, transfer *Transfer) (bool, error) {
+	switch s.sandbox.nextFailure(transfer.FromAccount) {
+	case SandboxInsufficientFunds:
+		if err := s.storage.FailTransfer(transfer, "insufficient funds"); err != nil {
+			return true, err
+		}
+		return true, writeJSON(w, http.StatusOK, newTransferResource(transfer))
+
+	case SandboxTimeout:
+		// Like a real timeout the transfer still goes through, the client
+		// just never hears about it.
+		s.transfers.Wake()
+		select {
+		case <-time.After(s.sandbox.Timeout):
+		case <-r.Context().Done():
+		}
+		return true, ApiError{Err: "upstream timeout", Status: http.StatusGatewayTimeout}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sandboxTest struct {
+	t         *testing.T
+	store     *MemoryStorage
+	server    *APIServer
+	router    http.Handler
+	sender    *Account
+	recipient *Account
+	token     string
+}
+
+func newSandboxTest(t *testing.T) *sandboxTest {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("APP_ENV", "sandbox")
+	t.Setenv("SANDBOX_SETTLE_DELAY", "0s")
+	t.Setenv("SANDBOX_TIMEOUT", "10ms")
+
+	store := NewMemoryStorage()
+	server := NewAPIServer(":0", store)
+	sender := createTestAccount(t, store, 1000)
+	recipient := createTestAccount(t, store, 0)
+	token, err := createJWT(sender)
+	assert.Nil(t, err)
+	return &sandboxTest{t: t, store: store, server: server, router: server.Router(), sender: sender, recipient: recipient, token: token}
+}
+
+func (s *sandboxTest) do(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("x-jwt-token", s.token)
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *sandboxTest) force(body string) *httptest.ResponseRecorder {
+	return s.do(http.MethodPost, "/sandbox/account/"+s.sender.PublicID+"/failures", body)
+}
+
+func (s *sandboxTest) transfer(amount string) *httptest.ResponseRecorder {
+	return s.do(http.MethodPost, "/transfer", `{"toAccountId":"`+s.recipient.PublicID+`","amount":{"amount":`+amount+`}}`)
+}
+
+func TestSandboxOpeningBalance(t *testing.T) {
+	s := newSandboxTest(t)
+	t.Setenv("SANDBOX_OPENING_BALANCE", "5000")
+	router := NewAPIServer(":0", s.store).Router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/account", strings.NewReader(`{"firstName":"Sandy","lastName":"Box","password":"pw"}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var account AccountDetails
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &account))
+	assert.Equal(t, int64(5000), account.Balance.Amount)
+}
+
+func TestSandboxForcedInsufficientFunds(t *testing.T) {
+	s := newSandboxTest(t)
+
+	rec := s.force(`{"failure":"insufficient_funds","count":2}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"queued":2}`, rec.Body.String())
+
+	for _, amount := range []string{"100", "200"} {
+		rec = s.transfer(amount)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var resource TransferResource
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resource))
+		assert.Equal(t, TransferFailed, resource.Status)
+		assert.Equal(t, "insufficient funds", resource.FailureReason)
+	}
+	assert.Equal(t, int64(1000), balanceOf(t, s.store, s.sender.ID))
+
+	// Once the queue is drained transfers go through again.
+	assert.Equal(t, http.StatusOK, s.transfer("300").Code)
+	assert.Equal(t, int64(300), balanceOf(t, s.store, s.recipient.ID))
+}
+
+func TestSandboxForcedTimeout(t *testing.T) {
+	s := newSandboxTest(t)
+
+	assert.Equal(t, http.StatusOK, s.force(`{"failure":"timeout"}`).Code)
+	assert.Equal(t, http.StatusGatewayTimeout, s.transfer("100").Code)
+
+	// The client never heard back, but the transfer still settles.
+	s.server.transfers.processAll()
+	assert.Equal(t, int64(100), balanceOf(t, s.store, s.recipient.ID))
+}
+
+func TestSandboxRejectsUnknownFailure(t *testing.T) {
+	s := newSandboxTest(t)
+	assert.Equal(t, http.StatusBadRequest, s.force(`{"failure":"meteor"}`).Code)
+}
+
+func TestForceFailureOutsideSandbox(t *testing.T) {
+	t.Setenv("APP_ENV", "development")
+
+	server := NewAPIServer(":0", NewMemoryStorage())
+	req := httptest.NewRequest(http.MethodPost, "/sandbox/account/1/failures", strings.NewReader(`{"failure":"timeout"}`))
+	err := server.HandleForceFailure(httptest.NewRecorder(), req)
+
+	var apiErr ApiError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+}
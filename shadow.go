@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+)
+
+// shadowStats exports how many transfers the shadow engine decided, how
+// many of its decisions disagreed with the executed outcome, and how often
+// it couldn't decide at all. A mismatch rate near zero over a few days of
+// traffic is what makes switching engines safe.
+var shadowStats = expvar.NewMap("transfer_shadow")
+
+// TransferDecision is what an engine decides for a transfer: settle it,
+// leaving the balances at From and To, or fail it for FailureReason.
+type TransferDecision struct {
+	Status        TransferStatus
+	FailureReason string
+	From, To      Money
+}
+
+// LedgerEngine decides transfers from the balance journal instead of the
+// balance cached on the account rows, which is what the ledger engine
+// will do once the journal is the source of truth. Which accounts exist
+// is still read from the accounts.
+type LedgerEngine struct {
+	storage Storage
+}
+
+func (e LedgerEngine) Decide(t *Transfer, now time.Time) (*TransferDecision, error) {
+	if _, err := e.storage.GetAccountByID(t.FromAccount); err != nil {
+		return &TransferDecision{Status: TransferFailed, FailureReason: "account not found"}, nil
+	}
+	if _, err := e.storage.GetAccountByID(t.ToAccount); err != nil {
+		return &TransferDecision{Status: TransferFailed, FailureReason: "account not found"}, nil
+	}
+
+	from, err := e.storage.GetBalanceAt(t.FromAccount, now)
+	if err != nil {
+		return nil, err
+	}
+	to, err := e.storage.GetBalanceAt(t.ToAccount, now)
+	if err != nil {
+		return nil, err
+	}
+
+	newFrom, newTo, reason := applyTransfer(from.Balance, to.Balance, t.Amount, t.Credited())
+	if reason != "" {
+		return &TransferDecision{Status: TransferFailed, FailureReason: reason}, nil
+	}
+	return &TransferDecision{Status: TransferSettled, From: newFrom, To: newTo}, nil
+}
+
+// ShadowStorage runs the LedgerEngine next to every transfer execution
+// without committing its decisions, and compares them with what the
+// current engine committed. Postings racing a transfer, such as a cash
+// deposit between the two reads, show up as balance mismatches too, so
+// judge the mismatch rate rather than single events.
+type ShadowStorage struct {
+	Storage
+	engine LedgerEngine
+}
+
+func NewShadowStorage(store Storage) *ShadowStorage {
+	return &ShadowStorage{Storage: store, engine: LedgerEngine{storage: store}}
+}
+
+func (s *ShadowStorage) WithContext(ctx context.Context) Storage {
+	return NewShadowStorage(storageWithContext(s.Storage, ctx))
+}
+
+func (s *ShadowStorage) ExecuteTransfer(t *Transfer) error {
+	if !t.IsPending() {
+		return s.Storage.ExecuteTransfer(t)
+	}
+
+	shadow, shadowErr := s.engine.Decide(t, time.Now().UTC())
+	if err := s.Storage.ExecuteTransfer(t); err != nil {
+		return err
+	}
+	if t.IsPending() {
+		return nil
+	}
+	if shadowErr != nil {
+		shadowStats.Add("errors", 1)
+		log.Printf("Shadow engine failed on transfer %d: %v\n", t.ID, shadowErr)
+		return nil
+	}
+
+	shadowStats.Add("compared", 1)
+	if mismatch := s.compare(t, shadow); mismatch != "" {
+		shadowStats.Add("mismatched", 1)
+		shadowStats.Add("mismatched."+mismatch, 1)
+	}
+	return nil
+}
+
+// compare returns how the shadow decision differs from the executed
+// transfer t, "status" or "balance", and logs the difference. It returns
+// "" when they agree.
+func (s *ShadowStorage) compare(t *Transfer, shadow *TransferDecision) string {
+	if shadow.Status != t.Status || shadow.FailureReason != t.FailureReason {
+		log.Printf("Shadow engine mismatch on transfer %d: executed %s %q, shadow %s %q\n",
+			t.ID, t.Status, t.FailureReason, shadow.Status, shadow.FailureReason)
+		return "status"
+	}
+	if t.Status != TransferSettled {
+		return ""
+	}
+
+	from, fromErr := s.Storage.GetAccountByID(t.FromAccount)
+	to, toErr := s.Storage.GetAccountByID(t.ToAccount)
+	if fromErr != nil || toErr != nil {
+		return ""
+	}
+	if from.Balance != shadow.From || to.Balance != shadow.To {
+		log.Printf("Shadow engine mismatch on transfer %d: executed balances %d/%d, shadow %d/%d\n",
+			t.ID, from.Balance.Amount, to.Balance.Amount, shadow.From.Amount, shadow.To.Amount)
+		return "balance"
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShadowEngineComparesDecisions(t *testing.T) {
+	store := NewMemoryStorage()
+	shadow := NewShadowStorage(store)
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+
+	execute := func(amount int64) (*Transfer, string) {
+		transfer := NewTransfer(from.ID, to.ID, NewMoney(amount, defaultCurrency))
+		assert.Nil(t, store.CreateTransfer(transfer))
+		decision, err := shadow.engine.Decide(transfer, time.Now().UTC())
+		assert.Nil(t, err)
+		assert.Nil(t, store.ExecuteTransfer(transfer))
+		return transfer, shadow.compare(transfer, decision)
+	}
+
+	transfer, mismatch := execute(300)
+	assert.Equal(t, TransferSettled, transfer.Status)
+	assert.Empty(t, mismatch)
+
+	transfer, mismatch = execute(5000)
+	assert.Equal(t, TransferFailed, transfer.Status)
+	assert.Empty(t, mismatch)
+
+	// A balance changed behind the journal's back makes the engines
+	// disagree.
+	store.accounts[from.ID].Balance.Amount = 200
+	transfer, mismatch = execute(500)
+	assert.Equal(t, TransferFailed, transfer.Status)
+	assert.Equal(t, "status", mismatch)
+
+	store.accounts[from.ID].Balance.Amount = 600
+	_, mismatch = execute(100)
+	assert.Equal(t, "balance", mismatch)
+
+	// Executing through the wrapper commits the current engine's decision.
+	transfer = NewTransfer(from.ID, to.ID, NewMoney(100, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(transfer))
+	assert.Nil(t, shadow.ExecuteTransfer(transfer))
+	assert.Equal(t, TransferSettled, transfer.Status)
+	assert.Equal(t, int64(400), balanceOf(t, store, from.ID))
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sloStats exports, per route with a latency SLO, how many requests were
+// served, how many blew the budget and the burn rate over the last 5
+// minutes and hour. A burn rate of 1 spends the error budget exactly over
+// the SLO period; alert well above it.
+var sloStats = expvar.NewMap("latency_slo")
+
+// sloWindowMinutes is how far back burn rates can look.
+const sloWindowMinutes = 60
+
+// LatencyBudget measures requests against per-route latency SLOs: at least
+// Objective of a route's requests have to be served within its budget.
+// With ServerTiming set, responses carry a Server-Timing header breaking
+// the request down into auth, handler and serialization time.
+type LatencyBudget struct {
+	// Budgets maps a route template, such as "/account/{id}/activity",
+	// to its latency budget. Routes not listed aren't measured.
+	Budgets      map[string]time.Duration
+	Objective    float64
+	ServerTiming bool
+
+	mu      sync.Mutex
+	windows map[string]*[sloWindowMinutes]sloBucket
+}
+
+// sloBucket counts the requests of one minute.
+type sloBucket struct {
+	minute   int64
+	requests int64
+	slow     int64
+}
+
+// latencyBudgetFromEnv reads ROUTE_LATENCY_SLOS, a comma separated list of
+// route=budget pairs such as "/transfer=300ms,/account/{id}/activity=1s".
+func latencyBudgetFromEnv() *LatencyBudget {
+	budgets := map[string]time.Duration{}
+	for _, pair := range strings.Split(getEnv("ROUTE_LATENCY_SLOS", ""), ",") {
+		route, budget, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if d, err := time.ParseDuration(budget); ok && err == nil && d > 0 {
+			budgets[route] = d
+		}
+	}
+
+	return NewLatencyBudget(budgets, getEnvFloat("SLO_OBJECTIVE", 0.99), getEnvBool("SERVER_TIMING", false))
+}
+
+func NewLatencyBudget(budgets map[string]time.Duration, objective float64, serverTiming bool) *LatencyBudget {
+	b := &LatencyBudget{
+		Budgets:      budgets,
+		Objective:    objective,
+		ServerTiming: serverTiming,
+		windows:      map[string]*[sloWindowMinutes]sloBucket{},
+	}
+
+	for route := range budgets {
+		route := route
+		sloStats.Set(route+".burn_rate_5m", expvar.Func(func() any { return b.BurnRate(route, 5*time.Minute, time.Now()) }))
+		sloStats.Set(route+".burn_rate_1h", expvar.Func(func() any { return b.BurnRate(route, time.Hour, time.Now()) }))
+	}
+	return b
+}
+
+func (b *LatencyBudget) record(route string, took time.Duration, now time.Time) {
+	slow := took > b.Budgets[route]
+	sloStats.Add(route+".requests", 1)
+	if slow {
+		sloStats.Add(route+".slow", 1)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	window, ok := b.windows[route]
+	if !ok {
+		window = new([sloWindowMinutes]sloBucket)
+		b.windows[route] = window
+	}
+	minute := now.Unix() / 60
+	bucket := &window[minute%sloWindowMinutes]
+	if bucket.minute != minute {
+		*bucket = sloBucket{minute: minute}
+	}
+	bucket.requests++
+	if slow {
+		bucket.slow++
+	}
+}
+
+// BurnRate is how fast route spent its error budget over the window
+// ending at now, which can't be longer than an hour.
+func (b *LatencyBudget) BurnRate(route string, window time.Duration, now time.Time) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	buckets, ok := b.windows[route]
+	if !ok || b.Objective >= 1 {
+		return 0
+	}
+
+	since := now.Add(-window).Unix() / 60
+	var requests, slow int64
+	for _, bucket := range buckets {
+		if bucket.minute > since {
+			requests += bucket.requests
+			slow += bucket.slow
+		}
+	}
+	if requests == 0 {
+		return 0
+	}
+	return float64(slow) / float64(requests) / (1 - b.Objective)
+}
+
+// Middleware times requests to routes with an SLO, and all of them when
+// ServerTiming is set. It runs first so queueing in the limiters counts.
+func (b *LatencyBudget) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, _ := currentRouteTemplate(r)
+		_, measured := b.Budgets[route]
+		if !measured && !b.ServerTiming {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		if b.ServerTiming {
+			w = &timingWriter{ResponseWriter: w, timing: &serverTiming{start: start}}
+		}
+		next.ServeHTTP(w, r)
+
+		if measured {
+			b.record(route, time.Since(start), time.Now())
+		}
+	})
+}
+
+// serverTiming collects where the time of a request went.
+type serverTiming struct {
+	start     time.Time
+	auth      time.Duration
+	serialize time.Duration
+}
+
+type timingWriter struct {
+	http.ResponseWriter
+	timing      *serverTiming
+	wroteHeader bool
+}
+
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WriteHeader adds the Server-Timing header. Storage calls aren't timed on
+// their own, as Storage takes no request context, so they count towards
+// auth or handler time depending on who made them.
+func (w *timingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		t := w.timing
+		total := time.Since(t.start)
+		w.Header().Set("Server-Timing", fmt.Sprintf("auth;dur=%.3f, handler;dur=%.3f, serialize;dur=%.3f, total;dur=%.3f",
+			ms(t.auth), ms(total-t.auth-t.serialize), ms(t.serialize), ms(total)))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streamed responses streaming.
+func (w *timingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// serverTimingOf returns the timing of the request w answers, or nil when
+// Server-Timing is off. Writers wrapped by other middleware are unwrapped.
+func serverTimingOf(w http.ResponseWriter) *serverTiming {
+	for {
+		switch tw := w.(type) {
+		case *timingWriter:
+			return tw.timing
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = tw.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// timeAuth adds the time since start to the auth time of the request.
+func timeAuth(w http.ResponseWriter, start time.Time) {
+	if t := serverTimingOf(w); t != nil {
+		t.auth += time.Since(start)
+	}
+}
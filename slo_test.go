@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyBudgetBurnRate(t *testing.T) {
+	b := NewLatencyBudget(map[string]time.Duration{"/transfer": 100 * time.Millisecond}, 0.9, false)
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	// An hour ago everything was slow; in the last 5 minutes, 1 in 4.
+	for i := 0; i < 4; i++ {
+		b.record("/transfer", time.Second, now.Add(-50*time.Minute))
+	}
+	b.record("/transfer", time.Second, now.Add(-time.Minute))
+	for i := 0; i < 3; i++ {
+		b.record("/transfer", 10*time.Millisecond, now.Add(-time.Minute))
+	}
+
+	assert.InDelta(t, 2.5, b.BurnRate("/transfer", 5*time.Minute, now), 0.001)
+	assert.InDelta(t, 6.25, b.BurnRate("/transfer", time.Hour, now), 0.001)
+	assert.Equal(t, 0.0, b.BurnRate("/account", time.Hour, now))
+
+	// Buckets older than the window are reused, not summed.
+	b.record("/transfer", 10*time.Millisecond, now.Add(10*time.Minute))
+	assert.InDelta(t, 1.0/5/0.1, b.BurnRate("/transfer", time.Hour, now.Add(10*time.Minute)), 0.001)
+}
+
+func TestServerTimingHeader(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("SERVER_TIMING", "true")
+	t.Setenv("ROUTE_LATENCY_SLOS", "/account/{id}=1ns")
+
+	store := NewMemoryStorage()
+	server := NewAPIServer(":0", store)
+	router := server.Router()
+	acc := createTestAccount(t, store, 0)
+	token, err := createJWT(acc)
+	assert.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/account/"+strconv.Itoa(acc.ID), nil)
+	req.Header.Set("x-jwt-token", token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Regexp(t, `^auth;dur=[0-9.]+, handler;dur=[0-9.]+, serialize;dur=[0-9.]+, total;dur=[0-9.]+$`, rec.Header().Get("Server-Timing"))
+	assert.Greater(t, server.latency.BurnRate("/account/{id}", time.Hour, time.Now()), 0.0)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type RegenerationStatus string
+
+const (
+	RegenerationRunning   RegenerationStatus = "running"
+	RegenerationCompleted RegenerationStatus = "completed"
+)
+
+// statementOutcome is what regenerating one statement did.
+type statementOutcome int
+
+const (
+	statementUnchanged statementOutcome = iota
+	statementRegenerated
+	statementCreated
+)
+
+// maxRegenerationPeriods keeps one regeneration from re-rendering the
+// whole history of the bank.
+const maxRegenerationPeriods = 24
+
+// StatementRegeneration re-renders the statements of the months From to To,
+// e.g. after a reconciliation corrected some transfers. Accounts are done
+// in id order and LastAccountID records how far it got, so a restart
+// resumes where it stopped.
+//
+// Regenerating overwrites a statement in place, keeping its id and any
+// links to it. A statement rendering to the same bytes is left alone, so
+// running the same regeneration twice changes nothing.
+type StatementRegeneration struct {
+	ID            int                `json:"id"`
+	PublicID      string             `json:"publicId"`
+	From          string             `json:"from"`
+	To            string             `json:"to"`
+	AccountIDs    []int              `json:"accountIds,omitempty"`
+	RequestedBy   string             `json:"requestedBy"`
+	Status        RegenerationStatus `json:"status"`
+	LastAccountID int                `json:"lastAccountId"`
+	// Accounts counts the accounts processed, and Regenerated, Created and
+	// Unchanged the statements by what happened to them.
+	Accounts    int        `json:"accounts"`
+	Regenerated int        `json:"regenerated"`
+	Created     int        `json:"created"`
+	Unchanged   int        `json:"unchanged"`
+	Failed      int        `json:"failed"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// StatementRegenerationRequest selects the statements to regenerate: the
+// months from From to To (YYYY-MM) of AccountIDs, or of every account when
+// there are none.
+type StatementRegenerationRequest struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	AccountIDs []int  `json:"accountIds"`
+}
+
+// statementRenderInterval spaces out renders so a large regeneration
+// doesn't starve the monthly run and receipts of the PDF renderer.
+func statementRenderInterval() time.Duration {
+	return getEnvDuration("STATEMENT_RENDER_INTERVAL", 100*time.Millisecond)
+}
+
+// ResumeRegenerations continues the regenerations a restart interrupted.
+func (d *DocumentCenter) ResumeRegenerations() {
+	jobs, err := d.storage.GetRunningStatementRegenerations()
+	if err != nil {
+		log.Println("Failed to resume statement regenerations: ", err)
+		return
+	}
+	for _, job := range jobs {
+		d.Regenerate(job)
+	}
+}
+
+// Regenerate works through job, one account at a time, saving its
+// progress after each.
+func (d *DocumentCenter) Regenerate(job *StatementRegeneration) {
+	from, _ := time.Parse(statementPeriodLayout, job.From)
+	to, _ := time.Parse(statementPeriodLayout, job.To)
+	only := map[int]bool{}
+	for _, id := range job.AccountIDs {
+		only[id] = true
+	}
+
+	accounts, err := d.storage.GetAccounts()
+	if err != nil {
+		log.Printf("Failed to regenerate statements %s: %v\n", job.PublicID, err)
+		return
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+
+	ticker := time.NewTicker(statementRenderInterval())
+	defer ticker.Stop()
+
+	for _, account := range accounts {
+		if account.ID <= job.LastAccountID || account.System != "" || len(only) > 0 && !only[account.ID] {
+			continue
+		}
+		for start := from; !start.After(to); start = start.AddDate(0, 1, 0) {
+			end := start.AddDate(0, 1, 0)
+			if !account.CreatedAt.Before(end) {
+				continue
+			}
+			<-ticker.C
+			outcome, err := d.regenerateStatement(account, start.Format(statementPeriodLayout), start, end)
+			if err != nil {
+				log.Printf("Failed to regenerate statement %s of account %d: %v\n", start.Format(statementPeriodLayout), account.ID, err)
+				job.Failed++
+				continue
+			}
+			switch outcome {
+			case statementUnchanged:
+				job.Unchanged++
+			case statementRegenerated:
+				job.Regenerated++
+			case statementCreated:
+				job.Created++
+			}
+		}
+
+		job.LastAccountID = account.ID
+		job.Accounts++
+		job.UpdatedAt = time.Now().UTC()
+		if err := d.storage.UpdateStatementRegeneration(job); err != nil {
+			log.Printf("Failed to save progress of statement regeneration %s: %v\n", job.PublicID, err)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	job.Status, job.UpdatedAt, job.CompletedAt = RegenerationCompleted, now, &now
+	if err := d.storage.UpdateStatementRegeneration(job); err != nil {
+		log.Printf("Failed to complete statement regeneration %s: %v\n", job.PublicID, err)
+	}
+}
+
+// regenerateStatement renders the statement again and overwrites the one
+// filed for the period if it differs. Periods that never had a statement
+// get one filed.
+func (d *DocumentCenter) regenerateStatement(account *Account, period string, start, end time.Time) (statementOutcome, error) {
+	body, err := d.statementPDF(account, period, start, end)
+	if err != nil {
+		return 0, err
+	}
+
+	existing, err := d.storage.GetDocuments(account.ID, DocumentStatement)
+	if err != nil {
+		return 0, err
+	}
+	for _, doc := range existing {
+		if doc.Period != period {
+			continue
+		}
+		if old, err := d.objects.Get(doc.objectKey()); err == nil && bytes.Equal(old, body) {
+			return statementUnchanged, nil
+		}
+		if err := d.objects.Put(doc.objectKey(), body); err != nil {
+			return 0, err
+		}
+		doc.Size = len(body)
+		if err := d.storage.UpdateDocumentSize(doc); err != nil {
+			return 0, err
+		}
+		n := NewNotification(doc.AccountID, NotificationKind(string(doc.Kind)+".corrected"),
+			fmt.Sprintf("A document in your document center was corrected: %s.", doc.Title))
+		if err := d.notifier.Notify(n); err != nil {
+			log.Println("Failed to send document notification: ", err)
+		}
+		return statementRegenerated, nil
+	}
+
+	doc := &Document{
+		AccountID:   account.ID,
+		Kind:        DocumentStatement,
+		Title:       "Statement " + period,
+		Period:      period,
+		ContentType: "application/pdf",
+	}
+	if err := d.File(doc, body); err != nil {
+		return 0, err
+	}
+	return statementCreated, nil
+}
+
+// HandleAdminRegenerateStatements starts a regeneration in the background
+// and answers 202 with it; poll its Location for progress.
+func (s *APIServer) HandleAdminRegenerateStatements(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(StatementRegenerationRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	from, err := time.Parse(statementPeriodLayout, req.From)
+	if err != nil {
+		return ApiError{Err: "invalid from: " + req.From, Status: http.StatusBadRequest}
+	}
+	to, err := time.Parse(statementPeriodLayout, req.To)
+	if err != nil {
+		return ApiError{Err: "invalid to: " + req.To, Status: http.StatusBadRequest}
+	}
+	now := time.Now().UTC()
+	if to.Before(from) {
+		return ApiError{Err: "from must not be after to", Status: http.StatusBadRequest}
+	}
+	if !to.AddDate(0, 1, 0).Before(now) {
+		return ApiError{Err: "statements can only be regenerated for months that have ended", Status: http.StatusBadRequest}
+	}
+	if from.AddDate(0, maxRegenerationPeriods, 0).Before(to.AddDate(0, 1, 0)) {
+		return ApiError{Err: fmt.Sprintf("at most %d months can be regenerated at once", maxRegenerationPeriods), Status: http.StatusBadRequest}
+	}
+
+	job := &StatementRegeneration{
+		PublicID:    NewULID(),
+		From:        req.From,
+		To:          req.To,
+		AccountIDs:  req.AccountIDs,
+		RequestedBy: adminActor(r),
+		Status:      RegenerationRunning,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.storage.CreateStatementRegeneration(job); err != nil {
+		return err
+	}
+
+	event := NewAuditEvent(job.RequestedBy, "statements.regeneration_started", 0, map[string]string{
+		"regeneration": job.PublicID,
+		"from":         job.From,
+		"to":           job.To,
+		"accounts":     fmt.Sprint(job.AccountIDs),
+	})
+	if err := s.storage.CreateAuditEvent(event); err != nil {
+		log.Println("Failed to audit statement regeneration: ", err)
+	}
+
+	started := *job
+	go s.documents.Regenerate(job)
+
+	w.Header().Set("Location", "/admin/statements/regenerations/"+started.PublicID)
+	return writeJSON(w, http.StatusAccepted, started)
+}
+
+func (s *APIServer) HandleAdminGetRegeneration(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	job, err := s.storage.GetStatementRegeneration(mux.Vars(r)["regenerationID"])
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementRegeneration(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Setenv("DOCUMENT_DIR", t.TempDir())
+	t.Setenv("STATEMENT_RENDER_INTERVAL", "1ms")
+
+	store := NewMemoryStorage()
+	server := NewAPIServer(":0", store)
+	router := server.Router()
+
+	now := time.Now().UTC()
+	lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	period := lastMonth.Format(statementPeriodLayout)
+	acc := createTestAccount(t, store, 1000)
+	other := createTestAccount(t, store, 0)
+	for _, a := range []*Account{acc, other} {
+		a.CreatedAt = lastMonth
+		assert.Nil(t, store.UpdateAccount(a))
+	}
+	assert.Nil(t, server.documents.fileStatements(now))
+	filed, err := store.GetDocuments(acc.ID, DocumentStatement)
+	assert.Nil(t, err)
+	assert.Len(t, filed, 1)
+
+	// A correction posted into last month after its statement was filed.
+	transfer := NewTransfer(acc.ID, other.ID, NewMoney(250, defaultCurrency))
+	transfer.CreatedAt = lastMonth.Add(48 * time.Hour)
+	assert.Nil(t, store.CreateTransfer(transfer))
+	assert.Nil(t, store.ExecuteTransfer(transfer))
+
+	regenerate := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/statements/regenerations", strings.NewReader(body))
+		req.Header.Set("x-admin-token", "admin-secret")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	poll := func(location string) *StatementRegeneration {
+		job := new(StatementRegeneration)
+		assert.Eventually(t, func() bool {
+			req := httptest.NewRequest(http.MethodGet, location, nil)
+			req.Header.Set("x-admin-token", "admin-secret")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			return json.NewDecoder(rec.Body).Decode(job) == nil && job.Status == RegenerationCompleted
+		}, 5*time.Second, 10*time.Millisecond)
+		return job
+	}
+
+	rec := regenerate(`{"from":"` + period + `","to":"` + now.Format(statementPeriodLayout) + `"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "the current month has no statement yet")
+	rec = regenerate(`{"from":"` + period + `","to":"2001-01"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	body := `{"from":"` + period + `","to":"` + period + `","accountIds":[` + strconv.Itoa(acc.ID) + `]}`
+	rec = regenerate(body)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	job := poll(rec.Header().Get("Location"))
+	assert.Equal(t, 1, job.Accounts)
+	assert.Equal(t, 1, job.Regenerated)
+
+	docs, err := store.GetDocuments(acc.ID, DocumentStatement)
+	assert.Nil(t, err)
+	assert.Len(t, docs, 1, "regenerating overwrites the statement")
+	assert.Equal(t, filed[0].PublicID, docs[0].PublicID)
+	content, err := server.documents.objects.Get(docs[0].objectKey())
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "-2.50")
+	assert.Equal(t, len(content), docs[0].Size)
+
+	rec = regenerate(body)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	job = poll(rec.Header().Get("Location"))
+	assert.Equal(t, 0, job.Regenerated)
+	assert.Equal(t, 1, job.Unchanged, "regenerating again changes nothing")
+
+	// Without an account filter every account is regenerated.
+	rec = regenerate(`{"from":"` + period + `","to":"` + period + `"}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	job = poll(rec.Header().Get("Location"))
+	assert.Equal(t, 2, job.Accounts)
+	assert.Equal(t, 1, job.Regenerated)
+	assert.Equal(t, 1, job.Unchanged)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"expvar"
+	"sync"
+)
+
+// statementCacheStats counts how often a hot-path query found its
+// prepared statement, and how many are prepared.
+var statementCacheStats = expvar.NewMap("statement_cache")
+
+// stmtCache prepares each query once per database and reuses the
+// statement. database/sql prepares a statement again on every connection
+// it runs on and keeps it there, so after warming up each pooled
+// connection skips parsing and planning the query.
+type stmtCache struct {
+	db *sql.DB
+
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+func newStmtCache(db *sql.DB) *stmtCache {
+	return &stmtCache{db: db, stmts: map[string]*sql.Stmt{}}
+}
+
+func (c *stmtCache) get(query string) (*sql.Stmt, error) {
+	c.mu.RLock()
+	stmt, ok := c.stmts[query]
+	c.mu.RUnlock()
+	if ok {
+		statementCacheStats.Add("hits", 1)
+		return stmt, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stmt, ok := c.stmts[query]; ok {
+		statementCacheStats.Add("hits", 1)
+		return stmt, nil
+	}
+	statementCacheStats.Add("misses", 1)
+	stmt, err := c.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[query] = stmt
+	statementCacheStats.Add("prepared", 1)
+	return stmt, nil
+}
+
+// The queries of the transfer hot path, prepared when PREPARED_STATEMENTS
+// is on.
+const (
+	getAccountByIDQuery = "select " + accountColumns + " from account where id = $1 and deleted_at is null"
+	insertTransferQuery = `insert into transfer
+	(public_id, from_account, to_account, amount, currency, credit_amount, credit_currency, quote_id, reference,
+		refund_of, status, failure_reason, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	returning id, ` + transferPublicIDColumns
+	lockTransferQuery = "select status from transfer where id = $1 for update"
+	lockAccountsQuery = `select id, balance, currency from account
+			where id in ($1, $2) and deleted_at is null order by id for update`
+	updateBalanceQuery  = "update account set balance = $1 where id = $2"
+	settleTransferQuery = "update transfer set status = $1, failure_reason = $2, updated_at = $3 where id = $4"
+	freezeWindowsQuery  = "select " + freezeWindowColumns + " from freeze_window where account_id = $1 order by id"
+)
+
+// prepared returns the cached statement of query on the database serving
+// it, or nil when statements aren't prepared; callers then run the query
+// as text.
+func (f *failoverDB) prepared(query string) (*sql.Stmt, error) {
+	if !f.Prepare {
+		return nil, nil
+	}
+	if f.failedOver() {
+		if !isReadQuery(query) {
+			return nil, ErrPrimaryUnavailable
+		}
+		return f.standbyStmts.get(query)
+	}
+	return f.primaryStmts.get(query)
+}
+
+func (c dbConn) queryPrepared(query string, args ...any) (*sql.Rows, error) {
+	stmt, err := c.prepared(query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return c.Query(query, args...)
+	}
+	return stmt.QueryContext(c.ctx, args...)
+}
+
+func (c dbConn) queryRowPrepared(query string, args ...any) *sql.Row {
+	if stmt, err := c.prepared(query); err == nil && stmt != nil {
+		return stmt.QueryRowContext(c.ctx, args...)
+	}
+	return c.QueryRow(query, args...)
+}
+
+// txStmt runs query in tx with the statement prepared for it, if any.
+func (c dbConn) txStmt(tx *sql.Tx, query string) *sql.Stmt {
+	if stmt, err := c.prepared(query); err == nil && stmt != nil {
+		return tx.StmtContext(c.ctx, stmt)
+	}
+	return nil
+}
+
+func (c dbConn) txQuery(tx *sql.Tx, query string, args ...any) (*sql.Rows, error) {
+	if stmt := c.txStmt(tx, query); stmt != nil {
+		return stmt.QueryContext(c.ctx, args...)
+	}
+	return tx.QueryContext(c.ctx, query, args...)
+}
+
+func (c dbConn) txQueryRow(tx *sql.Tx, query string, args ...any) *sql.Row {
+	if stmt := c.txStmt(tx, query); stmt != nil {
+		return stmt.QueryRowContext(c.ctx, args...)
+	}
+	return tx.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c dbConn) txExec(tx *sql.Tx, query string, args ...any) (sql.Result, error) {
+	if stmt := c.txStmt(tx, query); stmt != nil {
+		return stmt.ExecContext(c.ctx, args...)
+	}
+	return tx.ExecContext(c.ctx, query, args...)
+}
@@ -1,28 +1,210 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"math/rand"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type Storage interface {
 	Init() error
+	Ping() error
 	CreateAccount(*Account) error
 	DeleteAccount(int) error
 	UpdateAccount(*Account) error
 	GetAccounts() ([]*Account, error)
 	GetAccountByID(int) (*Account, error)
 	GetAccountByNumber(int32) (*Account, error)
+	// GetAccountIDByPublicID resolves a public id to the internal one,
+	// including for soft-deleted accounts so their redirects still apply.
+	GetAccountIDByPublicID(string) (int, error)
+	GetSystemAccounts() ([]*Account, error)
+	SearchAccounts(query string, limit int) ([]*Account, error)
+	CreateTransfer(*Transfer) error
+	GetTransferByID(int) (*Transfer, error)
+	GetTransferByPublicID(string) (*Transfer, error)
+	// ExecuteTransfer settles a pending transfer, or fails it when the
+	// sender can't be debited, e.g. for lack of funds or during a freeze.
+	ExecuteTransfer(*Transfer) error
+	ClaimTransfer(lease time.Duration, acceptedBefore time.Time) (*Transfer, error)
+	FailTransfer(t *Transfer, reason string) error
+	HoldTransfer(*Transfer) error
+	GetTransfers(TransferFilter, PageQuery) ([]*Transfer, error)
+	GetRefunds(transferID int) ([]*Transfer, error)
+	GetTransferChanges(ChangeQuery) ([]*Transfer, error)
+	CreateTransferOrigin(*TransferOrigin) error
+	GetTransferOrigins([]int) (map[int]*TransferOrigin, error)
+	GetReconciliationReport(stuckBefore time.Time) (*ReconciliationReport, error)
+	ExecuteCashOperation(op *CashOperation, dailyLimit Money) error
+	GetCashOperations(int, PageQuery) ([]*CashOperation, error)
+	GetCashOperationChanges(ChangeQuery) ([]*CashOperation, error)
+	CreateCapture(*CapturedExchange) error
+	GetCapture(int) (*CapturedExchange, error)
+	GetCaptures(limit int) ([]*CapturedExchange, error)
+	CreateLoginAttempt(*LoginAttempt) error
+	GetLoginAttempts(LoginAttemptFilter, PageQuery) ([]*LoginAttempt, error)
+	CreateDevice(*Device) error
+	GetDevicesByAccount(int) ([]*Device, error)
+	RevokeDevice(accountID, id int) error
+	TouchDevice(int) error
+	CreateLoginChallenge(*LoginChallenge) error
+	GetLoginChallenge(string) (*LoginChallenge, error)
+	// CountLoginChallengeAttempt counts one guess at the challenge's code
+	// and returns the challenge as counted. It fails with
+	// ErrLoginChallengeNotFound once the challenge is used, expired or out
+	// of attempts; concurrent guesses are counted one at a time.
+	CountLoginChallengeAttempt(id string, maxAttempts int, now time.Time) (*LoginChallenge, error)
+	UseLoginChallenge(id string, now time.Time) error
+	CreatePayee(*Payee) error
+	GetPayee(accountID, id int) (*Payee, error)
+	GetPayees(int) ([]*Payee, error)
+	DeletePayee(accountID, id int) error
+	CreateBillPayment(*BillPayment) error
+	GetBillPayments(int) ([]*BillPayment, error)
+	GetBillPaymentChanges(ChangeQuery) ([]*BillPayment, error)
+	GetBillPaymentsByStatus(status BillPaymentStatus, updatedBefore time.Time) ([]*BillPayment, error)
+	CancelBillPayment(accountID, id int) error
+	UpdateStandingOrder(accountID, id int, action StandingOrderAction, now time.Time) (*BillPayment, error)
+	SendDueBillPayment(now time.Time, closedCurrencies []string) (*BillPayment, error)
+	ConfirmBillPayment(*BillPayment) error
+	RefundBillPayment(p *BillPayment, reason string) error
+	CreateInvoice(*Invoice) error
+	GetInvoices(int) ([]*Invoice, error)
+	GetInvoiceByPublicID(string) (*Invoice, error)
+	CreateAlertRule(*AlertRule) error
+	GetAlertRule(accountID, id int) (*AlertRule, error)
+	GetAlertRules(int) ([]*AlertRule, error)
+	UpdateAlertRule(*AlertRule) error
+	DeleteAlertRule(accountID, id int) error
+	CreateSweepRule(*SweepRule) error
+	GetSweepRule(accountID, id int) (*SweepRule, error)
+	GetSweepRules(int) ([]*SweepRule, error)
+	UpdateSweepRule(*SweepRule) error
+	DeleteSweepRule(accountID, id int) error
+	CreateFreezeWindow(*FreezeWindow) error
+	GetFreezeWindow(accountID, id int) (*FreezeWindow, error)
+	GetFreezeWindows(int) ([]*FreezeWindow, error)
+	GetAllFreezeWindows() ([]*FreezeWindow, error)
+	DeleteFreezeWindow(accountID, id int) error
+	CreateDelegation(*Delegation) error
+	GetDelegations(accountID int) ([]*Delegation, error)
+	GetDelegationsTo(delegateAccount int) ([]*Delegation, error)
+	DeleteDelegation(accountID, id int) error
+	CreateDocument(*Document) error
+	GetDocuments(accountID int, kind DocumentKind) ([]*Document, error)
+	GetDocumentByPublicID(string) (*Document, error)
+	UpdateDocumentSize(*Document) error
+	CreateStatementRegeneration(*StatementRegeneration) error
+	GetStatementRegeneration(publicID string) (*StatementRegeneration, error)
+	GetRunningStatementRegenerations() ([]*StatementRegeneration, error)
+	UpdateStatementRegeneration(*StatementRegeneration) error
+	CreateEndOfDay(*EndOfDayRun) error
+	GetEndOfDay(businessDate string) (*EndOfDayRun, error)
+	GetEndOfDays(limit int) ([]*EndOfDayRun, error)
+	UpdateEndOfDay(*EndOfDayRun) error
+	CreateAutomationRule(*AutomationRule) error
+	GetAutomationRule(accountID, id int) (*AutomationRule, error)
+	GetAutomationRules(accountID int) ([]*AutomationRule, error)
+	UpdateAutomationRule(*AutomationRule) error
+	DeleteAutomationRule(accountID, id int) error
+	CreateAutomationRun(*AutomationRun) error
+	GetAutomationRuns(accountID, ruleID, limit int) ([]*AutomationRun, error)
+	SaveReviewCase(*ReviewCase) error
+	GetReviewCases(transferIDs []int) (map[int]*ReviewCase, error)
+	SaveTransactionTags([]*TransactionTag) error
+	GetTransactionTags(accountID int) (map[string]*TransactionTag, error)
+	GetDeletedAccounts(deletedBefore time.Time, afterID, limit int) ([]*DeletedAccount, error)
+	PurgeAccount(id int) (int64, error)
+	PlaceLegalHold(*LegalHold) error
+	GetLegalHold(accountID int) (*LegalHold, error)
+	ReleaseLegalHold(accountID int) error
+	CreateWebhookEndpoint(*WebhookEndpoint) error
+	GetWebhookEndpoint(accountID, id int) (*WebhookEndpoint, error)
+	GetWebhookEndpoints(accountID int) ([]*WebhookEndpoint, error)
+	UpdateWebhookEndpoint(*WebhookEndpoint) error
+	DeleteWebhookEndpoint(accountID, id int) error
+	GetPaperlessPreferences(accountID int) (*PaperlessPreferences, error)
+	UpdatePaperlessPreferences(*PaperlessPreferences) error
+	CreateHoliday(*Holiday) error
+	GetHolidays(currency string) ([]*Holiday, error)
+	DeleteHoliday(id int) error
+	CreateTerms(*Terms) error
+	GetTerms() ([]*Terms, error)
+	AcceptTerms(*TermsAcceptance) error
+	GetTermsAcceptances(accountID int) ([]*TermsAcceptance, error)
+	StartOnlineMigration(*OnlineMigration) (*MigrationProgress, error)
+	BackfillOnlineMigration(m *OnlineMigration, batchSize int) (*MigrationProgress, error)
+	GetMigrationProgress() ([]*MigrationProgress, error)
+	CutOverOnlineMigration(name string, now time.Time) (*MigrationProgress, error)
+	GetAccountsByPhone([]string) ([]*Account, error)
+	GetAccountsByName(normalized string) ([]*Account, error)
+	CreateContact(*Contact) error
+	GetContact(accountID, id int) (*Contact, error)
+	GetContacts(int) ([]*Contact, error)
+	UpdateContact(*Contact) error
+	DeleteContact(accountID, id int) error
+	CreateCheque(*Cheque) error
+	GetCheques(int) ([]*Cheque, error)
+	GetDueCheques(now time.Time) ([]*Cheque, error)
+	ClearCheque(*Cheque) error
+	BounceCheque(c *Cheque, reason string) error
+	CreateAuditEvent(*AuditEvent) error
+	GetAuditEvents(accountID, limit int) ([]*AuditEvent, error)
+	// GetAccountAuditEvents pages through the account's events with one of
+	// the given actions, newest first.
+	GetAccountAuditEvents(accountID int, actions []string, q PageQuery) ([]*AuditEvent, error)
+	TransferAccountOwnership(*Account, *AuditEvent) error
+	CloseAccountWithRedirect(*AccountRedirect, *AuditEvent) error
+	GetAccountRedirect(accountID int) (*AccountRedirect, error)
+	GetBalanceAt(accountID int, at time.Time) (*BalanceEntry, error)
+	GetIdempotentTransfer(accountID int, key string) (*Transfer, error)
+	SaveIdempotencyKey(*IdempotencyKey) error
+	CreateFXQuote(*FXQuote) error
+	GetFXQuote(publicID string) (*FXQuote, error)
+	UseFXQuote(*FXQuote) error
+	CreateTellerApproval(*TellerApproval) error
+	GetTellerApproval(publicID string) (*TellerApproval, error)
+	GetPendingTellerApprovals() ([]*TellerApproval, error)
+	DecideTellerApproval(*TellerApproval) error
+	CreateImpersonation(*Impersonation) error
+	GetImpersonation(publicID string) (*Impersonation, error)
+	GetImpersonations(accountID int) ([]*Impersonation, error)
+	UpdateImpersonation(i *Impersonation, from ImpersonationStatus) error
+	GetArchivableTransfers(before time.Time, limit int) ([]*Transfer, error)
+	DeleteTransfers([]int) error
+	GetArchivableAuditEvents(before time.Time, limit int) ([]*AuditEvent, error)
+	DeleteAuditEvents([]int) error
+	AddUsage([]UsageRecord) error
+	GetUsage(UsageFilter) ([]UsageRecord, error)
 }
 
 type PostgresStorage struct {
-	db *sql.DB
+	db dbConn
 }
 
+// NewPostgresStore connects to the primary and, if STANDBY_DATABASE_URL is
+// set, to a standby that serves reads while the primary is down.
 func NewPostgresStore() (*PostgresStorage, error) {
-	connStr := "user=postgres dbname=postgres password=gobank sslmode=disable"
+	s, err := OpenPostgresStore("user=postgres dbname=postgres password=gobank sslmode=disable timezone=UTC")
+	if err != nil {
+		return nil, err
+	}
+	standby, err := openStandby()
+	if err != nil {
+		return nil, err
+	}
+	s.db.setStandby(standby)
+	return s, nil
+}
+
+func OpenPostgresStore(connStr string) (*PostgresStorage, error) {
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, err
@@ -33,85 +215,486 @@ func NewPostgresStore() (*PostgresStorage, error) {
 	}
 
 	return &PostgresStorage{
-		db: db,
+		db: dbConn{failoverDB: newFailoverDB(db, nil), ctx: context.Background()},
 	}, nil
 }
 
+// maxSerializableAttempts bounds how often a transaction that lost a
+// serialization conflict is run again before the error is returned.
+const maxSerializableAttempts = 5
+
+var txRetries = expvar.NewInt("tx_serialization_retries")
+
+// serializable runs fn in a SERIALIZABLE transaction and commits it. When
+// Postgres aborts the transaction because of a concurrent one, fn is run
+// again from scratch, so it must not keep state from a failed attempt.
+func (s *PostgresStorage) serializable(fn func(tx *sql.Tx) error) error {
+	var err error
+	for attempt := 1; attempt <= maxSerializableAttempts; attempt++ {
+		if err = s.runTx(fn); !isSerializationFailure(err) {
+			return mapConstraintError(err)
+		}
+		txRetries.Add(1)
+		time.Sleep(time.Duration(attempt*attempt) * time.Duration(1+rand.Intn(5)) * time.Millisecond)
+	}
+	return mapConstraintError(err)
+}
+
+func (s *PostgresStorage) runTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(&sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isSerializationFailure reports whether err is a serialization failure or
+// deadlock, after which the transaction can safely be retried.
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01"
+	}
+	return false
+}
+
+func (s *PostgresStorage) Ping() error {
+	return s.db.Ping()
+}
+
+// RunFailover watches the primary and fails reads over to the standby
+// while it is down.
+func (s *PostgresStorage) RunFailover() {
+	s.db.Run()
+}
+
 func (s *PostgresStorage) Init() error {
-	return s.createAccountTable()
+	if err := s.createAccountTable(); err != nil {
+		return err
+	}
+	if err := s.createTransferTable(); err != nil {
+		return err
+	}
+	if err := s.createLoginAttemptTable(); err != nil {
+		return err
+	}
+	if err := s.createDeviceTables(); err != nil {
+		return err
+	}
+	if err := s.createCaptureTable(); err != nil {
+		return err
+	}
+	if err := s.createCashOperationTable(); err != nil {
+		return err
+	}
+	if err := s.createBillPayTables(); err != nil {
+		return err
+	}
+	if err := s.createInvoiceTable(); err != nil {
+		return err
+	}
+	if err := s.createAlertRuleTable(); err != nil {
+		return err
+	}
+	if err := s.createContactTable(); err != nil {
+		return err
+	}
+	if err := s.createChequeTable(); err != nil {
+		return err
+	}
+	if err := s.createAuditEventTable(); err != nil {
+		return err
+	}
+	if err := s.createUsageTable(); err != nil {
+		return err
+	}
+	if err := s.createSweepRuleTable(); err != nil {
+		return err
+	}
+	if err := s.createTransferOriginTable(); err != nil {
+		return err
+	}
+	if err := s.createFreezeWindowTable(); err != nil {
+		return err
+	}
+	if err := s.createDelegationTable(); err != nil {
+		return err
+	}
+	if err := s.createDocumentTables(); err != nil {
+		return err
+	}
+	if err := s.createHolidayTable(); err != nil {
+		return err
+	}
+	if err := s.createTermsTables(); err != nil {
+		return err
+	}
+	if err := s.createOnlineMigrationTable(); err != nil {
+		return err
+	}
+	if err := s.createAccountRedirectTable(); err != nil {
+		return err
+	}
+	if err := s.createBalanceEntryTable(); err != nil {
+		return err
+	}
+	if err := s.createIdempotencyKeyTable(); err != nil {
+		return err
+	}
+	if err := s.createTellerApprovalTable(); err != nil {
+		return err
+	}
+	if err := s.createFXQuoteTable(); err != nil {
+		return err
+	}
+	if err := s.createImpersonationTable(); err != nil {
+		return err
+	}
+	if err := s.createEndOfDayTable(); err != nil {
+		return err
+	}
+	if err := s.createAutomationTables(); err != nil {
+		return err
+	}
+	if err := s.createReviewCaseTable(); err != nil {
+		return err
+	}
+	if err := s.createTransactionTagTable(); err != nil {
+		return err
+	}
+	if err := s.createLegalHoldTable(); err != nil {
+		return err
+	}
+	if err := s.createWebhookEndpointTable(); err != nil {
+		return err
+	}
+
+	return s.migrate()
 }
 
 func (s *PostgresStorage) createAccountTable() error {
 	query := `create table if not exists account (
 		id serial primary key,
+		public_id char(26) unique not null,
 		first_name varchar(50),
 		last_name varchar(50),
 		number serial,
 		encrypted_password varchar(100),
-		balance serial,
-		created_at timestamp
+		balance bigint not null default 0,
+		created_at timestamptz,
+		currency char(3) not null default 'USD',
+		business boolean not null default false,
+		phone varchar(16) not null default '',
+		token_version integer not null default 0,
+		overdraft_limit bigint not null default 0,
+		deleted_at timestamptz
 	)`
 
 	_, err := s.db.Query(query)
 	return err
 }
 
-func (s *PostgresStorage) CreateAccount(account *Account) error {
-	query := `insert into account
-	(first_name, last_name, number, encrypted_password,balance, created_at)
-	values ($1, $2, $3, $4, $5, $6)`
+// migrations are applied in order on every start, so each one has to be
+// idempotent.
+var migrations = []string{
+	// created_at used to be a naive timestamp holding UTC wall-clock time.
+	`do $$
+	begin
+		if exists (select 1 from information_schema.columns
+			where table_name = 'account' and column_name = 'created_at'
+			and data_type = 'timestamp without time zone') then
+			alter table account alter column created_at type timestamptz
+				using created_at at time zone 'UTC';
+		end if;
+	end $$`,
+	// balance used to be a serial; store minor units as a plain bigint.
+	`alter table account alter column balance set default 0;
+	drop sequence if exists account_balance_seq;
+	alter table account alter column balance type bigint;
+	alter table account add column if not exists currency char(3) not null default 'USD'`,
+	// "pending" was split into "accepted" and "processing".
+	`update transfer set status = 'accepted' where status = 'pending'`,
+	// Public ULIDs; existing rows are filled in by backfillPublicIDs.
+	`alter table account add column if not exists public_id char(26) unique;
+	alter table transfer add column if not exists public_id char(26) unique`,
+	// Business accounts and payment references, used by invoicing.
+	`alter table account add column if not exists business boolean not null default false;
+	alter table transfer add column if not exists reference varchar(100) not null default ''`,
+	// Phone numbers customers can be found by from a contact list.
+	`alter table account add column if not exists phone varchar(16) not null default '';
+	create unique index if not exists account_phone_idx on account (phone) where phone <> ''`,
+	// Bumped to revoke every session of an account.
+	`alter table account add column if not exists token_version integer not null default 0`,
+	// Guard the ledger in the schema too: no balance below the overdraft
+	// limit and no two live accounts sharing a number or phone. Deleted
+	// accounts are kept, marked by deleted_at. The balance check is added
+	// NOT VALID so existing rows don't block the migration.
+	`alter table account add column if not exists overdraft_limit bigint not null default 0;
+	alter table account add column if not exists deleted_at timestamptz;
+	do $$
+	begin
+		if not exists (select 1 from pg_constraint where conname = 'account_balance_check') then
+			alter table account add constraint account_balance_check
+				check (balance >= -overdraft_limit) not valid;
+		end if;
+	end $$;
+	create unique index if not exists account_number_idx on account (number) where deleted_at is null;
+	drop index if exists account_phone_idx;
+	create unique index if not exists account_live_phone_idx on account (phone)
+		where phone <> '' and deleted_at is null`,
+	// Accounts owned by the bank itself, one per kind and currency.
+	`alter table account add column if not exists system_kind varchar(20) not null default '';
+	create unique index if not exists account_system_idx on account (system_kind, currency)
+		where system_kind <> '' and deleted_at is null`,
+	// Refunds point at the transfer they pay back.
+	`alter table transfer add column if not exists refund_of integer not null default 0;
+	create index if not exists transfer_refund_of_idx on transfer (refund_of) where refund_of <> 0`,
+	// Transfers converted at an FX quote credit the recipient in its
+	// currency.
+	`alter table transfer add column if not exists credit_amount bigint;
+	alter table transfer add column if not exists credit_currency char(3);
+	alter table transfer add column if not exists quote_id varchar(26) not null default ''`,
+	// Usage is also counted per endpoint; the totals quotas are checked
+	// against have an empty endpoint.
+	`do $$
+	begin
+		if not exists (select 1 from information_schema.columns
+			where table_name = 'api_usage' and column_name = 'endpoint') then
+			alter table api_usage add column endpoint varchar(150) not null default '';
+			alter table api_usage drop constraint api_usage_pkey;
+			alter table api_usage add primary key (consumer, metric, day, endpoint);
+		end if;
+	end $$`,
+}
 
-	if _, err := s.db.Exec(query, account.FirstName, account.LastName,
-		account.Number, account.EncryptedPassword, account.Balance, account.CreatedAt); err != nil {
-		return err
+// Domain errors the storage reports, wrapped with the id involved, so
+// handlers can tell them apart with errors.Is instead of matching strings.
+var (
+	ErrInsufficientFunds      = errors.New("insufficient funds")
+	ErrDuplicateAccountNumber = errors.New("account number is already in use")
+	ErrDuplicatePhone         = errors.New("phone number is already registered")
+	ErrStateConflict          = errors.New("not allowed in the current status")
+	ErrDebitsFrozen           = errors.New("debits are blocked by a freeze window")
+	ErrDuplicateHoliday       = errors.New("holiday already exists for this currency and date")
+	ErrDuplicateTermsVersion  = errors.New("terms version already published")
+	ErrTermsNotAccepted       = errors.New("the latest terms must be accepted first")
+
+	ErrAccountNotFound        = errors.New("account not found")
+	ErrTransferNotFound       = errors.New("transfer not found")
+	ErrDeviceNotFound         = errors.New("device not found")
+	ErrLoginChallengeNotFound = errors.New("login challenge not found")
+	ErrCaptureNotFound        = errors.New("capture not found")
+	ErrPayeeNotFound          = errors.New("payee not found")
+	ErrBillPaymentNotFound    = errors.New("bill payment not found")
+	ErrInvoiceNotFound        = errors.New("invoice not found")
+	ErrAlertRuleNotFound      = errors.New("alert rule not found")
+	ErrContactNotFound        = errors.New("contact not found")
+	ErrSweepRuleNotFound      = errors.New("sweep rule not found")
+	ErrFreezeWindowNotFound   = errors.New("freeze window not found")
+	ErrDocumentNotFound       = errors.New("document not found")
+	ErrMigrationNotFound      = errors.New("migration not found")
+	ErrBalanceHistoryNotFound = errors.New("no balance history")
+	ErrTellerApprovalNotFound = errors.New("teller approval not found")
+	ErrFXQuoteNotFound        = errors.New("FX quote not found")
+	ErrImpersonationNotFound  = errors.New("impersonation not found")
+	ErrRegenerationNotFound   = errors.New("statement regeneration not found")
+	ErrEndOfDayNotFound       = errors.New("end of day not found")
+	ErrAutomationRuleNotFound = errors.New("automation rule not found")
+	ErrLegalHoldNotFound      = errors.New("legal hold not found")
+	ErrWebhookNotFound        = errors.New("webhook endpoint not found")
+)
+
+// constraintErrors maps the names of schema constraints to the domain
+// error their violation means.
+var constraintErrors = map[string]error{
+	"account_balance_check":     ErrInsufficientFunds,
+	"account_number_idx":        ErrDuplicateAccountNumber,
+	"account_live_phone_idx":    ErrDuplicatePhone,
+	"holiday_currency_date_key": ErrDuplicateHoliday,
+	"terms_version_key":         ErrDuplicateTermsVersion,
+}
+
+func mapConstraintError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if domainErr, ok := constraintErrors[pqErr.Constraint]; ok {
+			return domainErr
+		}
+	}
+	return err
+}
+
+func (s *PostgresStorage) migrate() error {
+	for i, query := range migrations {
+		if _, err := s.db.Exec(query); err != nil {
+			return fmt.Errorf("migration %d failed: %w", i, err)
+		}
 	}
+	return s.backfillPublicIDs()
+}
 
+// backfillPublicIDs gives rows created before public ids existed a ULID.
+// ULIDs are generated here rather than in SQL, so this can't be a plain
+// migration.
+func (s *PostgresStorage) backfillPublicIDs() error {
+	for _, table := range []string{"account", "transfer"} {
+		rows, err := s.db.Query("select id, created_at from " + table + " where public_id is null")
+		if err != nil {
+			return err
+		}
+
+		ids := map[int]time.Time{}
+		for rows.Next() {
+			var id int
+			var createdAt sql.NullTime
+			if err := rows.Scan(&id, &createdAt); err != nil {
+				rows.Close()
+				return err
+			}
+			ids[id] = createdAt.Time
+		}
+		rows.Close()
+
+		for id, createdAt := range ids {
+			if _, err := s.db.Exec("update "+table+" set public_id = $1 where id = $2", newULIDAt(createdAt), id); err != nil {
+				return err
+			}
+		}
+
+		if _, err := s.db.Exec("alter table " + table + " alter column public_id set not null"); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (s *PostgresStorage) CreateAccount(account *Account) error {
+	query := `insert into account
+	(public_id, first_name, last_name, number, encrypted_password, balance, currency, business, phone, system_kind, created_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	returning id`
+
+	err := s.db.QueryRow(query, account.PublicID, account.FirstName, account.LastName, account.Number,
+		account.EncryptedPassword, account.Balance.Amount, account.Balance.Currency, account.Business,
+		account.Phone, account.System, account.CreatedAt).Scan(&account.ID)
+	return mapConstraintError(err)
+}
+
+// DeleteAccount soft-deletes the account: it disappears from every lookup
+// but its row stays, so the ledger still adds up.
 func (s *PostgresStorage) DeleteAccount(id int) error {
-	_, err := s.db.Query("delete from account where id = $1", id)
+	res, err := s.db.Exec("update account set deleted_at = $1 where id = $2 and deleted_at is null", time.Now().UTC(), id)
 	if err != nil {
 		return err
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+	}
 	return nil
 }
 
-func (s *PostgresStorage) UpdateAccount(*Account) error {
-	return nil
+func (s *PostgresStorage) UpdateAccount(account *Account) error {
+	query := `update account
+	set first_name = $1, last_name = $2, encrypted_password = $3, balance = $4, currency = $5, business = $6,
+		phone = $7
+	where id = $8`
+
+	_, err := s.db.Exec(query, account.FirstName, account.LastName, account.EncryptedPassword,
+		account.Balance.Amount, account.Balance.Currency, account.Business, account.Phone, account.ID)
+	return mapConstraintError(err)
 }
 
 func (s *PostgresStorage) GetAccountByNumber(number int32) (*Account, error) {
-	rows, err := s.db.Query("select * from account where number = $1", number)
+	rows, err := s.db.Query("select "+accountColumns+" from account where number = $1 and deleted_at is null", number)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		return scanIntoAccount(rows)
 	}
 
-	return nil, fmt.Errorf("Account with number [%d] was not found", number)
+	return nil, fmt.Errorf("%w: number %d", ErrAccountNotFound, number)
+}
+
+func (s *PostgresStorage) GetAccountIDByPublicID(publicID string) (int, error) {
+	var id int
+	err := s.db.QueryRow("select id from account where public_id = $1", publicID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %s", ErrAccountNotFound, publicID)
+	}
+	return id, err
 }
 
 func (s *PostgresStorage) GetAccountByID(id int) (*Account, error) {
-	rows, err := s.db.Query("select * from account where id = $1", id)
+	rows, err := s.db.queryPrepared(getAccountByIDQuery, id)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		return scanIntoAccount(rows)
 	}
 
-	return nil, fmt.Errorf("Account: %d was not found", id)
+	return nil, fmt.Errorf("%w: %d", ErrAccountNotFound, id)
+}
+
+// SearchAccounts matches accounts by name, number or public id.
+func (s *PostgresStorage) SearchAccounts(query string, limit int) ([]*Account, error) {
+	rows, err := s.db.Query("select "+accountColumns+` from account
+	where deleted_at is null and (first_name || ' ' || last_name ilike '%' || $1 || '%'
+		or number::text = $1 or public_id = upper($1))
+	order by id
+	limit $2`, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
 }
 
 func (s *PostgresStorage) GetAccounts() ([]*Account, error) {
-	rows, err := s.db.Query("select * from account")
+	rows, err := s.db.Query("select " + accountColumns + " from account where deleted_at is null")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
+
+func (s *PostgresStorage) GetSystemAccounts() ([]*Account, error) {
+	rows, err := s.db.Query("select " + accountColumns + " from account where system_kind <> '' and deleted_at is null order by currency, system_kind")
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	accounts := []*Account{}
 	for rows.Next() {
@@ -122,12 +705,3332 @@ func (s *PostgresStorage) GetAccounts() ([]*Account, error) {
 		accounts = append(accounts, account)
 	}
 
-	return accounts, nil
+	return accounts, rows.Err()
 }
 
+const accountColumns = "id, public_id, first_name, last_name, number, encrypted_password, balance, currency, business, phone, token_version, system_kind, created_at"
+
 func scanIntoAccount(rows *sql.Rows) (*Account, error) {
 	account := new(Account)
-	err := rows.Scan(&account.ID, &account.FirstName, &account.LastName,
-		&account.Number, &account.EncryptedPassword, &account.Balance, &account.CreatedAt)
+	err := rows.Scan(&account.ID, &account.PublicID, &account.FirstName, &account.LastName, &account.Number,
+		&account.EncryptedPassword, &account.Balance.Amount, &account.Balance.Currency, &account.Business,
+		&account.Phone, &account.TokenVersion, &account.System, &account.CreatedAt)
+	account.CreatedAt = account.CreatedAt.UTC()
 	return account, err
 }
+
+func (s *PostgresStorage) createTransferTable() error {
+	query := `create table if not exists transfer (
+		id serial primary key,
+		public_id char(26) unique not null,
+		from_account integer not null,
+		to_account integer not null,
+		amount bigint not null,
+		currency char(3) not null,
+		reference varchar(100) not null default '',
+		status varchar(20) not null,
+		failure_reason text not null default '',
+		created_at timestamptz not null,
+		updated_at timestamptz not null
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateTransfer(t *Transfer) error {
+	var creditAmount sql.NullInt64
+	var creditCurrency sql.NullString
+	if t.Credit != nil {
+		creditAmount = sql.NullInt64{Int64: t.Credit.Amount, Valid: true}
+		creditCurrency = sql.NullString{String: t.Credit.Currency, Valid: true}
+	}
+	var fromPublicID, toPublicID, refundOfPublicID sql.NullString
+	err := s.db.queryRowPrepared(insertTransferQuery, t.PublicID, t.FromAccount, t.ToAccount, t.Amount.Amount, t.Amount.Currency,
+		creditAmount, creditCurrency, t.QuoteID, t.Reference, t.RefundOf, t.Status, t.FailureReason, t.CreatedAt,
+		t.UpdatedAt).Scan(&t.ID, &fromPublicID, &toPublicID, &refundOfPublicID)
+	t.FromPublicID = fromPublicID.String
+	t.ToPublicID = toPublicID.String
+	t.RefundOfPublicID = refundOfPublicID.String
+	return err
+}
+
+func (s *PostgresStorage) GetTransferByID(id int) (*Transfer, error) {
+	rows, err := s.db.Query("select "+transferColumns+" from transfer where id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoTransfer(rows)
+	}
+
+	return nil, fmt.Errorf("%w: %d", ErrTransferNotFound, id)
+}
+
+func (s *PostgresStorage) GetTransferByPublicID(publicID string) (*Transfer, error) {
+	rows, err := s.db.Query("select "+transferColumns+" from transfer where public_id = $1", publicID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoTransfer(rows)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrTransferNotFound, publicID)
+}
+
+// ExecuteTransfer moves the money of a pending transfer and settles it, or
+// marks it failed when the source account cannot cover it. Both balances
+// and the transfer status change in one serializable transaction, which is
+// retried on conflicts; transfers that are no longer pending are left alone.
+func (s *PostgresStorage) ExecuteTransfer(t *Transfer) error {
+	orig := *t
+	return s.serializable(func(tx *sql.Tx) error {
+		*t = orig
+
+		var status TransferStatus
+		if err := s.db.txQueryRow(tx, lockTransferQuery, t.ID).Scan(&status); err != nil {
+			return err
+		}
+		if status != TransferAccepted && status != TransferProcessing && status != TransferHeld {
+			return nil
+		}
+
+		// Lock both rows in id order so concurrent transfers can't deadlock.
+		rows, err := s.db.txQuery(tx, lockAccountsQuery, t.FromAccount, t.ToAccount)
+		if err != nil {
+			return err
+		}
+		balances := map[int]Money{}
+		for rows.Next() {
+			var id int
+			var balance Money
+			if err := rows.Scan(&id, &balance.Amount, &balance.Currency); err != nil {
+				rows.Close()
+				return err
+			}
+			balances[id] = balance
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		t.UpdatedAt = time.Now().UTC()
+		from, fromOK := balances[t.FromAccount]
+		to, toOK := balances[t.ToAccount]
+		newFrom, newTo, reason := applyTransfer(from, to, t.Amount, t.Credited())
+		if !fromOK || !toOK {
+			reason = "account not found"
+		}
+		if reason == "" {
+			rows, err := s.db.txQuery(tx, freezeWindowsQuery, t.FromAccount)
+			if err != nil {
+				return err
+			}
+			windows, err := scanFreezeWindows(rows)
+			if err != nil {
+				return err
+			}
+			if activeFreezeWindow(windows, t.UpdatedAt) != nil {
+				reason = ErrDebitsFrozen.Error()
+			}
+		}
+
+		if reason != "" {
+			t.Status, t.FailureReason = TransferFailed, reason
+		} else {
+			t.Status = TransferSettled
+			if _, err := s.db.txExec(tx, updateBalanceQuery, newFrom.Amount, t.FromAccount); err != nil {
+				return err
+			}
+			if _, err := s.db.txExec(tx, updateBalanceQuery, newTo.Amount, t.ToAccount); err != nil {
+				return err
+			}
+			if t.Reference != "" {
+				// Settle the invoice this transfer pays in full, if any.
+				credited := t.Credited()
+				if _, err := tx.Exec(`update invoice set status = $1, paid_by_transfer = $2, paid_at = $3
+				where public_id = $4 and account_id = $5 and total = $6 and currency = $7 and status = $8`,
+					InvoicePaid, t.ID, t.UpdatedAt, t.Reference, t.ToAccount, credited.Amount, credited.Currency,
+					InvoiceSent); err != nil {
+					return err
+				}
+			}
+		}
+
+		if _, err := s.db.txExec(tx, settleTransferQuery, t.Status, t.FailureReason, t.UpdatedAt, t.ID); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// FailTransfer marks a pending transfer failed without moving any money.
+func (s *PostgresStorage) FailTransfer(t *Transfer, reason string) error {
+	t.Status, t.FailureReason, t.UpdatedAt = TransferFailed, reason, time.Now().UTC()
+	_, err := s.db.Exec(`update transfer set status = $1, failure_reason = $2, updated_at = $3
+	where id = $4 and status in ($5, $6, $7)`,
+		t.Status, t.FailureReason, t.UpdatedAt, t.ID, TransferAccepted, TransferProcessing, TransferHeld)
+	return err
+}
+
+// HoldTransfer keeps an accepted transfer from being processed until it
+// is executed or failed explicitly.
+func (s *PostgresStorage) HoldTransfer(t *Transfer) error {
+	t.Status, t.UpdatedAt = TransferHeld, time.Now().UTC()
+	_, err := s.db.Exec("update transfer set status = $1, updated_at = $2 where id = $3 and status = $4",
+		t.Status, t.UpdatedAt, t.ID, TransferAccepted)
+	return err
+}
+
+// ClaimTransfer moves the oldest transfer accepted before acceptedBefore to
+// processing and returns it, or returns nil when there is nothing to do.
+// Transfers stuck in processing for longer than lease are claimed again.
+func (s *PostgresStorage) ClaimTransfer(lease time.Duration, acceptedBefore time.Time) (*Transfer, error) {
+	now := time.Now().UTC()
+	query := `update transfer set status = $1, updated_at = $2
+	where id = (
+		select id from transfer
+		where (status = $3 and created_at <= $5) or (status = $1 and updated_at < $4)
+		order by id
+		limit 1
+		for update skip locked
+	)
+	returning ` + transferColumns
+
+	rows, err := s.db.Query(query, TransferProcessing, now, TransferAccepted, now.Add(-lease), acceptedBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoTransfer(rows)
+	}
+
+	return nil, rows.Err()
+}
+
+func (s *PostgresStorage) GetTransfers(f TransferFilter, q PageQuery) ([]*Transfer, error) {
+	query := `select ` + transferColumns + ` from transfer
+	where ($1::integer is null or from_account = $1 or to_account = $1)
+		and ($2 = '' or status = $2)
+		and created_at >= $3 and (created_at, id) < ($4, $5)
+	order by created_at desc, id desc
+	limit $6`
+
+	rows, err := s.db.Query(query, f.AccountID, f.Status, q.After, q.Before, q.BeforeID, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []*Transfer{}
+	for rows.Next() {
+		t, err := scanIntoTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+
+	return transfers, rows.Err()
+}
+
+const transferColumns = "id, public_id, from_account, to_account, amount, currency, credit_amount, credit_currency, quote_id, reference, refund_of, status, failure_reason, created_at, updated_at, " +
+	transferPublicIDColumns
+
+// transferPublicIDColumns looks up the public ids of the accounts a
+// transfer row refers to, and of the transfer a refund is for.
+const transferPublicIDColumns = `(select a.public_id from account a where a.id = transfer.from_account),
+	(select a.public_id from account a where a.id = transfer.to_account),
+	(select r.public_id from transfer r where r.id = transfer.refund_of)`
+
+func (s *PostgresStorage) GetRefunds(transferID int) ([]*Transfer, error) {
+	rows, err := s.db.Query("select "+transferColumns+" from transfer where refund_of = $1 order by id", transferID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []*Transfer{}
+	for rows.Next() {
+		t, err := scanIntoTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, t)
+	}
+
+	return refunds, rows.Err()
+}
+
+func scanIntoTransfer(rows *sql.Rows) (*Transfer, error) {
+	t := new(Transfer)
+	var creditAmount sql.NullInt64
+	var creditCurrency, fromPublicID, toPublicID, refundOfPublicID sql.NullString
+	err := rows.Scan(&t.ID, &t.PublicID, &t.FromAccount, &t.ToAccount, &t.Amount.Amount, &t.Amount.Currency,
+		&creditAmount, &creditCurrency, &t.QuoteID, &t.Reference, &t.RefundOf, &t.Status, &t.FailureReason,
+		&t.CreatedAt, &t.UpdatedAt, &fromPublicID, &toPublicID, &refundOfPublicID)
+	t.FromPublicID = fromPublicID.String
+	t.ToPublicID = toPublicID.String
+	t.RefundOfPublicID = refundOfPublicID.String
+	if creditAmount.Valid {
+		t.Credit = &Money{Amount: creditAmount.Int64, Currency: creditCurrency.String}
+	}
+	t.CreatedAt = t.CreatedAt.UTC()
+	t.UpdatedAt = t.UpdatedAt.UTC()
+	return t, err
+}
+
+func (s *PostgresStorage) createLoginAttemptTable() error {
+	query := `create table if not exists login_attempt (
+		id serial primary key,
+		account_id integer,
+		number integer not null,
+		ip varchar(45) not null,
+		user_agent text not null,
+		success boolean not null,
+		created_at timestamptz not null
+	);
+	create index if not exists login_attempt_account_idx on login_attempt (account_id, created_at)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateLoginAttempt(a *LoginAttempt) error {
+	query := `insert into login_attempt
+	(account_id, number, ip, user_agent, success, created_at)
+	values ($1, $2, $3, $4, $5, $6)
+	returning id`
+
+	return s.db.QueryRow(query, a.AccountID, a.Number, a.IP, a.UserAgent, a.Success, a.CreatedAt).Scan(&a.ID)
+}
+
+func (s *PostgresStorage) GetLoginAttempts(f LoginAttemptFilter, q PageQuery) ([]*LoginAttempt, error) {
+	query := `select id, account_id, number, ip, user_agent, success, created_at from login_attempt
+	where created_at >= $1 and (created_at, id) < ($2, $3)
+		and ($4::integer is null or account_id = $4)
+		and ($5::boolean is null or success = $5)
+		and ($6 = '' or ip = $6)
+	order by created_at desc, id desc
+	limit $7`
+
+	rows, err := s.db.Query(query, q.After, q.Before, q.BeforeID, f.AccountID, f.Success, f.IP, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []*LoginAttempt{}
+	for rows.Next() {
+		a := new(LoginAttempt)
+		if err := rows.Scan(&a.ID, &a.AccountID, &a.Number, &a.IP, &a.UserAgent, &a.Success, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.CreatedAt = a.CreatedAt.UTC()
+		attempts = append(attempts, a)
+	}
+
+	return attempts, rows.Err()
+}
+
+func (s *PostgresStorage) createDeviceTables() error {
+	query := `create table if not exists device (
+		id serial primary key,
+		account_id integer not null,
+		device_id varchar(200) not null,
+		name varchar(100) not null,
+		token_hash char(64) not null,
+		created_at timestamptz not null,
+		revoked_at timestamptz,
+		last_used_at timestamptz
+	);
+	create table if not exists login_challenge (
+		id varchar(64) primary key,
+		account_id integer not null,
+		code_hash char(64) not null,
+		attempts integer not null default 0,
+		expires_at timestamptz not null,
+		used_at timestamptz
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateDevice(d *Device) error {
+	query := `insert into device
+	(account_id, device_id, name, token_hash, created_at)
+	values ($1, $2, $3, $4, $5)
+	returning id`
+
+	return s.db.QueryRow(query, d.AccountID, d.DeviceID, d.Name, d.TokenHash, d.CreatedAt).Scan(&d.ID)
+}
+
+func (s *PostgresStorage) GetDevicesByAccount(accountID int) ([]*Device, error) {
+	rows, err := s.db.Query(`select id, account_id, device_id, name, token_hash, created_at, revoked_at, last_used_at
+	from device where account_id = $1 and revoked_at is null order by id`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []*Device{}
+	for rows.Next() {
+		d := new(Device)
+		if err := rows.Scan(&d.ID, &d.AccountID, &d.DeviceID, &d.Name, &d.TokenHash,
+			&d.CreatedAt, &d.RevokedAt, &d.LastUsedAt); err != nil {
+			return nil, err
+		}
+		d.CreatedAt = d.CreatedAt.UTC()
+		devices = append(devices, d)
+	}
+
+	return devices, rows.Err()
+}
+
+func (s *PostgresStorage) RevokeDevice(accountID, id int) error {
+	res, err := s.db.Exec("update device set revoked_at = $1 where id = $2 and account_id = $3 and revoked_at is null",
+		time.Now().UTC(), id, accountID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %d", ErrDeviceNotFound, id)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) TouchDevice(id int) error {
+	_, err := s.db.Exec("update device set last_used_at = $1 where id = $2", time.Now().UTC(), id)
+	return err
+}
+
+func (s *PostgresStorage) CreateLoginChallenge(c *LoginChallenge) error {
+	_, err := s.db.Exec(`insert into login_challenge (id, account_id, code_hash, attempts, expires_at)
+	values ($1, $2, $3, $4, $5)`, c.ID, c.AccountID, c.CodeHash, c.Attempts, c.ExpiresAt)
+	return err
+}
+
+func (s *PostgresStorage) GetLoginChallenge(id string) (*LoginChallenge, error) {
+	c := new(LoginChallenge)
+	err := s.db.QueryRow(`select id, account_id, code_hash, attempts, expires_at, used_at
+	from login_challenge where id = $1`, id).Scan(&c.ID, &c.AccountID, &c.CodeHash, &c.Attempts, &c.ExpiresAt, &c.UsedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrLoginChallengeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *PostgresStorage) CountLoginChallengeAttempt(id string, maxAttempts int, now time.Time) (*LoginChallenge, error) {
+	c := new(LoginChallenge)
+	err := s.db.QueryRow(`update login_challenge set attempts = attempts + 1
+	where id = $1 and attempts < $2 and used_at is null and expires_at > $3
+	returning id, account_id, code_hash, attempts, expires_at, used_at`, id, maxAttempts, now).
+		Scan(&c.ID, &c.AccountID, &c.CodeHash, &c.Attempts, &c.ExpiresAt, &c.UsedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrLoginChallengeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *PostgresStorage) UseLoginChallenge(id string, now time.Time) error {
+	res, err := s.db.Exec("update login_challenge set used_at = $1 where id = $2 and used_at is null", now, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLoginChallengeNotFound
+	}
+	return nil
+}
+
+// GetReconciliationReport totals balances and transfers. Transfers still
+// processing since before stuckBefore are listed individually.
+func (s *PostgresStorage) GetReconciliationReport(stuckBefore time.Time) (*ReconciliationReport, error) {
+	report := &ReconciliationReport{
+		GeneratedAt:    time.Now().UTC(),
+		Balances:       []BalanceTotals{},
+		Transfers:      []TransferTotals{},
+		StuckTransfers: []TransferWithIDs{},
+	}
+
+	rows, err := s.db.Query(`select currency, count(*), coalesce(sum(balance), 0), count(*) filter (where balance < 0)
+	from account where system_kind = '' group by currency order by currency`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var b BalanceTotals
+		if err := rows.Scan(&b.Currency, &b.Accounts, &b.Total.Amount, &b.NegativeBalances); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		b.Total.Currency = b.Currency
+		report.Balances = append(report.Balances, b)
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`select status, currency, count(*), sum(amount)
+	from transfer group by status, currency order by status, currency`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var t TransferTotals
+		if err := rows.Scan(&t.Status, &t.Total.Currency, &t.Count, &t.Total.Amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.Transfers = append(report.Transfers, t)
+	}
+	rows.Close()
+
+	rows, err = s.db.Query("select "+transferColumns+" from transfer where status = $1 and updated_at < $2 order by id",
+		TransferProcessing, stuckBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t, err := scanIntoTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		report.StuckTransfers = append(report.StuckTransfers, withInternalIDs(t))
+	}
+
+	return report, rows.Err()
+}
+
+func (s *PostgresStorage) createCaptureTable() error {
+	query := `create table if not exists capture (
+		id serial primary key,
+		method varchar(10) not null,
+		path text not null,
+		query text not null,
+		request_headers jsonb not null,
+		request_body text not null,
+		status integer not null,
+		response_headers jsonb not null,
+		response_body text not null,
+		duration_ms bigint not null,
+		created_at timestamptz not null
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateCapture(c *CapturedExchange) error {
+	reqHeaders, err := json.Marshal(c.RequestHeaders)
+	if err != nil {
+		return err
+	}
+	respHeaders, err := json.Marshal(c.ResponseHeaders)
+	if err != nil {
+		return err
+	}
+
+	query := `insert into capture
+	(method, path, query, request_headers, request_body, status, response_headers, response_body, duration_ms, created_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	returning id`
+
+	return s.db.QueryRow(query, c.Method, c.Path, c.Query, reqHeaders, c.RequestBody, c.Status,
+		respHeaders, c.ResponseBody, c.DurationMs, c.CreatedAt).Scan(&c.ID)
+}
+
+func (s *PostgresStorage) GetCapture(id int) (*CapturedExchange, error) {
+	rows, err := s.db.Query("select "+captureColumns+" from capture where id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoCapture(rows)
+	}
+
+	return nil, fmt.Errorf("%w: %d", ErrCaptureNotFound, id)
+}
+
+func (s *PostgresStorage) GetCaptures(limit int) ([]*CapturedExchange, error) {
+	rows, err := s.db.Query("select "+captureColumns+" from capture order by id desc limit $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	captures := []*CapturedExchange{}
+	for rows.Next() {
+		c, err := scanIntoCapture(rows)
+		if err != nil {
+			return nil, err
+		}
+		captures = append(captures, c)
+	}
+
+	return captures, rows.Err()
+}
+
+const captureColumns = "id, method, path, query, request_headers, request_body, status, response_headers, response_body, duration_ms, created_at"
+
+func scanIntoCapture(rows *sql.Rows) (*CapturedExchange, error) {
+	c := new(CapturedExchange)
+	var reqHeaders, respHeaders []byte
+	if err := rows.Scan(&c.ID, &c.Method, &c.Path, &c.Query, &reqHeaders, &c.RequestBody, &c.Status,
+		&respHeaders, &c.ResponseBody, &c.DurationMs, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(reqHeaders, &c.RequestHeaders); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(respHeaders, &c.ResponseHeaders); err != nil {
+		return nil, err
+	}
+	c.CreatedAt = c.CreatedAt.UTC()
+	return c, nil
+}
+
+func (s *PostgresStorage) createCashOperationTable() error {
+	query := `create table if not exists cash_operation (
+		id serial primary key,
+		public_id char(26) unique not null,
+		account_id integer not null,
+		terminal_id varchar(100) not null,
+		kind varchar(20) not null,
+		amount bigint not null,
+		currency char(3) not null,
+		status varchar(20) not null,
+		failure_reason text not null default '',
+		created_at timestamptz not null
+	);
+	create index if not exists cash_operation_account_idx on cash_operation (account_id, created_at)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// ExecuteCashOperation applies a deposit or withdrawal to the account
+// balance and records it, rejected or not, in one database transaction.
+func (s *PostgresStorage) ExecuteCashOperation(op *CashOperation, dailyLimit Money) error {
+	return s.serializable(func(tx *sql.Tx) error {
+		var balance Money
+		if err := tx.QueryRow("select balance, currency from account where id = $1 for update", op.AccountID).
+			Scan(&balance.Amount, &balance.Currency); err != nil {
+			return err
+		}
+
+		withdrawn := NewMoney(0, op.Amount.Currency)
+		if err := tx.QueryRow(`select coalesce(sum(amount), 0) from cash_operation
+		where account_id = $1 and kind = $2 and status = $3 and currency = $4 and created_at >= $5`,
+			op.AccountID, CashWithdrawal, CashCompleted, op.Amount.Currency, startOfDay(op.CreatedAt)).
+			Scan(&withdrawn.Amount); err != nil {
+			return err
+		}
+
+		newBalance, reason := applyCashOperation(balance, op, withdrawn, dailyLimit)
+		if reason != "" {
+			op.Status, op.FailureReason = CashRejected, reason
+		} else {
+			op.Status = CashCompleted
+			if _, err := tx.Exec("update account set balance = $1 where id = $2", newBalance.Amount, op.AccountID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.QueryRow(`insert into cash_operation
+		(public_id, account_id, terminal_id, kind, amount, currency, status, failure_reason, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		returning id`, op.PublicID, op.AccountID, op.TerminalID, op.Kind, op.Amount.Amount, op.Amount.Currency,
+			op.Status, op.FailureReason, op.CreatedAt).Scan(&op.ID); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (s *PostgresStorage) GetCashOperations(accountID int, q PageQuery) ([]*CashOperation, error) {
+	query := `select id, public_id, account_id, terminal_id, kind, amount, currency, status, failure_reason, created_at
+	from cash_operation
+	where account_id = $1 and created_at >= $2 and (created_at, id) < ($3, $4)
+	order by created_at desc, id desc
+	limit $5`
+
+	rows, err := s.db.Query(query, accountID, q.After, q.Before, q.BeforeID, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ops := []*CashOperation{}
+	for rows.Next() {
+		op := new(CashOperation)
+		if err := rows.Scan(&op.ID, &op.PublicID, &op.AccountID, &op.TerminalID, &op.Kind, &op.Amount.Amount,
+			&op.Amount.Currency, &op.Status, &op.FailureReason, &op.CreatedAt); err != nil {
+			return nil, err
+		}
+		op.CreatedAt = op.CreatedAt.UTC()
+		ops = append(ops, op)
+	}
+
+	return ops, rows.Err()
+}
+
+func (s *PostgresStorage) createBillPayTables() error {
+	query := `create table if not exists payee (
+		id serial primary key,
+		account_id integer not null,
+		biller_name varchar(100) not null,
+		reference varchar(100) not null,
+		nickname varchar(100) not null default '',
+		created_at timestamptz not null
+	);
+	create table if not exists bill_payment (
+		id serial primary key,
+		public_id char(26) unique not null,
+		account_id integer not null,
+		payee_id integer not null,
+		amount bigint not null,
+		currency char(3) not null,
+		recurrence varchar(20) not null default '',
+		scheduled_for timestamptz not null,
+		status varchar(20) not null,
+		failure_reason text not null default '',
+		created_at timestamptz not null,
+		updated_at timestamptz not null
+	);
+	create index if not exists bill_payment_due_idx on bill_payment (status, scheduled_for)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreatePayee(p *Payee) error {
+	query := `insert into payee (account_id, biller_name, reference, nickname, created_at)
+	values ($1, $2, $3, $4, $5)
+	returning id`
+
+	return s.db.QueryRow(query, p.AccountID, p.BillerName, p.Reference, p.Nickname, p.CreatedAt).Scan(&p.ID)
+}
+
+func (s *PostgresStorage) GetPayee(accountID, id int) (*Payee, error) {
+	p := new(Payee)
+	err := s.db.QueryRow(`select id, account_id, biller_name, reference, nickname, created_at
+	from payee where id = $1 and account_id = $2`, id, accountID).
+		Scan(&p.ID, &p.AccountID, &p.BillerName, &p.Reference, &p.Nickname, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrPayeeNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.CreatedAt = p.CreatedAt.UTC()
+	return p, nil
+}
+
+func (s *PostgresStorage) GetPayees(accountID int) ([]*Payee, error) {
+	rows, err := s.db.Query(`select id, account_id, biller_name, reference, nickname, created_at
+	from payee where account_id = $1 order by id`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payees := []*Payee{}
+	for rows.Next() {
+		p := new(Payee)
+		if err := rows.Scan(&p.ID, &p.AccountID, &p.BillerName, &p.Reference, &p.Nickname, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		p.CreatedAt = p.CreatedAt.UTC()
+		payees = append(payees, p)
+	}
+
+	return payees, rows.Err()
+}
+
+// DeletePayee removes the payee and cancels its scheduled bill payments.
+// Payments already sent are left to the biller rail.
+func (s *PostgresStorage) DeletePayee(accountID, id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("delete from payee where id = $1 and account_id = $2", id, accountID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %d", ErrPayeeNotFound, id)
+	}
+
+	if _, err := tx.Exec("update bill_payment set status = $1, updated_at = $2 where payee_id = $3 and status in ($4, $5)",
+		BillPaymentCancelled, time.Now().UTC(), id, BillPaymentScheduled, BillPaymentPaused); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const billPaymentColumns = `id, public_id, account_id, payee_id, amount, currency, recurrence,
+	scheduled_for, status, failure_reason, created_at, updated_at`
+
+func (s *PostgresStorage) CreateBillPayment(p *BillPayment) error {
+	return insertBillPayment(s.db, p)
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func insertBillPayment(db queryRower, p *BillPayment) error {
+	query := `insert into bill_payment
+	(public_id, account_id, payee_id, amount, currency, recurrence, scheduled_for, status, failure_reason, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	returning id`
+
+	return db.QueryRow(query, p.PublicID, p.AccountID, p.PayeeID, p.Amount.Amount, p.Amount.Currency, p.Recurrence,
+		p.ScheduledFor, p.Status, p.FailureReason, p.CreatedAt, p.UpdatedAt).Scan(&p.ID)
+}
+
+func (s *PostgresStorage) GetBillPayments(accountID int) ([]*BillPayment, error) {
+	rows, err := s.db.Query("select "+billPaymentColumns+` from bill_payment
+	where account_id = $1 order by scheduled_for desc, id desc`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return scanBillPayments(rows)
+}
+
+func (s *PostgresStorage) GetBillPaymentsByStatus(status BillPaymentStatus, updatedBefore time.Time) ([]*BillPayment, error) {
+	rows, err := s.db.Query("select "+billPaymentColumns+` from bill_payment
+	where status = $1 and updated_at < $2 order by id`, status, updatedBefore)
+	if err != nil {
+		return nil, err
+	}
+	return scanBillPayments(rows)
+}
+
+func (s *PostgresStorage) CancelBillPayment(accountID, id int) error {
+	res, err := s.db.Exec(`update bill_payment set status = $1, updated_at = $2
+	where id = $3 and account_id = $4 and status in ($5, $6)`,
+		BillPaymentCancelled, time.Now().UTC(), id, accountID, BillPaymentScheduled, BillPaymentPaused)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %d", ErrBillPaymentNotFound, id)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) UpdateStandingOrder(accountID, id int, action StandingOrderAction, now time.Time) (*BillPayment, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("select "+billPaymentColumns+` from bill_payment
+	where id = $1 and account_id = $2
+	for update`, id, accountID)
+	if err != nil {
+		return nil, err
+	}
+	payments, err := scanBillPayments(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrBillPaymentNotFound, id)
+	}
+	p := payments[0]
+
+	next, err := p.applyStandingOrderAction(action, now)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("update bill_payment set status = $1, scheduled_for = $2, updated_at = $3 where id = $4",
+		p.Status, p.ScheduledFor, p.UpdatedAt, p.ID); err != nil {
+		return nil, err
+	}
+	if next != nil {
+		if err := insertBillPayment(tx, next); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, tx.Commit()
+}
+
+// SendDueBillPayment debits the oldest payment due by now in a currency
+// that isn't closed and marks it sent, or failed when the account can't
+// cover it, and schedules the next occurrence of a recurring payment. It
+// returns nil when nothing is due.
+func (s *PostgresStorage) SendDueBillPayment(now time.Time, closedCurrencies []string) (*BillPayment, error) {
+	var sent *BillPayment
+	err := s.serializable(func(tx *sql.Tx) error {
+		sent = nil
+
+		rows, err := tx.Query("select "+billPaymentColumns+` from bill_payment
+		where status = $1 and scheduled_for <= $2 and not (currency = any($3))
+		order by scheduled_for, id
+		limit 1
+		for update skip locked`, BillPaymentScheduled, now, pq.Array(closedCurrencies))
+		if err != nil {
+			return err
+		}
+		payments, err := scanBillPayments(rows)
+		if err != nil || len(payments) == 0 {
+			return err
+		}
+		p := payments[0]
+
+		var balance Money
+		if err := tx.QueryRow("select balance, currency from account where id = $1 for update", p.AccountID).
+			Scan(&balance.Amount, &balance.Currency); err != nil {
+			return err
+		}
+
+		newBalance, reason := debitBillPayment(balance, p.Amount)
+		if reason != "" {
+			p.Status, p.FailureReason = BillPaymentFailed, reason
+		} else {
+			p.Status = BillPaymentSent
+			if _, err := tx.Exec("update account set balance = $1 where id = $2", newBalance.Amount, p.AccountID); err != nil {
+				return err
+			}
+		}
+		p.UpdatedAt = now
+
+		if _, err := tx.Exec("update bill_payment set status = $1, failure_reason = $2, updated_at = $3 where id = $4",
+			p.Status, p.FailureReason, p.UpdatedAt, p.ID); err != nil {
+			return err
+		}
+
+		if next := p.nextOccurrence(); next != nil {
+			if err := insertBillPayment(tx, next); err != nil {
+				return err
+			}
+		}
+
+		sent = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sent, nil
+}
+
+func (s *PostgresStorage) ConfirmBillPayment(p *BillPayment) error {
+	p.Status, p.UpdatedAt = BillPaymentConfirmed, time.Now().UTC()
+	_, err := s.db.Exec("update bill_payment set status = $1, updated_at = $2 where id = $3 and status = $4",
+		p.Status, p.UpdatedAt, p.ID, BillPaymentSent)
+	return err
+}
+
+// RefundBillPayment credits a sent payment back to the account and marks
+// it failed.
+func (s *PostgresStorage) RefundBillPayment(p *BillPayment, reason string) error {
+	err := s.serializable(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`update bill_payment set status = $1, failure_reason = $2, updated_at = $3
+		where id = $4 and status = $5`, BillPaymentFailed, reason, time.Now().UTC(), p.ID, BillPaymentSent)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("%w: bill payment %d is not awaiting confirmation", ErrStateConflict, p.ID)
+		}
+
+		if _, err := tx.Exec("update account set balance = balance + $1 where id = $2 and currency = $3",
+			p.Amount.Amount, p.AccountID, p.Amount.Currency); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.Status, p.FailureReason = BillPaymentFailed, reason
+	return nil
+}
+
+func scanBillPayments(rows *sql.Rows) ([]*BillPayment, error) {
+	defer rows.Close()
+
+	payments := []*BillPayment{}
+	for rows.Next() {
+		p := new(BillPayment)
+		if err := rows.Scan(&p.ID, &p.PublicID, &p.AccountID, &p.PayeeID, &p.Amount.Amount, &p.Amount.Currency,
+			&p.Recurrence, &p.ScheduledFor, &p.Status, &p.FailureReason, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		p.ScheduledFor = p.ScheduledFor.UTC()
+		p.CreatedAt = p.CreatedAt.UTC()
+		p.UpdatedAt = p.UpdatedAt.UTC()
+		payments = append(payments, p)
+	}
+
+	return payments, rows.Err()
+}
+
+func (s *PostgresStorage) createInvoiceTable() error {
+	query := `create table if not exists invoice (
+		id serial primary key,
+		public_id char(26) unique not null,
+		account_id integer not null,
+		customer_name varchar(100) not null,
+		customer_email varchar(254) not null default '',
+		line_items jsonb not null,
+		total bigint not null,
+		currency char(3) not null,
+		due_date timestamptz not null,
+		status varchar(20) not null,
+		paid_by_transfer integer,
+		paid_at timestamptz,
+		created_at timestamptz not null
+	);
+	create index if not exists invoice_account_idx on invoice (account_id, created_at)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateInvoice(inv *Invoice) error {
+	items, err := json.Marshal(inv.LineItems)
+	if err != nil {
+		return err
+	}
+
+	query := `insert into invoice
+	(public_id, account_id, customer_name, customer_email, line_items, total, currency, due_date, status, created_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	returning id`
+
+	return s.db.QueryRow(query, inv.PublicID, inv.AccountID, inv.CustomerName, inv.CustomerEmail, items,
+		inv.Total.Amount, inv.Total.Currency, inv.DueDate, inv.Status, inv.CreatedAt).Scan(&inv.ID)
+}
+
+func (s *PostgresStorage) GetInvoices(accountID int) ([]*Invoice, error) {
+	rows, err := s.db.Query("select "+invoiceColumns+" from invoice where account_id = $1 order by created_at desc, id desc",
+		accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invoices := []*Invoice{}
+	for rows.Next() {
+		inv, err := scanIntoInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+
+	return invoices, rows.Err()
+}
+
+func (s *PostgresStorage) GetInvoiceByPublicID(publicID string) (*Invoice, error) {
+	rows, err := s.db.Query("select "+invoiceColumns+" from invoice where public_id = $1", publicID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoInvoice(rows)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrInvoiceNotFound, publicID)
+}
+
+const invoiceColumns = `id, public_id, account_id, customer_name, customer_email, line_items, total, currency,
+	due_date, status, paid_by_transfer, paid_at, created_at`
+
+func scanIntoInvoice(rows *sql.Rows) (*Invoice, error) {
+	inv := new(Invoice)
+	var items []byte
+	var paidBy sql.NullInt64
+	var paidAt sql.NullTime
+	if err := rows.Scan(&inv.ID, &inv.PublicID, &inv.AccountID, &inv.CustomerName, &inv.CustomerEmail, &items,
+		&inv.Total.Amount, &inv.Total.Currency, &inv.DueDate, &inv.Status, &paidBy, &paidAt, &inv.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(items, &inv.LineItems); err != nil {
+		return nil, err
+	}
+	if paidBy.Valid {
+		id := int(paidBy.Int64)
+		inv.PaidByTransfer = &id
+	}
+	if paidAt.Valid {
+		t := paidAt.Time.UTC()
+		inv.PaidAt = &t
+	}
+	inv.DueDate = inv.DueDate.UTC()
+	inv.CreatedAt = inv.CreatedAt.UTC()
+	return inv, nil
+}
+
+func (s *PostgresStorage) createAlertRuleTable() error {
+	query := `create table if not exists alert_rule (
+		id serial primary key,
+		account_id integer not null,
+		kind varchar(30) not null,
+		threshold bigint,
+		currency char(3) not null,
+		created_at timestamptz not null
+	);
+	create index if not exists alert_rule_account_idx on alert_rule (account_id)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// alertRuleValues flattens a rule into its threshold and currency columns.
+func alertRuleValues(a *AlertRule) (sql.NullInt64, string) {
+	if a.Threshold == nil {
+		return sql.NullInt64{}, a.Currency
+	}
+	return sql.NullInt64{Int64: a.Threshold.Amount, Valid: true}, a.Threshold.Currency
+}
+
+func (s *PostgresStorage) CreateAlertRule(a *AlertRule) error {
+	threshold, currency := alertRuleValues(a)
+	query := `insert into alert_rule (account_id, kind, threshold, currency, created_at)
+	values ($1, $2, $3, $4, $5)
+	returning id`
+
+	return s.db.QueryRow(query, a.AccountID, a.Kind, threshold, currency, a.CreatedAt).Scan(&a.ID)
+}
+
+func (s *PostgresStorage) GetAlertRule(accountID, id int) (*AlertRule, error) {
+	rules, err := s.queryAlertRules("where id = $1 and account_id = $2", id, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrAlertRuleNotFound, id)
+	}
+	return rules[0], nil
+}
+
+func (s *PostgresStorage) GetAlertRules(accountID int) ([]*AlertRule, error) {
+	return s.queryAlertRules("where account_id = $1 order by id", accountID)
+}
+
+func (s *PostgresStorage) queryAlertRules(where string, args ...any) ([]*AlertRule, error) {
+	rows, err := s.db.Query("select id, account_id, kind, threshold, currency, created_at from alert_rule "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*AlertRule{}
+	for rows.Next() {
+		a := new(AlertRule)
+		var threshold sql.NullInt64
+		var currency string
+		if err := rows.Scan(&a.ID, &a.AccountID, &a.Kind, &threshold, &currency, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if threshold.Valid {
+			a.Threshold = &Money{Amount: threshold.Int64, Currency: currency}
+		} else {
+			a.Currency = currency
+		}
+		a.CreatedAt = a.CreatedAt.UTC()
+		rules = append(rules, a)
+	}
+
+	return rules, rows.Err()
+}
+
+func (s *PostgresStorage) UpdateAlertRule(a *AlertRule) error {
+	threshold, currency := alertRuleValues(a)
+	_, err := s.db.Exec("update alert_rule set kind = $1, threshold = $2, currency = $3 where id = $4 and account_id = $5",
+		a.Kind, threshold, currency, a.ID, a.AccountID)
+	return err
+}
+
+func (s *PostgresStorage) DeleteAlertRule(accountID, id int) error {
+	_, err := s.db.Exec("delete from alert_rule where id = $1 and account_id = $2", id, accountID)
+	return err
+}
+
+func (s *PostgresStorage) GetAccountsByPhone(phones []string) ([]*Account, error) {
+	rows, err := s.db.Query("select "+accountColumns+" from account where phone <> '' and phone = any($1) and deleted_at is null",
+		pq.Array(phones))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
+
+// GetAccountsByName returns the customer accounts whose name normalizes to
+// normalized, see normalizeName.
+func (s *PostgresStorage) GetAccountsByName(normalized string) ([]*Account, error) {
+	rows, err := s.db.Query("select "+accountColumns+` from account
+	where lower(regexp_replace(trim(first_name || ' ' || last_name), '\s+', ' ', 'g')) = $1
+	and system_kind = '' and deleted_at is null`, normalized)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*Account{}
+	for rows.Next() {
+		account, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
+
+func (s *PostgresStorage) createContactTable() error {
+	query := `create table if not exists contact (
+		id serial primary key,
+		account_id integer not null,
+		name varchar(100) not null,
+		account_number integer not null default 0,
+		alias varchar(100) not null default '',
+		phone varchar(16) not null default '',
+		avatar_url text not null default '',
+		created_at timestamptz not null
+	);
+	create index if not exists contact_account_idx on contact (account_id)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateContact(c *Contact) error {
+	query := `insert into contact (account_id, name, account_number, alias, phone, avatar_url, created_at)
+	values ($1, $2, $3, $4, $5, $6, $7)
+	returning id`
+
+	return s.db.QueryRow(query, c.AccountID, c.Name, c.AccountNumber, c.Alias, c.Phone, c.AvatarURL, c.CreatedAt).
+		Scan(&c.ID)
+}
+
+func (s *PostgresStorage) GetContact(accountID, id int) (*Contact, error) {
+	contacts, err := s.queryContacts("where id = $1 and account_id = $2", id, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if len(contacts) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrContactNotFound, id)
+	}
+	return contacts[0], nil
+}
+
+func (s *PostgresStorage) GetContacts(accountID int) ([]*Contact, error) {
+	return s.queryContacts("where account_id = $1 order by name, id", accountID)
+}
+
+func (s *PostgresStorage) queryContacts(where string, args ...any) ([]*Contact, error) {
+	rows, err := s.db.Query(`select id, account_id, name, account_number, alias, phone, avatar_url, created_at
+	from contact `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []*Contact{}
+	for rows.Next() {
+		c := new(Contact)
+		if err := rows.Scan(&c.ID, &c.AccountID, &c.Name, &c.AccountNumber, &c.Alias, &c.Phone, &c.AvatarURL,
+			&c.CreatedAt); err != nil {
+			return nil, err
+		}
+		c.CreatedAt = c.CreatedAt.UTC()
+		contacts = append(contacts, c)
+	}
+
+	return contacts, rows.Err()
+}
+
+func (s *PostgresStorage) UpdateContact(c *Contact) error {
+	_, err := s.db.Exec(`update contact set name = $1, account_number = $2, alias = $3, phone = $4, avatar_url = $5
+	where id = $6 and account_id = $7`, c.Name, c.AccountNumber, c.Alias, c.Phone, c.AvatarURL, c.ID, c.AccountID)
+	return err
+}
+
+func (s *PostgresStorage) DeleteContact(accountID, id int) error {
+	_, err := s.db.Exec("delete from contact where id = $1 and account_id = $2", id, accountID)
+	return err
+}
+
+func (s *PostgresStorage) createChequeTable() error {
+	query := `create table if not exists cheque (
+		id serial primary key,
+		public_id char(26) unique not null,
+		account_id integer not null,
+		image_ref text not null,
+		issuing_account varchar(100) not null,
+		amount bigint not null,
+		currency char(3) not null,
+		status varchar(20) not null,
+		hold_reason text not null default '',
+		failure_reason text not null default '',
+		available_at timestamptz not null,
+		created_at timestamptz not null,
+		updated_at timestamptz not null
+	);
+	create index if not exists cheque_due_idx on cheque (status, available_at)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateCheque(c *Cheque) error {
+	query := `insert into cheque
+	(public_id, account_id, image_ref, issuing_account, amount, currency, status, hold_reason, failure_reason,
+		available_at, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	returning id`
+
+	return s.db.QueryRow(query, c.PublicID, c.AccountID, c.ImageRef, c.IssuingAccount, c.Amount.Amount,
+		c.Amount.Currency, c.Status, c.HoldReason, c.FailureReason, c.AvailableAt, c.CreatedAt, c.UpdatedAt).Scan(&c.ID)
+}
+
+func (s *PostgresStorage) GetCheques(accountID int) ([]*Cheque, error) {
+	return s.queryCheques("where account_id = $1 order by created_at desc, id desc", accountID)
+}
+
+func (s *PostgresStorage) GetDueCheques(now time.Time) ([]*Cheque, error) {
+	return s.queryCheques("where status = $1 and available_at <= $2 order by available_at, id", ChequePending, now)
+}
+
+func (s *PostgresStorage) queryCheques(where string, args ...any) ([]*Cheque, error) {
+	rows, err := s.db.Query(`select id, public_id, account_id, image_ref, issuing_account, amount, currency, status,
+		hold_reason, failure_reason, available_at, created_at, updated_at
+	from cheque `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cheques := []*Cheque{}
+	for rows.Next() {
+		c := new(Cheque)
+		if err := rows.Scan(&c.ID, &c.PublicID, &c.AccountID, &c.ImageRef, &c.IssuingAccount, &c.Amount.Amount,
+			&c.Amount.Currency, &c.Status, &c.HoldReason, &c.FailureReason, &c.AvailableAt, &c.CreatedAt,
+			&c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		c.AvailableAt = c.AvailableAt.UTC()
+		c.CreatedAt = c.CreatedAt.UTC()
+		c.UpdatedAt = c.UpdatedAt.UTC()
+		cheques = append(cheques, c)
+	}
+
+	return cheques, rows.Err()
+}
+
+// ClearCheque credits a pending cheque to its account. Cheques that were
+// settled in the meantime are reported as an error and left alone.
+func (s *PostgresStorage) ClearCheque(c *Cheque) error {
+	now := time.Now().UTC()
+	err := s.serializable(func(tx *sql.Tx) error {
+		res, err := tx.Exec("update cheque set status = $1, updated_at = $2 where id = $3 and status = $4",
+			ChequeCleared, now, c.ID, ChequePending)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("%w: cheque %d is not pending", ErrStateConflict, c.ID)
+		}
+
+		if _, err := tx.Exec("update account set balance = balance + $1 where id = $2 and currency = $3",
+			c.Amount.Amount, c.AccountID, c.Amount.Currency); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.Status, c.UpdatedAt = ChequeCleared, now
+	return nil
+}
+
+func (s *PostgresStorage) BounceCheque(c *Cheque, reason string) error {
+	now := time.Now().UTC()
+	res, err := s.db.Exec(`update cheque set status = $1, failure_reason = $2, updated_at = $3
+	where id = $4 and status = $5`, ChequeBounced, reason, now, c.ID, ChequePending)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: cheque %d is not pending", ErrStateConflict, c.ID)
+	}
+
+	c.Status, c.FailureReason, c.UpdatedAt = ChequeBounced, reason, now
+	return nil
+}
+
+func (s *PostgresStorage) createAuditEventTable() error {
+	query := `create table if not exists audit_event (
+		id serial primary key,
+		actor varchar(100) not null,
+		action varchar(100) not null,
+		account_id integer,
+		details jsonb not null default '{}',
+		created_at timestamptz not null
+	);
+	create index if not exists audit_event_account_idx on audit_event (account_id, created_at)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateAuditEvent(e *AuditEvent) error {
+	return insertAuditEvent(s.db, e)
+}
+
+func insertAuditEvent(db queryRower, e *AuditEvent) error {
+	details, err := json.Marshal(e.Details)
+	if err != nil {
+		return err
+	}
+
+	var accountID sql.NullInt64
+	if e.AccountID != 0 {
+		accountID = sql.NullInt64{Int64: int64(e.AccountID), Valid: true}
+	}
+
+	return db.QueryRow(`insert into audit_event (actor, action, account_id, details, created_at)
+	values ($1, $2, $3, $4, $5)
+	returning id`, e.Actor, e.Action, accountID, details, e.CreatedAt).Scan(&e.ID)
+}
+
+// GetAuditEvents returns the newest events first, for one account or, with
+// accountID 0, for all of them.
+func (s *PostgresStorage) GetAuditEvents(accountID, limit int) ([]*AuditEvent, error) {
+	rows, err := s.db.Query(`select id, actor, action, account_id, details, created_at from audit_event
+	where $1 = 0 or account_id = $1
+	order by created_at desc, id desc
+	limit $2`, accountID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEvents(rows)
+}
+
+func (s *PostgresStorage) GetAccountAuditEvents(accountID int, actions []string, q PageQuery) ([]*AuditEvent, error) {
+	rows, err := s.db.Query(`select id, actor, action, account_id, details, created_at from audit_event
+	where account_id = $1 and action = any($2) and created_at >= $3 and (created_at, id) < ($4, $5)
+	order by created_at desc, id desc
+	limit $6`, accountID, pq.Array(actions), q.After, q.Before, q.BeforeID, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEvents(rows)
+}
+
+func scanAuditEvents(rows *sql.Rows) ([]*AuditEvent, error) {
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		e := new(AuditEvent)
+		var accountID sql.NullInt64
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &accountID, &details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(details, &e.Details); err != nil {
+			return nil, err
+		}
+		e.AccountID = int(accountID.Int64)
+		e.CreatedAt = e.CreatedAt.UTC()
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// TransferAccountOwnership stores the account's new holder details, signs
+// out its sessions, revokes its trusted devices and audits the change in
+// one database transaction.
+func (s *PostgresStorage) TransferAccountOwnership(account *Account, event *AuditEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`update account
+	set first_name = $1, last_name = $2, phone = $3, encrypted_password = $4, token_version = token_version + 1
+	where id = $5
+	returning token_version`, account.FirstName, account.LastName, account.Phone, account.EncryptedPassword,
+		account.ID).Scan(&account.TokenVersion); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("update device set revoked_at = $1 where account_id = $2 and revoked_at is null",
+		event.CreatedAt, account.ID); err != nil {
+		return err
+	}
+
+	if err := insertAuditEvent(tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) createAccountRedirectTable() error {
+	query := `create table if not exists account_redirect (
+		account_id integer primary key,
+		number integer not null,
+		phone varchar(16) not null default '',
+		successor_id integer not null,
+		successor_number integer not null,
+		mode varchar(10) not null,
+		created_at timestamptz not null,
+		expires_at timestamptz not null
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// CloseAccountWithRedirect closes the account, records its redirect and
+// moves its phone number to the successor in one transaction.
+func (s *PostgresStorage) CloseAccountWithRedirect(r *AccountRedirect, event *AuditEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("update account set deleted_at = $1 where id = $2 and deleted_at is null", r.CreatedAt, r.AccountID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %d", ErrAccountNotFound, r.AccountID)
+	}
+
+	if _, err := tx.Exec(`insert into account_redirect
+	(account_id, number, phone, successor_id, successor_number, mode, created_at, expires_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		r.AccountID, r.Number, r.Phone, r.SuccessorID, r.SuccessorNumber, r.Mode, r.CreatedAt, r.ExpiresAt); err != nil {
+		return err
+	}
+
+	if r.Phone != "" {
+		if _, err := tx.Exec("update account set phone = $1 where id = $2 and phone = ''", r.Phone, r.SuccessorID); err != nil {
+			return mapConstraintError(err)
+		}
+	}
+
+	if err := insertAuditEvent(tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) GetAccountRedirect(accountID int) (*AccountRedirect, error) {
+	r := new(AccountRedirect)
+	err := s.db.QueryRow(`select account_id, number, phone, successor_id, successor_number, mode, created_at, expires_at
+	from account_redirect where account_id = $1`, accountID).Scan(
+		&r.AccountID, &r.Number, &r.Phone, &r.SuccessorID, &r.SuccessorNumber, &r.Mode, &r.CreatedAt, &r.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrAccountNotFound, accountID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.CreatedAt, r.ExpiresAt = r.CreatedAt.UTC(), r.ExpiresAt.UTC()
+	return r, nil
+}
+
+// createBalanceEntryTable journals account balances with a trigger, so
+// every statement changing a balance is covered without having to record
+// it. Accounts that predate the journal start with their current balance.
+func (s *PostgresStorage) createBalanceEntryTable() error {
+	query := `create table if not exists balance_entry (
+		id bigserial primary key,
+		account_id integer not null,
+		balance bigint not null,
+		currency char(3) not null,
+		recorded_at timestamptz not null
+	);
+	create index if not exists balance_entry_account_idx on balance_entry (account_id, recorded_at);
+
+	create or replace function record_balance_entry() returns trigger as $$
+	begin
+		if tg_op = 'UPDATE' and old.balance = new.balance and old.currency = new.currency then
+			return new;
+		end if;
+		insert into balance_entry (account_id, balance, currency, recorded_at)
+		values (new.id, new.balance, new.currency, clock_timestamp());
+		return new;
+	end
+	$$ language plpgsql;
+
+	drop trigger if exists account_balance_entry on account;
+	create trigger account_balance_entry after insert or update of balance, currency on account
+		for each row execute function record_balance_entry();
+
+	insert into balance_entry (account_id, balance, currency, recorded_at)
+	select id, balance, currency, now() from account a
+	where not exists (select 1 from balance_entry e where e.account_id = a.id)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// GetBalanceAt returns the last balance entry of the account recorded at
+// or before at.
+func (s *PostgresStorage) GetBalanceAt(accountID int, at time.Time) (*BalanceEntry, error) {
+	e := new(BalanceEntry)
+	err := s.db.QueryRow(`select account_id, balance, currency, recorded_at from balance_entry
+	where account_id = $1 and recorded_at <= $2
+	order by recorded_at desc, id desc limit 1`, accountID, at).Scan(
+		&e.AccountID, &e.Balance.Amount, &e.Balance.Currency, &e.RecordedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: account %d", ErrBalanceHistoryNotFound, accountID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.RecordedAt = e.RecordedAt.UTC()
+	return e, nil
+}
+
+func (s *PostgresStorage) createIdempotencyKeyTable() error {
+	query := `create table if not exists idempotency_key (
+		account_id integer not null,
+		key varchar(255) not null,
+		transfer_id integer not null,
+		created_at timestamptz not null,
+		primary key (account_id, key)
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// GetIdempotentTransfer returns the transfer the account created with key,
+// or nil when the key is new.
+func (s *PostgresStorage) GetIdempotentTransfer(accountID int, key string) (*Transfer, error) {
+	rows, err := s.db.Query("select "+transferColumns+` from transfer
+	where id = (select transfer_id from idempotency_key where account_id = $1 and key = $2)`, accountID, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoTransfer(rows)
+	}
+	return nil, rows.Err()
+}
+
+func (s *PostgresStorage) SaveIdempotencyKey(k *IdempotencyKey) error {
+	_, err := s.db.Exec("insert into idempotency_key (account_id, key, transfer_id, created_at) values ($1, $2, $3, $4)",
+		k.AccountID, k.Key, k.TransferID, k.CreatedAt)
+	return err
+}
+
+func (s *PostgresStorage) createTellerApprovalTable() error {
+	query := `create table if not exists teller_approval (
+		id serial primary key,
+		public_id char(26) unique not null,
+		account_id integer not null,
+		kind varchar(20) not null,
+		amount bigint not null,
+		currency char(3) not null,
+		reason text not null default '',
+		requested_by varchar(100) not null,
+		status varchar(10) not null,
+		decided_by varchar(100) not null default '',
+		decided_at timestamptz,
+		operation_id varchar(26) not null default '',
+		created_at timestamptz not null
+	);
+	create index if not exists teller_approval_pending_idx on teller_approval (created_at) where status = 'pending'`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+const tellerApprovalColumns = `id, public_id, account_id, kind, amount, currency, reason, requested_by, status,
+	decided_by, decided_at, operation_id, created_at`
+
+func scanIntoTellerApproval(rows *sql.Rows) (*TellerApproval, error) {
+	a := new(TellerApproval)
+	var decidedAt sql.NullTime
+	if err := rows.Scan(&a.ID, &a.PublicID, &a.AccountID, &a.Kind, &a.Amount.Amount, &a.Amount.Currency, &a.Reason,
+		&a.RequestedBy, &a.Status, &a.DecidedBy, &decidedAt, &a.OperationID, &a.CreatedAt); err != nil {
+		return nil, err
+	}
+	if decidedAt.Valid {
+		t := decidedAt.Time.UTC()
+		a.DecidedAt = &t
+	}
+	a.CreatedAt = a.CreatedAt.UTC()
+	return a, nil
+}
+
+func (s *PostgresStorage) CreateTellerApproval(a *TellerApproval) error {
+	return s.db.QueryRow(`insert into teller_approval
+	(public_id, account_id, kind, amount, currency, reason, requested_by, status, created_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	returning id`, a.PublicID, a.AccountID, a.Kind, a.Amount.Amount, a.Amount.Currency, a.Reason, a.RequestedBy,
+		a.Status, a.CreatedAt).Scan(&a.ID)
+}
+
+func (s *PostgresStorage) GetTellerApproval(publicID string) (*TellerApproval, error) {
+	rows, err := s.db.Query("select "+tellerApprovalColumns+" from teller_approval where public_id = $1", publicID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoTellerApproval(rows)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: %s", ErrTellerApprovalNotFound, publicID)
+}
+
+func (s *PostgresStorage) GetPendingTellerApprovals() ([]*TellerApproval, error) {
+	rows, err := s.db.Query("select "+tellerApprovalColumns+` from teller_approval
+	where status = $1 order by created_at, id`, TellerApprovalPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	approvals := []*TellerApproval{}
+	for rows.Next() {
+		a, err := scanIntoTellerApproval(rows)
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, a)
+	}
+	return approvals, rows.Err()
+}
+
+// DecideTellerApproval records the decision on a pending approval. Only
+// one decision can win; the others get ErrStateConflict.
+func (s *PostgresStorage) DecideTellerApproval(a *TellerApproval) error {
+	res, err := s.db.Exec(`update teller_approval
+	set status = $1, decided_by = $2, decided_at = $3, operation_id = $4
+	where id = $5 and status = $6`, a.Status, a.DecidedBy, a.DecidedAt, a.OperationID, a.ID, TellerApprovalPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrStateConflict
+	}
+	return nil
+}
+
+func (s *PostgresStorage) createImpersonationTable() error {
+	query := `create table if not exists impersonation (
+		id serial primary key,
+		public_id char(26) unique not null,
+		account_id integer not null,
+		admin varchar(100) not null,
+		reason text not null,
+		status varchar(10) not null,
+		created_at timestamptz not null,
+		consented_at timestamptz,
+		expires_at timestamptz not null,
+		revoked_by varchar(200) not null default '',
+		revoked_at timestamptz
+	);
+	create index if not exists impersonation_account_idx on impersonation (account_id, created_at)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+const impersonationColumns = `id, public_id, account_id, admin, reason, status, created_at, consented_at,
+	expires_at, revoked_by, revoked_at`
+
+func scanIntoImpersonation(rows *sql.Rows) (*Impersonation, error) {
+	i := new(Impersonation)
+	var consentedAt, revokedAt sql.NullTime
+	if err := rows.Scan(&i.ID, &i.PublicID, &i.AccountID, &i.Admin, &i.Reason, &i.Status, &i.CreatedAt,
+		&consentedAt, &i.ExpiresAt, &i.RevokedBy, &revokedAt); err != nil {
+		return nil, err
+	}
+	if consentedAt.Valid {
+		t := consentedAt.Time.UTC()
+		i.ConsentedAt = &t
+	}
+	if revokedAt.Valid {
+		t := revokedAt.Time.UTC()
+		i.RevokedAt = &t
+	}
+	i.CreatedAt, i.ExpiresAt = i.CreatedAt.UTC(), i.ExpiresAt.UTC()
+	return i, nil
+}
+
+func (s *PostgresStorage) CreateImpersonation(i *Impersonation) error {
+	return s.db.QueryRow(`insert into impersonation
+	(public_id, account_id, admin, reason, status, created_at, expires_at)
+	values ($1, $2, $3, $4, $5, $6, $7)
+	returning id`, i.PublicID, i.AccountID, i.Admin, i.Reason, i.Status, i.CreatedAt, i.ExpiresAt).Scan(&i.ID)
+}
+
+func (s *PostgresStorage) GetImpersonation(publicID string) (*Impersonation, error) {
+	rows, err := s.db.Query("select "+impersonationColumns+" from impersonation where public_id = $1", publicID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoImpersonation(rows)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: %s", ErrImpersonationNotFound, publicID)
+}
+
+func (s *PostgresStorage) GetImpersonations(accountID int) ([]*Impersonation, error) {
+	rows, err := s.db.Query("select "+impersonationColumns+` from impersonation
+	where account_id = $1 order by created_at desc, id desc`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	impersonations := []*Impersonation{}
+	for rows.Next() {
+		i, err := scanIntoImpersonation(rows)
+		if err != nil {
+			return nil, err
+		}
+		impersonations = append(impersonations, i)
+	}
+	return impersonations, rows.Err()
+}
+
+// UpdateImpersonation moves the impersonation out of status from. If it
+// left that status in the meantime, e.g. because the customer and an admin
+// revoked it at once, it returns ErrStateConflict.
+func (s *PostgresStorage) UpdateImpersonation(i *Impersonation, from ImpersonationStatus) error {
+	res, err := s.db.Exec(`update impersonation
+	set status = $1, consented_at = $2, expires_at = $3, revoked_by = $4, revoked_at = $5
+	where id = $6 and status = $7`, i.Status, i.ConsentedAt, i.ExpiresAt, i.RevokedBy, i.RevokedAt, i.ID, from)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrStateConflict
+	}
+	return nil
+}
+
+func (s *PostgresStorage) createFXQuoteTable() error {
+	query := `create table if not exists fx_quote (
+		id serial primary key,
+		public_id char(26) unique not null,
+		account_id integer not null,
+		from_currency char(3) not null,
+		to_currency char(3) not null,
+		rate varchar(40) not null,
+		created_at timestamptz not null,
+		expires_at timestamptz not null,
+		transfer_id varchar(26) not null default '',
+		used_at timestamptz
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateFXQuote(q *FXQuote) error {
+	return s.db.QueryRow(`insert into fx_quote
+	(public_id, account_id, from_currency, to_currency, rate, created_at, expires_at)
+	values ($1, $2, $3, $4, $5, $6, $7)
+	returning id`, q.PublicID, q.AccountID, q.From, q.To, q.Rate, q.CreatedAt, q.ExpiresAt).Scan(&q.ID)
+}
+
+func (s *PostgresStorage) GetFXQuote(publicID string) (*FXQuote, error) {
+	q := new(FXQuote)
+	var usedAt sql.NullTime
+	err := s.db.QueryRow(`select id, public_id, account_id, from_currency, to_currency, rate, created_at, expires_at,
+		transfer_id, used_at
+	from fx_quote where public_id = $1`, publicID).Scan(&q.ID, &q.PublicID, &q.AccountID, &q.From, &q.To, &q.Rate,
+		&q.CreatedAt, &q.ExpiresAt, &q.TransferID, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrFXQuoteNotFound, publicID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		t := usedAt.Time.UTC()
+		q.UsedAt = &t
+	}
+	q.CreatedAt, q.ExpiresAt = q.CreatedAt.UTC(), q.ExpiresAt.UTC()
+	return q, nil
+}
+
+// UseFXQuote ties an unused quote to its transfer. A quote already used
+// reports ErrStateConflict.
+func (s *PostgresStorage) UseFXQuote(q *FXQuote) error {
+	res, err := s.db.Exec("update fx_quote set transfer_id = $1, used_at = $2 where id = $3 and transfer_id = ''",
+		q.TransferID, q.UsedAt, q.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrStateConflict
+	}
+	return nil
+}
+
+func (s *PostgresStorage) GetTransferChanges(q ChangeQuery) ([]*Transfer, error) {
+	rows, err := s.db.Query("select "+transferColumns+` from transfer
+	where (from_account = $1 or to_account = $1) and (updated_at > $2 or (updated_at = $2 and id > $3))
+	order by updated_at, id
+	limit $4`, q.AccountID, q.Since, q.AfterID, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []*Transfer{}
+	for rows.Next() {
+		t, err := scanIntoTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+
+	return transfers, rows.Err()
+}
+
+// GetCashOperationChanges pages by creation time; cash operations never
+// change once recorded.
+func (s *PostgresStorage) GetCashOperationChanges(q ChangeQuery) ([]*CashOperation, error) {
+	rows, err := s.db.Query(`select id, public_id, account_id, terminal_id, kind, amount, currency, status,
+		failure_reason, created_at
+	from cash_operation
+	where account_id = $1 and (created_at > $2 or (created_at = $2 and id > $3))
+	order by created_at, id
+	limit $4`, q.AccountID, q.Since, q.AfterID, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ops := []*CashOperation{}
+	for rows.Next() {
+		op := new(CashOperation)
+		if err := rows.Scan(&op.ID, &op.PublicID, &op.AccountID, &op.TerminalID, &op.Kind, &op.Amount.Amount,
+			&op.Amount.Currency, &op.Status, &op.FailureReason, &op.CreatedAt); err != nil {
+			return nil, err
+		}
+		op.CreatedAt = op.CreatedAt.UTC()
+		ops = append(ops, op)
+	}
+
+	return ops, rows.Err()
+}
+
+func (s *PostgresStorage) GetBillPaymentChanges(q ChangeQuery) ([]*BillPayment, error) {
+	rows, err := s.db.Query("select "+billPaymentColumns+` from bill_payment
+	where account_id = $1 and (updated_at > $2 or (updated_at = $2 and id > $3))
+	order by updated_at, id
+	limit $4`, q.AccountID, q.Since, q.AfterID, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanBillPayments(rows)
+}
+
+func (s *PostgresStorage) createUsageTable() error {
+	query := `create table if not exists api_usage (
+		consumer varchar(150) not null,
+		metric varchar(30) not null,
+		day date not null,
+		count bigint not null default 0,
+		primary key (consumer, metric, day)
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// AddUsage adds the records' counts to what is stored for the same
+// consumer, metric, day and endpoint.
+func (s *PostgresStorage) AddUsage(records []UsageRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, rec := range records {
+		if _, err := tx.Exec(`insert into api_usage (consumer, metric, day, endpoint, count)
+		values ($1, $2, $3, $4, $5)
+		on conflict (consumer, metric, day, endpoint) do update set count = api_usage.count + excluded.count`,
+			rec.Consumer, rec.Metric, rec.Day, rec.Endpoint, rec.Count); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) GetUsage(filter UsageFilter) ([]UsageRecord, error) {
+	rows, err := s.db.Query(`select consumer, metric, to_char(day, 'YYYY-MM-DD'), endpoint, count from api_usage
+	where ($1 = '' or consumer = $1) and day between $2 and $3 and (endpoint <> '') = $4
+	order by day, consumer, metric, endpoint`, filter.Consumer, filter.From, filter.To, filter.ByEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []UsageRecord{}
+	for rows.Next() {
+		var rec UsageRecord
+		if err := rows.Scan(&rec.Consumer, &rec.Metric, &rec.Day, &rec.Endpoint, &rec.Count); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+
+	return records, rows.Err()
+}
+
+func (s *PostgresStorage) createSweepRuleTable() error {
+	query := `create table if not exists sweep_rule (
+		id serial primary key,
+		account_id integer not null,
+		target_account integer not null,
+		threshold bigint not null,
+		currency char(3) not null,
+		created_at timestamptz not null
+	);
+	create index if not exists sweep_rule_account_idx on sweep_rule (account_id)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// sweepTargetPublicIDColumn selects the public id of a sweep rule's target
+// account, which customers name it by.
+const sweepTargetPublicIDColumn = "coalesce((select public_id from account where account.id = sweep_rule.target_account), '')"
+
+func (s *PostgresStorage) CreateSweepRule(r *SweepRule) error {
+	query := `insert into sweep_rule (account_id, target_account, threshold, currency, created_at)
+	values ($1, $2, $3, $4, $5)
+	returning id, ` + sweepTargetPublicIDColumn
+
+	return s.db.QueryRow(query, r.AccountID, r.TargetAccount, r.Threshold.Amount, r.Threshold.Currency,
+		r.CreatedAt).Scan(&r.ID, &r.TargetPublicID)
+}
+
+func (s *PostgresStorage) GetSweepRule(accountID, id int) (*SweepRule, error) {
+	rules, err := s.querySweepRules("where id = $1 and account_id = $2", id, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrSweepRuleNotFound, id)
+	}
+	return rules[0], nil
+}
+
+func (s *PostgresStorage) GetSweepRules(accountID int) ([]*SweepRule, error) {
+	return s.querySweepRules("where account_id = $1 order by id", accountID)
+}
+
+func (s *PostgresStorage) querySweepRules(where string, args ...any) ([]*SweepRule, error) {
+	rows, err := s.db.Query("select id, account_id, target_account, "+sweepTargetPublicIDColumn+
+		", threshold, currency, created_at from sweep_rule "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*SweepRule{}
+	for rows.Next() {
+		r := new(SweepRule)
+		if err := rows.Scan(&r.ID, &r.AccountID, &r.TargetAccount, &r.TargetPublicID, &r.Threshold.Amount,
+			&r.Threshold.Currency, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.CreatedAt = r.CreatedAt.UTC()
+		rules = append(rules, r)
+	}
+
+	return rules, rows.Err()
+}
+
+func (s *PostgresStorage) UpdateSweepRule(r *SweepRule) error {
+	_, err := s.db.Exec(`update sweep_rule set target_account = $1, threshold = $2, currency = $3
+	where id = $4 and account_id = $5`, r.TargetAccount, r.Threshold.Amount, r.Threshold.Currency, r.ID, r.AccountID)
+	return err
+}
+
+func (s *PostgresStorage) DeleteSweepRule(accountID, id int) error {
+	_, err := s.db.Exec("delete from sweep_rule where id = $1 and account_id = $2", id, accountID)
+	return err
+}
+
+// GetArchivableTransfers returns the oldest transfers created before
+// before that have reached a final state.
+func (s *PostgresStorage) GetArchivableTransfers(before time.Time, limit int) ([]*Transfer, error) {
+	rows, err := s.db.Query("select "+transferColumns+` from transfer
+	where status in ($1, $2) and created_at < $3
+	order by created_at, id
+	limit $4`, TransferSettled, TransferFailed, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []*Transfer{}
+	for rows.Next() {
+		t, err := scanIntoTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+
+	return transfers, rows.Err()
+}
+
+func (s *PostgresStorage) DeleteTransfers(ids []int) error {
+	_, err := s.db.Exec(`delete from transfer_origin where transfer_id = any($1);
+	delete from transfer where id = any($1)`, pq.Array(ids))
+	return err
+}
+
+func (s *PostgresStorage) GetArchivableAuditEvents(before time.Time, limit int) ([]*AuditEvent, error) {
+	rows, err := s.db.Query(`select id, actor, action, account_id, details, created_at from audit_event
+	where created_at < $1
+	order by created_at, id
+	limit $2`, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEvents(rows)
+}
+
+func (s *PostgresStorage) DeleteAuditEvents(ids []int) error {
+	_, err := s.db.Exec("delete from audit_event where id = any($1)", pq.Array(ids))
+	return err
+}
+
+func (s *PostgresStorage) createTransferOriginTable() error {
+	query := `create table if not exists transfer_origin (
+		transfer_id integer primary key,
+		ip varchar(45) not null,
+		country varchar(2) not null default '',
+		login_country varchar(2) not null default '',
+		geo_mismatch boolean not null,
+		created_at timestamptz not null
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateTransferOrigin(o *TransferOrigin) error {
+	_, err := s.db.Exec(`insert into transfer_origin
+	(transfer_id, ip, country, login_country, geo_mismatch, created_at)
+	values ($1, $2, $3, $4, $5, $6)`,
+		o.TransferID, o.IP, o.Country, o.LoginCountry, o.GeoMismatch, o.CreatedAt)
+	return err
+}
+
+func (s *PostgresStorage) GetTransferOrigins(ids []int) (map[int]*TransferOrigin, error) {
+	rows, err := s.db.Query(`select transfer_id, ip, country, login_country, geo_mismatch, created_at
+	from transfer_origin where transfer_id = any($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	origins := map[int]*TransferOrigin{}
+	for rows.Next() {
+		o := new(TransferOrigin)
+		if err := rows.Scan(&o.TransferID, &o.IP, &o.Country, &o.LoginCountry, &o.GeoMismatch, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		o.CreatedAt = o.CreatedAt.UTC()
+		origins[o.TransferID] = o
+	}
+
+	return origins, rows.Err()
+}
+
+func (s *PostgresStorage) createFreezeWindowTable() error {
+	query := `create table if not exists freeze_window (
+		id serial primary key,
+		account_id integer not null,
+		starts_at timestamptz,
+		ends_at timestamptz,
+		daily_from varchar(5) not null default '',
+		daily_to varchar(5) not null default '',
+		timezone varchar(64) not null default '',
+		created_at timestamptz not null
+	);
+	create index if not exists freeze_window_account_idx on freeze_window (account_id)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateFreezeWindow(w *FreezeWindow) error {
+	query := `insert into freeze_window (account_id, starts_at, ends_at, daily_from, daily_to, timezone, created_at)
+	values ($1, $2, $3, $4, $5, $6, $7)
+	returning id`
+
+	return s.db.QueryRow(query, w.AccountID, w.StartsAt, w.EndsAt, w.DailyFrom, w.DailyTo, w.Timezone,
+		w.CreatedAt).Scan(&w.ID)
+}
+
+func (s *PostgresStorage) GetFreezeWindow(accountID, id int) (*FreezeWindow, error) {
+	windows, err := s.queryFreezeWindows("where id = $1 and account_id = $2", id, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrFreezeWindowNotFound, id)
+	}
+	return windows[0], nil
+}
+
+func (s *PostgresStorage) GetFreezeWindows(accountID int) ([]*FreezeWindow, error) {
+	return s.queryFreezeWindows("where account_id = $1 order by id", accountID)
+}
+
+func (s *PostgresStorage) GetAllFreezeWindows() ([]*FreezeWindow, error) {
+	return s.queryFreezeWindows("order by id")
+}
+
+const freezeWindowColumns = "id, account_id, starts_at, ends_at, daily_from, daily_to, timezone, created_at"
+
+func (s *PostgresStorage) queryFreezeWindows(where string, args ...any) ([]*FreezeWindow, error) {
+	rows, err := s.db.Query("select "+freezeWindowColumns+" from freeze_window "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanFreezeWindows(rows)
+}
+
+func scanFreezeWindows(rows *sql.Rows) ([]*FreezeWindow, error) {
+	defer rows.Close()
+
+	windows := []*FreezeWindow{}
+	for rows.Next() {
+		w := new(FreezeWindow)
+		var startsAt, endsAt sql.NullTime
+		if err := rows.Scan(&w.ID, &w.AccountID, &startsAt, &endsAt, &w.DailyFrom, &w.DailyTo, &w.Timezone,
+			&w.CreatedAt); err != nil {
+			return nil, err
+		}
+		if startsAt.Valid && endsAt.Valid {
+			from, to := startsAt.Time.UTC(), endsAt.Time.UTC()
+			w.StartsAt, w.EndsAt = &from, &to
+		}
+		w.CreatedAt = w.CreatedAt.UTC()
+		windows = append(windows, w)
+	}
+
+	return windows, rows.Err()
+}
+
+func (s *PostgresStorage) DeleteFreezeWindow(accountID, id int) error {
+	_, err := s.db.Exec("delete from freeze_window where id = $1 and account_id = $2", id, accountID)
+	return err
+}
+
+func (s *PostgresStorage) createDelegationTable() error {
+	query := `create table if not exists delegation (
+		id serial primary key,
+		account_id integer not null,
+		delegate_account integer not null,
+		created_at timestamptz not null,
+		unique (account_id, delegate_account)
+	);
+	create index if not exists delegation_delegate_idx on delegation (delegate_account)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateDelegation(d *Delegation) error {
+	query := `insert into delegation (account_id, delegate_account, created_at)
+	values ($1, $2, $3)
+	returning id, (select public_id from account where account.id = delegation.delegate_account)`
+
+	return s.db.QueryRow(query, d.AccountID, d.DelegateAccount, d.CreatedAt).Scan(&d.ID, &d.DelegatePublicID)
+}
+
+func (s *PostgresStorage) GetDelegations(accountID int) ([]*Delegation, error) {
+	return s.queryDelegations("where account_id = $1 order by id", accountID)
+}
+
+func (s *PostgresStorage) GetDelegationsTo(delegateAccount int) ([]*Delegation, error) {
+	return s.queryDelegations("where delegate_account = $1 order by id", delegateAccount)
+}
+
+func (s *PostgresStorage) queryDelegations(where string, args ...any) ([]*Delegation, error) {
+	rows, err := s.db.Query(`select id, account_id, delegate_account,
+		(select public_id from account where account.id = delegation.delegate_account), created_at
+	from delegation `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	delegations := []*Delegation{}
+	for rows.Next() {
+		d := new(Delegation)
+		if err := rows.Scan(&d.ID, &d.AccountID, &d.DelegateAccount, &d.DelegatePublicID, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		d.CreatedAt = d.CreatedAt.UTC()
+		delegations = append(delegations, d)
+	}
+
+	return delegations, rows.Err()
+}
+
+func (s *PostgresStorage) DeleteDelegation(accountID, id int) error {
+	_, err := s.db.Exec("delete from delegation where id = $1 and account_id = $2", id, accountID)
+	return err
+}
+
+func (s *PostgresStorage) createDocumentTables() error {
+	query := `create table if not exists document (
+		id serial primary key,
+		public_id char(26) unique not null,
+		account_id integer not null,
+		kind varchar(20) not null,
+		title varchar(200) not null,
+		period varchar(7) not null default '',
+		content_type varchar(100) not null,
+		size integer not null,
+		delivery varchar(20) not null,
+		created_at timestamptz not null
+	);
+	create index if not exists document_account_idx on document (account_id, created_at);
+	create table if not exists paperless_preference (
+		account_id integer primary key,
+		statements boolean not null,
+		notices boolean not null,
+		updated_at timestamptz not null
+	);
+	create table if not exists statement_regeneration (
+		id serial primary key,
+		public_id char(26) unique not null,
+		period_from varchar(7) not null,
+		period_to varchar(7) not null,
+		account_ids integer[] not null,
+		requested_by varchar(100) not null,
+		status varchar(10) not null,
+		last_account_id integer not null default 0,
+		accounts integer not null default 0,
+		regenerated integer not null default 0,
+		created integer not null default 0,
+		unchanged integer not null default 0,
+		failed integer not null default 0,
+		created_at timestamptz not null,
+		updated_at timestamptz not null,
+		completed_at timestamptz
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateDocument(d *Document) error {
+	query := `insert into document
+	(public_id, account_id, kind, title, period, content_type, size, delivery, created_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	returning id`
+
+	return s.db.QueryRow(query, d.PublicID, d.AccountID, d.Kind, d.Title, d.Period, d.ContentType, d.Size,
+		d.Delivery, d.CreatedAt).Scan(&d.ID)
+}
+
+// GetDocuments returns the account's documents newest first, all of them
+// when kind is empty.
+func (s *PostgresStorage) GetDocuments(accountID int, kind DocumentKind) ([]*Document, error) {
+	return s.queryDocuments("where account_id = $1 and ($2 = '' or kind = $2) order by created_at desc, id desc",
+		accountID, kind)
+}
+
+func (s *PostgresStorage) GetDocumentByPublicID(publicID string) (*Document, error) {
+	docs, err := s.queryDocuments("where public_id = $1", publicID)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, publicID)
+	}
+	return docs[0], nil
+}
+
+// UpdateDocumentSize records the size of a document whose content was
+// overwritten.
+func (s *PostgresStorage) UpdateDocumentSize(d *Document) error {
+	_, err := s.db.Exec("update document set size = $1 where id = $2", d.Size, d.ID)
+	return err
+}
+
+func (s *PostgresStorage) CreateStatementRegeneration(job *StatementRegeneration) error {
+	return s.db.QueryRow(`insert into statement_regeneration
+	(public_id, period_from, period_to, account_ids, requested_by, status, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8)
+	returning id`, job.PublicID, job.From, job.To, pq.Array(job.AccountIDs), job.RequestedBy, job.Status,
+		job.CreatedAt, job.UpdatedAt).Scan(&job.ID)
+}
+
+func (s *PostgresStorage) GetStatementRegeneration(publicID string) (*StatementRegeneration, error) {
+	jobs, err := s.queryStatementRegenerations("where public_id = $1", publicID)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrRegenerationNotFound, publicID)
+	}
+	return jobs[0], nil
+}
+
+func (s *PostgresStorage) GetRunningStatementRegenerations() ([]*StatementRegeneration, error) {
+	return s.queryStatementRegenerations("where status = $1 order by id", RegenerationRunning)
+}
+
+func (s *PostgresStorage) UpdateStatementRegeneration(job *StatementRegeneration) error {
+	_, err := s.db.Exec(`update statement_regeneration
+	set status = $1, last_account_id = $2, accounts = $3, regenerated = $4, created = $5, unchanged = $6, failed = $7,
+		updated_at = $8, completed_at = $9
+	where id = $10`, job.Status, job.LastAccountID, job.Accounts, job.Regenerated, job.Created, job.Unchanged,
+		job.Failed, job.UpdatedAt, job.CompletedAt, job.ID)
+	return err
+}
+
+func (s *PostgresStorage) queryStatementRegenerations(where string, args ...any) ([]*StatementRegeneration, error) {
+	rows, err := s.db.Query(`select id, public_id, period_from, period_to, account_ids, requested_by, status,
+		last_account_id, accounts, regenerated, created, unchanged, failed, created_at, updated_at, completed_at
+	from statement_regeneration `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*StatementRegeneration{}
+	for rows.Next() {
+		job := new(StatementRegeneration)
+		var accountIDs pq.Int64Array
+		var completedAt sql.NullTime
+		if err := rows.Scan(&job.ID, &job.PublicID, &job.From, &job.To, &accountIDs, &job.RequestedBy, &job.Status,
+			&job.LastAccountID, &job.Accounts, &job.Regenerated, &job.Created, &job.Unchanged, &job.Failed,
+			&job.CreatedAt, &job.UpdatedAt, &completedAt); err != nil {
+			return nil, err
+		}
+		for _, id := range accountIDs {
+			job.AccountIDs = append(job.AccountIDs, int(id))
+		}
+		if completedAt.Valid {
+			t := completedAt.Time.UTC()
+			job.CompletedAt = &t
+		}
+		job.CreatedAt, job.UpdatedAt = job.CreatedAt.UTC(), job.UpdatedAt.UTC()
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *PostgresStorage) queryDocuments(where string, args ...any) ([]*Document, error) {
+	rows, err := s.db.Query(`select id, public_id, account_id, kind, title, period, content_type, size, delivery, created_at
+	from document `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := []*Document{}
+	for rows.Next() {
+		d := new(Document)
+		if err := rows.Scan(&d.ID, &d.PublicID, &d.AccountID, &d.Kind, &d.Title, &d.Period, &d.ContentType, &d.Size,
+			&d.Delivery, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		d.CreatedAt = d.CreatedAt.UTC()
+		docs = append(docs, d)
+	}
+
+	return docs, rows.Err()
+}
+
+// GetPaperlessPreferences returns the defaults for accounts that never
+// set any.
+func (s *PostgresStorage) GetPaperlessPreferences(accountID int) (*PaperlessPreferences, error) {
+	p := &PaperlessPreferences{AccountID: accountID}
+	err := s.db.QueryRow("select statements, notices, updated_at from paperless_preference where account_id = $1",
+		accountID).Scan(&p.Statements, &p.Notices, &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultPaperlessPreferences(accountID), nil
+	}
+	p.UpdatedAt = p.UpdatedAt.UTC()
+	return p, err
+}
+
+func (s *PostgresStorage) UpdatePaperlessPreferences(p *PaperlessPreferences) error {
+	_, err := s.db.Exec(`insert into paperless_preference (account_id, statements, notices, updated_at)
+	values ($1, $2, $3, $4)
+	on conflict (account_id) do update set statements = $2, notices = $3, updated_at = $4`,
+		p.AccountID, p.Statements, p.Notices, p.UpdatedAt)
+	return err
+}
+
+func (s *PostgresStorage) createHolidayTable() error {
+	query := `create table if not exists holiday (
+		id serial primary key,
+		currency char(3) not null,
+		date date not null,
+		name varchar(100) not null,
+		created_at timestamptz not null,
+		unique (currency, date)
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateHoliday(h *Holiday) error {
+	query := `insert into holiday (currency, date, name, created_at)
+	values ($1, $2, $3, $4)
+	returning id`
+
+	err := s.db.QueryRow(query, h.Currency, h.Date, h.Name, h.CreatedAt).Scan(&h.ID)
+	return mapConstraintError(err)
+}
+
+// GetHolidays returns the holidays of currency, or of every currency when
+// it is empty, by date.
+func (s *PostgresStorage) GetHolidays(currency string) ([]*Holiday, error) {
+	rows, err := s.db.Query(`select id, currency, date, name, created_at from holiday
+	where $1 = '' or currency = $1
+	order by date, currency`, currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holidays := []*Holiday{}
+	for rows.Next() {
+		h := new(Holiday)
+		var date time.Time
+		if err := rows.Scan(&h.ID, &h.Currency, &date, &h.Name, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		h.Date, h.CreatedAt = date.Format(dateLayout), h.CreatedAt.UTC()
+		holidays = append(holidays, h)
+	}
+
+	return holidays, rows.Err()
+}
+
+func (s *PostgresStorage) DeleteHoliday(id int) error {
+	_, err := s.db.Exec("delete from holiday where id = $1", id)
+	return err
+}
+
+func (s *PostgresStorage) createTermsTables() error {
+	query := `create table if not exists terms (
+		id serial primary key,
+		version varchar(50) unique not null,
+		title varchar(200) not null,
+		url text not null,
+		mandatory boolean not null,
+		published_at timestamptz not null
+	);
+	create table if not exists terms_acceptance (
+		account_id integer not null,
+		version varchar(50) not null,
+		ip varchar(45) not null,
+		accepted_at timestamptz not null,
+		primary key (account_id, version)
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateTerms(t *Terms) error {
+	query := `insert into terms (version, title, url, mandatory, published_at)
+	values ($1, $2, $3, $4, $5)
+	returning id`
+
+	err := s.db.QueryRow(query, t.Version, t.Title, t.URL, t.Mandatory, t.PublishedAt).Scan(&t.ID)
+	return mapConstraintError(err)
+}
+
+// GetTerms returns every published version, oldest first.
+func (s *PostgresStorage) GetTerms() ([]*Terms, error) {
+	rows, err := s.db.Query("select id, version, title, url, mandatory, published_at from terms order by published_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := []*Terms{}
+	for rows.Next() {
+		t := new(Terms)
+		if err := rows.Scan(&t.ID, &t.Version, &t.Title, &t.URL, &t.Mandatory, &t.PublishedAt); err != nil {
+			return nil, err
+		}
+		t.PublishedAt = t.PublishedAt.UTC()
+		terms = append(terms, t)
+	}
+
+	return terms, rows.Err()
+}
+
+// AcceptTerms records an acceptance. Accepting a version again keeps the
+// first acceptance.
+func (s *PostgresStorage) AcceptTerms(a *TermsAcceptance) error {
+	_, err := s.db.Exec(`insert into terms_acceptance (account_id, version, ip, accepted_at)
+	values ($1, $2, $3, $4)
+	on conflict (account_id, version) do nothing`,
+		a.AccountID, a.Version, a.IP, a.AcceptedAt)
+	return err
+}
+
+func (s *PostgresStorage) GetTermsAcceptances(accountID int) ([]*TermsAcceptance, error) {
+	rows, err := s.db.Query(`select account_id, version, ip, accepted_at from terms_acceptance
+	where account_id = $1 order by accepted_at`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	acceptances := []*TermsAcceptance{}
+	for rows.Next() {
+		a := new(TermsAcceptance)
+		if err := rows.Scan(&a.AccountID, &a.Version, &a.IP, &a.AcceptedAt); err != nil {
+			return nil, err
+		}
+		a.AcceptedAt = a.AcceptedAt.UTC()
+		acceptances = append(acceptances, a)
+	}
+
+	return acceptances, rows.Err()
+}
+
+func (s *PostgresStorage) createOnlineMigrationTable() error {
+	query := `create table if not exists online_migration (
+		name varchar(100) primary key,
+		phase varchar(20) not null,
+		backfilled_to integer not null default 0,
+		max_id integer not null,
+		started_at timestamptz not null,
+		updated_at timestamptz not null,
+		cut_over_at timestamptz
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+const migrationProgressColumns = "name, phase, backfilled_to, max_id, started_at, updated_at, cut_over_at"
+
+func scanMigrationProgress(row interface{ Scan(...any) error }) (*MigrationProgress, error) {
+	p := new(MigrationProgress)
+	var cutOverAt sql.NullTime
+	if err := row.Scan(&p.Name, &p.Phase, &p.BackfilledTo, &p.MaxID, &p.StartedAt, &p.UpdatedAt, &cutOverAt); err != nil {
+		return nil, err
+	}
+	p.StartedAt, p.UpdatedAt = p.StartedAt.UTC(), p.UpdatedAt.UTC()
+	if cutOverAt.Valid {
+		at := cutOverAt.Time.UTC()
+		p.CutOverAt = &at
+	}
+	return p, nil
+}
+
+// StartOnlineMigration runs the expand step of m and records the highest
+// id of its table the first time, as the end of the backfill.
+func (s *PostgresStorage) StartOnlineMigration(m *OnlineMigration) (*MigrationProgress, error) {
+	if _, err := s.db.Exec(m.Expand); err != nil {
+		return nil, fmt.Errorf("expanding %s: %w", m.Name, err)
+	}
+
+	now := time.Now().UTC()
+	_, err := s.db.Exec(`insert into online_migration (name, phase, max_id, started_at, updated_at)
+	select $1, $2, coalesce(max(id), 0), $3, $3 from `+m.Table+`
+	on conflict (name) do nothing`, m.Name, MigrationBackfilling, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanMigrationProgress(s.db.QueryRow("select "+migrationProgressColumns+" from online_migration where name = $1", m.Name))
+}
+
+// BackfillOnlineMigration backfills the next batchSize ids of m and
+// records the progress in the same transaction.
+func (s *PostgresStorage) BackfillOnlineMigration(m *OnlineMigration, batchSize int) (*MigrationProgress, error) {
+	var p *MigrationProgress
+	err := s.serializable(func(tx *sql.Tx) error {
+		var err error
+		p, err = scanMigrationProgress(tx.QueryRow("select "+migrationProgressColumns+" from online_migration where name = $1 for update", m.Name))
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %s", ErrMigrationNotFound, m.Name)
+		}
+		if err != nil || p.Phase != MigrationBackfilling {
+			return err
+		}
+
+		to := p.BackfilledTo + batchSize
+		if to >= p.MaxID {
+			to, p.Phase = p.MaxID, MigrationBackfilled
+		}
+		if to > p.BackfilledTo {
+			if _, err := tx.Exec(m.Backfill, p.BackfilledTo, to); err != nil {
+				return fmt.Errorf("backfilling %s: %w", m.Name, err)
+			}
+		}
+		p.BackfilledTo, p.UpdatedAt = to, time.Now().UTC()
+
+		_, err = tx.Exec("update online_migration set phase = $1, backfilled_to = $2, updated_at = $3 where name = $4",
+			p.Phase, p.BackfilledTo, p.UpdatedAt, p.Name)
+		return err
+	})
+	return p, err
+}
+
+func (s *PostgresStorage) GetMigrationProgress() ([]*MigrationProgress, error) {
+	rows, err := s.db.Query("select " + migrationProgressColumns + " from online_migration order by started_at, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	progress := []*MigrationProgress{}
+	for rows.Next() {
+		p, err := scanMigrationProgress(rows)
+		if err != nil {
+			return nil, err
+		}
+		progress = append(progress, p)
+	}
+
+	return progress, rows.Err()
+}
+
+// CutOverOnlineMigration moves a backfilled migration to cut over.
+func (s *PostgresStorage) CutOverOnlineMigration(name string, now time.Time) (*MigrationProgress, error) {
+	p, err := scanMigrationProgress(s.db.QueryRow(`update online_migration set phase = $1, cut_over_at = $2, updated_at = $2
+	where name = $3 and phase = $4
+	returning `+migrationProgressColumns, MigrationCutOver, now, name, MigrationBackfilled))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return p, err
+	}
+
+	var exists bool
+	if err := s.db.QueryRow("select exists (select 1 from online_migration where name = $1)", name).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrMigrationNotFound, name)
+	}
+	return nil, ErrStateConflict
+}
+
+func (s *PostgresStorage) createEndOfDayTable() error {
+	query := `create table if not exists end_of_day (
+		id serial primary key,
+		public_id char(26) unique not null,
+		business_date varchar(10) unique not null,
+		status varchar(10) not null,
+		settled integer not null default 0,
+		failed integer not null default 0,
+		positions jsonb not null default '[]',
+		started_at timestamptz not null,
+		completed_at timestamptz
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateEndOfDay(run *EndOfDayRun) error {
+	return s.db.QueryRow(`insert into end_of_day (public_id, business_date, status, started_at)
+	values ($1, $2, $3, $4)
+	returning id`, run.PublicID, run.BusinessDate, run.Status, run.StartedAt).Scan(&run.ID)
+}
+
+func (s *PostgresStorage) GetEndOfDay(businessDate string) (*EndOfDayRun, error) {
+	runs, err := s.queryEndOfDays("where business_date = $1", businessDate)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEndOfDayNotFound, businessDate)
+	}
+	return runs[0], nil
+}
+
+// GetEndOfDays returns the latest runs, latest business date first.
+func (s *PostgresStorage) GetEndOfDays(limit int) ([]*EndOfDayRun, error) {
+	return s.queryEndOfDays("order by business_date desc limit $1", limit)
+}
+
+func (s *PostgresStorage) UpdateEndOfDay(run *EndOfDayRun) error {
+	positions, err := json.Marshal(run.Positions)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`update end_of_day set status = $1, settled = $2, failed = $3, positions = $4, completed_at = $5
+	where id = $6`, run.Status, run.Settled, run.Failed, positions, run.CompletedAt, run.ID)
+	return err
+}
+
+func (s *PostgresStorage) queryEndOfDays(where string, args ...any) ([]*EndOfDayRun, error) {
+	rows, err := s.db.Query(`select id, public_id, business_date, status, settled, failed, positions, started_at, completed_at
+	from end_of_day `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*EndOfDayRun{}
+	for rows.Next() {
+		run := new(EndOfDayRun)
+		var positions []byte
+		var completedAt sql.NullTime
+		if err := rows.Scan(&run.ID, &run.PublicID, &run.BusinessDate, &run.Status, &run.Settled, &run.Failed,
+			&positions, &run.StartedAt, &completedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(positions, &run.Positions); err != nil {
+			return nil, err
+		}
+		if completedAt.Valid {
+			t := completedAt.Time.UTC()
+			run.CompletedAt = &t
+		}
+		run.StartedAt = run.StartedAt.UTC()
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+func (s *PostgresStorage) createAutomationTables() error {
+	query := `create table if not exists automation_rule (
+		id serial primary key,
+		account_id integer not null,
+		name varchar(100) not null default '',
+		trigger varchar(20) not null,
+		min_amount bigint,
+		reference_contains varchar(100) not null default '',
+		threshold bigint,
+		action varchar(20) not null,
+		target_account integer not null default 0,
+		percent integer not null default 0,
+		amount bigint,
+		message text not null default '',
+		currency char(3) not null,
+		enabled boolean not null default true,
+		created_at timestamptz not null
+	);
+	create index if not exists automation_rule_account_idx on automation_rule (account_id);
+	create table if not exists automation_run (
+		id serial primary key,
+		rule_id integer not null,
+		account_id integer not null,
+		posting varchar(200) not null,
+		status varchar(10) not null,
+		transfer_id varchar(26) not null default '',
+		detail text not null default '',
+		created_at timestamptz not null
+	);
+	create index if not exists automation_run_rule_idx on automation_run (rule_id, created_at)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// automationAmounts returns the amounts of r as nullable columns, and the
+// currency they share.
+func automationAmounts(r *AutomationRule) (minAmount, threshold, amount sql.NullInt64, currency string) {
+	column := func(m *Money) sql.NullInt64 {
+		if m == nil {
+			return sql.NullInt64{}
+		}
+		currency = m.Currency
+		return sql.NullInt64{Int64: m.Amount, Valid: true}
+	}
+	minAmount, threshold, amount = column(r.MinAmount), column(r.Threshold), column(r.Amount)
+	if currency == "" {
+		currency = "   "
+	}
+	return minAmount, threshold, amount, currency
+}
+
+// automationTargetPublicIDColumn selects the public id of the account an
+// automation rule moves money into, empty for rules that only notify.
+const automationTargetPublicIDColumn = "coalesce((select public_id from account where account.id = automation_rule.target_account), '')"
+
+func (s *PostgresStorage) CreateAutomationRule(r *AutomationRule) error {
+	minAmount, threshold, amount, currency := automationAmounts(r)
+	query := `insert into automation_rule (account_id, name, trigger, min_amount, reference_contains, threshold, action,
+		target_account, percent, amount, message, currency, enabled, created_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	returning id, ` + automationTargetPublicIDColumn
+
+	return s.db.QueryRow(query, r.AccountID, r.Name, r.Trigger, minAmount, r.ReferenceContains, threshold, r.Action,
+		r.TargetAccount, r.Percent, amount, r.Message, currency, r.Enabled, r.CreatedAt).Scan(&r.ID, &r.TargetPublicID)
+}
+
+func (s *PostgresStorage) GetAutomationRule(accountID, id int) (*AutomationRule, error) {
+	rules, err := s.queryAutomationRules("where id = $1 and account_id = $2", id, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrAutomationRuleNotFound, id)
+	}
+	return rules[0], nil
+}
+
+func (s *PostgresStorage) GetAutomationRules(accountID int) ([]*AutomationRule, error) {
+	return s.queryAutomationRules("where account_id = $1 order by id", accountID)
+}
+
+func (s *PostgresStorage) queryAutomationRules(where string, args ...any) ([]*AutomationRule, error) {
+	rows, err := s.db.Query(`select id, account_id, name, trigger, min_amount, reference_contains, threshold, action,
+	target_account, `+automationTargetPublicIDColumn+`, percent, amount, message, currency, enabled, created_at
+	from automation_rule `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*AutomationRule{}
+	for rows.Next() {
+		r := new(AutomationRule)
+		var minAmount, threshold, amount sql.NullInt64
+		var currency string
+		if err := rows.Scan(&r.ID, &r.AccountID, &r.Name, &r.Trigger, &minAmount, &r.ReferenceContains, &threshold,
+			&r.Action, &r.TargetAccount, &r.TargetPublicID, &r.Percent, &amount, &r.Message, &currency, &r.Enabled, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		money := func(column sql.NullInt64) *Money {
+			if !column.Valid {
+				return nil
+			}
+			m := NewMoney(column.Int64, currency)
+			return &m
+		}
+		r.MinAmount, r.Threshold, r.Amount = money(minAmount), money(threshold), money(amount)
+		r.CreatedAt = r.CreatedAt.UTC()
+		rules = append(rules, r)
+	}
+
+	return rules, rows.Err()
+}
+
+func (s *PostgresStorage) UpdateAutomationRule(r *AutomationRule) error {
+	minAmount, threshold, amount, currency := automationAmounts(r)
+	_, err := s.db.Exec(`update automation_rule set name = $1, trigger = $2, min_amount = $3, reference_contains = $4,
+	threshold = $5, action = $6, target_account = $7, percent = $8, amount = $9, message = $10, currency = $11,
+	enabled = $12
+	where id = $13 and account_id = $14`, r.Name, r.Trigger, minAmount, r.ReferenceContains, threshold, r.Action,
+		r.TargetAccount, r.Percent, amount, r.Message, currency, r.Enabled, r.ID, r.AccountID)
+	return err
+}
+
+func (s *PostgresStorage) DeleteAutomationRule(accountID, id int) error {
+	_, err := s.db.Exec("delete from automation_rule where id = $1 and account_id = $2", id, accountID)
+	return err
+}
+
+func (s *PostgresStorage) CreateAutomationRun(run *AutomationRun) error {
+	return s.db.QueryRow(`insert into automation_run (rule_id, account_id, posting, status, transfer_id, detail, created_at)
+	values ($1, $2, $3, $4, $5, $6, $7)
+	returning id`, run.RuleID, run.AccountID, run.Posting, run.Status, run.TransferID, run.Detail,
+		run.CreatedAt).Scan(&run.ID)
+}
+
+// GetAutomationRuns returns the latest runs of a rule, newest first.
+func (s *PostgresStorage) GetAutomationRuns(accountID, ruleID, limit int) ([]*AutomationRun, error) {
+	rows, err := s.db.Query(`select id, rule_id, account_id, posting, status, transfer_id, detail, created_at
+	from automation_run
+	where rule_id = $1 and account_id = $2
+	order by created_at desc, id desc
+	limit $3`, ruleID, accountID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*AutomationRun{}
+	for rows.Next() {
+		run := new(AutomationRun)
+		if err := rows.Scan(&run.ID, &run.RuleID, &run.AccountID, &run.Posting, &run.Status, &run.TransferID,
+			&run.Detail, &run.CreatedAt); err != nil {
+			return nil, err
+		}
+		run.CreatedAt = run.CreatedAt.UTC()
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+func (s *PostgresStorage) createReviewCaseTable() error {
+	query := `create table if not exists review_case (
+		transfer_id integer primary key,
+		priority varchar(10) not null,
+		note text not null default '',
+		due_at timestamptz not null,
+		escalated_at timestamptz,
+		updated_at timestamptz not null
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) SaveReviewCase(c *ReviewCase) error {
+	_, err := s.db.Exec(`insert into review_case (transfer_id, priority, note, due_at, escalated_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6)
+	on conflict (transfer_id) do update set priority = excluded.priority, note = excluded.note,
+		due_at = excluded.due_at, escalated_at = excluded.escalated_at, updated_at = excluded.updated_at`,
+		c.TransferID, c.Priority, c.Note, c.DueAt, c.EscalatedAt, c.UpdatedAt)
+	return err
+}
+
+func (s *PostgresStorage) GetReviewCases(transferIDs []int) (map[int]*ReviewCase, error) {
+	rows, err := s.db.Query(`select transfer_id, priority, note, due_at, escalated_at, updated_at
+	from review_case where transfer_id = any($1)`, pq.Array(transferIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cases := map[int]*ReviewCase{}
+	for rows.Next() {
+		c := new(ReviewCase)
+		var escalatedAt sql.NullTime
+		if err := rows.Scan(&c.TransferID, &c.Priority, &c.Note, &c.DueAt, &escalatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if escalatedAt.Valid {
+			t := escalatedAt.Time.UTC()
+			c.EscalatedAt = &t
+		}
+		c.DueAt, c.UpdatedAt = c.DueAt.UTC(), c.UpdatedAt.UTC()
+		cases[c.TransferID] = c
+	}
+
+	return cases, rows.Err()
+}
+
+func (s *PostgresStorage) createTransactionTagTable() error {
+	query := `create table if not exists transaction_tag (
+		account_id integer not null,
+		transfer_id char(26) not null,
+		category varchar(50) not null,
+		tags text[] not null default '{}',
+		updated_at timestamptz not null,
+		primary key (account_id, transfer_id)
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// SaveTransactionTags replaces the tags of every transfer in tags at once.
+func (s *PostgresStorage) SaveTransactionTags(tags []*TransactionTag) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, t := range tags {
+		if _, err := tx.Exec(`insert into transaction_tag (account_id, transfer_id, category, tags, updated_at)
+		values ($1, $2, $3, $4, $5)
+		on conflict (account_id, transfer_id) do update set category = excluded.category, tags = excluded.tags,
+			updated_at = excluded.updated_at`,
+			t.AccountID, t.TransferID, t.Category, pq.Array(t.Tags), t.UpdatedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) GetTransactionTags(accountID int) (map[string]*TransactionTag, error) {
+	rows, err := s.db.Query(`select account_id, transfer_id, category, tags, updated_at
+	from transaction_tag where account_id = $1`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := map[string]*TransactionTag{}
+	for rows.Next() {
+		t := &TransactionTag{Tags: []string{}}
+		if err := rows.Scan(&t.AccountID, &t.TransferID, &t.Category, pq.Array(&t.Tags), &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		t.UpdatedAt = t.UpdatedAt.UTC()
+		tags[t.TransferID] = t
+	}
+
+	return tags, rows.Err()
+}
+
+func (s *PostgresStorage) createLegalHoldTable() error {
+	query := `create table if not exists legal_hold (
+		account_id integer primary key,
+		reason text not null,
+		placed_by varchar(100) not null,
+		created_at timestamptz not null
+	)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// GetDeletedAccounts returns the accounts soft-deleted before
+// deletedBefore with ids above afterID, in id order.
+func (s *PostgresStorage) GetDeletedAccounts(deletedBefore time.Time, afterID, limit int) ([]*DeletedAccount, error) {
+	rows, err := s.db.Query(`select a.id, a.public_id, a.balance, a.currency, a.deleted_at, h.account_id is not null
+	from account a left join legal_hold h on h.account_id = a.id
+	where a.deleted_at < $1 and a.id > $2
+	order by a.id limit $3`, deletedBefore, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*DeletedAccount{}
+	for rows.Next() {
+		a := new(DeletedAccount)
+		if err := rows.Scan(&a.ID, &a.PublicID, &a.Balance.Amount, &a.Balance.Currency, &a.DeletedAt, &a.LegalHold); err != nil {
+			return nil, err
+		}
+		a.DeletedAt = a.DeletedAt.UTC()
+		accounts = append(accounts, a)
+	}
+
+	return accounts, rows.Err()
+}
+
+// purgedAccountTables hold the personal data removed with a purged
+// account. The ledger, audit log and documents stay.
+var purgedAccountTables = []string{
+	"login_attempt", "device", "login_challenge", "alert_rule", "contact", "sweep_rule",
+	"automation_rule", "automation_run", "freeze_window", "paperless_preference",
+	"idempotency_key", "transaction_tag", "webhook_endpoint", "delegation",
+}
+
+// PurgeAccount removes a soft-deleted account without a legal hold and
+// its personal data, and returns how many rows went. Accounts that are
+// live or held are an ErrStateConflict.
+func (s *PostgresStorage) PurgeAccount(id int) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`delete from account where id = $1 and deleted_at is not null
+	and not exists (select 1 from legal_hold where account_id = $1)`, id)
+	if err != nil {
+		return 0, err
+	}
+	records, _ := res.RowsAffected()
+	if records == 0 {
+		return 0, fmt.Errorf("%w: account %d is live or on legal hold", ErrStateConflict, id)
+	}
+
+	for _, table := range purgedAccountTables {
+		query := "delete from " + table + " where account_id = $1"
+		if table == "delegation" {
+			query += " or delegate_account = $1"
+		}
+		res, err := tx.Exec(query, id)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		records += n
+	}
+
+	return records, tx.Commit()
+}
+
+func (s *PostgresStorage) PlaceLegalHold(h *LegalHold) error {
+	_, err := s.db.Exec(`insert into legal_hold (account_id, reason, placed_by, created_at)
+	values ($1, $2, $3, $4)
+	on conflict (account_id) do update set reason = excluded.reason, placed_by = excluded.placed_by,
+		created_at = excluded.created_at`,
+		h.AccountID, h.Reason, h.PlacedBy, h.CreatedAt)
+	return err
+}
+
+func (s *PostgresStorage) GetLegalHold(accountID int) (*LegalHold, error) {
+	h := new(LegalHold)
+	err := s.db.QueryRow("select account_id, reason, placed_by, created_at from legal_hold where account_id = $1", accountID).
+		Scan(&h.AccountID, &h.Reason, &h.PlacedBy, &h.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrLegalHoldNotFound, accountID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	h.CreatedAt = h.CreatedAt.UTC()
+	return h, nil
+}
+
+func (s *PostgresStorage) ReleaseLegalHold(accountID int) error {
+	res, err := s.db.Exec("delete from legal_hold where account_id = $1", accountID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %d", ErrLegalHoldNotFound, accountID)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) createWebhookEndpointTable() error {
+	query := `create table if not exists webhook_endpoint (
+		id serial primary key,
+		account_id integer not null,
+		url text not null,
+		event_types text[] not null default '{}',
+		secret varchar(64) not null,
+		status varchar(10) not null,
+		disabled_reason text not null default '',
+		disabled_at timestamptz,
+		created_at timestamptz not null
+	);
+	create index if not exists webhook_endpoint_account_idx on webhook_endpoint (account_id)`
+
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStorage) CreateWebhookEndpoint(e *WebhookEndpoint) error {
+	return s.db.QueryRow(`insert into webhook_endpoint (account_id, url, event_types, secret, status, created_at)
+	values ($1, $2, $3, $4, $5, $6)
+	returning id`, e.AccountID, e.URL, pq.Array(e.EventTypes), e.Secret, e.Status, e.CreatedAt).Scan(&e.ID)
+}
+
+func (s *PostgresStorage) GetWebhookEndpoint(accountID, id int) (*WebhookEndpoint, error) {
+	endpoints, err := s.queryWebhookEndpoints("where id = $1 and account_id = $2", id, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrWebhookNotFound, id)
+	}
+	return endpoints[0], nil
+}
+
+func (s *PostgresStorage) GetWebhookEndpoints(accountID int) ([]*WebhookEndpoint, error) {
+	return s.queryWebhookEndpoints("where account_id = $1 order by id", accountID)
+}
+
+func (s *PostgresStorage) queryWebhookEndpoints(where string, args ...any) ([]*WebhookEndpoint, error) {
+	rows, err := s.db.Query(`select id, account_id, url, event_types, secret, status, disabled_reason, disabled_at,
+	created_at from webhook_endpoint `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []*WebhookEndpoint{}
+	for rows.Next() {
+		e := &WebhookEndpoint{EventTypes: []string{}}
+		if err := rows.Scan(&e.ID, &e.AccountID, &e.URL, pq.Array(&e.EventTypes), &e.Secret, &e.Status,
+			&e.DisabledReason, &e.DisabledAt, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if e.DisabledAt != nil {
+			disabledAt := e.DisabledAt.UTC()
+			e.DisabledAt = &disabledAt
+		}
+		e.CreatedAt = e.CreatedAt.UTC()
+		endpoints = append(endpoints, e)
+	}
+
+	return endpoints, rows.Err()
+}
+
+func (s *PostgresStorage) UpdateWebhookEndpoint(e *WebhookEndpoint) error {
+	res, err := s.db.Exec(`update webhook_endpoint set status = $1, disabled_reason = $2, disabled_at = $3
+	where id = $4 and account_id = $5`, e.Status, e.DisabledReason, e.DisabledAt, e.ID, e.AccountID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %d", ErrWebhookNotFound, e.ID)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) DeleteWebhookEndpoint(accountID, id int) error {
+	_, err := s.db.Exec("delete from webhook_endpoint where id = $1 and account_id = $2", id, accountID)
+	return err
+}
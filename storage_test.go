@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSerializationFailure(t *testing.T) {
+	assert.True(t, isSerializationFailure(&pq.Error{Code: "40001"}))
+	assert.True(t, isSerializationFailure(fmt.Errorf("commit: %w", &pq.Error{Code: "40P01"})))
+	assert.False(t, isSerializationFailure(&pq.Error{Code: "23505"}))
+	assert.False(t, isSerializationFailure(errors.New("connection refused")))
+	assert.False(t, isSerializationFailure(nil))
+}
+
+func TestMapConstraintError(t *testing.T) {
+	err := mapConstraintError(&pq.Error{Code: "23514", Constraint: "account_balance_check"})
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+
+	err = mapConstraintError(&pq.Error{Code: "23505", Constraint: "account_number_idx"})
+	assert.ErrorIs(t, err, ErrDuplicateAccountNumber)
+
+	other := &pq.Error{Code: "23505", Constraint: "transfer_public_id_key"}
+	assert.Equal(t, other, mapConstraintError(other))
+}
+
+func TestDomainErrorsMapToStatus(t *testing.T) {
+	store := NewMemoryStorage()
+	_, err := store.GetAccountByID(42)
+	assert.ErrorIs(t, err, ErrAccountNotFound)
+
+	handler := makeHTTPHandleFunc(func(w http.ResponseWriter, r *http.Request) error { return err })
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/account/42", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error": "account not found"}`, w.Body.String())
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// sweepTransferReference marks the transfers made by sweeps. Credits from
+// them don't trigger sweeps on the receiving account, so two accounts
+// sweeping into each other can't ping-pong forever.
+const sweepTransferReference = "auto-sweep"
+
+const sweepQueueSize = 1000
+
+const NotifySweepExecuted NotificationKind = "sweep.executed"
+
+// SweepRule moves whatever the account holds above Threshold to
+// TargetAccount, e.g. a savings account, after money comes in.
+type SweepRule struct {
+	ID             int       `json:"id"`
+	AccountID      int       `json:"-"`
+	TargetAccount  int       `json:"-"`
+	TargetPublicID string    `json:"targetAccountId"`
+	Threshold      Money     `json:"threshold"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+type SweepRuleRequest struct {
+	TargetPublicID string `json:"targetAccountId"`
+	Threshold      Money  `json:"threshold"`
+}
+
+// applySweepRequest validates req and copies it onto the rule.
+func (s *APIServer) applySweepRequest(rule *SweepRule, req *SweepRuleRequest, account *Account) error {
+	threshold := req.Threshold
+	if threshold.Currency == "" {
+		threshold.Currency = account.Balance.Currency
+	}
+	if threshold.IsNegative() {
+		return ApiError{Err: "threshold must not be negative", Status: http.StatusBadRequest}
+	}
+	if threshold.Currency != account.Balance.Currency {
+		return ApiError{Err: "threshold must be in the account's currency", Status: http.StatusBadRequest}
+	}
+	if req.TargetPublicID == account.PublicID {
+		return ApiError{Err: "cannot sweep into the same account", Status: http.StatusBadRequest}
+	}
+	target, err := s.targetAccount(req.TargetPublicID)
+	if err != nil {
+		return err
+	}
+	if target.Balance.Currency != threshold.Currency {
+		return ApiError{Err: "target account holds a different currency", Status: http.StatusBadRequest}
+	}
+
+	rule.TargetAccount, rule.TargetPublicID, rule.Threshold = target.ID, target.PublicID, threshold
+	return nil
+}
+
+// targetAccount looks up the account a sweep or automation moves money
+// into by its public id.
+func (s *APIServer) targetAccount(publicID string) (*Account, error) {
+	notFound := ApiError{Err: "target account not found", Status: http.StatusBadRequest}
+	id, err := s.storage.GetAccountIDByPublicID(publicID)
+	if err != nil {
+		return nil, notFound
+	}
+	target, err := s.storage.GetAccountByID(id)
+	if err != nil {
+		return nil, notFound
+	}
+	return target, nil
+}
+
+func (s *APIServer) HandleSweepRules(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		rules, err := s.storage.GetSweepRules(id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, rules)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(SweepRuleRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	rule := &SweepRule{AccountID: id, CreatedAt: time.Now().UTC()}
+	if err := s.applySweepRequest(rule, req, accountFromContext(r)); err != nil {
+		return err
+	}
+	if err := s.storage.CreateSweepRule(rule); err != nil {
+		return err
+	}
+	s.sweeps.Enqueue(id)
+
+	return writeJSON(w, http.StatusCreated, rule)
+}
+
+func (s *APIServer) HandleSweepRule(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	ruleID, err := getIntVar(r, "ruleID")
+	if err != nil {
+		return err
+	}
+
+	rule, err := s.storage.GetSweepRule(id, ruleID)
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return writeJSON(w, http.StatusOK, rule)
+	case http.MethodPut:
+		req := new(SweepRuleRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return invalidRequest
+		}
+		defer r.Body.Close()
+
+		if err := s.applySweepRequest(rule, req, accountFromContext(r)); err != nil {
+			return err
+		}
+		if err := s.storage.UpdateSweepRule(rule); err != nil {
+			return err
+		}
+		s.sweeps.Enqueue(id)
+		return writeJSON(w, http.StatusOK, rule)
+	case http.MethodDelete:
+		if err := s.storage.DeleteSweepRule(id, ruleID); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, map[string]int{"deleted": ruleID})
+	}
+
+	return methodNotAllowed
+}
+
+// SweepEvaluator applies an account's sweep rules after money was credited
+// to it. Accounts are queued by the AlertingStorage and swept in the
+// background, so the credit itself never waits for the sweep.
+type SweepEvaluator struct {
+	storage  Storage
+	notifier Notifier
+	queue    chan int
+}
+
+func NewSweepEvaluator(store Storage, notifier Notifier) *SweepEvaluator {
+	return &SweepEvaluator{storage: store, notifier: notifier, queue: make(chan int, sweepQueueSize)}
+}
+
+// Enqueue asks for the account's rules to be evaluated. When the queue is
+// full the credit is not swept until the account's next credit.
+func (e *SweepEvaluator) Enqueue(accountID int) {
+	select {
+	case e.queue <- accountID:
+	default:
+		log.Printf("Sweep queue is full, skipping account %d\n", accountID)
+	}
+}
+
+func (e *SweepEvaluator) Run() {
+	for accountID := range e.queue {
+		if err := e.sweep(accountID); err != nil {
+			log.Printf("Failed to sweep account %d: %v\n", accountID, err)
+		}
+	}
+}
+
+// sweep applies the rules with the highest threshold first, so several
+// rules split the balance into tiers instead of the lowest one taking all.
+func (e *SweepEvaluator) sweep(accountID int) error {
+	rules, err := e.storage.GetSweepRules(accountID)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Threshold.Amount > rules[j].Threshold.Amount })
+
+	for _, rule := range rules {
+		account, err := e.storage.GetAccountByID(accountID)
+		if err != nil {
+			return err
+		}
+		excess, err := account.Balance.Sub(rule.Threshold)
+		if err != nil || !excess.IsPositive() {
+			continue
+		}
+
+		t := NewTransfer(accountID, rule.TargetAccount, excess)
+		t.Reference = sweepTransferReference
+		if err := e.storage.CreateTransfer(t); err != nil {
+			return err
+		}
+		if err := e.storage.ExecuteTransfer(t); err != nil {
+			return err
+		}
+		if t.Status != TransferSettled {
+			log.Printf("Sweep transfer %s failed: %s\n", t.PublicID, t.FailureReason)
+			continue
+		}
+
+		n := NewNotification(accountID, NotifySweepExecuted,
+			fmt.Sprintf("%s above %s was swept to account %s.", excess, rule.Threshold, t.ToPublicID))
+		if err := e.notifier.Notify(n); err != nil {
+			log.Println("Failed to send sweep notification: ", err)
+		}
+	}
+	return nil
+}
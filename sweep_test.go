@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweepMovesExcessAfterCredit(t *testing.T) {
+	notifier := &recordingNotifier{}
+	store := NewAlertingStorage(NewMemoryStorage(), notifier)
+	sweeps := NewSweepEvaluator(store, notifier)
+	store.onCredit = sweeps.Enqueue
+
+	checking := createTestAccount(t, store, 0)
+	savings := createTestAccount(t, store, 0)
+	payer := createTestAccount(t, store, 1000)
+	assert.Nil(t, store.CreateSweepRule(&SweepRule{
+		AccountID:     checking.ID,
+		TargetAccount: savings.ID,
+		Threshold:     NewMoney(300, defaultCurrency),
+	}))
+
+	transfer := NewTransfer(payer.ID, checking.ID, NewMoney(800, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(transfer))
+	assert.Nil(t, store.ExecuteTransfer(transfer))
+
+	assert.Len(t, sweeps.queue, 1)
+	assert.Nil(t, sweeps.sweep(<-sweeps.queue))
+	assert.Equal(t, int64(300), balanceOf(t, store, checking.ID))
+	assert.Equal(t, int64(500), balanceOf(t, store, savings.ID))
+	assert.Equal(t, NotifySweepExecuted, notifier.sent[len(notifier.sent)-1].Kind)
+	assert.Contains(t, notifier.sent[len(notifier.sent)-1].Message, savings.PublicID)
+
+	// The sweep's own credit to savings doesn't queue another sweep.
+	assert.Len(t, sweeps.queue, 0)
+}
+
+func TestSweepAppliesHighestThresholdFirst(t *testing.T) {
+	store := NewMemoryStorage()
+	sweeps := NewSweepEvaluator(store, &recordingNotifier{})
+
+	acc := createTestAccount(t, store, 1000)
+	low := createTestAccount(t, store, 0)
+	high := createTestAccount(t, store, 0)
+	assert.Nil(t, store.CreateSweepRule(&SweepRule{AccountID: acc.ID, TargetAccount: low.ID, Threshold: NewMoney(200, defaultCurrency)}))
+	assert.Nil(t, store.CreateSweepRule(&SweepRule{AccountID: acc.ID, TargetAccount: high.ID, Threshold: NewMoney(600, defaultCurrency)}))
+
+	assert.Nil(t, sweeps.sweep(acc.ID))
+	assert.Equal(t, int64(200), balanceOf(t, store, acc.ID))
+	assert.Equal(t, int64(400), balanceOf(t, store, high.ID))
+	assert.Equal(t, int64(400), balanceOf(t, store, low.ID))
+}
+
+func TestSweepRuleTargetsPublicID(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	checking := createTestAccount(t, store, 0)
+	savings := createTestAccount(t, store, 0)
+	token, err := createJWT(checking)
+	assert.Nil(t, err)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/account/"+checking.PublicID+"/sweeps"+path, strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "", `{"targetAccountId":"`+savings.PublicID+`","threshold":{"amount":300}}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var body map[string]any
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, savings.PublicID, body["targetAccountId"])
+	assert.NotContains(t, body, "targetAccount")
+
+	rules, err := store.GetSweepRules(checking.ID)
+	assert.Nil(t, err)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, savings.ID, rules[0].TargetAccount)
+
+	rec = do(http.MethodGet, "", "")
+	assert.Contains(t, rec.Body.String(), `"targetAccountId":"`+savings.PublicID+`"`)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "", `{"targetAccountId":"`+NewULID()+`"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "", `{"targetAccountId":"`+checking.PublicID+`"}`).Code)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const ActivityBillPayment ActivityType = "bill_payment"
+
+type ChangeKind string
+
+const (
+	ChangeNew      ChangeKind = "new"
+	ChangeUpdated  ChangeKind = "updated"
+	ChangeReversed ChangeKind = "reversed"
+)
+
+// ChangeQuery selects the records of an account changed after (Since,
+// AfterID), oldest change first.
+type ChangeQuery struct {
+	AccountID int
+	Since     time.Time
+	AfterID   int
+	Limit     int
+}
+
+// SyncChange is a record that is new or changed since the client's cursor.
+// Data holds the record as it is now.
+type SyncChange struct {
+	Type      ActivityType `json:"type"`
+	Change    ChangeKind   `json:"change"`
+	ID        int          `json:"-"`
+	ChangedAt time.Time    `json:"changedAt"`
+	Data      any          `json:"data"`
+}
+
+// SyncPage always carries a cursor to resume from, even when there were no
+// changes, so clients can store it unconditionally.
+type SyncPage struct {
+	Changes    []SyncChange `json:"changes"`
+	NextCursor string       `json:"nextCursor"`
+	HasMore    bool         `json:"hasMore"`
+}
+
+// changeQueryFor returns the query a source of type t has to run to
+// continue after the cursor. The sync feed runs oldest first, ordered by
+// change time, then type, then id.
+func (c *activityCursor) changeQueryFor(t ActivityType, q ChangeQuery) ChangeQuery {
+	if c == nil {
+		q.AfterID = -1
+		return q
+	}
+
+	q.Since = c.OccurredAt
+	switch {
+	case t < c.Type:
+		q.AfterID = math.MaxInt32
+	case t == c.Type:
+		q.AfterID = c.ID
+	default:
+		q.AfterID = -1
+	}
+	return q
+}
+
+// changeKind tells a client whether it has seen the record before: records
+// created after the cursor are new to it.
+func changeKind(createdAt time.Time, cursor *activityCursor) ChangeKind {
+	if cursor == nil || createdAt.After(cursor.OccurredAt) {
+		return ChangeNew
+	}
+	return ChangeUpdated
+}
+
+// HandleTransactionsSync returns the account's transfers, cash operations
+// and bill payments that are new or changed since ?since_cursor=, for
+// clients that keep a local copy. Without a cursor it returns everything.
+func (s *APIServer) HandleTransactionsSync(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	limit, err := getPageLimit(r)
+	if err != nil {
+		return err
+	}
+
+	var cursor *activityCursor
+	if v := r.URL.Query().Get("since_cursor"); v != "" {
+		if cursor, err = parseActivityCursor(v); err != nil {
+			return err
+		}
+	}
+
+	// Each source may fill the page on its own, so fetch one more than the
+	// limit to know whether there is more.
+	q := ChangeQuery{AccountID: id, Limit: limit + 1}
+	changes := []SyncChange{}
+
+	transfers, err := s.storage.GetTransferChanges(cursor.changeQueryFor(ActivityTransfer, q))
+	if err != nil {
+		return err
+	}
+	for _, t := range transfers {
+		changes = append(changes, SyncChange{Type: ActivityTransfer, Change: changeKind(t.CreatedAt, cursor),
+			ID: t.ID, ChangedAt: t.UpdatedAt, Data: t})
+	}
+
+	ops, err := s.storage.GetCashOperationChanges(cursor.changeQueryFor(ActivityCash, q))
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		changes = append(changes, SyncChange{Type: ActivityCash, Change: ChangeNew, ID: op.ID,
+			ChangedAt: op.CreatedAt, Data: op})
+	}
+
+	payments, err := s.storage.GetBillPaymentChanges(cursor.changeQueryFor(ActivityBillPayment, q))
+	if err != nil {
+		return err
+	}
+	for _, p := range payments {
+		change := changeKind(p.CreatedAt, cursor)
+		if p.Status == BillPaymentFailed && p.FailureReason == billPaymentRejectedReason {
+			change = ChangeReversed
+		}
+		changes = append(changes, SyncChange{Type: ActivityBillPayment, Change: change, ID: p.ID,
+			ChangedAt: p.UpdatedAt, Data: p})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if !a.ChangedAt.Equal(b.ChangedAt) {
+			return a.ChangedAt.Before(b.ChangedAt)
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.ID < b.ID
+	})
+
+	page := SyncPage{Changes: changes}
+	if len(changes) > limit {
+		page.Changes, page.HasMore = changes[:limit], true
+	}
+	if len(page.Changes) > 0 {
+		last := page.Changes[len(page.Changes)-1]
+		page.NextCursor = activityCursor{OccurredAt: last.ChangedAt, Type: last.Type, ID: last.ID}.String()
+	} else if cursor != nil {
+		page.NextCursor = cursor.String()
+	} else {
+		page.NextCursor = activityCursor{OccurredAt: time.Unix(0, 0).UTC()}.String()
+	}
+
+	return writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferChangesResumeAfterCursor(t *testing.T) {
+	store := NewMemoryStorage()
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+
+	first := NewTransfer(from.ID, to.ID, NewMoney(100, defaultCurrency))
+	assert.Nil(t, store.CreateTransfer(first))
+	second := NewTransfer(from.ID, to.ID, NewMoney(100, defaultCurrency))
+	second.CreatedAt, second.UpdatedAt = first.CreatedAt, first.UpdatedAt
+	assert.Nil(t, store.CreateTransfer(second))
+
+	var cursor *activityCursor
+	q := ChangeQuery{AccountID: from.ID, Limit: 10}
+	changes, err := store.GetTransferChanges(cursor.changeQueryFor(ActivityTransfer, q))
+	assert.Nil(t, err)
+	assert.Len(t, changes, 2)
+
+	// Resuming after the first transfer still returns the second one that
+	// changed at the same instant.
+	cursor = &activityCursor{OccurredAt: first.UpdatedAt, Type: ActivityTransfer, ID: first.ID}
+	changes, err = store.GetTransferChanges(cursor.changeQueryFor(ActivityTransfer, q))
+	assert.Nil(t, err)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, second.ID, changes[0].ID)
+
+	// Settling the first transfer makes it show up again as an update.
+	cursor.ID = second.ID
+	assert.Nil(t, store.ExecuteTransfer(first))
+	changes, err = store.GetTransferChanges(cursor.changeQueryFor(ActivityTransfer, q))
+	assert.Nil(t, err)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, first.ID, changes[0].ID)
+	assert.Equal(t, ChangeUpdated, changeKind(changes[0].CreatedAt, cursor))
+}
+
+func TestChangeQueryForOtherTypes(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cursor := &activityCursor{OccurredAt: at, Type: ActivityCash, ID: 7}
+
+	// bill_payment sorts before cash, so those at the cursor's instant were
+	// already returned; transfer sorts after, so none of them were.
+	assert.Greater(t, cursor.changeQueryFor(ActivityBillPayment, ChangeQuery{}).AfterID, 1<<30)
+	assert.Equal(t, 7, cursor.changeQueryFor(ActivityCash, ChangeQuery{}).AfterID)
+	assert.Equal(t, -1, cursor.changeQueryFor(ActivityTransfer, ChangeQuery{}).AfterID)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// SystemAccountKind names an account the bank itself owns, one of each
+// kind per currency. Nothing posts to them yet; they are created at
+// startup and listed for finance.
+type SystemAccountKind string
+
+const (
+	SystemFees     SystemAccountKind = "fees"
+	SystemInterest SystemAccountKind = "interest"
+	SystemFXSpread SystemAccountKind = "fx_spread"
+	SystemSuspense SystemAccountKind = "suspense"
+)
+
+var systemAccountKinds = []SystemAccountKind{SystemFees, SystemInterest, SystemFXSpread, SystemSuspense}
+
+// SystemAccountsReport lists what the bank's own accounts hold, for
+// finance. Customer balances are in the reconciliation report.
+type SystemAccountsReport struct {
+	GeneratedAt time.Time      `json:"generatedAt"`
+	Accounts    []AdminAccount `json:"accounts"`
+}
+
+// newSystemAccount has no password, so nobody can log in as it.
+func newSystemAccount(kind SystemAccountKind, currency string) *Account {
+	return &Account{
+		PublicID:  NewULID(),
+		FirstName: "System",
+		LastName:  string(kind),
+		Number:    rand.Int31n(math.MaxInt32),
+		Balance:   NewMoney(0, currency),
+		System:    kind,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// ensureSystemAccounts creates the system accounts missing for currency.
+// Startup only calls it for the default currency.
+func ensureSystemAccounts(store Storage, currency string) error {
+	accounts, err := store.GetSystemAccounts()
+	if err != nil {
+		return err
+	}
+
+	exists := map[SystemAccountKind]bool{}
+	for _, a := range accounts {
+		if a.Balance.Currency == currency {
+			exists[a.System] = true
+		}
+	}
+	for _, kind := range systemAccountKinds {
+		if exists[kind] {
+			continue
+		}
+		if err := store.CreateAccount(newSystemAccount(kind, currency)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *APIServer) HandleAdminSystemAccounts(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	accounts, err := s.storage.GetSystemAccounts()
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, SystemAccountsReport{GeneratedAt: time.Now().UTC(), Accounts: adminAccounts(accounts)})
+}
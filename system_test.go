@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnsureSystemAccounts(t *testing.T) {
+	store := NewMemoryStorage()
+	customer := createTestAccount(t, store, 500)
+
+	assert.Nil(t, ensureSystemAccounts(store, defaultCurrency))
+	assert.Nil(t, ensureSystemAccounts(store, defaultCurrency))
+
+	accounts, err := store.GetSystemAccounts()
+	assert.Nil(t, err)
+	assert.Len(t, accounts, len(systemAccountKinds))
+	for _, a := range accounts {
+		assert.False(t, a.ValidPassword(""))
+	}
+
+	// Customer totals leave the bank's own accounts out.
+	report, err := store.GetReconciliationReport(time.Now())
+	assert.Nil(t, err)
+	assert.Len(t, report.Balances, 1)
+	assert.Equal(t, 1, report.Balances[0].Accounts)
+
+	server := &APIServer{storage: store}
+	_, err = server.validateTransferRequest(customer, &TransferRequest{ToAccount: accounts[0].ID, Amount: NewMoney(100, defaultCurrency)})
+	assert.NotNil(t, err)
+}
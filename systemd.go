@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// sdListenFDsStart is the first file descriptor systemd passes sockets on.
+const sdListenFDsStart = 3
+
+// systemdListener returns the socket systemd passed when the process was
+// socket activated, or nil when it wasn't. systemd keeps the socket open
+// across restarts, so connections arriving meanwhile wait in its backlog
+// instead of being refused.
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	// The sockets are ours only; don't hand them down to child processes.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n > 1 {
+		log.Printf("systemd passed %d sockets, serving on the first\n", n)
+	}
+
+	f := os.NewFile(sdListenFDsStart, "LISTEN_FD_3")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// sdNotify sends state, such as "READY=1", to systemd. It does nothing
+// unless systemd asked for notifications through NOTIFY_SOCKET.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval is how often to ping systemd's watchdog: half of
+// WATCHDOG_USEC, as systemd recommends. It is 0 when the watchdog is off
+// or meant for another process.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+func runWatchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Println("Failed to notify the systemd watchdog: ", err)
+		}
+	}
+}
+
+// serve serves handler on l until SIGTERM or SIGINT, then stops accepting
+// connections and waits up to SHUTDOWN_TIMEOUT for the requests in flight.
+func serve(l net.Listener, handler http.Handler) error {
+	srv := &http.Server{Handler: handler}
+
+	stopped := make(chan error, 1)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		<-signals
+
+		if err := sdNotify("STOPPING=1"); err != nil {
+			log.Println("Failed to notify systemd: ", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+		defer cancel()
+		stopped <- srv.Shutdown(ctx)
+	}()
+
+	if err := sdNotify("READY=1"); err != nil {
+		log.Println("Failed to notify systemd: ", err)
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		go runWatchdog(interval)
+	}
+
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return <-stopped
+}
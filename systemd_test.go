@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSdNotify(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skip("unix datagram sockets unavailable: ", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	assert.Nil(t, sdNotify("READY=1"))
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	assert.Nil(t, sdNotify("READY=1"))
+}
+
+func TestSystemdEnvironment(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	l, err := systemdListener()
+	assert.Nil(t, err)
+	assert.Nil(t, l)
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	assert.Equal(t, 15*time.Second, watchdogInterval())
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	assert.Equal(t, time.Duration(0), watchdogInterval())
+}
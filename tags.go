@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Uncategorized is the category of transactions nobody tagged.
+const Uncategorized = "uncategorized"
+
+const (
+	maxCategoryLength = 50
+	maxTagLength      = 30
+	maxTags           = 10
+)
+
+// TransactionTag is how an account files one of its transfers: a category
+// and free-form tags. Both sides of a transfer tag it separately.
+type TransactionTag struct {
+	AccountID  int       `json:"-"`
+	TransferID string    `json:"transferId"`
+	Category   string    `json:"category"`
+	Tags       []string  `json:"tags"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+type TagRequest struct {
+	Category string   `json:"category"`
+	Tags     []string `json:"tags"`
+}
+
+// RetagFilter selects the transfers of an account a retag applies to.
+// Empty fields match everything.
+type RetagFilter struct {
+	// Counterparty is the public id of the other account.
+	Counterparty      string `json:"counterparty"`
+	ReferenceContains string `json:"referenceContains"`
+	// Category is the current category, e.g. uncategorized.
+	Category string `json:"category"`
+	// Direction is debit or credit.
+	Direction string `json:"direction"`
+	// From and To are RFC 3339 times or dates, as in ?from=&to=.
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RetagRequest recategorizes every transfer matching Filter. Tags replace
+// the tags of the matches when given and are kept otherwise.
+type RetagRequest struct {
+	Filter   RetagFilter `json:"filter"`
+	Category string      `json:"category"`
+	Tags     []string    `json:"tags"`
+}
+
+type RetagResult struct {
+	Retagged int `json:"retagged"`
+}
+
+// CategorySummary is what an account spent and received in a category.
+type CategorySummary struct {
+	Category     string `json:"category"`
+	Spent        Money  `json:"spent"`
+	Received     Money  `json:"received"`
+	Transactions int    `json:"transactions"`
+}
+
+// normalizeTags validates a category and tags, lowercased and trimmed.
+func normalizeTags(category string, tags []string) (string, []string, error) {
+	category = strings.ToLower(strings.TrimSpace(category))
+	if category == "" || len(category) > maxCategoryLength {
+		return "", nil, ApiError{Err: "category must be 1 to 50 characters", Status: http.StatusBadRequest}
+	}
+	if len(tags) > maxTags {
+		return "", nil, ApiError{Err: "at most 10 tags", Status: http.StatusBadRequest}
+	}
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || len(tag) > maxTagLength {
+			return "", nil, ApiError{Err: "tags must be 1 to 30 characters", Status: http.StatusBadRequest}
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return category, normalized, nil
+}
+
+// eachTransfer calls visit with every transfer of the account created in
+// [from, to), newest first.
+func (s *APIServer) eachTransfer(accountID int, from, to time.Time, visit func(*Transfer)) error {
+	page := PageQuery{After: from, Before: to, BeforeID: math.MaxInt32, Limit: maxPageLimit}
+	for {
+		transfers, err := s.storage.GetTransfers(TransferFilter{AccountID: &accountID}, page)
+		if err != nil {
+			return err
+		}
+		for _, t := range transfers {
+			visit(t)
+		}
+		if len(transfers) < page.Limit {
+			return nil
+		}
+		last := transfers[len(transfers)-1]
+		page.Before, page.BeforeID = last.CreatedAt, last.ID
+	}
+}
+
+// HandleTransactionTag reads or sets the category and tags of one
+// transfer of the account.
+func (s *APIServer) HandleTransactionTag(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	t, err := s.storage.GetTransferByPublicID(mux.Vars(r)["transferID"])
+	if err != nil {
+		return err
+	}
+	if !t.Involves(id) {
+		return fmt.Errorf("%w: %s", ErrTransferNotFound, t.PublicID)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		tags, err := s.storage.GetTransactionTags(id)
+		if err != nil {
+			return err
+		}
+		tag, ok := tags[t.PublicID]
+		if !ok {
+			tag = &TransactionTag{AccountID: id, TransferID: t.PublicID, Category: Uncategorized, Tags: []string{}, UpdatedAt: t.CreatedAt}
+		}
+		return writeJSON(w, http.StatusOK, tag)
+	case http.MethodPut:
+		req := new(TagRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return invalidRequest
+		}
+		defer r.Body.Close()
+
+		category, tags, err := normalizeTags(req.Category, req.Tags)
+		if err != nil {
+			return err
+		}
+		tag := &TransactionTag{AccountID: id, TransferID: t.PublicID, Category: category, Tags: tags, UpdatedAt: time.Now().UTC()}
+		if err := s.storage.SaveTransactionTags([]*TransactionTag{tag}); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, tag)
+	}
+	return methodNotAllowed
+}
+
+// HandleRetagTransactions recategorizes the account's transfers matching
+// a filter in one go, e.g. everything paid to a landlord as rent.
+func (s *APIServer) HandleRetagTransactions(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	req := new(RetagRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	category, tags, err := normalizeTags(req.Category, req.Tags)
+	if err != nil {
+		return err
+	}
+	f := req.Filter
+	if f.Direction != "" && f.Direction != "debit" && f.Direction != "credit" {
+		return ApiError{Err: "direction must be debit or credit", Status: http.StatusBadRequest}
+	}
+	counterpartyID := 0
+	if f.Counterparty != "" {
+		if counterpartyID, err = s.storage.GetAccountIDByPublicID(f.Counterparty); err != nil {
+			return ApiError{Err: "counterparty not found", Status: http.StatusBadRequest}
+		}
+	}
+	period := Period{To: time.Now().UTC().Add(time.Second)}
+	if f.From != "" {
+		if period.From, err = parseBoundary(f.From, time.UTC, false); err != nil {
+			return err
+		}
+	}
+	if f.To != "" {
+		if period.To, err = parseBoundary(f.To, time.UTC, true); err != nil {
+			return err
+		}
+	}
+
+	existing, err := s.storage.GetTransactionTags(id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	retagged := []*TransactionTag{}
+	err = s.eachTransfer(id, period.From, period.To, func(t *Transfer) {
+		current, tagged := existing[t.PublicID]
+		counterparty, debit := t.ToAccount, t.FromAccount == id
+		if !debit {
+			counterparty = t.FromAccount
+		}
+		switch {
+		case counterpartyID != 0 && counterpartyID != counterparty:
+			return
+		case f.ReferenceContains != "" && !strings.Contains(strings.ToLower(t.Reference), strings.ToLower(f.ReferenceContains)):
+			return
+		case f.Direction == "debit" && !debit, f.Direction == "credit" && debit:
+			return
+		case f.Category != "" && (tagged && current.Category != strings.ToLower(f.Category) || !tagged && f.Category != Uncategorized):
+			return
+		}
+
+		tag := &TransactionTag{AccountID: id, TransferID: t.PublicID, Category: category, Tags: tags, UpdatedAt: now}
+		if req.Tags == nil {
+			tag.Tags = []string{}
+			if tagged {
+				tag.Tags = current.Tags
+			}
+		}
+		retagged = append(retagged, tag)
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.storage.SaveTransactionTags(retagged); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, RetagResult{Retagged: len(retagged)})
+}
+
+// HandleGetCategories sums the account's settled transfers in a period by
+// category, for budgets and spending analytics.
+func (s *APIServer) HandleGetCategories(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	period, err := parsePeriod(r)
+	if err != nil {
+		return err
+	}
+	account, err := s.storage.GetAccountByID(id)
+	if err != nil {
+		return err
+	}
+	tags, err := s.storage.GetTransactionTags(id)
+	if err != nil {
+		return err
+	}
+
+	currency := account.Balance.Currency
+	summaries := map[string]*CategorySummary{}
+	err = s.eachTransfer(id, period.From, period.To, func(t *Transfer) {
+		if t.Status != TransferSettled {
+			return
+		}
+		category := Uncategorized
+		if tag, ok := tags[t.PublicID]; ok {
+			category = tag.Category
+		}
+		summary, ok := summaries[category]
+		if !ok {
+			summary = &CategorySummary{Category: category, Spent: NewMoney(0, currency), Received: NewMoney(0, currency)}
+			summaries[category] = summary
+		}
+		summary.Transactions++
+		if t.FromAccount == id {
+			summary.Spent.Amount += t.Amount.Amount
+		} else if t.Credit != nil {
+			summary.Received.Amount += t.Credit.Amount
+		} else {
+			summary.Received.Amount += t.Amount.Amount
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	result := make([]*CategorySummary, 0, len(summaries))
+	for _, summary := range summaries {
+		result = append(result, summary)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Category < result[j].Category })
+	return writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetagTransactionsFeedsCategories(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	acc := createTestAccount(t, store, 1000)
+	landlord := createTestAccount(t, store, 0)
+	shop := createTestAccount(t, store, 0)
+	token, err := createJWT(acc)
+	assert.Nil(t, err)
+
+	pay := func(to *Account, amount int64, reference string) *Transfer {
+		transfer := NewTransfer(acc.ID, to.ID, NewMoney(amount, defaultCurrency))
+		transfer.Reference = reference
+		assert.Nil(t, store.CreateTransfer(transfer))
+		assert.Nil(t, store.ExecuteTransfer(transfer))
+		return transfer
+	}
+	pay(landlord, 300, "March rent")
+	pay(landlord, 300, "April rent")
+	groceries := pay(shop, 50, "")
+
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	base := "/account/" + strconv.Itoa(acc.ID)
+
+	rec := call(http.MethodPut, base+"/transactions/"+groceries.PublicID+"/tags", `{"category":"Groceries","tags":["weekly"," Weekly "]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var tag TransactionTag
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &tag))
+	assert.Equal(t, "groceries", tag.Category)
+	assert.Equal(t, []string{"weekly"}, tag.Tags)
+
+	rec = call(http.MethodPost, base+"/transactions/retag",
+		`{"filter":{"counterparty":"`+landlord.PublicID+`","category":"uncategorized"},"category":"rent"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var result RetagResult
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, 2, result.Retagged)
+
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, base+"/transactions/retag", `{"category":""}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, base+"/transactions/retag",
+		`{"filter":{"counterparty":"01ARZ3NDEKTSV4RRFFQ69G5FAV"},"category":"rent"}`).Code)
+
+	var summaries []CategorySummary
+	rec = call(http.MethodGet, base+"/categories", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &summaries))
+	if assert.Len(t, summaries, 2) {
+		assert.Equal(t, "groceries", summaries[0].Category)
+		assert.Equal(t, int64(50), summaries[0].Spent.Amount)
+		assert.Equal(t, "rent", summaries[1].Category)
+		assert.Equal(t, int64(600), summaries[1].Spent.Amount)
+		assert.Equal(t, 2, summaries[1].Transactions)
+	}
+}
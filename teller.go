@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type TellerApprovalStatus string
+
+const (
+	TellerApprovalPending  TellerApprovalStatus = "pending"
+	TellerApprovalApproved TellerApprovalStatus = "approved"
+	TellerApprovalRejected TellerApprovalStatus = "rejected"
+)
+
+// TellerApproval is a teller operation above the dual-control threshold,
+// waiting for a second teller. OperationID is the cash operation posted
+// when it was approved.
+type TellerApproval struct {
+	ID          int                  `json:"id"`
+	PublicID    string               `json:"publicId"`
+	AccountID   int                  `json:"accountId"`
+	Kind        CashOperationKind    `json:"kind"`
+	Amount      Money                `json:"amount"`
+	Reason      string               `json:"reason,omitempty"`
+	RequestedBy string               `json:"requestedBy"`
+	Status      TellerApprovalStatus `json:"status"`
+	DecidedBy   string               `json:"decidedBy,omitempty"`
+	DecidedAt   *time.Time           `json:"decidedAt,omitempty"`
+	OperationID string               `json:"operationId,omitempty"`
+	CreatedAt   time.Time            `json:"createdAt"`
+}
+
+type AdjustmentRequest struct {
+	AccountNumber int32 `json:"accountNumber"`
+	// Kind is adjustment_credit or adjustment_debit.
+	Kind   CashOperationKind `json:"kind"`
+	Amount Money             `json:"amount"`
+	Reason string            `json:"reason"`
+}
+
+// tellerTokens parses TELLER_TOKENS, a comma separated list of
+// tellerID=token pairs. Each teller has their own token, so the two
+// tellers of a dual-control operation can be told apart.
+func tellerTokens() map[string]string {
+	return tokenPairs("TELLER_TOKENS")
+}
+
+// withTellerAuth lets through branch tellers identifying with x-teller-id
+// and x-teller-token.
+func withTellerAuth(apiFunc apiFunc) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		start := time.Now()
+		id := r.Header.Get("x-teller-id")
+		expected, ok := tellerTokens()[id]
+		token := r.Header.Get("x-teller-token")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			return permissionDenied
+		}
+		r = withPrincipal(r, &Principal{Role: RoleTeller, Name: id, Scopes: []string{"cash"}})
+		if err := meterRequest(w, r); err != nil {
+			return err
+		}
+		useConsumerNaming(w, r)
+
+		timeAuth(w, start)
+		return apiFunc(w, r)
+	}
+}
+
+// tellerApprovalThreshold is the largest amount a teller may post alone.
+func tellerApprovalThreshold(currency string) Money {
+	return NewMoney(getEnvInt("TELLER_APPROVAL_THRESHOLD", 5_000_00), currency)
+}
+
+// tellerWithdrawalLimit lifts the ATM daily withdrawal limit, since tellers
+// hand out cash themselves and larger amounts need a second teller anyway.
+func tellerWithdrawalLimit(currency string) Money {
+	return NewMoney(math.MaxInt64, currency)
+}
+
+func tellerActor(r *http.Request) string {
+	return "teller:" + principalFromContext(r).Name
+}
+
+func (s *APIServer) HandleTellerCashDeposit(w http.ResponseWriter, r *http.Request) error {
+	return s.handleTellerCash(w, r, CashDeposit)
+}
+
+func (s *APIServer) HandleTellerCashWithdrawal(w http.ResponseWriter, r *http.Request) error {
+	return s.handleTellerCash(w, r, CashWithdrawal)
+}
+
+func (s *APIServer) handleTellerCash(w http.ResponseWriter, r *http.Request, kind CashOperationKind) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(CashOperationRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	return s.handleTellerOperation(w, r, &AdjustmentRequest{AccountNumber: req.AccountNumber, Kind: kind, Amount: req.Amount})
+}
+
+// HandleTellerAdjustment posts a manual correction to an account. A reason
+// is required, since it is all the audit trail has to explain it.
+func (s *APIServer) HandleTellerAdjustment(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(AdjustmentRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	if req.Kind != CashAdjustmentCredit && req.Kind != CashAdjustmentDebit {
+		return ApiError{Err: "kind must be adjustment_credit or adjustment_debit", Status: http.StatusBadRequest}
+	}
+	if req.Reason == "" {
+		return ApiError{Err: "reason is required", Status: http.StatusBadRequest}
+	}
+	return s.handleTellerOperation(w, r, req)
+}
+
+// handleTellerOperation posts the operation right away when its amount is
+// within the threshold. Larger ones wait for a second teller and are
+// answered with 202 and the pending approval.
+func (s *APIServer) handleTellerOperation(w http.ResponseWriter, r *http.Request, req *AdjustmentRequest) error {
+	account, err := s.storage.GetAccountByNumber(req.AccountNumber)
+	if err != nil {
+		return ApiError{Err: "account not found", Status: http.StatusNotFound}
+	}
+	if req.Amount.Currency == "" {
+		req.Amount.Currency = account.Balance.Currency
+	}
+	if !req.Amount.IsPositive() {
+		return ApiError{Err: "amount must be positive", Status: http.StatusBadRequest}
+	}
+
+	if cmp, err := req.Amount.Cmp(tellerApprovalThreshold(req.Amount.Currency)); err != nil || cmp > 0 {
+		a := &TellerApproval{
+			PublicID:    NewULID(),
+			AccountID:   account.ID,
+			Kind:        req.Kind,
+			Amount:      req.Amount,
+			Reason:      req.Reason,
+			RequestedBy: principalFromContext(r).Name,
+			Status:      TellerApprovalPending,
+			CreatedAt:   time.Now().UTC(),
+		}
+		if err := s.storage.CreateTellerApproval(a); err != nil {
+			return err
+		}
+		s.auditTeller(r, "teller.approval_requested", a.AccountID, map[string]string{
+			"approval": a.PublicID,
+			"kind":     string(a.Kind),
+			"amount":   a.Amount.String(),
+			"reason":   a.Reason,
+		})
+
+		w.Header().Set("Location", "/teller/approvals/"+a.PublicID)
+		return writeJSON(w, http.StatusAccepted, a)
+	}
+
+	op, err := s.postTellerOperation(r, account.ID, req.Kind, req.Amount)
+	if err != nil {
+		return err
+	}
+	details := map[string]string{"operation": op.PublicID, "amount": op.Amount.String(), "status": string(op.Status)}
+	if req.Reason != "" {
+		details["reason"] = req.Reason
+	}
+	s.auditTeller(r, "teller."+string(req.Kind), account.ID, details)
+
+	return writeJSON(w, http.StatusOK, op)
+}
+
+func (s *APIServer) postTellerOperation(r *http.Request, accountID int, kind CashOperationKind, amount Money) (*CashOperation, error) {
+	if !kind.credit() {
+		if err := s.checkDebitsAllowed(accountID, time.Now().UTC()); err != nil {
+			return nil, err
+		}
+	}
+
+	op := &CashOperation{
+		PublicID:   NewULID(),
+		AccountID:  accountID,
+		TerminalID: "teller:" + principalFromContext(r).Name,
+		Kind:       kind,
+		Amount:     amount,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := s.postCashOperation(r.Context(), op, tellerWithdrawalLimit(amount.Currency)); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (s *APIServer) auditTeller(r *http.Request, action string, accountID int, details map[string]string) {
+	if err := s.storage.CreateAuditEvent(NewAuditEvent(tellerActor(r), action, accountID, details)); err != nil {
+		log.Println("Failed to audit teller operation: ", err)
+	}
+}
+
+// HandleTellerApprovals lists the operations waiting for a second teller,
+// oldest first.
+func (s *APIServer) HandleTellerApprovals(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	approvals, err := s.storage.GetPendingTellerApprovals()
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, approvals)
+}
+
+// HandleTellerApprove posts a pending operation. The teller who requested
+// it can't approve it themselves.
+func (s *APIServer) HandleTellerApprove(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	a, err := s.storage.GetTellerApproval(mux.Vars(r)["approvalID"])
+	if err != nil {
+		return err
+	}
+	if a.Status != TellerApprovalPending {
+		return ErrStateConflict
+	}
+	teller := principalFromContext(r).Name
+	if a.RequestedBy == teller {
+		return ApiError{Err: "a second teller must approve this operation", Status: http.StatusForbidden}
+	}
+	if !a.Kind.credit() {
+		if err := s.checkDebitsAllowed(a.AccountID, time.Now().UTC()); err != nil {
+			return err
+		}
+	}
+
+	// Deciding first means two tellers approving at once can't both post
+	// the operation; the loser gets a state conflict.
+	now := time.Now().UTC()
+	a.Status, a.DecidedBy, a.DecidedAt = TellerApprovalApproved, teller, &now
+	a.OperationID = NewULID()
+	if err := s.storage.DecideTellerApproval(a); err != nil {
+		return err
+	}
+
+	op := &CashOperation{
+		PublicID:   a.OperationID,
+		AccountID:  a.AccountID,
+		TerminalID: "teller:" + a.RequestedBy,
+		Kind:       a.Kind,
+		Amount:     a.Amount,
+		CreatedAt:  now,
+	}
+	if err := s.postCashOperation(r.Context(), op, tellerWithdrawalLimit(a.Amount.Currency)); err != nil {
+		return err
+	}
+	s.auditTeller(r, "teller.approved", a.AccountID, map[string]string{
+		"approval":  a.PublicID,
+		"operation": op.PublicID,
+		"status":    string(op.Status),
+	})
+
+	return writeJSON(w, http.StatusOK, op)
+}
+
+func (s *APIServer) HandleTellerReject(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	a, err := s.storage.GetTellerApproval(mux.Vars(r)["approvalID"])
+	if err != nil {
+		return err
+	}
+	if a.Status != TellerApprovalPending {
+		return ErrStateConflict
+	}
+
+	now := time.Now().UTC()
+	a.Status, a.DecidedBy, a.DecidedAt = TellerApprovalRejected, principalFromContext(r).Name, &now
+	if err := s.storage.DecideTellerApproval(a); err != nil {
+		return err
+	}
+	s.auditTeller(r, "teller.rejected", a.AccountID, map[string]string{"approval": a.PublicID})
+
+	return writeJSON(w, http.StatusOK, a)
+}
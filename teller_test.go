@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTellerDualControl(t *testing.T) {
+	t.Setenv("TELLER_TOKENS", "alice=a-token,bob=b-token")
+	t.Setenv("TELLER_APPROVAL_THRESHOLD", "1000")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	account := createTestAccount(t, store, 500)
+
+	call := func(teller, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("x-teller-id", teller)
+		req.Header.Set("x-teller-token", teller[:1]+"-token")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	number := strconv.Itoa(int(account.Number))
+
+	rec := call("alice", "/teller/cash/deposit", `{"accountNumber":`+number+`,"amount":{"amount":1000}}`)
+	assert.Equal(t, http.StatusOK, rec.Code, "amounts up to the threshold post right away")
+	assert.Equal(t, int64(1500), balanceOf(t, store, account.ID))
+
+	rec = call("alice", "/teller/adjustments", `{"accountNumber":`+number+`,"kind":"adjustment_debit","amount":{"amount":1200}}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "adjustments need a reason")
+
+	rec = call("alice", "/teller/adjustments",
+		`{"accountNumber":`+number+`,"kind":"adjustment_debit","amount":{"amount":1200},"reason":"miscounted deposit"}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	approval := new(TellerApproval)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(approval))
+	assert.Equal(t, TellerApprovalPending, approval.Status)
+	assert.Equal(t, int64(1500), balanceOf(t, store, account.ID), "nothing is posted before approval")
+
+	pending, err := store.GetPendingTellerApprovals()
+	assert.Nil(t, err)
+	assert.Len(t, pending, 1)
+
+	rec = call("alice", "/teller/approvals/"+approval.PublicID+"/approve", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code, "the requesting teller can't approve")
+
+	rec = call("bob", "/teller/approvals/"+approval.PublicID+"/approve", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	op := new(CashOperation)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(op))
+	assert.Equal(t, CashCompleted, op.Status)
+	assert.Equal(t, int64(300), balanceOf(t, store, account.ID))
+
+	rec = call("bob", "/teller/approvals/"+approval.PublicID+"/approve", "")
+	assert.Equal(t, http.StatusConflict, rec.Code, "an approval is posted once")
+
+	rec = call("bob", "/teller/cash/withdrawal", `{"accountNumber":`+number+`,"amount":{"amount":5000}}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(approval))
+	rec = call("alice", "/teller/approvals/"+approval.PublicID+"/reject", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(300), balanceOf(t, store, account.ID))
+
+	events, err := store.GetAuditEvents(account.ID, 10)
+	assert.Nil(t, err)
+	actions := []string{}
+	for _, e := range events {
+		actions = append(actions, e.Actor+" "+e.Action)
+	}
+	assert.ElementsMatch(t, []string{
+		"teller:alice teller.deposit",
+		"teller:alice teller.approval_requested",
+		"teller:bob teller.approved",
+		"teller:bob teller.approval_requested",
+		"teller:alice teller.rejected",
+	}, actions)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Terms is a published version of the terms of service. Accepting a
+// version accepts every earlier one too. Until an account has accepted the
+// latest mandatory version, or a later one, it can't move money.
+type Terms struct {
+	ID          int       `json:"-"`
+	Version     string    `json:"version"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Mandatory   bool      `json:"mandatory"`
+	PublishedAt time.Time `json:"publishedAt"`
+}
+
+type TermsRequest struct {
+	Version   string `json:"version"`
+	Title     string `json:"title"`
+	URL       string `json:"url"`
+	Mandatory bool   `json:"mandatory"`
+}
+
+type TermsAcceptance struct {
+	AccountID  int       `json:"-"`
+	Version    string    `json:"version"`
+	IP         string    `json:"ip"`
+	AcceptedAt time.Time `json:"acceptedAt"`
+}
+
+type AcceptTermsRequest struct {
+	Version string `json:"version"`
+}
+
+// TermsStatus tells a customer which terms they still have to look at.
+// Pending lists the versions published since the one they last accepted.
+type TermsStatus struct {
+	Latest           *Terms           `json:"latest,omitempty"`
+	Accepted         *TermsAcceptance `json:"accepted,omitempty"`
+	Pending          []*Terms         `json:"pending"`
+	TransfersBlocked bool             `json:"transfersBlocked"`
+}
+
+// termsStatus works out where accountID stands against the published terms,
+// which storage returns oldest first.
+func (s *APIServer) termsStatus(accountID int) (*TermsStatus, error) {
+	terms, err := s.storage.GetTerms()
+	if err != nil {
+		return nil, err
+	}
+	acceptances, err := s.storage.GetTermsAcceptances(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	accepted := map[string]*TermsAcceptance{}
+	for _, a := range acceptances {
+		accepted[a.Version] = a
+	}
+
+	status := &TermsStatus{Pending: []*Terms{}}
+	for _, t := range terms {
+		status.Latest = t
+		if a, ok := accepted[t.Version]; ok {
+			status.Accepted, status.Pending, status.TransfersBlocked = a, []*Terms{}, false
+			continue
+		}
+		status.Pending = append(status.Pending, t)
+		status.TransfersBlocked = status.TransfersBlocked || t.Mandatory
+	}
+	return status, nil
+}
+
+// checkTermsAccepted refuses to move money for an account that hasn't
+// accepted the latest mandatory terms.
+func (s *APIServer) checkTermsAccepted(accountID int) error {
+	status, err := s.termsStatus(accountID)
+	if err != nil {
+		return err
+	}
+	if status.TransfersBlocked {
+		return ErrTermsNotAccepted
+	}
+	return nil
+}
+
+// checkSignupTerms requires a new customer to accept the latest terms, once
+// any are published.
+func (s *APIServer) checkSignupTerms(version string) error {
+	terms, err := s.storage.GetTerms()
+	if err != nil {
+		return err
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+	if latest := terms[len(terms)-1]; version != latest.Version {
+		return ApiError{Err: "acceptedTerms must be the latest terms version: " + latest.Version, Status: http.StatusBadRequest}
+	}
+	return nil
+}
+
+// acceptTerms records that the account accepted version, which has to be
+// published.
+func (s *APIServer) acceptTerms(r *http.Request, accountID int, version string) (*TermsAcceptance, error) {
+	terms, err := s.storage.GetTerms()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range terms {
+		if t.Version == version {
+			acceptance := &TermsAcceptance{
+				AccountID:  accountID,
+				Version:    version,
+				IP:         clientIP(r),
+				AcceptedAt: time.Now().UTC(),
+			}
+			return acceptance, s.storage.AcceptTerms(acceptance)
+		}
+	}
+	return nil, ApiError{Err: "unknown terms version: " + version, Status: http.StatusBadRequest}
+}
+
+func (s *APIServer) HandleTerms(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		status, err := s.termsStatus(id)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, status)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(AcceptTermsRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	acceptance, err := s.acceptTerms(r, id, req.Version)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, acceptance)
+}
+
+// HandleAdminTerms lists and publishes terms versions. Publishing a
+// mandatory version blocks transfers of every account until it is accepted.
+func (s *APIServer) HandleAdminTerms(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodGet {
+		terms, err := s.storage.GetTerms()
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, terms)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(TermsRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	terms := &Terms{
+		Version:     strings.TrimSpace(req.Version),
+		Title:       strings.TrimSpace(req.Title),
+		URL:         strings.TrimSpace(req.URL),
+		Mandatory:   req.Mandatory,
+		PublishedAt: time.Now().UTC(),
+	}
+	if terms.Version == "" || terms.Title == "" || terms.URL == "" {
+		return ApiError{Err: "version, title and url are required", Status: http.StatusBadRequest}
+	}
+
+	if err := s.storage.CreateTerms(terms); err != nil {
+		return err
+	}
+
+	event := NewAuditEvent(adminActor(r), "terms.published", 0, map[string]string{
+		"version":   terms.Version,
+		"mandatory": strconv.FormatBool(terms.Mandatory),
+	})
+	if err := s.storage.CreateAuditEvent(event); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, terms)
+}
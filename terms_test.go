@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMandatoryTermsBlockTransfers(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+	token, err := createJWT(from)
+	assert.Nil(t, err)
+
+	do := func(method, path, body string, admin bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if admin {
+			req.Header.Set("x-admin-token", "test-admin")
+		} else {
+			req.Header.Set("x-jwt-token", token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	transfer := `{"toAccount":` + strconv.Itoa(to.ID) + `,"amount":{"amount":100}}`
+	termsPath := "/account/" + strconv.Itoa(from.ID) + "/terms"
+
+	rec := do(http.MethodPost, "/admin/terms", `{"version":"2026-10","title":"Terms of service","url":"https://example.com/terms/2026-10","mandatory":true}`, true)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/admin/terms", `{"version":"2026-10","title":"again","url":"https://example.com"}`, true).Code)
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/transfer", transfer, false).Code)
+	assert.Contains(t, do(http.MethodGet, termsPath, "", false).Body.String(), `"transfersBlocked":true`)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, termsPath, `{"version":"2025-01"}`, false).Code)
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, termsPath, `{"version":"2026-10"}`, false).Code)
+
+	rec = do(http.MethodGet, termsPath, "", false)
+	assert.Contains(t, rec.Body.String(), `"pending":[]`)
+	assert.Contains(t, rec.Body.String(), `"transfersBlocked":false`)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/transfer", transfer, false).Code)
+
+	// Optional terms are pending but don't block.
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/admin/terms", `{"version":"2026-11","title":"Privacy notice","url":"https://example.com/privacy"}`, true).Code)
+	rec = do(http.MethodGet, termsPath, "", false)
+	assert.Contains(t, rec.Body.String(), `"transfersBlocked":false`)
+	assert.Contains(t, rec.Body.String(), `"version":"2026-11"`)
+}
+
+func TestSignupRequiresLatestTerms(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	assert.Nil(t, store.CreateTerms(&Terms{Version: "v1", Title: "Terms", URL: "https://example.com/v1", Mandatory: true}))
+
+	signup := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/account", strings.NewReader(body)))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusBadRequest, signup(`{"firstName":"Ada","lastName":"Lovelace","password":"correct horse"}`).Code)
+
+	rec := signup(`{"firstName":"Ada","lastName":"Lovelace","password":"correct horse","acceptedTerms":"v1"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	accounts, err := store.GetAccounts()
+	assert.Nil(t, err)
+	if assert.Len(t, accounts, 1) {
+		acceptances, err := store.GetTermsAcceptances(accounts[0].ID)
+		assert.Nil(t, err)
+		assert.Len(t, acceptances, 1)
+	}
+}
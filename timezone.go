@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	_ "time/tzdata"
+)
+
+const dateLayout = "2006-01-02"
+
+// Period is a half-open [From, To) interval in UTC.
+type Period struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+func (p Period) Contains(t time.Time) bool {
+	return !t.Before(p.From) && t.Before(p.To)
+}
+
+// requestLocation returns the timezone the caller wants calendar dates
+// interpreted in, taken from the "tz" query parameter or the X-Timezone
+// header. Everything we store and return stays in UTC; the location only
+// decides where a day starts and ends.
+func requestLocation(r *http.Request) (*time.Location, error) {
+	name := r.URL.Query().Get("tz")
+	if name == "" {
+		name = r.Header.Get("X-Timezone")
+	}
+	if name == "" || name == "UTC" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, ApiError{Err: fmt.Sprintf("unknown timezone: %s", name), Status: http.StatusBadRequest}
+	}
+	return loc, nil
+}
+
+// parsePeriod reads the "from" and "to" query parameters. Both accept either
+// an RFC 3339 timestamp or a calendar date; dates are resolved in the request
+// timezone and "to" includes the whole day. Missing bounds default to the
+// zero time and now.
+func parsePeriod(r *http.Request) (Period, error) {
+	loc, err := requestLocation(r)
+	if err != nil {
+		return Period{}, err
+	}
+
+	q := r.URL.Query()
+	period := Period{To: time.Now().UTC()}
+
+	if v := q.Get("from"); v != "" {
+		from, err := parseBoundary(v, loc, false)
+		if err != nil {
+			return Period{}, err
+		}
+		period.From = from
+	}
+	if v := q.Get("to"); v != "" {
+		to, err := parseBoundary(v, loc, true)
+		if err != nil {
+			return Period{}, err
+		}
+		period.To = to
+	}
+
+	if period.To.Before(period.From) {
+		return Period{}, ApiError{Err: "invalid period: from is after to", Status: http.StatusBadRequest}
+	}
+	return period, nil
+}
+
+func parseBoundary(v string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+
+	day, err := time.ParseInLocation(dateLayout, v, loc)
+	if err != nil {
+		return time.Time{}, ApiError{Err: fmt.Sprintf("invalid date: %s", v), Status: http.StatusBadRequest}
+	}
+	if end {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day.UTC(), nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePeriodUsesRequestTimezone(t *testing.T) {
+	r := httptest.NewRequest("GET", "/?from=2024-06-01&to=2024-06-30&tz=Europe/Kyiv", nil)
+
+	period, err := parsePeriod(r)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2024, 5, 31, 21, 0, 0, 0, time.UTC), period.From)
+	assert.Equal(t, time.Date(2024, 6, 30, 21, 0, 0, 0, time.UTC), period.To)
+	assert.Equal(t, time.UTC, period.From.Location())
+}
+
+func TestParsePeriodRejectsUnknownTimezone(t *testing.T) {
+	r := httptest.NewRequest("GET", "/?from=2024-06-01&tz=Mars/Olympus", nil)
+
+	_, err := parsePeriod(r)
+	assert.NotNil(t, err)
+}
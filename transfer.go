@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type TransferStatus string
+
+// A transfer is accepted, picked up for processing and ends up either
+// settled or failed. Transfers flagged as possible fraud are held instead
+// until a reviewer settles or fails them.
+const (
+	TransferAccepted   TransferStatus = "accepted"
+	TransferProcessing TransferStatus = "processing"
+	TransferHeld       TransferStatus = "held"
+	TransferSettled    TransferStatus = "settled"
+	TransferFailed     TransferStatus = "failed"
+)
+
+var transferTransitions = map[TransferStatus][]TransferStatus{
+	TransferAccepted:   {TransferProcessing, TransferHeld, TransferSettled, TransferFailed},
+	TransferProcessing: {TransferSettled, TransferFailed},
+	TransferHeld:       {TransferSettled, TransferFailed},
+	TransferSettled:    {},
+	TransferFailed:     {},
+}
+
+const (
+	maxTransferStatusWait  = time.Minute
+	transferStatusPollRate = 200 * time.Millisecond
+
+	transferWorkerPollRate = time.Second
+
+	maxTransferReferenceLength = 100
+	// transferProcessingLease is how long a transfer may stay in processing
+	// before another worker assumes the first one died and claims it again.
+	transferProcessingLease = 5 * time.Minute
+)
+
+// Transfer refers to its accounts, and to the transfer a refund is for,
+// by their internal ids; the public ids are filled in by storage for the
+// API to show instead.
+type Transfer struct {
+	ID           int    `json:"-"`
+	PublicID     string `json:"publicId"`
+	FromAccount  int    `json:"-"`
+	ToAccount    int    `json:"-"`
+	FromPublicID string `json:"fromAccountId"`
+	ToPublicID   string `json:"toAccountId"`
+	Amount       Money  `json:"amount"`
+	// Credit is what the recipient gets when it differs from Amount,
+	// converted at the rate of the FX quote QuoteID.
+	Credit           *Money         `json:"credit,omitempty"`
+	QuoteID          string         `json:"quoteId,omitempty"`
+	Reference        string         `json:"reference,omitempty"`
+	RefundOf         int            `json:"-"`
+	RefundOfPublicID string         `json:"refundOfId,omitempty"`
+	Status           TransferStatus `json:"status"`
+	FailureReason    string         `json:"failureReason,omitempty"`
+	CreatedAt        time.Time      `json:"createdAt"`
+	UpdatedAt        time.Time      `json:"updatedAt"`
+}
+
+func NewTransfer(from, to int, amount Money) *Transfer {
+	now := time.Now().UTC()
+	return &Transfer{
+		PublicID:    NewULID(),
+		FromAccount: from,
+		ToAccount:   to,
+		Amount:      amount,
+		Status:      TransferAccepted,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// IsPending reports whether the transfer has not reached a final state yet.
+func (t *Transfer) IsPending() bool {
+	return t.Status == TransferAccepted || t.Status == TransferProcessing || t.Status == TransferHeld
+}
+
+// Credited is what the transfer adds to the recipient's balance.
+func (t *Transfer) Credited() Money {
+	if t.Credit != nil {
+		return *t.Credit
+	}
+	return t.Amount
+}
+
+func (t *Transfer) Involves(accountID int) bool {
+	return t.FromAccount == accountID || t.ToAccount == accountID
+}
+
+// TransferWithIDs is a transfer with the internal ids the API leaves out,
+// for the back office, whose routes take them, and for the archive.
+type TransferWithIDs struct {
+	*Transfer
+	ID          int `json:"id"`
+	FromAccount int `json:"fromAccount"`
+	ToAccount   int `json:"toAccount"`
+	RefundOf    int `json:"refundOf,omitempty"`
+}
+
+func withInternalIDs(t *Transfer) TransferWithIDs {
+	return TransferWithIDs{Transfer: t, ID: t.ID, FromAccount: t.FromAccount, ToAccount: t.ToAccount, RefundOf: t.RefundOf}
+}
+
+type TransferFilter struct {
+	AccountID *int
+	Status    TransferStatus
+}
+
+// TransferResource is a transfer as returned by the API. NextStatuses lists
+// the states it can still move to, so clients know whether to keep polling.
+type TransferResource struct {
+	*Transfer
+	NextStatuses []TransferStatus `json:"nextStatuses"`
+}
+
+func newTransferResource(t *Transfer) TransferResource {
+	return TransferResource{Transfer: t, NextStatuses: transferTransitions[t.Status]}
+}
+
+func (s *APIServer) HandleTransfer(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	transferReq := new(TransferRequest)
+	if err := json.NewDecoder(r.Body).Decode(transferReq); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	from := accountFromContext(r)
+	to, err := s.validateTransferRequest(from, transferReq)
+	if err != nil {
+		return err
+	}
+	if transferReq.ToPublicID == "" {
+		markIntegerIDDeprecated(w, r, strconv.Itoa(transferReq.ToAccount), to.PublicID)
+	}
+	existing, err := s.idempotentTransfer(r, from, transferReq)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return writeJSON(w, http.StatusOK, newTransferResource(existing))
+	}
+	var quote *FXQuote
+	var credit Money
+	if transferReq.QuoteID != "" {
+		if quote, credit, err = s.quotedCredit(from, to, transferReq); err != nil {
+			return err
+		}
+	}
+	if err := s.checkDebitsAllowed(from.ID, time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := s.checkTermsAccepted(from.ID); err != nil {
+		return err
+	}
+	if err := s.checkDuplicateTransfer(from, transferReq, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	if err := s.usage.Record(w, principalFromContext(r).consumer(), UsageTransfers); err != nil {
+		return err
+	}
+
+	transfer := NewTransfer(from.ID, transferReq.ToAccount, transferReq.Amount)
+	transfer.Reference = transferReq.Reference
+	if quote != nil {
+		transfer.Credit, transfer.QuoteID = &credit, quote.PublicID
+	}
+	if err := s.storage.CreateTransfer(transfer); err != nil {
+		return err
+	}
+	// The quote is only spent once the transfer exists, so a failed insert
+	// leaves it usable. A transfer that then can't have it fails instead.
+	if quote != nil {
+		if err := s.useFXQuote(r, quote, transfer); err != nil {
+			return s.failQuotedTransfer(transfer, err)
+		}
+	}
+	if err := s.saveIdempotencyKey(r, transfer); err != nil {
+		return err
+	}
+	if origin := s.recordTransferOrigin(r, transfer); origin != nil && origin.GeoMismatch {
+		return s.holdForReview(w, transfer)
+	}
+
+	if handled, err := s.simulateFailure(w, r, transfer); handled {
+		return err
+	}
+
+	// Clients that can't afford to block ask for "Prefer: respond-async"
+	// and follow the Location header to the status resource instead.
+	if preferAsync(r) {
+		s.transfers.Wake()
+		w.Header().Set("Location", "/transfer/"+transfer.PublicID)
+		return writeJSON(w, http.StatusAccepted, newTransferResource(transfer))
+	}
+
+	if err := s.storage.ExecuteTransfer(transfer); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, newTransferResource(transfer))
+}
+
+// validateTransferRequest fills in the default currency and the integer
+// destination id, and checks the request against the sending account. It
+// returns the destination account.
+func (s *APIServer) validateTransferRequest(from *Account, req *TransferRequest) (*Account, error) {
+	if req.ToPublicID != "" {
+		id, err := s.storage.GetAccountIDByPublicID(req.ToPublicID)
+		if err != nil {
+			return nil, ApiError{Err: "destination account not found", Status: http.StatusBadRequest}
+		}
+		req.ToAccount = id
+	}
+	if req.Amount.Currency == "" {
+		req.Amount.Currency = from.Balance.Currency
+	}
+	if !req.Amount.IsPositive() {
+		return nil, ApiError{Err: "amount must be positive", Status: http.StatusBadRequest}
+	}
+	if req.ToAccount == from.ID {
+		return nil, ApiError{Err: "cannot transfer to the same account", Status: http.StatusBadRequest}
+	}
+	if len(req.Reference) > maxTransferReferenceLength {
+		return nil, ApiError{Err: "reference is too long", Status: http.StatusBadRequest}
+	}
+	to, err := s.storage.GetAccountByID(req.ToAccount)
+	if err != nil {
+		if to, err = s.redirectedDestination(from, req); err != nil {
+			return nil, err
+		}
+	}
+	if to.System != "" {
+		return nil, ApiError{Err: "cannot transfer to a system account", Status: http.StatusBadRequest}
+	}
+	return to, nil
+}
+
+func (s *APIServer) HandleGetTransfer(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	transfer, err := s.getTransfer(w, r)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, newTransferResource(transfer))
+}
+
+// HandleTransferStatus returns the transfer once it has left the pending
+// state. With ?wait=30s it long-polls for up to that long before answering
+// with the transfer as it currently is.
+func (s *APIServer) HandleTransferStatus(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	wait := time.Duration(0)
+	var err error
+	if v := r.URL.Query().Get("wait"); v != "" {
+		wait, err = time.ParseDuration(v)
+		if err != nil || wait < 0 {
+			return ApiError{Err: "invalid wait duration: " + v, Status: http.StatusBadRequest}
+		}
+		if wait > maxTransferStatusWait {
+			wait = maxTransferStatusWait
+		}
+	}
+
+	transfer, err := s.getTransfer(w, r)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(transferStatusPollRate)
+	defer ticker.Stop()
+
+	for transfer.IsPending() {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-deadline.C:
+			return writeJSON(w, http.StatusOK, newTransferResource(transfer))
+		case <-ticker.C:
+		}
+
+		if transfer, err = s.storage.GetTransferByID(transfer.ID); err != nil {
+			return err
+		}
+	}
+
+	return writeJSON(w, http.StatusOK, newTransferResource(transfer))
+}
+
+// getTransfer loads the transfer addressed by {transferID}, either by its
+// public ULID or by its deprecated integer id. Transfers the caller isn't a
+// party to are reported as not found.
+func (s *APIServer) getTransfer(w http.ResponseWriter, r *http.Request) (*Transfer, error) {
+	idStr := mux.Vars(r)["transferID"]
+
+	var transfer *Transfer
+	var err error
+	if isULID(idStr) {
+		transfer, err = s.storage.GetTransferByPublicID(idStr)
+	} else {
+		id, convErr := strconv.Atoi(idStr)
+		if convErr != nil {
+			return nil, transferNotFound
+		}
+		transfer, err = s.storage.GetTransferByID(id)
+		if err == nil {
+			markIntegerIDDeprecated(w, r, idStr, transfer.PublicID)
+		}
+	}
+
+	if err != nil || !transfer.Involves(accountFromContext(r).ID) {
+		return nil, transferNotFound
+	}
+	return transfer, nil
+}
+
+var transferNotFound = ApiError{Err: "transfer not found", Status: http.StatusNotFound}
+
+// applyTransfer returns the balances after taking debit from one and
+// adding credit to the other, or the reason the transfer has to fail. The
+// two amounts only differ for transfers converted at an FX quote.
+func applyTransfer(from, to, debit, credit Money) (Money, Money, string) {
+	if from.Currency != debit.Currency || to.Currency != credit.Currency {
+		return from, to, "currency mismatch"
+	}
+
+	newFrom, err := from.Sub(debit)
+	if err != nil {
+		return from, to, err.Error()
+	}
+	if newFrom.IsNegative() {
+		return from, to, "insufficient funds"
+	}
+
+	newTo, err := to.Add(credit)
+	if err != nil {
+		return from, to, err.Error()
+	}
+	return newFrom, newTo, ""
+}
+
+func preferAsync(r *http.Request) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const (
+	NotifyTransferReceived NotificationKind = "transfer.received"
+	NotifyTransferFailed   NotificationKind = "transfer.failed"
+)
+
+// notifyTransferOutcome subscribes to the event bus to tell recipients
+// about money received and senders about transfers that failed. Sweeps
+// send their own notification.
+func notifyTransferOutcome(notifier Notifier) EventHandler {
+	return func(e DomainEvent) error {
+		t := e.Transfer
+		switch {
+		case t.Reference == sweepTransferReference:
+			return nil
+		case e.Kind == EventTransferSettled:
+			msg := fmt.Sprintf("You received %s from account %s.", t.Credited(), t.FromPublicID)
+			return notifier.Notify(NewNotification(t.ToAccount, NotifyTransferReceived, msg))
+		case e.Kind == EventTransferFailed:
+			msg := fmt.Sprintf("Your transfer %s of %s failed: %s.", t.PublicID, t.Amount, t.FailureReason)
+			return notifier.Notify(NewNotification(t.FromAccount, NotifyTransferFailed, msg))
+		}
+		return nil
+	}
+}
+
+// TransferProcessor executes transfers that were accepted asynchronously.
+// Transfers are claimed from storage, so several server instances can run a
+// processor side by side.
+type TransferProcessor struct {
+	storage Storage
+	wake    chan struct{}
+	// settleDelay holds accepted transfers back for a while before they
+	// are executed, so sandbox clients can observe the intermediate states.
+	settleDelay time.Duration
+}
+
+func NewTransferProcessor(store Storage, settleDelay time.Duration) *TransferProcessor {
+	return &TransferProcessor{
+		storage:     store,
+		wake:        make(chan struct{}, 1),
+		settleDelay: settleDelay,
+	}
+}
+
+// Wake makes the processor look for work right away instead of waiting for
+// its next poll.
+func (p *TransferProcessor) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *TransferProcessor) Run() {
+	ticker := time.NewTicker(transferWorkerPollRate)
+	defer ticker.Stop()
+
+	for {
+		p.processAll()
+
+		select {
+		case <-ticker.C:
+		case <-p.wake:
+		}
+	}
+}
+
+func (p *TransferProcessor) processAll() {
+	for {
+		transfer, err := p.storage.ClaimTransfer(transferProcessingLease, time.Now().UTC().Add(-p.settleDelay))
+		if err != nil {
+			log.Println("Failed to claim transfer: ", err)
+			return
+		}
+		if transfer == nil {
+			return
+		}
+
+		if err := p.storage.ExecuteTransfer(transfer); err != nil {
+			log.Printf("Failed to execute transfer %d: %v\n", transfer.ID, err)
+			return
+		}
+	}
+}
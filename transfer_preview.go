@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transferConfirmationTTL is how long a preview's confirmation token can be
+// passed to POST /transfer.
+const transferConfirmationTTL = 5 * time.Minute
+
+// TransferPreview is what a transfer would do if it were submitted now.
+// Transfers carry no fees and never convert currencies, so Fee is always
+// zero and Total equals the amount; both are here so clients don't have to
+// change when that does. Problem names why the transfer would fail.
+type TransferPreview struct {
+	Transfer          TransferRequest `json:"transfer"`
+	Fee               Money           `json:"fee"`
+	Total             Money           `json:"total"`
+	BalanceAfter      Money           `json:"balanceAfter"`
+	Problem           string          `json:"problem,omitempty"`
+	PossibleDuplicate *Transfer       `json:"possibleDuplicate,omitempty"`
+	ConfirmationToken string          `json:"confirmationToken"`
+	ExpiresAt         time.Time       `json:"expiresAt"`
+}
+
+func duplicateTransferWindow() time.Duration {
+	return getEnvDuration("DUPLICATE_TRANSFER_WINDOW", 5*time.Minute)
+}
+
+// HandlePreviewTransfer checks a transfer request without moving money and
+// warns about a near-identical transfer made within the duplicate window.
+func (s *APIServer) HandlePreviewTransfer(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(TransferRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	from := accountFromContext(r)
+	to, err := s.validateTransferRequest(from, req)
+	if err != nil {
+		return err
+	}
+	if req.ToPublicID == "" {
+		markIntegerIDDeprecated(w, r, strconv.Itoa(req.ToAccount), to.PublicID)
+	}
+
+	now := time.Now().UTC()
+	if err := s.checkDebitsAllowed(from.ID, now); err != nil {
+		return err
+	}
+	if err := s.checkTermsAccepted(from.ID); err != nil {
+		return err
+	}
+	duplicate, err := s.findDuplicateTransfer(from, req, now)
+	if err != nil {
+		return err
+	}
+
+	preview := &TransferPreview{
+		Transfer:          *req,
+		Fee:               NewMoney(0, req.Amount.Currency),
+		Total:             req.Amount,
+		PossibleDuplicate: duplicate,
+		ExpiresAt:         now.Add(transferConfirmationTTL),
+	}
+	preview.Transfer.ConfirmationToken = ""
+	preview.BalanceAfter, _, preview.Problem = applyTransfer(from.Balance, to.Balance, req.Amount, req.Amount)
+	preview.ConfirmationToken = transferConfirmationToken(from.ID, req, preview.ExpiresAt)
+
+	return writeJSON(w, http.StatusOK, preview)
+}
+
+// checkDuplicateTransfer refuses a transfer that repeats one made within
+// the duplicate window, unless the request carries a confirmation token
+// for it from a preview.
+func (s *APIServer) checkDuplicateTransfer(from *Account, req *TransferRequest, now time.Time) error {
+	if req.ConfirmationToken != "" {
+		if !validTransferConfirmation(req.ConfirmationToken, from.ID, req, now) {
+			return ApiError{Err: "invalid or expired confirmation token", Status: http.StatusBadRequest}
+		}
+		return nil
+	}
+
+	duplicate, err := s.findDuplicateTransfer(from, req, now)
+	if err != nil {
+		return err
+	}
+	if duplicate != nil {
+		return ApiError{
+			Err:    fmt.Sprintf("possible duplicate of transfer %s, confirm it through /transfer/preview", duplicate.PublicID),
+			Status: http.StatusConflict,
+		}
+	}
+	return nil
+}
+
+// findDuplicateTransfer returns the newest transfer from the account to the
+// same destination for the same amount made within the duplicate window.
+// Failed transfers don't count.
+func (s *APIServer) findDuplicateTransfer(from *Account, req *TransferRequest, now time.Time) (*Transfer, error) {
+	page := PageQuery{After: now.Add(-duplicateTransferWindow()), Before: now.Add(time.Second), BeforeID: math.MaxInt32, Limit: maxPageLimit}
+	transfers, err := s.storage.GetTransfers(TransferFilter{AccountID: &from.ID}, page)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range transfers {
+		if t.FromAccount == from.ID && t.ToAccount == req.ToAccount && t.Amount == req.Amount && t.Status != TransferFailed {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+// transferConfirmationToken binds the sender, destination, amount and
+// expiry with an HMAC under the JWT secret: "<expiry>.<mac>".
+func transferConfirmationToken(from int, req *TransferRequest, expiresAt time.Time) string {
+	exp := strconv.FormatInt(expiresAt.Unix(), 10)
+	return exp + "." + transferConfirmationMAC(from, req, exp)
+}
+
+func validTransferConfirmation(token string, from int, req *TransferRequest, now time.Time) bool {
+	exp, mac, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(transferConfirmationMAC(from, req, exp)))
+}
+
+func transferConfirmationMAC(from int, req *TransferRequest, exp string) string {
+	h := hmac.New(sha256.New, []byte("transfer-confirmation:"+getSecret()))
+	fmt.Fprintf(h, "%d|%d|%d|%s|%s", from, req.ToAccount, req.Amount.Amount, req.Amount.Currency, exp)
+	return hex.EncodeToString(h.Sum(nil))
+}
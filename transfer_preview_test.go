@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicateTransferNeedsConfirmation(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	from := createTestAccount(t, store, 1000)
+	to := createTestAccount(t, store, 0)
+	token, err := createJWT(from)
+	assert.Nil(t, err)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	body := `{"toAccount":` + strconv.Itoa(to.ID) + `,"amount":{"amount":100,"currency":"` + defaultCurrency + `"}}`
+
+	rec := post("/transfer", body)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// The same transfer again is held back until confirmed.
+	rec = post("/transfer", body)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = post("/transfer/preview", body)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var preview TransferPreview
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &preview))
+	assert.NotNil(t, preview.PossibleDuplicate)
+	assert.Equal(t, int64(800), preview.BalanceAfter.Amount)
+	assert.Equal(t, int64(0), preview.Fee.Amount)
+
+	// A token for a different amount doesn't confirm this one.
+	other := strings.Replace(body, `"amount":100`, `"amount":101`, 1)
+	rec = post("/transfer", strings.Replace(other, "}}", `},"confirmationToken":"`+preview.ConfirmationToken+`"}`, 1))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = post("/transfer", strings.Replace(body, "}}", `},"confirmationToken":"`+preview.ConfirmationToken+`"}`, 1))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(800), balanceOf(t, store, from.ID))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferAddressesAccountsByPublicID(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	router := NewAPIServer(":0", store).Router()
+	sender := createTestAccount(t, store, 1000)
+	recipient := createTestAccount(t, store, 0)
+	token, err := createJWT(sender)
+	assert.Nil(t, err)
+
+	transfer := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/transfer", strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := transfer(`{"toAccountId":"` + recipient.PublicID + `","amount":{"amount":100}}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+	var body map[string]any
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, sender.PublicID, body["fromAccountId"])
+	assert.Equal(t, recipient.PublicID, body["toAccountId"])
+	for _, field := range []string{"id", "fromAccount", "toAccount"} {
+		assert.NotContains(t, body, field)
+	}
+
+	// The integer id still works, flagged as deprecated.
+	rec = transfer(`{"toAccount":` + strconv.Itoa(recipient.ID) + `,"amount":{"amount":150}}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Deprecation"))
+	assert.Equal(t, int64(250), balanceOf(t, store, recipient.ID))
+
+	rec = transfer(`{"toAccountId":"` + NewULID() + `","amount":{"amount":100}}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/account/"+sender.PublicID, nil)
+	req.Header.Set("x-jwt-token", token)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body = nil
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.NotContains(t, body, "id")
+	assert.Equal(t, sender.PublicID, body["publicId"])
+}
+
+func TestAsyncTransferSettlesInBackground(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	store := NewMemoryStorage()
+	server := NewAPIServer(":0", store)
+	router := server.Router()
+	sender := createTestAccount(t, store, 1000)
+	recipient := createTestAccount(t, store, 0)
+	token, err := createJWT(sender)
+	assert.Nil(t, err)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		if method == http.MethodPost {
+			req.Header.Set("Prefer", "respond-async")
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	// poll follows the Location header, letting the processor run between
+	// reads, until the transfer has left the pending state.
+	poll := func(location string) TransferResource {
+		var resource TransferResource
+		for i := 0; i < 10; i++ {
+			rec := do(http.MethodGet, location, "")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			resource = TransferResource{}
+			assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resource))
+			if !resource.IsPending() {
+				break
+			}
+			server.transfers.processAll()
+		}
+		return resource
+	}
+
+	rec := do(http.MethodPost, "/transfer", `{"toAccountId":"`+recipient.PublicID+`","amount":{"amount":300}}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	var accepted TransferResource
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &accepted))
+	assert.Equal(t, TransferAccepted, accepted.Status)
+	location := rec.Header().Get("Location")
+	assert.Equal(t, "/transfer/"+accepted.PublicID, location)
+	// Nothing moves until the processor picks the transfer up.
+	assert.Equal(t, int64(0), balanceOf(t, store, recipient.ID))
+
+	settled := poll(location)
+	assert.Equal(t, TransferSettled, settled.Status)
+	assert.Equal(t, int64(700), balanceOf(t, store, sender.ID))
+	assert.Equal(t, int64(300), balanceOf(t, store, recipient.ID))
+
+	rec = do(http.MethodPost, "/transfer", `{"toAccountId":"`+recipient.PublicID+`","amount":{"amount":5000}}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	failed := poll(rec.Header().Get("Location"))
+	assert.Equal(t, TransferFailed, failed.Status)
+	assert.NotEmpty(t, failed.FailureReason)
+	assert.Equal(t, int64(700), balanceOf(t, store, sender.ID))
+}
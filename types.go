@@ -14,29 +14,99 @@ type LoginResponse struct {
 }
 
 type LoginRequest struct {
-	Number   int32  `json:"number"`
-	Password string `json:"password"`
+	Number      int32  `json:"number"`
+	Password    string `json:"password"`
+	DeviceID    string `json:"deviceId,omitempty"`
+	DeviceToken string `json:"deviceToken,omitempty"`
 }
 
 type TransferRequest struct {
-	ToAccount int `json:"toAccount"`
-	Amount    int `json:"amount"`
+	// ToPublicID is the receiving account's public id. ToAccount, its
+	// integer id, is deprecated; whichever is given, ToAccount holds the
+	// integer id once the request is validated.
+	ToPublicID string `json:"toAccountId,omitempty"`
+	ToAccount  int    `json:"toAccount,omitempty"`
+	Amount     Money  `json:"amount"`
+	Reference  string `json:"reference,omitempty"`
+	// ConfirmationToken comes from POST /transfer/preview and confirms a
+	// transfer that looks like a duplicate of a recent one.
+	ConfirmationToken string `json:"confirmationToken,omitempty"`
+	// QuoteID references an FX quote from POST /fx/quotes, for transfers
+	// to an account in another currency.
+	QuoteID string `json:"quoteId,omitempty"`
+}
+
+// PageQuery selects rows created in [After, Before), continuing a keyset
+// pagination on (created_at, id) below (Before, BeforeID), newest first.
+type PageQuery struct {
+	After    time.Time
+	Before   time.Time
+	BeforeID int
+	Limit    int
 }
 
 type CreateAccountRequest struct {
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
 	Password  string `json:"password"`
+	Business  bool   `json:"business"`
+	Phone     string `json:"phone"`
+	// AcceptedTerms is the terms version the customer agreed to while
+	// signing up. It has to be the latest once any terms are published.
+	AcceptedTerms string `json:"acceptedTerms,omitempty"`
+	// ConfirmNotDuplicate goes ahead with a signup whose name matches an
+	// existing customer's.
+	ConfirmNotDuplicate bool `json:"confirmNotDuplicate,omitempty"`
 }
 
 type Account struct {
-	ID                int       `json:"id"`
-	FirstName         string    `json:"firstName"`
-	LastName          string    `json:"lastName"`
-	Number            int32     `json:"number"`
-	EncryptedPassword string    `json:"-"`
-	Balance           int64     `json:"balance"`
-	CreatedAt         time.Time `json:"createdAt"`
+	// ID is the internal surrogate key. Clients only ever see PublicID.
+	ID                int    `json:"-"`
+	PublicID          string `json:"publicId"`
+	FirstName         string `json:"firstName"`
+	LastName          string `json:"lastName"`
+	Number            int32  `json:"number"`
+	EncryptedPassword string `json:"-"`
+	Balance           Money  `json:"balance"`
+	Business          bool   `json:"business"`
+	// Phone is only shown in AccountDetails.
+	Phone string `json:"-"`
+	// TokenVersion is embedded in issued JWTs. Bumping it signs out every
+	// session of the account.
+	TokenVersion int `json:"-"`
+	// System is set on the accounts the bank itself owns.
+	System    SystemAccountKind `json:"system,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// AccountDetails is an account as its holder and the back office see it,
+// with the contact details other views of the account leave out.
+type AccountDetails struct {
+	*Account
+	Phone string `json:"phone,omitempty"`
+}
+
+func (a *Account) Details() AccountDetails {
+	return AccountDetails{Account: a, Phone: a.Phone}
+}
+
+// AdminAccount is an account as the back office sees it, with the
+// internal id the admin routes take.
+type AdminAccount struct {
+	AccountDetails
+	ID int `json:"id"`
+}
+
+func (a *Account) Admin() AdminAccount {
+	return AdminAccount{AccountDetails: a.Details(), ID: a.ID}
+}
+
+func adminAccounts(accounts []*Account) []AdminAccount {
+	admin := make([]AdminAccount, len(accounts))
+	for i, a := range accounts {
+		admin[i] = a.Admin()
+	}
+	return admin
 }
 
 func (a *Account) ValidPassword(pw string) bool {
@@ -50,10 +120,12 @@ func NewAccount(firstName, lastName string, password string) (*Account, error) {
 	}
 
 	return &Account{
+		PublicID:          NewULID(),
 		FirstName:         firstName,
 		LastName:          lastName,
 		Number:            rand.Int31n(math.MaxInt32),
 		EncryptedPassword: string(encpw),
+		Balance:           NewMoney(0, defaultCurrency),
 		CreatedAt:         time.Now().UTC(),
 	}, nil
 }
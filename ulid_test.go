@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestULID(t *testing.T) {
+	id := NewULID()
+	assert.Len(t, id, 26)
+	assert.True(t, isULID(id))
+	assert.False(t, isULID("42"))
+	assert.False(t, isULID("8ZZZZZZZZZZZZZZZZZZZZZZZZZ"))
+
+	earlier := newULIDAt(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	later := newULIDAt(time.Date(2024, 1, 1, 0, 0, 0, 1e6, time.UTC))
+	assert.Less(t, earlier, later)
+	assert.Equal(t, "01HK153X00", earlier[:10])
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type UsageMetric string
+
+const (
+	UsageRequests  UsageMetric = "requests"
+	UsageTransfers UsageMetric = "transfers"
+	// UsageErrors counts responses with a 4xx or 5xx status other than
+	// 429, which UsageRateLimited counts. Both are only kept per endpoint.
+	UsageErrors      UsageMetric = "errors"
+	UsageRateLimited UsageMetric = "rate_limited"
+)
+
+const usageDayLayout = "2006-01-02"
+
+// UsageRecord counts what one API consumer used on one UTC day. Consumers
+// are named after their credentials, e.g. "account:<publicId>" or
+// "terminal:<id>". Records with an Endpoint, such as
+// "GET /account/{id}", break the day down by route; those without are the
+// totals quotas apply to.
+type UsageRecord struct {
+	Consumer string      `json:"consumer"`
+	Metric   UsageMetric `json:"metric"`
+	Day      string      `json:"day"`
+	Endpoint string      `json:"endpoint,omitempty"`
+	Count    int64       `json:"count"`
+}
+
+// UsageFilter selects usage by consumer and day. ByEndpoint selects the
+// per-endpoint records instead of the totals.
+type UsageFilter struct {
+	Consumer   string
+	From       string
+	To         string
+	ByEndpoint bool
+}
+
+// UsageQuota is a daily allowance. Going over Soft only adds a warning
+// header; requests over Hard are rejected with 429. Zero means unlimited.
+type UsageQuota struct {
+	Soft int64 `json:"soft,omitempty"`
+	Hard int64 `json:"hard,omitempty"`
+}
+
+type usageKey struct {
+	consumer string
+	metric   UsageMetric
+	day      string
+	endpoint string
+}
+
+// UsageMeter counts requests and transfers per consumer. Counts are kept in
+// memory for quota checks and written to storage in batches by Run.
+type UsageMeter struct {
+	Quotas        map[UsageMetric]UsageQuota
+	FlushInterval time.Duration
+
+	storage Storage
+	mu      sync.Mutex
+	counts  map[usageKey]int64
+	pending map[usageKey]int64
+}
+
+func NewUsageMeter(store Storage) *UsageMeter {
+	return &UsageMeter{
+		Quotas:        map[UsageMetric]UsageQuota{},
+		FlushInterval: time.Minute,
+		storage:       store,
+		counts:        map[usageKey]int64{},
+		pending:       map[usageKey]int64{},
+	}
+}
+
+func usageMeterFromEnv(store Storage) *UsageMeter {
+	m := NewUsageMeter(store)
+	m.FlushInterval = getEnvDuration("USAGE_FLUSH_INTERVAL", time.Minute)
+	m.Quotas[UsageRequests] = UsageQuota{
+		Soft: getEnvInt("USAGE_SOFT_QUOTA_REQUESTS", 0),
+		Hard: getEnvInt("USAGE_HARD_QUOTA_REQUESTS", 0),
+	}
+	m.Quotas[UsageTransfers] = UsageQuota{
+		Soft: getEnvInt("USAGE_SOFT_QUOTA_TRANSFERS", 0),
+		Hard: getEnvInt("USAGE_HARD_QUOTA_TRANSFERS", 0),
+	}
+	return m
+}
+
+func (m *UsageMeter) Run() {
+	ticker := time.NewTicker(m.FlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := m.Flush(); err != nil {
+			log.Println("Failed to store API usage: ", err)
+		}
+	}
+}
+
+// Flush writes the counts recorded since the last flush and forgets days
+// that are over.
+func (m *UsageMeter) Flush() error {
+	m.mu.Lock()
+	records := make([]UsageRecord, 0, len(m.pending))
+	for k, n := range m.pending {
+		records = append(records, UsageRecord{Consumer: k.consumer, Metric: k.metric, Day: k.day, Endpoint: k.endpoint, Count: n})
+	}
+	m.pending = map[usageKey]int64{}
+
+	today := time.Now().UTC().Format(usageDayLayout)
+	for k := range m.counts {
+		if k.day != today {
+			delete(m.counts, k)
+		}
+	}
+	m.mu.Unlock()
+
+	if len(records) == 0 {
+		return nil
+	}
+	if err := m.storage.AddUsage(records); err != nil {
+		// Keep the counts for the next attempt rather than losing them.
+		m.mu.Lock()
+		for _, rec := range records {
+			m.pending[usageKey{rec.Consumer, rec.Metric, rec.Day, rec.Endpoint}] += rec.Count
+		}
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Record counts one unit of metric for consumer, unless that would take it
+// over its hard quota.
+func (m *UsageMeter) Record(w http.ResponseWriter, consumer string, metric UsageMetric) error {
+	now := time.Now().UTC()
+	key := usageKey{consumer: consumer, metric: metric, day: now.Format(usageDayLayout)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count, ok := m.counts[key]
+	if !ok {
+		// Other instances or an earlier run may have used some of today's
+		// quota already.
+		stored, err := m.storage.GetUsage(UsageFilter{Consumer: consumer, From: key.day, To: key.day})
+		if err != nil {
+			return err
+		}
+		for _, rec := range stored {
+			if rec.Metric == metric {
+				count += rec.Count
+			}
+		}
+		count += m.pending[key]
+	}
+
+	quota := m.Quotas[metric]
+	if quota.Hard > 0 && count >= quota.Hard {
+		m.counts[key] = count
+		midnight := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		w.Header().Set("Retry-After", strconv.Itoa(int(midnight.Sub(now).Seconds())+1))
+		return ApiError{Err: "daily " + string(metric) + " quota exceeded", Status: http.StatusTooManyRequests}
+	}
+
+	count++
+	m.counts[key] = count
+	m.pending[key]++
+	if quota.Soft > 0 && count > quota.Soft {
+		w.Header().Add("X-Usage-Warning", "daily "+string(metric)+" soft quota of "+strconv.FormatInt(quota.Soft, 10)+" exceeded")
+	}
+	return nil
+}
+
+// observe counts a finished request against its endpoint, and as an error
+// or a rate-limit hit if its status says so.
+func (m *UsageMeter) observe(consumer, endpoint string, status int) {
+	day := time.Now().UTC().Format(usageDayLayout)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[usageKey{consumer, UsageRequests, day, endpoint}]++
+	switch {
+	case status == http.StatusTooManyRequests:
+		m.pending[usageKey{consumer, UsageRateLimited, day, endpoint}]++
+	case status >= 400:
+		m.pending[usageKey{consumer, UsageErrors, day, endpoint}]++
+	}
+}
+
+type contextUsageMeterKey struct{}
+
+// meteredRequest tells the middleware who the auth wrapper found the
+// consumer to be.
+type meteredRequest struct {
+	meter    *UsageMeter
+	consumer string
+}
+
+// usageWriter remembers the status of the response.
+type usageWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *usageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *usageWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *usageWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streamed responses streaming.
+func (w *usageWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Middleware makes the meter available to the auth wrappers, which are the
+// first to know who the consumer is. Once the response is written, it
+// counts authenticated requests against their endpoint.
+func (m *UsageMeter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metered := &meteredRequest{meter: m}
+		ctx := context.WithValue(r.Context(), contextUsageMeterKey{}, metered)
+		uw := &usageWriter{ResponseWriter: w}
+		next.ServeHTTP(uw, r.WithContext(ctx))
+
+		if metered.consumer == "" {
+			return
+		}
+		endpoint := r.Method + " " + routeTemplate(r)
+		if uw.status == 0 {
+			uw.status = http.StatusOK
+		}
+		m.observe(metered.consumer, endpoint, uw.status)
+	})
+}
+
+// identifyConsumer lets the middleware count the request against p's
+// endpoint usage even if it is denied before it is metered.
+func identifyConsumer(r *http.Request, p *Principal) {
+	if metered, ok := r.Context().Value(contextUsageMeterKey{}).(*meteredRequest); ok {
+		metered.consumer = p.consumer()
+	}
+}
+
+// meterRequest counts an authenticated request against its principal.
+// Requests that didn't go through the middleware, e.g. in tests, aren't
+// metered.
+func meterRequest(w http.ResponseWriter, r *http.Request) error {
+	metered, ok := r.Context().Value(contextUsageMeterKey{}).(*meteredRequest)
+	if !ok {
+		return nil
+	}
+	metered.consumer = principalFromContext(r).consumer()
+	return metered.meter.Record(w, metered.consumer, UsageRequests)
+}
+
+type UsageReport struct {
+	From    string                     `json:"from"`
+	To      string                     `json:"to"`
+	Quotas  map[UsageMetric]UsageQuota `json:"quotas"`
+	Records []UsageRecord              `json:"records"`
+}
+
+// HandleAdminGetUsage reports daily usage per consumer, for the last 30
+// days unless ?from=&to= (YYYY-MM-DD) say otherwise. ?consumer= narrows it
+// down to one consumer.
+func (s *APIServer) HandleAdminGetUsage(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	q := r.URL.Query()
+	now := time.Now().UTC()
+	report := UsageReport{
+		From:   now.AddDate(0, 0, -30).Format(usageDayLayout),
+		To:     now.Format(usageDayLayout),
+		Quotas: s.usage.Quotas,
+	}
+	for param, day := range map[string]*string{"from": &report.From, "to": &report.To} {
+		if v := q.Get(param); v != "" {
+			if _, err := time.Parse(usageDayLayout, v); err != nil {
+				return ApiError{Err: "invalid " + param + ": " + v, Status: http.StatusBadRequest}
+			}
+			*day = v
+		}
+	}
+
+	if err := s.usage.Flush(); err != nil {
+		return err
+	}
+	records, err := s.storage.GetUsage(UsageFilter{Consumer: q.Get("consumer"), From: report.From, To: report.To})
+	if err != nil {
+		return err
+	}
+	report.Records = records
+
+	return writeJSON(w, http.StatusOK, report)
+}
+
+// EndpointUsage is what a consumer did with one endpoint. ErrorRate is the
+// share of its requests answered with an error other than 429.
+type EndpointUsage struct {
+	Endpoint    string  `json:"endpoint"`
+	Requests    int64   `json:"requests"`
+	Errors      int64   `json:"errors"`
+	RateLimited int64   `json:"rateLimited"`
+	ErrorRate   float64 `json:"errorRate"`
+}
+
+// APIUsageInsights summarizes how an account's credentials used the API,
+// to help integrators debug their clients. Endpoints are busiest first.
+type APIUsageInsights struct {
+	From      string                     `json:"from"`
+	To        string                     `json:"to"`
+	Total     EndpointUsage              `json:"total"`
+	Endpoints []EndpointUsage            `json:"endpoints"`
+	Quotas    map[UsageMetric]UsageQuota `json:"quotas"`
+}
+
+func (u *EndpointUsage) add(metric UsageMetric, n int64) {
+	switch metric {
+	case UsageRequests:
+		u.Requests += n
+	case UsageErrors:
+		u.Errors += n
+	case UsageRateLimited:
+		u.RateLimited += n
+	}
+	if u.Requests > 0 {
+		u.ErrorRate = float64(u.Errors) / float64(u.Requests)
+	}
+}
+
+// HandleGetAPIUsage reports the requests made with the account's own
+// tokens over the last 30 days, by endpoint.
+func (s *APIServer) HandleGetAPIUsage(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	account, err := s.storage.GetAccountByID(id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	insights := APIUsageInsights{
+		From:      now.AddDate(0, 0, -30).Format(usageDayLayout),
+		To:        now.Format(usageDayLayout),
+		Total:     EndpointUsage{Endpoint: "*"},
+		Endpoints: []EndpointUsage{},
+		Quotas:    s.usage.Quotas,
+	}
+
+	if err := s.usage.Flush(); err != nil {
+		return err
+	}
+	consumer := customerPrincipal(account, "").consumer()
+	records, err := s.storage.GetUsage(UsageFilter{Consumer: consumer, From: insights.From, To: insights.To, ByEndpoint: true})
+	if err != nil {
+		return err
+	}
+
+	byEndpoint := map[string]*EndpointUsage{}
+	for _, rec := range records {
+		u, ok := byEndpoint[rec.Endpoint]
+		if !ok {
+			u = &EndpointUsage{Endpoint: rec.Endpoint}
+			byEndpoint[rec.Endpoint] = u
+		}
+		u.add(rec.Metric, rec.Count)
+		insights.Total.add(rec.Metric, rec.Count)
+	}
+	for _, u := range byEndpoint {
+		insights.Endpoints = append(insights.Endpoints, *u)
+	}
+	sort.Slice(insights.Endpoints, func(i, j int) bool {
+		a, b := insights.Endpoints[i], insights.Endpoints[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Endpoint < b.Endpoint
+	})
+
+	return writeJSON(w, http.StatusOK, insights)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageMeterQuotas(t *testing.T) {
+	store := NewMemoryStorage()
+	meter := NewUsageMeter(store)
+	meter.Quotas[UsageTransfers] = UsageQuota{Soft: 1, Hard: 2}
+
+	w := httptest.NewRecorder()
+	assert.Nil(t, meter.Record(w, "account:a", UsageTransfers))
+	assert.Empty(t, w.Header().Get("X-Usage-Warning"))
+
+	assert.Nil(t, meter.Record(w, "account:a", UsageTransfers))
+	assert.NotEmpty(t, w.Header().Get("X-Usage-Warning"))
+
+	err := meter.Record(w, "account:a", UsageTransfers)
+	assert.Equal(t, http.StatusTooManyRequests, err.(ApiError).Status)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// Other consumers have their own allowance.
+	assert.Nil(t, meter.Record(httptest.NewRecorder(), "account:b", UsageTransfers))
+
+	assert.Nil(t, meter.Flush())
+	today := time.Now().UTC().Format(usageDayLayout)
+	records, err := store.GetUsage(UsageFilter{Consumer: "account:a", From: today, To: today})
+	assert.Nil(t, err)
+	assert.Equal(t, []UsageRecord{{Consumer: "account:a", Metric: UsageTransfers, Day: today, Count: 2}}, records)
+}
+
+func TestUsageMeterPicksUpStoredCounts(t *testing.T) {
+	store := NewMemoryStorage()
+	today := time.Now().UTC().Format(usageDayLayout)
+	assert.Nil(t, store.AddUsage([]UsageRecord{{Consumer: "terminal:atm-1", Metric: UsageRequests, Day: today, Count: 5}}))
+
+	meter := NewUsageMeter(store)
+	meter.Quotas[UsageRequests] = UsageQuota{Hard: 5}
+
+	err := meter.Record(httptest.NewRecorder(), "terminal:atm-1", UsageRequests)
+	assert.Equal(t, http.StatusTooManyRequests, err.(ApiError).Status)
+}
+
+func TestAPIUsageInsights(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("USAGE_HARD_QUOTA_REQUESTS", "3")
+
+	store := NewMemoryStorage()
+	server := NewAPIServer(":0", store)
+	router := server.Router()
+	account := createTestAccount(t, store, 100)
+	token, err := createJWT(account)
+	assert.Nil(t, err)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusOK, get("/account/"+account.PublicID).Code)
+	assert.Equal(t, http.StatusOK, get("/account/"+account.PublicID+"/balance").Code)
+	assert.Equal(t, http.StatusNotFound, get("/transfer/01ARZ3NDEKTSV4RRFFQ69G5FAV").Code)
+
+	// Requests denied by their policy don't count towards the quota, so
+	// this is the last one within it.
+	rec := get("/account/" + account.PublicID + "/api-usage")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	insights := new(APIUsageInsights)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(insights))
+	assert.Equal(t, int64(3), insights.Total.Requests)
+	assert.Equal(t, int64(1), insights.Total.Errors)
+	assert.InDelta(t, 1.0/3, insights.Total.ErrorRate, 0.001)
+	assert.Len(t, insights.Endpoints, 3)
+
+	assert.Equal(t, http.StatusTooManyRequests, get("/account/"+account.PublicID).Code)
+
+	server.usage.Quotas[UsageRequests] = UsageQuota{}
+	rec = get("/account/" + account.PublicID + "/api-usage")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(insights))
+	assert.Equal(t, int64(5), insights.Total.Requests)
+	assert.Equal(t, int64(1), insights.Total.RateLimited)
+	assert.Equal(t, EndpointUsage{Endpoint: "GET /account/{id}", Requests: 2, RateLimited: 1}, insights.Endpoints[0])
+}
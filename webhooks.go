@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const NotifyWebhookDisabled NotificationKind = "webhook.disabled"
+
+// webhookHealthWindow is how many of an endpoint's latest deliveries its
+// health is scored on.
+const webhookHealthWindow = 100
+
+var webhookStats = expvar.NewMap("webhooks")
+
+type WebhookStatus string
+
+const (
+	WebhookActive   WebhookStatus = "active"
+	WebhookDisabled WebhookStatus = "disabled"
+)
+
+// WebhookEndpoint is a URL an integrator receives the notifications of an
+// account at, as listed in the event catalog. Each delivery is a POST of
+// the Notification, signed with Secret: the X-Webhook-Signature header is
+// "sha256=" and the hex HMAC-SHA256 of the body. Secret is only shown
+// when the endpoint is created.
+type WebhookEndpoint struct {
+	ID        int    `json:"id"`
+	AccountID int    `json:"-"`
+	URL       string `json:"url"`
+	// EventTypes are the kinds delivered, or every kind when empty.
+	EventTypes     []string       `json:"eventTypes"`
+	Secret         string         `json:"secret,omitempty"`
+	Status         WebhookStatus  `json:"status"`
+	DisabledReason string         `json:"disabledReason,omitempty"`
+	DisabledAt     *time.Time     `json:"disabledAt,omitempty"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	Health         *WebhookHealth `json:"health,omitempty"`
+}
+
+type WebhookEndpointRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"eventTypes"`
+}
+
+// WebhookHealth is how an endpoint did on its latest deliveries, so
+// integrators can tell a slow or broken receiver from missing events. A
+// delivery meets the SLA when the endpoint answers 2xx within
+// WEBHOOK_DELIVERY_SLA; Score is the percentage that did. Health is kept
+// by the server delivering, and starts over when it restarts.
+type WebhookHealth struct {
+	Deliveries          int        `json:"deliveries"`
+	Failures            int        `json:"failures"`
+	FailureRate         float64    `json:"failureRate"`
+	LatencyP50Ms        float64    `json:"latencyP50Ms"`
+	LatencyP95Ms        float64    `json:"latencyP95Ms"`
+	Score               int        `json:"score"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	FailingSince        *time.Time `json:"failingSince,omitempty"`
+	LastSuccessAt       *time.Time `json:"lastSuccessAt,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+}
+
+type webhookDelivery struct {
+	latency time.Duration
+	ok      bool
+}
+
+// webhookTracker keeps the latest deliveries of one endpoint.
+type webhookTracker struct {
+	deliveries   []webhookDelivery
+	next         int
+	consecutive  int
+	failingSince time.Time
+	lastSuccess  time.Time
+	lastError    string
+	// disabled is set once the endpoint was disabled for failing.
+	disabled bool
+}
+
+func (t *webhookTracker) record(d webhookDelivery, errMsg string, now time.Time) {
+	if len(t.deliveries) < webhookHealthWindow {
+		t.deliveries = append(t.deliveries, d)
+	} else {
+		t.deliveries[t.next] = d
+		t.next = (t.next + 1) % webhookHealthWindow
+	}
+	if d.ok {
+		t.consecutive, t.failingSince, t.lastSuccess, t.lastError = 0, time.Time{}, now, ""
+		return
+	}
+	if t.consecutive == 0 {
+		t.failingSince = now
+	}
+	t.consecutive++
+	t.lastError = errMsg
+}
+
+func (t *webhookTracker) health(sla time.Duration) *WebhookHealth {
+	h := &WebhookHealth{Deliveries: len(t.deliveries), ConsecutiveFailures: t.consecutive, LastError: t.lastError, Score: 100}
+	if !t.failingSince.IsZero() {
+		since := t.failingSince
+		h.FailingSince = &since
+	}
+	if !t.lastSuccess.IsZero() {
+		last := t.lastSuccess
+		h.LastSuccessAt = &last
+	}
+	if len(t.deliveries) == 0 {
+		return h
+	}
+
+	latencies := make([]time.Duration, len(t.deliveries))
+	met := 0
+	for i, d := range t.deliveries {
+		latencies[i] = d.latency
+		if !d.ok {
+			h.Failures++
+		} else if d.latency <= sla {
+			met++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	h.FailureRate = float64(h.Failures) / float64(len(t.deliveries))
+	h.LatencyP50Ms = ms(latencies[len(latencies)*50/100])
+	h.LatencyP95Ms = ms(latencies[len(latencies)*95/100])
+	h.Score = met * 100 / len(t.deliveries)
+	return h
+}
+
+// WebhookDispatcher passes notifications on to next and delivers them to
+// the webhook endpoints of their account from its own queue, so a slow
+// receiver never holds up SMS and email. An endpoint failing every
+// delivery for DisableAfter, at least DisableAfterFailures times, is
+// disabled and its owner told; they can enable it again once it's fixed.
+type WebhookDispatcher struct {
+	SLA                  time.Duration
+	DisableAfter         time.Duration
+	DisableAfterFailures int
+	Workers              int
+
+	next    Notifier
+	storage Storage
+	client  *http.Client
+	queue   chan Notification
+
+	mu       sync.Mutex
+	trackers map[int]*webhookTracker
+}
+
+// webhookDispatcherFromEnv reads WEBHOOK_DELIVERY_SLA, WEBHOOK_TIMEOUT,
+// WEBHOOK_DISABLE_AFTER, WEBHOOK_DISABLE_AFTER_FAILURES, WEBHOOK_WORKERS
+// and WEBHOOK_QUEUE_SIZE.
+func webhookDispatcherFromEnv(next Notifier, store Storage) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		SLA:                  getEnvDuration("WEBHOOK_DELIVERY_SLA", 2*time.Second),
+		DisableAfter:         getEnvDuration("WEBHOOK_DISABLE_AFTER", 24*time.Hour),
+		DisableAfterFailures: int(getEnvInt("WEBHOOK_DISABLE_AFTER_FAILURES", 20)),
+		Workers:              int(getEnvInt("WEBHOOK_WORKERS", 4)),
+		next:                 next,
+		storage:              store,
+		client:               &http.Client{Timeout: getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)},
+		queue:                make(chan Notification, getEnvInt("WEBHOOK_QUEUE_SIZE", 1000)),
+		trackers:             map[int]*webhookTracker{},
+	}
+}
+
+func (d *WebhookDispatcher) Start() {
+	for i := 0; i < d.Workers; i++ {
+		go func() {
+			for n := range d.queue {
+				d.dispatch(n, time.Now().UTC())
+			}
+		}()
+	}
+}
+
+// Notify delivers n through next and queues it for the account's
+// webhooks. A full queue drops the webhook delivery only.
+func (d *WebhookDispatcher) Notify(n Notification) error {
+	select {
+	case d.queue <- n:
+	default:
+		webhookStats.Add("dropped", 1)
+	}
+	return d.next.Notify(n)
+}
+
+// dispatch delivers n to every active endpoint of its account that
+// subscribed to its kind.
+func (d *WebhookDispatcher) dispatch(n Notification, now time.Time) {
+	endpoints, err := d.storage.GetWebhookEndpoints(n.AccountID)
+	if err != nil {
+		log.Printf("Failed to look up webhooks of account %d: %v\n", n.AccountID, err)
+		return
+	}
+	for _, e := range endpoints {
+		if e.Status == WebhookActive && subscribesTo(e, n.Kind) {
+			d.deliver(e, n, now)
+		}
+	}
+}
+
+func subscribesTo(e *WebhookEndpoint, kind NotificationKind) bool {
+	if len(e.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range e.EventTypes {
+		if t == string(kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *WebhookDispatcher) deliver(e *WebhookEndpoint, n Notification, now time.Time) {
+	body, err := json.Marshal(n)
+	if err != nil {
+		log.Printf("Failed to encode %s for webhook %d: %v\n", n.Kind, e.ID, err)
+		return
+	}
+
+	start := time.Now()
+	err = d.post(e, n.Kind, body)
+	latency := time.Since(start)
+
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+		webhookStats.Add("failed", 1)
+	} else {
+		webhookStats.Add("delivered", 1)
+	}
+
+	d.mu.Lock()
+	tracker, ok := d.trackers[e.ID]
+	if !ok {
+		tracker = &webhookTracker{}
+		d.trackers[e.ID] = tracker
+	}
+	tracker.record(webhookDelivery{latency: latency, ok: err == nil}, errMsg, now)
+	chronic := !tracker.disabled && tracker.consecutive >= d.DisableAfterFailures && now.Sub(tracker.failingSince) >= d.DisableAfter
+	tracker.disabled = tracker.disabled || chronic
+	failures, since := tracker.consecutive, tracker.failingSince
+	d.mu.Unlock()
+
+	if chronic {
+		d.disable(e, failures, since, now)
+	}
+}
+
+func (d *WebhookDispatcher) post(e *WebhookEndpoint, kind NotificationKind, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", string(kind))
+	req.Header.Set("X-Webhook-Signature", signWebhook(e.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (d *WebhookDispatcher) disable(e *WebhookEndpoint, failures int, since, now time.Time) {
+	e.Status = WebhookDisabled
+	e.DisabledReason = fmt.Sprintf("%d deliveries in a row failed since %s", failures, since.Format(time.RFC3339))
+	e.DisabledAt = &now
+	if err := d.storage.UpdateWebhookEndpoint(e); err != nil {
+		log.Printf("Failed to disable webhook %d: %v\n", e.ID, err)
+		return
+	}
+	webhookStats.Add("disabled", 1)
+	log.Printf("Disabled webhook %d of account %d: %s\n", e.ID, e.AccountID, e.DisabledReason)
+
+	message := fmt.Sprintf("We stopped sending events to %s: %s. Fix the endpoint and enable it again.", e.URL, e.DisabledReason)
+	if err := d.Notify(NewNotification(e.AccountID, NotifyWebhookDisabled, message)); err != nil {
+		log.Printf("Failed to tell account %d about disabled webhook %d: %v\n", e.AccountID, e.ID, err)
+	}
+}
+
+// Health returns how the endpoint did on its latest deliveries.
+func (d *WebhookDispatcher) Health(id int) *WebhookHealth {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tracker, ok := d.trackers[id]
+	if !ok {
+		tracker = &webhookTracker{}
+	}
+	return tracker.health(d.SLA)
+}
+
+// reset forgets the deliveries of an endpoint enabled again, so the old
+// failures don't disable it on the next one.
+func (d *WebhookDispatcher) reset(id int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.trackers, id)
+}
+
+func validateWebhookRequest(req *WebhookEndpointRequest) error {
+	u, err := url.Parse(req.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return ApiError{Err: "url must be an absolute http(s) URL", Status: http.StatusBadRequest}
+	}
+	if u.Scheme != "https" && isProduction() {
+		return ApiError{Err: "url must use https", Status: http.StatusBadRequest}
+	}
+
+	known := map[string]bool{}
+	for _, t := range eventTypes {
+		known[string(t.Name)] = true
+	}
+	for _, t := range req.EventTypes {
+		if !known[t] {
+			return ApiError{Err: "unknown event type: " + t, Status: http.StatusBadRequest}
+		}
+	}
+	return nil
+}
+
+func (s *APIServer) HandleWebhooks(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		endpoints, err := s.storage.GetWebhookEndpoints(id)
+		if err != nil {
+			return err
+		}
+		for _, e := range endpoints {
+			e.Secret, e.Health = "", s.webhooks.Health(e.ID)
+		}
+		return writeJSON(w, http.StatusOK, endpoints)
+	}
+
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	req := new(WebhookEndpointRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return invalidRequest
+	}
+	defer r.Body.Close()
+
+	if err := validateWebhookRequest(req); err != nil {
+		return err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	e := &WebhookEndpoint{
+		AccountID:  id,
+		URL:        req.URL,
+		EventTypes: append([]string{}, req.EventTypes...),
+		Secret:     secret,
+		Status:     WebhookActive,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := s.storage.CreateWebhookEndpoint(e); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, e)
+}
+
+func (s *APIServer) HandleWebhook(w http.ResponseWriter, r *http.Request) error {
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	webhookID, err := getIntVar(r, "webhookID")
+	if err != nil {
+		return err
+	}
+
+	e, err := s.storage.GetWebhookEndpoint(id, webhookID)
+	if err != nil {
+		return err
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		e.Secret, e.Health = "", s.webhooks.Health(e.ID)
+		return writeJSON(w, http.StatusOK, e)
+	case http.MethodDelete:
+		if err := s.storage.DeleteWebhookEndpoint(id, webhookID); err != nil {
+			return err
+		}
+		s.webhooks.reset(webhookID)
+		return writeJSON(w, http.StatusOK, map[string]int{"deleted": webhookID})
+	}
+
+	return methodNotAllowed
+}
+
+// HandleEnableWebhook turns a disabled endpoint back on, with a clean
+// health record.
+func (s *APIServer) HandleEnableWebhook(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return methodNotAllowed
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		return err
+	}
+	webhookID, err := getIntVar(r, "webhookID")
+	if err != nil {
+		return err
+	}
+
+	e, err := s.storage.GetWebhookEndpoint(id, webhookID)
+	if err != nil {
+		return err
+	}
+	if e.Status != WebhookActive {
+		e.Status, e.DisabledReason, e.DisabledAt = WebhookActive, "", nil
+		if err := s.storage.UpdateWebhookEndpoint(e); err != nil {
+			return err
+		}
+		s.webhooks.reset(webhookID)
+	}
+
+	e.Secret, e.Health = "", s.webhooks.Health(e.ID)
+	return writeJSON(w, http.StatusOK, e)
+}
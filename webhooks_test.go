@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookHealthAndAutoDisable(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("WEBHOOK_DISABLE_AFTER", "1h")
+	t.Setenv("WEBHOOK_DISABLE_AFTER_FAILURES", "3")
+
+	failing := false
+	var signatures []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signatures = append(signatures, r.Header.Get("X-Webhook-Signature"))
+		assert.Equal(t, "transfer.received", r.Header.Get("X-Webhook-Event"))
+		assert.Contains(t, string(body), `"kind":"transfer.received"`)
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer receiver.Close()
+
+	store := NewMemoryStorage()
+	server := NewAPIServer(":0", store)
+	notifier := &recordingNotifier{}
+	server.webhooks = webhookDispatcherFromEnv(notifier, store)
+	router := server.Router()
+	acc := createTestAccount(t, store, 0)
+	token, err := createJWT(acc)
+	assert.Nil(t, err)
+
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/account/"+strconv.Itoa(acc.ID)+path, strings.NewReader(body))
+		req.Header.Set("x-jwt-token", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/webhooks", `{"url":"ftp://example.com"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/webhooks", `{"url":"`+receiver.URL+`","eventTypes":["nope"]}`).Code)
+	rec := call(http.MethodPost, "/webhooks", `{"url":"`+receiver.URL+`","eventTypes":["transfer.received"]}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var endpoint WebhookEndpoint
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &endpoint))
+	assert.NotEmpty(t, endpoint.Secret)
+	path := "/webhooks/" + strconv.Itoa(endpoint.ID)
+	get := func(path string) WebhookEndpoint {
+		var e WebhookEndpoint
+		assert.Nil(t, json.Unmarshal(call(http.MethodGet, path, "").Body.Bytes(), &e))
+		return e
+	}
+
+	now := time.Now().UTC()
+	received := NewNotification(acc.ID, NotifyTransferReceived, "You received 10.00 USD")
+	server.webhooks.dispatch(received, now)
+	server.webhooks.dispatch(NewNotification(acc.ID, NotifyCashDeposit, "not subscribed"), now)
+	body, _ := json.Marshal(received)
+	assert.Equal(t, []string{signWebhook(endpoint.Secret, body)}, signatures)
+
+	// Failing for less than an hour doesn't disable the endpoint.
+	failing = true
+	for _, after := range []time.Duration{time.Minute, 30 * time.Minute, 50 * time.Minute} {
+		server.webhooks.dispatch(received, now.Add(after))
+	}
+	endpoint = get(path)
+	assert.Equal(t, WebhookActive, endpoint.Status)
+	assert.Empty(t, endpoint.Secret)
+	if assert.NotNil(t, endpoint.Health) {
+		assert.Equal(t, 4, endpoint.Health.Deliveries)
+		assert.Equal(t, 3, endpoint.Health.ConsecutiveFailures)
+		assert.Equal(t, 0.75, endpoint.Health.FailureRate)
+		assert.Equal(t, 25, endpoint.Health.Score)
+		assert.Contains(t, endpoint.Health.LastError, "502")
+	}
+
+	server.webhooks.dispatch(received, now.Add(2*time.Hour))
+	endpoint = get(path)
+	assert.Equal(t, WebhookDisabled, endpoint.Status)
+	assert.NotEmpty(t, endpoint.DisabledReason)
+	kinds := []NotificationKind{}
+	for _, n := range notifier.sent {
+		kinds = append(kinds, n.Kind)
+	}
+	assert.Contains(t, kinds, NotifyWebhookDisabled)
+
+	// Disabled endpoints get nothing until enabled again, with a clean
+	// record.
+	server.webhooks.dispatch(received, now.Add(3*time.Hour))
+	assert.Len(t, signatures, 5)
+	rec = call(http.MethodPost, path+"/enable", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	endpoint = WebhookEndpoint{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &endpoint))
+	assert.Equal(t, WebhookActive, endpoint.Status)
+	assert.Equal(t, 0, endpoint.Health.Deliveries)
+
+	assert.Equal(t, http.StatusOK, call(http.MethodDelete, path, "").Code)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, path, "").Code)
+}